@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// scheduleAgentLaunch arms the launch of agent.command for when Crush has
+// not attached within agent.launch_after_ms of Neovim attaching.
+func (d *Daemon) scheduleAgentLaunch() {
+	if len(d.agent.Command) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.agentTimer != nil || len(d.agents) > 0 {
+		return
+	}
+	d.agentTimer = d.clock.AfterFunc(d.agent.LaunchDelay(), d.launchAgent)
+}
+
+// launchAgent starts agent.command in the workspace root, unless Crush has
+// attached, Neovim has left, or an agent it launched is still running.
+func (d *Daemon) launchAgent() {
+	d.mu.Lock()
+	d.agentTimer = nil
+	skip := len(d.agents) > 0 || d.clients["neovim"] == nil || d.agentRunning
+	if !skip {
+		d.agentRunning = true
+	}
+	d.mu.Unlock()
+	if skip {
+		return
+	}
+
+	name := strings.Join(d.agent.Command, " ")
+	cmd := exec.Command(d.agent.Command[0], d.agent.Command[1:]...)
+	cmd.Dir = d.workspace
+	cmd.Stdout = d.logger.Writer()
+	cmd.Stderr = d.logger.Writer()
+	if err := cmd.Start(); err != nil {
+		d.mu.Lock()
+		d.agentRunning = false
+		d.mu.Unlock()
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeAgentLaunch,
+			Message: fmt.Sprintf("failed to launch agent %q: %v", name, err),
+		})
+		return
+	}
+	d.logger.Printf("Crush did not attach, launched agent %q (pid %d)", name, cmd.Process.Pid)
+
+	go func() {
+		err := cmd.Wait()
+		d.mu.Lock()
+		d.agentRunning = false
+		d.mu.Unlock()
+		d.logger.Printf("Agent %q exited: %v", name, err)
+	}()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// agentClientPrefix starts the client IDs of Crush agents connected while
+// another one holds "crush" ("crush-2", ...).
+const agentClientPrefix = "crush-"
+
+// agentIDLocked returns the client ID of a new Crush connection: "crush"
+// if no agent holds it, else the next "crush-N". d.mu must be held.
+func (d *Daemon) agentIDLocked() string {
+	d.agentClients++
+	if _, taken := d.clientInfo["crush"]; !taken {
+		return "crush"
+	}
+	return fmt.Sprintf("%s%d", agentClientPrefix, d.agentClients)
+}
+
+// agentForLocked returns the agent Neovim's requests are routed to under
+// agent.routing, or "" if none is connected. d.mu must be held.
+func (d *Daemon) agentForLocked() string {
+	if len(d.agents) == 0 {
+		return ""
+	}
+	if d.agent.Routing == config.AgentRoutingActive && slices.Contains(d.agents, d.activeAgent) {
+		return d.activeAgent
+	}
+	return d.agents[0]
+}
+
+func (d *Daemon) agentFor() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.agentForLocked()
+}
+
+// touchAgent records that agent sent a message, for agent.routing "active".
+func (d *Daemon) touchAgent(agent string) {
+	if d.agent.Routing != config.AgentRoutingActive {
+		return
+	}
+	d.mu.Lock()
+	d.activeAgent = agent
+	d.mu.Unlock()
+}
+
+// peerOf returns the client messages from client are routed to: Neovim
+// for an agent, and for Neovim the agent agent.routing picks.
+func (d *Daemon) peerOf(client string) string {
+	switch clientType(client) {
+	case "neovim":
+		return d.agentFor()
+	case "crush":
+		return "neovim"
+	default:
+		return ""
+	}
+}
+
+// peersLocked returns every client that hears about client's session:
+// Neovim for an agent, every agent for Neovim. d.mu must be held.
+func (d *Daemon) peersLocked(client string) []string {
+	switch clientType(client) {
+	case "neovim":
+		return slices.Clone(d.agents)
+	case "crush":
+		return []string{"neovim"}
+	default:
+		return nil
+	}
+}
+
+// agentConns returns the connections of every agent, keyed by client ID.
+func (d *Daemon) agentConns() map[string]net.Conn {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	conns := make(map[string]net.Conn, len(d.agents))
+	for _, name := range d.agents {
+		if conn := d.clients[name]; conn != nil {
+			conns[name] = conn
+		}
+	}
+	return conns
+}
+
+// agentEditBaseline returns the document a didChange from an agent edits
+// and its text before the edit, if other agents are connected to tell.
+func (d *Daemon) agentEditBaseline(method string, content []byte) (uri, before string) {
+	if method != "textDocument/didChange" {
+		return "", ""
+	}
+	var req struct {
+		Params struct {
+			TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(content, &req) != nil {
+		return "", ""
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.agents) < 2 {
+		return "", ""
+	}
+	return req.Params.TextDocument.URI, d.documentState[req.Params.TextDocument.URI]
+}
+
+// shareAgentEdit sends the text of uri to every agent but from, as
+// crush/documentChanged, if from's edit changed it. Their copies would
+// otherwise miss it, and their next incremental change would not apply.
+func (d *Daemon) shareAgentEdit(from, uri, before string) {
+	d.mu.RLock()
+	text, ok := d.documentState[uri]
+	d.mu.RUnlock()
+	if !ok || text == before {
+		return
+	}
+
+	msg := []byte(rpc.EncodeMessage(lsp.DocumentChangedNotification{
+		Notification: lsp.Notification{RPC: "2.0", Method: "crush/documentChanged"},
+		Params: lsp.DocumentChangedParams{
+			TextDocument: lsp.VersionTextDocumentIdentifier{TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri}},
+			Content:      text,
+			ChangeSource: "crush",
+		},
+	}))
+	for name, conn := range d.agentConns() {
+		if name == from {
+			continue
+		}
+		if _, err := conn.Write(msg); err != nil {
+			d.logger.Printf("Failed to share %s's edit of %s with %s: %v", from, uri, name, err)
+		}
+	}
+}
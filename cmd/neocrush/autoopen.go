@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// autoOpenTitle titles the crush/showLocations list of surfaced files.
+const autoOpenTitle = "Files opened by Crush"
+
+// surfaceFile brings a file Crush opened or created to the user's attention
+// as files.auto_open says, unless Neovim has it open or it was surfaced
+// before.
+func (d *Daemon) surfaceFile(uri string) {
+	if d.autoOpen == "" {
+		return
+	}
+
+	d.mu.Lock()
+	seen := d.neovimOpenDocs[uri] || d.surfaced[uri]
+	d.surfaced[uri] = true
+	d.mu.Unlock()
+	if seen {
+		return
+	}
+
+	switch d.autoOpen {
+	case config.AutoOpenShow:
+		d.requestNeovim("window/showDocument", lsp.ShowDocumentParams{URI: uri})
+	case config.AutoOpenQuickfix:
+		path, err := uriToPath(uri)
+		if err != nil {
+			return
+		}
+		d.forwardToNeovim([]byte(rpc.EncodeMessage(lsp.ShowLocationsNotification{
+			Notification: lsp.Notification{
+				RPC:    "2.0",
+				Method: "crush/showLocations",
+			},
+			Params: lsp.ShowLocationsParams{
+				Title: autoOpenTitle,
+				Items: []lsp.LocationItem{{Filename: path, Line: 1, Note: "Opened by Crush", Type: "I"}},
+			},
+		})))
+	default:
+		return
+	}
+	d.logger.Printf("Surfaced %s in Neovim (%s)", uri, d.autoOpen)
+}
+
+// surfaceCreated returns a response observer for a workspace/applyEdit
+// request from Crush that surfaces the files it creates once Neovim has
+// applied it, or nil if there are none to surface.
+func (d *Daemon) surfaceCreated(content []byte) func([]byte) {
+	if d.autoOpen == "" {
+		return nil
+	}
+
+	var req struct {
+		Params struct {
+			Edit struct {
+				DocumentChanges []struct {
+					Kind string `json:"kind"`
+					URI  string `json:"uri"`
+				} `json:"documentChanges"`
+			} `json:"edit"`
+		} `json:"params"`
+	}
+	_ = json.Unmarshal(content, &req)
+
+	var created []string
+	for _, change := range req.Params.Edit.DocumentChanges {
+		if change.Kind == "create" && change.URI != "" {
+			created = append(created, change.URI)
+		}
+	}
+	if len(created) == 0 {
+		return nil
+	}
+
+	return func(resp []byte) {
+		var msg struct {
+			Result lsp.ApplyWorkspaceEditResult `json:"result"`
+		}
+		if json.Unmarshal(resp, &msg) != nil || !msg.Result.Applied {
+			return
+		}
+		for _, uri := range created {
+			d.surfaceFile(uri)
+		}
+	}
+}
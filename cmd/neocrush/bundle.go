@@ -0,0 +1,483 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/capture"
+	"github.com/taigrr/neocrush/internal/history"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// bundleClientName identifies the bundle command to the daemon.
+const bundleClientName = "bundle"
+
+// maxBundleLog is how much of the end of the daemon log a bundle keeps.
+const maxBundleLog = 4 << 20
+
+// Files in a bundle. Nothing else is extracted from one.
+const (
+	bundleManifestFile = "manifest.json"
+	bundleSessionFile  = "session.json"
+	bundleStateFile    = "state.json"
+	bundleLogFile      = "daemon.log"
+	bundleCaptureFile  = "capture.jsonl"
+)
+
+var bundleFiles = []string{bundleManifestFile, bundleSessionFile, bundleStateFile, bundleLogFile, bundleCaptureFile}
+
+// bundleManifest describes a bundle: where and by what it was made, and
+// what it holds.
+type bundleManifest struct {
+	Created   time.Time `json:"created"`
+	Version   string    `json:"version"` // neocrush version that made the bundle
+	GoVersion string    `json:"goVersion"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	Workspace string    `json:"workspace"`
+	Session   string    `json:"session,omitempty"`
+	Contents  bool      `json:"contents"` // Whether file contents were kept
+	Files     []string  `json:"files"`
+	Missing   []string  `json:"missing,omitempty"` // What couldn't be collected, and why
+}
+
+// bundleState is the running daemon's state when the bundle was made.
+type bundleState struct {
+	Session lsp.SessionInfoResult `json:"session"`
+	State   lsp.GetStateResult    `json:"state"`
+	Metrics lsp.GetMetricsResult  `json:"metrics"`
+}
+
+// bundleSource is what a bundle is made from. Empty fields are left out
+// of it and recorded as missing.
+type bundleSource struct {
+	Workspace string
+	Session   *session.Session
+	LogPath   string
+	State     *bundleState
+	StateErr  error // Why State is nil
+	Contents  bool
+	Created   time.Time
+}
+
+// bundleRedact masks secrets in a JSON message and, unless file contents
+// are kept, replaces long strings by their size.
+func bundleRedact(msg json.RawMessage, contents bool) json.RawMessage {
+	if contents {
+		return history.RedactSecrets(msg)
+	}
+	return history.Redact(msg)
+}
+
+// writeBundle writes a gzipped tarball of src to w.
+func writeBundle(w io.Writer, src bundleSource) (bundleManifest, error) {
+	manifest := bundleManifest{
+		Created:   src.Created,
+		Version:   version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Workspace: src.Workspace,
+		Contents:  src.Contents,
+		Files:     []string{},
+	}
+	files := make(map[string][]byte)
+	missing := func(format string, args ...any) {
+		manifest.Missing = append(manifest.Missing, fmt.Sprintf(format, args...))
+	}
+
+	if src.Session != nil {
+		manifest.Session = src.Session.ID
+		if data, err := os.ReadFile(filepath.Join(src.Workspace, ".crush", session.SessionFileName)); err == nil {
+			files[bundleSessionFile] = history.RedactSecrets(data)
+		} else {
+			missing("session metadata: %v", err)
+		}
+
+		if data, err := bundleCapture(capture.Path(capture.Dir(src.Workspace), src.Session.ID), src.Contents); err == nil {
+			files[bundleCaptureFile] = data
+		} else {
+			missing("capture: %v (enable it with \"debug\": {\"capture\": true})", err)
+		}
+	} else {
+		missing("session metadata: no neocrush session for %s", src.Workspace)
+	}
+
+	if src.State != nil {
+		data, err := json.Marshal(src.State)
+		if err != nil {
+			return manifest, err
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, bundleRedact(data, src.Contents), "", "  "); err == nil {
+			files[bundleStateFile] = out.Bytes()
+		} else {
+			missing("state: %v", err)
+		}
+	} else if src.StateErr != nil {
+		missing("state: %v", src.StateErr)
+	}
+
+	if src.LogPath != "" {
+		if data, err := bundleLog(src.LogPath); err == nil {
+			files[bundleLogFile] = data
+		} else {
+			missing("daemon log: %v", err)
+		}
+	}
+
+	for _, name := range bundleFiles {
+		if files[name] != nil {
+			manifest.Files = append(manifest.Files, name)
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	files[bundleManifestFile] = data
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range bundleFiles {
+		data, ok := files[name]
+		if !ok {
+			continue
+		}
+		header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: src.Created, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return manifest, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return manifest, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+// bundleCapture returns the capture at path with its messages redacted.
+func bundleCapture(path string, contents bool) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	err = capture.Read(f, func(rec capture.Record) error {
+		rec.Message = bundleRedact(rec.Message, contents)
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		out.Write(append(data, '\n'))
+		return nil
+	})
+	return out.Bytes(), err
+}
+
+// bundleLog returns the last maxBundleLog bytes of the log at path, whole
+// lines only, with credentials masked.
+func bundleLog(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	cut := info.Size() > maxBundleLog
+	if cut {
+		if _, err := f.Seek(-maxBundleLog, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxBundleLog)
+	for scanner.Scan() {
+		if cut {
+			cut = false // The first line is likely partial
+			continue
+		}
+		out.WriteString(history.RedactString("", scanner.Text()) + "\n")
+	}
+	return out.Bytes(), scanner.Err()
+}
+
+// fetchBundleState identifies as the bundle client and asks the daemon for
+// its session, editor state and metrics.
+func fetchBundleState(socketPath, workspace string, contents bool) (*bundleState, error) {
+	c, err := connectAs(socketPath, workspace, bundleClientName)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var state bundleState
+	for _, req := range []struct {
+		method string
+		params any
+		result any
+	}{
+		{"crush/sessionInfo", nil, &state.Session},
+		{"crush/getState", lsp.GetStateParams{IncludeContent: contents, IncludeDiagnostics: true, IncludeCursor: true, IncludeCapabilities: true}, &state.State},
+		{"crush/getMetrics", nil, &state.Metrics},
+	} {
+		result, err := c.Request(req.method, req.params)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", req.method, err)
+		}
+		if err := json.Unmarshal(result, req.result); err != nil {
+			return nil, fmt.Errorf("failed to parse %s result: %w", req.method, err)
+		}
+	}
+	return &state, nil
+}
+
+// extractBundle writes the files of the bundle at path to dir and returns
+// its manifest.
+func extractBundle(path, dir string) (bundleManifest, error) {
+	var manifest bundleManifest
+
+	f, err := os.Open(path)
+	if err != nil {
+		return manifest, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return manifest, fmt.Errorf("%s is not a neocrush bundle: %w", path, err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return manifest, err
+	}
+
+	found := false
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("%s is not a neocrush bundle: %w", path, err)
+		}
+		if header.Typeflag != tar.TypeReg || !slices.Contains(bundleFiles, header.Name) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return manifest, err
+		}
+		if header.Name == bundleManifestFile {
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return manifest, fmt.Errorf("invalid manifest: %w", err)
+			}
+			found = true
+		}
+		if err := os.WriteFile(filepath.Join(dir, header.Name), data, 0o600); err != nil {
+			return manifest, err
+		}
+	}
+	if !found {
+		return manifest, fmt.Errorf("%s is not a neocrush bundle: no %s", path, bundleManifestFile)
+	}
+	return manifest, nil
+}
+
+// writeBundleManifest prints what a bundle holds.
+func writeBundleManifest(out io.Writer, m bundleManifest) {
+	fmt.Fprintf(out, "Created:   %s by neocrush %s (%s, %s/%s)\n", m.Created.Local().Format(time.DateTime), m.Version, m.GoVersion, m.OS, m.Arch)
+	fmt.Fprintf(out, "Workspace: %s\n", m.Workspace)
+	if m.Session != "" {
+		fmt.Fprintf(out, "Session:   %s\n", m.Session)
+	}
+	files := strings.Join(m.Files, ", ")
+	if files == "" {
+		files = "none"
+	}
+	fmt.Fprintf(out, "Files:     %s\n", files)
+	if !m.Contents {
+		fmt.Fprintln(out, "           file contents replaced by their sizes")
+	}
+	for _, what := range m.Missing {
+		fmt.Fprintf(out, "Missing:   %s\n", what)
+	}
+}
+
+func newBundleCmd() *cobra.Command {
+	var (
+		output   string
+		logPath  string
+		contents bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Package logs, a capture and daemon state for a bug report",
+		Long: `Writes a gzipped tarball describing the neocrush session of the workspace
+in the current directory, to attach to a bug report:
+
+  manifest.json  neocrush, Go and OS versions, and what the bundle holds
+  session.json   the session file: socket, daemon PID, version and start time
+  state.json     the running daemon's clients, editor state and metrics
+  daemon.log     the end of the daemon log
+  capture.jsonl  the session's wire capture, if "debug": {"capture": true}
+
+Tokens, passwords and credentials in URLs are masked everywhere. File
+contents are replaced by their sizes unless --contents is given; keep them
+when the bundle is for "neocrush bundle import", which replays the capture,
+and only share such a bundle with people who may read the code. Parts that
+can't be collected (no capture, no running daemon) are listed in the
+manifest instead.`,
+		Example: `  neocrush bundle
+  neocrush bundle --contents -o bug.tar.gz`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			mgr := session.NewManager()
+			src := bundleSource{Workspace: cwd, LogPath: logPath, Contents: contents, Created: time.Now()}
+			if src.LogPath == "" {
+				src.LogPath = filepath.Join(mgr.SocketDir(), "daemon.log")
+			}
+			if sess, err := mgr.LoadSessionMetadata(cwd); err == nil {
+				src.Session = sess
+				src.State, src.StateErr = fetchBundleState(sess.SocketPath, cwd, contents)
+			}
+
+			if output == "" {
+				output = fmt.Sprintf("neocrush-bundle-%s.tar.gz", src.Created.Format("20060102-150405"))
+			}
+			var w io.Writer = cmd.OutOrStdout()
+			if output != "-" {
+				f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+
+			manifest, err := writeBundle(w, src)
+			if err != nil {
+				return err
+			}
+			if output != "-" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Wrote %s\n", output)
+				for _, what := range manifest.Missing {
+					fmt.Fprintf(cmd.ErrOrStderr(), "Missing %s\n", what)
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", `File to write, "-" for stdout (default neocrush-bundle-<time>.tar.gz)`)
+	cmd.Flags().StringVar(&logPath, "log", "", "Daemon log to include (default the log of spawned daemons)")
+	cmd.Flags().BoolVar(&contents, "contents", false, "Keep file contents, so the capture can be replayed")
+	cmd.AddCommand(newBundleImportCmd())
+	return cmd
+}
+
+func newBundleImportCmd() *cobra.Command {
+	var (
+		dir        string
+		workspace  string
+		timeout    time.Duration
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import <bundle.tar.gz>",
+		Short: "Unpack a bundle and replay its capture",
+		Long: `Extracts a bundle made by "neocrush bundle" into --dir, prints its manifest,
+and replays its capture against a fresh daemon as "neocrush replay" does.
+The capture is only replayed if the bundle kept file contents (--contents);
+otherwise the extracted files are left for reading. The daemon reads files
+and config from --workspace, which should match the reporter's workspace
+(check out the commit they were on).`,
+		Example: `  neocrush bundle import bug.tar.gz
+  neocrush bundle import bug.tar.gz --dir /tmp/bug --workspace ~/src/project --json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if workspace == "" {
+				cwd, err := os.Getwd()
+				if err != nil {
+					return err
+				}
+				workspace = cwd
+			}
+			if dir == "" {
+				base := filepath.Base(args[0])
+				for _, ext := range []string{".tar.gz", ".tgz"} {
+					base = strings.TrimSuffix(base, ext)
+				}
+				dir = filepath.Join(filepath.Dir(args[0]), base)
+			}
+
+			manifest, err := extractBundle(args[0], dir)
+			if err != nil {
+				return err
+			}
+			var result *replayResult
+			if manifest.Contents && slices.Contains(manifest.Files, bundleCaptureFile) {
+				r, err := replayCapture(filepath.Join(dir, bundleCaptureFile), workspace, timeout)
+				if err != nil {
+					return err
+				}
+				result = &r
+			}
+
+			out := cmd.OutOrStdout()
+			if jsonOutput {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(map[string]any{"dir": dir, "manifest": manifest, "replay": result}); err != nil {
+					return err
+				}
+			} else {
+				fmt.Fprintf(out, "Extracted to %s\n", dir)
+				writeBundleManifest(out, manifest)
+				if result != nil {
+					fmt.Fprintln(out)
+					writeReplay(out, *result)
+				}
+			}
+			if result != nil && len(result.Mismatches) > 0 {
+				return errors.New("the replay diverged from the capture")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory to extract to (default the bundle's path without .tar.gz)")
+	cmd.Flags().StringVar(&workspace, "workspace", "", "Workspace the replayed daemon serves (default: current directory)")
+	cmd.Flags().DurationVar(&timeout, "timeout", defaultReplayTimeout, "How long to wait for each message the capture expects")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the manifest and replay result as JSON")
+	return cmd
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// inflightRequests holds the contexts of requests the daemon is serving,
+// so clients can cancel them with $/cancelRequest. It has its own lock
+// because requests are answered from code that may hold d.mu.
+type inflightRequests struct {
+	mu       sync.Mutex
+	requests map[spanKey]inflightRequest
+}
+
+// inflightRequest is the context of a request being served.
+type inflightRequest struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// start returns the context of the request id from conn, derived from
+// parent the first time it is asked for and ending after timeout, unless
+// timeout is 0.
+func (r *inflightRequests) start(parent context.Context, conn net.Conn, id json.RawMessage, timeout time.Duration) context.Context {
+	key := spanKey{conn, string(id)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req, ok := r.requests[key]; ok {
+		return req.ctx
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	r.requests[key] = inflightRequest{ctx: ctx, cancel: cancel}
+	return ctx
+}
+
+// cancel cancels the request id from conn without releasing it. It
+// reports whether the request was in flight.
+func (r *inflightRequests) cancel(conn net.Conn, id json.RawMessage) bool {
+	r.mu.Lock()
+	req, ok := r.requests[spanKey{conn, string(id)}]
+	r.mu.Unlock()
+
+	if ok {
+		req.cancel()
+	}
+	return ok
+}
+
+// finish releases the context of the request id from conn once it is
+// answered.
+func (r *inflightRequests) finish(conn net.Conn, id json.RawMessage) {
+	key := spanKey{conn, string(id)}
+
+	r.mu.Lock()
+	req, ok := r.requests[key]
+	delete(r.requests, key)
+	r.mu.Unlock()
+
+	if ok {
+		req.cancel()
+	}
+}
+
+// finishAll cancels the requests conn is still waiting on when it
+// disconnects.
+func (r *inflightRequests) finishAll(conn net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, req := range r.requests {
+		if key.conn == conn {
+			req.cancel()
+			delete(r.requests, key)
+		}
+	}
+}
+
+// cancelRequest handles a $/cancelRequest from conn, cancelling the
+// request if the daemon is serving it. It reports whether it was; other
+// cancellations are for requests relayed to the peer.
+func (d *Daemon) cancelRequest(conn net.Conn, content []byte) bool {
+	var notif struct {
+		Params struct {
+			ID json.RawMessage `json:"id"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(content, &notif); err != nil || len(notif.Params.ID) == 0 {
+		return false
+	}
+	// The request's handler answers once it notices
+	if !d.inflight.cancel(conn, notif.Params.ID) {
+		return false
+	}
+	d.logger.Printf("Cancelled request #%s", notif.Params.ID)
+	return true
+}
+
+// selfTimedMethods are requests the daemon serves that are bounded by
+// their own, longer timeouts: jobs by jobs.timeout_ms, git operations by
+// the user's confirmation and git's timeout. The handler timeout doesn't
+// apply unless requests.deadlines names them.
+var selfTimedMethods = map[string]bool{
+	"crush/runJob":     true,
+	"crush/stageFiles": true,
+	"crush/commit":     true,
+}
+
+// handlerDeadline returns how long the daemon may serve the request in
+// content, or 0 if it is unbounded: requests.deadlines for its method, or
+// else requests.handler_timeout_ms.
+func (d *Daemon) handlerDeadline(content []byte) time.Duration {
+	var msg struct {
+		Method string `json:"method"`
+	}
+	json.Unmarshal(content, &msg)
+	fallback := d.requests.HandlerTimeout()
+	if selfTimedMethods[msg.Method] {
+		fallback = 0
+	}
+	return d.requests.Deadline(msg.Method, fallback)
+}
+
+// forwardContext returns the context of forwarding a message with method
+// to the peer, which ends after the method's deadline (requests.deadlines,
+// else requests.handler_timeout_ms), so a transform blocked reading a file
+// doesn't hold up the client's other messages.
+func (d *Daemon) forwardContext(method string) (context.Context, context.CancelFunc) {
+	if timeout := d.requests.Deadline(method, d.requests.HandlerTimeout()); timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// failureCode returns the error code to answer a failed request with:
+// RequestCancelled if the client cancelled it, else code.
+func failureCode(err error, code int) int {
+	if errors.Is(err, context.Canceled) {
+		return lsp.RequestCancelled
+	}
+	return code
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/journal"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// journalSize bounds the change journal.
+	journalSize = 1000
+	// defaultRecentMinutes is the crush/recentChanges window when unset.
+	defaultRecentMinutes = 10
+)
+
+// recordChange adds a document change to the journal and the session
+// transcript, and to the open transaction of the agent that made it.
+func (d *Daemon) recordChange(source, uri, oldText, newText string) {
+	added, removed := journal.LineStats(oldText, newText)
+	d.journal.Record(journal.Entry{
+		Source:  source,
+		URI:     uri,
+		Added:   added,
+		Removed: removed,
+	})
+	d.recordEdit(source, uri, added, removed)
+	if source == "crush" || source == "mcp" {
+		d.trackTransaction(source, uri, added, removed)
+		d.recordBaseline(uri, oldText)
+	}
+	d.publishDocumentChanged(uri, newText, source)
+}
+
+// handleRecentChanges answers crush/recentChanges with a per-document
+// summary of the journal.
+func (d *Daemon) handleRecentChanges(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.RecentChangesParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid recentChanges params: "+err.Error())
+		return
+	}
+
+	minutes := req.Params.Minutes
+	if minutes <= 0 {
+		minutes = defaultRecentMinutes
+	}
+	since := time.Now().Add(-time.Duration(minutes) * time.Minute)
+
+	result := lsp.RecentChangesResult{
+		Since: since.Format(time.RFC3339),
+		Files: []lsp.ChangedFile{},
+	}
+	for _, f := range d.journal.Summarize(since) {
+		result.Files = append(result.Files, lsp.ChangedFile{
+			URI:         f.URI,
+			Changes:     f.Changes,
+			Added:       f.Added,
+			Removed:     f.Removed,
+			Sources:     f.Sources,
+			LastChanged: f.LastChanged.Format(time.RFC3339),
+		})
+	}
+	d.respondResult(conn, messageID(content), result)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/checkpoint"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleCreateCheckpoint answers crush/createCheckpoint.
+func (d *Daemon) handleCreateCheckpoint(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.CreateCheckpointParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid createCheckpoint params: "+err.Error())
+		return
+	}
+	if len(req.Params.Paths) == 0 {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "paths is required")
+		return
+	}
+
+	var rels []string
+	for _, p := range req.Params.Paths {
+		path, err := d.resolveWorkspacePath(p)
+		if err != nil {
+			d.respondError(conn, messageID(content), lsp.InvalidParams, fmt.Sprintf("%s: %v", p, err))
+			return
+		}
+		rel, err := filepath.Rel(d.workspace, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			d.respondError(conn, messageID(content), lsp.InvalidParams, p+": checkpoints only cover the workspace root")
+			return
+		}
+		rels = append(rels, rel)
+	}
+
+	ctx := d.requestContext(conn, content)
+	progress := beginWorkDone(conn, content, "Creating checkpoint")
+	source := d.checkpointSource(ctx)
+	read := 0
+	cp, err := checkpoint.Create(d.workspace, req.Params.Name, rels, func(path string) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		progress.report(read, len(rels), "Reading "+filepath.Base(path))
+		read++
+		return source(path)
+	})
+	progress.end("")
+	if err != nil {
+		d.respondError(conn, messageID(content), failureCode(err, lsp.RequestFailed), err.Error())
+		return
+	}
+	d.logger.Printf("Created checkpoint %s (%q, %d files)", cp.ID, cp.Name, len(cp.Files))
+	d.respondResult(conn, messageID(content), checkpointInfo(*cp))
+}
+
+// checkpointSource returns the reader for checkpoint files, which prefers
+// Neovim's buffer so unsaved changes are included.
+func (d *Daemon) checkpointSource(ctx context.Context) checkpoint.ReadFunc {
+	return func(path string) ([]byte, error) {
+		uri := "file://" + path
+		d.mu.RLock()
+		neovimHasFile := d.neovimOpenDocs[uri]
+		d.mu.RUnlock()
+
+		if neovimHasFile {
+			if text := d.documentContent(ctx, "neovim", uri); text != nil {
+				return []byte(*text), nil
+			}
+		}
+		return os.ReadFile(path)
+	}
+}
+
+// handleListCheckpoints answers crush/listCheckpoints.
+func (d *Daemon) handleListCheckpoints(content []byte, conn net.Conn) {
+	checkpoints, err := checkpoint.List(d.workspace)
+	if err != nil {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+		return
+	}
+
+	result := lsp.ListCheckpointsResult{Checkpoints: []lsp.Checkpoint{}}
+	for _, cp := range checkpoints {
+		result.Checkpoints = append(result.Checkpoints, checkpointInfo(cp))
+	}
+	d.respondResult(conn, messageID(content), result)
+}
+
+// handleRestoreCheckpoint answers crush/restoreCheckpoint.
+func (d *Daemon) handleRestoreCheckpoint(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.RestoreCheckpointParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid restoreCheckpoint params: "+err.Error())
+		return
+	}
+
+	cp, err := checkpoint.Find(d.workspace, req.Params.Checkpoint)
+	if errors.Is(err, checkpoint.ErrNotFound) {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, err.Error())
+		return
+	}
+	if err != nil {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+		return
+	}
+
+	ctx := d.requestContext(conn, content)
+	progress := beginWorkDone(conn, content, "Restoring checkpoint "+cp.ID)
+	result := lsp.RestoreCheckpointResult{ID: cp.ID, Restored: []string{}}
+	for i, f := range cp.Files {
+		if ctx.Err() != nil {
+			// Files already restored stay restored
+			result.Failed = append(result.Failed, f.Path+": cancelled")
+			continue
+		}
+		progress.report(i, len(cp.Files), "Restoring "+f.Path)
+		if err := d.restoreCheckpointFile(ctx, cp, f); err != nil {
+			result.Failed = append(result.Failed, fmt.Sprintf("%s: %v", f.Path, err))
+			continue
+		}
+		result.Restored = append(result.Restored, f.Path)
+	}
+	progress.end("")
+	d.logger.Printf("Restored checkpoint %s (%d files, %d failed)", cp.ID, len(result.Restored), len(result.Failed))
+	d.respondResult(conn, messageID(content), result)
+}
+
+// restoreCheckpointFile restores one file: in Neovim's buffer when it is
+// open there, otherwise on disk. Crush is sent the restored text.
+func (d *Daemon) restoreCheckpointFile(ctx context.Context, cp *checkpoint.Checkpoint, f checkpoint.File) error {
+	data, err := cp.Content(d.workspace, f)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(d.workspace, filepath.FromSlash(f.Path))
+	uri := "file://" + path
+
+	d.mu.RLock()
+	neovimHasFile := d.neovimOpenDocs[uri]
+	d.mu.RUnlock()
+
+	if neovimHasFile && f.Exists {
+		result, _, err := d.writeBuffer(ctx, "checkpoint", uri, string(data), lsp.WriteFileResult{Path: path})
+		if err != nil {
+			return err
+		}
+		if !result.Applied {
+			return errors.New("neovim did not apply the edit")
+		}
+	} else {
+		old, _ := os.ReadFile(path)
+		if !f.Exists {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		} else {
+			mode := fs.FileMode(0o644)
+			if info, err := os.Stat(path); err == nil {
+				mode = info.Mode().Perm()
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, data, mode); err != nil {
+				return err
+			}
+		}
+
+		d.mu.Lock()
+		if f.Exists {
+			d.documentState[uri] = string(data)
+		} else {
+			delete(d.documentState, uri)
+		}
+		d.fileLists = nil // Files may have been deleted or recreated
+		d.mu.Unlock()
+		d.state.Touch()
+		d.documentReplaced(uri)
+		d.recordChange("checkpoint", uri, string(old), string(data))
+	}
+
+	if !f.Exists {
+		return nil
+	}
+	if err := d.sendDocumentChanged(uri, string(data), "neovim"); err != nil {
+		d.logger.Printf("Failed to push restored %s to crush: %v", f.Path, err)
+	}
+	return nil
+}
+
+// checkpointInfo converts a checkpoint to its wire form.
+func checkpointInfo(cp checkpoint.Checkpoint) lsp.Checkpoint {
+	info := lsp.Checkpoint{
+		ID:      cp.ID,
+		Name:    cp.Name,
+		Created: cp.Created.Format(time.RFC3339),
+		Files:   make([]lsp.CheckpointFile, 0, len(cp.Files)),
+	}
+	for _, f := range cp.Files {
+		info.Files = append(info.Files, lsp.CheckpointFile{Path: f.Path, Exists: f.Exists, Size: f.Size})
+	}
+	return info
+}
+
+// newCheckpointCmd returns the `checkpoint` subcommand, which manages the
+// checkpoints of the workspace in the current directory.
+func newCheckpointCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "checkpoint",
+		Short: "Create, list, and restore file snapshots",
+		Long: `Manages the snapshots in .crush/checkpoints for the workspace in the
+current directory. Agents create them with the create_checkpoint tool before
+large edits; restoring puts every file back as it was, deleting files that did
+not exist yet.
+
+Restoring from the command line writes to disk. Reload buffers open in Neovim
+afterwards, or restore with crush/restoreCheckpoint from the plugin to restore
+them in place.`,
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List checkpoints, oldest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			checkpoints, err := checkpoint.List(cwd)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			for _, cp := range checkpoints {
+				paths := make([]string, 0, len(cp.Files))
+				for _, f := range cp.Files {
+					paths = append(paths, f.Path)
+				}
+				fmt.Fprintf(out, "%s  %-20s  %s\n", cp.ID, cp.Name, strings.Join(paths, " "))
+			}
+			return nil
+		},
+	}
+
+	var name string
+	create := &cobra.Command{
+		Use:   "create PATH...",
+		Short: "Snapshot files from disk",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			cp, err := checkpoint.Create(cwd, name, args, nil)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Created checkpoint %s (%d files)\n", cp.ID, len(cp.Files))
+			return nil
+		},
+	}
+	create.Flags().StringVar(&name, "name", "", "Checkpoint name")
+
+	restore := &cobra.Command{
+		Use:   "restore ID|NAME",
+		Short: "Restore a checkpoint to disk",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			cp, err := checkpoint.Find(cwd, args[0])
+			if err != nil {
+				return err
+			}
+			restored, err := checkpoint.Restore(cwd, cp)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Restored %s: %s\n", cp.ID, strings.Join(restored, " "))
+			return nil
+		},
+	}
+
+	cmd.AddCommand(list, create, restore)
+	return cmd
+}
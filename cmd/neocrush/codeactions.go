@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// maxActionTitle caps the diagnostic message quoted in a fix action's
+// title.
+const maxActionTitle = 60
+
+// handleCodeActionRequest answers Neovim's textDocument/codeAction with AI
+// actions bound to the crush.invokeAction command: explain the selection,
+// generate a test, and fix each diagnostic Neovim sent. There are none while
+// Crush is not connected.
+func (d *Daemon) handleCodeActionRequest(content []byte, conn net.Conn) {
+	var req struct {
+		ID     json.RawMessage                  `json:"id"`
+		Params lsp.TextDocumentCodeActionParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid codeAction params: "+err.Error())
+		return
+	}
+
+	d.mu.RLock()
+	crush := d.clients[d.agentForLocked()]
+	d.mu.RUnlock()
+
+	actions := []lsp.CodeAction{}
+	if crush == nil {
+		d.respondResult(conn, req.ID, actions)
+		return
+	}
+
+	params := req.Params
+	uri := params.TextDocument.URI
+	add := func(title, kind, action string, r lsp.Range, diagnostic *lsp.Diagnostic) {
+		if !wantsCodeActionKind(params.Context.Only, kind) {
+			return
+		}
+		codeAction := lsp.CodeAction{
+			Title: title,
+			Kind:  kind,
+			Command: &lsp.Command{
+				Title:   title,
+				Command: lsp.InvokeActionCommand,
+				Arguments: []any{lsp.InvokeActionParams{
+					Action:       action,
+					TextDocument: params.TextDocument,
+					Range:        r,
+					LanguageID:   workspace.Language(extractFilename(uri)),
+					Diagnostic:   diagnostic,
+				}},
+			},
+		}
+		if diagnostic != nil {
+			codeAction.Diagnostics = []lsp.Diagnostic{*diagnostic}
+		}
+		actions = append(actions, codeAction)
+	}
+
+	if params.Range.Start != params.Range.End {
+		add("AI: Explain selection", lsp.CodeActionKindExplain, lsp.ActionExplain, params.Range, nil)
+	}
+	add("AI: Generate test", lsp.CodeActionKindGenerateTest, lsp.ActionGenerateTest, params.Range, nil)
+	for i := range params.Context.Diagnostics {
+		diagnostic := &params.Context.Diagnostics[i]
+		add("AI: Fix "+actionTitle(diagnostic.Message), lsp.CodeActionKindFix, lsp.ActionFixDiagnostic, diagnostic.Range, diagnostic)
+	}
+	d.respondResult(conn, req.ID, actions)
+}
+
+// wantsCodeActionKind reports whether kind passes a codeAction request's
+// context.only filter, which also admits subkinds of the kinds it lists.
+func wantsCodeActionKind(only []string, kind string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, o := range only {
+		if kind == o || strings.HasPrefix(kind, o+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// actionTitle shortens a diagnostic message to its first line, cut to
+// maxActionTitle characters.
+func actionTitle(message string) string {
+	message, _, _ = strings.Cut(strings.TrimSpace(message), "\n")
+	if runes := []rune(message); len(runes) > maxActionTitle {
+		message = string(runes[:maxActionTitle-1]) + "…"
+	}
+	return message
+}
+
+// handleInvokeAction turns Neovim's workspace/executeCommand for an AI code
+// action into a crush/invokeAction request, or crush/fixDiagnostic for a
+// fix. Crush's response is relayed back as the command's result. Returns
+// false for other commands.
+func (d *Daemon) handleInvokeAction(content []byte, conn net.Conn) bool {
+	var req struct {
+		ID     json.RawMessage          `json:"id"`
+		Params lsp.ExecuteCommandParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil || req.Params.Command != lsp.InvokeActionCommand {
+		return false
+	}
+
+	var params lsp.InvokeActionParams
+	if len(req.Params.Arguments) != 1 || json.Unmarshal(req.Params.Arguments[0], &params) != nil || params.Action == "" {
+		d.respondError(conn, req.ID, lsp.InvalidParams, "crush.invokeAction expects one action argument")
+		return true
+	}
+	if params.Action == lsp.ActionFixDiagnostic && params.Diagnostic != nil {
+		d.fixDiagnostic(conn, req.ID, params.TextDocument, *params.Diagnostic)
+		return true
+	}
+	d.logger.Printf("Invoking AI action %s on %s", params.Action, extractFilename(params.TextDocument.URI))
+	d.relayCommandToCrush(conn, req.ID, "crush/invokeAction", params, params.TextDocument.URI)
+	return true
+}
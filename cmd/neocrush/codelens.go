@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleCodeLens stores AI actions published by Crush via crush/codeLens and
+// asks Neovim to re-query them.
+func (d *Daemon) handleCodeLens(content []byte) {
+	var notif lsp.CodeLensNotification
+	if err := json.Unmarshal(content, &notif); err != nil {
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeParse,
+			Message: fmt.Sprintf("failed to parse codeLens: %v", err),
+			Method:  "crush/codeLens",
+		})
+		return
+	}
+
+	uri := notif.Params.TextDocument.URI
+
+	d.mu.Lock()
+	if len(notif.Params.Lenses) == 0 {
+		delete(d.codeLenses, uri)
+	} else {
+		d.codeLenses[uri] = notif.Params.Lenses
+	}
+	d.mu.Unlock()
+
+	d.logger.Printf("Crush published %d code lenses for %s", len(notif.Params.Lenses), extractFilename(uri))
+	d.refreshCodeLenses()
+}
+
+// handleCodeLensRequest answers Neovim's textDocument/codeLens with the
+// lenses Crush last published, each bound to the crush.executeLens command.
+func (d *Daemon) handleCodeLensRequest(content []byte, conn net.Conn) {
+	var req struct {
+		ID     json.RawMessage    `json:"id"`
+		Params lsp.CodeLensParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.logger.Printf("Failed to parse codeLens request: %v", err)
+		return
+	}
+
+	uri := req.Params.TextDocument.URI
+
+	d.mu.RLock()
+	lenses := []lsp.CodeLens{}
+	for _, lens := range d.codeLenses[uri] {
+		lenses = append(lenses, lsp.CodeLens{
+			Range: lens.Range,
+			Command: &lsp.Command{
+				Title:   lens.Title,
+				Command: lsp.ExecuteLensCommand,
+				Arguments: []any{lsp.ExecuteLensParams{
+					ID:           lens.ID,
+					TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+					Range:        lens.Range,
+					Action:       lens.Action,
+				}},
+			},
+		})
+	}
+	d.mu.RUnlock()
+
+	d.respondResult(conn, req.ID, lenses)
+}
+
+// handleExecuteLens turns Neovim's workspace/executeCommand for an AI lens
+// into a crush/executeLens request. Crush's response is relayed back as the
+// command's result. Returns false for other commands, which are routed
+// normally.
+func (d *Daemon) handleExecuteLens(content []byte, conn net.Conn) bool {
+	var req struct {
+		ID     json.RawMessage          `json:"id"`
+		Params lsp.ExecuteCommandParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil || req.Params.Command != lsp.ExecuteLensCommand {
+		return false
+	}
+
+	var params lsp.ExecuteLensParams
+	if len(req.Params.Arguments) != 1 || json.Unmarshal(req.Params.Arguments[0], &params) != nil {
+		d.respondError(conn, req.ID, lsp.InvalidParams, "crush.executeLens expects one lens argument")
+		return true
+	}
+	d.relayCommandToCrush(conn, req.ID, "crush/executeLens", params, params.TextDocument.URI)
+	return true
+}
+
+// clearCodeLenses drops lenses for uri once its content changes.
+func (d *Daemon) clearCodeLenses(uri string) {
+	d.mu.Lock()
+	_, had := d.codeLenses[uri]
+	delete(d.codeLenses, uri)
+	d.mu.Unlock()
+
+	if had {
+		d.refreshCodeLenses()
+	}
+}
+
+// clearAllCodeLenses drops every published lens, e.g. when Crush disconnects.
+func (d *Daemon) clearAllCodeLenses() {
+	d.mu.Lock()
+	had := len(d.codeLenses) > 0
+	d.codeLenses = make(map[string][]lsp.AICodeLens)
+	d.mu.Unlock()
+
+	if had {
+		d.refreshCodeLenses()
+	}
+}
+
+// refreshCodeLenses asks Neovim to re-query code lenses, if it supports refresh.
+func (d *Daemon) refreshCodeLenses() {
+	if d.state.Capabilities().CodeLensRefresh {
+		d.requestNeovim("workspace/codeLens/refresh", nil)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// defaultCommandTimeout bounds how long a relayed workspace/executeCommand
+// may run before the sender gets an error. Agent commands can be slow, so
+// this is generous compared to interactive requests.
+const defaultCommandTimeout = 30 * time.Second
+
+// handleExecuteCommand validates a workspace/executeCommand and relays it to
+// the sender's peer, so Crush can invoke commands registered by Neovim
+// plugins or language servers and vice versa. AI lens and code action
+// commands from Neovim are handled by handleExecuteLens and
+// handleInvokeAction instead.
+func (d *Daemon) handleExecuteCommand(from string, content []byte, conn net.Conn) {
+	if from == "neovim" && (d.handleExecuteLens(content, conn) || d.handleInvokeAction(content, conn)) {
+		return
+	}
+
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Params struct {
+			Command   string          `json:"command"`
+			Arguments json.RawMessage `json:"arguments"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid executeCommand params: "+err.Error())
+		return
+	}
+	if req.Params.Command == "" {
+		d.respondError(conn, req.ID, lsp.InvalidParams, "executeCommand requires a command")
+		return
+	}
+	if args := bytes.TrimSpace(req.Params.Arguments); len(args) > 0 && args[0] != '[' && string(args) != "null" {
+		d.respondError(conn, req.ID, lsp.InvalidParams, "executeCommand arguments must be an array")
+		return
+	}
+
+	d.logger.Printf("Relaying command %s from %s", req.Params.Command, from)
+	d.relayWithDeadline(from, conn, "workspace/executeCommand", content, d.commandTimeout,
+		func(id json.RawMessage, reason string) {
+			d.respondError(conn, id, lsp.RequestFailed, req.Params.Command+": "+reason)
+		}, nil)
+}
+
+// relayCommandToCrush sends Crush a method request for a daemon command
+// Neovim executed, under Neovim's request ID, so Crush's response is relayed
+// back as the command's result.
+func (d *Daemon) relayCommandToCrush(conn net.Conn, id json.RawMessage, method string, params any, uri string) {
+	d.mu.RLock()
+	crush := d.clients[d.agentForLocked()]
+	d.mu.RUnlock()
+
+	if crush == nil {
+		d.respondError(conn, id, lsp.RequestFailed, "Crush is not connected")
+		return
+	}
+
+	request, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		d.respondError(conn, id, lsp.InternalError, err.Error())
+		return
+	}
+
+	msg, relayID := d.relayRequest("neovim", method, request, nil)
+	if msg == nil {
+		d.respondError(conn, id, lsp.InternalError, "failed to relay "+method)
+		return
+	}
+	d.watchRelayed(relayID)
+
+	if _, err := crush.Write(msg); err != nil {
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeForward,
+			Message: fmt.Sprintf("failed to forward to crush: %v", err),
+			Method:  method,
+			URI:     uri,
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// confirmTimeout bounds how long the user has to answer a confirmation
+	// in Neovim.
+	confirmTimeout = 2 * time.Minute
+	// gitTimeout bounds each git command, commit hooks included.
+	gitTimeout = time.Minute
+)
+
+// confirm asks the user in Neovim whether to go ahead, with a
+// window/showMessageRequest offering action and Cancel.
+func (d *Daemon) confirm(ctx context.Context, message, action string) (bool, error) {
+	raw, err := d.call(ctx, "neovim", "window/showMessageRequest", lsp.ShowMessageRequestParams{
+		Type:    lsp.MessageTypeInfo,
+		Message: message,
+		Actions: []lsp.MessageActionItem{{Title: action}, {Title: "Cancel"}},
+	}, confirmTimeout)
+	if err != nil {
+		return false, err
+	}
+	var choice *lsp.MessageActionItem
+	if err := json.Unmarshal(raw, &choice); err != nil {
+		return false, err
+	}
+	return choice != nil && choice.Title == action, nil
+}
+
+// git runs git in the workspace root and returns its output, trimmed.
+func (d *Daemon) git(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = d.workspace
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// editedFiles returns the workspace files agents edited in the session, by
+// path relative to the workspace root, with their URIs.
+func (d *Daemon) editedFiles() map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	files := make(map[string]string, len(d.baselines))
+	for uri := range d.baselines {
+		path, err := uriToPath(uri)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(d.workspace, path); err == nil && filepath.IsLocal(rel) {
+			files[filepath.ToSlash(rel)] = uri
+		}
+	}
+	return files
+}
+
+// handleStageFiles answers crush/stageFiles: files agents edited are staged
+// with git add once the user confirms.
+func (d *Daemon) handleStageFiles(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.StageFilesParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid stageFiles params: "+err.Error())
+		return
+	}
+
+	edited := d.editedFiles()
+	paths := slices.Sorted(maps.Keys(edited))
+	if len(req.Params.Paths) > 0 {
+		paths = paths[:0]
+		for _, p := range req.Params.Paths {
+			if filepath.IsAbs(p) {
+				if rel, err := filepath.Rel(d.workspace, p); err == nil {
+					p = rel
+				}
+			}
+			p = filepath.ToSlash(filepath.Clean(p))
+			if _, ok := edited[p]; !ok {
+				d.respondError(conn, messageID(content), lsp.InvalidParams, p+" was not edited by an agent in this session")
+				return
+			}
+			paths = append(paths, p)
+		}
+		slices.Sort(paths)
+		paths = slices.Compact(paths)
+	}
+	if len(paths) == 0 {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, "agents have not edited any files in this session")
+		return
+	}
+
+	// git add takes the file on disk, so it must be what the user sees
+	ctx := d.requestContext(conn, content)
+	var unsaved []string
+	for _, p := range paths {
+		uri := edited[p]
+		d.mu.RLock()
+		open := d.neovimOpenDocs[uri]
+		d.mu.RUnlock()
+		if !open {
+			continue
+		}
+		saved, _, err := readText(filepath.Join(d.workspace, filepath.FromSlash(p)))
+		if current := d.documentContent(ctx, "neovim", uri); current != nil && (err != nil || *current != saved) {
+			unsaved = append(unsaved, p)
+		}
+	}
+	if len(unsaved) > 0 {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, "save "+strings.Join(unsaved, ", ")+" in Neovim first")
+		return
+	}
+
+	result := lsp.StageFilesResult{Staged: []string{}}
+	ok, err := d.confirm(ctx, fmt.Sprintf("Stage %d files edited by the agent?\n%s", len(paths), strings.Join(paths, "\n")), "Stage")
+	if err != nil {
+		d.respondError(conn, messageID(content), failureCode(err, lsp.RequestFailed), "confirmation failed: "+err.Error())
+		return
+	}
+	if !ok {
+		d.logger.Printf("User declined staging %d files", len(paths))
+		d.respondResult(conn, messageID(content), result)
+		return
+	}
+	if _, err := d.git(ctx, append([]string{"add", "--"}, paths...)...); err != nil {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+		return
+	}
+	d.logger.Printf("Staged %s", strings.Join(paths, " "))
+	result.Confirmed = true
+	result.Staged = paths
+	d.respondResult(conn, messageID(content), result)
+}
+
+// handleCommit answers crush/commit: the staged changes are committed with
+// the agent's message once the user confirms.
+func (d *Daemon) handleCommit(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.CommitParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid commit params: "+err.Error())
+		return
+	}
+	message := strings.TrimSpace(req.Params.Message)
+	if message == "" {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "message is required")
+		return
+	}
+
+	ctx := d.requestContext(conn, content)
+	staged, err := d.git(ctx, "diff", "--cached", "--name-only")
+	if err != nil {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+		return
+	}
+	if staged == "" {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, "nothing is staged")
+		return
+	}
+	result := lsp.CommitResult{Files: strings.Split(staged, "\n")}
+
+	ok, err := d.confirm(ctx, fmt.Sprintf("Commit %d files?\n\n%s\n\n%s", len(result.Files), message, staged), "Commit")
+	if err != nil {
+		d.respondError(conn, messageID(content), failureCode(err, lsp.RequestFailed), "confirmation failed: "+err.Error())
+		return
+	}
+	if !ok {
+		d.logger.Printf("User declined the commit")
+		d.respondResult(conn, messageID(content), result)
+		return
+	}
+	if _, err := d.git(ctx, "commit", "--message", message); err != nil {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+		return
+	}
+	hash, err := d.git(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+		return
+	}
+	d.logger.Printf("Committed %s (%d files)", hash, len(result.Files))
+	result.Confirmed = true
+	result.Commit = hash
+	d.respondResult(conn, messageID(content), result)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// completionCacheTTL is how long Crush's completions are reused for
+	// repeated requests at the same position.
+	completionCacheTTL = 10 * time.Second
+	// completionCacheSize caps the number of cached completion results.
+	completionCacheSize = 64
+)
+
+// completionEntry is a cached completion result.
+type completionEntry struct {
+	result json.RawMessage
+	at     time.Time
+}
+
+// completionKey identifies a completion request by document, position, and
+// trigger character.
+func completionKey(params lsp.CompletionParams) string {
+	trigger := ""
+	if params.Context != nil {
+		trigger = params.Context.TriggerCharacter
+	}
+	return fmt.Sprintf("%s:%d:%d:%s", params.TextDocument.URI,
+		params.Position.Line, params.Position.Character, trigger)
+}
+
+// handleCompletion answers Neovim's textDocument/completion with AI
+// suggestions from Crush. Cached results are returned immediately; otherwise
+// Crush gets the completion budget to answer, after which Neovim receives an
+// empty incomplete list so typing is never blocked. Late answers still fill
+// the cache.
+func (d *Daemon) handleCompletion(content []byte, conn net.Conn) {
+	var req struct {
+		ID     json.RawMessage      `json:"id"`
+		Params lsp.CompletionParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid completion params: "+err.Error())
+		return
+	}
+
+	key := completionKey(req.Params)
+	if result, ok := d.cachedCompletion(key); ok {
+		d.respondResult(conn, req.ID, result)
+		return
+	}
+
+	d.relayWithDeadline("neovim", conn, "textDocument/completion", content, d.completion.Timeout(),
+		func(id json.RawMessage, reason string) {
+			d.logger.Printf("Completion: %s, returning no items", reason)
+			d.respondResult(conn, id, lsp.CompletionList{IsIncomplete: true, Items: []lsp.CompletionItem{}})
+		},
+		func(resp []byte) {
+			d.cacheCompletion(key, resp)
+		})
+}
+
+// cachedCompletion returns a fresh cached result for key.
+func (d *Daemon) cachedCompletion(key string) (json.RawMessage, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	entry, ok := d.completionCache[key]
+	if !ok || time.Since(entry.at) > completionCacheTTL {
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// cacheCompletion stores a successful, non-empty completion response,
+// evicting expired and then oldest entries to stay within the cache size.
+func (d *Daemon) cacheCompletion(key string, content []byte) {
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(content, &resp); err != nil || resp.Error != nil ||
+		len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range d.completionCache {
+		if now.Sub(entry.at) > completionCacheTTL {
+			delete(d.completionCache, k)
+		}
+	}
+	for len(d.completionCache) >= completionCacheSize {
+		oldest := ""
+		for k, entry := range d.completionCache {
+			if oldest == "" || entry.at.Before(d.completionCache[oldest].at) {
+				oldest = k
+			}
+		}
+		delete(d.completionCache, oldest)
+	}
+
+	d.completionCache[key] = completionEntry{result: resp.Result, at: now}
+}
+
+// clearCompletions drops cached completions for uri once its content changes.
+func (d *Daemon) clearCompletions(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key := range d.completionCache {
+		if strings.HasPrefix(key, uri+":") {
+			delete(d.completionCache, key)
+		}
+	}
+}
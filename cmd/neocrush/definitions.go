@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// maxDefinitionHops bounds how many symbols around the cursor are
+	// resolved: the one under it, then enclosing calls outward.
+	maxDefinitionHops = 2
+	// maxDefinitionLines bounds the source included per definition.
+	maxDefinitionLines = 60
+	// definitionTimeout bounds each crush/definition lookup.
+	definitionTimeout = 2 * time.Second
+)
+
+// symbolRef is an identifier on the cursor line.
+type symbolRef struct {
+	name string
+	col  int // Byte offset of its first character
+}
+
+// referencedSymbols returns the symbols worth resolving at col: the
+// identifier under the cursor, then the callee of each call whose
+// arguments enclose it, innermost first.
+func referencedSymbols(line string, col int) []symbolRef {
+	col = min(max(col, 0), len(line))
+
+	var refs []symbolRef
+	seen := map[int]bool{}
+	add := func(start, end int) {
+		if start < end && !seen[start] {
+			seen[start] = true
+			refs = append(refs, symbolRef{name: line[start:end], col: start})
+		}
+	}
+
+	add(identifierAt(line, col))
+
+	depth := 0
+	for i := col - 1; i >= 0; i-- {
+		switch line[i] {
+		case ')', ']':
+			depth++
+		case '[':
+			depth--
+		case '(':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			end := i
+			for end > 0 && line[end-1] == ' ' {
+				end--
+			}
+			add(identifierAt(line, end))
+		}
+	}
+	return refs
+}
+
+// identifierAt returns the bounds of the identifier containing or ending
+// at col, or an empty range.
+func identifierAt(line string, col int) (int, int) {
+	start, end := col, col
+	for start > 0 && isIdentByte(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isIdentByte(line[end]) {
+		end++
+	}
+	if start < end && line[start] >= '0' && line[start] <= '9' {
+		return 0, 0 // A number, not a name
+	}
+	return start, end
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// resolveDefinitions asks Neovim where the symbols at the cursor are
+// defined and returns their source, skipping definitions that are the
+// cursor line itself.
+func (d *Daemon) resolveDefinitions(ctx context.Context, uri, lineText string, line, col int) []lsp.DefinitionContext {
+	var defs []lsp.DefinitionContext
+	for _, ref := range referencedSymbols(lineText, col) {
+		if len(defs) >= maxDefinitionHops {
+			break
+		}
+
+		params := lsp.DefinitionParams{TextDocumentPositionParams: lsp.TextDocumentPositionParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+			Position:     lsp.Position{Line: line, Character: ref.col},
+		}}
+		raw, err := d.call(ctx, "neovim", "crush/definition", params, definitionTimeout)
+		if err != nil {
+			d.logger.Printf("Definition of %s: %v", ref.name, err)
+			break // Neovim can't help; don't pay the timeout again
+		}
+
+		target, targetRange, ok := firstLocation(raw)
+		if !ok || (target == uri && targetRange.Start.Line == line) {
+			continue
+		}
+		text, truncated := d.definitionSource(target, targetRange)
+		if text == "" {
+			continue
+		}
+		defs = append(defs, lsp.DefinitionContext{
+			Symbol:    ref.name,
+			URI:       target,
+			StartLine: targetRange.Start.Line,
+			Text:      text,
+			Truncated: truncated,
+		})
+	}
+	return defs
+}
+
+// firstLocation extracts the first target of a definition result, which
+// may be a Location, a Location list, or a LocationLink list.
+func firstLocation(raw json.RawMessage) (string, lsp.Range, bool) {
+	var single lsp.Location
+	if json.Unmarshal(raw, &single) == nil && single.URI != "" {
+		return single.URI, single.Range, true
+	}
+
+	var links []struct {
+		lsp.Location
+		lsp.LocationLink
+	}
+	if json.Unmarshal(raw, &links) != nil || len(links) == 0 {
+		return "", lsp.Range{}, false
+	}
+	if link := links[0].LocationLink; link.TargetURI != "" {
+		return link.TargetURI, link.TargetRange, true
+	}
+	if loc := links[0].Location; loc.URI != "" {
+		return loc.URI, loc.Range, true
+	}
+	return "", lsp.Range{}, false
+}
+
+// definitionSource returns the source of the definition starting at r: the
+// whole range when it spans lines (LocationLink targets), otherwise from its
+// first line until braces balance or a blank line ends the declaration.
+// At most maxDefinitionLines are returned.
+func (d *Daemon) definitionSource(uri string, r lsp.Range) (string, bool) {
+	d.mu.RLock()
+	text, ok := d.documentState[uri]
+	d.mu.RUnlock()
+	if !ok {
+		path, err := uriToPath(uri)
+		if err != nil {
+			return "", false
+		}
+		if text, _, err = readText(path); err != nil {
+			return "", false
+		}
+	}
+
+	lines := strings.Split(text, "\n")
+	if r.Start.Line < 0 || r.Start.Line >= len(lines) {
+		return "", false
+	}
+
+	end := r.Start.Line // Inclusive
+	if r.End.Line > r.Start.Line {
+		end = r.End.Line
+	} else {
+		depth, opened := 0, false
+		for i := r.Start.Line; i < len(lines) && i <= r.Start.Line+maxDefinitionLines; i++ {
+			if !opened && i > r.Start.Line && strings.TrimSpace(lines[i]) == "" {
+				break
+			}
+			end = i
+			depth += strings.Count(lines[i], "{") + strings.Count(lines[i], "(")
+			depth -= strings.Count(lines[i], "}") + strings.Count(lines[i], ")")
+			opened = opened || strings.ContainsAny(lines[i], "{(")
+			if opened && depth <= 0 {
+				break
+			}
+		}
+	}
+	end = min(end, len(lines)-1)
+
+	truncated := false
+	if end-r.Start.Line+1 > maxDefinitionLines {
+		end = r.Start.Line + maxDefinitionLines - 1
+		truncated = true
+	}
+	return strings.Join(lines[r.Start.Line:end+1], "\n"), truncated
+}
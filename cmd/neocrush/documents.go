@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// documentMatchers compiles the files of each documents rule.
+func documentMatchers(rules []config.DocumentRule) []*workspace.Matcher {
+	matchers := make([]*workspace.Matcher, len(rules))
+	for i, rule := range rules {
+		var m workspace.Matcher
+		m.Add("", rule.Files)
+		matchers[i] = &m
+	}
+	return matchers
+}
+
+// documentPolicy returns the documents policy for a didOpen/didClose of
+// uri sent by from.
+func (d *Daemon) documentPolicy(from, uri string) string {
+	rel, ok := d.workspaceRel(uri)
+	return d.documents.Policy(from, func(i int) bool {
+		return ok && d.documentRules[i].Matches(rel)
+	})
+}
+
+// workspaceRel returns uri's slash-separated path relative to the
+// workspace folder it is in.
+func (d *Daemon) workspaceRel(uri string) (string, bool) {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return "", false
+	}
+	for _, root := range d.roots() {
+		if root == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, filepath.Clean(path))
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel), true
+		}
+	}
+	return "", false
+}
+
+// applyDocumentPolicy returns the didOpen/didClose msg from `from` as the
+// documents policy says it reaches the peer: unchanged, reduced to a
+// crush/documentOpened or crush/documentClosed, or nil if dropped.
+func (d *Daemon) applyDocumentPolicy(from, method string, msg, content []byte) []byte {
+	var notif struct {
+		Params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(content, &notif); err != nil {
+		return nil
+	}
+	uri := notif.Params.TextDocument.URI
+
+	switch d.documentPolicy(from, uri) {
+	case config.PolicyMirror:
+		return msg
+	case config.PolicyNotify:
+		notifyMethod := "crush/documentOpened"
+		if method == "textDocument/didClose" {
+			notifyMethod = "crush/documentClosed"
+		}
+		return []byte(rpc.EncodeMessage(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  notifyMethod,
+			"params":  lsp.DocumentNotifyParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}},
+		}))
+	default:
+		return nil
+	}
+}
+
+// transformNeovimToCrush applies the documents policy to Neovim's
+// didOpen/didClose. Returns nil if the message should not be forwarded.
+func (d *Daemon) transformNeovimToCrush(msg []byte) []byte {
+	method, content, err := rpc.DecodeMessage(msg)
+	if err != nil {
+		return msg // Pass through if we can't decode
+	}
+
+	switch method {
+	case "textDocument/didOpen", "textDocument/didClose":
+		return d.applyDocumentPolicy("neovim", method, msg, content)
+	default:
+		return msg
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleEditFile answers crush/editFile, applying range edits to a file
+// through Neovim so they land in its buffer and undo history.
+func (d *Daemon) handleEditFile(from string, content []byte, conn net.Conn) {
+	id := messageID(content)
+
+	var req struct {
+		Params lsp.EditFileParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, id, lsp.InvalidParams, "invalid editFile params: "+err.Error())
+		return
+	}
+
+	result, code, err := d.editFile(d.requestContext(conn, content), from, req.Params)
+	code = failureCode(err, code)
+	if data := editErrorData(err); data != nil {
+		d.respondErrorData(conn, id, code, err.Error(), data)
+		return
+	}
+	if err != nil {
+		d.respondError(conn, id, code, err.Error())
+		return
+	}
+	d.respondResult(conn, id, result)
+}
+
+// editFile applies params to Neovim's buffer of the document, loading it
+// from disk if Neovim doesn't have it open. A non-zero version must match
+// the version Neovim last reported. On failure it also returns the
+// JSON-RPC error code to answer with.
+func (d *Daemon) editFile(ctx context.Context, from string, params lsp.EditFileParams) (lsp.EditFileResult, int, error) {
+	var result lsp.EditFileResult
+	path := params.Path
+	if path == "" {
+		var err error
+		if path, err = uriToPath(params.TextDocument.URI); err != nil {
+			return result, lsp.InvalidParams, err
+		}
+	}
+	path, err := d.resolveWorkspacePath(path)
+	if err != nil {
+		return result, lsp.InvalidParams, err
+	}
+	uri := "file://" + path
+	if len(params.Edits) == 0 {
+		return result, lsp.InvalidParams, errors.New("no edits given")
+	}
+	if !d.state.Capabilities().ApplyEdit {
+		return result, lsp.RequestFailed, errors.New("neovim does not support workspace/applyEdit")
+	}
+	if version := params.TextDocument.Version; version != 0 {
+		if doc := d.state.GetDocument(uri); doc != nil && doc.Version != version {
+			return result, lsp.ContentModified, fmt.Errorf("%s is at version %d, not %d", extractFilename(uri), doc.Version, version)
+		}
+	}
+
+	d.mu.RLock()
+	neovimHasFile := d.neovimOpenDocs[uri]
+	d.mu.RUnlock()
+	var current *string
+	if neovimHasFile {
+		current = d.documentContent(ctx, "neovim", uri)
+	}
+	if current == nil {
+		text, _, err := readText(path)
+		if err != nil {
+			return result, lsp.RequestFailed, err
+		}
+		current = &text
+	}
+
+	ranged := make([]rangeEdit, 0, len(params.Edits))
+	for _, edit := range params.Edits {
+		if positionBefore(edit.Range.End, edit.Range.Start) {
+			return result, lsp.InvalidParams, fmt.Errorf("range %d:%d-%d:%d ends before it starts", edit.Range.Start.Line, edit.Range.Start.Character, edit.Range.End.Line, edit.Range.End.Character)
+		}
+		ranged = append(ranged, rangeEdit{r: edit.Range, newText: edit.NewText})
+	}
+	if err := checkOverlap(ranged); err != nil {
+		return result, lsp.InvalidParams, err
+	}
+
+	edits := make([]map[string]any, 0, len(ranged))
+	for _, e := range ranged {
+		edits = append(edits, map[string]any{
+			"range": map[string]any{
+				"start": map[string]any{"line": e.r.Start.Line, "character": e.r.Start.Character},
+				"end":   map[string]any{"line": e.r.End.Line, "character": e.r.End.Character},
+			},
+			"newText": e.newText,
+		})
+	}
+	if err := d.checkEdit(from, "crush/editFile", documentEditStats(uri, edits)); err != nil {
+		return result, lsp.RequestFailed, err
+	}
+
+	updated := applyRangeEdits(*current, ranged)
+	raw, err := d.call(ctx, "neovim", "workspace/applyEdit", d.withFoldHint(map[string]any{
+		"label":     "Crush edit",
+		"edit":      d.workspaceEdit(uri, edits),
+		"editGroup": d.beginEditGroup("Edit "+extractFilename(uri), []string{uri}),
+	}, uri, *current, updated), d.commandTimeout)
+	if err != nil {
+		return result, lsp.RequestFailed, err
+	}
+	var applied struct {
+		Applied       bool   `json:"applied"`
+		FailureReason string `json:"failureReason"`
+	}
+	if err := json.Unmarshal(raw, &applied); err != nil {
+		return result, lsp.RequestFailed, err
+	}
+	result.Applied = applied.Applied
+	if !applied.Applied {
+		d.logger.Printf("Neovim rejected edit to %s: %s", uri, applied.FailureReason)
+		result.Error = applied.FailureReason
+		return result, 0, nil
+	}
+
+	d.mu.Lock()
+	d.documentState[uri] = updated
+	d.mu.Unlock()
+	d.state.Touch()
+	d.fixEdited(uri)
+	d.documentReplaced(uri)
+	d.recordChange(from, uri, *current, updated)
+	d.scheduleVerify(uri)
+	return result, 0, nil
+}
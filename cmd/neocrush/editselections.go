@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleEditSelections answers crush/editSelections, replacing the text of
+// Neovim's selections (or cursors) in one undoable edit.
+func (d *Daemon) handleEditSelections(from string, content []byte, conn net.Conn) {
+	id := messageID(content)
+
+	var req struct {
+		Params lsp.EditSelectionsParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, id, lsp.InvalidParams, "invalid editSelections params: "+err.Error())
+		return
+	}
+
+	result, code, err := d.editSelections(d.requestContext(conn, content), from, req.Params)
+	code = failureCode(err, code)
+	if data := editErrorData(err); data != nil {
+		d.respondErrorData(conn, id, code, err.Error(), data)
+		return
+	}
+	if err != nil {
+		d.respondError(conn, id, code, err.Error())
+		return
+	}
+	d.respondResult(conn, id, result)
+}
+
+// selectionTargets returns the ranges crush/editSelections indexes into:
+// the selections, or else the cursors as empty ranges. Callers hold d.mu.
+func (d *Daemon) selectionTargets() []lsp.Range {
+	if len(d.selections) > 0 {
+		return d.selections
+	}
+	if d.cursorURI == "" {
+		return nil
+	}
+	positions := append([]lsp.Position{{Line: d.cursorLine, Character: d.cursorColumn}}, d.cursors...)
+	targets := make([]lsp.Range, 0, len(positions))
+	for _, p := range positions {
+		targets = append(targets, lsp.Range{Start: p, End: p})
+	}
+	return targets
+}
+
+// editSelections maps params onto the current selections and applies them
+// to Neovim's buffer. On failure it also returns the JSON-RPC error code to
+// answer with.
+func (d *Daemon) editSelections(ctx context.Context, from string, params lsp.EditSelectionsParams) (lsp.EditSelectionsResult, int, error) {
+	d.mu.RLock()
+	uri := d.cursorURI
+	targets := d.selectionTargets()
+	d.mu.RUnlock()
+
+	result := lsp.EditSelectionsResult{URI: uri}
+	if len(targets) == 0 {
+		return result, lsp.RequestFailed, errors.New("neovim has not reported a cursor or selection")
+	}
+	if len(params.Edits) == 0 {
+		return result, lsp.InvalidParams, errors.New("no edits given")
+	}
+	if !d.state.Capabilities().ApplyEdit {
+		return result, lsp.RequestFailed, errors.New("neovim does not support workspace/applyEdit")
+	}
+
+	current := d.documentContent(ctx, "neovim", uri)
+	if current == nil {
+		d.mu.RLock()
+		text, ok := d.documentState[uri]
+		d.mu.RUnlock()
+		if !ok {
+			return result, lsp.RequestFailed, errors.New("could not read Neovim's buffer")
+		}
+		current = &text
+	}
+
+	ranged := make([]rangeEdit, 0, len(params.Edits))
+	seen := make(map[int]bool)
+	for _, edit := range params.Edits {
+		if edit.Index < 0 || edit.Index >= len(targets) {
+			return result, lsp.InvalidParams, fmt.Errorf("selection %d does not exist (%d selected)", edit.Index, len(targets))
+		}
+		if seen[edit.Index] {
+			return result, lsp.InvalidParams, fmt.Errorf("selection %d is edited twice", edit.Index)
+		}
+		seen[edit.Index] = true
+
+		r := targets[edit.Index]
+		if edit.OldText != nil && rangeText(*current, r) != *edit.OldText {
+			return result, lsp.RequestFailed, fmt.Errorf("selection %d has changed since it was read", edit.Index)
+		}
+		ranged = append(ranged, rangeEdit{r: r, newText: edit.NewText})
+	}
+	if err := checkOverlap(ranged); err != nil {
+		return result, lsp.InvalidParams, err
+	}
+
+	edits := make([]map[string]any, 0, len(ranged))
+	for _, e := range ranged {
+		edits = append(edits, map[string]any{
+			"range": map[string]any{
+				"start": map[string]any{"line": e.r.Start.Line, "character": e.r.Start.Character},
+				"end":   map[string]any{"line": e.r.End.Line, "character": e.r.End.Character},
+			},
+			"newText": e.newText,
+		})
+	}
+	if err := d.checkEdit(from, "crush/editSelections", documentEditStats(uri, edits)); err != nil {
+		return result, lsp.RequestFailed, err
+	}
+
+	raw, err := d.call(ctx, "neovim", "workspace/applyEdit", map[string]any{
+		"label":     "Crush edit",
+		"edit":      d.workspaceEdit(uri, edits),
+		"editGroup": d.beginEditGroup("Edit selections in "+extractFilename(uri), []string{uri}),
+	}, d.commandTimeout)
+	if err != nil {
+		return result, lsp.RequestFailed, err
+	}
+	var applied struct {
+		Applied       bool   `json:"applied"`
+		FailureReason string `json:"failureReason"`
+	}
+	if err := json.Unmarshal(raw, &applied); err != nil {
+		return result, lsp.RequestFailed, err
+	}
+	result.Applied = applied.Applied
+	if !applied.Applied {
+		d.logger.Printf("Neovim rejected selection edit to %s: %s", uri, applied.FailureReason)
+		return result, 0, nil
+	}
+	result.Edits = len(ranged)
+
+	updated := applyRangeEdits(*current, ranged)
+	d.mu.Lock()
+	d.documentState[uri] = updated
+	d.mu.Unlock()
+	d.state.Touch()
+	d.fixEdited(uri)
+	d.documentReplaced(uri)
+	d.recordChange(from, uri, *current, updated)
+	d.scheduleVerify(uri)
+	return result, 0, nil
+}
+
+// checkOverlap rejects edits whose ranges overlap, which LSP leaves
+// undefined. It sorts edits by position.
+func checkOverlap(edits []rangeEdit) error {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return positionBefore(edits[i].r.Start, edits[j].r.Start)
+	})
+	for i := 1; i < len(edits); i++ {
+		prev, next := edits[i-1].r, edits[i].r
+		if positionBefore(next.Start, prev.End) {
+			return fmt.Errorf("edits at %d:%d and %d:%d overlap", prev.Start.Line, prev.Start.Character, next.Start.Line, next.Start.Character)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/history"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// probeTimeout bounds each tool's version command.
+const probeTimeout = 3 * time.Second
+
+// toolProbes are the tools crush/getEnvironment looks for, with the
+// arguments that print their version.
+var toolProbes = map[string][]string{
+	"go":      {"version"},
+	"node":    {"--version"},
+	"npm":     {"--version"},
+	"python3": {"--version"},
+	"rustc":   {"--version"},
+	"cargo":   {"--version"},
+	"git":     {"--version"},
+	"make":    {"--version"},
+	"nvim":    {"--version"},
+}
+
+// environmentVars are the variables crush/getEnvironment reports, those
+// that change how code is built and run.
+var environmentVars = []string{
+	"SHELL", "LANG", "LC_ALL", "TZ",
+	"GOPATH", "GOROOT", "GOFLAGS", "GOPROXY", "GOPRIVATE", "GOOS", "GOARCH", "CGO_ENABLED", "GOTOOLCHAIN",
+	"NODE_ENV", "NODE_OPTIONS",
+	"VIRTUAL_ENV", "CONDA_DEFAULT_ENV", "PYTHONPATH",
+	"CARGO_HOME", "RUSTUP_TOOLCHAIN",
+}
+
+// handleGetEnvironment answers crush/getEnvironment.
+func (d *Daemon) handleGetEnvironment(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.GetEnvironmentParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid getEnvironment params: "+err.Error())
+		return
+	}
+
+	d.mu.RLock()
+	env := d.environment
+	d.mu.RUnlock()
+	if env == nil || req.Params.Refresh {
+		env = d.collectEnvironment(d.requestContext(conn, content))
+		d.mu.Lock()
+		d.environment = env
+		d.mu.Unlock()
+	}
+	d.respondResult(conn, messageID(content), env)
+}
+
+// collectEnvironment probes the installed tools, in parallel, and reads
+// the environment variables.
+func (d *Daemon) collectEnvironment(ctx context.Context) *lsp.EnvironmentResult {
+	env := &lsp.EnvironmentResult{
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		Shell:       os.Getenv("SHELL"),
+		Tools:       []lsp.ToolVersion{},
+		Env:         make(map[string]string),
+		CollectedAt: time.Now(),
+	}
+
+	for _, name := range environmentVars {
+		if value, ok := os.LookupEnv(name); ok {
+			env.Env[name] = history.RedactString(name, hideHome(value))
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, args := range toolProbes {
+		wg.Go(func() {
+			if tool, ok := d.probeTool(ctx, name, args); ok {
+				mu.Lock()
+				env.Tools = append(env.Tools, tool)
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	slices.SortFunc(env.Tools, func(a, b lsp.ToolVersion) int { return cmp.Compare(a.Name, b.Name) })
+	return env
+}
+
+// hideHome shows the home directory in s as ~.
+func hideHome(s string) string {
+	home, _ := os.UserHomeDir()
+	if home == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, home, "~")
+}
+
+// probeTool runs a tool's version command in the workspace root, so
+// per-project toolchain selection applies, and returns the first line of
+// its output.
+func (d *Daemon) probeTool(ctx context.Context, name string, args []string) (lsp.ToolVersion, bool) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return lsp.ToolVersion{}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = d.workspace
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		d.logger.Printf("Failed to get the %s version: %v", name, err)
+		return lsp.ToolVersion{}, false
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return lsp.ToolVersion{Name: name, Version: strings.TrimSpace(line), Path: hideHome(path)}, true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// reportError logs a routing failure and surfaces it to the user: Neovim
+// gets a window/showMessage, every agent a crush/error with a
+// machine-readable code. Failures while reporting are only logged.
+func (d *Daemon) reportError(params lsp.ErrorParams) {
+	d.logger.Printf("Error [%s] %s: %s", params.Code, params.Method, params.Message)
+
+	d.mu.RLock()
+	neovim := d.clients["neovim"]
+	d.mu.RUnlock()
+
+	if neovim != nil {
+		msg := lsp.ShowMessageNotification{
+			Notification: lsp.Notification{
+				RPC:    "2.0",
+				Method: "window/showMessage",
+			},
+			Params: lsp.ShowMessageParams{
+				Type:    lsp.MessageTypeError,
+				Message: "neocrush: " + params.Message,
+			},
+		}
+		if _, err := neovim.Write([]byte(rpc.EncodeMessage(msg))); err != nil {
+			d.logger.Printf("Failed to report error to neovim: %v", err)
+		}
+	}
+
+	msg := lsp.ErrorNotification{
+		Notification: lsp.Notification{
+			RPC:    "2.0",
+			Method: "crush/error",
+		},
+		Params: params,
+	}
+	for name, crush := range d.agentConns() {
+		if _, err := crush.Write([]byte(rpc.EncodeMessage(msg))); err != nil {
+			d.logger.Printf("Failed to report error to %s: %v", name, err)
+		}
+	}
+}
+
+// respondError answers a request with a JSON-RPC error.
+func (d *Daemon) respondError(conn net.Conn, id json.RawMessage, code int, message string) {
+	d.respondErrorData(conn, id, code, message, nil)
+}
+
+// respondErrorData answers a request with a JSON-RPC error carrying
+// structured data.
+func (d *Daemon) respondErrorData(conn net.Conn, id json.RawMessage, code int, message string, data any) {
+	response := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error": lsp.ResponseError{
+			Code:    code,
+			Message: message,
+			Data:    data,
+		},
+	}
+	if _, err := conn.Write([]byte(rpc.EncodeMessage(response))); err != nil {
+		d.logger.Printf("Failed to send error response: %v", err)
+	}
+	d.endSpan(conn, id, message)
+	d.inflight.finish(conn, id)
+}
+
+// respondResult answers a request with a successful result.
+func (d *Daemon) respondResult(conn net.Conn, id json.RawMessage, result any) {
+	response := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"result":  result,
+	}
+	if _, err := conn.Write([]byte(rpc.EncodeMessage(response))); err != nil {
+		d.logger.Printf("Failed to send response: %v", err)
+	}
+	d.endSpan(conn, id, "")
+	d.inflight.finish(conn, id)
+}
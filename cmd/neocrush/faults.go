@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// chaosClientName identifies `neocrush chaos` to the daemon.
+const chaosClientName = "chaos"
+
+// fault is what is done to one frame on its way to a client.
+type fault struct {
+	drop           bool
+	delay          time.Duration
+	truncate       int // Bytes sent before the connection is closed (0 = all)
+	bytesPerSecond int // Throttled rate (0 = unthrottled)
+}
+
+// faultInjector decides the faults injected into sends, as configured with
+// crush/setFaults. It is safe for concurrent use.
+type faultInjector struct {
+	active atomic.Bool // Checked on every send, so idle injection costs nothing
+
+	mu     sync.Mutex
+	params lsp.SetFaultsParams
+	rand   *rand.Rand
+	counts lsp.SetFaultsResult // Injected under params
+}
+
+// set replaces the faults, returning the counts of the replaced ones.
+func (f *faultInjector) set(params lsp.SetFaultsParams) lsp.SetFaultsResult {
+	seed := uint64(params.Seed)
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	replaced := f.counts
+	f.params = params
+	f.rand = rand.New(rand.NewPCG(seed, 0))
+	f.counts = lsp.SetFaultsResult{}
+
+	replaced.Active = params.DelayMS > 0 || params.JitterMS > 0 || params.DropRate > 0 ||
+		params.TruncateRate > 0 || params.BytesPerSecond > 0
+	f.active.Store(replaced.Active)
+	return replaced
+}
+
+// decide picks the faults for msg on its way to client. Only notifications
+// are dropped, so no request waits forever on a lost response; neocrush's
+// own commands are spared.
+func (f *faultInjector) decide(client string, msg []byte) fault {
+	if !f.active.Load() || toolClients[client] {
+		return fault{}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := f.params
+	if len(p.Clients) > 0 && !slices.Contains(p.Clients, clientType(client)) {
+		return fault{}
+	}
+
+	var ft fault
+	if p.DropRate > 0 {
+		if method, content, err := rpc.DecodeMessage(msg); err == nil && method != "" && messageID(content) == nil && f.rand.Float64() < p.DropRate {
+			f.counts.Dropped++
+			return fault{drop: true}
+		}
+	}
+	if p.DelayMS > 0 || p.JitterMS > 0 {
+		ft.delay = time.Duration(p.DelayMS) * time.Millisecond
+		if p.JitterMS > 0 {
+			ft.delay += time.Duration(f.rand.IntN(p.JitterMS+1)) * time.Millisecond
+		}
+		f.counts.Delayed++
+	}
+	if p.TruncateRate > 0 && len(msg) > 1 && f.rand.Float64() < p.TruncateRate {
+		ft.truncate = 1 + f.rand.IntN(len(msg)-1)
+		f.counts.Truncated++
+	}
+	if p.BytesPerSecond > 0 {
+		ft.bytesPerSecond = p.BytesPerSecond
+		f.counts.Throttled++
+	}
+	return ft
+}
+
+// faultFor decides the faults for a frame the outbox o is about to send.
+func (d *Daemon) faultFor(o *outbox, msg []byte) fault {
+	if !d.faults.active.Load() {
+		return fault{}
+	}
+	return d.faults.decide(d.clientNameOf(o), msg)
+}
+
+// handleSetFaults answers crush/setFaults, if debug.faults allows it.
+func (d *Daemon) handleSetFaults(content []byte, conn net.Conn) {
+	if !d.faultsEnabled {
+		d.respondError(conn, messageID(content), lsp.InvalidRequest, `fault injection is disabled; set "debug": {"faults": true} in the config`)
+		return
+	}
+	var req struct {
+		Params lsp.SetFaultsParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid setFaults params: "+err.Error())
+		return
+	}
+
+	result := d.faults.set(req.Params)
+	if result.Active {
+		d.logger.Printf("Injecting faults: %s", describeFaults(req.Params))
+	} else {
+		d.logger.Printf("Fault injection off")
+	}
+	d.respondResult(conn, messageID(content), result)
+}
+
+// describeFaults summarizes params for logs and the chaos command.
+func describeFaults(p lsp.SetFaultsParams) string {
+	var parts []string
+	if p.DelayMS > 0 || p.JitterMS > 0 {
+		parts = append(parts, fmt.Sprintf("delay %dms+%dms", p.DelayMS, p.JitterMS))
+	}
+	if p.DropRate > 0 {
+		parts = append(parts, fmt.Sprintf("drop %g", p.DropRate))
+	}
+	if p.TruncateRate > 0 {
+		parts = append(parts, fmt.Sprintf("truncate %g", p.TruncateRate))
+	}
+	if p.BytesPerSecond > 0 {
+		parts = append(parts, fmt.Sprintf("throttle %d B/s", p.BytesPerSecond))
+	}
+	to := "all clients"
+	if len(p.Clients) > 0 {
+		to = strings.Join(p.Clients, ", ")
+	}
+	return strings.Join(parts, ", ") + " to " + to
+}
+
+func newChaosCmd() *cobra.Command {
+	var (
+		params lsp.SetFaultsParams
+		delay  time.Duration
+		jitter time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "chaos",
+		Short: "Inject faults into the running daemon's traffic",
+		Long: `Makes the running daemon misbehave the way real connections do, to check
+that Neovim, Crush and other clients recover: messages are delayed, dropped
+(notifications only), cut short with the connection closed, or sent at a
+trickle. Each run replaces the previous faults; without fault flags it turns
+injection off. The faults injected under the replaced settings are counted.
+
+The daemon only accepts this with "debug": {"faults": true} in the config.`,
+		Example: `  neocrush chaos --delay 200ms --jitter 300ms --clients crush
+  neocrush chaos --drop 0.1 --truncate 0.01 --seed 42
+  neocrush chaos --slow 2048
+  neocrush chaos`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			sess, err := session.NewManager().LoadSessionMetadata(cwd)
+			if err != nil {
+				return fmt.Errorf("no neocrush session for %s", cwd)
+			}
+
+			params.DelayMS = int(delay.Milliseconds())
+			params.JitterMS = int(jitter.Milliseconds())
+			c, err := connectAs(sess.SocketPath, cwd, chaosClientName)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			raw, err := c.Request("crush/setFaults", params)
+			if err != nil {
+				return err
+			}
+			var result lsp.SetFaultsResult
+			if err := json.Unmarshal(raw, &result); err != nil {
+				return fmt.Errorf("failed to parse result: %w", err)
+			}
+
+			out := cmd.OutOrStdout()
+			if result.Active {
+				fmt.Fprintf(out, "Injecting faults: %s\n", describeFaults(params))
+			} else {
+				fmt.Fprintln(out, "Fault injection off")
+			}
+			fmt.Fprintf(out, "Previously injected: %d delayed, %d dropped, %d truncated, %d throttled\n",
+				result.Delayed, result.Dropped, result.Truncated, result.Throttled)
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&delay, "delay", 0, "Hold every message this long")
+	cmd.Flags().DurationVar(&jitter, "jitter", 0, "Plus a random extra delay of up to this long")
+	cmd.Flags().Float64Var(&params.DropRate, "drop", 0, "Fraction of notifications to drop")
+	cmd.Flags().Float64Var(&params.TruncateRate, "truncate", 0, "Fraction of messages to cut short, closing the connection")
+	cmd.Flags().IntVar(&params.BytesPerSecond, "slow", 0, "Send at most this many bytes per second")
+	cmd.Flags().StringSliceVar(&params.Clients, "clients", nil, "Client types to affect (neovim, crush, mcp); all if unset")
+	cmd.Flags().Int64Var(&params.Seed, "seed", 0, "Seed for reproducible runs")
+	return cmd
+}
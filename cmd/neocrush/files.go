@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// fileListTTL is how long a workspace walk is reused.
+	fileListTTL = 10 * time.Second
+	// maxListedFiles caps a workspace walk.
+	maxListedFiles = 20000
+)
+
+// fileList is a cached workspace walk.
+type fileList struct {
+	files     []workspace.File
+	truncated bool
+	listed    time.Time
+	lines     []int // Line counts of files, -1 if binary (nil = not counted yet)
+}
+
+// handleListFiles answers crush/listFiles with the files of every workspace
+// folder, filtered by .gitignore and the files.exclude config.
+func (d *Daemon) handleListFiles(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.ListFilesParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid listFiles params: "+err.Error())
+		return
+	}
+
+	if req.Params.Cursor != "" {
+		snapshot, id, offset, err := d.pages.load("crush/listFiles", req.Params.Cursor)
+		if err != nil {
+			d.respondError(conn, messageID(content), lsp.InvalidParams, err.Error())
+			return
+		}
+		d.respondResult(conn, messageID(content), listFilesPage(snapshot.(lsp.ListFilesResult), req.Params.PageParams, offset, func() int { return id }))
+		return
+	}
+
+	// Clean relative to the root so ".." can't escape it
+	prefix := strings.Trim(path.Clean("/"+filepath.ToSlash(req.Params.Path)), "/")
+
+	roots := d.roots()
+	result := lsp.ListFilesResult{
+		Root:    d.workspace,
+		Files:   []lsp.FileInfo{},
+		Folders: roots[1:],
+	}
+	for i, root := range roots {
+		list, err := d.workspaceFiles(root, req.Params.Refresh)
+		if err != nil {
+			d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+			return
+		}
+		result.Truncated = result.Truncated || list.truncated
+
+		var folder string
+		if i > 0 {
+			folder = root
+		}
+		for _, f := range list.files {
+			if prefix != "" && !strings.HasPrefix(f.Path, prefix+"/") {
+				continue
+			}
+			result.Files = append(result.Files, lsp.FileInfo{
+				Path:     f.Path,
+				Size:     f.Size,
+				Language: f.Language,
+				Root:     folder,
+			})
+		}
+	}
+	d.respondResult(conn, messageID(content), listFilesPage(result, req.Params.PageParams, 0, func() int {
+		return d.pages.save("crush/listFiles", result)
+	}))
+}
+
+// listFilesPage returns the page of result that params asks for, starting
+// at offset. snapshot returns the ID of result's page snapshot.
+func listFilesPage(result lsp.ListFilesResult, params lsp.PageParams, offset int, snapshot func() int) lsp.ListFilesResult {
+	start, end, next := paginate(params, len(result.Files), offset, snapshot)
+	result.Files = result.Files[start:end]
+	result.NextCursor = next
+	return result
+}
+
+// errOutsideWorkspace rejects paths that resolve outside every workspace folder.
+var errOutsideWorkspace = errors.New("path is outside the workspace")
+
+// resolveWorkspacePath returns the absolute form of p (relative to the
+// workspace root, or absolute) after checking that it stays inside the
+// root or another workspace folder, following symlinks. The file need not
+// exist.
+func (d *Daemon) resolveWorkspacePath(p string) (string, error) {
+	if d.workspace == "" {
+		return "", errors.New("workspace root is unknown")
+	}
+	if p == "" {
+		return "", errors.New("path is required")
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(d.workspace, p)
+	}
+	p = filepath.Clean(p)
+
+	real := evalExisting(p)
+	for _, root := range d.roots() {
+		root, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue // A removed folder
+		}
+		rel, err := filepath.Rel(root, real)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return p, nil
+		}
+	}
+	return "", errOutsideWorkspace
+}
+
+// evalExisting resolves symlinks in the longest existing prefix of p and
+// appends the rest unchanged.
+func evalExisting(p string) string {
+	var rest []string
+	for {
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(append([]string{real}, rest...)...)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(append([]string{p}, rest...)...)
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+		p = parent
+	}
+}
+
+// workspaceFiles returns the cached walk of a workspace folder, redoing it
+// when stale or when refresh is set.
+func (d *Daemon) workspaceFiles(root string, refresh bool) (*fileList, error) {
+	if root == "" {
+		return nil, errors.New("workspace root is unknown")
+	}
+
+	d.mu.RLock()
+	cached := d.fileLists[root]
+	d.mu.RUnlock()
+	if !refresh && cached != nil && time.Since(cached.listed) < fileListTTL {
+		return cached, nil
+	}
+
+	files, truncated, err := workspace.List(root, d.fileExcludes, maxListedFiles)
+	if err != nil {
+		return nil, err
+	}
+	list := &fileList{files: files, truncated: truncated, listed: time.Now()}
+
+	d.mu.Lock()
+	if d.fileLists == nil {
+		d.fileLists = make(map[string]*fileList)
+	}
+	d.fileLists[root] = list
+	d.mu.Unlock()
+	d.logger.Printf("Listed %d files in %s (truncated=%v)", len(files), root, truncated)
+	return list, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+const (
+	// fixContextLines is how many lines on each side of a diagnostic
+	// crush/fixDiagnostic includes.
+	fixContextLines = 10
+	// maxPendingFixes bounds the fixes awaiting the agent's edit; the
+	// oldest are dropped.
+	maxPendingFixes = 64
+)
+
+// pendingFix is a crush/fixDiagnostic awaiting the agent's edit.
+type pendingFix struct {
+	id         int
+	uri        string
+	diagnostic lsp.Diagnostic
+	verifiable bool // The providers reported the diagnostic, so they can recheck it
+	edited     bool // The agent edited the document since the last check
+}
+
+// sameDiagnostic reports whether a and b are the same problem. Ranges are
+// not compared, since edits above a diagnostic move it.
+func sameDiagnostic(a, b lsp.Diagnostic) bool {
+	return a.Message == b.Message && a.Source == b.Source
+}
+
+// handleFixDiagnostic answers crush/fixDiagnostic from Neovim.
+func (d *Daemon) handleFixDiagnostic(content []byte, conn net.Conn) {
+	var req struct {
+		ID     json.RawMessage         `json:"id"`
+		Params lsp.FixDiagnosticParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid fixDiagnostic params: "+err.Error())
+		return
+	}
+	if req.Params.TextDocument.URI == "" {
+		d.respondError(conn, req.ID, lsp.InvalidParams, "textDocument is required")
+		return
+	}
+	d.fixDiagnostic(conn, req.ID, req.Params.TextDocument, req.Params.Diagnostic)
+}
+
+// fixDiagnostic packages diagnostic with the code around it and the
+// document's metadata, and sends it to the agents as crush/fixDiagnostic: a
+// request to Crush, whose response answers id, and a notification to MCP
+// clients. The fix is then tracked until the agent's edits clear it.
+func (d *Daemon) fixDiagnostic(conn net.Conn, id json.RawMessage, doc lsp.TextDocumentIdentifier, diagnostic lsp.Diagnostic) {
+	uri := doc.URI
+	params := lsp.FixDiagnosticParams{
+		TextDocument: doc,
+		Diagnostic:   diagnostic,
+		LanguageID:   workspace.Language(extractFilename(uri)),
+	}
+	if path, err := uriToPath(uri); err == nil {
+		params.Path = path
+		if rel, err := filepath.Rel(d.workspace, path); err == nil && filepath.IsLocal(rel) {
+			params.Path = filepath.ToSlash(rel)
+		}
+	}
+	// The synced copy, else the one Neovim opened, else the file
+	d.mu.RLock()
+	text, ok := d.documentState[uri]
+	d.mu.RUnlock()
+	if !ok {
+		text, ok = d.state.GetDocumentContent(uri)
+	}
+	if !ok {
+		text = d.documentText(uri)
+	}
+	if text != "" {
+		lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+		params.LineCount = len(lines)
+		start := max(diagnostic.Range.Start.Line-fixContextLines, 0)
+		end := min(diagnostic.Range.End.Line+fixContextLines+1, len(lines))
+		if start < end {
+			params.Context = &lsp.FixContext{StartLine: start, Text: strings.Join(lines[start:end], "\n")}
+		}
+	}
+	verifiable := d.linting && slices.ContainsFunc(d.state.GetDiagnostics(uri), func(x lsp.Diagnostic) bool {
+		return sameDiagnostic(x, diagnostic)
+	})
+
+	d.mu.Lock()
+	crush := d.clients[d.agentForLocked()]
+	var mcpConns []net.Conn
+	for name, c := range d.clients {
+		if clientType(name) == "mcp" {
+			mcpConns = append(mcpConns, c)
+		}
+	}
+	if crush == nil && len(mcpConns) == 0 {
+		d.mu.Unlock()
+		d.respondError(conn, id, lsp.RequestFailed, "no agent is connected")
+		return
+	}
+	d.fixSeq++
+	params.ID = d.fixSeq
+	d.fixes = append(d.fixes, &pendingFix{id: params.ID, uri: uri, diagnostic: diagnostic, verifiable: verifiable})
+	if len(d.fixes) > maxPendingFixes {
+		d.fixes = d.fixes[len(d.fixes)-maxPendingFixes:]
+	}
+	d.mu.Unlock()
+
+	d.logger.Printf("Sending fix %d for %s to the agent: %s", params.ID, extractFilename(uri), diagnostic.Message)
+	if len(mcpConns) > 0 {
+		msg := []byte(rpc.EncodeMessage(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "crush/fixDiagnostic",
+			"params":  params,
+		}))
+		for _, c := range mcpConns {
+			if _, err := c.Write(msg); err != nil {
+				d.logger.Printf("Failed to send crush/fixDiagnostic: %v", err)
+			}
+		}
+	}
+	if crush == nil {
+		d.respondResult(conn, id, lsp.FixDiagnosticResult{ID: params.ID})
+		return
+	}
+	d.relayCommandToCrush(conn, id, "crush/fixDiagnostic", params, uri)
+}
+
+// fixEdited notes an agent edit of uri, before it is linted. Its fixes are
+// rechecked once the providers have seen the edit; those they can't
+// recheck are reported unverified.
+func (d *Daemon) fixEdited(uri string) {
+	var unverified []pendingFix
+	d.mu.Lock()
+	d.fixes = slices.DeleteFunc(d.fixes, func(f *pendingFix) bool {
+		if f.uri != uri {
+			return false
+		}
+		if !f.verifiable {
+			unverified = append(unverified, *f)
+			return true
+		}
+		f.edited = true
+		return false
+	})
+	d.mu.Unlock()
+
+	for _, f := range unverified {
+		d.sendFixVerified(f, lsp.FixUnverified)
+	}
+}
+
+// verifyFixes reports whether the providers still find the diagnostics of
+// the edited fixes of uri. Cleared fixes are done.
+func (d *Daemon) verifyFixes(uri string) {
+	diagnostics := d.state.GetDiagnostics(uri)
+
+	type outcome struct {
+		fix    pendingFix
+		status string
+	}
+	var outcomes []outcome
+	d.mu.Lock()
+	d.fixes = slices.DeleteFunc(d.fixes, func(f *pendingFix) bool {
+		if f.uri != uri || !f.edited {
+			return false
+		}
+		f.edited = false
+		if slices.ContainsFunc(diagnostics, func(x lsp.Diagnostic) bool { return sameDiagnostic(x, f.diagnostic) }) {
+			outcomes = append(outcomes, outcome{*f, lsp.FixRemaining})
+			return false
+		}
+		outcomes = append(outcomes, outcome{*f, lsp.FixCleared})
+		return true
+	})
+	d.mu.Unlock()
+
+	for _, o := range outcomes {
+		d.sendFixVerified(o.fix, o.status)
+	}
+}
+
+// dropFixes forgets the fixes of a document Neovim closed.
+func (d *Daemon) dropFixes(uri string) {
+	d.mu.Lock()
+	d.fixes = slices.DeleteFunc(d.fixes, func(f *pendingFix) bool { return f.uri == uri })
+	d.mu.Unlock()
+}
+
+// sendFixVerified sends crush/fixVerified to Neovim, Crush and MCP
+// clients.
+func (d *Daemon) sendFixVerified(fix pendingFix, status string) {
+	d.logger.Printf("Fix %d for %s: %s", fix.id, extractFilename(fix.uri), status)
+
+	d.mu.RLock()
+	var conns []net.Conn
+	for name, conn := range d.clients {
+		if name == "neovim" || clientType(name) == "crush" || clientType(name) == "mcp" {
+			conns = append(conns, conn)
+		}
+	}
+	d.mu.RUnlock()
+
+	msg := []byte(rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "crush/fixVerified",
+		"params": lsp.FixVerifiedParams{
+			ID:           fix.id,
+			TextDocument: lsp.TextDocumentIdentifier{URI: fix.uri},
+			Diagnostic:   fix.diagnostic,
+			Status:       status,
+		},
+	}))
+	for _, conn := range conns {
+		if _, err := conn.Write(msg); err != nil {
+			d.logger.Printf("Failed to send crush/fixVerified: %v", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/taigrr/neocrush/internal/diff"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// foldMinLines is the shortest run of unchanged lines a fold hint offers
+// to fold.
+const foldMinLines = 5
+
+// withFoldHint adds a fold hint to the params of a workspace/applyEdit
+// changing uri from oldText to newText, if the change spans more than
+// review.fold_lines lines. Returns params.
+func (d *Daemon) withFoldHint(params map[string]any, uri, oldText, newText string) map[string]any {
+	if hint := d.foldHint(uri, oldText, newText); hint != nil {
+		params["fold"] = []lsp.FoldHint{*hint}
+	}
+	return params
+}
+
+// foldHint describes the change of uri from oldText to newText, or returns
+// nil if it spans no more than review.fold_lines lines.
+func (d *Daemon) foldHint(uri, oldText, newText string) *lsp.FoldHint {
+	if d.foldLines <= 0 {
+		return nil
+	}
+	// Measured as applied, in the document's line endings
+	newText = matchLineEnding(oldText, newText)
+	hunks := diff.Lines(splitLines(oldText), splitLines(newText))
+	if len(hunks) == 0 {
+		return nil
+	}
+	first, last := hunks[0], hunks[len(hunks)-1]
+	oldSpan := last.OldStart + len(last.Old) - first.OldStart
+	newSpan := last.NewStart + len(last.New) - first.NewStart
+	if max(oldSpan, newSpan) <= d.foldLines {
+		return nil
+	}
+
+	hint := &lsp.FoldHint{URI: uri, Range: lineSpan(first.NewStart, last.NewStart+len(last.New))}
+	for i, h := range hunks {
+		hint.Added += len(h.New)
+		hint.Removed += len(h.Old)
+		if i == 0 {
+			continue
+		}
+		prev := hunks[i-1]
+		if from := prev.NewStart + len(prev.New); h.NewStart-from >= foldMinLines {
+			hint.Unchanged = append(hint.Unchanged, lineSpan(from, h.NewStart))
+		}
+	}
+	hint.Summary = fmt.Sprintf("+%d -%d lines in %d %s", hint.Added, hint.Removed, len(hunks), plural(len(hunks), "hunk", "hunks"))
+	return hint
+}
+
+// lineSpan returns the range of lines from up to, but not including, end.
+func lineSpan(from, end int) lsp.Range {
+	return lsp.Range{Start: lsp.Position{Line: from}, End: lsp.Position{Line: end}}
+}
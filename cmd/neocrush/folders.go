@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// roots returns the workspace root followed by the other workspace
+// folders of a multi-root workspace.
+func (d *Daemon) roots() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return append([]string{d.workspace}, d.folders...)
+}
+
+// folderPaths converts workspace folders to absolute paths, skipping
+// non-file URIs and the workspace root itself.
+func (d *Daemon) folderPaths(folders []lsp.WorkspaceFolder) []string {
+	var paths []string
+	for _, f := range folders {
+		path, err := uriToPath(f.URI)
+		if err != nil || !filepath.IsAbs(path) {
+			continue
+		}
+		path = filepath.Clean(path)
+		if path != d.workspace && !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// changeFolders adds and removes workspace folders.
+func (d *Daemon) changeFolders(added, removed []lsp.WorkspaceFolder) {
+	remove := d.folderPaths(removed)
+
+	d.mu.RLock()
+	folders := slices.DeleteFunc(slices.Clone(d.folders), func(f string) bool {
+		return slices.Contains(remove, f)
+	})
+	d.mu.RUnlock()
+
+	for _, f := range d.folderPaths(added) {
+		if !slices.Contains(folders, f) {
+			folders = append(folders, f)
+		}
+	}
+	d.setFolders(folders)
+}
+
+// setFolders replaces the workspace folders, dropping the cached listings
+// and reporting the new set to foldersChanged.
+func (d *Daemon) setFolders(folders []string) {
+	d.mu.Lock()
+	if slices.Equal(folders, d.folders) {
+		d.mu.Unlock()
+		return
+	}
+	d.folders = folders
+	d.fileLists = nil
+	d.mu.Unlock()
+
+	d.logger.Printf("Workspace folders: %v", folders)
+	if d.foldersChanged != nil {
+		d.foldersChanged(slices.Clone(folders))
+	}
+}
+
+// handleDidChangeWorkspaceFolders tracks folders Neovim adds to or removes
+// from the workspace. The notification is still forwarded to Crush.
+func (d *Daemon) handleDidChangeWorkspaceFolders(content []byte) {
+	var notif struct {
+		Params lsp.DidChangeWorkspaceFoldersParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &notif); err != nil {
+		d.logger.Printf("Invalid didChangeWorkspaceFolders: %v", err)
+		return
+	}
+	d.changeFolders(notif.Params.Event.Added, notif.Params.Event.Removed)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/charset"
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/workspace"
+)
+
+// formatter is a compiled format rule.
+type formatter struct {
+	files   *workspace.Matcher
+	command []string
+}
+
+// formatters compiles the format rules, in order.
+func formatters(rules []config.FormatRule) []formatter {
+	compiled := make([]formatter, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule.Command) == 0 {
+			continue
+		}
+		var m workspace.Matcher
+		m.Add("", rule.Files)
+		compiled = append(compiled, formatter{files: &m, command: rule.Command})
+	}
+	return compiled
+}
+
+// errNoOutput rejects a formatter that printed nothing for a non-empty file.
+var errNoOutput = errors.New("formatter wrote nothing")
+
+// formatContent returns text as formatted by the formatter configured for
+// uri, and whether that changed it. A formatter that fails (often on a
+// syntax error in the agent's output) leaves text as it is.
+func (d *Daemon) formatContent(uri, text string) (string, bool) {
+	rel, ok := d.workspaceRel(uri)
+	if !ok {
+		return text, false
+	}
+	var command []string
+	for _, f := range d.formatters {
+		if f.files.Matches(rel) {
+			command = f.command
+			break
+		}
+	}
+	if command == nil {
+		_, profile := d.language(uri)
+		command = profile.Format
+	}
+	if len(command) == 0 {
+		return text, false
+	}
+
+	path, _ := uriToPath(uri)
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = strings.ReplaceAll(arg, "{path}", path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.format.Timeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = d.workspace
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err == nil && len(out) == 0 && text != "" {
+		err = errNoOutput
+	}
+	if err != nil {
+		d.logger.Printf("Formatter %q failed on %s, leaving it unformatted: %v %s", args[0], extractFilename(uri), err, strings.TrimSpace(stderr.String()))
+		return text, false
+	}
+	return string(out), string(out) != text
+}
+
+// saveFormatted writes the formatted text of uri over the file on disk,
+// keeping its permissions and encoding.
+func (d *Daemon) saveFormatted(uri, text string) {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return
+	}
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	_, encoding, _ := readText(path)
+	data, err := charset.Encode(text, cmp.Or(encoding, charset.UTF8))
+	if err != nil {
+		d.logger.Printf("Not saving formatted %s: %v", path, err)
+		return
+	}
+	if err := os.WriteFile(path, data, mode); err != nil {
+		d.logger.Printf("Failed to save formatted %s: %v", path, err)
+	}
+}
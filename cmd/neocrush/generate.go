@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/boilerplate"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleGenerateFile answers crush/generateFile. The generated file is
+// created as by crush/writeFile, so it is formatted, checked against the
+// guardrails, previewed and recorded like any other write.
+func (d *Daemon) handleGenerateFile(from string, content []byte, conn net.Conn) {
+	id := messageID(content)
+
+	var req struct {
+		Params lsp.GenerateFileParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, id, lsp.InvalidParams, "invalid generateFile params: "+err.Error())
+		return
+	}
+
+	// Templates take paths relative to the workspace root
+	params := maps.Clone(req.Params.Params)
+	for _, key := range []string{"path", "source"} {
+		if params[key] == "" {
+			continue
+		}
+		rel, err := d.rootRelative(params[key])
+		if err != nil {
+			d.respondError(conn, id, lsp.InvalidParams, key+": "+err.Error())
+			return
+		}
+		params[key] = rel
+	}
+
+	project := boilerplate.Project{Root: d.workspace, Year: d.clock.Now().Year()}
+	if list, err := d.workspaceFiles(d.workspace, false); err == nil {
+		for _, f := range list.files {
+			project.Files = append(project.Files, f.Path)
+		}
+	}
+	file, err := boilerplate.Generate(project, req.Params.Template, params)
+	if err != nil {
+		d.respondError(conn, id, lsp.InvalidParams, err.Error())
+		return
+	}
+
+	result, code, err := d.writeFile(d.requestContext(conn, content), from, lsp.WriteFileParams{
+		Path:    file.Path,
+		Content: file.Content,
+		Create:  true,
+	})
+	if data := editErrorData(err); data != nil {
+		d.respondErrorData(conn, id, code, err.Error(), data)
+		return
+	}
+	if err != nil {
+		d.respondError(conn, id, code, err.Error())
+		return
+	}
+	d.logger.Printf("Generated %s from template %s for %s", file.Path, req.Params.Template, from)
+	d.respondResult(conn, id, lsp.GenerateFileResult{WriteFileResult: result, Content: file.Content})
+}
+
+// rootRelative returns p, a workspace path, slash-separated and relative to
+// the workspace root. Paths in other workspace folders are rejected.
+func (d *Daemon) rootRelative(p string) (string, error) {
+	abs, err := d.resolveWorkspacePath(p)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(d.workspace, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not under the workspace root", p)
+	}
+	return filepath.ToSlash(rel), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleGetState answers crush/getState with the editor state the daemon
+// tracks: the focused document, cursor, documents open in Neovim, and the
+// negotiated client capabilities.
+func (d *Daemon) handleGetState(content []byte, conn net.Conn) {
+	var req struct {
+		ID     json.RawMessage    `json:"id"`
+		Params lsp.GetStateParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid getState params: "+err.Error())
+		return
+	}
+
+	result := lsp.GetStateResult{Version: d.state.GetVersion()}
+
+	d.mu.RLock()
+	if d.cursorURI != "" {
+		result.FocusedDocument = &lsp.TextDocumentIdentifier{URI: d.cursorURI}
+	}
+
+	if req.Params.IncludeCursor && d.cursorURI != "" {
+		result.Cursor = &lsp.CursorInfo{
+			TextDocument: lsp.TextDocumentIdentifier{URI: d.cursorURI},
+			Position:     lsp.Position{Line: d.cursorLine, Character: d.cursorColumn},
+			Selections:   d.selections,
+			Cursors:      d.cursors,
+			LineContent:  d.cursorLineContent(),
+		}
+		if len(d.selections) > 0 {
+			result.Cursor.Selection = &d.selections[0]
+		}
+	}
+
+	for uri := range d.neovimOpenDocs {
+		info := lsp.DocumentInfo{
+			TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+		}
+		if req.Params.IncludeContent {
+			if text, ok := d.documentState[uri]; ok {
+				info.Content = &text
+			}
+		}
+		result.OpenDocuments = append(result.OpenDocuments, info)
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(result.OpenDocuments, func(i, j int) bool {
+		return result.OpenDocuments[i].TextDocument.URI < result.OpenDocuments[j].TextDocument.URI
+	})
+
+	if req.Params.IncludeCapabilities {
+		caps := d.state.Capabilities()
+		result.Capabilities = &caps
+	}
+
+	d.respondResult(conn, req.ID, result)
+}
+
+// handleStateVersion answers crush/stateVersion.
+func (d *Daemon) handleStateVersion(content []byte, conn net.Conn) {
+	d.respondResult(conn, messageID(content), lsp.StateVersionResult{Version: d.state.GetVersion()})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/quota"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// largeEditAnnotation labels edits over the guardrails.
+const largeEditAnnotation = "crush-large"
+
+// editStats measures an agent edit for the limits and guardrails.
+type editStats struct {
+	uris  []string // Documents touched
+	bytes int      // Bytes of new text
+	lines int      // Lines replaced plus lines inserted
+}
+
+// op returns the edit as counted by the rate limits.
+func (s editStats) op() quota.Op {
+	return quota.Op{Files: len(s.uris), Bytes: s.bytes}
+}
+
+// editLines counts the lines an edit replaces plus the lines it inserts.
+func editLines(r lsp.Range, newText string) int {
+	lines := r.End.Line - r.Start.Line
+	if newText != "" {
+		lines += strings.Count(newText, "\n")
+		if !strings.HasSuffix(newText, "\n") {
+			lines++
+		}
+	}
+	return lines
+}
+
+// documentEditStats measures edits to uri built by computeLineEdits.
+func documentEditStats(uri string, edits []map[string]any) editStats {
+	stats := editStats{uris: []string{uri}}
+	for _, edit := range edits {
+		text, _ := edit["newText"].(string)
+		stats.bytes += len(text)
+
+		var r lsp.Range
+		if rangeData, ok := edit["range"].(map[string]any); ok {
+			r.Start.Line, _ = rangeData["start"].(map[string]any)["line"].(int)
+			r.End.Line, _ = rangeData["end"].(map[string]any)["line"].(int)
+		}
+		stats.lines += editLines(r, text)
+	}
+	return stats
+}
+
+// workspaceEditStats measures the WorkspaceEdit of a workspace/applyEdit
+// request.
+func workspaceEditStats(content []byte) editStats {
+	var req struct {
+		Params struct {
+			Edit struct {
+				Changes         map[string][]lsp.TextEdit `json:"changes"`
+				DocumentChanges []struct {
+					TextDocument struct {
+						URI string `json:"uri"`
+					} `json:"textDocument"`
+					Edits  []lsp.TextEdit `json:"edits"`
+					URI    string         `json:"uri"`    // Create and delete operations
+					OldURI string         `json:"oldUri"` // Rename operations
+					NewURI string         `json:"newUri"`
+				} `json:"documentChanges"`
+			} `json:"edit"`
+		} `json:"params"`
+	}
+	_ = json.Unmarshal(content, &req)
+
+	var stats editStats
+	seen := make(map[string]bool)
+	touch := func(uri string, edits []lsp.TextEdit) {
+		if uri != "" && !seen[uri] {
+			seen[uri] = true
+			stats.uris = append(stats.uris, uri)
+		}
+		for _, e := range edits {
+			stats.bytes += len(e.NewText)
+			stats.lines += editLines(e.Range, e.NewText)
+		}
+	}
+
+	for uri, edits := range req.Params.Edit.Changes {
+		touch(uri, edits)
+	}
+	for _, change := range req.Params.Edit.DocumentChanges {
+		touch(change.TextDocument.URI, change.Edits)
+		touch(change.URI, nil)
+		touch(change.OldURI, nil)
+		touch(change.NewURI, nil)
+	}
+	return stats
+}
+
+// oversized reports whether an edit crosses the guardrails and must be
+// confirmed in Neovim instead of applied automatically.
+func (d *Daemon) oversized(stats editStats) bool {
+	g := d.guardrails
+	return g.ConfirmLines > 0 && stats.lines > g.ConfirmLines ||
+		g.ConfirmFiles > 0 && len(stats.uris) > g.ConfirmFiles
+}
+
+// largeEditLabel describes an oversized edit in the confirmation prompt.
+func largeEditLabel(stats editStats) string {
+	return fmt.Sprintf("Large Crush edit (%d lines in %d files)", stats.lines, len(stats.uris))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/history"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// defaultHistoryLimit caps crush/history results when no limit is given.
+const defaultHistoryLimit = 100
+
+// unrecordedMethods are crush/* methods left out of the transcript: they
+// fire on every keystroke or cursor move and say nothing about what an
+// agent did.
+var unrecordedMethods = map[string]bool{
+	"crush/cursorMoved":      true,
+	"crush/selectionChanged": true,
+	"crush/inlayHints":       true,
+	"crush/codeLens":         true,
+	"crush/history":          true,
+}
+
+// recordRequest appends a crush/* request to the session transcript.
+func (d *Daemon) recordRequest(client, method string, content []byte) {
+	if d.history == nil || unrecordedMethods[method] || !strings.HasPrefix(method, "crush/") {
+		return
+	}
+	var msg struct {
+		Params json.RawMessage `json:"params"`
+	}
+	_ = json.Unmarshal(content, &msg)
+	d.appendHistory(history.Record{
+		Kind:   history.KindRequest,
+		Client: client,
+		Method: method,
+		Params: msg.Params,
+	})
+}
+
+// recordEdit appends an applied document change to the session transcript.
+func (d *Daemon) recordEdit(client, uri string, added, removed int) {
+	if d.history == nil {
+		return
+	}
+	d.appendHistory(history.Record{
+		Kind:    history.KindEdit,
+		Client:  client,
+		URI:     uri,
+		Summary: fmt.Sprintf("+%d -%d lines", added, removed),
+	})
+}
+
+func (d *Daemon) appendHistory(r history.Record) {
+	if err := d.history.Append(r); err != nil {
+		d.logger.Printf("Failed to record history: %v", err)
+	}
+}
+
+// handleHistory answers crush/history from the transcripts on disk.
+func (d *Daemon) handleHistory(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.HistoryParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid history params: "+err.Error())
+		return
+	}
+	if d.history == nil {
+		d.respondError(conn, messageID(content), lsp.InternalError, "session history is not available")
+		return
+	}
+	if req.Params.Cursor != "" {
+		snapshot, id, offset, err := d.pages.load("crush/history", req.Params.Cursor)
+		if err != nil {
+			d.respondError(conn, messageID(content), lsp.InvalidParams, err.Error())
+			return
+		}
+		d.respondResult(conn, messageID(content), historyPage(snapshot.(lsp.HistoryResult), req.Params.PageParams, offset, func() int { return id }))
+		return
+	}
+
+	filter := history.Filter{
+		Method: req.Params.Method,
+		Kind:   req.Params.Kind,
+		Limit:  req.Params.Limit,
+	}
+	if !req.Params.AllSessions {
+		filter.Session = d.history.Session()
+	}
+	if req.Params.Minutes > 0 {
+		filter.Since = time.Now().Add(-time.Duration(req.Params.Minutes) * time.Minute)
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultHistoryLimit
+	}
+
+	records, err := history.Query(history.Dir(d.workspace), filter)
+	if err != nil {
+		d.respondError(conn, messageID(content), lsp.InternalError, err.Error())
+		return
+	}
+
+	result := lsp.HistoryResult{
+		Session: d.history.Session(),
+		Records: make([]lsp.HistoryRecord, 0, len(records)),
+	}
+	for _, r := range records {
+		result.Records = append(result.Records, historyRecord(r))
+	}
+	d.respondResult(conn, messageID(content), historyPage(result, req.Params.PageParams, 0, func() int {
+		return d.pages.save("crush/history", result)
+	}))
+}
+
+// historyPage returns the page of result that params asks for, starting at
+// offset. snapshot returns the ID of result's page snapshot.
+func historyPage(result lsp.HistoryResult, params lsp.PageParams, offset int, snapshot func() int) lsp.HistoryResult {
+	start, end, next := paginate(params, len(result.Records), offset, snapshot)
+	result.Records = result.Records[start:end]
+	result.NextCursor = next
+	return result
+}
+
+// historyRecord converts a stored record to its wire form.
+func historyRecord(r history.Record) lsp.HistoryRecord {
+	return lsp.HistoryRecord{
+		Time:    r.Time.Format(time.RFC3339),
+		Session: r.Session,
+		Kind:    r.Kind,
+		Client:  r.Client,
+		Method:  r.Method,
+		URI:     r.URI,
+		Summary: r.Summary,
+		Params:  r.Params,
+	}
+}
+
+// newHistoryCmd returns the `history` subcommand, which prints the session
+// transcript of the workspace in the current directory.
+func newHistoryCmd() *cobra.Command {
+	var (
+		since      time.Duration
+		filter     history.Filter
+		all        bool
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show the session transcript of agent activity",
+		Long: `Prints the requests, tool calls, and edits recorded in .crush/history
+for the workspace in the current directory, oldest first. Parameters are
+stored redacted: secrets are masked and file contents replaced by their size.
+
+By default only the current (or most recent) session is shown.`,
+		Example: `  neocrush history --since 30m
+  neocrush history --method 'crush/write*' --json
+  neocrush history --all --kind edit`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			if !all && filter.Session == "" {
+				if sess, err := session.NewManager().LoadSessionMetadata(cwd); err == nil {
+					filter.Session = sess.ID
+				}
+			}
+			if since > 0 {
+				filter.Since = time.Now().Add(-since)
+			}
+
+			records, err := history.Query(history.Dir(cwd), filter)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if jsonOutput {
+				enc := json.NewEncoder(out)
+				for _, r := range records {
+					if err := enc.Encode(historyRecord(r)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			for _, r := range records {
+				fmt.Fprintln(out, formatHistoryRecord(r))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&since, "since", 0, "Only records from this long ago (e.g. 30m)")
+	cmd.Flags().StringVar(&filter.Method, "method", "", "Only this method (a trailing * matches by prefix)")
+	cmd.Flags().StringVar(&filter.Kind, "kind", "", "Only this kind (request or edit)")
+	cmd.Flags().IntVar(&filter.Limit, "limit", 0, "Only the newest N records")
+	cmd.Flags().StringVar(&filter.Session, "session", "", "Session ID to show")
+	cmd.Flags().BoolVar(&all, "all", false, "Show all sessions")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print records as JSON lines")
+	return cmd
+}
+
+// formatHistoryRecord renders r as one human-readable line.
+func formatHistoryRecord(r history.Record) string {
+	parts := []string{r.Time.Local().Format(time.DateTime), r.Client}
+	switch r.Kind {
+	case history.KindEdit:
+		parts = append(parts, "edit", r.URI, r.Summary)
+	case history.KindSummary:
+		parts = append(parts, "summary", r.Summary)
+	default:
+		parts = append(parts, r.Method)
+		if len(r.Params) > 0 && string(r.Params) != "{}" && string(r.Params) != "null" {
+			parts = append(parts, string(r.Params))
+		}
+	}
+	return strings.Join(parts, "  ")
+}
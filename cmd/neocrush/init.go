@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/client"
+	"github.com/taigrr/neocrush/internal/config"
+)
+
+// nvimPlugin is the lazy.nvim spec of the Neovim plugin.
+const nvimPlugin = `{
+  'taigrr/neocrush.nvim',
+  event = 'VeryLazy',
+  opts = {},
+}`
+
+// mcpConfigFiles are the project MCP configs neocrush init mcp looks for,
+// in order of preference.
+var mcpConfigFiles = []string{".mcp.json", filepath.Join(".cursor", "mcp.json"), filepath.Join(".vscode", "mcp.json")}
+
+// initSnippet is what neocrush init adds to one client's configuration.
+type initSnippet struct {
+	Path    string   // The config file it belongs in
+	Snippet string   // The configuration to add
+	Content []byte   // The file with it added; nil if it can't be added automatically
+	Reason  string   // Why Content is nil
+	Done    bool     // The file already has it
+	Notes   []string // What was detected
+}
+
+func (s *initSnippet) notef(format string, args ...any) {
+	s.Notes = append(s.Notes, fmt.Sprintf(format, args...))
+}
+
+func newInitCmd() *cobra.Command {
+	var apply bool
+	var path string
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Print or write the configuration that connects a client to neocrush",
+		Long: `Prints the configuration snippet a client needs to use neocrush, after
+looking for its existing configuration and version (reported on stderr).
+With --apply, the snippet is added to the configuration file instead; a
+file that is changed is kept as <file>.bak first, and one that already
+uses neocrush is left alone.`,
+		Example: `  neocrush init nvim
+  neocrush init crush --apply
+  neocrush init mcp --path .vscode/mcp.json --apply`,
+	}
+	cmd.PersistentFlags().BoolVar(&apply, "apply", false, "Write the snippet into the configuration file")
+	cmd.PersistentFlags().StringVar(&path, "path", "", "Configuration file to use instead of the detected one")
+
+	run := func(plan func(path, command string) (initSnippet, error)) func(*cobra.Command, []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			snippet, err := plan(path, neocrushCommand())
+			if err != nil {
+				return err
+			}
+			return runInit(cmd, snippet, apply)
+		}
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "nvim",
+		Short: "Set up the neocrush.nvim plugin",
+		Long: `Prints the lazy.nvim spec of neocrush.nvim. --apply writes it to
+lua/plugins/neocrush.lua in the Neovim config ($NVIM_APPNAME respected), if
+it keeps its plugin specs there. --path is the Neovim config directory.`,
+		Args: cobra.NoArgs,
+		RunE: run(func(path, _ string) (initSnippet, error) {
+			return planNvim(cmp.Or(path, userConfigDir(cmp.Or(os.Getenv("NVIM_APPNAME"), "nvim"))))
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "crush",
+		Short: "Register neocrush with Crush as LSP and MCP server",
+		Long: `Prints the crush.json entries that run neocrush as Crush's fallback
+LSP and as an MCP server, and allow its tools (those the workspace's
+neocrush config enables, under their aliases). --apply adds what is
+missing to the global crush.json, leaving the rest as it is.`,
+		Args: cobra.NoArgs,
+		RunE: run(func(path, command string) (initSnippet, error) {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return initSnippet{}, err
+			}
+			cfg, err := config.Load(cwd)
+			if err != nil {
+				return initSnippet{}, err
+			}
+			tools, err := offeredTools(cfg.MCP)
+			if err != nil {
+				return initSnippet{}, err
+			}
+			snippet, err := planCrush(cmp.Or(path, filepath.Join(userConfigDir("crush"), "crush.json")), command, tools)
+			for _, name := range []string{".crush.json", "crush.json"} {
+				if _, err := os.Stat(filepath.Join(cwd, name)); err == nil {
+					snippet.notef("Found project config %s, which Crush merges over it", name)
+				}
+			}
+			return snippet, err
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "mcp",
+		Short: "Register neocrush with other MCP clients",
+		Long: `Prints the entry that runs neocrush as an MCP server, for clients that
+read a project MCP config: .mcp.json, .cursor/mcp.json or .vscode/mcp.json
+(in VS Code's format), whichever exists first. --apply adds it to that
+file, or to .mcp.json if there is none.`,
+		Args: cobra.NoArgs,
+		RunE: run(func(path, command string) (initSnippet, error) {
+			if path == "" {
+				for _, name := range mcpConfigFiles {
+					if _, err := os.Stat(name); err == nil {
+						path = name
+						break
+					}
+				}
+			}
+			return planMCP(cmp.Or(path, mcpConfigFiles[0]), command)
+		}),
+	})
+	return cmd
+}
+
+// runInit prints snippet, or with apply writes it to its file.
+func runInit(cmd *cobra.Command, snippet initSnippet, apply bool) error {
+	out, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+	for _, note := range snippet.Notes {
+		fmt.Fprintln(errOut, note)
+	}
+
+	if snippet.Done {
+		fmt.Fprintf(out, "%s already uses neocrush\n", snippet.Path)
+		return nil
+	}
+	if !apply {
+		fmt.Fprintf(errOut, "Add to %s:\n", snippet.Path)
+		fmt.Fprintln(out, snippet.Snippet)
+		return nil
+	}
+	if snippet.Content == nil {
+		return fmt.Errorf("can't update %s: %s; add this by hand:\n%s", snippet.Path, snippet.Reason, snippet.Snippet)
+	}
+	if err := os.MkdirAll(filepath.Dir(snippet.Path), 0o755); err != nil {
+		return err
+	}
+	if old, err := os.ReadFile(snippet.Path); err == nil {
+		if err := os.WriteFile(snippet.Path+".bak", old, 0o644); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", snippet.Path, err)
+		}
+		fmt.Fprintf(out, "Saved the previous %s as %s.bak\n", snippet.Path, snippet.Path)
+	}
+	if err := os.WriteFile(snippet.Path, snippet.Content, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote %s\n", snippet.Path)
+	return nil
+}
+
+// neocrushCommand returns how clients should run neocrush: by name if it
+// is in PATH, else by the path of this binary.
+func neocrushCommand() string {
+	if _, err := exec.LookPath("neocrush"); err == nil {
+		return "neocrush"
+	}
+	if exe, err := os.Executable(); err == nil {
+		return exe
+	}
+	return "neocrush"
+}
+
+// userConfigDir returns the directory an application keeps its user
+// configuration in, as Neovim and Crush look for it.
+func userConfigDir(name string) string {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, name)
+		}
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, name)
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", name)
+}
+
+// toolVersion returns the first line of a tool's version output, or "" if
+// it isn't installed.
+func toolVersion(name string, args ...string) string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, args...).Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(line)
+}
+
+// offeredTools returns the names of the tools the MCP server offers under
+// cfg.
+func offeredTools(cfg config.MCPConfig) ([]string, error) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := client.New(conn, log.New(io.Discard, "", 0))
+	defer c.Close()
+
+	server, err := NewMCPServer(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return server.tools, nil
+}
+
+// planNvim plans the neocrush.nvim spec for the Neovim config in dir.
+func planNvim(dir string) (initSnippet, error) {
+	s := initSnippet{Path: filepath.Join(dir, "init.lua"), Snippet: nvimPlugin}
+	if v := toolVersion("nvim", "--version"); v != "" {
+		s.notef("Found %s", v)
+	} else {
+		s.notef("nvim is not in PATH")
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		s.notef("No Neovim config in %s", dir)
+		s.Reason = "there is no Neovim config to add the plugin to"
+		return s, nil
+	}
+
+	// Look for the plugin in the config, lazy-lock.json included
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || s.Done {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".lua", ".vim", ".json":
+		default:
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err == nil && bytes.Contains(data, []byte("neocrush.nvim")) {
+			s.Path, s.Done = path, true
+			s.notef("Found neocrush.nvim in %s", path)
+		}
+		return nil
+	})
+	if err != nil || s.Done {
+		return s, err
+	}
+
+	plugins := filepath.Join(dir, "lua", "plugins")
+	if info, err := os.Stat(plugins); err == nil && info.IsDir() {
+		s.notef("Found lazy.nvim plugin specs in %s", plugins)
+		s.Path = filepath.Join(plugins, "neocrush.lua")
+		s.Snippet = "return " + nvimPlugin
+		s.Content = []byte(s.Snippet + "\n")
+		return s, nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, "lazy-lock.json")); err == nil {
+		s.Reason = "its lazy.nvim specs are not in lua/plugins"
+	} else {
+		s.Reason = "no lazy.nvim setup found"
+	}
+	return s, nil
+}
+
+// planCrush plans the crush.json entries at path that run command as
+// Crush's fallback LSP and as MCP server, and allow tools.
+func planCrush(path, command string, tools []string) (initSnippet, error) {
+	allowed := make([]any, 0, len(tools))
+	for _, tool := range tools {
+		allowed = append(allowed, "mcp_neocrush_"+tool)
+	}
+	want := map[string]any{
+		"lsp":         map[string]any{"*": map[string]any{"command": command}},
+		"mcp":         map[string]any{"neocrush": map[string]any{"command": command, "type": "stdio"}},
+		"permissions": map[string]any{"allowed_tools": allowed},
+	}
+
+	s := initSnippet{Path: path}
+	if v := toolVersion("crush", "--version"); v != "" {
+		s.notef("Found %s", v)
+	} else {
+		s.notef("crush is not in PATH")
+	}
+	err := mergeJSONConfig(&s, want, func(cfg map[string]any) (bool, error) {
+		changed := false
+		lsp, err := jsonObject(cfg, "lsp")
+		if err != nil {
+			return false, err
+		}
+		if existing, ok := lsp["*"]; !ok {
+			lsp["*"] = want["lsp"].(map[string]any)["*"]
+			changed = true
+		} else if m, _ := existing.(map[string]any); m["command"] != command {
+			s.notef(`lsp."*" already runs %v; leaving it`, m["command"])
+		}
+
+		mcp, err := jsonObject(cfg, "mcp")
+		if err != nil {
+			return false, err
+		}
+		if _, ok := mcp["neocrush"]; !ok {
+			mcp["neocrush"] = want["mcp"].(map[string]any)["neocrush"]
+			changed = true
+		}
+
+		permissions, err := jsonObject(cfg, "permissions")
+		if err != nil {
+			return false, err
+		}
+		current, _ := permissions["allowed_tools"].([]any)
+		for _, tool := range allowed {
+			if !slices.Contains(current, tool) {
+				current = append(current, tool)
+				changed = true
+			}
+		}
+		permissions["allowed_tools"] = current
+		return changed, nil
+	})
+	return s, err
+}
+
+// planMCP plans the entry at path that runs command as an MCP server.
+// VS Code's mcp.json lists servers under "servers" with their type, other
+// clients under "mcpServers".
+func planMCP(path, command string) (initSnippet, error) {
+	key := "mcpServers"
+	entry := map[string]any{"command": command}
+	if filepath.Base(filepath.Dir(path)) == ".vscode" {
+		key = "servers"
+		entry["type"] = "stdio"
+	}
+
+	s := initSnippet{Path: path}
+	err := mergeJSONConfig(&s, map[string]any{key: map[string]any{"neocrush": entry}}, func(cfg map[string]any) (bool, error) {
+		servers, err := jsonObject(cfg, key)
+		if err != nil {
+			return false, err
+		}
+		if _, ok := servers["neocrush"]; ok {
+			return false, nil
+		}
+		servers["neocrush"] = entry
+		return true, nil
+	})
+	return s, err
+}
+
+// mergeJSONConfig sets s's snippet to want, and its content to the JSON
+// file at s.Path after merge adds the snippet to it (or to want if there
+// is no file). Merged files are rewritten with sorted keys.
+func mergeJSONConfig(s *initSnippet, want map[string]any, merge func(cfg map[string]any) (bool, error)) error {
+	snippet, err := json.MarshalIndent(want, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.Snippet = string(snippet)
+
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.notef("No config at %s", s.Path)
+		s.Content = append(snippet, '\n')
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.notef("Found config at %s", s.Path)
+
+	cfg := make(map[string]any)
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			s.Reason = "it is not plain JSON: " + err.Error()
+			return nil
+		}
+	}
+	changed, err := merge(cfg)
+	if err != nil {
+		s.Reason = err.Error()
+		return nil
+	}
+	if !changed {
+		s.Done = true
+		return nil
+	}
+	content, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.Content = append(content, '\n')
+	return nil
+}
+
+// jsonObject returns the object under key in cfg, adding an empty one if
+// there is none.
+func jsonObject(cfg map[string]any, key string) (map[string]any, error) {
+	v, ok := cfg[key]
+	if !ok || v == nil {
+		obj := make(map[string]any)
+		cfg[key] = obj
+		return obj, nil
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%q is not an object", key)
+	}
+	return obj, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleInlayHints stores hints published by Crush via crush/inlayHints and
+// asks Neovim to re-query them.
+func (d *Daemon) handleInlayHints(content []byte) {
+	var notif lsp.InlayHintsNotification
+	if err := json.Unmarshal(content, &notif); err != nil {
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeParse,
+			Message: fmt.Sprintf("failed to parse inlayHints: %v", err),
+			Method:  "crush/inlayHints",
+		})
+		return
+	}
+
+	uri := notif.Params.TextDocument.URI
+
+	d.mu.Lock()
+	if len(notif.Params.Hints) == 0 {
+		delete(d.inlayHints, uri)
+	} else {
+		d.inlayHints[uri] = notif.Params.Hints
+	}
+	d.mu.Unlock()
+
+	d.logger.Printf("Crush published %d inlay hints for %s", len(notif.Params.Hints), extractFilename(uri))
+	d.refreshInlayHints()
+}
+
+// handleInlayHintRequest answers Neovim's textDocument/inlayHint from the
+// hints Crush last published for the document.
+func (d *Daemon) handleInlayHintRequest(content []byte, conn net.Conn) {
+	var req struct {
+		ID     json.RawMessage     `json:"id"`
+		Params lsp.InlayHintParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.logger.Printf("Failed to parse inlayHint request: %v", err)
+		return
+	}
+
+	start, end := req.Params.Range.Start.Line, req.Params.Range.End.Line
+
+	d.mu.RLock()
+	hints := []lsp.InlayHint{}
+	for _, hint := range d.inlayHints[req.Params.TextDocument.URI] {
+		if hint.Position.Line >= start && hint.Position.Line <= end {
+			hints = append(hints, hint)
+		}
+	}
+	d.mu.RUnlock()
+
+	d.respondResult(conn, req.ID, hints)
+}
+
+// clearInlayHints drops hints for uri once its content changes, since their
+// positions no longer line up. Crush republishes for the new content.
+func (d *Daemon) clearInlayHints(uri string) {
+	d.mu.Lock()
+	_, had := d.inlayHints[uri]
+	delete(d.inlayHints, uri)
+	d.mu.Unlock()
+
+	if had {
+		d.refreshInlayHints()
+	}
+}
+
+// clearAllInlayHints drops every published hint, e.g. when Crush disconnects.
+func (d *Daemon) clearAllInlayHints() {
+	d.mu.Lock()
+	had := len(d.inlayHints) > 0
+	d.inlayHints = make(map[string][]lsp.InlayHint)
+	d.mu.Unlock()
+
+	if had {
+		d.refreshInlayHints()
+	}
+}
+
+// refreshInlayHints asks Neovim to re-query inlay hints, if it supports refresh.
+func (d *Daemon) refreshInlayHints() {
+	if d.state.Capabilities().InlayHintRefresh {
+		d.requestNeovim("workspace/inlayHint/refresh", nil)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/capture"
+	"github.com/taigrr/neocrush/internal/history"
+	"github.com/taigrr/neocrush/internal/inspect"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// inspectClientName identifies `neocrush inspect --live` to the daemon.
+const inspectClientName = "inspect"
+
+// handleInspect answers crush/inspect by streaming every routed message to
+// conn as crush/inspectMessage until it disconnects.
+func (d *Daemon) handleInspect(content []byte, conn net.Conn) {
+	d.mu.Lock()
+	d.inspectors[conn] = true
+	d.mu.Unlock()
+
+	d.logger.Printf("Inspector attached")
+	d.respondResult(conn, messageID(content), struct{}{})
+}
+
+// detachInspector stops streaming to conn.
+func (d *Daemon) detachInspector(conn net.Conn) {
+	d.mu.Lock()
+	delete(d.inspectors, conn)
+	d.mu.Unlock()
+}
+
+// tapSent records a frame the daemon wrote to a client.
+func (d *Daemon) tapSent(o *outbox, frame []byte) {
+	d.tap(capture.Send, d.clientNameOf(o), o, frame)
+}
+
+// tap records a routed frame to the capture file and attached inspectors.
+// Traffic of the inspectors themselves is not recorded.
+func (d *Daemon) tap(dir, client string, conn net.Conn, frame []byte) {
+	if client == inspectClientName {
+		return
+	}
+	d.mu.RLock()
+	if d.inspectors[conn] || d.capture == nil && len(d.inspectors) == 0 {
+		d.mu.RUnlock()
+		return
+	}
+	number := d.connNumbers[conn]
+	w := d.capture
+	inspectors := make([]net.Conn, 0, len(d.inspectors))
+	for c := range d.inspectors {
+		inspectors = append(inspectors, c)
+	}
+	d.mu.RUnlock()
+
+	_, content, _ := rpc.DecodeMessage(frame)
+	msg := history.RedactSecrets(content)
+	if msg == nil {
+		msg, _ = json.Marshal(string(content)) // Keep what arrived, for the inspector to flag
+	}
+	rec := capture.Record{Time: d.clock.Now(), Dir: dir, Client: client, Conn: number, Message: msg}
+
+	if w != nil {
+		if err := w.Append(rec); err != nil {
+			d.logger.Printf("Failed to capture message: %v", err)
+		}
+	}
+	if len(inspectors) == 0 {
+		return
+	}
+	notification := []byte(rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "crush/inspectMessage",
+		"params":  lsp.InspectMessageParams(rec),
+	}))
+	for _, c := range inspectors {
+		c.Write(notification)
+	}
+}
+
+// numberConn numbers a new client connection for captures, which replays
+// use to tell connections apart. The returned function forgets it.
+func (d *Daemon) numberConn(conn net.Conn) func() {
+	d.mu.Lock()
+	d.connSeq++
+	d.connNumbers[conn] = d.connSeq
+	d.mu.Unlock()
+
+	return func() {
+		d.mu.Lock()
+		delete(d.connNumbers, conn)
+		d.mu.Unlock()
+	}
+}
+
+// clientNameOf returns the name conn identified as, or "".
+func (d *Daemon) clientNameOf(conn net.Conn) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for name, c := range d.clients {
+		if c == conn {
+			return name
+		}
+	}
+	return ""
+}
+
+func newInspectCmd() *cobra.Command {
+	var (
+		live    bool
+		opts    inspect.Options
+		timeout time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "inspect [capture.jsonl]",
+		Short: "Pretty-print routed messages from a capture or a running daemon",
+		Long: `Prints the messages the daemon routed, one line each, with edits decoded
+into hunks. Document contents are replaced by their size unless --bodies is
+given, and secrets are always masked. Protocol anomalies (error responses,
+responses nobody asked for, duplicate request IDs, and requests still
+unanswered after --timeout) are flagged with "!!".
+
+Without arguments, reads the current session's capture, written when
+"debug": {"capture": true} is set in the config. With --live, connects to
+the running daemon and prints messages as they are routed.`,
+		Example: `  neocrush inspect
+  neocrush inspect .crush/captures/abc123.jsonl --bodies
+  neocrush inspect --live --timeout 5s`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Timeout = timeout
+			in := inspect.New(cmd.OutOrStdout(), opts)
+
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+
+			var path string
+			if len(args) > 0 && !live {
+				path = args[0]
+			} else {
+				sess, err := session.NewManager().LoadSessionMetadata(cwd)
+				if err != nil {
+					return fmt.Errorf("no neocrush session for %s", cwd)
+				}
+				if live {
+					return inspectLive(in, sess.SocketPath, cwd)
+				}
+				path = capture.Path(capture.Dir(cwd), sess.ID)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if err := capture.Read(f, func(r capture.Record) error {
+				in.Print(r)
+				return nil
+			}); err != nil {
+				return err
+			}
+			in.Summary()
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&live, "live", false, "Follow the running daemon instead of reading a capture")
+	cmd.Flags().BoolVar(&opts.Bodies, "bodies", false, "Show document contents")
+	cmd.Flags().DurationVar(&timeout, "timeout", inspect.DefaultTimeout, "Flag requests unanswered for this long")
+	return cmd
+}
+
+// inspectLive attaches to the daemon and prints routed messages until the
+// daemon exits or the user interrupts.
+func inspectLive(in *inspect.Inspector, socketPath, workspace string) error {
+	c, err := connectAs(socketPath, workspace, inspectClientName)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if _, err := c.Request("crush/inspect", nil); err != nil {
+		return err
+	}
+
+	records := make(chan capture.Record)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			method, content, err := c.Next()
+			if err != nil {
+				done <- err
+				return
+			}
+			if method != "crush/inspectMessage" {
+				continue
+			}
+			var msg struct {
+				Params capture.Record `json:"params"`
+			}
+			if json.Unmarshal(content, &msg) == nil {
+				records <- msg.Params
+			}
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case r := <-records:
+			in.Print(r)
+		case now := <-ticker.C:
+			in.Check(now)
+		case <-interrupt:
+			in.Summary()
+			return nil
+		case err := <-done:
+			in.Summary()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
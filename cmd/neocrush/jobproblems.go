@@ -0,0 +1,125 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/errorformat"
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// maxJobProblems caps the problems taken from one job's output.
+const maxJobProblems = 500
+
+// jobProblems returns the problems the parser found in job's output, with
+// their paths resolved in the workspace, and the same problems as
+// diagnostics by document URI.
+func (d *Daemon) jobProblems(job *runningJob) ([]lsp.JobProblem, map[string][]lsp.Diagnostic) {
+	job.mu.Lock()
+	entries := job.parser.Entries()
+	job.mu.Unlock()
+
+	var (
+		problems    []lsp.JobProblem
+		diagnostics = make(map[string][]lsp.Diagnostic)
+		files       []workspace.File // Listed when first needed
+	)
+	for _, e := range entries {
+		problem := lsp.JobProblem{
+			Path:     e.File,
+			Line:     e.Line,
+			Column:   e.Column,
+			Severity: e.Severity,
+			Message:  e.Message,
+		}
+		if files == nil && !filepath.IsAbs(e.File) {
+			if list, err := d.workspaceFiles(d.workspace, false); err == nil {
+				files = list.files
+			}
+		}
+		if rel, ok := d.problemPath(e.File, files); ok {
+			problem.Path = rel
+			uri := "file://" + filepath.Join(d.workspace, filepath.FromSlash(rel))
+			diagnostics[uri] = append(diagnostics[uri], problemDiagnostic(problem, "neocrush "+job.kind))
+		}
+		problems = append(problems, problem)
+	}
+	return problems, diagnostics
+}
+
+// problemPath returns the slash-separated path, relative to the workspace
+// root, of the file a tool printed as name: name itself, or for names
+// relative to a package or crate directory (as go test prints them), the
+// one listed file ending in name.
+func (d *Daemon) problemPath(name string, files []workspace.File) (string, bool) {
+	if filepath.IsAbs(name) {
+		rel, err := filepath.Rel(d.workspace, name)
+		if err != nil || !filepath.IsLocal(rel) {
+			return "", false
+		}
+		return filepath.ToSlash(rel), true
+	}
+
+	name = path.Clean(filepath.ToSlash(name))
+	if !filepath.IsLocal(name) {
+		return "", false
+	}
+	if _, err := os.Stat(filepath.Join(d.workspace, filepath.FromSlash(name))); err == nil {
+		return name, true
+	}
+	var found string
+	for _, f := range files {
+		if strings.HasSuffix(f.Path, "/"+name) {
+			if found != "" {
+				return "", false // Ambiguous
+			}
+			found = f.Path
+		}
+	}
+	return found, found != ""
+}
+
+// problemDiagnostic converts a problem to an LSP diagnostic at its
+// position.
+func problemDiagnostic(p lsp.JobProblem, source string) lsp.Diagnostic {
+	pos := lsp.Position{Line: max(p.Line-1, 0), Character: max(p.Column-1, 0)}
+	severity := lsp.SeverityError
+	if p.Severity == errorformat.SeverityWarning {
+		severity = lsp.SeverityWarning
+	}
+	return lsp.Diagnostic{
+		Range:    lsp.Range{Start: pos, End: pos},
+		Severity: severity,
+		Source:   source,
+		Message:  p.Message,
+	}
+}
+
+// publishJobDiagnostics replaces the diagnostics of the last finished job
+// of kind and sends Neovim those of each document they changed, clearing
+// the previous job's from documents that no longer have any.
+func (d *Daemon) publishJobDiagnostics(kind string, diagnostics map[string][]lsp.Diagnostic) {
+	d.mu.Lock()
+	if d.clients["neovim"] == nil {
+		d.mu.Unlock()
+		return
+	}
+	uris := slices.Collect(maps.Keys(diagnostics))
+	for uri := range d.jobDiagnostics[kind] {
+		if _, ok := diagnostics[uri]; !ok {
+			uris = append(uris, uri)
+		}
+	}
+	d.jobDiagnostics[kind] = diagnostics
+	d.mu.Unlock()
+
+	slices.Sort(uris)
+	for _, uri := range uris {
+		d.publishDiagnostics(uri)
+	}
+}
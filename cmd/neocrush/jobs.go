@@ -0,0 +1,354 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/clock"
+	"github.com/taigrr/neocrush/internal/errorformat"
+	"github.com/taigrr/neocrush/internal/jobs"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+const (
+	// jobOutputInterval is how long a job's output is batched before it is
+	// sent as crush/jobOutput.
+	jobOutputInterval = 100 * time.Millisecond
+	// maxJobOutputBatch sends a batch early once it has this many lines.
+	maxJobOutputBatch = 200
+	// maxJobResultLines is how many of a job's last lines crush/runJob
+	// answers with.
+	maxJobResultLines = 200
+	// maxRecentJobLines is how many of a finished job's last lines
+	// crush/getRecentJobs keeps.
+	maxRecentJobLines = 50
+)
+
+// runningJob is a test or build command started by crush/runJob.
+type runningJob struct {
+	id      int
+	kind    string
+	command []string
+	client  string             // Who ran it
+	conn    net.Conn           // Gets the output along with Neovim
+	cancel  context.CancelFunc // Kills the command
+	started time.Time
+
+	mu        sync.Mutex
+	parser    *errorformat.Parser // Finds problems in the output
+	pending   []string            // Output not sent yet
+	flush     clock.Timer         // Pending send of pending
+	tail      []string            // Last maxJobResultLines lines of output
+	truncated bool                // Lines were dropped from tail
+}
+
+// handleRunJob answers crush/runJob: it starts the test or build command
+// and streams its output to Neovim and the caller. With params.wait the
+// answer comes when the job ends; otherwise right away.
+func (d *Daemon) handleRunJob(client string, content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.RunJobParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid runJob params: "+err.Error())
+		return
+	}
+	kind := cmp.Or(req.Params.Kind, jobs.KindTest)
+	if kind != jobs.KindTest && kind != jobs.KindBuild {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, fmt.Sprintf("unknown job kind %q", kind))
+		return
+	}
+
+	command := d.jobConfig.Command(kind)
+	if kind == jobs.KindTest {
+		if test := d.testCommand(req.Params.Language); len(test) > 0 {
+			command = test
+		}
+	}
+	if len(command) == 0 {
+		command = jobs.Detect(d.workspace, kind)
+	}
+	if len(command) == 0 {
+		d.respondError(conn, messageID(content), lsp.RequestFailed,
+			fmt.Sprintf("no %s command is configured or detected; set jobs.%s in the neocrush config", kind, kind))
+		return
+	}
+	command = append(slices.Clone(command), req.Params.Args...)
+
+	// A waiting caller can kill the job by cancelling its request
+	parent := context.Background()
+	if req.Params.Wait {
+		parent = d.requestContext(conn, content)
+	}
+	ctx, cancel := context.WithTimeout(parent, d.jobConfig.Timeout())
+
+	d.mu.Lock()
+	for _, other := range d.runningJobs {
+		if other.kind == kind {
+			d.mu.Unlock()
+			cancel()
+			d.respondError(conn, messageID(content), lsp.RequestFailed,
+				fmt.Sprintf("a %s job is already running (job %d)", kind, other.id))
+			return
+		}
+	}
+	d.jobSeq++
+	job := &runningJob{
+		id:      d.jobSeq,
+		kind:    kind,
+		command: command,
+		client:  client,
+		conn:    conn,
+		cancel:  cancel,
+		started: time.Now(),
+		parser:  errorformat.New(maxJobProblems),
+	}
+	d.runningJobs[job.id] = job
+	d.mu.Unlock()
+
+	d.logger.Printf("Job %d: running %q for %s", job.id, strings.Join(command, " "), client)
+	d.sendJobEvent(job, "crush/jobStarted", lsp.JobStartedParams{
+		JobID:   job.id,
+		Kind:    kind,
+		Command: command,
+		Client:  client,
+	})
+
+	if !req.Params.Wait {
+		d.respondResult(conn, messageID(content), lsp.RunJobResult{
+			JobID:    job.id,
+			Kind:     kind,
+			Command:  command,
+			Status:   lsp.JobStatusRunning,
+			ExitCode: -1,
+		})
+		go d.runJob(ctx, job)
+		return
+	}
+
+	progress := beginWorkDone(conn, content, "Running "+strings.Join(command, " "))
+	result := d.runJob(ctx, job)
+	progress.end(result.Status)
+	if err := parent.Err(); err != nil {
+		d.respondError(conn, messageID(content), failureCode(err, lsp.RequestFailed), err.Error())
+		return
+	}
+	d.respondResult(conn, messageID(content), result)
+}
+
+// runJob runs job until it exits or ctx ends, then reports how it ended.
+func (d *Daemon) runJob(ctx context.Context, job *runningJob) lsp.RunJobResult {
+	defer job.cancel()
+
+	code, err := jobs.Run(ctx, d.workspace, job.command, func(line string) {
+		d.jobOutput(job, line)
+	})
+	d.flushJobOutput(job)
+
+	finished := lsp.JobFinishedParams{
+		JobID:      job.id,
+		Kind:       job.kind,
+		ExitCode:   code,
+		DurationMS: time.Since(job.started).Milliseconds(),
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		finished.Status = lsp.JobStatusTimedOut
+	case ctx.Err() != nil:
+		finished.Status = lsp.JobStatusCancelled
+	case err != nil:
+		finished.Status = lsp.JobStatusFailed
+		finished.Message = err.Error()
+	case code == 0:
+		finished.Status = lsp.JobStatusSucceeded
+	default:
+		finished.Status = lsp.JobStatusFailed
+	}
+	problems, diagnostics := d.jobProblems(job)
+	finished.Problems = problems
+	// A killed job says nothing about problems it didn't get to
+	if ctx.Err() == nil && err == nil {
+		d.publishJobDiagnostics(job.kind, diagnostics)
+	}
+
+	d.mu.Lock()
+	delete(d.runningJobs, job.id)
+	d.mu.Unlock()
+
+	d.logger.Printf("Job %d: %s (exit code %d) after %v", job.id, finished.Status, code, time.Since(job.started).Round(time.Millisecond))
+	d.sendJobEvent(job, "crush/jobFinished", finished)
+
+	job.mu.Lock()
+	result := lsp.RunJobResult{
+		JobID:      job.id,
+		Kind:       job.kind,
+		Command:    job.command,
+		Status:     finished.Status,
+		ExitCode:   code,
+		Output:     slices.Clone(job.tail),
+		Truncated:  job.truncated,
+		DurationMS: finished.DurationMS,
+		Problems:   problems,
+	}
+	job.mu.Unlock()
+	d.rememberJob(job, result)
+	return result
+}
+
+// rememberJob adds a finished job to the ones crush/getRecentJobs returns,
+// with the end of its output, forgetting the oldest beyond jobs.history.
+func (d *Daemon) rememberJob(job *runningJob, result lsp.RunJobResult) {
+	limit := d.jobConfig.HistorySize()
+	if limit == 0 {
+		return
+	}
+	if len(result.Output) > maxRecentJobLines {
+		result.Output = result.Output[len(result.Output)-maxRecentJobLines:]
+		result.Truncated = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recentJobs = append(d.recentJobs, lsp.RecentJob{
+		RunJobResult: result,
+		Client:       job.client,
+		StartedAt:    job.started,
+		FinishedAt:   time.Now(),
+	})
+	if len(d.recentJobs) > limit {
+		d.recentJobs = slices.Delete(d.recentJobs, 0, len(d.recentJobs)-limit)
+	}
+}
+
+// handleGetRecentJobs answers crush/getRecentJobs with the remembered
+// jobs, most recent first.
+func (d *Daemon) handleGetRecentJobs(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.GetRecentJobsParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid getRecentJobs params: "+err.Error())
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	result := lsp.GetRecentJobsResult{Jobs: []lsp.RecentJob{}}
+	for _, job := range slices.Backward(d.recentJobs) {
+		if req.Params.Kind != "" && job.Kind != req.Params.Kind {
+			continue
+		}
+		if req.Params.Failed && job.Status == lsp.JobStatusSucceeded {
+			continue
+		}
+		result.Jobs = append(result.Jobs, job)
+		if len(result.Jobs) == req.Params.Limit {
+			break
+		}
+	}
+	d.respondResult(conn, messageID(content), result)
+}
+
+// jobOutput queues a line of job's output, sending the batch once it is
+// full or jobOutputInterval has passed.
+func (d *Daemon) jobOutput(job *runningJob, line string) {
+	job.mu.Lock()
+	job.parser.Feed(line)
+	job.pending = append(job.pending, line)
+	job.tail = append(job.tail, line)
+	if len(job.tail) > maxJobResultLines {
+		job.tail = job.tail[len(job.tail)-maxJobResultLines:]
+		job.truncated = true
+	}
+	full := len(job.pending) >= maxJobOutputBatch
+	if !full && job.flush == nil {
+		job.flush = d.clock.AfterFunc(jobOutputInterval, func() { d.flushJobOutput(job) })
+	}
+	job.mu.Unlock()
+
+	if full {
+		d.flushJobOutput(job)
+	}
+}
+
+// flushJobOutput sends job's queued output as one crush/jobOutput.
+func (d *Daemon) flushJobOutput(job *runningJob) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if job.flush != nil {
+		job.flush.Stop()
+		job.flush = nil
+	}
+	if len(job.pending) == 0 {
+		return
+	}
+	// Sent under job.mu so batches can't overtake each other
+	d.sendJobEvent(job, "crush/jobOutput", lsp.JobOutputParams{JobID: job.id, Lines: job.pending})
+	job.pending = nil
+}
+
+// sendJobEvent sends a job notification to Neovim and to whoever ran the
+// job.
+func (d *Daemon) sendJobEvent(job *runningJob, method string, params any) {
+	d.mu.RLock()
+	conns := []net.Conn{job.conn}
+	if neovim := d.clients["neovim"]; neovim != nil && neovim != job.conn {
+		conns = append(conns, neovim)
+	}
+	d.mu.RUnlock()
+
+	msg := []byte(rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	}))
+	for _, conn := range conns {
+		if _, err := conn.Write(msg); err != nil {
+			d.logger.Printf("Failed to send %s for job %d: %v", method, job.id, err)
+		}
+	}
+}
+
+// handleCancelJob answers crush/cancelJob by killing the job. It ends with
+// status "cancelled".
+func (d *Daemon) handleCancelJob(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.CancelJobParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid cancelJob params: "+err.Error())
+		return
+	}
+
+	d.mu.RLock()
+	job := d.runningJobs[req.Params.JobID]
+	d.mu.RUnlock()
+
+	if job == nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, fmt.Sprintf("no running job %d", req.Params.JobID))
+		return
+	}
+	job.cancel()
+	d.respondResult(conn, messageID(content), nil)
+}
+
+// cancelJobs kills every running job, so no command outlives the daemon.
+func (d *Daemon) cancelJobs() {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, job := range d.runningJobs {
+		job.cancel()
+	}
+}
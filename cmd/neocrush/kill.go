@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/client"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/internal/transport"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// killClientName identifies the kill and restart commands to the daemon.
+const killClientName = "kill"
+
+// defaultStopTimeout is how long kill and restart wait for a daemon to
+// clean up after itself.
+const defaultStopTimeout = 10 * time.Second
+
+func newKillCmd() *cobra.Command {
+	var all bool
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "kill",
+		Short: "Stop the daemon serving the current directory",
+		Long: `Asks the daemon serving the workspace in the current directory to shut
+down with crush/stopDaemon, as it would on SIGTERM: clients are told the
+session is ending, and the daemon records its final state and removes its
+socket and session file. Waits until it has. A daemon that doesn't answer
+is sent SIGTERM instead. With --all, every running daemon is stopped.
+
+Editors reconnect on their own, starting a new daemon.`,
+		Example: `  neocrush kill
+  neocrush kill --all`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			mgr := session.NewManager()
+			out := cmd.OutOrStdout()
+
+			if !all {
+				target, err := currentDaemon(mgr, cwd)
+				if err != nil {
+					return err
+				}
+				if err := stopDaemon(mgr, target, "neocrush kill", timeout); err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "Stopped session %s (pid %d)\n", target.Status.SessionID, target.Status.PID)
+				return nil
+			}
+
+			stopped, failed := 0, 0
+			for _, entry := range findSessions(mgr, cwd) {
+				if entry.Status == nil {
+					continue
+				}
+				if err := stopDaemon(mgr, entry, "neocrush kill --all", timeout); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "Failed to stop session %s: %v\n", entry.Status.SessionID, err)
+					failed++
+					continue
+				}
+				fmt.Fprintf(out, "Stopped session %s of %s (pid %d)\n", entry.Status.SessionID, entry.Status.Root, entry.Status.PID)
+				stopped++
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to stop %d of %d daemons", failed, stopped+failed)
+			}
+			if stopped == 0 {
+				fmt.Fprintln(out, "No daemons running")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Stop every running daemon")
+	cmd.Flags().DurationVar(&timeout, "timeout", defaultStopTimeout, "How long to wait for each daemon to stop")
+	return cmd
+}
+
+func newRestartCmd() *cobra.Command {
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "restart",
+		Short: "Restart the daemon serving the current directory",
+		Long: `Stops the daemon serving the workspace in the current directory as
+neocrush kill does, then starts a new one (with this binary, so restarting
+picks up an upgrade) for connected editors to resume with. If an editor
+has already started one by then, that one is kept.`,
+		Example: `  neocrush restart`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			mgr := session.NewManager()
+
+			target, err := currentDaemon(mgr, cwd)
+			if err != nil {
+				return err
+			}
+			if err := stopDaemon(mgr, target, "neocrush restart", timeout); err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Stopped session %s (pid %d)\n", target.Status.SessionID, target.Status.PID)
+
+			root := target.Status.Root
+			if sess, err := mgr.LoadSessionMetadata(root); err == nil && !sess.DaemonDead() && transport.Exists(sess.SocketPath) {
+				fmt.Fprintf(out, "Session %s already started\n", sess.ID)
+				return nil
+			}
+			sess, err := client.Spawn(log.New(io.Discard, "", 0), root, mgr)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Started session %s on %s\n", sess.ID, sess.SocketPath)
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&timeout, "timeout", defaultStopTimeout, "How long to wait for the daemon to stop")
+	return cmd
+}
+
+// currentDaemon returns the running daemon serving cwd.
+func currentDaemon(mgr *session.Manager, cwd string) (sessionEntry, error) {
+	socket := currentSocket(mgr, cwd)
+	if socket == "" {
+		return sessionEntry{}, fmt.Errorf("no neocrush session for %s", cwd)
+	}
+	entry := sessionEntry{Socket: socket, Current: true}
+	status, err := fetchDaemonStatus(socket, cwd)
+	if err != nil {
+		return entry, fmt.Errorf("no daemon running for %s: %w", cwd, err)
+	}
+	entry.Status = &status
+	return entry, nil
+}
+
+// stopDaemon asks the daemon of entry to stop for reason, or sends it
+// SIGTERM if it doesn't answer, and waits up to timeout for it to remove
+// its socket, session file and index entries, so a new daemon can take its
+// place.
+func stopDaemon(mgr *session.Manager, entry sessionEntry, reason string, timeout time.Duration) error {
+	status := entry.Status
+	if err := requestStop(entry.Socket, status.Root, reason); err != nil {
+		if !session.IsProcessAlive(status.PID) {
+			return err
+		}
+		p, _ := os.FindProcess(status.PID)
+		if err := p.Signal(syscall.SIGTERM); err != nil {
+			return fmt.Errorf("daemon didn't answer, and failed to signal pid %d: %w", status.PID, err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for !daemonStopped(mgr, status.SessionID, status.Root, entry.Socket) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("daemon (pid %d) did not stop within %s", status.PID, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
+
+// requestStop sends crush/stopDaemon to the daemon on socketPath.
+func requestStop(socketPath, workspace, reason string) error {
+	c, err := connectAs(socketPath, workspace, killClientName)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_, err = c.Request("crush/stopDaemon", lsp.StopDaemonParams{Reason: reason})
+	return err
+}
+
+// daemonStopped reports whether the daemon of session id has cleaned up
+// after itself: its socket is gone, and neither the workspace's session
+// file nor the index names the session any more.
+func daemonStopped(mgr *session.Manager, id, workspace, socketPath string) bool {
+	if transport.Exists(socketPath) {
+		return false
+	}
+	if sess, err := mgr.LoadSessionMetadata(workspace); err == nil && sess.ID == id {
+		return false
+	}
+	for _, entry := range mgr.IndexedSessions() {
+		if entry.ID == id {
+			return false
+		}
+	}
+	return true
+}
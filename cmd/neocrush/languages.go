@@ -0,0 +1,87 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/diff"
+	"github.com/taigrr/neocrush/internal/workspace"
+)
+
+// languageProfile is a compiled languages entry.
+type languageProfile struct {
+	config.LanguageConfig
+	protected *workspace.Matcher // Compiled Protected (nil = none)
+}
+
+// languageProfiles compiles the languages profiles.
+func languageProfiles(languages map[string]config.LanguageConfig) map[string]languageProfile {
+	profiles := make(map[string]languageProfile, len(languages))
+	for id, cfg := range languages {
+		profiles[id] = languageProfile{LanguageConfig: cfg, protected: protectedMatcher(cfg.Protected)}
+	}
+	return profiles
+}
+
+// language returns the language ID of uri and its profile, the zero
+// profile if it has none. The ID is the one Neovim opened the document
+// with, or else guessed from the file extension.
+func (d *Daemon) language(uri string) (string, languageProfile) {
+	id := d.languageID(uri)
+	return id, d.languages[id]
+}
+
+// languageID returns the languageId Neovim declared for uri in its
+// didOpen, or the language of its file extension if Neovim doesn't have
+// it open.
+func (d *Daemon) languageID(uri string) string {
+	d.languageMu.RLock()
+	id, ok := d.languageIDs[uri]
+	d.languageMu.RUnlock()
+	if ok {
+		return id
+	}
+	return workspace.Language(uri)
+}
+
+// protectsFiles reports whether files.protected or any language profile
+// protects files from agents.
+func (d *Daemon) protectsFiles() bool {
+	if d.protected != nil {
+		return true
+	}
+	for _, profile := range d.languages {
+		if profile.protected != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// testCommand returns the test command of language's profile, or nil if
+// it has none. An empty language is that of the file at Neovim's cursor.
+func (d *Daemon) testCommand(language string) []string {
+	if language == "" {
+		d.mu.RLock()
+		uri := d.cursorURI
+		d.mu.RUnlock()
+		language = d.languageID(uri)
+	}
+	return d.languages[language].Test
+}
+
+// mergeHunks returns hunks as one hunk spanning them all, for the "file"
+// diff granularity. a and b are the texts the hunks turn one into the
+// other.
+func mergeHunks(a, b []string, hunks []diff.Hunk) []diff.Hunk {
+	if len(hunks) < 2 {
+		return hunks
+	}
+	first, last := hunks[0], hunks[len(hunks)-1]
+	return []diff.Hunk{{
+		OldStart: first.OldStart,
+		NewStart: first.NewStart,
+		Old:      slices.Clone(a[first.OldStart : last.OldStart+len(last.Old)]),
+		New:      slices.Clone(b[first.NewStart : last.NewStart+len(last.New)]),
+	}}
+}
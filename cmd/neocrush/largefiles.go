@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// contentSkip returns why the daemon handles only the metadata of a
+// document with text, one of lsp.ContentBinary and lsp.ContentTooLarge, or
+// "" if it handles the content.
+func (d *Daemon) contentSkip(text string) string {
+	switch {
+	case strings.IndexByte(text[:min(len(text), binarySniffLen)], 0) >= 0:
+		return lsp.ContentBinary
+	case len(text) > d.maxDocumentSize():
+		return lsp.ContentTooLarge
+	}
+	return ""
+}
+
+// maxDocumentSize returns files.max_document_bytes, or its default.
+func (d *Daemon) maxDocumentSize() int {
+	if d.maxDocSize <= 0 {
+		return config.DefaultMaxDocumentBytes
+	}
+	return d.maxDocSize
+}
+
+// describeSkip describes a document left alone for reason, for messages.
+func describeSkip(uri, reason string, size int) string {
+	if reason == lsp.ContentBinary {
+		return fmt.Sprintf("%s is binary", extractFilename(uri))
+	}
+	return fmt.Sprintf("%s is %d bytes, over files.max_document_bytes", extractFilename(uri), size)
+}
+
+// skipDocument forgets the content of uri, which Crush opened or changed
+// (with method) to a document the daemon doesn't diff, so stale text isn't
+// diffed against later. Neovim's buffer can't be updated without sending
+// the whole document, so the user is told to reload it.
+func (d *Daemon) skipDocument(method, uri, reason string, size int) {
+	d.mu.Lock()
+	delete(d.documentState, uri)
+	neovimHasFile := d.neovimOpenDocs[uri]
+	d.mu.Unlock()
+	d.state.Touch()
+
+	if !neovimHasFile {
+		d.logger.Printf("Not mirroring Crush's %s: %s", method, describeSkip(uri, reason, size))
+		return
+	}
+	d.reportError(lsp.ErrorParams{
+		Code:    lsp.ErrorCodeTransform,
+		Message: describeSkip(uri, reason, size) + "; Crush's text isn't mirrored, reload the buffer to see it",
+		Method:  method,
+		URI:     uri,
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/quota"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// maxQueueWait bounds how long queue mode holds an edit over a limit.
+const maxQueueWait = quota.Window
+
+// reserveEdit checks an edit by client against the configured limits. In
+// queue mode it waits until the edit fits; otherwise, or when it never
+// will, the violation is reported to both editors and returned as a
+// *quota.ExceededError.
+func (d *Daemon) reserveEdit(client, method, uri string, op quota.Op) error {
+	deadline := time.Now().Add(maxQueueWait)
+	for {
+		err := d.quota.Reserve(client, op)
+		var exceeded *quota.ExceededError
+		if !errors.As(err, &exceeded) {
+			return err
+		}
+
+		if d.limits.Mode == config.LimitModeQueue && exceeded.RetryAfter > 0 && time.Now().Add(exceeded.RetryAfter).Before(deadline) {
+			d.logger.Printf("Queueing %s edit for %s: %v", client, exceeded.RetryAfter, err)
+			time.Sleep(exceeded.RetryAfter)
+			continue
+		}
+
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeRateLimited,
+			Message: fmt.Sprintf("%s edit rejected: %v", client, err),
+			Method:  method,
+			URI:     uri,
+		})
+		return err
+	}
+}
+
+// editErrorData describes an edit rejected by checkEdit as JSON-RPC error
+// data, or returns nil for other errors.
+func editErrorData(err error) any {
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		return lsp.RateLimitedData{
+			Code:         lsp.ErrorCodeRateLimited,
+			Limit:        exceeded.Limit,
+			Max:          exceeded.Max,
+			Value:        exceeded.Value,
+			RetryAfterMS: exceeded.RetryAfter.Milliseconds(),
+		}
+	}
+	var protected *protectedError
+	if errors.As(err, &protected) {
+		return lsp.ProtectedPathData{
+			Code: lsp.ErrorCodeProtected,
+			Path: protected.path,
+		}
+	}
+	var branch *branchError
+	if errors.As(err, &branch) {
+		return lsp.ProtectedBranchData{
+			Code:   lsp.ErrorCodeProtectedBranch,
+			Branch: branch.branch,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import "strings"
+
+// lineEnding returns the dominant line ending of text, "\r\n" or "\n", or
+// "" if it has no line breaks.
+func lineEnding(text string) string {
+	crlf := strings.Count(text, "\r\n")
+	lf := strings.Count(text, "\n") - crlf
+	switch {
+	case crlf+lf == 0:
+		return ""
+	case crlf > lf:
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// withLineEnding returns text with every line break written as eol.
+func withLineEnding(text, eol string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if eol == "\r\n" {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	return text
+}
+
+// matchLineEnding returns newText written in the dominant line ending of
+// oldText, if newText's own differs, so a CRLF document isn't rewritten
+// line by line.
+func matchLineEnding(oldText, newText string) string {
+	if eol := lineEnding(oldText); eol != "" && lineEnding(newText) != eol {
+		return withLineEnding(newText, eol)
+	}
+	return newText
+}
+
+// splitLines splits text into lines that keep their line endings. The last
+// line has none when text lacks a final newline.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// linePosition returns the LSP position of the start of line i of lines,
+// which may be one past the last. Past a last line without a final
+// newline there is no line to start, so it is the end of that line.
+func linePosition(lines []string, i int) map[string]any {
+	if i == len(lines) && i > 0 && !strings.HasSuffix(lines[i-1], "\n") {
+		return map[string]any{"line": i - 1, "character": lineUnits(lines[i-1])}
+	}
+	return map[string]any{"line": i, "character": 0}
+}
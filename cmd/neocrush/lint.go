@@ -0,0 +1,176 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/state"
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// lintRequest is a document waiting for the diagnostics providers.
+type lintRequest struct {
+	languageID string // "" keeps the document's language
+	text       string
+	version    int
+	closed     bool // Neovim closed it; its diagnostics are dropped
+}
+
+// diagnosticsProviders builds the providers of cfg, in order. A provider
+// with both rules and a command becomes two; rules with invalid patterns
+// are logged and skipped.
+func (d *Daemon) diagnosticsProviders(cfg config.DiagnosticsConfig) []state.Provider {
+	var providers []state.Provider
+	for _, p := range cfg.Providers {
+		source := cmp.Or(p.Name, "neocrush")
+		var rules []state.Rule
+		for _, rule := range p.Rules {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				d.logger.Printf("Warning: skipping diagnostics rule %q: %v", rule.Pattern, err)
+				continue
+			}
+			rules = append(rules, state.Rule{
+				Pattern:  pattern,
+				Severity: state.ParseSeverity(rule.Severity),
+				Message:  rule.Message,
+			})
+		}
+		if len(rules) > 0 {
+			providers = append(providers, state.ForLanguages(state.RegexProvider{Source: source, Rules: rules}, p.Languages...))
+		}
+		if len(p.Command) > 0 {
+			providers = append(providers, state.ForLanguages(state.CommandProvider{
+				Source:  source,
+				Command: p.Command,
+				Dir:     d.workspace,
+				Timeout: cfg.Timeout(),
+			}, p.Languages...))
+		}
+	}
+
+	if cfg.Todos || len(cfg.BannedWords) > 0 {
+		var markers []string
+		if cfg.Todos {
+			markers = cfg.Markers
+			if len(markers) == 0 {
+				markers = state.DefaultMarkers
+			}
+		}
+		scanner, err := state.NewScanner(markers, cfg.BannedWords)
+		if err != nil {
+			d.logger.Printf("Warning: skipping TODO and banned word diagnostics: %v", err)
+		} else {
+			providers = append(providers, scanner)
+		}
+	}
+	return providers
+}
+
+// lint queues the new text of uri for the diagnostics providers. Only the
+// latest text of a document waiting its turn is checked. Scratch documents
+// are not linted.
+func (d *Daemon) lint(uri, languageID, text string, version int) {
+	if !d.linting || isScratch(uri) {
+		return
+	}
+	d.queueLint(uri, lintRequest{languageID: languageID, text: text, version: version})
+}
+
+// unlint drops the diagnostics of a document Neovim closed.
+func (d *Daemon) unlint(uri string) {
+	if !d.linting {
+		return
+	}
+	d.queueLint(uri, lintRequest{closed: true})
+}
+
+func (d *Daemon) queueLint(uri string, req lintRequest) {
+	d.mu.Lock()
+	if pending, ok := d.lintPending[uri]; ok && req.languageID == "" {
+		req.languageID = pending.languageID
+	}
+	d.lintPending[uri] = req
+	d.mu.Unlock()
+
+	select {
+	case d.lintWake <- struct{}{}:
+	default:
+	}
+}
+
+// lintLoop runs the diagnostics providers on queued documents, one at a
+// time, and publishes their diagnostics to Neovim.
+func (d *Daemon) lintLoop(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-d.lintWake:
+		}
+
+		d.mu.Lock()
+		pending := d.lintPending
+		d.lintPending = make(map[string]lintRequest)
+		d.mu.Unlock()
+
+		for _, uri := range slices.Sorted(maps.Keys(pending)) {
+			req := pending[uri]
+			switch {
+			case req.closed:
+				d.state.CloseDocument(uri)
+				d.dropFixes(uri)
+			case req.languageID != "" || d.state.GetDocument(uri) == nil:
+				languageID := req.languageID
+				if languageID == "" {
+					languageID = workspace.Language(extractFilename(uri))
+				}
+				d.state.OpenDocument(uri, req.text, languageID, req.version)
+			default:
+				d.state.UpdateDocument(uri, req.text, req.version)
+			}
+			d.publishDiagnostics(uri)
+			if !req.closed {
+				d.verifyFixes(uri)
+			}
+		}
+	}
+}
+
+// publishDiagnostics sends Neovim every diagnostic of uri: the providers'
+// and those of the last test and build jobs.
+func (d *Daemon) publishDiagnostics(uri string) {
+	d.publishMu.Lock()
+	defer d.publishMu.Unlock()
+
+	diagnostics := d.state.GetDiagnostics(uri)
+	d.mu.RLock()
+	neovim := d.clients["neovim"]
+	for _, kind := range slices.Sorted(maps.Keys(d.jobDiagnostics)) {
+		diagnostics = append(diagnostics, d.jobDiagnostics[kind][uri]...)
+	}
+	d.mu.RUnlock()
+
+	if diagnostics == nil {
+		diagnostics = []lsp.Diagnostic{} // Clears the document's diagnostics
+	}
+	d.publish(wantsDiagnostics, "textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+	if neovim == nil {
+		return
+	}
+	msg := lsp.PublishDiagnosticsNotification{
+		Notification: lsp.Notification{
+			RPC:    "2.0",
+			Method: "textDocument/publishDiagnostics",
+		},
+		Params: lsp.PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	}
+	if _, err := neovim.Write([]byte(rpc.EncodeMessage(msg))); err != nil {
+		d.logger.Printf("Failed to publish diagnostics for %s: %v", uri, err)
+	}
+}
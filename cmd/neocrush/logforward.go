@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// logLevels are the MCP logging levels (RFC 5424 severities), least severe
+// first.
+var logLevels = []string{"debug", "info", "notice", "warning", "error", "critical", "alert", "emergency"}
+
+// logForwarder copies the daemon's log lines to the clients that asked for
+// them with crush/setLogLevel, so MCP hosts see failures without reading
+// daemon.log. It is written to by the daemon's logger, which is used while
+// d.mu is held, so it has its own lock.
+type logForwarder struct {
+	prefix string // Header the logger adds, to strip from lines
+	flags  int
+
+	mu      sync.Mutex
+	clients map[net.Conn]int // Connection -> index in logLevels of the least severe level it receives
+}
+
+// newLogForwarder returns a forwarder for lines written by a logger with
+// the given prefix and flags.
+func newLogForwarder(prefix string, flags int) *logForwarder {
+	return &logForwarder{prefix: prefix, flags: flags, clients: make(map[net.Conn]int)}
+}
+
+// Write sends the log line p as crush/logMessage to the clients whose level
+// it meets. Sends are queued, so a slow client doesn't hold up logging.
+func (f *logForwarder) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.clients) == 0 {
+		return len(p), nil
+	}
+
+	text := f.text(string(p))
+	level := lineLevel(text)
+	severity := slices.Index(logLevels, level)
+	msg := []byte(rpc.EncodeMessage(lsp.LogMessageNotification{
+		Notification: lsp.Notification{RPC: "2.0", Method: "crush/logMessage"},
+		Params:       lsp.LogMessageParams{Level: level, Logger: "neocrush", Message: text},
+	}))
+	for conn, least := range f.clients {
+		if severity >= least {
+			conn.Write(msg) // Failures aren't logged, which would loop back here
+		}
+	}
+	return len(p), nil
+}
+
+// text strips the logger's header (prefix, date, time, file) from line.
+func (f *logForwarder) text(line string) string {
+	line = strings.TrimSuffix(line, "\n")
+	if f.flags&log.Lmsgprefix == 0 {
+		line = strings.TrimPrefix(line, f.prefix)
+	}
+	if f.flags&(log.Lshortfile|log.Llongfile) != 0 {
+		if _, rest, ok := strings.Cut(line, ": "); ok {
+			line = rest
+		}
+	} else {
+		if f.flags&log.Ldate != 0 && len(line) >= len("2006/01/02 ") {
+			line = line[len("2006/01/02 "):]
+		}
+		if f.flags&(log.Ltime|log.Lmicroseconds) != 0 {
+			if _, rest, ok := strings.Cut(line, " "); ok {
+				line = rest
+			}
+		}
+	}
+	return strings.TrimPrefix(line, f.prefix)
+}
+
+// subscribe sends conn the lines at level and above.
+func (f *logForwarder) subscribe(conn net.Conn, level string) {
+	f.mu.Lock()
+	f.clients[conn] = slices.Index(logLevels, level)
+	f.mu.Unlock()
+}
+
+// unsubscribe stops sending lines to conn.
+func (f *logForwarder) unsubscribe(conn net.Conn) {
+	f.mu.Lock()
+	delete(f.clients, conn)
+	f.mu.Unlock()
+}
+
+// lineLevel guesses a log line's level from its wording: warnings are
+// "warning", failures and errors "error", and the rest (connections,
+// routing, cursor moves) "debug".
+func lineLevel(text string) string {
+	switch {
+	case strings.HasPrefix(text, "Warning"):
+		return "warning"
+	case strings.HasPrefix(text, "Failed"), strings.HasPrefix(text, "Error"), strings.Contains(text, " failed"):
+		return "error"
+	default:
+		return "debug"
+	}
+}
+
+// handleSetLogLevel answers crush/setLogLevel, forwarding the daemon's log
+// lines at params.level and above to the caller until it disconnects.
+func (d *Daemon) handleSetLogLevel(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.SetLogLevelParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid setLogLevel params: "+err.Error())
+		return
+	}
+	if !slices.Contains(logLevels, req.Params.Level) {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "level must be one of "+strings.Join(logLevels, ", "))
+		return
+	}
+
+	d.logs.subscribe(conn, req.Params.Level)
+	d.respondResult(conn, messageID(content), struct{}{})
+}
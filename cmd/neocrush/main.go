@@ -2,33 +2,70 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/charmbracelet/fang"
 	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/capture"
+	"github.com/taigrr/neocrush/internal/client"
+	"github.com/taigrr/neocrush/internal/clock"
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/history"
+	"github.com/taigrr/neocrush/internal/journal"
+	"github.com/taigrr/neocrush/internal/metrics"
+	"github.com/taigrr/neocrush/internal/mux"
+	"github.com/taigrr/neocrush/internal/quota"
+	"github.com/taigrr/neocrush/internal/sandbox"
 	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/internal/soak"
+	"github.com/taigrr/neocrush/internal/state"
+	"github.com/taigrr/neocrush/internal/tracing"
+	"github.com/taigrr/neocrush/internal/transport"
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
 	"github.com/taigrr/neocrush/rpc"
 )
 
 var version = "0.2.7"
 
+// legacyName is the retired crush-lsp binary name. Invoking neocrush through
+// a symlink with this name behaves identically, so existing editor configs
+// keep working after the crush-lsp binary is removed.
+const legacyName = "crush-lsp"
+
+// invokedName returns the name the binary was invoked as.
+func invokedName() string {
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	if name == legacyName {
+		return legacyName
+	}
+	return "neocrush"
+}
+
 func main() {
 	var logPath string
 	var daemonMode bool
+	var strict bool
+
+	name := invokedName()
 
 	rootCmd := &cobra.Command{
-		Use:   "neocrush",
+		Use:   name,
 		Short: "LSP/MCP multiplexed server for Crush and Neovim",
 		Long: `Runs as an LSP server that synchronizes state between Neovim and Crush,
 and as an MCP server providing editor context to AI tools.
@@ -47,6 +84,7 @@ Messages from Neovim are forwarded to Crush and vice versa.
 MCP Tools:
   editor_context   Get cursor position, surrounding code, and active file
   show_locations   Display code locations with AI explanations in Telescope
+  session_history  List earlier requests, tool calls, and edits in the session
 
 Configuration:
   Neovim: cmd = { "neocrush" }
@@ -55,17 +93,26 @@ Configuration:
 
 Files:
   .crush/session               Session info (workspace root)
+  .crush/history/              Session transcripts (see neocrush history)
+  .crush/checkpoints/          File snapshots (see neocrush checkpoint)
   $XDG_RUNTIME_DIR/neocrush/   Sockets (Linux)
   $TMPDIR/neocrush-$UID/       Sockets (macOS)`,
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			logger := getLogger(logPath)
 
+			if name == legacyName {
+				logger.Printf("Invoked as %s; this name is deprecated, use neocrush", legacyName)
+			}
+
 			if daemonMode {
-				runDaemon(logger)
+				runDaemon(logger, strict)
 				return nil
 			}
 
+			// Only a daemon this client starts is affected
+			client.Strict = strict
+
 			runClient(logger)
 			return nil
 		},
@@ -74,6 +121,9 @@ Files:
 	rootCmd.Flags().StringVar(&logPath, "log", "", "Log file path")
 	rootCmd.Flags().BoolVar(&daemonMode, "daemon", false, "Run as daemon (internal use)")
 	_ = rootCmd.Flags().MarkHidden("daemon")
+	rootCmd.Flags().BoolVar(&strict, "strict", false, "Validate crush/* messages against their schemas, rejecting violations")
+
+	rootCmd.AddCommand(newHistoryCmd(), newCheckpointCmd(), newStatusCmd(), newInspectCmd(), newExportPatchCmd(), newImportPatchCmd(), newReplayCmd(), newChaosCmd(), newBundleCmd(), newSessionsCmd(), newInitCmd(), newKillCmd(), newRestartCmd())
 
 	if err := fang.Execute(context.Background(), rootCmd, fang.WithVersion(version)); err != nil {
 		os.Exit(1)
@@ -82,7 +132,6 @@ Files:
 
 func runClient(logger *log.Logger) {
 	cwd, _ := os.Getwd()
-	mgr := session.NewManager()
 
 	// Peek at stdin to detect protocol (MCP vs LSP)
 	// MCP: newline-delimited JSON, starts with '{'
@@ -106,133 +155,122 @@ func runClient(logger *log.Logger) {
 			// EOF or error - could be MCP client that hasn't sent yet, or closed pipe
 			// Try running as MCP server anyway - it will handle the error gracefully
 			logger.Printf("Peek returned error (%v), attempting MCP mode", peekErr)
-			runMCPClient(logger, cwd, mgr, stdinReader)
+			runMCPClient(logger, cwd, stdinReader)
 			return
 		}
 	case <-time.After(5 * time.Second):
 		// Timeout waiting for first byte - assume MCP
 		logger.Printf("Timeout waiting for first byte, assuming MCP protocol")
-		runMCPClient(logger, cwd, mgr, stdinReader)
+		runMCPClient(logger, cwd, stdinReader)
 		return
 	}
 
 	isMCP := firstByte[0] == '{'
 	if isMCP {
 		logger.Printf("Detected MCP protocol")
-		runMCPClient(logger, cwd, mgr, stdinReader)
+		runMCPClient(logger, cwd, stdinReader)
 		return
 	}
 
 	logger.Printf("Detected LSP protocol")
-	runLSPClient(logger, cwd, mgr, stdinReader)
+	runLSPClient(logger, cwd, stdinReader)
 }
 
-func runMCPClient(logger *log.Logger, cwd string, mgr *session.Manager, stdinReader *bufio.Reader) {
+func runMCPClient(logger *log.Logger, cwd string, stdinReader *bufio.Reader) {
 	// Connect to daemon (or start one)
-	conn, err := connectToDaemon(logger, cwd, mgr)
+	c, err := client.Connect(logger, cwd)
 	if err != nil {
 		logger.Fatalf("Failed to connect to daemon: %v", err)
 	}
-	defer conn.Close()
+	defer c.Close()
 
 	// Run MCP server with daemon connection
-	mcpServer := NewMCPServer(conn)
+	cfg, err := config.Load(cwd)
+	if err != nil {
+		logger.Printf("Warning: %v, using defaults", err)
+		cfg = config.Default()
+	}
+	mcpServer, err := NewMCPServer(c, cfg.MCP)
+	if err != nil {
+		logger.Fatalf("Failed to create MCP server: %v", err)
+	}
+	mcpServer.authToken = cfg.Security.AuthToken
+	mcpServer.logger = logger
+	// A daemon that goes away is redialed (or respawned) in the background
+	mcpServer.reconnect = func() (*client.Client, error) { return client.Connect(logger, cwd) }
+	if cfg.Tracing.Enabled {
+		mcpServer.tracer = tracing.New(cfg.Tracing.OTLPEndpoint(), "neocrush-mcp", logger)
+		defer mcpServer.tracer.Shutdown()
+	}
+	ctx := context.Background()
+	if id, err := mcpServer.Register(ctx); err != nil {
+		logger.Printf("%v", err)
+	} else {
+		logger.Printf("Registered with daemon as %s", id)
+	}
 
 	// Create a custom stdin that uses our buffered reader
-	ctx := context.Background()
 	if err := mcpServer.RunWithReader(ctx, stdinReader); err != nil {
 		logger.Printf("MCP server error: %v", err)
 	}
 }
 
-func runLSPClient(logger *log.Logger, cwd string, mgr *session.Manager, stdinReader *bufio.Reader) {
-	conn, err := connectToDaemon(logger, cwd, mgr)
+func runLSPClient(logger *log.Logger, cwd string, stdinReader *bufio.Reader) {
+	// The session belongs to the workspace the editor opened, which may not
+	// be where it was started (e.g. a file opened from outside the repo), so
+	// read initialize before picking the daemon to connect to.
+	first, err := rpc.ReadMessage(stdinReader)
 	if err != nil {
-		logger.Fatalf("Failed to connect to daemon: %v", err)
+		logger.Printf("Failed to read first message: %v", err)
+		return
 	}
-	defer conn.Close()
-
-	logger.Printf("LSP client connected to daemon")
-	bridgeConnections(stdinReader, os.Stdout, conn, logger)
-}
-
-func connectToDaemon(logger *log.Logger, cwd string, mgr *session.Manager) (net.Conn, error) {
-	// Try to load existing session (don't check socket - we'll verify by connecting)
-	sess, err := mgr.LoadSessionMetadata(cwd)
-	if err == nil {
-		// Session file exists, try to connect to existing daemon
-		conn, err := net.DialTimeout("unix", sess.SocketPath, 2*time.Second)
-		if err == nil {
-			logger.Printf("Connected to existing session %s", sess.ID)
-			return conn, nil
+	workspace := cwd
+	if method, content, err := rpc.DecodeMessage(first); err == nil && method == "initialize" {
+		if root := initializeRoot(content); root != "" {
+			workspace = root
 		}
-		// Socket exists in session but can't connect - daemon probably dead
-		logger.Printf("Session exists but daemon unreachable, creating new session")
 	}
 
-	// No session or daemon dead - start new daemon
-	sess, err = startDaemonAndCreateSession(logger, cwd, mgr)
+	c, err := client.Connect(logger, workspace)
 	if err != nil {
-		return nil, fmt.Errorf("failed to start daemon: %w", err)
+		logger.Fatalf("Failed to connect to daemon: %v", err)
 	}
+	defer c.Close()
 
-	conn, err := net.DialTimeout("unix", sess.SocketPath, 5*time.Second)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to daemon: %w", err)
+	logger.Printf("LSP client connected to daemon for %s", workspace)
+	// A daemon that goes away is redialed (or respawned), so the editor's
+	// connection survives it
+	redial := func() (*client.Client, error) { return client.Connect(logger, workspace) }
+	if err := c.BridgeResuming(io.MultiReader(bytes.NewReader(first), stdinReader), os.Stdout, redial); err != nil {
+		logger.Printf("Bridge error: %v", err)
 	}
-
-	logger.Printf("Connected to session %s", sess.ID)
-	return conn, nil
 }
 
-func startDaemonAndCreateSession(logger *log.Logger, cwd string, mgr *session.Manager) (*session.Session, error) {
-	// Create session first to get socket path
-	sess, err := mgr.CreateSession(cwd, os.Getppid())
-	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %w", err)
-	}
-
-	// Start daemon in background
-	exe, err := os.Executable()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get executable path: %w", err)
-	}
-
-	cmd := exec.Command(exe, "--daemon",
-		"--log", filepath.Join(filepath.Dir(sess.SocketPath), "daemon.log"))
-	cmd.Dir = cwd
-	cmd.Env = append(os.Environ(), "CRUSH_SESSION_ID="+sess.ID)
-
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start daemon: %w", err)
+func runDaemon(logger *log.Logger, strict bool) {
+	sessionID := os.Getenv("CRUSH_SESSION_ID")
+	if sessionID == "" {
+		logger.Fatal("CRUSH_SESSION_ID not set")
 	}
 
-	// Detach from parent
-	if err := cmd.Process.Release(); err != nil {
-		logger.Printf("Warning: failed to release daemon process: %v", err)
+	// The working directory may be overridden by config, so the spawning
+	// client passes the workspace root explicitly.
+	workspace := os.Getenv("CRUSH_WORKSPACE_ROOT")
+	if workspace == "" {
+		workspace, _ = os.Getwd()
 	}
 
-	// Wait for socket to be ready
-	for i := 0; i < 50; i++ {
-		time.Sleep(100 * time.Millisecond)
-		if _, err := os.Stat(sess.SocketPath); err == nil {
-			return sess, nil
-		}
+	cfg, err := config.Load(workspace)
+	if err != nil {
+		logger.Printf("Warning: %v, using defaults", err)
+		cfg = config.Default()
 	}
-
-	return nil, fmt.Errorf("daemon did not create socket within timeout")
-}
-
-func runDaemon(logger *log.Logger) {
-	sessionID := os.Getenv("CRUSH_SESSION_ID")
-	if sessionID == "" {
-		logger.Fatal("CRUSH_SESSION_ID not set")
+	if err := sandbox.Apply(cfg.Daemon); err != nil {
+		logger.Printf("Warning: failed to apply daemon limits: %v", err)
 	}
 
-	cwd, _ := os.Getwd()
 	mgr := session.NewManager()
 
-	sess, err := mgr.LoadSessionMetadata(cwd)
+	sess, err := mgr.LoadSessionMetadata(workspace)
 	if err != nil {
 		logger.Fatalf("Failed to load session: %v", err)
 	}
@@ -242,62 +280,378 @@ func runDaemon(logger *log.Logger) {
 	}
 
 	// Ensure socket directory exists
-	socketDir := filepath.Dir(sess.SocketPath)
-	if err := os.MkdirAll(socketDir, 0o700); err != nil {
+	if err := os.MkdirAll(mgr.SocketDir(), 0o700); err != nil {
 		logger.Fatalf("Failed to create socket directory: %v", err)
 	}
 
-	// Remove stale socket if exists
-	os.Remove(sess.SocketPath)
-
-	listener, err := net.Listen("unix", sess.SocketPath)
+	// A Unix socket owner-only, or a named pipe on Windows
+	listener, err := transport.Listen(sess.SocketPath)
 	if err != nil {
 		logger.Fatalf("Failed to listen on socket: %v", err)
 	}
 	defer listener.Close()
-	defer os.Remove(sess.SocketPath)
 
-	// Set socket permissions
-	if err := os.Chmod(sess.SocketPath, 0o600); err != nil {
-		logger.Printf("Warning: failed to set socket permissions: %v", err)
+	logger.Printf("Daemon listening on %s", sess.SocketPath)
+
+	exe, _ := os.Executable()
+	if err := mgr.RecordDaemon(sessionID, session.DaemonInfo{
+		PID:        os.Getpid(),
+		Executable: exe,
+		Version:    version,
+		StartedAt:  time.Now(),
+	}); err != nil {
+		logger.Printf("Warning: failed to record daemon: %v", err)
+	}
+
+	// Clients may multiplex several channels over one connection
+	daemon := newDaemon(logger, mux.Listen(listener))
+	daemon.authToken = cfg.Security.AuthToken
+	daemon.strict = strict
+	daemon.workspace = workspace
+	daemon.sessionID = sessionID
+	daemon.configure(cfg)
+	if providers := daemon.diagnosticsProviders(cfg.Diagnostics); len(providers) > 0 {
+		daemon.state.SetProviders(func(err error) { daemon.logger.Printf("Diagnostics provider failed: %v", err) }, providers...)
+		daemon.linting = true
+	}
+	daemon.foldersChanged = func(folders []string) {
+		// Clients started in any folder find this session
+		if err := mgr.SetFolders(sessionID, folders); err != nil {
+			logger.Printf("Warning: failed to index workspace folders: %v", err)
+		}
 	}
 
-	logger.Printf("Daemon listening on %s", sess.SocketPath)
+	if cfg.Tracing.Enabled {
+		daemon.tracer = tracing.New(cfg.Tracing.OTLPEndpoint(), "neocrush-daemon", logger)
+		defer daemon.tracer.Shutdown()
+	}
+
+	if cfg.Debug.Capture {
+		path := capture.Path(capture.Dir(workspace), sessionID)
+		if w, err := capture.Create(path); err != nil {
+			logger.Printf("Warning: message capture disabled: %v", err)
+		} else {
+			defer w.Close()
+			daemon.capture = w
+			logger.Printf("Capturing messages to %s", path)
+		}
+	}
+
+	store, err := history.Open(history.Dir(workspace), sessionID)
+	if err != nil {
+		logger.Printf("Warning: session history disabled: %v", err)
+	} else {
+		defer store.Close()
+		daemon.history = store
+	}
+
+	// A killed daemon must not leave its socket and session file behind
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	stopped := make(chan struct{})
+	tornDown := make(chan struct{})
+	go func() {
+		var reason, ending string
+		select {
+		case sig := <-signals:
+			reason, ending = sig.String(), lsp.SessionEndingSignal
+		case reason = <-daemon.stopRequests:
+			ending = lsp.SessionEndingStopped
+		}
+		close(stopped)
+		daemon.teardown(reason, ending)
+		close(tornDown)
+	}()
+
+	daemon.run()
+
+	select {
+	case <-stopped:
+		<-tornDown
+		mgr.CleanupOnShutdown(sessionID)
+	default:
+	}
+}
 
-	daemon := &Daemon{
-		logger:          logger,
+// configure applies the workspace configuration to the daemon.
+func (d *Daemon) configure(cfg *config.Config) {
+	d.completion = cfg.Completion
+	d.fileExcludes = cfg.Files.Exclude
+	d.maxDocSize = cfg.Files.MaxDocumentSize()
+	d.related = cfg.Related
+	d.limits = cfg.Limits
+	d.protected = protectedMatcher(cfg.Files.Protected)
+	d.protectedMode = cfg.Files.ProtectedMode
+	d.branches = cfg.Git
+	d.autoOpen = cfg.Files.AutoOpen
+	d.documents = cfg.Documents
+	d.documentRules = documentMatchers(cfg.Documents.Rules)
+	d.guardrails = cfg.Guardrails
+	d.reviewing = cfg.Review.Enabled
+	d.previewing = cfg.Review.PreviewCreated
+	d.foldLines = cfg.Review.FoldThreshold()
+	d.requests = cfg.Requests
+	d.agent = cfg.Agent
+	d.jobConfig = cfg.Jobs
+	d.format = cfg.Format
+	d.formatters = formatters(cfg.Format.Rules)
+	d.languages = languageProfiles(cfg.Languages)
+	d.todoMarkers = cfg.Diagnostics.Markers
+	d.faultsEnabled = cfg.Debug.Faults
+	if cfg.Debug.Soak {
+		d.soak = soak.New(cfg.Debug.SoakSamples(), d.soakGauges()...)
+		d.soakInterval = cfg.Debug.SoakInterval()
+	}
+	d.quota = quota.New(quota.Limits{
+		EditsPerMinute:    cfg.Limits.EditsPerMinute,
+		BytesPerMinute:    cfg.Limits.BytesPerMinute,
+		FilesPerOperation: cfg.Limits.FilesPerOperation,
+	})
+}
+
+// newDaemon creates a daemon serving connections from listener.
+func newDaemon(logger *log.Logger, listener net.Listener) *Daemon {
+	logs := newLogForwarder(logger.Prefix(), logger.Flags())
+	return &Daemon{
+		logger:          log.New(io.MultiWriter(logger.Writer(), logs), logger.Prefix(), logger.Flags()),
+		logs:            logs,
+		inflight:        &inflightRequests{requests: make(map[spanKey]inflightRequest)},
+		pages:           newPageSnapshots(),
 		listener:        listener,
+		state:           state.NewState(),
 		clients:         make(map[string]net.Conn),
 		pendingRequests: make(map[int]bool),
+		relayed:         make(map[int]relayedRequest),
+		progressTokens:  make(map[string]string),
+		inlayHints:      make(map[string][]lsp.InlayHint),
+		codeLenses:      make(map[string][]lsp.AICodeLens),
 		documentState:   make(map[string]string),
 		neovimOpenDocs:  make(map[string]bool),
+		languageIDs:     make(map[string]string),
+		surfaced:        make(map[string]bool),
+		willSaveTimeout: defaultWillSaveTimeout,
+		commandTimeout:  defaultCommandTimeout,
+		verifyInterval:  defaultVerifyInterval,
+		verifyDelay:     defaultVerifyDelay,
+		verifyTimers:    make(map[string]clock.Timer),
+		completionCache: make(map[string]completionEntry),
+		clientSettings:  make(map[string]lsp.InitializationOptions),
+		clientInfo:      make(map[string]lsp.ClientInfo),
+		mcpTools:        make(map[string][]string),
+		subscriptions:   make(map[string]lsp.SubscribeParams),
+		runningJobs:     make(map[int]*runningJob),
+		jobDiagnostics:  make(map[string]map[string][]lsp.Diagnostic),
+		lintPending:     make(map[string]lintRequest),
+		reviews:         make(map[string]*review),
+		previews:        make(map[string]preview),
+		baselines:       make(map[string]string),
+		scratches:       make(map[string]bool),
+		lintWake:        make(chan struct{}, 1),
+		routes:          make(map[string]string),
+		journal:         journal.New(journalSize),
+		quota:           quota.New(quota.Limits{}),
+		transactions:    make(map[string]*editTransaction),
+		summaryDelay:    defaultSummaryDelay,
+		metrics:         metrics.New(),
+		clock:           clock.Real,
+		spans:           make(map[spanKey]*tracing.Span),
+		inspectors:      make(map[net.Conn]bool),
+		connNumbers:     make(map[net.Conn]int),
+		faults:          &faultInjector{},
+		shuttingDown:    make(map[string]bool),
+		stopRequests:    make(chan string, 1),
+		foldLines:       config.DefaultFoldLines,
 	}
+}
 
-	daemon.run()
+// mcpMethods are the methods MCP clients call. They are served to any
+// client, and identify an unidentified connection as a new MCP client.
+var mcpMethods = map[string]bool{
+	"crush/mcpInitialize":     true,
+	"crush/getEditorContext":  true,
+	"crush/stateVersion":      true,
+	"crush/setLogLevel":       true,
+	"crush/showLocations":     true,
+	"crush/listFiles":         true,
+	"crush/readFile":          true,
+	"crush/writeFile":         true,
+	"crush/recentChanges":     true,
+	"crush/relatedFiles":      true,
+	"crush/history":           true,
+	"crush/undoLastAgentEdit": true,
+	"crush/createCheckpoint":  true,
+	"crush/listCheckpoints":   true,
+	"crush/restoreCheckpoint": true,
+	"crush/editSelections":    true,
+	"crush/runJob":            true,
+	"crush/cancelJob":         true,
+	"crush/getRecentJobs":     true,
+	"crush/listTodos":         true,
+	"crush/exportPatch":       true,
+	"crush/importPatch":       true,
+	"crush/stageFiles":        true,
+	"crush/commit":            true,
+	"crush/openScratch":       true,
+	"crush/appendTranscript":  true,
+	"crush/getTranscript":     true,
+	"crush/renderSnippet":     true,
+	"crush/getEnvironment":    true,
+	"crush/workspaceStats":    true,
+	"crush/generateFile":      true,
+	"crush/editFile":          true,
+	"crush/subscribe":         true,
 }
 
 // Daemon manages connected clients and routes messages between them
 type Daemon struct {
-	logger   *log.Logger
-	listener net.Listener
+	logger    *log.Logger
+	listener  net.Listener
+	workspace string            // Workspace root
+	sessionID string            // Session the daemon serves
+	state     *state.State      // Negotiated client capabilities
+	authToken string            // Token clients must present (empty = no check)
+	strict    bool              // Validate crush/* params against their schemas (--strict)
+	logs      *logForwarder     // Copies log lines to crush/setLogLevel subscribers
+	inflight  *inflightRequests // Requests being served, for $/cancelRequest
+	pages     *pageSnapshots    // Paginated results, by cursor
 
 	mu              sync.RWMutex
-	clients         map[string]net.Conn // "neovim", "crush", or "mcp" -> connection
-	requestID       int                 // Counter for generating unique request IDs
-	pendingRequests map[int]bool        // Request IDs we've sent (to filter responses)
-	documentState   map[string]string   // URI -> last known content (for diffing)
-	neovimOpenDocs  map[string]bool     // URIs of documents open in Neovim
+	clients         map[string]net.Conn                  // "neovim", an agent's or MCP client's ID -> connection
+	clientSettings  map[string]lsp.InitializationOptions // Client -> initializationOptions
+	clientInfo      map[string]lsp.ClientInfo            // Client -> clientInfo from initialize
+	routes          map[string]string                    // Registered method -> handling client
+	requestID       int                                  // Counter for generating unique request IDs
+	mcpClients      int                                  // MCP connections registered so far, for client IDs
+	agentClients    int                                  // Crush connections registered so far, for client IDs
+	agents          []string                             // Connected agents' client IDs, oldest first
+	activeAgent     string                               // Agent that sent a message last
+	mcpTools        map[string][]string                  // MCP client ID -> names of the tools it offers
+	subscriptions   map[string]lsp.SubscribeParams       // Client -> events it asked for with crush/subscribe
+	pendingRequests map[int]bool                         // Request IDs we've sent (to filter responses)
+	relayed         map[int]relayedRequest               // Daemon ID -> peer request awaiting a response
+	progressTokens  map[string]string                    // Open $/progress token -> owning client
+	documentState   map[string]string                    // URI -> last known content (for diffing)
+	neovimOpenDocs  map[string]bool                      // URIs of documents open in Neovim
+	languageIDs     map[string]string                    // URI -> languageId Neovim opened it with (under languageMu)
+	languageMu      sync.RWMutex                         // Guards languageIDs, so they can be read with d.mu held
+	autoOpen        string                               // files.auto_open: "", "show", or "quickfix"
+	documents       config.DocumentsConfig               // didOpen/didClose policies
+	documentRules   []*workspace.Matcher                 // Compiled files of documents.rules
+	surfaced        map[string]bool                      // URIs of Crush's files surfaced in Neovim
+	inlayHints      map[string][]lsp.InlayHint           // URI -> hints published by Crush
+	codeLenses      map[string][]lsp.AICodeLens          // URI -> lenses published by Crush
+	willSaveTimeout time.Duration                        // Max wait for Crush's pre-save edits
+	commandTimeout  time.Duration                        // Max wait for a relayed executeCommand
+	completion      config.CompletionConfig              // AI completion settings
+	completionCache map[string]completionEntry           // Completion key -> Crush's last answer
+	verifyInterval  time.Duration                        // Period of the crush/verifySync sweep
+	verifyDelay     time.Duration                        // Wait after an applyEdit before verifying
+	verifyTimers    map[string]clock.Timer               // URI -> pending post-edit verification
+	desyncs         int                                  // Documents found out of sync
+	fileExcludes    []string                             // Extra patterns hidden from crush/listFiles
+	maxDocSize      int                                  // Bytes over which only a document's metadata is handled (0 = default)
+	fileLists       map[string]*fileList                 // Root -> cached crush/listFiles walk
+	folders         []string                             // Workspace folders besides the root (multi-root)
+	foldersChanged  func(folders []string)               // Called with the folders after they change
+	journal         *journal.Journal                     // Recent document changes
+	related         config.RelatedConfig                 // Related-file suggestion settings
+	relatedIndex    *relatedIndex                        // Cached crush/relatedFiles index
+	history         *history.Store                       // Session transcript (nil = not recorded)
+	limits          config.LimitsConfig                  // Agent edit limits
+	quota           *quota.Limiter                       // Enforces limits per client
+	protected       *workspace.Matcher                   // Files agents may not edit (nil = none)
+	protectedMode   string                               // "deny" or "confirm"
+	branches        config.GitConfig                     // Branches agents may not edit on
+	guardrails      config.GuardrailsConfig              // Edit sizes that need confirmation
+	requests        config.RequestsConfig                // Unanswered-request handling
+	agent           config.AgentConfig                   // Agent launched when Crush doesn't attach
+	agentTimer      clock.Timer                          // Pending agent launch
+	agentRunning    bool                                 // A launched agent has not exited
+	jobConfig       config.JobsConfig                    // Test and build commands for crush/runJob
+	runningJobs     map[int]*runningJob                  // Job ID -> job started by crush/runJob
+	jobSeq          int                                  // Counter for job IDs
+	recentJobs      []lsp.RecentJob                      // Finished jobs for crush/getRecentJobs, oldest first
+	format          config.FormatConfig                  // Formatting of agent edits
+	formatters      []formatter                          // Compiled format.rules
+	languages       map[string]languageProfile           // Compiled languages profiles, by language ID
+	editGroups      []editGroup                          // Recent agent operations, oldest first
+	editGroupSeq    int                                  // Counter for edit group IDs
+	transactions    map[string]*editTransaction          // Agent -> edits not yet summed up
+	summaryDelay    time.Duration                        // Quiet time that ends a transaction
+	metrics         *metrics.Recorder                    // Per-method traffic statistics
+	clock           clock.Clock                          // Drives timers (virtual when replaying)
+	onHandled       func(conn net.Conn)                  // Called after each message from conn is handled (replays wait on it)
+	tracer          *tracing.Tracer                      // Exports request spans (nil = tracing off)
+	spans           map[spanKey]*tracing.Span            // Requests awaiting a response -> their span
+	capture         *capture.Writer                      // Records routed messages (nil = debug.capture off)
+	inspectors      map[net.Conn]bool                    // Clients following traffic via crush/inspect
+	connNumbers     map[net.Conn]int                     // Client connection -> its number in captures
+	connSeq         int                                  // Counter for connection numbers
+	faults          *faultInjector                       // Faults injected into sends (crush/setFaults)
+	faultsEnabled   bool                                 // debug.faults: crush/setFaults is allowed
+	soak            *soak.Monitor                        // Watches for leaks (nil = debug.soak off)
+	soakInterval    time.Duration                        // How often soak samples
+	shuttingDown    map[string]bool                      // Clients that requested shutdown, awaiting exit
+	stopRequests    chan string                          // Reasons of crush/stopDaemon requests, for runDaemon
+
+	// Diagnostics published to Neovim
+	jobDiagnostics map[string]map[string][]lsp.Diagnostic // Job kind -> URI -> diagnostics of its last run
+	linting        bool                                   // Diagnostics providers are configured
+	todoMarkers    []string                               // Comment markers for crush/listTodos (nil = default)
+	lintPending    map[string]lintRequest                 // URI -> text waiting for the providers
+	lintWake       chan struct{}                          // Wakes lintLoop
+	publishMu      sync.Mutex                             // Orders publishDiagnostics' sends
+	fixes          []*pendingFix                          // crush/fixDiagnostic requests awaiting verification, oldest first
+	fixSeq         int                                    // Counter for fix IDs
+
+	// Hunks of Crush's edits staged for review
+	reviewing bool               // review.enabled
+	foldLines int                // Lines an edit spans before it gets a fold hint (0 = never)
+	reviews   map[string]*review // URI -> hunks awaiting the user's decision
+	hunkSeq   int                // Counter for hunk IDs
+	reviewMu  sync.Mutex         // Serializes crush/resolveHunk
+
+	// Files agents created, held back until the user accepts them
+	previewing bool               // review.preview_created
+	previews   map[string]preview // URI -> staged content
+
+	// Texts before the agents' edits, for crush/exportPatch
+	baselines map[string]string // URI -> text before the first agent edit
+
+	// Scratch buffers opened with crush/openScratch
+	scratches  map[string]bool // untitled:// URIs open in Neovim
+	scratchSeq int             // Counter for scratch URIs
+
+	// Machine facts for crush/getEnvironment
+	environment *lsp.EnvironmentResult // Probed once (nil = not yet)
+
+	// Conversation document kept with crush/appendTranscript
+	transcript      string // Markdown, every entry of the session
+	transcriptShown bool   // Neovim has the conversation buffer
 
 	// Cursor tracking for MCP tool
-	cursorURI    string // Current file URI
-	cursorLine   int    // 0-indexed line
-	cursorColumn int    // 0-indexed column
+	cursorURI      string         // Current file URI
+	cursorLine     int            // 0-indexed line
+	cursorColumn   int            // 0-indexed column
+	cursorLineText string         // Text of the cursor's line, for documents not cached
+	cursors        []lsp.Position // Secondary cursors (multi-cursor plugins)
 
 	// Selection tracking (from crush/selectionChanged)
-	selectionText string // Currently selected text (empty if no selection)
+	selectionText string      // Currently selected text (empty if no selection)
+	selections    []lsp.Range // Selected ranges, in Neovim's order
 }
 
 func (d *Daemon) run() {
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.verifyLoop(stop)
+	go d.lintLoop(stop)
+	if d.soak != nil {
+		go d.soakLoop(stop)
+	}
+
 	for {
 		conn, err := d.listener.Accept()
 		if err != nil {
@@ -310,7 +664,17 @@ func (d *Daemon) run() {
 }
 
 func (d *Daemon) handleClient(conn net.Conn) {
+	accepted := conn
+	// All writes to this client, from any goroutine, go through its outbox.
+	out := newOutbox(conn, d.logger, d.tapSent)
+	out.faults = d.faultFor
+	conn = out
 	defer conn.Close()
+	defer d.numberConn(conn)()
+	defer d.detachInspector(conn)
+	defer d.logs.unsubscribe(conn)
+	defer d.inflight.finishAll(conn)
+	defer d.endSpans(conn)
 
 	scanner := bufio.NewScanner(conn)
 	scanner.Split(rpc.Split)
@@ -318,66 +682,152 @@ func (d *Daemon) handleClient(conn net.Conn) {
 
 	var clientName string
 
-	for scanner.Scan() {
+	for ; scanner.Scan(); d.handled(accepted) {
 		msg := scanner.Bytes()
 
 		// Check for MCP-specific requests first (these don't require identification)
 		method, content, _ := rpc.DecodeMessage(msg)
+		if method != "" {
+			d.metrics.Message(method, len(msg))
+		}
+		d.tap(capture.Recv, clientName, conn, msg)
+
+		// In strict mode, crush/* messages must match their schemas
+		if !d.checkSchema(method, content, conn) {
+			continue
+		}
+
+		// Cancellations of requests the daemon serves itself aren't forwarded
+		if method == "$/cancelRequest" && d.cancelRequest(conn, content) {
+			continue
+		}
 
 		// Handle MCP-specific methods (these don't require prior identification)
-		if method == "crush/getEditorContext" || method == "crush/showLocations" {
+		if mcpMethods[method] {
 			if clientName == "" {
-				clientName = "mcp"
-				d.logger.Printf("Client identified: %s (from %s)", clientName, method)
-				d.mu.Lock()
-				d.clients[clientName] = conn
-				d.mu.Unlock()
+				var req struct {
+					Params struct {
+						AuthToken string `json:"authToken"`
+					} `json:"params"`
+				}
+				_ = json.Unmarshal(content, &req)
+				if !d.authorized(req.Params.AuthToken) {
+					d.logger.Printf("Rejecting MCP client: %v", errUnauthorized)
+					d.respondError(conn, messageID(content), lsp.InvalidRequest, errUnauthorized.Error())
+					return
+				}
 
-				defer func() {
-					d.mu.Lock()
-					delete(d.clients, clientName)
-					noClients := len(d.clients) == 0
-					d.mu.Unlock()
-					d.logger.Printf("Client disconnected: %s", clientName)
-
-					// Exit daemon if no clients remain
-					if noClients {
-						d.logger.Println("No clients remaining, shutting down")
-						d.listener.Close()
-					}
-				}()
+				clientName = d.registerMCP(conn, method, content)
+				defer d.unregisterClient(clientName)
 			}
 
-			if method == "crush/getEditorContext" {
-				d.handleGetEditorContext(content, conn)
-			} else if method == "crush/showLocations" {
+			// Edits and history are kept per client type
+			from := clientType(clientName)
+			d.recordRequest(from, method, content)
+			d.traceRequest(conn, from, method, content)
+			switch method {
+			case "crush/mcpInitialize":
+				d.handleMCPInitialize(clientName, content, conn)
+			case "crush/getEditorContext":
+				// May ask Neovim for definitions, so don't block Neovim's own loop
+				go d.handleGetEditorContext(bytes.Clone(content), conn)
+			case "crush/stateVersion":
+				d.handleStateVersion(content, conn)
+			case "crush/setLogLevel":
+				d.handleSetLogLevel(content, conn)
+			case "crush/showLocations":
 				d.forwardToNeovim(msg)
+			case "crush/listFiles":
+				d.handleListFiles(content, conn)
+			case "crush/readFile":
+				// May ask Neovim for its buffer, so don't block Neovim's own loop
+				go d.handleReadFile(bytes.Clone(content), conn)
+			case "crush/renderSnippet":
+				go d.handleRenderSnippet(bytes.Clone(content), conn)
+			case "crush/workspaceStats":
+				// Counts lines on disk
+				go d.handleWorkspaceStats(bytes.Clone(content), conn)
+			case "crush/getEnvironment":
+				// Runs the tools' version commands
+				go d.handleGetEnvironment(bytes.Clone(content), conn)
+			case "crush/writeFile":
+				go d.handleWriteFile(from, bytes.Clone(content), conn)
+			case "crush/generateFile":
+				// Reads the source file and the project's conventions
+				go d.handleGenerateFile(from, bytes.Clone(content), conn)
+			case "crush/recentChanges":
+				d.handleRecentChanges(content, conn)
+			case "crush/relatedFiles":
+				// Building the index can take a while
+				go d.handleRelatedFiles(bytes.Clone(content), conn)
+			case "crush/history":
+				d.handleHistory(content, conn)
+			case "crush/undoLastAgentEdit":
+				go d.handleUndoLastAgentEdit(bytes.Clone(content), conn)
+			case "crush/createCheckpoint":
+				// May ask Neovim for unsaved buffers
+				go d.handleCreateCheckpoint(bytes.Clone(content), conn)
+			case "crush/listCheckpoints":
+				d.handleListCheckpoints(content, conn)
+			case "crush/restoreCheckpoint":
+				go d.handleRestoreCheckpoint(bytes.Clone(content), conn)
+			case "crush/editSelections":
+				go d.handleEditSelections(from, bytes.Clone(content), conn)
+			case "crush/editFile":
+				go d.handleEditFile(from, bytes.Clone(content), conn)
+			case "crush/subscribe":
+				d.handleSubscribe(clientName, content, conn)
+			case "crush/runJob":
+				go d.handleRunJob(clientName, bytes.Clone(content), conn)
+			case "crush/cancelJob":
+				d.handleCancelJob(content, conn)
+			case "crush/getRecentJobs":
+				d.handleGetRecentJobs(content, conn)
+			case "crush/listTodos":
+				// Reads every workspace file
+				go d.handleListTodos(bytes.Clone(content), conn)
+			case "crush/exportPatch":
+				// May read unsynced files
+				go d.handleExportPatch(bytes.Clone(content), conn)
+			case "crush/importPatch":
+				go d.handleImportPatch(from, bytes.Clone(content), conn)
+			case "crush/stageFiles":
+				// Waits for the user's confirmation
+				go d.handleStageFiles(bytes.Clone(content), conn)
+			case "crush/commit":
+				go d.handleCommit(bytes.Clone(content), conn)
+			case "crush/openScratch":
+				d.handleOpenScratch(content, conn)
+			case "crush/appendTranscript":
+				d.handleAppendTranscript(from, content, conn)
+			case "crush/getTranscript":
+				d.handleGetTranscript(content, conn)
 			}
 			continue
 		}
 
 		// Parse to identify client from initialize request
 		if clientName == "" {
-			clientName, _ = d.handleInitialize(msg, conn)
+			var err error
+			clientName, err = d.handleInitialize(msg, conn)
+			if errors.Is(err, errUnauthorized) {
+				d.logger.Printf("Rejecting client: %v", err)
+				return
+			}
 			if clientName != "" {
 				d.logger.Printf("Client identified: %s", clientName)
 				d.mu.Lock()
 				d.clients[clientName] = conn
+				if clientType(clientName) == "crush" {
+					d.agents = append(d.agents, clientName)
+				}
 				d.mu.Unlock()
+				d.announcePresence("crush/clientConnected", d.presenceOf(clientName))
+				if clientName == "neovim" {
+					d.scheduleAgentLaunch()
+				}
 
-				defer func() {
-					d.mu.Lock()
-					delete(d.clients, clientName)
-					noClients := len(d.clients) == 0
-					d.mu.Unlock()
-					d.logger.Printf("Client disconnected: %s", clientName)
-
-					// Exit daemon if no clients remain
-					if noClients {
-						d.logger.Println("No clients remaining, shutting down")
-						d.listener.Close()
-					}
-				}()
+				defer d.unregisterClient(clientName)
 			}
 			continue // Don't forward initialize, we responded to it
 		}
@@ -387,6 +837,36 @@ func (d *Daemon) handleClient(conn net.Conn) {
 			continue
 		}
 
+		if method == "exit" {
+			d.logger.Printf("Client %s exited", clientName)
+			return // Queued messages are flushed as the connection closes
+		}
+
+		if d.refuseAfterShutdown(clientName, method, content, conn) {
+			continue
+		}
+
+		d.recordRequest(clientName, method, content)
+		d.traceRequest(conn, clientName, method, content)
+		if clientType(clientName) == "crush" {
+			d.touchAgent(clientName)
+		}
+
+		if method == "shutdown" {
+			d.handleShutdown(clientName, content, conn)
+			continue
+		}
+
+		if method == "crush/registerMethod" {
+			d.handleRegisterMethod(clientName, content, conn)
+			continue
+		}
+
+		if method == "crush/unregisterMethod" {
+			d.handleUnregisterMethod(clientName, content, conn)
+			continue
+		}
+
 		// Handle crush/cursorMoved from Neovim
 		if method == "crush/cursorMoved" {
 			d.handleCursorMoved(content)
@@ -399,12 +879,128 @@ func (d *Daemon) handleClient(conn net.Conn) {
 			continue
 		}
 
+		if method == "textDocument/willSaveWaitUntil" && clientName == "neovim" {
+			d.handleWillSaveWaitUntil(content, conn)
+			continue
+		}
+
+		if method == "crush/inlayHints" && clientType(clientName) == "crush" {
+			d.handleInlayHints(content)
+			continue
+		}
+
+		if method == "textDocument/inlayHint" && clientName == "neovim" {
+			d.handleInlayHintRequest(content, conn)
+			continue
+		}
+
+		if method == "crush/codeLens" && clientType(clientName) == "crush" {
+			d.handleCodeLens(content)
+			continue
+		}
+
+		if method == "textDocument/codeLens" && clientName == "neovim" {
+			d.handleCodeLensRequest(content, conn)
+			continue
+		}
+
+		if method == "workspace/executeCommand" && messageID(content) != nil {
+			d.handleExecuteCommand(clientName, content, conn)
+			continue
+		}
+
+		if method == "textDocument/completion" && clientName == "neovim" && d.completion.Enabled {
+			d.handleCompletion(content, conn)
+			continue
+		}
+
+		if method == "crush/resyncDocument" {
+			go d.handleResyncDocument(bytes.Clone(content), conn)
+			continue
+		}
+
+		if method == "crush/getState" {
+			d.handleGetState(content, conn)
+			continue
+		}
+
+		if method == "crush/sessionInfo" {
+			d.handleSessionInfo(content, conn)
+			continue
+		}
+
+		if method == "crush/getMetrics" {
+			d.handleGetMetrics(content, conn)
+			continue
+		}
+
+		if method == "crush/daemonStatus" {
+			d.handleDaemonStatus(content, conn)
+			continue
+		}
+
+		if method == "crush/stopDaemon" {
+			d.handleStopDaemon(clientName, content, conn)
+			continue
+		}
+
+		if method == "crush/inspect" {
+			d.handleInspect(content, conn)
+			continue
+		}
+
+		if method == "crush/setFaults" {
+			d.handleSetFaults(content, conn)
+			continue
+		}
+
+		if method == "workspace/applyEdit" && clientType(clientName) == "crush" && messageID(content) != nil {
+			if msg = d.guardApplyEdit(msg, content, conn); msg == nil {
+				continue
+			}
+			msg = d.groupApplyEdit(msg)
+		}
+
+		if method == "$/progress" {
+			d.trackProgress(clientName, content)
+		}
+
+		if method == "workspace/didChangeWorkspaceFolders" && clientName == "neovim" {
+			d.handleDidChangeWorkspaceFolders(content)
+		}
+
 		// Track cursor position from Neovim requests
 		if clientName == "neovim" {
 			d.trackCursorFromRequest(method, content)
 			d.trackNeovimDocuments(method, content)
 		}
 
+		if method == "textDocument/codeAction" && clientName == "neovim" {
+			d.handleCodeActionRequest(content, conn)
+			continue
+		}
+
+		if method == "crush/fixDiagnostic" && clientName == "neovim" {
+			d.handleFixDiagnostic(content, conn)
+			continue
+		}
+
+		if method == "crush/resolveHunk" && clientName == "neovim" {
+			// Waits for Neovim to apply the hunk
+			go d.handleResolveHunk(bytes.Clone(content), conn)
+			continue
+		}
+
+		if method == "crush/resolvePreview" && clientName == "neovim" {
+			d.handleResolvePreview(content, conn)
+			continue
+		}
+
+		if method == "crush/listHunks" {
+			d.handleListHunks(content, conn)
+			continue
+		}
+
 		// Filter out responses to our own requests (from Neovim responding to workspace/applyEdit)
 		if method == "" && clientName == "neovim" {
 			// No method means this is a response, check if it's to one of our requests
@@ -424,7 +1020,9 @@ func (d *Daemon) handleClient(conn net.Conn) {
 		}
 
 		// Forward to peer
-		d.forwardToPeer(clientName, msg)
+		ctx, cancel := d.forwardContext(method)
+		d.forwardToPeer(ctx, clientName, msg)
+		cancel()
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -432,6 +1030,53 @@ func (d *Daemon) handleClient(conn net.Conn) {
 	}
 }
 
+// unregisterClient removes a disconnected client and cleans up state it
+// owned. The daemon exits when the last client leaves.
+func (d *Daemon) unregisterClient(clientName string) {
+	who := d.presenceOf(clientName)
+
+	d.mu.Lock()
+	delete(d.clients, clientName)
+	delete(d.clientSettings, clientName)
+	delete(d.clientInfo, clientName)
+	delete(d.mcpTools, clientName)
+	delete(d.subscriptions, clientName)
+	delete(d.shuttingDown, clientName)
+	d.agents = slices.DeleteFunc(d.agents, func(name string) bool { return name == clientName })
+	noAgents := len(d.agents) == 0
+	if clientName == "neovim" {
+		clear(d.surfaced) // A new Neovim hasn't seen them
+		clear(d.reviews)  // Staged against its buffers
+		clear(d.previews) // Its preview buffers are gone
+		for uri := range d.scratches {
+			// Scratch buffers don't outlive Neovim
+			delete(d.documentState, uri)
+			delete(d.neovimOpenDocs, uri)
+		}
+		clear(d.scratches)
+		d.transcriptShown = false
+	}
+	noClients := len(d.clients) == 0
+	d.mu.Unlock()
+	d.logger.Printf("Client disconnected: %s", clientName)
+	d.announcePresence("crush/clientDisconnected", who)
+
+	d.state.RemoveClientCapabilities(clientName)
+	d.dropRoutes(clientName)
+	d.dropRelayed(clientName)
+	d.endOrphanedProgress(clientName)
+	if clientType(clientName) == "crush" && noAgents {
+		d.clearAllInlayHints()
+		d.clearAllCodeLenses()
+	}
+
+	// Exit daemon if no clients remain
+	if noClients {
+		d.logger.Println("No clients remaining, shutting down")
+		d.listener.Close()
+	}
+}
+
 // handleInitialize processes the initialize request and sends a response.
 // Returns the identified client name and any error.
 func (d *Daemon) handleInitialize(msg []byte, conn net.Conn) (string, error) {
@@ -448,9 +1093,10 @@ func (d *Daemon) handleInitialize(msg []byte, conn net.Conn) (string, error) {
 	var req struct {
 		ID     any `json:"id"`
 		Params struct {
-			ClientInfo struct {
-				Name string `json:"name"`
-			} `json:"clientInfo"`
+			ClientInfo            lsp.ClientInfo         `json:"clientInfo"`
+			Capabilities          lsp.ClientCapabilities `json:"capabilities"`
+			InitializationOptions json.RawMessage        `json:"initializationOptions"`
+			WorkspaceFolders      []lsp.WorkspaceFolder  `json:"workspaceFolders"`
 		} `json:"params"`
 	}
 
@@ -461,6 +1107,33 @@ func (d *Daemon) handleInitialize(msg []byte, conn net.Conn) (string, error) {
 	// Identify client first to determine capabilities
 	clientName := identifyClientName(req.Params.ClientInfo.Name)
 
+	opts, err := parseInitializationOptions(req.Params.InitializationOptions)
+	if err != nil {
+		d.logger.Printf("Ignoring malformed initializationOptions from %s: %v", clientName, err)
+	}
+	if !d.authorized(opts.AuthToken) {
+		id, _ := json.Marshal(req.ID)
+		d.respondError(conn, id, lsp.InvalidRequest, errUnauthorized.Error())
+		return "", errUnauthorized
+	}
+	if opts.PluginVersion != "" {
+		d.logger.Printf("%s plugin version %s", clientName, opts.PluginVersion)
+	}
+
+	d.mu.Lock()
+	if clientName == "crush" {
+		clientName = d.agentIDLocked()
+	}
+	d.clientSettings[clientName] = opts
+	d.clientInfo[clientName] = req.Params.ClientInfo
+	d.mu.Unlock()
+
+	d.state.SetClientCapabilities(clientName, req.Params.Capabilities)
+
+	if clientName == "neovim" {
+		d.setFolders(d.folderPaths(req.Params.WorkspaceFolders))
+	}
+
 	// Different capabilities for different clients
 	var changeSync int
 	if clientName == "neovim" {
@@ -469,22 +1142,46 @@ func (d *Daemon) handleInitialize(msg []byte, conn net.Conn) (string, error) {
 		changeSync = 2 // Incremental - Crush sends us changes to forward to Neovim
 	}
 
+	capabilities := map[string]any{
+		"textDocumentSync": map[string]any{
+			"openClose":         true,
+			"change":            changeSync,
+			"willSaveWaitUntil": clientName == "neovim",
+		},
+		"inlayHintProvider": clientName == "neovim",
+		"workspace": map[string]any{
+			"workspaceFolders": map[string]any{
+				"supported":           true,
+				"changeNotifications": true,
+			},
+		},
+		"experimental": map[string]any{
+			"cursorSync":    true,
+			"selectionSync": true,
+			"editorContext": true,
+		},
+	}
+	if clientName == "neovim" && d.completion.Enabled {
+		capabilities["completionProvider"] = map[string]any{}
+	}
+	if clientName == "neovim" {
+		// AI code lenses and code actions, executed through
+		// crush.executeLens and crush.invokeAction
+		capabilities["codeLensProvider"] = map[string]any{}
+		capabilities["codeActionProvider"] = map[string]any{
+			"codeActionKinds": []string{lsp.CodeActionKindExplain, lsp.CodeActionKindGenerateTest, lsp.CodeActionKindFix},
+		}
+		capabilities["executeCommandProvider"] = map[string]any{
+			"commands": []string{lsp.ExecuteLensCommand, lsp.InvokeActionCommand},
+		}
+	}
+
 	// Send initialize response
 	response := map[string]any{
 		"jsonrpc": "2.0",
 		"id":      req.ID,
 		"result": map[string]any{
-			"capabilities": map[string]any{
-				"textDocumentSync": map[string]any{
-					"openClose": true,
-					"change":    changeSync,
-				},
-				"experimental": map[string]any{
-					"cursorSync":    true,
-					"selectionSync": true,
-					"editorContext": true,
-				},
-			},
+			"capabilities": capabilities,
 			"serverInfo": map[string]any{
 				"name":    "neocrush",
 				"version": version,
@@ -516,29 +1213,59 @@ func identifyClientName(name string) string {
 	}
 }
 
-func (d *Daemon) forwardToPeer(fromClient string, msg []byte) {
-	var peerName string
-	switch fromClient {
-	case "neovim":
-		peerName = "crush"
-	case "crush":
-		peerName = "neovim"
-	default:
+// forwardToPeer routes msg from fromClient to its peer, transformed for it.
+// Work the transform does, like reading a file's baseline, is abandoned
+// when ctx is done.
+func (d *Daemon) forwardToPeer(ctx context.Context, fromClient string, msg []byte) {
+	method, content, err := rpc.DecodeMessage(msg)
+	target := d.routeFor(method, fromClient)
+
+	// Requests travel under daemon-assigned IDs; responses to them are
+	// restored to the ID the original sender used and returned to it. This
+	// runs before the transform so requests the daemon itself generates are
+	// left alone.
+	isResponse := err == nil && method == "" && messageID(content) != nil
+	if isResponse {
+		if relayed, to, ok := d.relayResponse(content); ok {
+			if relayed == nil {
+				return
+			}
+			msg = relayed
+			target = to
+		}
+	}
+
+	if target == "" {
 		return // Unknown client, don't forward
 	}
 
 	d.mu.RLock()
-	peer, ok := d.clients[peerName]
+	peer, ok := d.clients[target]
 	d.mu.RUnlock()
 
 	if !ok {
-		d.logger.Printf("Peer %s not connected, cannot forward", peerName)
+		d.logger.Printf("Peer %s not connected, cannot forward", target)
 		return // Peer not connected
 	}
 
+	if err == nil && method != "" && messageID(content) != nil {
+		var onResponse func([]byte)
+		if clientType(fromClient) == "crush" && method == "workspace/applyEdit" {
+			onResponse = d.surfaceCreated(content)
+		}
+		if relayed, relayID := d.relayRequest(fromClient, method, content, onResponse); relayed != nil {
+			msg = relayed
+			d.watchRelayed(relayID)
+		}
+	}
+
 	// Transform messages from Crush to Neovim
-	if fromClient == "crush" && peerName == "neovim" {
-		transformed := d.transformCrushToNeovim(msg)
+	if clientType(fromClient) == "crush" && target == "neovim" {
+		uri, before := d.agentEditBaseline(method, content)
+		transformed := d.transformCrushToNeovim(ctx, msg)
+		if uri != "" {
+			d.shareAgentEdit(fromClient, uri, before)
+		}
 		if transformed != nil {
 			msg = transformed
 		} else {
@@ -546,8 +1273,25 @@ func (d *Daemon) forwardToPeer(fromClient string, msg []byte) {
 		}
 	}
 
-	if _, err := peer.Write(msg); err != nil {
-		d.logger.Printf("Failed to forward to %s: %v", peerName, err)
+	// And from Neovim to Crush
+	recipients := map[string]net.Conn{target: peer}
+	if fromClient == "neovim" && clientType(target) == "crush" {
+		if msg = d.transformNeovimToCrush(msg); msg == nil {
+			return
+		}
+		if err == nil && method != "" && messageID(content) == nil {
+			recipients = d.agentConns() // Editor events go to every agent
+		}
+	}
+
+	for name, conn := range recipients {
+		if _, err := conn.Write(msg); err != nil {
+			d.reportError(lsp.ErrorParams{
+				Code:    lsp.ErrorCodeForward,
+				Message: fmt.Sprintf("failed to forward to %s: %v", name, err),
+				Method:  method,
+			})
+		}
 	}
 }
 
@@ -563,13 +1307,46 @@ func (d *Daemon) forwardToNeovim(msg []byte) {
 	}
 
 	if _, err := neovim.Write(msg); err != nil {
-		d.logger.Printf("Failed to forward to neovim: %v", err)
+		method, _, _ := rpc.DecodeMessage(msg)
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeForward,
+			Message: fmt.Sprintf("failed to forward to neovim: %v", err),
+			Method:  method,
+		})
+	}
+}
+
+// requestNeovim sends a daemon-originated request to Neovim. The response is
+// consumed by the pendingRequests filter rather than forwarded to Crush.
+func (d *Daemon) requestNeovim(method string, params any) {
+	d.mu.Lock()
+	neovim, ok := d.clients["neovim"]
+	if !ok {
+		d.mu.Unlock()
+		return
+	}
+	d.requestID++
+	requestID := d.requestID
+	d.pendingRequests[requestID] = true
+	d.mu.Unlock()
+
+	request := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      requestID,
+		"method":  method,
+	}
+	if params != nil {
+		request["params"] = params
+	}
+
+	if _, err := neovim.Write([]byte(rpc.EncodeMessage(request))); err != nil {
+		d.logger.Printf("Failed to send %s to neovim: %v", method, err)
 	}
 }
 
 // transformCrushToNeovim transforms LSP messages from Crush into messages Neovim understands.
 // Returns the transformed message, or nil if the message should not be forwarded.
-func (d *Daemon) transformCrushToNeovim(msg []byte) []byte {
+func (d *Daemon) transformCrushToNeovim(ctx context.Context, msg []byte) []byte {
 	method, content, err := rpc.DecodeMessage(msg)
 	if err != nil {
 		return msg // Pass through if we can't decode
@@ -578,13 +1355,22 @@ func (d *Daemon) transformCrushToNeovim(msg []byte) []byte {
 	switch method {
 	case "textDocument/didChange":
 		// Transform didChange into workspace/applyEdit
-		return d.didChangeToApplyEdit(content)
+		return d.didChangeToApplyEdit(ctx, content)
 	case "textDocument/didOpen":
-		// Could send window/showDocument to open in Neovim
-		d.logger.Printf("Crush opened file, consider notifying Neovim")
-		return nil // Don't forward raw didOpen
+		var didOpen lsp.DidOpenTextDocumentNotification
+		if err := json.Unmarshal(content, &didOpen); err == nil {
+			doc := didOpen.Params.TextDocument
+			d.surfaceFile(doc.URI)
+			if reason := d.contentSkip(doc.Text); reason != "" {
+				d.skipDocument(method, doc.URI, reason, len(doc.Text))
+				return nil
+			}
+		}
+		return d.applyDocumentPolicy("crush", method, msg, content)
 	case "textDocument/didClose":
-		return nil // Don't forward
+		return d.applyDocumentPolicy("crush", method, msg, content)
+	case "workspace/applyEdit":
+		return d.adaptSnippetEdits(msg, content)
 	default:
 		return msg // Forward other messages as-is
 	}
@@ -592,7 +1378,8 @@ func (d *Daemon) transformCrushToNeovim(msg []byte) []byte {
 
 // didChangeToApplyEdit converts a textDocument/didChange notification into a workspace/applyEdit request.
 // Uses line-based diffing to only send changed regions, preserving unsaved changes in other parts of the buffer.
-func (d *Daemon) didChangeToApplyEdit(content []byte) []byte {
+// Reading the baseline from disk is abandoned when ctx is done.
+func (d *Daemon) didChangeToApplyEdit(ctx context.Context, content []byte) []byte {
 	var didChange struct {
 		Params struct {
 			TextDocument struct {
@@ -606,7 +1393,11 @@ func (d *Daemon) didChangeToApplyEdit(content []byte) []byte {
 	}
 
 	if err := json.Unmarshal(content, &didChange); err != nil {
-		d.logger.Printf("Failed to parse didChange: %v", err)
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeParse,
+			Message: fmt.Sprintf("failed to parse didChange: %v", err),
+			Method:  "textDocument/didChange",
+		})
 		return nil
 	}
 
@@ -617,6 +1408,14 @@ func (d *Daemon) didChangeToApplyEdit(content []byte) []byte {
 	// Get the new content (Crush sends full document)
 	newText := didChange.Params.ContentChanges[0].Text
 	uri := didChange.Params.TextDocument.URI
+	if reason := d.contentSkip(newText); reason != "" {
+		d.skipDocument("textDocument/didChange", uri, reason, len(newText))
+		return nil
+	}
+
+	// Agent output lands formatted; Crush gets the formatted text back so
+	// its copy matches
+	newText, formatted := d.formatContent(uri, newText)
 
 	// Get previous state for diffing
 	d.mu.Lock()
@@ -624,6 +1423,14 @@ func (d *Daemon) didChangeToApplyEdit(content []byte) []byte {
 	d.documentState[uri] = newText
 	neovimHasFile := d.neovimOpenDocs[uri]
 	d.mu.Unlock()
+	d.state.Touch()
+	d.fixEdited(uri)
+	d.lint(uri, "", newText, didChange.Params.TextDocument.Version)
+	if formatted {
+		if err := d.sendDocumentChanged(uri, newText, "formatter"); err != nil {
+			d.logger.Printf("Failed to send formatted %s to crush: %v", uri, err)
+		}
+	}
 
 	var edits []map[string]any
 
@@ -636,58 +1443,41 @@ func (d *Daemon) didChangeToApplyEdit(content []byte) []byte {
 		// Compute diff to find which lines changed
 		if !hasOld {
 			if path, err := uriToPath(uri); err == nil {
-				if data, err := os.ReadFile(path); err == nil {
+				if text, err := readDiskText(ctx, path); err == nil {
 					// Disk has new content, we need oldText from before
 					// But we don't have it - use newText to find the region
 					// and send a no-op that replaces it with itself
-					oldText = string(data)
+					oldText = text
 					hasOld = true
 				}
 			}
 		}
+		if formatted {
+			// Crush saved its unformatted text
+			d.saveFormatted(uri, newText)
+		}
 
-		// Find the changed region by diffing old vs new
-		realEdits := computeLineEdits(oldText, newText)
-		if len(realEdits) == 0 {
+		edits = noOpEdits(oldText, newText)
+		if len(edits) == 0 {
 			d.logger.Printf("No changes detected for %s", uri)
 			return nil
 		}
-
-		// Create no-op edits: replace each region with the NEW content (same as disk)
-		for _, edit := range realEdits {
-			rangeData := edit["range"].(map[string]any)
-			startLine := rangeData["start"].(map[string]any)["line"].(int)
-			endLine := rangeData["end"].(map[string]any)["line"].(int)
-
-			// Get the lines from newText that correspond to this range
-			newLines := strings.Split(newText, "\n")
-			var replacementLines []string
-			for i := startLine; i < endLine && i < len(newLines); i++ {
-				replacementLines = append(replacementLines, newLines[i])
-			}
-			replacementText := strings.Join(replacementLines, "\n")
-			if len(replacementLines) > 0 && endLine <= len(newLines) {
-				replacementText += "\n"
-			}
-
-			// No-op: replace the range with what's already there (from disk/newText)
-			edits = append(edits, map[string]any{
-				"range":   rangeData,
-				"newText": replacementText,
-			})
-		}
 	} else {
 		// Neovim has the file open - send the real diff
 		if !hasOld {
 			// First time seeing this file - read from disk as baseline
 			if path, err := uriToPath(uri); err == nil {
-				if data, err := os.ReadFile(path); err == nil {
-					oldText = string(data)
+				if text, err := readDiskText(ctx, path); err == nil {
+					oldText = text
 					hasOld = true
 				}
 			}
 		}
 
+		if !hasOld {
+			d.logger.Printf("No baseline for %s, sending whole document", uri)
+		}
+
 		// Compute line-based diff
 		edits = computeLineEdits(oldText, newText)
 		if len(edits) == 0 {
@@ -697,6 +1487,33 @@ func (d *Daemon) didChangeToApplyEdit(content []byte) []byte {
 	}
 
 	d.logger.Printf("Crush changed file: %s (%d edits, neovim_open=%v)", uri, len(edits), neovimHasFile)
+	if err := d.checkEdit("crush", "textDocument/didChange", documentEditStats(uri, edits)); err != nil {
+		// Neovim's buffer keeps its content; the sync check resets Crush's
+		// copy to match
+		d.scheduleVerify(uri)
+		return nil
+	}
+	d.recordChange("crush", uri, oldText, newText)
+
+	d.clearInlayHints(uri)
+	d.clearCodeLenses(uri)
+	d.clearCompletions(uri)
+
+	if !d.state.Capabilities().ApplyEdit {
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeTransform,
+			Message: "neovim does not support workspace/applyEdit, not applying edit to " + extractFilename(uri),
+			Method:  "textDocument/didChange",
+			URI:     uri,
+		})
+		return nil
+	}
+
+	if d.reviewing && neovimHasFile && !isScratch(uri) {
+		// Neovim's buffer keeps its text until the user accepts hunks
+		d.stageReview(uri, oldText, newText)
+		return nil
+	}
 
 	// Create workspace/applyEdit request with incremental edits
 	d.mu.Lock()
@@ -705,23 +1522,69 @@ func (d *Daemon) didChangeToApplyEdit(content []byte) []byte {
 	d.pendingRequests[requestID] = true
 	d.mu.Unlock()
 
+	workspaceEdit := d.workspaceEdit(uri, edits)
+
+	d.scheduleVerify(uri)
+
+	params := d.withFoldHint(map[string]any{
+		"label": "Crush edit",
+		"edit":  workspaceEdit,
+	}, uri, oldText, newText)
+	if neovimHasFile {
+		// A real change to the buffer, which can be undone as one step
+		params["editGroup"] = d.beginEditGroup("Crush edit: "+extractFilename(uri), []string{uri})
+	}
+
 	applyEdit := map[string]any{
 		"jsonrpc": "2.0",
 		"id":      requestID,
 		"method":  "workspace/applyEdit",
-		"params": map[string]any{
-			"label": "Crush edit",
-			"edit": map[string]any{
-				"changes": map[string]any{
-					uri: edits,
-				},
-			},
-		},
+		"params":  params,
 	}
 
 	return []byte(rpc.EncodeMessage(applyEdit))
 }
 
+// workspaceEdit wraps edits to uri in a WorkspaceEdit, annotated for
+// confirmation when Neovim turned autoApply off, uri is protected in
+// confirm mode, or the edits cross the guardrails.
+func (d *Daemon) workspaceEdit(uri string, edits []map[string]any) map[string]any {
+	protected := d.confirmProtected(uri)
+	stats := documentEditStats(uri, edits)
+	large := d.oversized(stats)
+	if d.autoApply() && !protected && !large {
+		return map[string]any{
+			"changes": map[string]any{
+				uri: edits,
+			},
+		}
+	}
+
+	// Annotate the edits so the client prompts
+	annotation, label := "crush", "Crush edit"
+	switch {
+	case protected:
+		annotation, label = protectedAnnotation, d.protectedLabel()
+	case large:
+		annotation, label = largeEditAnnotation, largeEditLabel(stats)
+	}
+	for _, edit := range edits {
+		edit["annotationId"] = annotation
+	}
+	return map[string]any{
+		"documentChanges": []map[string]any{{
+			"textDocument": map[string]any{"uri": uri, "version": nil},
+			"edits":        edits,
+		}},
+		"changeAnnotations": map[string]any{
+			annotation: map[string]any{
+				"label":             label,
+				"needsConfirmation": true,
+			},
+		},
+	}
+}
+
 // uriToPath converts a file:// URI to a local path
 func uriToPath(uri string) (string, error) {
 	if !strings.HasPrefix(uri, "file://") {
@@ -731,71 +1594,68 @@ func uriToPath(uri string) (string, error) {
 }
 
 // computeLineEdits computes minimal line-based edits to transform oldText into newText.
-// Returns a slice of LSP TextEdit objects.
+// Returns a slice of LSP TextEdit objects. When newText breaks lines
+// differently from oldText, the edits are written in oldText's dominant
+// line ending.
 func computeLineEdits(oldText, newText string) []map[string]any {
-	oldLines := strings.Split(oldText, "\n")
-	newLines := strings.Split(newText, "\n")
+	newText = matchLineEnding(oldText, newText)
+	oldLines, newLines := splitLines(oldText), splitLines(newText)
+	start, oldEnd, newEnd, changed := changedLines(oldLines, newLines)
+	if !changed {
+		return nil
+	}
+
+	edit := map[string]any{
+		"range": map[string]any{
+			"start": linePosition(oldLines, start),
+			"end":   linePosition(oldLines, oldEnd),
+		},
+		"newText": strings.Join(newLines[start:newEnd], ""),
+	}
 
+	return []map[string]any{edit}
+}
+
+// changedLines finds the lines that differ between oldLines and newLines,
+// which keep their line endings: oldLines[start:oldEnd] became
+// newLines[start:newEnd].
+func changedLines(oldLines, newLines []string) (start, oldEnd, newEnd int, changed bool) {
 	// Find common prefix
-	prefixLen := 0
-	for prefixLen < len(oldLines) && prefixLen < len(newLines) && oldLines[prefixLen] == newLines[prefixLen] {
-		prefixLen++
+	for start < len(oldLines) && start < len(newLines) && oldLines[start] == newLines[start] {
+		start++
 	}
 
 	// Find common suffix (but don't overlap with prefix)
 	suffixLen := 0
-	for suffixLen < len(oldLines)-prefixLen && suffixLen < len(newLines)-prefixLen &&
+	for suffixLen < len(oldLines)-start && suffixLen < len(newLines)-start &&
 		oldLines[len(oldLines)-1-suffixLen] == newLines[len(newLines)-1-suffixLen] {
 		suffixLen++
 	}
 
-	// The changed region
-	oldStart := prefixLen
-	oldEnd := len(oldLines) - suffixLen
-	newStart := prefixLen
-	newEnd := len(newLines) - suffixLen
-
-	// Clamp to valid ranges to prevent highlight going past buffer length
-	if oldEnd > len(oldLines) {
-		oldEnd = len(oldLines)
-	}
-	if newEnd > len(newLines) {
-		newEnd = len(newLines)
-	}
+	oldEnd, newEnd = len(oldLines)-suffixLen, len(newLines)-suffixLen
+	return start, oldEnd, newEnd, start < oldEnd || start < newEnd
+}
 
-	if oldStart >= oldEnd && newStart >= newEnd {
-		// No changes
+// noOpEdits finds the region that changed between oldText and newText and
+// returns edits replacing it with the NEW content, which is already on disk.
+// Applied to a file Neovim doesn't have open, they open it and highlight the
+// change without doubling the content. The range is in newText, and the
+// text is newText's own, line endings included.
+func noOpEdits(oldText, newText string) []map[string]any {
+	newLines := splitLines(newText)
+	start, _, newEnd, changed := changedLines(splitLines(oldText), newLines)
+	if !changed {
 		return nil
 	}
 
-	// Build the replacement text
-	var replacementLines []string
-	for i := newStart; i < newEnd; i++ {
-		replacementLines = append(replacementLines, newLines[i])
-	}
-	replacementText := strings.Join(replacementLines, "\n")
-
-	// Add trailing newline if we're not at the end and original had content after
-	if newEnd < len(newLines) && len(replacementLines) > 0 {
-		replacementText += "\n"
-	} else if oldEnd < len(oldLines) && len(replacementLines) > 0 {
-		replacementText += "\n"
-	}
-
-	// Handle edge case: if we're replacing lines but keeping suffix, we need the newline
-	if oldEnd < len(oldLines) && newEnd < len(newLines) && len(replacementLines) == 0 {
-		// Deleting lines - no trailing newline needed
-	}
-
-	edit := map[string]any{
+	// No-op: replace the range with what's already there (from disk/newText)
+	return []map[string]any{{
 		"range": map[string]any{
-			"start": map[string]any{"line": oldStart, "character": 0},
-			"end":   map[string]any{"line": oldEnd, "character": 0},
+			"start": linePosition(newLines, start),
+			"end":   linePosition(newLines, newEnd),
 		},
-		"newText": replacementText,
-	}
-
-	return []map[string]any{edit}
+		"newText": strings.Join(newLines[start:newEnd], ""),
+	}}
 }
 
 // trackCursorFromRequest extracts cursor position from LSP requests that include position info.
@@ -826,28 +1686,39 @@ func (d *Daemon) trackCursorFromRequest(method string, content []byte) {
 			d.cursorURI = req.Params.TextDocument.URI
 			d.cursorLine = req.Params.Position.Line
 			d.cursorColumn = req.Params.Position.Character
+			d.cursorLineText, _ = d.cachedLine(d.cursorURI, d.cursorLine)
 			d.mu.Unlock()
+			d.state.Touch()
 			d.logger.Printf("Cursor updated: %s:%d:%d (from %s)", d.cursorURI, d.cursorLine, d.cursorColumn, method)
 		}
 	}
 }
 
-// trackNeovimDocuments tracks which documents Neovim has open.
+// trackNeovimDocuments tracks which documents Neovim has open, and bumps
+// the state version when they change.
 func (d *Daemon) trackNeovimDocuments(method string, content []byte) {
 	switch method {
 	case "textDocument/didOpen":
 		var req struct {
-			Params struct {
-				TextDocument struct {
-					URI string `json:"uri"`
-				} `json:"textDocument"`
-			} `json:"params"`
+			Params lsp.DidOpenTextDocumentParams `json:"params"`
 		}
 		if err := json.Unmarshal(content, &req); err == nil && req.Params.TextDocument.URI != "" {
+			doc := req.Params.TextDocument
 			d.mu.Lock()
-			d.neovimOpenDocs[req.Params.TextDocument.URI] = true
+			d.neovimOpenDocs[doc.URI] = true
 			d.mu.Unlock()
-			d.logger.Printf("Neovim opened: %s", req.Params.TextDocument.URI)
+			if doc.LanguageID != "" {
+				d.languageMu.Lock()
+				d.languageIDs[doc.URI] = doc.LanguageID
+				d.languageMu.Unlock()
+			}
+			d.state.Touch()
+			d.logger.Printf("Neovim opened: %s", doc.URI)
+			if reason := d.contentSkip(doc.Text); reason != "" {
+				d.logger.Printf("Not linting %s", describeSkip(doc.URI, reason, len(doc.Text)))
+			} else {
+				d.lint(doc.URI, doc.LanguageID, doc.Text, doc.Version)
+			}
 		}
 	case "textDocument/didClose":
 		var req struct {
@@ -861,7 +1732,32 @@ func (d *Daemon) trackNeovimDocuments(method string, content []byte) {
 			d.mu.Lock()
 			delete(d.neovimOpenDocs, req.Params.TextDocument.URI)
 			d.mu.Unlock()
+			d.languageMu.Lock()
+			delete(d.languageIDs, req.Params.TextDocument.URI)
+			d.languageMu.Unlock()
+			d.state.Touch()
 			d.logger.Printf("Neovim closed: %s", req.Params.TextDocument.URI)
+			d.unlint(req.Params.TextDocument.URI)
+			d.dropReview(req.Params.TextDocument.URI)
+			d.dropScratch(req.Params.TextDocument.URI)
+		}
+	case "textDocument/didChange":
+		// Not cached, but the editor context around the cursor changed
+		d.state.Touch()
+		var req struct {
+			Params struct {
+				TextDocument   lsp.TextDocumentIdentifier `json:"textDocument"`
+				ContentChanges []struct {
+					Range *lsp.Range `json:"range"`
+					Text  string     `json:"text"`
+				} `json:"contentChanges"`
+			} `json:"params"`
+		}
+		// Subscribers get full-text changes; incremental ones can't be
+		// applied without the buffer
+		if err := json.Unmarshal(content, &req); err == nil && len(req.Params.ContentChanges) == 1 && req.Params.ContentChanges[0].Range == nil &&
+			d.contentSkip(req.Params.ContentChanges[0].Text) == "" {
+			d.publishDocumentChanged(req.Params.TextDocument.URI, req.Params.ContentChanges[0].Text, "neovim")
 		}
 	}
 }
@@ -869,24 +1765,34 @@ func (d *Daemon) trackNeovimDocuments(method string, content []byte) {
 // handleSelectionChanged processes crush/selectionChanged from Neovim.
 func (d *Daemon) handleSelectionChanged(content []byte) {
 	var notif struct {
-		Params struct {
-			TextDocument struct {
-				URI string `json:"uri"`
-			} `json:"textDocument"`
-			Text string `json:"text"`
-		} `json:"params"`
+		Params lsp.SelectionChangedParams `json:"params"`
 	}
 	if err := json.Unmarshal(content, &notif); err != nil {
-		d.logger.Printf("Failed to parse selectionChanged: %v", err)
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeParse,
+			Message: fmt.Sprintf("failed to parse selectionChanged: %v", err),
+			Method:  "crush/selectionChanged",
+		})
 		return
 	}
 
 	d.mu.Lock()
-	d.selectionText = notif.Params.Text
 	if notif.Params.TextDocument.URI != "" {
 		d.cursorURI = notif.Params.TextDocument.URI
 	}
+	d.selections = notif.Params.Selections
+	d.selectionText = notif.Params.Text
+	if text, ok := d.documentState[d.cursorURI]; ok && d.selectionText == "" {
+		// Older plugins send only ranges
+		var texts []string
+		for _, c := range selectionContexts(text, true, d.selections) {
+			texts = append(texts, c.Text)
+		}
+		d.selectionText = strings.Join(texts, "\n")
+	}
 	d.mu.Unlock()
+	d.state.Touch()
+	d.publish(wantsCursor, "crush/selectionChanged", notif.Params)
 
 	d.logger.Printf("Selection updated: %d chars in %s", len(d.selectionText), d.cursorURI)
 }
@@ -894,48 +1800,102 @@ func (d *Daemon) handleSelectionChanged(content []byte) {
 // handleCursorMoved processes crush/cursorMoved from Neovim.
 func (d *Daemon) handleCursorMoved(content []byte) {
 	var notif struct {
-		Params struct {
-			TextDocument struct {
-				URI string `json:"uri"`
-			} `json:"textDocument"`
-			Position struct {
-				Line      int `json:"line"`
-				Character int `json:"character"`
-			} `json:"position"`
-		} `json:"params"`
+		Params lsp.CursorMovedParams `json:"params"`
 	}
 	if err := json.Unmarshal(content, &notif); err != nil {
-		d.logger.Printf("Failed to parse cursorMoved: %v", err)
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeParse,
+			Message: fmt.Sprintf("failed to parse cursorMoved: %v", err),
+			Method:  "crush/cursorMoved",
+		})
 		return
 	}
 
 	d.mu.Lock()
+	focused := d.cursorURI != notif.Params.TextDocument.URI
 	d.cursorURI = notif.Params.TextDocument.URI
 	d.cursorLine = notif.Params.Position.Line
 	d.cursorColumn = notif.Params.Position.Character
+	d.cursors = notif.Params.Cursors
+	if notif.Params.LineContent != nil {
+		d.cursorLineText = *notif.Params.LineContent
+	} else {
+		d.cursorLineText, _ = d.cachedLine(d.cursorURI, d.cursorLine)
+	}
 	d.mu.Unlock()
+	d.state.Touch()
+	d.publish(wantsCursor, "crush/cursorMoved", notif.Params)
+	if focused {
+		d.publish(wantsFocus, "crush/focusChanged", lsp.FocusChangedParams{TextDocument: notif.Params.TextDocument, Source: "neovim"})
+	}
 
 	d.logger.Printf("Cursor moved: %s:%d:%d", d.cursorURI, d.cursorLine, d.cursorColumn)
 }
 
+// cachedLine returns line n of the cached text of uri. Callers hold d.mu.
+func (d *Daemon) cachedLine(uri string, n int) (string, bool) {
+	text, ok := d.documentState[uri]
+	if !ok || n < 0 {
+		return "", false
+	}
+	lines := strings.Split(text, "\n")
+	if n >= len(lines) {
+		return "", false
+	}
+	return lines[n], true
+}
+
+// cursorLineContent returns the text of the cursor's line: from the cached
+// document when there is one, else as last reported by Neovim. Callers hold
+// d.mu.
+func (d *Daemon) cursorLineContent() string {
+	if line, ok := d.cachedLine(d.cursorURI, d.cursorLine); ok {
+		return line
+	}
+	return d.cursorLineText
+}
+
 // handleGetEditorContext responds to crush/getEditorContext requests from MCP clients.
 func (d *Daemon) handleGetEditorContext(content []byte, conn net.Conn) {
 	var req struct {
-		ID any `json:"id"`
+		ID     any `json:"id"`
+		Params struct {
+			IncludeDefinitions bool `json:"includeDefinitions"`
+		} `json:"params"`
 	}
 	if err := json.Unmarshal(content, &req); err != nil {
 		d.logger.Printf("Failed to parse getEditorContext request: %v", err)
 		return
 	}
 
+	// Read first, so a change while the context is built makes it stale
+	version := d.state.GetVersion()
+
 	d.mu.RLock()
 	uri := d.cursorURI
 	line := d.cursorLine
 	col := d.cursorColumn
 	selectionText := d.selectionText
+	selections := d.selections
+	cursors := d.cursors
+	lineContent := d.cursorLineContent()
 	docContent, hasDoc := d.documentState[uri]
 	d.mu.RUnlock()
 
+	// Documents Neovim never routed through the daemon aren't cached
+	var skipped string
+	if hasDoc {
+		skipped = d.contentSkip(docContent)
+	} else if uri != "" {
+		docContent, skipped, hasDoc = d.loadDocument(d.requestContext(conn, content), uri)
+	}
+	if skipped != "" {
+		// Metadata only: a minified or binary line is no context
+		docContent, hasDoc, lineContent = "", false, ""
+	}
+
+	contextLines := d.contextLines(uri)
+
 	// Build response
 	hasSelection := selectionText != ""
 	result := map[string]any{
@@ -944,21 +1904,31 @@ func (d *Daemon) handleGetEditorContext(content []byte, conn net.Conn) {
 		"cursor_line":   line,
 		"cursor_column": col,
 		"has_selection": hasSelection,
+		"state_version": version,
 	}
 	if hasSelection {
 		result["selection"] = selectionText
 	}
+	if skipped != "" {
+		result["content_skipped"] = skipped
+	}
+	if len(selections) > 0 {
+		result["selections"] = selectionContexts(docContent, hasDoc, selections)
+	}
+	if len(cursors) > 0 {
+		result["cursors"] = cursors
+	}
 
 	if hasDoc {
 		lines := strings.Split(docContent, "\n")
 		result["total_lines"] = len(lines)
 
-		// Get context lines (5 before, current, 5 after)
-		startLine := line - 5
+		// Get context lines (N before, current, N after)
+		startLine := line - contextLines
 		if startLine < 0 {
 			startLine = 0
 		}
-		endLine := line + 6 // exclusive
+		endLine := line + contextLines + 1 // exclusive
 		if endLine > len(lines) {
 			endLine = len(lines)
 		}
@@ -979,10 +1949,16 @@ func (d *Daemon) handleGetEditorContext(content []byte, conn net.Conn) {
 			afterLines = append(afterLines, lines[i])
 		}
 		result["context_after"] = strings.Join(afterLines, "\n")
+
+		if req.Params.IncludeDefinitions && line < len(lines) {
+			if defs := d.resolveDefinitions(d.requestContext(conn, content), uri, lines[line], line, col); len(defs) > 0 {
+				result["definitions"] = defs
+			}
+		}
 	} else {
 		result["total_lines"] = 0
 		result["context_before"] = ""
-		result["context_line"] = ""
+		result["context_line"] = lineContent
 		result["context_after"] = ""
 	}
 
@@ -1008,42 +1984,6 @@ func extractFilename(uri string) string {
 	return path
 }
 
-func bridgeConnections(stdin io.Reader, stdout io.Writer, conn net.Conn, logger *log.Logger) {
-	errChan := make(chan error, 2)
-
-	// stdin -> socket
-	go func() {
-		scanner := bufio.NewScanner(stdin)
-		scanner.Split(rpc.Split)
-		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
-
-		for scanner.Scan() {
-			if _, err := conn.Write(scanner.Bytes()); err != nil {
-				errChan <- err
-				return
-			}
-		}
-		errChan <- scanner.Err()
-	}()
-
-	// socket -> stdout
-	go func() {
-		scanner := bufio.NewScanner(conn)
-		scanner.Split(rpc.Split)
-		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
-
-		for scanner.Scan() {
-			if _, err := stdout.Write(scanner.Bytes()); err != nil {
-				errChan <- err
-				return
-			}
-		}
-		errChan <- scanner.Err()
-	}()
-
-	<-errChan
-}
-
 func getLogger(path string) *log.Logger {
 	if path == "" {
 		path = os.Getenv("CRUSH_LSP_LOG")
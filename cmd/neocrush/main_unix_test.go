@@ -0,0 +1,70 @@
+//go:build unix
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+func TestDaemonRefusesFIFOs(t *testing.T) {
+	daemon, socketPath := startTestDaemon(t)
+	root := t.TempDir()
+	daemon.workspace = root
+
+	// A FIFO blocks its reader until a writer opens it
+	fifo := filepath.Join(root, "pipe")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Fatalf("Failed to make a FIFO: %v", err)
+	}
+	uri := "file://" + fifo
+
+	nvimConn, nvimScanner := connectTestClient(t, socketPath, "Neovim")
+	daemon.mu.Lock()
+	daemon.neovimOpenDocs[uri] = true
+	daemon.mu.Unlock()
+	crushConn, crushScanner := connectTestClient(t, socketPath, "Crush")
+
+	// Neovim never answers crush/documentContent, so the read goes to disk
+	daemon.requests.Deadlines = map[string]int{"crush/documentContent": 50}
+	start := time.Now()
+	msg := rpc.EncodeMessage(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "crush/readFile", "params": lsp.ReadFileParams{Path: "pipe"}})
+	if _, err := crushConn.Write([]byte(msg)); err != nil {
+		t.Fatalf("Failed to send readFile: %v", err)
+	}
+	resp := readMessage(t, crushConn, crushScanner)
+	errObj, _ := resp["error"].(map[string]any)
+	if message, _ := errObj["message"].(string); errObj["code"] != float64(lsp.InvalidParams) || !strings.Contains(message, "not a regular file") {
+		t.Errorf("Expected the FIFO refused, got %v", resp)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the FIFO refused without reading it, took %s", elapsed)
+	}
+
+	// Crush's edit has no baseline on disk either, so Neovim gets the whole
+	// document rather than the daemon waiting on the FIFO
+	didChange := rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/didChange",
+		"params": map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 2},
+			"contentChanges": []map[string]any{{"text": "data\n"}},
+		},
+	})
+	if _, err := crushConn.Write([]byte(didChange)); err != nil {
+		t.Fatalf("Failed to change: %v", err)
+	}
+	got := readMessage(t, nvimConn, nvimScanner)
+	for got["method"] == "crush/documentContent" {
+		got = readMessage(t, nvimConn, nvimScanner) // The unanswered one above
+	}
+	if got["method"] != "workspace/applyEdit" {
+		t.Errorf("Expected applyEdit, got %v", got)
+	}
+}
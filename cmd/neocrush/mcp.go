@@ -2,20 +2,40 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net"
+	"log"
 	"os"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/taigrr/neocrush/internal/client"
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/tracing"
+	"github.com/taigrr/neocrush/lsp"
 )
 
+// defaultToolPageSize is the page size of list-style tools when the host
+// does not give one, keeping their results a reasonable size.
+const defaultToolPageSize = 500
+
+// runTestsTimeout is how long run_tests waits for the daemon's job.
+const runTestsTimeout = time.Hour
+
+// gitToolTimeout is how long stage_files and commit wait for the user's
+// confirmation and git.
+const gitToolTimeout = confirmTimeout + 3*gitTimeout
+
 // EditorContextInput is the input for the editor_context tool.
-type EditorContextInput struct{}
+type EditorContextInput struct {
+	IncludeDefinitions bool `json:"include_definitions,omitempty"`
+	Render             bool `json:"render,omitempty"`
+}
 
 // ShowLocationsInput is the input for the show_locations tool.
 type ShowLocationsInput struct {
@@ -37,6 +57,7 @@ type LocationItem struct {
 type ShowLocationsOutput struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
+	Queued  bool   `json:"queued,omitempty"`
 }
 
 // EditorContextOutput is the output for the editor_context tool.
@@ -51,16 +72,285 @@ type EditorContextOutput struct {
 	TotalLines    int    `json:"total_lines"`
 	HasSelection  bool   `json:"has_selection"`
 	Selection     string `json:"selection,omitempty"`
+
+	Selections  []lsp.SelectionContext  `json:"selections,omitempty"`
+	Cursors     []lsp.Position          `json:"cursors,omitempty"`
+	Definitions []lsp.DefinitionContext `json:"definitions,omitempty"`
+
+	// ContentSkipped is "binary" or "too_large" when the document's text is
+	// left out and only its metadata given
+	ContentSkipped string `json:"content_skipped,omitempty"`
+
+	StateVersion int64 `json:"state_version"`
+	Stale        bool  `json:"stale,omitempty"` // Served from the last result while the daemon is unreachable
+}
+
+// ListFilesInput is the input for the list_files tool.
+type ListFilesInput struct {
+	Path     string `json:"path,omitempty"`
+	Refresh  bool   `json:"refresh,omitempty"`
+	Cursor   string `json:"cursor,omitempty"`
+	PageSize int    `json:"page_size,omitempty"`
+}
+
+// ListFilesOutput is the output for the list_files tool.
+type ListFilesOutput struct {
+	Root       string         `json:"root"`
+	Files      []lsp.FileInfo `json:"files"`
+	Truncated  bool           `json:"truncated,omitempty"`
+	Folders    []string       `json:"folders,omitempty"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// ReadFileInput is the input for the read_file tool.
+type ReadFileInput struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+	Render    bool   `json:"render,omitempty"`
+}
+
+// ReadFileOutput is the output for the read_file tool.
+type ReadFileOutput struct {
+	Path       string `json:"path"`
+	Content    string `json:"content"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	TotalLines int    `json:"total_lines"`
+	Size       int64  `json:"size"`
+	Source     string `json:"source"`
+	Encoding   string `json:"encoding,omitempty"`
+	Binary     bool   `json:"binary,omitempty"`
+	TooLarge   bool   `json:"too_large,omitempty"`
+}
+
+// WriteFileInput is the input for the write_file and create_file tools.
+type WriteFileInput struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// WriteFileOutput is the output for the write_file and create_file tools.
+type WriteFileOutput struct {
+	Path      string `json:"path"`
+	Via       string `json:"via"`
+	Created   bool   `json:"created,omitempty"`
+	Applied   bool   `json:"applied"`
+	Formatted bool   `json:"formatted,omitempty"`
+}
+
+// GenerateFileInput is the input for the generate_file tool.
+type GenerateFileInput struct {
+	Template string            `json:"template"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+// GenerateFileOutput is the output for the generate_file tool.
+type GenerateFileOutput struct {
+	WriteFileOutput
+	Content string `json:"content"`
+}
+
+// RecentChangesInput is the input for the recent_changes tool.
+type RecentChangesInput struct {
+	Minutes int `json:"minutes,omitempty"`
+}
+
+// RecentChangesOutput is the output for the recent_changes tool.
+type RecentChangesOutput struct {
+	Summary string            `json:"summary"`
+	Since   string            `json:"since"`
+	Files   []lsp.ChangedFile `json:"files"`
+}
+
+// RelatedFilesInput is the input for the related_files tool.
+type RelatedFilesInput struct {
+	UseSelection bool `json:"use_selection,omitempty"`
+	Limit        int  `json:"limit,omitempty"`
+}
+
+// RelatedFilesOutput is the output for the related_files tool.
+type RelatedFilesOutput struct {
+	Files []lsp.RelatedFile `json:"files"`
+}
+
+// SessionHistoryInput is the input for the session_history tool.
+type SessionHistoryInput struct {
+	Minutes  int    `json:"minutes,omitempty"`
+	Method   string `json:"method,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+	Cursor   string `json:"cursor,omitempty"`
+	PageSize int    `json:"page_size,omitempty"`
+}
+
+// SessionHistoryOutput is the output for the session_history tool.
+type SessionHistoryOutput struct {
+	Session    string              `json:"session"`
+	Records    []lsp.HistoryRecord `json:"records"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// UndoLastEditInput is the input for the undo_last_edit tool.
+type UndoLastEditInput struct{}
+
+// UndoLastEditOutput is the output for the undo_last_edit tool.
+type UndoLastEditOutput struct {
+	Undone bool     `json:"undone"`
+	Label  string   `json:"label,omitempty"`
+	URIs   []string `json:"uris,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// CreateCheckpointInput is the input for the create_checkpoint tool.
+type CreateCheckpointInput struct {
+	Name  string   `json:"name,omitempty"`
+	Paths []string `json:"paths"`
+}
+
+// CreateCheckpointOutput is the output for the create_checkpoint tool.
+type CreateCheckpointOutput = lsp.Checkpoint
+
+// EditSelectionsInput is the input for the edit_selections tool.
+type EditSelectionsInput struct {
+	Edits []SelectionEditInput `json:"edits"`
+}
+
+// SelectionEditInput replaces the text of one selection.
+type SelectionEditInput struct {
+	Index   int     `json:"index"`
+	NewText string  `json:"new_text"`
+	OldText *string `json:"old_text,omitempty"`
+}
+
+// EditSelectionsOutput is the output for the edit_selections tool.
+type EditSelectionsOutput = lsp.EditSelectionsResult
+
+// ApplyEditInput is the input for the apply_edit tool.
+type ApplyEditInput struct {
+	Path    string    `json:"path"`
+	Range   lsp.Range `json:"range"`
+	NewText string    `json:"new_text"`
+}
+
+// ApplyEditOutput is the output for the apply_edit tool.
+type ApplyEditOutput = lsp.EditFileResult
+
+// RunTestsInput is the input for the run_tests tool.
+type RunTestsInput struct {
+	Kind     string   `json:"kind,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	Language string   `json:"language,omitempty"`
+}
+
+// RunTestsOutput is the output for the run_tests tool.
+type RunTestsOutput = lsp.RunJobResult
+
+// RecentJobsInput is the input for the recent_jobs tool.
+type RecentJobsInput struct {
+	Kind   string `json:"kind,omitempty"`
+	Failed bool   `json:"failed,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// RecentJobsOutput is the output for the recent_jobs tool.
+type RecentJobsOutput = lsp.GetRecentJobsResult
+
+// ListTodosInput is the input for the list_todos tool.
+type ListTodosInput struct {
+	Path     string `json:"path,omitempty"`
+	Cursor   string `json:"cursor,omitempty"`
+	PageSize int    `json:"page_size,omitempty"`
+}
+
+// ListTodosOutput is the output for the list_todos tool.
+type ListTodosOutput struct {
+	Todos      []lsp.TodoInfo `json:"todos"`
+	Truncated  bool           `json:"truncated,omitempty"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// StageFilesInput is the input for the stage_files tool.
+type StageFilesInput struct {
+	Paths []string `json:"paths,omitempty"`
+}
+
+// StageFilesOutput is the output for the stage_files tool.
+type StageFilesOutput = lsp.StageFilesResult
+
+// CommitInput is the input for the commit tool.
+type CommitInput struct {
+	Message string `json:"message"`
+}
+
+// CommitOutput is the output for the commit tool.
+type CommitOutput = lsp.CommitResult
+
+// OpenScratchInput is the input for the open_scratch tool.
+type OpenScratchInput struct {
+	URI        string `json:"uri,omitempty"`
+	Title      string `json:"title,omitempty"`
+	LanguageID string `json:"language_id,omitempty"`
+	Text       string `json:"text"`
+	Append     bool   `json:"append,omitempty"`
+}
+
+// OpenScratchOutput is the output for the open_scratch tool.
+type OpenScratchOutput = lsp.OpenScratchResult
+
+// WorkspaceStatsInput is the input for the workspace_stats tool.
+type WorkspaceStatsInput struct {
+	Path    string `json:"path,omitempty"`
+	Largest int    `json:"largest,omitempty"`
+	Refresh bool   `json:"refresh,omitempty"`
+}
+
+// WorkspaceStatsOutput is the output for the workspace_stats tool.
+type WorkspaceStatsOutput = lsp.WorkspaceStatsResult
+
+// GetEnvironmentInput is the input for the get_environment tool.
+type GetEnvironmentInput struct {
+	Refresh bool `json:"refresh,omitempty"`
+}
+
+// GetEnvironmentOutput is the output for the get_environment tool.
+type GetEnvironmentOutput = lsp.EnvironmentResult
+
+// AppendTranscriptInput is the input for the append_transcript tool.
+type AppendTranscriptInput struct {
+	Role string `json:"role,omitempty"`
+	Text string `json:"text"`
+}
+
+// AppendTranscriptOutput is the output for the append_transcript tool.
+type AppendTranscriptOutput struct {
+	URI    string `json:"uri"`
+	Queued bool   `json:"queued,omitempty"`
 }
 
 // MCPServer wraps the MCP server with access to daemon state.
 type MCPServer struct {
-	server     *mcp.Server
-	daemonConn net.Conn
+	server    *mcp.Server
+	cache     *contextCache   // Reuses editor_context results (nil = caching off)
+	tools     []string        // Names of the tools offered
+	progress  *progressRelay  // Relays the daemon's progress on tool calls to the host
+	authToken string          // Sent with requests when the daemon requires a token
+	tracer    *tracing.Tracer // Starts a trace per daemon request (nil = tracing off)
+	logger    *log.Logger
+
+	daemonMu sync.RWMutex
+	daemon   *client.Client
+	calls    *client.Dispatcher // Routes the daemon's responses to concurrent tool calls
+
+	// Offline mode, while the daemon is unreachable
+	reconnect      func() (*client.Client, error) // Dials the daemon again (nil = tool calls just fail)
+	reconnectDelay time.Duration                  // First wait before redialing
+	offline        offlineState
 }
 
-// NewMCPServer creates a new MCP server connected to the daemon.
-func NewMCPServer(daemonConn net.Conn) *MCPServer {
+// NewMCPServer creates a new MCP server connected to the daemon, offering
+// the tools cfg enables under their configured names.
+func NewMCPServer(daemon *client.Client, cfg config.MCPConfig) (*MCPServer, error) {
 	server := mcp.NewServer(
 		&mcp.Implementation{
 			Name:    "neocrush",
@@ -72,18 +362,22 @@ func NewMCPServer(daemonConn net.Conn) *MCPServer {
 	)
 
 	mcpServer := &MCPServer{
-		server:     server,
-		daemonConn: daemonConn,
+		server:         server,
+		cache:          newContextCache(cfg.CacheTTL()),
+		progress:       newProgressRelay(),
+		logger:         log.New(io.Discard, "", 0),
+		reconnectDelay: defaultReconnectDelay,
 	}
+	tools := &toolSet{server: server, cfg: cfg}
 
 	// Add the editor_context tool
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(tools, &mcp.Tool{
 		Name:        "editor_context",
-		Description: "Get the current editor context including cursor position, surrounding code, and active file from Neovim, useful for when the user asks you about 'this' or 'here' (provides editor state context, i.e. open file and cursor location.)",
+		Description: "Get the current editor context including cursor position, surrounding code, and active file from Neovim, useful for when the user asks you about 'this' or 'here' (provides editor state context, i.e. open file and cursor location.) Set include_definitions to also get the source of the symbol under the cursor and of the function call it sits in, resolved by Neovim's language servers. Set render to also get the code around the cursor as a syntax-highlighted image, for hosts that display images. For binary files and files over the neocrush files.max_document_bytes limit, no code is returned and content_skipped says why. While the daemon is unreachable, the last known context is returned with stale set.",
 	}, mcpServer.editorContextHandler)
 
 	// Add the show_locations tool
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(tools, &mcp.Tool{
 		Name: "show_locations",
 		Description: `Nvim navigaion tool. Call this tool when the user asks you to show them a list of code or text locations.
 
@@ -99,21 +393,167 @@ Each item should include:
 - note: YOUR explanation of WHY this location matters for the current task (critical - be specific)
 - type: N (note), I (info), W (warning), E (error) - defaults to N
 
-The note field is the key differentiator - explain WHY this location is relevant to what the user asked, not just WHAT the code does; use this after analyzing code to show the user relevant locations with context.`,
+The note field is the key differentiator - explain WHY this location is relevant to what the user asked, not just WHAT the code does; use this after analyzing code to show the user relevant locations with context.
+
+While the daemon is unreachable, the locations are queued (queued is true) and shown once it is back.`,
 	}, mcpServer.showLocationsHandler)
 
-	return mcpServer
+	// Add the list_files tool
+	addTool(tools, &mcp.Tool{
+		Name:        "list_files",
+		Description: "List the files in the workspace with their sizes and languages, skipping anything ignored by .gitignore or the neocrush files.exclude config. Optionally limit to a directory with path (relative to the workspace root), and set refresh to bypass the daemon's short-lived cache. Results come in pages of page_size files (default 500); when next_cursor is set, call again with it as cursor for the next page. Prefer this over running find or ls through a shell.",
+	}, mcpServer.listFilesHandler)
+
+	// Add the read_file tool
+	addTool(tools, &mcp.Tool{
+		Name:        "read_file",
+		Description: "Read a file in the workspace. Returns the live Neovim buffer when the file is open there (including unsaved edits), otherwise the file on disk. Use start_line and end_line (1-indexed, inclusive) to read part of a large file. Binary files, and whole files over the neocrush files.max_document_bytes limit (too_large), are reported without content. Paths are relative to the workspace root; paths outside it are rejected. Set render to also get the lines (at most 200) as a syntax-highlighted image, for hosts that display images.",
+	}, mcpServer.readFileHandler)
+
+	// Add the write_file and create_file tools
+	addTool(tools, &mcp.Tool{
+		Name:        "write_file",
+		Description: "Replace the full content of a workspace file, creating it if needed. If the file is open in Neovim the change is applied to the buffer (undoable, left unsaved; via is \"buffer\"); otherwise it is written to disk and shown in Neovim (via is \"disk\"). A new file may instead be shown to the user as a read-only preview (via is \"preview\", applied false): it is written only once they accept it, and read_file returns it meanwhile. applied is false if the user rejected the edit. formatted is true if the workspace's formatter changed your content; read the file again before editing it by line.",
+	}, mcpServer.writeFileHandler(false))
+	addTool(tools, &mcp.Tool{
+		Name:        "create_file",
+		Description: "Create a new workspace file with the given content. Fails if the file already exists; use write_file to change existing files. Like write_file, it may stage the file as a preview for the user to accept (via is \"preview\").",
+	}, mcpServer.writeFileHandler(true))
+
+	// Add the generate_file tool
+	addTool(tools, &mcp.Tool{
+		Name:        "generate_file",
+		Description: "Create a new workspace file from a template, so boilerplate is consistent across the project. Templates: \"license\" (params path and optional body: body under the project's license header, taken from its LICENSE file unless license and holder are given), \"test\" (params source: a test file for that source file with a skipped test per exported function, named, placed and written for the framework the project's existing tests use; Go, Python, JavaScript and TypeScript), and any the workspace keeps in .crush/templates/<name>.tmpl (give path and the params it uses). The file is created like create_file (it may be staged as a preview, via \"preview\"); content is what was generated. Prefer this over writing license headers and test scaffolds by hand.",
+	}, mcpServer.generateFileHandler)
+
+	// Add the recent_changes tool
+	addTool(tools, &mcp.Tool{
+		Name:        "recent_changes",
+		Description: "Summarize what changed in the workspace in the last N minutes (default 10): for each file, how many changes, lines added and removed, who made them (crush, mcp, resync), and when. Use it to catch up when rejoining a session.",
+	}, mcpServer.recentChangesHandler)
+
+	// Add the related_files tool
+	addTool(tools, &mcp.Tool{
+		Name:        "related_files",
+		Description: "Suggest workspace files related to the file open in Neovim (or, with use_selection, to the selected text), ranked by vocabulary similarity. Use it to decide what else to read. Requires related.enabled in the neocrush config.",
+	}, mcpServer.relatedFilesHandler)
+
+	// Add the session_history tool
+	addTool(tools, &mcp.Tool{
+		Name:        "session_history",
+		Description: "List what happened earlier in this session: editor requests and tool calls (method and redacted parameters) and applied edits, oldest first. Filter by minutes, method (e.g. crush/writeFile or crush/*), kind (request or edit), and limit (default 100). Results come in pages of page_size records (default 500); when next_cursor is set, call again with it as cursor for the next page.",
+	}, mcpServer.sessionHistoryHandler)
+
+	// Add the undo_last_edit tool
+	addTool(tools, &mcp.Tool{
+		Name:        "undo_last_edit",
+		Description: "Undo the most recent agent edit in Neovim as one step (all files it touched), as if the user pressed u. Fails if the user has edited those buffers since.",
+	}, mcpServer.undoLastEditHandler)
+
+	// Add the create_checkpoint tool
+	addTool(tools, &mcp.Tool{
+		Name:        "create_checkpoint",
+		Description: "Snapshot the files you are about to change (paths relative to the workspace root, including files you will create) under a name, before a large or risky edit. The user can restore it with `neocrush checkpoint restore <name>`.",
+	}, mcpServer.createCheckpointHandler)
+
+	// Add the edit_selections tool
+	addTool(tools, &mcp.Tool{
+		Name:        "edit_selections",
+		Description: "Replace the text of the user's selections in Neovim (several with visual block or multi-cursor plugins), or insert at their cursors when nothing is selected. Give one edit per selection, with index as in editor_context's selections (or cursors, after the main cursor at index 0). Set old_text to the text you read to fail instead of clobbering a selection the user has changed. All edits are applied as one undoable change.",
+	}, mcpServer.editSelectionsHandler)
+
+	// Add the apply_edit tool
+	addTool(tools, &mcp.Tool{
+		Name:        "apply_edit",
+		Description: "Replace the text in range of a workspace file with new_text through Neovim, so the change lands in its buffer and undo history (left unsaved); a file Neovim doesn't have open is loaded. Lines and characters are 0-indexed, as in editor_context's cursor and selections, and the end is exclusive; an empty range inserts new_text. Paths are relative to the workspace root; paths outside it are rejected. applied is false, with error, if Neovim rejected the edit. Prefer this over write_file for a small change to a large file.",
+	}, mcpServer.applyEditHandler)
+
+	// Add the run_tests tool
+	addTool(tools, &mcp.Tool{
+		Name:        "run_tests",
+		Description: "Run the workspace's test command (or, with kind \"build\", its build command) and wait for it to finish. The command comes from the neocrush config (the test command of language, an LSP language ID such as \"python\", defaulting to the language of the file at the cursor; then jobs) or is detected from the project (go test ./..., cargo test, npm test, ...); args are appended to it, e.g. [\"-run\", \"TestFoo\"]. The user sees the output live in Neovim. Returns the status (succeeded, failed, cancelled, timedOut), exit code, and the last 200 lines of output. Prefer this over running tests through a shell.",
+	}, mcpServer.runTestsHandler)
+
+	// Add the recent_jobs tool
+	addTool(tools, &mcp.Tool{
+		Name:        "recent_jobs",
+		Description: "Get the test and build jobs that finished most recently in this session, most recent first, whether you or the user ran them: command, status, exit code, duration, problems found, and the last 50 lines of output. Set failed to get only the jobs that did not succeed, kind (\"test\" or \"build\") to get one kind, and limit to cap how many. Use it to pick up a failing test without running the suite again.",
+	}, mcpServer.recentJobsHandler)
+
+	// Add the list_todos tool
+	addTool(tools, &mcp.Tool{
+		Name:        "list_todos",
+		Description: "List the outstanding TODO, FIXME and HACK comments in the workspace (or the markers set in the neocrush diagnostics config), with their file, line, owner from TODO(owner), and text. Optionally limit to a directory with path (relative to the workspace root). Results come in pages of page_size comments (default 500); when next_cursor is set, call again with it as cursor for the next page. Prefer this over grepping for TODOs through a shell.",
+	}, mcpServer.listTodosHandler)
+
+	// Add the stage_files tool
+	addTool(tools, &mcp.Tool{
+		Name:        "stage_files",
+		Description: "Stage files you edited in this session with git add, after the user confirms in Neovim. Give paths relative to the workspace root, or none to stage every file you edited; other files are refused, as are files with unsaved changes in Neovim. Returns confirmed: false if the user declined.",
+	}, mcpServer.stageFilesHandler)
+
+	// Add the commit tool
+	addTool(tools, &mcp.Tool{
+		Name:        "commit",
+		Description: "Commit the staged changes with your proposed message, after the user confirms the message and files in Neovim. Stage files with stage_files first. Returns the commit hash, or confirmed: false if the user declined. Prefer this over running git commit through a shell.",
+	}, mcpServer.commitHandler)
+
+	// Add the open_scratch tool
+	addTool(tools, &mcp.Tool{
+		Name:        "open_scratch",
+		Description: "Show text (a plan, an explanation, a generated snippet) in an unsaved Neovim scratch buffer instead of a file. Give a title such as plan.md (its extension sets the filetype unless language_id is given). Returns the buffer's uri; call again with it to replace the text, or with append to stream more text into the buffer.",
+	}, mcpServer.openScratchHandler)
+
+	// Add the workspace_stats tool
+	addTool(tools, &mcp.Tool{
+		Name:        "workspace_stats",
+		Description: "Get aggregate statistics of the workspace, or of the directory at path: file, line and byte counts per language, the largest files by lines (largest sets how many, default 10), and test files and lines with the ratio of test to source lines. Use it to scope a refactor or get a feel for an unfamiliar codebase before reading files.",
+	}, mcpServer.workspaceStatsHandler)
+
+	// Add the get_environment tool
+	addTool(tools, &mcp.Tool{
+		Name:        "get_environment",
+		Description: "Get facts about the user's machine: OS, architecture, shell, the versions of installed tools (go, node, python3, rustc, git, ...) as run in the workspace, and the environment variables that affect builds (secrets redacted). Use it to tailor commands to the machine instead of guessing. Results are cached for the session; set refresh after the user installs or switches a tool.",
+	}, mcpServer.getEnvironmentHandler)
+
+	// Add the append_transcript tool
+	addTool(tools, &mcp.Tool{
+		Name:        "append_transcript",
+		Description: "Add a markdown entry (your plan, reasoning, or a summary of what you did) to the session's conversation buffer, which Neovim shows live in a split. The role heads the entry, e.g. plan or reasoning. While the daemon is unreachable, the entry is queued (queued is true) and added once it is back.",
+	}, mcpServer.appendTranscriptHandler)
+
+	if tools.err != nil {
+		return nil, tools.err
+	}
+	mcpServer.tools = tools.names
+	server.AddReceivingMiddleware(mcpServer.subscribeLogs, mcpServer.trackProgress)
+	mcpServer.attach(daemon)
+	return mcpServer, nil
 }
 
 // editorContextHandler handles the editor_context tool call.
 func (m *MCPServer) editorContextHandler(ctx context.Context, req *mcp.CallToolRequest, input EditorContextInput) (*mcp.CallToolResult, EditorContextOutput, error) {
 	// Request editor state from daemon
-	state, err := m.requestEditorState()
+	state, err := m.cachedEditorState(ctx, input.IncludeDefinitions)
+	if errors.Is(err, errDaemonOffline) {
+		if stale, ok := m.staleContext(input.IncludeDefinitions); ok {
+			return nil, stale, nil
+		}
+	}
 	if err != nil {
 		return nil, EditorContextOutput{}, fmt.Errorf("failed to get editor state: %w", err)
 	}
+	m.rememberContext(input.IncludeDefinitions, state)
+	if !input.Render || state.ContentSkipped != "" {
+		return nil, state, nil
+	}
 
-	return nil, state, nil
+	image, err := m.renderContext(ctx, state)
+	if err != nil {
+		return nil, EditorContextOutput{}, err
+	}
+	res, err := withImage(state, image)
+	return res, state, err
 }
 
 // showLocationsHandler handles the show_locations tool call.
@@ -123,108 +563,543 @@ func (m *MCPServer) showLocationsHandler(ctx context.Context, req *mcp.CallToolR
 	}
 
 	// Send to daemon which will forward to Neovim
-	err := m.sendShowLocations(input.Title, input.Items)
-	if err != nil {
+	params := m.withAuth(map[string]any{
+		"title": input.Title,
+		"items": input.Items,
+	})
+	if m.enqueue("crush/showLocations", params, true) {
+		return nil, ShowLocationsOutput{Success: true, Queued: true}, nil
+	}
+	if err := m.notify("crush/showLocations", params); err != nil {
 		return nil, ShowLocationsOutput{Success: false, Error: err.Error()}, nil
 	}
 
 	return nil, ShowLocationsOutput{Success: true}, nil
 }
 
-// sendShowLocations sends a crush/showLocations notification to the daemon.
-func (m *MCPServer) sendShowLocations(title string, items []LocationItem) error {
-	notification := map[string]any{
-		"jsonrpc": "2.0",
-		"method":  "crush/showLocations",
-		"params": map[string]any{
-			"title": title,
-			"items": items,
-		},
+// listFilesHandler handles the list_files tool call.
+func (m *MCPServer) listFilesHandler(ctx context.Context, req *mcp.CallToolRequest, input ListFilesInput) (*mcp.CallToolResult, ListFilesOutput, error) {
+	result, err := m.request(ctx, "crush/listFiles", m.withAuth(map[string]any{
+		"path":     input.Path,
+		"refresh":  input.Refresh,
+		"cursor":   input.Cursor,
+		"pageSize": cmp.Or(input.PageSize, defaultToolPageSize),
+	}))
+	if err != nil {
+		return nil, ListFilesOutput{}, fmt.Errorf("failed to list files: %w", err)
 	}
 
-	notifBytes, err := json.Marshal(notification)
+	var list lsp.ListFilesResult
+	if err := json.Unmarshal(result, &list); err != nil {
+		return nil, ListFilesOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, ListFilesOutput{
+		Root:       list.Root,
+		Files:      list.Files,
+		Truncated:  list.Truncated,
+		Folders:    list.Folders,
+		NextCursor: list.NextCursor,
+	}, nil
+}
+
+// readFileHandler handles the read_file tool call.
+func (m *MCPServer) readFileHandler(ctx context.Context, req *mcp.CallToolRequest, input ReadFileInput) (*mcp.CallToolResult, ReadFileOutput, error) {
+	result, err := m.request(ctx, "crush/readFile", m.withAuth(map[string]any{
+		"path":      input.Path,
+		"startLine": input.StartLine,
+		"endLine":   input.EndLine,
+	}))
 	if err != nil {
-		return err
+		return nil, ReadFileOutput{}, fmt.Errorf("failed to read %s: %w", input.Path, err)
 	}
 
-	// Format as LSP message with Content-Length header
-	msg := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(notifBytes), notifBytes)
+	var file lsp.ReadFileResult
+	if err := json.Unmarshal(result, &file); err != nil {
+		return nil, ReadFileOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	output := ReadFileOutput{
+		Path:       file.Path,
+		Content:    file.Content,
+		StartLine:  file.StartLine,
+		EndLine:    file.EndLine,
+		TotalLines: file.TotalLines,
+		Size:       file.Size,
+		Source:     file.Source,
+		Encoding:   file.Encoding,
+		Binary:     file.Binary,
+		TooLarge:   file.TooLarge,
+	}
+	if !input.Render || file.Binary || file.Content == "" {
+		return nil, output, nil
+	}
 
-	if err := m.daemonConn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
-		return err
+	image, err := m.renderSnippet(ctx, lsp.RenderSnippetParams{Path: file.Path, StartLine: file.StartLine, EndLine: file.EndLine})
+	if err != nil {
+		return nil, ReadFileOutput{}, err
 	}
+	res, err := withImage(output, &image)
+	return res, output, err
+}
 
-	if _, err := m.daemonConn.Write([]byte(msg)); err != nil {
-		return err
+// writeFileHandler returns the handler for write_file, or create_file when
+// create is set.
+func (m *MCPServer) writeFileHandler(create bool) mcp.ToolHandlerFor[WriteFileInput, WriteFileOutput] {
+	return func(ctx context.Context, req *mcp.CallToolRequest, input WriteFileInput) (*mcp.CallToolResult, WriteFileOutput, error) {
+		result, err := m.request(ctx, "crush/writeFile", m.withAuth(map[string]any{
+			"path":    input.Path,
+			"content": input.Content,
+			"create":  create,
+		}))
+		if err != nil {
+			return nil, WriteFileOutput{}, fmt.Errorf("failed to write %s: %w", input.Path, err)
+		}
+
+		var output WriteFileOutput
+		if err := json.Unmarshal(result, &output); err != nil {
+			return nil, WriteFileOutput{}, fmt.Errorf("failed to parse response: %w", err)
+		}
+		return nil, output, nil
 	}
+}
 
-	return nil
+// generateFileHandler handles the generate_file tool call.
+func (m *MCPServer) generateFileHandler(ctx context.Context, req *mcp.CallToolRequest, input GenerateFileInput) (*mcp.CallToolResult, GenerateFileOutput, error) {
+	result, err := m.request(ctx, "crush/generateFile", m.withAuth(map[string]any{
+		"template": input.Template,
+		"params":   input.Params,
+	}))
+	if err != nil {
+		return nil, GenerateFileOutput{}, fmt.Errorf("failed to generate from template %s: %w", input.Template, err)
+	}
+
+	var output GenerateFileOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, GenerateFileOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
 }
 
-// requestEditorState sends a custom request to the daemon to get editor state.
-func (m *MCPServer) requestEditorState() (EditorContextOutput, error) {
-	// Send a custom JSON-RPC request to the daemon
-	request := map[string]any{
-		"jsonrpc": "2.0",
-		"id":      1,
-		"method":  "crush/getEditorContext",
-		"params":  map[string]any{},
+// recentChangesHandler handles the recent_changes tool call.
+func (m *MCPServer) recentChangesHandler(ctx context.Context, req *mcp.CallToolRequest, input RecentChangesInput) (*mcp.CallToolResult, RecentChangesOutput, error) {
+	result, err := m.request(ctx, "crush/recentChanges", m.withAuth(map[string]any{
+		"minutes": input.Minutes,
+	}))
+	if err != nil {
+		return nil, RecentChangesOutput{}, fmt.Errorf("failed to get recent changes: %w", err)
+	}
+
+	var changes lsp.RecentChangesResult
+	if err := json.Unmarshal(result, &changes); err != nil {
+		return nil, RecentChangesOutput{}, fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	reqBytes, err := json.Marshal(request)
+	added, removed := 0, 0
+	for _, f := range changes.Files {
+		added += f.Added
+		removed += f.Removed
+	}
+	return nil, RecentChangesOutput{
+		Summary: fmt.Sprintf("%d files changed since %s (+%d -%d lines)", len(changes.Files), changes.Since, added, removed),
+		Since:   changes.Since,
+		Files:   changes.Files,
+	}, nil
+}
+
+// relatedFilesHandler handles the related_files tool call.
+func (m *MCPServer) relatedFilesHandler(ctx context.Context, req *mcp.CallToolRequest, input RelatedFilesInput) (*mcp.CallToolResult, RelatedFilesOutput, error) {
+	result, err := m.request(ctx, "crush/relatedFiles", m.withAuth(map[string]any{
+		"useSelection": input.UseSelection,
+		"limit":        input.Limit,
+	}))
 	if err != nil {
-		return EditorContextOutput{}, err
+		return nil, RelatedFilesOutput{}, fmt.Errorf("failed to find related files: %w", err)
 	}
 
-	// Format as LSP message with Content-Length header
-	msg := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(reqBytes), reqBytes)
+	var output RelatedFilesOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, RelatedFilesOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
 
-	// Set a timeout for the request
-	if err := m.daemonConn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
-		return EditorContextOutput{}, err
+// sessionHistoryHandler handles the session_history tool call.
+func (m *MCPServer) sessionHistoryHandler(ctx context.Context, req *mcp.CallToolRequest, input SessionHistoryInput) (*mcp.CallToolResult, SessionHistoryOutput, error) {
+	result, err := m.request(ctx, "crush/history", m.withAuth(map[string]any{
+		"minutes":  input.Minutes,
+		"method":   input.Method,
+		"kind":     input.Kind,
+		"limit":    input.Limit,
+		"cursor":   input.Cursor,
+		"pageSize": cmp.Or(input.PageSize, defaultToolPageSize),
+	}))
+	if err != nil {
+		return nil, SessionHistoryOutput{}, fmt.Errorf("failed to get session history: %w", err)
 	}
 
-	if _, err := m.daemonConn.Write([]byte(msg)); err != nil {
-		return EditorContextOutput{}, err
+	var history lsp.HistoryResult
+	if err := json.Unmarshal(result, &history); err != nil {
+		return nil, SessionHistoryOutput{}, fmt.Errorf("failed to parse response: %w", err)
 	}
+	return nil, SessionHistoryOutput{
+		Session:    history.Session,
+		Records:    history.Records,
+		NextCursor: history.NextCursor,
+	}, nil
+}
 
-	// Read response
-	if err := m.daemonConn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
-		return EditorContextOutput{}, err
+// undoLastEditHandler handles the undo_last_edit tool call.
+func (m *MCPServer) undoLastEditHandler(ctx context.Context, req *mcp.CallToolRequest, input UndoLastEditInput) (*mcp.CallToolResult, UndoLastEditOutput, error) {
+	result, err := m.request(ctx, "crush/undoLastAgentEdit", m.withAuth(map[string]any{}))
+	if err != nil {
+		return nil, UndoLastEditOutput{}, fmt.Errorf("failed to undo: %w", err)
 	}
 
-	// Read Content-Length header
-	buf := make([]byte, 4096)
-	n, err := m.daemonConn.Read(buf)
+	var undo lsp.UndoLastAgentEditResult
+	if err := json.Unmarshal(result, &undo); err != nil {
+		return nil, UndoLastEditOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, UndoLastEditOutput{
+		Undone: undo.Undone,
+		Label:  undo.Group.Label,
+		URIs:   undo.URIs,
+		Reason: undo.Reason,
+	}, nil
+}
+
+// createCheckpointHandler handles the create_checkpoint tool call.
+func (m *MCPServer) createCheckpointHandler(ctx context.Context, req *mcp.CallToolRequest, input CreateCheckpointInput) (*mcp.CallToolResult, CreateCheckpointOutput, error) {
+	result, err := m.request(ctx, "crush/createCheckpoint", m.withAuth(map[string]any{
+		"name":  input.Name,
+		"paths": input.Paths,
+	}))
 	if err != nil {
-		return EditorContextOutput{}, err
+		return nil, CreateCheckpointOutput{}, fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+
+	var output CreateCheckpointOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, CreateCheckpointOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// editSelectionsHandler handles the edit_selections tool call.
+func (m *MCPServer) editSelectionsHandler(ctx context.Context, req *mcp.CallToolRequest, input EditSelectionsInput) (*mcp.CallToolResult, EditSelectionsOutput, error) {
+	edits := make([]lsp.SelectionEdit, 0, len(input.Edits))
+	for _, e := range input.Edits {
+		edits = append(edits, lsp.SelectionEdit{Index: e.Index, NewText: e.NewText, OldText: e.OldText})
+	}
+	result, err := m.request(ctx, "crush/editSelections", m.withAuth(map[string]any{
+		"edits": edits,
+	}))
+	if err != nil {
+		return nil, EditSelectionsOutput{}, fmt.Errorf("failed to edit selections: %w", err)
+	}
+
+	var output EditSelectionsOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, EditSelectionsOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// applyEditHandler handles the apply_edit tool call.
+func (m *MCPServer) applyEditHandler(ctx context.Context, req *mcp.CallToolRequest, input ApplyEditInput) (*mcp.CallToolResult, ApplyEditOutput, error) {
+	result, err := m.request(ctx, "crush/editFile", m.withAuth(map[string]any{
+		"path":  input.Path,
+		"edits": []lsp.TextEdit{{Range: input.Range, NewText: input.NewText}},
+	}))
+	if err != nil {
+		return nil, ApplyEditOutput{}, fmt.Errorf("failed to edit %s: %w", input.Path, err)
+	}
+
+	var output ApplyEditOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, ApplyEditOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// runTestsHandler handles the run_tests tool call.
+func (m *MCPServer) runTestsHandler(ctx context.Context, req *mcp.CallToolRequest, input RunTestsInput) (*mcp.CallToolResult, RunTestsOutput, error) {
+	// The daemon kills the job after jobs.timeout_ms
+	ctx, cancel := context.WithTimeout(ctx, runTestsTimeout)
+	defer cancel()
+
+	result, err := m.request(ctx, "crush/runJob", m.withAuth(map[string]any{
+		"kind":     input.Kind,
+		"args":     input.Args,
+		"language": input.Language,
+		"wait":     true,
+	}))
+	if err != nil {
+		return nil, RunTestsOutput{}, fmt.Errorf("failed to run %s: %w", cmp.Or(input.Kind, "tests"), err)
+	}
+
+	var output RunTestsOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, RunTestsOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// recentJobsHandler handles the recent_jobs tool call.
+func (m *MCPServer) recentJobsHandler(ctx context.Context, req *mcp.CallToolRequest, input RecentJobsInput) (*mcp.CallToolResult, RecentJobsOutput, error) {
+	result, err := m.request(ctx, "crush/getRecentJobs", m.withAuth(map[string]any{
+		"kind":   input.Kind,
+		"failed": input.Failed,
+		"limit":  input.Limit,
+	}))
+	if err != nil {
+		return nil, RecentJobsOutput{}, fmt.Errorf("failed to get recent jobs: %w", err)
+	}
+
+	var output RecentJobsOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, RecentJobsOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// listTodosHandler handles the list_todos tool call.
+func (m *MCPServer) listTodosHandler(ctx context.Context, req *mcp.CallToolRequest, input ListTodosInput) (*mcp.CallToolResult, ListTodosOutput, error) {
+	result, err := m.request(ctx, "crush/listTodos", m.withAuth(map[string]any{
+		"path":     input.Path,
+		"cursor":   input.Cursor,
+		"pageSize": cmp.Or(input.PageSize, defaultToolPageSize),
+	}))
+	if err != nil {
+		return nil, ListTodosOutput{}, fmt.Errorf("failed to list TODOs: %w", err)
+	}
+
+	var list lsp.ListTodosResult
+	if err := json.Unmarshal(result, &list); err != nil {
+		return nil, ListTodosOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, ListTodosOutput{
+		Todos:      list.Todos,
+		Truncated:  list.Truncated,
+		NextCursor: list.NextCursor,
+	}, nil
+}
+
+// stageFilesHandler handles the stage_files tool call.
+func (m *MCPServer) stageFilesHandler(ctx context.Context, req *mcp.CallToolRequest, input StageFilesInput) (*mcp.CallToolResult, StageFilesOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitToolTimeout)
+	defer cancel()
+
+	result, err := m.request(ctx, "crush/stageFiles", m.withAuth(map[string]any{
+		"paths": input.Paths,
+	}))
+	if err != nil {
+		return nil, StageFilesOutput{}, fmt.Errorf("failed to stage files: %w", err)
+	}
+
+	var output StageFilesOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, StageFilesOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// commitHandler handles the commit tool call.
+func (m *MCPServer) commitHandler(ctx context.Context, req *mcp.CallToolRequest, input CommitInput) (*mcp.CallToolResult, CommitOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitToolTimeout)
+	defer cancel()
+
+	result, err := m.request(ctx, "crush/commit", m.withAuth(map[string]any{
+		"message": input.Message,
+	}))
+	if err != nil {
+		return nil, CommitOutput{}, fmt.Errorf("failed to commit: %w", err)
+	}
+
+	var output CommitOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, CommitOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// openScratchHandler handles the open_scratch tool call.
+func (m *MCPServer) openScratchHandler(ctx context.Context, req *mcp.CallToolRequest, input OpenScratchInput) (*mcp.CallToolResult, OpenScratchOutput, error) {
+	result, err := m.request(ctx, "crush/openScratch", m.withAuth(map[string]any{
+		"uri":        input.URI,
+		"title":      input.Title,
+		"languageId": input.LanguageID,
+		"text":       input.Text,
+		"append":     input.Append,
+	}))
+	if err != nil {
+		return nil, OpenScratchOutput{}, fmt.Errorf("failed to open scratch buffer: %w", err)
+	}
+
+	var output OpenScratchOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, OpenScratchOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// workspaceStatsHandler handles the workspace_stats tool call.
+func (m *MCPServer) workspaceStatsHandler(ctx context.Context, req *mcp.CallToolRequest, input WorkspaceStatsInput) (*mcp.CallToolResult, WorkspaceStatsOutput, error) {
+	result, err := m.request(ctx, "crush/workspaceStats", m.withAuth(map[string]any{
+		"path":    input.Path,
+		"largest": input.Largest,
+		"refresh": input.Refresh,
+	}))
+	if err != nil {
+		return nil, WorkspaceStatsOutput{}, fmt.Errorf("failed to get workspace statistics: %w", err)
+	}
+
+	var output WorkspaceStatsOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, WorkspaceStatsOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// getEnvironmentHandler handles the get_environment tool call.
+func (m *MCPServer) getEnvironmentHandler(ctx context.Context, req *mcp.CallToolRequest, input GetEnvironmentInput) (*mcp.CallToolResult, GetEnvironmentOutput, error) {
+	// Each tool probe may take up to probeTimeout
+	ctx, cancel := context.WithTimeout(ctx, 2*probeTimeout)
+	defer cancel()
+
+	result, err := m.request(ctx, "crush/getEnvironment", m.withAuth(map[string]any{
+		"refresh": input.Refresh,
+	}))
+	if err != nil {
+		return nil, GetEnvironmentOutput{}, fmt.Errorf("failed to get the environment: %w", err)
+	}
+
+	var output GetEnvironmentOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, GetEnvironmentOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
+
+// appendTranscriptHandler handles the append_transcript tool call.
+func (m *MCPServer) appendTranscriptHandler(ctx context.Context, req *mcp.CallToolRequest, input AppendTranscriptInput) (*mcp.CallToolResult, AppendTranscriptOutput, error) {
+	params := m.withAuth(map[string]any{
+		"role": input.Role,
+		"text": input.Text,
+	})
+	result, err := m.request(ctx, "crush/appendTranscript", params)
+	if errors.Is(err, errDaemonOffline) && m.enqueue("crush/appendTranscript", params, false) {
+		return nil, AppendTranscriptOutput{Queued: true}, nil
+	}
+	if err != nil {
+		return nil, AppendTranscriptOutput{}, fmt.Errorf("failed to append to the conversation: %w", err)
 	}
 
-	// Parse the response
-	response := string(buf[:n])
+	var output AppendTranscriptOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, AppendTranscriptOutput{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil, output, nil
+}
 
-	// Find the JSON body after headers
-	_, jsonBody, found := strings.Cut(response, "\r\n\r\n")
-	if !found {
-		return EditorContextOutput{}, fmt.Errorf("invalid response format")
+// Register introduces the server to the daemon with crush/mcpInitialize and
+// returns the client ID it was given.
+func (m *MCPServer) Register(ctx context.Context) (string, error) {
+	result, err := m.request(ctx, "crush/mcpInitialize", m.withAuth(map[string]any{
+		"clientInfo": lsp.ClientInfo{Name: "neocrush-mcp", Version: version},
+		"tools":      m.tools,
+	}))
+	if err != nil {
+		return "", fmt.Errorf("failed to register with daemon: %w", err)
 	}
 
-	var resp struct {
-		Result EditorContextOutput `json:"result"`
-		Error  *struct {
-			Message string `json:"message"`
-		} `json:"error"`
+	var registered lsp.MCPInitializeResult
+	if err := json.Unmarshal(result, &registered); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return registered.ClientID, nil
+}
+
+// daemonNotification handles the notifications the daemon sends the MCP
+// server: log lines, progress on tool calls, and diagnostics to fix.
+func (m *MCPServer) daemonNotification(method string, content []byte) {
+	switch method {
+	case "crush/logMessage":
+		m.relayLog(content)
+	case "crush/fixDiagnostic", "crush/fixVerified":
+		m.relayFix(method, content)
+	case "$/progress":
+		m.progress.relay(content)
+	}
+}
+
+// notify sends a notification to the daemon.
+func (m *MCPServer) notify(method string, params any) error {
+	m.daemonMu.RLock()
+	c := m.daemon
+	m.daemonMu.RUnlock()
+	return c.Notify(method, params)
+}
+
+// requestEditorState sends a custom request to the daemon to get editor state.
+func (m *MCPServer) requestEditorState(ctx context.Context, includeDefinitions bool) (EditorContextOutput, error) {
+	result, err := m.request(ctx, "crush/getEditorContext", m.withAuth(map[string]any{
+		"includeDefinitions": includeDefinitions,
+	}))
+	if err != nil {
+		return EditorContextOutput{}, err
 	}
 
-	if err := json.Unmarshal([]byte(jsonBody), &resp); err != nil {
+	var state EditorContextOutput
+	if err := json.Unmarshal(result, &state); err != nil {
 		return EditorContextOutput{}, fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	if resp.Error != nil {
-		return EditorContextOutput{}, fmt.Errorf("daemon error: %s", resp.Error.Message)
+	return state, nil
+}
+
+// request sends a request to the daemon and waits for its response until
+// ctx is done, tracing it when enabled. Tool calls may run concurrently.
+// While the daemon is unreachable it fails with errDaemonOffline.
+func (m *MCPServer) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	if m.isOffline() {
+		return nil, errDaemonOffline
 	}
 
-	return resp.Result, nil
+	span := m.tracer.Start(method, tracing.KindClient, tracing.SpanContext{})
+	defer span.End()
+	span.SetAttr("rpc.system", "jsonrpc")
+	span.SetAttr("rpc.method", method)
+
+	// Tool calls with a progress token get the daemon's progress
+	if target, ok := ctx.Value(progressKey{}).(progressTarget); ok {
+		if p, ok := params.(map[string]any); ok {
+			token := m.progress.watch(target)
+			defer m.progress.unwatch(token)
+			p["workDoneToken"] = token
+		}
+	}
+
+	calls := m.dispatcher()
+	result, err := calls.Call(ctx, span.Context().Traceparent(), method, params)
+	if err != nil {
+		span.SetError(err.Error())
+	}
+	if err != nil && m.reconnect != nil {
+		select {
+		case <-calls.Done():
+			m.lost(calls)
+			return nil, fmt.Errorf("%w: %v", errDaemonOffline, err)
+		default:
+		}
+	}
+	return result, err
+}
+
+// withAuth adds the configured auth token to request params.
+func (m *MCPServer) withAuth(params map[string]any) map[string]any {
+	if m.authToken != "" {
+		params["authToken"] = m.authToken
+	}
+	return params
 }
 
 // RunWithReader starts the MCP server using a custom reader for stdin.
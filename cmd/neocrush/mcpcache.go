@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// contextCache reuses editor_context results, so hosts that poll it
+// between model steps don't have the daemon rebuild the context (or ask
+// Neovim for definitions) when nothing has changed. A result is served
+// as is for ttl, and after that for as long as the daemon's state version
+// matches the one it was built at.
+type contextCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[bool]cachedContext // By include_definitions
+}
+
+// cachedContext is an editor_context result and when it was last known to
+// be current.
+type cachedContext struct {
+	output  EditorContextOutput
+	checked time.Time
+}
+
+// newContextCache returns a cache keeping results for ttl, or nil (no
+// caching) if ttl is 0.
+func newContextCache(ttl time.Duration) *contextCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &contextCache{ttl: ttl, entries: make(map[bool]cachedContext)}
+}
+
+// cachedEditorState returns the editor context, from the cache when it is
+// still current.
+func (m *MCPServer) cachedEditorState(ctx context.Context, includeDefinitions bool) (EditorContextOutput, error) {
+	c := m.cache
+	if c == nil {
+		return m.requestEditorState(ctx, includeDefinitions)
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[includeDefinitions]
+	c.mu.Unlock()
+
+	if ok && time.Since(entry.checked) < c.ttl {
+		return entry.output, nil
+	}
+	if ok {
+		if version, err := m.stateVersion(ctx); err == nil && version == entry.output.StateVersion {
+			c.mu.Lock()
+			c.entries[includeDefinitions] = cachedContext{output: entry.output, checked: time.Now()}
+			c.mu.Unlock()
+			return entry.output, nil
+		}
+	}
+
+	output, err := m.requestEditorState(ctx, includeDefinitions)
+	if err != nil {
+		return output, err
+	}
+	c.mu.Lock()
+	c.entries[includeDefinitions] = cachedContext{output: output, checked: time.Now()}
+	c.mu.Unlock()
+	return output, nil
+}
+
+// stateVersion asks the daemon for its state version.
+func (m *MCPServer) stateVersion(ctx context.Context) (int64, error) {
+	result, err := m.request(ctx, "crush/stateVersion", m.withAuth(map[string]any{}))
+	if err != nil {
+		return 0, err
+	}
+
+	var version lsp.StateVersionResult
+	if err := json.Unmarshal(result, &version); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return version.Version, nil
+}
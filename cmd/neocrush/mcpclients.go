@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// mcpClientPrefix starts the client IDs of MCP connections ("mcp-1", ...).
+const mcpClientPrefix = "mcp-"
+
+// clientType returns the kind of client name is: "mcp" for MCP
+// connections, "crush" for every Crush agent, otherwise name itself.
+// Edits, rate limits and history are kept per type.
+func clientType(name string) string {
+	switch {
+	case strings.HasPrefix(name, mcpClientPrefix):
+		return "mcp"
+	case strings.HasPrefix(name, agentClientPrefix):
+		return "crush"
+	default:
+		return name
+	}
+}
+
+// registerMCP identifies conn as a new MCP client, from its
+// crush/mcpInitialize handshake or else its first crush/* request, and
+// returns its client ID.
+func (d *Daemon) registerMCP(conn net.Conn, method string, content []byte) string {
+	var req struct {
+		Params lsp.MCPInitializeParams `json:"params"`
+	}
+	if method == "crush/mcpInitialize" {
+		_ = json.Unmarshal(content, &req)
+	}
+
+	d.mu.Lock()
+	d.mcpClients++
+	id := fmt.Sprintf("%s%d", mcpClientPrefix, d.mcpClients)
+	d.clients[id] = conn
+	if req.Params.ClientInfo != (lsp.ClientInfo{}) {
+		d.clientInfo[id] = req.Params.ClientInfo
+	}
+	if req.Params.Tools != nil {
+		d.mcpTools[id] = req.Params.Tools
+	}
+	d.mu.Unlock()
+
+	d.logger.Printf("Client identified: %s (from %s)", id, method)
+	d.announcePresence("crush/clientConnected", d.presenceOf(id))
+	return id
+}
+
+// handleMCPInitialize answers crush/mcpInitialize with the connection's
+// client ID. A repeated handshake updates the client's info and tools.
+func (d *Daemon) handleMCPInitialize(clientName string, content []byte, conn net.Conn) {
+	if clientType(clientName) != "mcp" {
+		d.respondError(conn, messageID(content), lsp.InvalidRequest, "already identified as "+clientName)
+		return
+	}
+
+	var req struct {
+		Params lsp.MCPInitializeParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid mcpInitialize params: "+err.Error())
+		return
+	}
+
+	d.mu.Lock()
+	d.clientInfo[clientName] = req.Params.ClientInfo
+	d.mcpTools[clientName] = req.Params.Tools
+	d.mu.Unlock()
+
+	d.respondResult(conn, messageID(content), lsp.MCPInitializeResult{
+		ClientID:  clientName,
+		SessionID: d.sessionID,
+	})
+}
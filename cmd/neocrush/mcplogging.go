@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// subscribeLogs is receiving middleware that, once the host sets a level
+// with logging/setLevel, subscribes to the daemon's log at that level with
+// crush/setLogLevel.
+func (m *MCPServer) subscribeLogs(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		result, err := next(ctx, method, req)
+		if err != nil || method != "logging/setLevel" {
+			return result, err
+		}
+		params, ok := req.GetParams().(*mcp.SetLoggingLevelParams)
+		if !ok {
+			return result, nil
+		}
+		if _, err := m.request(ctx, "crush/setLogLevel", m.withAuth(map[string]any{
+			"level": params.Level,
+		})); err != nil {
+			return nil, fmt.Errorf("failed to subscribe to daemon logs: %w", err)
+		}
+		m.offline.mu.Lock()
+		m.offline.logLevel = string(params.Level)
+		m.offline.mu.Unlock()
+		return result, nil
+	}
+}
+
+// relayLog sends a crush/logMessage from the daemon on to the host as an
+// MCP log message. The SDK drops those below the host's level.
+func (m *MCPServer) relayLog(content []byte) {
+	var msg struct {
+		Params lsp.LogMessageParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return
+	}
+	for session := range m.server.Sessions() {
+		_ = session.Log(context.Background(), &mcp.LoggingMessageParams{
+			Level:  mcp.LoggingLevel(msg.Params.Level),
+			Logger: msg.Params.Logger,
+			Data:   msg.Params.Message,
+		})
+	}
+}
+
+// fixLogger names the MCP log messages carrying crush/fixDiagnostic and
+// crush/fixVerified.
+const fixLogger = "neocrush.fix"
+
+// relayFix sends a diagnostic the user asked the agent to fix, or the
+// outcome of the fix, on to the host as a notice-level MCP log message from
+// fixLogger. Its data holds the method and params.
+func (m *MCPServer) relayFix(method string, content []byte) {
+	var msg struct {
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return
+	}
+	for session := range m.server.Sessions() {
+		_ = session.Log(context.Background(), &mcp.LoggingMessageParams{
+			Level:  "notice",
+			Logger: fixLogger,
+			Data:   map[string]any{"method": method, "params": msg.Params},
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/client"
+)
+
+const (
+	// defaultReconnectDelay is the first wait before redialing a daemon that
+	// went away. It doubles after each failed attempt, up to
+	// maxReconnectDelay.
+	defaultReconnectDelay = 250 * time.Millisecond
+	maxReconnectDelay     = 30 * time.Second
+	// maxQueuedRequests bounds the requests held while offline; the oldest
+	// are dropped first.
+	maxQueuedRequests = 100
+	// flushTimeout bounds each queued request sent after reconnecting.
+	flushTimeout = 10 * time.Second
+)
+
+// errDaemonOffline fails tool calls while the MCP server has lost the
+// daemon and is reconnecting.
+var errDaemonOffline = errors.New("daemon unreachable, reconnecting")
+
+// offlineState is what the MCP server keeps to serve tool calls while the
+// daemon is unreachable: the last editor context it got, and requests that
+// can wait for the daemon to come back. Requests that change the workspace
+// are never queued; they fail until the daemon is back.
+type offlineState struct {
+	mu       sync.Mutex
+	offline  bool                         // The daemon is gone; a reconnect loop is running
+	contexts map[bool]EditorContextOutput // Last editor_context results, by include_definitions
+	queue    []queuedRequest              // Held until reconnected, oldest first
+	logLevel string                       // Level subscribed with crush/setLogLevel, renewed on reconnect
+}
+
+// queuedRequest is a request (or notification) to send once the daemon is
+// back.
+type queuedRequest struct {
+	method string
+	params map[string]any
+	notify bool
+}
+
+// attach starts using c for daemon requests. When its connection goes away
+// and the server can reconnect, the server goes offline and redials in the
+// background.
+func (m *MCPServer) attach(c *client.Client) {
+	calls := c.Dispatch(m.daemonNotification)
+	m.daemonMu.Lock()
+	m.daemon = c
+	m.calls = calls
+	m.daemonMu.Unlock()
+
+	go func() {
+		<-calls.Done()
+		m.lost(calls)
+	}()
+}
+
+// lost takes the server offline after the connection of calls went away,
+// unless it was already replaced, and starts redialing.
+func (m *MCPServer) lost(calls *client.Dispatcher) {
+	if m.reconnect == nil || calls != m.dispatcher() {
+		return
+	}
+	m.offline.mu.Lock()
+	if m.offline.offline {
+		m.offline.mu.Unlock()
+		return
+	}
+	m.offline.offline = true
+	m.offline.mu.Unlock()
+	m.logger.Printf("Lost the daemon connection, reconnecting")
+	go m.reconnectLoop()
+}
+
+// dispatcher returns the dispatcher of the current daemon connection.
+func (m *MCPServer) dispatcher() *client.Dispatcher {
+	m.daemonMu.RLock()
+	defer m.daemonMu.RUnlock()
+	return m.calls
+}
+
+// isOffline reports whether the server has lost the daemon and is
+// reconnecting.
+func (m *MCPServer) isOffline() bool {
+	m.offline.mu.Lock()
+	defer m.offline.mu.Unlock()
+	return m.offline.offline
+}
+
+// reconnectLoop redials the daemon with exponential backoff until it
+// answers, then registers again and sends the queued requests.
+func (m *MCPServer) reconnectLoop() {
+	delay := m.reconnectDelay
+	for {
+		time.Sleep(delay)
+		c, err := m.reconnect()
+		if err != nil {
+			m.logger.Printf("Reconnecting to daemon failed: %v", err)
+			delay = min(delay*2, maxReconnectDelay)
+			continue
+		}
+		m.attach(c)
+
+		m.offline.mu.Lock()
+		m.offline.offline = false
+		queue := m.offline.queue
+		m.offline.queue = nil
+		level := m.offline.logLevel
+		m.offline.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		if id, err := m.Register(ctx); err != nil {
+			m.logger.Printf("%v", err)
+		} else {
+			m.logger.Printf("Reconnected to daemon as %s", id)
+		}
+		if level != "" {
+			if _, err := m.request(ctx, "crush/setLogLevel", m.withAuth(map[string]any{"level": level})); err != nil {
+				m.logger.Printf("Failed to renew the log subscription: %v", err)
+			}
+		}
+		cancel()
+		m.flush(queue)
+		return
+	}
+}
+
+// flush sends the requests queued while offline, in order.
+func (m *MCPServer) flush(queue []queuedRequest) {
+	for _, q := range queue {
+		var err error
+		if q.notify {
+			err = m.notify(q.method, q.params)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			_, err = m.request(ctx, q.method, q.params)
+			cancel()
+		}
+		if err != nil {
+			m.logger.Printf("Failed to send queued %s: %v", q.method, err)
+		}
+	}
+}
+
+// enqueue holds a request until the daemon is back. It reports false if
+// the server is online, so the caller should send the request itself.
+func (m *MCPServer) enqueue(method string, params map[string]any, notify bool) bool {
+	m.offline.mu.Lock()
+	defer m.offline.mu.Unlock()
+	if !m.offline.offline {
+		return false
+	}
+	if len(m.offline.queue) >= maxQueuedRequests {
+		m.offline.queue = m.offline.queue[1:]
+	}
+	m.offline.queue = append(m.offline.queue, queuedRequest{method: method, params: params, notify: notify})
+	return true
+}
+
+// rememberContext keeps an editor_context result to serve while offline.
+func (m *MCPServer) rememberContext(includeDefinitions bool, output EditorContextOutput) {
+	m.offline.mu.Lock()
+	defer m.offline.mu.Unlock()
+	if m.offline.contexts == nil {
+		m.offline.contexts = make(map[bool]EditorContextOutput)
+	}
+	m.offline.contexts[includeDefinitions] = output
+}
+
+// staleContext returns the last editor_context result, marked stale. Without
+// definitions, one with definitions will do.
+func (m *MCPServer) staleContext(includeDefinitions bool) (EditorContextOutput, bool) {
+	m.offline.mu.Lock()
+	defer m.offline.mu.Unlock()
+	output, ok := m.offline.contexts[includeDefinitions]
+	if !ok && !includeDefinitions {
+		output, ok = m.offline.contexts[true]
+		output.Definitions = nil
+	}
+	output.Stale = ok
+	return output, ok
+}
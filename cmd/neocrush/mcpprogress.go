@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// progressKey is the context key of a tool call's progressTarget.
+type progressKey struct{}
+
+// progressTarget is where the progress of a tool call goes: the host's
+// progress token on its session.
+type progressTarget struct {
+	session *mcp.ServerSession
+	token   any
+}
+
+// trackProgress is receiving middleware that gives tool calls carrying a
+// progress token a progressTarget, so their daemon requests report to it.
+func (m *MCPServer) trackProgress(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		if call, ok := req.(*mcp.CallToolRequest); ok && call.Params != nil {
+			if token := call.Params.GetProgressToken(); token != nil {
+				ctx = context.WithValue(ctx, progressKey{}, progressTarget{session: call.Session, token: token})
+			}
+		}
+		return next(ctx, method, req)
+	}
+}
+
+// progressRelay maps the work-done tokens the MCP server sends the daemon
+// to the host's progress tokens, and relays the daemon's $/progress to the
+// host as MCP progress notifications.
+type progressRelay struct {
+	mu      sync.Mutex
+	nextID  int
+	targets map[string]*relayedProgress // Work-done token -> where it goes
+}
+
+// relayedProgress is a tool call's progress as relayed so far.
+type relayedProgress struct {
+	target   progressTarget
+	progress float64 // Last percentage sent (-1 = none yet); MCP requires it to increase
+}
+
+func newProgressRelay() *progressRelay {
+	return &progressRelay{targets: make(map[string]*relayedProgress)}
+}
+
+// watch returns a work-done token whose progress is relayed to target
+// until unwatch.
+func (r *progressRelay) watch(target progressTarget) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	token := fmt.Sprintf("mcp-progress-%d", r.nextID)
+	r.targets[token] = &relayedProgress{target: target, progress: -1}
+	return token
+}
+
+// unwatch stops relaying token.
+func (r *progressRelay) unwatch(token string) {
+	r.mu.Lock()
+	delete(r.targets, token)
+	r.mu.Unlock()
+}
+
+// relay sends a $/progress notification from the daemon on to the host,
+// as a percentage: 0 when the work begins and 100 when it ends.
+func (r *progressRelay) relay(content []byte) {
+	var notif lsp.ProgressNotification
+	if err := json.Unmarshal(content, &notif); err != nil {
+		return
+	}
+	var token string
+	if err := json.Unmarshal(notif.Params.Token, &token); err != nil {
+		return
+	}
+
+	value := notif.Params.Value
+	params := &mcp.ProgressNotificationParams{Message: value.Message, Total: 100}
+	switch value.Kind {
+	case "begin":
+		params.Message = value.Title
+	case "report":
+		if value.Percentage == nil {
+			return
+		}
+		params.Progress = float64(*value.Percentage)
+	case "end":
+		params.Progress = 100
+	}
+
+	r.mu.Lock()
+	p := r.targets[token]
+	if p == nil || params.Progress <= p.progress {
+		r.mu.Unlock()
+		return
+	}
+	p.progress = params.Progress
+	target := p.target
+	r.mu.Unlock()
+
+	params.ProgressToken = target.token
+	_ = target.session.NotifyProgress(context.Background(), params)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// renderSnippet asks the daemon to draw lines of a file.
+func (m *MCPServer) renderSnippet(ctx context.Context, params lsp.RenderSnippetParams) (lsp.RenderSnippetResult, error) {
+	result, err := m.request(ctx, "crush/renderSnippet", m.withAuth(map[string]any{
+		"path":      params.Path,
+		"startLine": params.StartLine,
+		"endLine":   params.EndLine,
+		"highlight": params.Highlight,
+	}))
+	if err != nil {
+		return lsp.RenderSnippetResult{}, fmt.Errorf("failed to render %s: %w", params.Path, err)
+	}
+
+	var image lsp.RenderSnippetResult
+	if err := json.Unmarshal(result, &image); err != nil {
+		return lsp.RenderSnippetResult{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return image, nil
+}
+
+// renderContext draws the code around the cursor of an editor_context
+// result, or returns nil if the cursor is not in a file.
+func (m *MCPServer) renderContext(ctx context.Context, state EditorContextOutput) (*lsp.RenderSnippetResult, error) {
+	path, err := uriToPath(state.URI)
+	if err != nil {
+		return nil, nil // No file, or a scratch buffer
+	}
+	line := state.CursorLine + 1
+	params := lsp.RenderSnippetParams{Path: path, StartLine: line, EndLine: line, Highlight: line}
+	if state.ContextBefore != "" {
+		params.StartLine -= strings.Count(state.ContextBefore, "\n") + 1
+	}
+	if state.ContextAfter != "" {
+		params.EndLine += strings.Count(state.ContextAfter, "\n") + 1
+	}
+	image, err := m.renderSnippet(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// withImage returns a tool result holding output, as the SDK would send
+// it, followed by image as an image content block.
+func withImage(output any, image *lsp.RenderSnippetResult) (*mcp.CallToolResult, error) {
+	if image == nil {
+		return nil, nil
+	}
+	text, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	return &mcp.CallToolResult{Content: []mcp.Content{
+		&mcp.TextContent{Text: string(text)},
+		&mcp.ImageContent{Data: image.Data, MIMEType: image.MIMEType},
+	}}, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/taigrr/neocrush/internal/config"
+)
+
+// toolSet registers the tools an MCPServer offers, under the names the
+// mcp config gives them. The first name collision is kept in err and
+// stops further registrations.
+type toolSet struct {
+	server *mcp.Server
+	cfg    config.MCPConfig
+	names  []string // Names offered, in registration order
+	err    error
+}
+
+// addTool registers tool with handler unless the config disables it,
+// renaming it to its alias.
+func addTool[In, Out any](ts *toolSet, tool *mcp.Tool, handler mcp.ToolHandlerFor[In, Out]) {
+	if ts.err != nil {
+		return
+	}
+	name := ts.cfg.ToolName(tool.Name)
+	if name == "" {
+		return
+	}
+	if slices.Contains(ts.names, name) {
+		ts.err = fmt.Errorf("tool %s: name %q is already taken", tool.Name, name)
+		return
+	}
+	tool.Name = name
+	mcp.AddTool(ts.server, tool, handler)
+	ts.names = append(ts.names, name)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/client"
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// statusClientName identifies the status command to the daemon. It must
+// not look like Neovim or Crush, or the daemon would route traffic to it.
+const statusClientName = "status"
+
+// handleGetMetrics answers crush/getMetrics with per-method traffic
+// statistics.
+func (d *Daemon) handleGetMetrics(content []byte, conn net.Conn) {
+	d.respondResult(conn, messageID(content), d.metricsResult())
+}
+
+func (d *Daemon) metricsResult() lsp.GetMetricsResult {
+	result := lsp.GetMetricsResult{
+		UptimeMS: time.Since(d.metrics.Started()).Milliseconds(),
+		Methods:  []lsp.MethodMetrics{},
+		Leaks:    d.leakWarnings(),
+	}
+
+	d.mu.RLock()
+	for name := range d.clients {
+		result.Clients = append(result.Clients, name)
+	}
+	result.Desyncs = d.desyncs
+	d.mu.RUnlock()
+	slices.Sort(result.Clients)
+
+	for _, s := range d.metrics.Snapshot() {
+		result.Methods = append(result.Methods, lsp.MethodMetrics{
+			Method:        s.Method,
+			Count:         s.Count,
+			Bytes:         s.Bytes,
+			AvgBytes:      s.AvgBytes(),
+			MaxBytes:      s.MaxBytes,
+			Responses:     s.Responses,
+			ResponseBytes: s.ResponseBytes,
+			Unanswered:    s.Unanswered,
+			Invalid:       s.Invalid,
+			P50MS:         milliseconds(s.P50),
+			P90MS:         milliseconds(s.P90),
+			P99MS:         milliseconds(s.P99),
+		})
+	}
+	return result
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func newStatusCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the running daemon's clients and traffic statistics",
+		Long: `Connects to the daemon serving the workspace in the current directory
+(without starting one) and prints its connected clients and per-method
+traffic: message counts, sizes, and how long peers take to answer
+forwarded requests. Methods are listed heaviest traffic first, so costly
+traffic such as full-document didChange stands out.`,
+		Example: `  neocrush status
+  neocrush status --json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			sess, err := session.NewManager().LoadSessionMetadata(cwd)
+			if err != nil {
+				return fmt.Errorf("no neocrush session for %s", cwd)
+			}
+
+			metrics, err := fetchMetrics(sess.SocketPath, cwd)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if jsonOutput {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(metrics)
+			}
+			fmt.Fprintf(out, "Session:  %s\n", sess.ID)
+			fmt.Fprintf(out, "Socket:   %s\n", sess.SocketPath)
+			if d := sess.Daemon; d.PID > 0 {
+				fmt.Fprintf(out, "Daemon:   pid %d, %s, started %s\n", d.PID, d.Version, d.StartedAt.Local().Format(time.DateTime))
+				if d.Version != version {
+					fmt.Fprintf(out, "          running %s, this binary is %s; run neocrush restart to upgrade\n", d.Executable, version)
+				}
+			}
+			return writeStatus(out, metrics)
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the metrics as JSON")
+	return cmd
+}
+
+// fetchMetrics identifies as the status client and asks the daemon for
+// crush/getMetrics.
+func fetchMetrics(socketPath, workspace string) (lsp.GetMetricsResult, error) {
+	var metrics lsp.GetMetricsResult
+
+	c, err := connectAs(socketPath, workspace, statusClientName)
+	if err != nil {
+		return metrics, err
+	}
+	defer c.Close()
+
+	result, err := c.Request("crush/getMetrics", nil)
+	if err != nil {
+		return metrics, err
+	}
+	if err := json.Unmarshal(result, &metrics); err != nil {
+		return metrics, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// connectAs dials the daemon and identifies as name, authenticating with
+// the workspace's token if one is configured.
+func connectAs(socketPath, workspace, name string) (*client.Client, error) {
+	c, err := client.Dial(socketPath, log.New(io.Discard, "", 0))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := map[string]any{}
+	if cfg, err := config.Load(workspace); err == nil && cfg.Security.AuthToken != "" {
+		opts["authToken"] = cfg.Security.AuthToken
+	}
+	if _, err := c.Request("initialize", map[string]any{
+		"clientInfo":            map[string]any{"name": name},
+		"initializationOptions": opts,
+	}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// writeStatus prints metrics as a summary and a per-method table.
+func writeStatus(out io.Writer, metrics lsp.GetMetricsResult) error {
+	clients := slices.DeleteFunc(metrics.Clients, func(name string) bool { return name == statusClientName })
+	if len(clients) == 0 {
+		clients = []string{"none"}
+	}
+	fmt.Fprintf(out, "Uptime:   %s\n", (time.Duration(metrics.UptimeMS) * time.Millisecond).Round(time.Second))
+	fmt.Fprintf(out, "Clients:  %s\n", strings.Join(clients, ", "))
+	fmt.Fprintf(out, "Desyncs:  %d\n", metrics.Desyncs)
+	for _, l := range metrics.Leaks {
+		fmt.Fprintf(out, "Growing:  %s grew from %d to %d since %s\n",
+			l.Gauge, l.From, l.To, time.UnixMilli(l.SinceMS).Local().Format(time.DateTime))
+	}
+	fmt.Fprintln(out)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tCOUNT\tBYTES\tAVG\tMAX\tP50\tP90\tP99\tUNANSWERED\tINVALID\t")
+	for _, m := range metrics.Methods {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t\n",
+			m.Method, m.Count, formatBytes(m.Bytes), formatBytes(int64(m.AvgBytes)), formatBytes(int64(m.MaxBytes)),
+			formatLatency(m.Responses, m.P50MS), formatLatency(m.Responses, m.P90MS), formatLatency(m.Responses, m.P99MS),
+			m.Unanswered, m.Invalid)
+	}
+	return w.Flush()
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
+func formatLatency(responses int, ms float64) string {
+	if responses == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fms", ms)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// lane is a send priority. Lower lanes are always drained first.
+type lane int
+
+const (
+	// laneInteractive carries responses and user-facing requests (hover,
+	// completion, confirmation dialogs) that someone is waiting on.
+	laneInteractive lane = iota
+	// laneBulk carries document sync traffic and other background updates.
+	laneBulk
+	// laneCursor carries cursor and selection updates. Only the latest
+	// update per method is kept.
+	laneCursor
+	numLanes
+)
+
+const (
+	// maxQueued bounds messages waiting for a slow client before sends fail.
+	maxQueued = 4096
+	// sendTimeout bounds a single write to a client.
+	sendTimeout = 10 * time.Second
+)
+
+var errQueueFull = errors.New("send queue full")
+
+// bulkMethods are document sync messages that may yield to interactive traffic.
+var bulkMethods = map[string]bool{
+	"textDocument/didOpen":            true,
+	"textDocument/didChange":          true,
+	"textDocument/didClose":           true,
+	"textDocument/didSave":            true,
+	"textDocument/publishDiagnostics": true,
+	"workspace/applyEdit":             true,
+	"$/progress":                      true,
+	"crush/documentChanged":           true,
+	"crush/logMessage":                true,
+	"crush/jobStarted":                true,
+	"crush/jobOutput":                 true,
+	"crush/jobFinished":               true,
+}
+
+// cursorMethods are high-frequency updates where only the latest matters.
+var cursorMethods = map[string]bool{
+	"crush/cursorMoved":      true,
+	"crush/selectionChanged": true,
+	"crush/focusChanged":     true,
+}
+
+// classify picks the lane for a method ("" for responses).
+func classify(method string) lane {
+	switch {
+	case cursorMethods[method]:
+		return laneCursor
+	case bulkMethods[method]:
+		return laneBulk
+	default:
+		return laneInteractive
+	}
+}
+
+// hasDocument reports whether a message's params name a document, as
+// requests such as hover and completion do.
+func hasDocument(content []byte) bool {
+	var msg struct {
+		Params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		} `json:"params"`
+	}
+	return json.Unmarshal(content, &msg) == nil && msg.Params.TextDocument.URI != ""
+}
+
+// queued is a message waiting in a lane.
+type queued struct {
+	method string
+	msg    []byte
+}
+
+// outbox is a client connection whose writes go through a per-client send
+// queue with priority lanes, so a flood of document syncs can't starve a
+// pending hover or confirmation dialog. It also serializes all writes to
+// the connection. Reads and everything else go to the embedded net.Conn.
+type outbox struct {
+	net.Conn
+	logger *log.Logger
+	sent   func(o *outbox, msg []byte)       // Observes each frame written, if set
+	faults func(o *outbox, msg []byte) fault // Decides the faults injected into each frame, if set
+
+	mu     sync.Mutex
+	lanes  [numLanes][]queued
+	count  int
+	closed bool
+	wake   chan struct{}
+	done   chan struct{} // Closed once the writer has stopped
+}
+
+// newOutbox wraps conn and starts its writer. sent, if non-nil, is called
+// with each frame after it is written.
+func newOutbox(conn net.Conn, logger *log.Logger, sent func(o *outbox, msg []byte)) *outbox {
+	o := &outbox{
+		Conn:   conn,
+		logger: logger,
+		sent:   sent,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go o.run()
+	return o
+}
+
+// Write queues one LSP frame on the lane for its method. It never blocks
+// on the network.
+func (o *outbox) Write(b []byte) (int, error) {
+	method, content, _ := rpc.DecodeMessage(b)
+	l := classify(method)
+	msg := append([]byte(nil), b...) // Callers may reuse b (e.g. scanner buffers)
+
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	// A request about a document must see the syncs sent before it, so it
+	// waits behind them instead of overtaking them
+	if l == laneInteractive && len(o.lanes[laneBulk]) > 0 && hasDocument(content) {
+		l = laneBulk
+	}
+
+	replaced := false
+	if l == laneCursor {
+		for i, q := range o.lanes[l] {
+			if q.method == method {
+				o.lanes[l][i].msg = msg
+				replaced = true
+				break
+			}
+		}
+	}
+	if !replaced {
+		if o.count >= maxQueued {
+			o.mu.Unlock()
+			return 0, errQueueFull
+		}
+		o.lanes[l] = append(o.lanes[l], queued{method: method, msg: msg})
+		o.count++
+	}
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// Close stops accepting messages. Queued messages are still flushed before
+// the connection is closed.
+func (o *outbox) Close() error {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next pops the highest-priority queued message.
+func (o *outbox) next() ([]byte, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for l := range o.lanes {
+		if len(o.lanes[l]) > 0 {
+			q := o.lanes[l][0]
+			o.lanes[l] = o.lanes[l][1:]
+			o.count--
+			return q.msg, true
+		}
+	}
+	return nil, false
+}
+
+// write sends msg, at about bytesPerSecond if that is set.
+func (o *outbox) write(msg []byte, bytesPerSecond int) error {
+	if bytesPerSecond <= 0 {
+		if err := o.Conn.SetWriteDeadline(time.Now().Add(sendTimeout)); err != nil {
+			return err
+		}
+		_, err := o.Conn.Write(msg)
+		return err
+	}
+
+	// A tenth of a second's worth at a time
+	chunk := max(bytesPerSecond/10, 1)
+	for len(msg) > 0 {
+		n := min(chunk, len(msg))
+		if err := o.Conn.SetWriteDeadline(time.Now().Add(sendTimeout)); err != nil {
+			return err
+		}
+		if _, err := o.Conn.Write(msg[:n]); err != nil {
+			return err
+		}
+		msg = msg[n:]
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(bytesPerSecond))
+	}
+	return nil
+}
+
+// flushed is closed once the outbox has been closed and drained (or its
+// connection failed).
+func (o *outbox) flushed() <-chan struct{} {
+	return o.done
+}
+
+// run writes queued messages until the outbox is closed and drained.
+func (o *outbox) run() {
+	defer close(o.done)
+	defer o.Conn.Close()
+
+	for {
+		msg, ok := o.next()
+		if !ok {
+			o.mu.Lock()
+			closed := o.closed
+			o.mu.Unlock()
+			if closed {
+				return
+			}
+			<-o.wake
+			continue
+		}
+
+		var f fault
+		if o.faults != nil {
+			f = o.faults(o, msg)
+		}
+		if f.drop {
+			continue
+		}
+		time.Sleep(f.delay)
+		if f.truncate > 0 {
+			o.Conn.Write(msg[:f.truncate])
+			o.logger.Printf("Injected fault: closed the connection %d bytes into a %d byte message", f.truncate, len(msg))
+			o.mu.Lock()
+			o.closed = true
+			o.mu.Unlock()
+			return
+		}
+
+		if err := o.write(msg, f.bytesPerSecond); err != nil {
+			o.logger.Printf("Write failed, dropping connection: %v", err)
+			o.mu.Lock()
+			o.closed = true
+			o.mu.Unlock()
+			return
+		}
+		if o.sent != nil {
+			o.sent(o, msg)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// pageSnapshotTTL is how long a paginated result is kept after its
+	// last page was read.
+	pageSnapshotTTL = 5 * time.Minute
+	// maxPageSnapshots bounds the results kept for paging; the least
+	// recently read is dropped first.
+	maxPageSnapshots = 32
+)
+
+// errCursorExpired rejects a cursor whose result is no longer kept.
+var errCursorExpired = errors.New("cursor is unknown or expired; list again without a cursor")
+
+// pageSnapshots keeps the full results of paginated requests, so every
+// page of a listing comes from the same snapshot even if the workspace
+// changes between calls, and a cursor returns the same page when retried.
+type pageSnapshots struct {
+	mu        sync.Mutex
+	nextID    int
+	snapshots map[int]*pageSnapshot
+}
+
+// pageSnapshot is one paginated result.
+type pageSnapshot struct {
+	method string
+	result any // The full result, as the handler built it
+	used   time.Time
+}
+
+func newPageSnapshots() *pageSnapshots {
+	return &pageSnapshots{snapshots: make(map[int]*pageSnapshot)}
+}
+
+// save keeps result for paging and returns its snapshot ID.
+func (p *pageSnapshots) save(method string, result any) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+	if len(p.snapshots) >= maxPageSnapshots {
+		oldest := -1
+		for id, s := range p.snapshots {
+			if oldest < 0 || s.used.Before(p.snapshots[oldest].used) {
+				oldest = id
+			}
+		}
+		delete(p.snapshots, oldest)
+	}
+	p.nextID++
+	p.snapshots[p.nextID] = &pageSnapshot{method: method, result: result, used: time.Now()}
+	return p.nextID
+}
+
+// load returns the snapshot and offset a cursor for method names.
+func (p *pageSnapshots) load(method, cursor string) (result any, id, offset int, err error) {
+	id, offset, err = parseCursor(cursor)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+	s := p.snapshots[id]
+	if s == nil || s.method != method {
+		return nil, 0, 0, errCursorExpired
+	}
+	s.used = time.Now()
+	return s.result, id, offset, nil
+}
+
+// expire drops snapshots not read for pageSnapshotTTL. Callers hold p.mu.
+func (p *pageSnapshots) expire() {
+	for id, s := range p.snapshots {
+		if time.Since(s.used) > pageSnapshotTTL {
+			delete(p.snapshots, id)
+		}
+	}
+}
+
+// pageCursor names the page of snapshot id starting at offset.
+func pageCursor(id, offset int) string {
+	return fmt.Sprintf("%d.%d", id, offset)
+}
+
+// parseCursor reverses pageCursor.
+func parseCursor(cursor string) (id, offset int, err error) {
+	idText, offsetText, ok := strings.Cut(cursor, ".")
+	id, idErr := strconv.Atoi(idText)
+	offset, offsetErr := strconv.Atoi(offsetText)
+	if !ok || idErr != nil || offsetErr != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return id, offset, nil
+}
+
+// paginate returns the bounds of the page params asks for within total
+// items starting at offset, and the cursor of the next page ("" if this is
+// the last). A page size of 0 returns everything left. snapshot is called
+// for the snapshot ID when a next cursor is needed.
+func paginate(params lsp.PageParams, total, offset int, snapshot func() int) (start, end int, next string) {
+	start = min(offset, total)
+	end = total
+	if params.PageSize > 0 {
+		end = min(start+params.PageSize, total)
+	}
+	if end < total {
+		next = pageCursor(snapshot(), end)
+	}
+	return start, end, next
+}
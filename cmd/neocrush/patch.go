@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/diff"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// patchClientName identifies the export-patch and import-patch commands to
+// the daemon.
+const patchClientName = "patch"
+
+// recordBaseline keeps the text of uri before its first agent edit, for
+// crush/exportPatch. An empty baseline is a file the agent created.
+func (d *Daemon) recordBaseline(uri, oldText string) {
+	d.mu.Lock()
+	if _, ok := d.baselines[uri]; !ok {
+		d.baselines[uri] = oldText
+	}
+	d.mu.Unlock()
+}
+
+// patchDocument is a document with agent edits, as crush/exportPatch sees
+// it.
+type patchDocument struct {
+	uri      string
+	base     string // Text before the first agent edit
+	hasBase  bool
+	buffer   string // Neovim's buffer, or the daemon's copy
+	target   string // buffer with the hunks pending review
+	reviewed bool   // Hunks are pending review
+}
+
+// handleExportPatch answers crush/exportPatch.
+func (d *Daemon) handleExportPatch(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.ExportPatchParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid exportPatch params: "+err.Error())
+		return
+	}
+	scope := req.Params.Scope
+	if scope != "" && scope != lsp.PatchApplied && scope != lsp.PatchPending {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, fmt.Sprintf("unknown scope %q", scope))
+		return
+	}
+
+	d.mu.RLock()
+	uris := slices.Collect(maps.Keys(d.baselines))
+	for uri := range d.reviews {
+		if _, ok := d.baselines[uri]; !ok {
+			uris = append(uris, uri)
+		}
+	}
+	docs := make([]patchDocument, 0, len(uris))
+	var unsynced []int
+	for _, uri := range uris {
+		doc := patchDocument{uri: uri}
+		doc.base, doc.hasBase = d.baselines[uri]
+		if r := d.reviews[uri]; r != nil {
+			doc.buffer = strings.Join(r.lines, "\n")
+			doc.target = strings.Join(r.target(), "\n")
+			doc.reviewed = true
+		} else if text, ok := d.documentState[uri]; ok {
+			doc.buffer, doc.target = text, text
+		} else {
+			unsynced = append(unsynced, len(docs))
+		}
+		docs = append(docs, doc)
+	}
+	d.mu.RUnlock()
+	for _, i := range unsynced {
+		docs[i].buffer = d.documentText(docs[i].uri)
+		docs[i].target = docs[i].buffer
+	}
+
+	type filePatch struct {
+		path, diff string
+	}
+	var patches []filePatch
+	for _, doc := range docs {
+		path, err := uriToPath(doc.uri)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(d.workspace, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			d.logger.Printf("Not exporting %s, outside the workspace root", path)
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+
+		oldPath, oldText, newText := rel, doc.base, doc.target
+		switch {
+		case scope == lsp.PatchApplied && doc.hasBase:
+			newText = doc.buffer
+		case scope == lsp.PatchPending && doc.reviewed:
+			oldText = doc.buffer
+		case scope == "" && !doc.hasBase:
+			oldText = doc.buffer
+		case scope != "":
+			continue
+		}
+		if doc.hasBase && doc.base == "" && scope != lsp.PatchPending {
+			oldPath = "" // Created by the agent
+		}
+		if patch := diff.Unified(oldPath, rel, oldText, newText); patch != "" {
+			patches = append(patches, filePatch{rel, patch})
+		}
+	}
+	slices.SortFunc(patches, func(a, b filePatch) int { return strings.Compare(a.path, b.path) })
+
+	var sb strings.Builder
+	result := lsp.ExportPatchResult{Files: []string{}}
+	for _, p := range patches {
+		sb.WriteString(p.diff)
+		result.Files = append(result.Files, p.path)
+	}
+	result.Patch = sb.String()
+	d.respondResult(conn, messageID(content), result)
+}
+
+// handleImportPatch answers crush/importPatch. The patch applies to every
+// file, and the protected paths, protected branch and rate limits allow
+// the whole of it, before any file is written, so a rejected patch
+// changes nothing.
+func (d *Daemon) handleImportPatch(from string, content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.ImportPatchParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid importPatch params: "+err.Error())
+		return
+	}
+	files, err := diff.Parse(req.Params.Patch)
+	if err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid patch: "+err.Error())
+		return
+	}
+	if len(files) == 0 {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "the patch changes no files")
+		return
+	}
+
+	ctx := d.requestContext(conn, content)
+	writes := make([]lsp.WriteFileParams, 0, len(files))
+	for _, f := range files {
+		params, code, err := d.patchedFile(ctx, f)
+		if err != nil {
+			d.respondError(conn, messageID(content), code, err.Error())
+			return
+		}
+		writes = append(writes, params)
+	}
+
+	stats := editStats{uris: make([]string, 0, len(writes))}
+	for _, params := range writes {
+		stats.uris = append(stats.uris, "file://"+params.Path)
+		stats.bytes += len(params.Content)
+	}
+	if err := d.checkEdit(from, "crush/importPatch", stats); err != nil {
+		if data := editErrorData(err); data != nil {
+			d.respondErrorData(conn, messageID(content), lsp.RequestFailed, err.Error(), data)
+		} else {
+			d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+		}
+		return
+	}
+
+	result := lsp.ImportPatchResult{Files: make([]lsp.WriteFileResult, 0, len(writes))}
+	for i, params := range writes {
+		written, code, err := d.applyWrite(ctx, from, params, false)
+		if err != nil {
+			d.respondError(conn, messageID(content), code, fmt.Sprintf("%s: %v (%d of %d files written)", files[i].NewPath, err, i, len(writes)))
+			return
+		}
+		result.Files = append(result.Files, written)
+	}
+	d.logger.Printf("Imported a patch of %d files from %s", len(writes), from)
+	d.respondResult(conn, messageID(content), result)
+}
+
+// patchedFile returns the write applying f to Neovim's buffer of the file,
+// if it has the file open, or else the file on disk.
+func (d *Daemon) patchedFile(ctx context.Context, f diff.File) (lsp.WriteFileParams, int, error) {
+	switch {
+	case f.NewPath == "":
+		return lsp.WriteFileParams{}, lsp.InvalidParams, fmt.Errorf("deleting %s is not supported", f.OldPath)
+	case f.OldPath != "" && f.OldPath != f.NewPath:
+		return lsp.WriteFileParams{}, lsp.InvalidParams, fmt.Errorf("renaming %s is not supported", f.OldPath)
+	}
+	path, err := d.resolveWorkspacePath(f.NewPath)
+	if err != nil {
+		return lsp.WriteFileParams{}, lsp.InvalidParams, fmt.Errorf("%s: %w", f.NewPath, err)
+	}
+
+	var text string
+	if f.OldPath != "" {
+		uri := "file://" + path
+		d.mu.RLock()
+		neovimHasFile := d.neovimOpenDocs[uri]
+		d.mu.RUnlock()
+		var current *string
+		if neovimHasFile {
+			current = d.documentContent(ctx, "neovim", uri)
+		}
+		if current != nil {
+			text = *current
+		} else {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return lsp.WriteFileParams{}, lsp.RequestFailed, fmt.Errorf("%s: %w", f.NewPath, err)
+			}
+			text = string(data)
+		}
+	}
+	patched, err := f.Apply(text)
+	if err != nil {
+		return lsp.WriteFileParams{}, lsp.RequestFailed, fmt.Errorf("%s: %w", f.NewPath, err)
+	}
+	return lsp.WriteFileParams{Path: path, Content: patched, Create: f.OldPath == ""}, 0, nil
+}
+
+func newExportPatchCmd() *cobra.Command {
+	var scope, output string
+
+	cmd := &cobra.Command{
+		Use:   "export-patch",
+		Short: "Write the agents' edits as a unified diff",
+		Long: `Connects to the daemon serving the workspace in the current directory and
+prints the agents' edits as a unified diff, for review in other tools or to
+share. Applied edits run from each file's text before the first agent edit
+to its current text, unsaved changes in Neovim included; pending edits are
+the hunks awaiting review. Both are exported by default.`,
+		Example: `  neocrush export-patch > agent.patch
+  neocrush export-patch --scope pending -o pending.patch`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var result lsp.ExportPatchResult
+			if err := requestPatch("crush/exportPatch", lsp.ExportPatchParams{Scope: scope}, &result); err != nil {
+				return err
+			}
+			if result.Patch == "" {
+				fmt.Fprintln(os.Stderr, "No agent edits")
+				return nil
+			}
+			if output == "" || output == "-" {
+				_, err := io.WriteString(cmd.OutOrStdout(), result.Patch)
+				return err
+			}
+			if err := os.WriteFile(output, []byte(result.Patch), 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Wrote %s (%d files)\n", output, len(result.Files))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&scope, "scope", "", `Edits to export: "applied" or "pending" (default both)`)
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the patch to a file instead of stdout")
+	return cmd
+}
+
+func newImportPatchCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import-patch [FILE]",
+		Short: "Apply a unified diff through the daemon",
+		Long: `Applies a unified diff, read from FILE or stdin, to the workspace in the
+current directory as an agent's edit would be: files open in Neovim are
+changed in their buffers, the others on disk, with the daemon's guardrails
+and formatting. Every file must match the patch exactly, or nothing is
+changed. Paths are relative to the workspace; the a/ and b/ prefixes of git
+diffs are accepted.`,
+		Example: `  neocrush import-patch agent.patch
+  git diff | neocrush import-patch`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var data []byte
+			var err error
+			if len(args) == 0 || args[0] == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+
+			var result lsp.ImportPatchResult
+			if err := requestPatch("crush/importPatch", lsp.ImportPatchParams{Patch: string(data)}, &result); err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			for _, f := range result.Files {
+				status := "applied"
+				if !f.Applied {
+					status = "rejected in Neovim"
+				}
+				fmt.Fprintf(out, "%s  %s (%s)\n", f.Path, status, f.Via)
+			}
+			return nil
+		},
+	}
+}
+
+// requestPatch sends method to the daemon serving the current directory and
+// decodes its result into result.
+func requestPatch(method string, params, result any) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	sess, err := session.NewManager().LoadSessionMetadata(cwd)
+	if err != nil {
+		return fmt.Errorf("no neocrush session for %s", cwd)
+	}
+	c, err := connectAs(sess.SocketPath, cwd, patchClientName)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	raw, err := c.Request(method, params)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("failed to parse %s result: %w", method, err)
+	}
+	return nil
+}
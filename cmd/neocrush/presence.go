@@ -0,0 +1,65 @@
+package main
+
+import (
+	"cmp"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// toolClients are neocrush's own commands that attach to the daemon.
+// They neither receive nor cause presence events.
+var toolClients = map[string]bool{
+	statusClientName:   true,
+	inspectClientName:  true,
+	patchClientName:    true,
+	chaosClientName:    true,
+	bundleClientName:   true,
+	sessionsClientName: true,
+	killClientName:     true,
+}
+
+// announcePresence sends method (crush/clientConnected or
+// crush/clientDisconnected) about who to every other connected client.
+func (d *Daemon) announcePresence(method string, who lsp.ClientPresenceParams) {
+	if toolClients[who.Type] {
+		return
+	}
+
+	self := cmp.Or(who.ID, who.Type)
+	d.mu.RLock()
+	var conns []net.Conn
+	for name, conn := range d.clients {
+		if name != self && !toolClients[name] {
+			conns = append(conns, conn)
+		}
+	}
+	d.mu.RUnlock()
+
+	if len(conns) == 0 {
+		return
+	}
+	msg := []byte(rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  who,
+	}))
+	for _, conn := range conns {
+		if _, err := conn.Write(msg); err != nil {
+			d.logger.Printf("Failed to send %s: %v", method, err)
+		}
+	}
+}
+
+// presenceOf returns the presence params of a connected client.
+func (d *Daemon) presenceOf(client string) lsp.ClientPresenceParams {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	who := lsp.ClientPresenceParams{Type: clientType(client), Name: d.clientInfo[client].Name}
+	if who.Type != client {
+		who.ID = client
+	}
+	return who
+}
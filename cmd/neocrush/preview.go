@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// preview is a file an agent created, held back from disk until the user
+// accepts it.
+type preview struct {
+	text string
+	from string // The agent that wrote it
+}
+
+// previewsCreated reports whether files agents create are staged as
+// previews: review.preview_created is set and Neovim is there to show them.
+func (d *Daemon) previewsCreated() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.previewing && d.clients["neovim"] != nil
+}
+
+// stagePreview holds text, the content client from gave a new file uri,
+// and shows it to Neovim as a read-only preview.
+func (d *Daemon) stagePreview(from, uri, text string, result lsp.WriteFileResult) lsp.WriteFileResult {
+	d.mu.Lock()
+	d.previews[uri] = preview{text: text, from: from}
+	d.mu.Unlock()
+	d.state.Touch()
+
+	languageID, _ := d.language(uri)
+	d.forwardToNeovim([]byte(rpc.EncodeMessage(lsp.PreviewFileNotification{
+		Notification: lsp.Notification{
+			RPC:    "2.0",
+			Method: "crush/previewFile",
+		},
+		Params: lsp.PreviewFileParams{URI: uri, Text: text, LanguageID: languageID, Client: from},
+	})))
+	d.logger.Printf("Staged %s as a preview for %s", uri, from)
+
+	result.Via = "preview"
+	return result
+}
+
+// handleResolvePreview answers crush/resolvePreview from Neovim. An
+// accepted preview is written to disk, unless a file appeared there in the
+// meantime; then the preview stays pending.
+func (d *Daemon) handleResolvePreview(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.ResolvePreviewParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid resolvePreview params: "+err.Error())
+		return
+	}
+	uri := req.Params.URI
+
+	d.mu.Lock()
+	p, ok := d.previews[uri]
+	delete(d.previews, uri)
+	d.mu.Unlock()
+	if !ok {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "no pending preview of "+uri)
+		return
+	}
+	d.state.Touch()
+
+	if !req.Params.Accept {
+		d.logger.Printf("Discarded the preview of %s", uri)
+		d.respondResult(conn, messageID(content), lsp.ResolvePreviewResult{})
+		return
+	}
+
+	path, _ := uriToPath(uri)
+	var code int
+	var err error
+	if _, statErr := os.Stat(path); !errors.Is(statErr, fs.ErrNotExist) {
+		code, err = lsp.RequestFailed, fmt.Errorf("%s exists now; discard the preview or move the file", extractFilename(uri))
+	} else {
+		_, code, err = d.writeDisk(p.from, uri, "", "", p.text, lsp.WriteFileResult{Path: path, Created: true})
+	}
+	if err != nil {
+		d.mu.Lock()
+		d.previews[uri] = p
+		d.mu.Unlock()
+		d.respondError(conn, messageID(content), code, err.Error())
+		return
+	}
+	d.respondResult(conn, messageID(content), lsp.ResolvePreviewResult{Written: true})
+}
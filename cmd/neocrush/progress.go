@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// trackProgress records work-done progress tokens a client begins and ends,
+// so progress left open by a client that disconnects can be closed in the
+// peer's UI rather than spinning forever.
+func (d *Daemon) trackProgress(from string, content []byte) {
+	var notif lsp.ProgressNotification
+	if err := json.Unmarshal(content, &notif); err != nil || len(notif.Params.Token) == 0 {
+		return
+	}
+
+	token := string(notif.Params.Token)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch notif.Params.Value.Kind {
+	case "begin":
+		d.progressTokens[token] = from
+	case "end":
+		delete(d.progressTokens, token)
+	}
+}
+
+// endOrphanedProgress sends $/progress end to the peer for every token still
+// open by a disconnected client.
+func (d *Daemon) endOrphanedProgress(client string) {
+	d.mu.Lock()
+	var tokens []string
+	for token, owner := range d.progressTokens {
+		if owner == client {
+			tokens = append(tokens, token)
+			delete(d.progressTokens, token)
+		}
+	}
+	var peers []net.Conn
+	for _, name := range d.peersLocked(client) {
+		if conn := d.clients[name]; conn != nil {
+			peers = append(peers, conn)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, token := range tokens {
+		end := lsp.ProgressNotification{
+			Notification: lsp.Notification{
+				RPC:    "2.0",
+				Method: "$/progress",
+			},
+			Params: lsp.ProgressParams{
+				Token: lsp.ProgressToken(token),
+				Value: lsp.WorkDoneProgressValue{
+					Kind:    "end",
+					Message: client + " disconnected",
+				},
+			},
+		}
+		for _, peer := range peers {
+			if _, err := peer.Write([]byte(rpc.EncodeMessage(end))); err != nil {
+				d.logger.Printf("Failed to end progress %s: %v", token, err)
+			}
+		}
+	}
+}
+
+// workDone reports the progress of a request the daemon serves to the
+// client that sent it, under the params.workDoneToken it gave. Its methods
+// do nothing on a nil *workDone, which requests without a token get.
+type workDone struct {
+	conn    net.Conn
+	token   lsp.ProgressToken
+	percent int // Last percentage reported
+}
+
+// beginWorkDone starts reporting progress on the request in content, if it
+// carries a workDoneToken.
+func beginWorkDone(conn net.Conn, content []byte, title string) *workDone {
+	var req struct {
+		Params struct {
+			WorkDoneToken lsp.ProgressToken `json:"workDoneToken"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		return nil
+	}
+	if token := req.Params.WorkDoneToken; len(token) == 0 || string(token) == "null" {
+		return nil
+	}
+	w := &workDone{conn: conn, token: req.Params.WorkDoneToken}
+	w.send(lsp.WorkDoneProgressValue{Kind: "begin", Title: title, Cancellable: true})
+	return w
+}
+
+// report sends how much of the work is done, with an optional message.
+// Calls that don't raise the percentage are skipped, so callers can report
+// every item of a long loop.
+func (w *workDone) report(done, total int, message string) {
+	if w == nil || total <= 0 {
+		return
+	}
+	percentage := done * 100 / total
+	if percentage <= w.percent {
+		return
+	}
+	w.percent = percentage
+	w.send(lsp.WorkDoneProgressValue{Kind: "report", Message: message, Percentage: &percentage})
+}
+
+// end reports that the work has finished.
+func (w *workDone) end(message string) {
+	if w == nil {
+		return
+	}
+	w.send(lsp.WorkDoneProgressValue{Kind: "end", Message: message})
+}
+
+func (w *workDone) send(value lsp.WorkDoneProgressValue) {
+	w.conn.Write([]byte(rpc.EncodeMessage(lsp.ProgressNotification{
+		Notification: lsp.Notification{RPC: "2.0", Method: "$/progress"},
+		Params:       lsp.ProgressParams{Token: w.token, Value: value},
+	})))
+}
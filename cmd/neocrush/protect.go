@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// protectedAnnotation labels edits to protected files in confirm mode.
+const protectedAnnotation = "crush-protected"
+
+// protectedError rejects an agent edit of a protected file.
+type protectedError struct {
+	path    string // Relative to the workspace root
+	setting string // The setting protecting it
+}
+
+func (e *protectedError) Error() string {
+	return fmt.Sprintf("%s is protected (%s) and cannot be edited by agents", e.path, e.setting)
+}
+
+// branchError rejects an agent edit while the workspace is on a protected
+// branch.
+type branchError struct {
+	branch string
+}
+
+func (e *branchError) Error() string {
+	return fmt.Sprintf("the workspace is on %s, a protected branch (git.protected_branches); switch branches before agents edit", e.branch)
+}
+
+// protectedMatcher compiles files.protected, returning nil when empty.
+func protectedMatcher(patterns []string) *workspace.Matcher {
+	if len(patterns) == 0 {
+		return nil
+	}
+	var m workspace.Matcher
+	m.Add("", patterns)
+	return &m
+}
+
+// protectedPath reports whether uri is a workspace file matched by
+// files.protected or its language's protected patterns, returning its path
+// relative to the workspace folder it is in and the setting that matched.
+// Symlinks are checked on both sides.
+func (d *Daemon) protectedPath(uri string) (string, string, bool) {
+	type matcher struct {
+		setting string
+		m       *workspace.Matcher
+	}
+	var matchers []matcher
+	if d.protected != nil {
+		matchers = append(matchers, matcher{"files.protected", d.protected})
+	}
+	if language, profile := d.language(uri); profile.protected != nil {
+		matchers = append(matchers, matcher{"languages." + language + ".protected", profile.protected})
+	}
+	if len(matchers) == 0 || d.workspace == "" {
+		return "", "", false
+	}
+	path, err := uriToPath(uri)
+	if err != nil {
+		return "", "", false
+	}
+	path = filepath.Clean(path)
+
+	for _, root := range d.roots() {
+		real := root
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			real = r
+		}
+		for _, candidate := range []struct{ root, path string }{
+			{root, path},
+			{real, evalExisting(path)},
+		} {
+			rel, err := filepath.Rel(candidate.root, candidate.path)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+			for _, m := range matchers {
+				if m.m.Matches(rel) {
+					return rel, m.setting, true
+				}
+			}
+		}
+	}
+	return "", "", false
+}
+
+// protectedBranch returns the branch the workspace is on if it is matched
+// by git.protected_branches. The branch is read from HEAD on each call, so
+// switching branches takes effect at once.
+func (d *Daemon) protectedBranch() (string, bool) {
+	if len(d.branches.ProtectedBranches) == 0 || d.workspace == "" {
+		return "", false
+	}
+	branch := workspace.Branch(d.workspace)
+	if branch == "" {
+		return "", false
+	}
+	for _, pattern := range d.branches.ProtectedBranches {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return branch, true
+		}
+	}
+	return "", false
+}
+
+// confirmProtected reports whether edits to uri must be confirmed in
+// Neovim because it is protected and protected_mode is "confirm", or the
+// workspace is on a protected branch and protected_branch_mode is
+// "confirm".
+func (d *Daemon) confirmProtected(uri string) bool {
+	if d.branches.ProtectedBranchMode == config.ProtectedModeConfirm {
+		if _, ok := d.protectedBranch(); ok {
+			return true
+		}
+	}
+	if d.protectedMode != config.ProtectedModeConfirm {
+		return false
+	}
+	_, _, ok := d.protectedPath(uri)
+	return ok
+}
+
+// protectedLabel labels the confirmation of an edit confirmProtected
+// holds back.
+func (d *Daemon) protectedLabel() string {
+	if d.branches.ProtectedBranchMode == config.ProtectedModeConfirm {
+		if branch, ok := d.protectedBranch(); ok {
+			return "Edit on protected branch " + branch
+		}
+	}
+	return "Edit to protected file"
+}
+
+// checkEdit vets an agent edit of uris before it is applied: edits on
+// protected branches and to protected files are rejected (unless they are
+// to be confirmed instead) and the edit is counted against the rate limits.
+// Violations are reported to both editors.
+func (d *Daemon) checkEdit(client, method string, stats editStats) error {
+	var first string
+	if len(stats.uris) > 0 {
+		first = stats.uris[0]
+	}
+
+	if d.branches.ProtectedBranchMode != config.ProtectedModeConfirm {
+		if branch, ok := d.protectedBranch(); ok {
+			err := &branchError{branch: branch}
+			d.reportError(lsp.ErrorParams{
+				Code:    lsp.ErrorCodeProtectedBranch,
+				Message: fmt.Sprintf("%s edit rejected: %v", client, err),
+				Method:  method,
+				URI:     first,
+			})
+			return err
+		}
+	}
+
+	if d.protectedMode != config.ProtectedModeConfirm {
+		for _, uri := range stats.uris {
+			if rel, setting, ok := d.protectedPath(uri); ok {
+				err := &protectedError{path: rel, setting: setting}
+				d.reportError(lsp.ErrorParams{
+					Code:    lsp.ErrorCodeProtected,
+					Message: fmt.Sprintf("%s edit rejected: %v", client, err),
+					Method:  method,
+					URI:     uri,
+				})
+				return err
+			}
+		}
+	}
+
+	return d.reserveEdit(client, method, first, stats.op())
+}
+
+// guardApplyEdit vets a workspace/applyEdit request from Crush. A rejected
+// request is answered with applied: false and nil is returned; otherwise
+// the message to forward is returned, rewritten to need confirmation if it
+// touches protected files in confirm mode or crosses the guardrails.
+func (d *Daemon) guardApplyEdit(msg, content []byte, conn net.Conn) []byte {
+	if !d.protectsFiles() && len(d.branches.ProtectedBranches) == 0 && !d.quota.Enabled() && d.guardrails == (config.GuardrailsConfig{}) {
+		return msg
+	}
+
+	stats := workspaceEditStats(content)
+	if err := d.checkEdit("crush", "workspace/applyEdit", stats); err != nil {
+		d.respondResult(conn, messageID(content), lsp.ApplyWorkspaceEditResult{
+			Applied:       false,
+			FailureReason: err.Error(),
+		})
+		return nil
+	}
+
+	for _, uri := range stats.uris {
+		if d.confirmProtected(uri) {
+			return requireConfirmation(msg, content, protectedAnnotation, d.protectedLabel())
+		}
+	}
+	if d.oversized(stats) {
+		return requireConfirmation(msg, content, largeEditAnnotation, largeEditLabel(stats))
+	}
+	return msg
+}
+
+// requireConfirmation rewrites a workspace/applyEdit request so every
+// change carries annotation, labelled label, with needsConfirmation set.
+// Returns msg unchanged if it cannot be parsed.
+func requireConfirmation(msg, content []byte, annotation, label string) []byte {
+	var req map[string]any
+	if err := json.Unmarshal(content, &req); err != nil {
+		return msg
+	}
+	params, _ := req["params"].(map[string]any)
+	edit, _ := params["edit"].(map[string]any)
+	if edit == nil {
+		return msg
+	}
+
+	// Annotations are only carried by documentChanges
+	docChanges, _ := edit["documentChanges"].([]any)
+	if changes, ok := edit["changes"].(map[string]any); ok {
+		for uri, edits := range changes {
+			docChanges = append(docChanges, map[string]any{
+				"textDocument": map[string]any{"uri": uri, "version": nil},
+				"edits":        edits,
+			})
+		}
+		delete(edit, "changes")
+	}
+
+	for _, change := range docChanges {
+		change, ok := change.(map[string]any)
+		if !ok {
+			continue
+		}
+		edits, ok := change["edits"].([]any)
+		if !ok {
+			change["annotationId"] = annotation // create, rename, or delete
+			continue
+		}
+		for _, e := range edits {
+			if e, ok := e.(map[string]any); ok {
+				e["annotationId"] = annotation
+			}
+		}
+	}
+	edit["documentChanges"] = docChanges
+
+	annotations, _ := edit["changeAnnotations"].(map[string]any)
+	if annotations == nil {
+		annotations = make(map[string]any)
+	}
+	annotations[annotation] = map[string]any{
+		"label":             label,
+		"needsConfirmation": true,
+	}
+	edit["changeAnnotations"] = annotations
+
+	return []byte(rpc.EncodeMessage(req))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/charset"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// maxReadFileSize caps files crush/readFile will load from disk.
+	maxReadFileSize = 10 << 20
+	// binarySniffLen is how much of a file is checked for NUL bytes.
+	binarySniffLen = charset.SniffLen
+)
+
+// handleReadFile answers crush/readFile with a workspace file's content,
+// preferring Neovim's buffer over the file on disk.
+func (d *Daemon) handleReadFile(content []byte, conn net.Conn) {
+	id := messageID(content)
+
+	var req struct {
+		Params lsp.ReadFileParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, id, lsp.InvalidParams, "invalid readFile params: "+err.Error())
+		return
+	}
+
+	result, code, err := d.readFile(d.requestContext(conn, content), req.Params)
+	if err != nil {
+		d.respondError(conn, id, code, err.Error())
+		return
+	}
+	d.respondResult(conn, id, result)
+}
+
+// readFile loads the requested lines. On failure it also returns the
+// JSON-RPC error code to answer with.
+func (d *Daemon) readFile(ctx context.Context, params lsp.ReadFileParams) (lsp.ReadFileResult, int, error) {
+	path, err := d.resolveWorkspacePath(params.Path)
+	if err != nil {
+		return lsp.ReadFileResult{}, lsp.InvalidParams, err
+	}
+	result := lsp.ReadFileResult{Path: path, Source: "disk"}
+
+	uri := "file://" + path
+	d.mu.RLock()
+	neovimHasFile := d.neovimOpenDocs[uri]
+	staged, previewed := d.previews[uri]
+	d.mu.RUnlock()
+
+	var data []byte
+	if neovimHasFile {
+		if text := d.documentContent(ctx, "neovim", uri); text != nil {
+			data = []byte(*text)
+			result.Source = "buffer"
+		}
+	}
+	if data == nil && previewed {
+		data = []byte(staged.text)
+		result.Source = "preview"
+	}
+	if data == nil {
+		var code int
+		if data, code, err = readDisk(ctx, path, params.Path); err != nil {
+			var tooLarge *fileTooLargeError
+			if errors.As(err, &tooLarge) {
+				result.Size = tooLarge.size
+				result.TooLarge = true
+				return result, 0, nil
+			}
+			return result, code, err
+		}
+	}
+	result.Size = int64(len(data))
+
+	if charset.Binary(data) {
+		result.Binary = true
+		return result, 0, nil
+	}
+	// A whole file that large is more than a tool should take in; a range
+	// of it is fine
+	if len(data) > d.maxDocumentSize() && params.StartLine <= 0 && params.EndLine <= 0 {
+		result.TooLarge = true
+		return result, 0, nil
+	}
+
+	text, encoding := charset.Decode(data)
+	result.Encoding = string(encoding)
+
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	result.TotalLines = len(lines)
+
+	start, end := params.StartLine, params.EndLine
+	if start <= 0 {
+		start = 1
+	}
+	if end <= 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		if len(lines) == 0 && params.StartLine <= 1 {
+			return result, 0, nil // Empty file
+		}
+		return result, lsp.InvalidParams, fmt.Errorf("line range %d-%d is outside the file's %d lines", params.StartLine, params.EndLine, len(lines))
+	}
+
+	result.StartLine = start
+	result.EndLine = end
+	result.Content = strings.Join(lines[start-1:end], "")
+	return result, 0, nil
+}
+
+// fileTooLargeError is the error of reading a file over maxReadFileSize.
+type fileTooLargeError struct {
+	name string
+	size int64
+}
+
+func (e *fileTooLargeError) Error() string {
+	return fmt.Sprintf("%s is %d bytes, more than the %d byte limit", e.name, e.size, maxReadFileSize)
+}
+
+// readDisk loads the file at path, refusing directories, other files that
+// aren't regular (a FIFO would block until written to) and files over
+// maxReadFileSize (with a *fileTooLargeError). name is the path as the
+// client gave it, for errors. A read that blocks on a hung network mount is
+// abandoned when ctx is done. On failure it also returns the JSON-RPC error
+// code to answer with.
+func readDisk(ctx context.Context, path, name string) ([]byte, int, error) {
+	type read struct {
+		data []byte
+		code int
+		err  error
+	}
+	done := make(chan read, 1)
+	go func() {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			done <- read{code: lsp.RequestFailed, err: err}
+		case info.IsDir():
+			done <- read{code: lsp.InvalidParams, err: fmt.Errorf("%s is a directory", name)}
+		case !info.Mode().IsRegular():
+			done <- read{code: lsp.InvalidParams, err: fmt.Errorf("%s is not a regular file", name)}
+		case info.Size() > maxReadFileSize:
+			done <- read{code: lsp.RequestFailed, err: &fileTooLargeError{name: name, size: info.Size()}}
+		default:
+			data, err := os.ReadFile(path)
+			done <- read{data: data, code: lsp.RequestFailed, err: err}
+		}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.code, r.err
+		}
+		return r.data, 0, nil
+	case <-ctx.Done():
+		return nil, failureCode(ctx.Err(), lsp.RequestFailed), fmt.Errorf("reading %s: %w", name, ctx.Err())
+	}
+}
+
+// loadDocument reads uri for crush/getEditorContext when the daemon has not
+// cached it: from Neovim's buffer, or else from disk if the file is inside
+// the workspace. For a binary document or one over files.max_document_bytes
+// it returns no text but the reason, lsp.ContentBinary or
+// lsp.ContentTooLarge. ok is false if uri can't be read.
+func (d *Daemon) loadDocument(ctx context.Context, uri string) (text, skipped string, ok bool) {
+	if text := d.documentContent(ctx, "neovim", uri); text != nil {
+		if skipped := d.contentSkip(*text); skipped != "" {
+			return "", skipped, true
+		}
+		return *text, "", true
+	}
+
+	path, err := uriToPath(uri)
+	if err == nil {
+		path, err = d.resolveWorkspacePath(path)
+	}
+	if err != nil {
+		d.logger.Printf("Not reading %s for editor context: %v", uri, err)
+		return "", "", false
+	}
+	data, _, err := readDisk(ctx, path, path)
+	var tooLarge *fileTooLargeError
+	if errors.As(err, &tooLarge) {
+		return "", lsp.ContentTooLarge, true
+	}
+	if err != nil {
+		d.logger.Printf("Not reading %s for editor context: %v", uri, err)
+		return "", "", false
+	}
+	if charset.Binary(data) {
+		return "", lsp.ContentBinary, true
+	}
+	text, _ = charset.Decode(data)
+	if skipped := d.contentSkip(text); skipped != "" {
+		return "", skipped, true
+	}
+	return text, "", true
+}
+
+// readDiskText reads the file at path as UTF-8 text with readDisk, so a
+// read that blocks is abandoned when ctx is done.
+func readDiskText(ctx context.Context, path string) (string, error) {
+	data, _, err := readDisk(ctx, path, path)
+	if err != nil {
+		return "", err
+	}
+	text, _ := charset.Decode(data)
+	return text, nil
+}
+
+// readText reads the file at path as UTF-8 text, transcoded from the
+// encoding it is in, which it also returns.
+func readText(path string) (string, charset.Encoding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	text, enc := charset.Decode(data)
+	return text, enc, nil
+}
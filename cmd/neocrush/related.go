@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/charset"
+	"github.com/taigrr/neocrush/internal/related"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// relatedIndexTTL is how long a related-files index is reused.
+	relatedIndexTTL = 5 * time.Minute
+	// maxIndexedFileSize skips large (usually generated) files.
+	maxIndexedFileSize = 256 << 10
+	// defaultRelatedLimit is the number of files returned when unset.
+	defaultRelatedLimit = 10
+)
+
+// relatedIndex is a cached related-files index.
+type relatedIndex struct {
+	index *related.Index
+	built time.Time
+}
+
+// handleRelatedFiles answers crush/relatedFiles with the workspace files
+// most similar to a document or the current selection.
+func (d *Daemon) handleRelatedFiles(content []byte, conn net.Conn) {
+	id := messageID(content)
+
+	var req struct {
+		Params lsp.RelatedFilesParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, id, lsp.InvalidParams, "invalid relatedFiles params: "+err.Error())
+		return
+	}
+	if !d.related.Enabled {
+		d.respondError(conn, id, lsp.RequestFailed, "related files are disabled; set related.enabled in the neocrush config")
+		return
+	}
+
+	d.mu.RLock()
+	uri := d.cursorURI
+	selection := d.selectionText
+	d.mu.RUnlock()
+	if req.Params.TextDocument != nil {
+		uri = req.Params.TextDocument.URI
+	}
+
+	var query string
+	if req.Params.UseSelection {
+		query = selection
+	} else if uri != "" {
+		query = d.documentText(uri)
+	}
+	if strings.TrimSpace(query) == "" {
+		d.respondError(conn, id, lsp.InvalidParams, "nothing to compare: no document or selection")
+		return
+	}
+
+	progress := beginWorkDone(conn, content, "Finding related files")
+	index, err := d.relatedFilesIndex(d.requestContext(conn, content), progress)
+	progress.end("")
+	if err != nil {
+		d.respondError(conn, id, failureCode(err, lsp.RequestFailed), err.Error())
+		return
+	}
+
+	limit := req.Params.Limit
+	if limit <= 0 {
+		limit = defaultRelatedLimit
+	}
+	exclude := ""
+	if path, err := uriToPath(uri); err == nil {
+		if rel, err := filepath.Rel(d.workspace, path); err == nil {
+			exclude = filepath.ToSlash(rel)
+		}
+	}
+
+	result := lsp.RelatedFilesResult{Files: []lsp.RelatedFile{}}
+	for _, m := range index.Query(query, exclude, limit) {
+		result.Files = append(result.Files, lsp.RelatedFile{Path: m.Path, Score: m.Score})
+	}
+	d.respondResult(conn, id, result)
+}
+
+// documentText returns the daemon's copy of uri, or the file on disk.
+func (d *Daemon) documentText(uri string) string {
+	d.mu.RLock()
+	text, ok := d.documentState[uri]
+	d.mu.RUnlock()
+	if ok {
+		return text
+	}
+	path, err := uriToPath(uri)
+	if err != nil {
+		return ""
+	}
+	if text, _, err = readText(path); err != nil {
+		return ""
+	}
+	return text
+}
+
+// relatedFilesIndex returns the cached index, rebuilding it from the
+// workspace listing when stale. A rebuild reports its progress and stops
+// when ctx is cancelled.
+func (d *Daemon) relatedFilesIndex(ctx context.Context, progress *workDone) (*related.Index, error) {
+	d.mu.RLock()
+	cached := d.relatedIndex
+	d.mu.RUnlock()
+	if cached != nil && time.Since(cached.built) < relatedIndexTTL {
+		return cached.index, nil
+	}
+
+	list, err := d.workspaceFiles(d.workspace, false)
+	if err != nil {
+		return nil, err
+	}
+
+	texts := make(map[string]string, len(list.files))
+	for i, f := range list.files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		progress.report(i, len(list.files), "Indexing "+f.Path)
+		if f.Size == 0 || f.Size > maxIndexedFileSize {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(d.workspace, filepath.FromSlash(f.Path)))
+		if err != nil || charset.Binary(data) {
+			continue
+		}
+		texts[f.Path], _ = charset.Decode(data)
+	}
+	if len(texts) == 0 {
+		return nil, errors.New("no text files to index")
+	}
+
+	index := related.Build(texts)
+	d.mu.Lock()
+	d.relatedIndex = &relatedIndex{index: index, built: time.Now()}
+	d.mu.Unlock()
+	d.logger.Printf("Indexed %d files for related-file suggestions", index.Len())
+	return index, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/tracing"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// relayedRequest records a request forwarded from one peer to the other
+// under a daemon-assigned ID, so the response can be routed back under the
+// ID the sender chose. Without rewriting, IDs picked independently by
+// Neovim, Crush, and the daemon itself collide.
+type relayedRequest struct {
+	from    string          // Client that sent the request ("" for the daemon)
+	to      string          // Client the request was sent to, if known
+	id      json.RawMessage // Original request ID
+	method  string
+	sent    time.Time
+	expired bool          // Already answered by the daemon; drop the late response
+	span    *tracing.Span // The hop to the peer, if traced
+
+	// onResponse, if set, observes the peer's response, including one that
+	// arrives after the request expired.
+	onResponse func(content []byte)
+}
+
+// messageID returns the raw "id" of a JSON-RPC message, or nil for notifications.
+func messageID(content []byte) json.RawMessage {
+	var msg struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(content, &msg); err != nil || string(msg.ID) == "null" {
+		return nil
+	}
+	return msg.ID
+}
+
+// withID returns content re-encoded as an LSP frame with its "id" replaced
+// and, if traceparent is set, carrying it as "traceparent".
+func withID(content []byte, id json.RawMessage, traceparent string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+	fields["id"] = id
+	if traceparent != "" {
+		fields["traceparent"], _ = json.Marshal(traceparent)
+	}
+	return []byte(rpc.EncodeMessage(fields)), nil
+}
+
+// relayRequest moves a peer request into the daemon's ID space and remembers
+// where the response must go. Returns the rewritten frame and its daemon ID.
+func (d *Daemon) relayRequest(from, method string, content []byte, onResponse func([]byte)) ([]byte, int) {
+	origID := messageID(content)
+	to := d.routeFor(method, from)
+
+	d.mu.Lock()
+	d.requestID++
+	relayID := d.requestID
+	parent := d.spans[spanKey{d.clients[from], string(origID)}]
+	span := d.startHop(parent.Context(), method, to)
+	d.relayed[relayID] = relayedRequest{from: from, to: to, id: origID, method: method, sent: d.clock.Now(), span: span, onResponse: onResponse}
+	d.mu.Unlock()
+
+	msg, err := withID(content, json.RawMessage(strconv.Itoa(relayID)), span.Context().Traceparent())
+	if err != nil {
+		d.mu.Lock()
+		delete(d.relayed, relayID)
+		d.mu.Unlock()
+		span.SetError(err.Error())
+		span.End()
+		return nil, 0
+	}
+
+	d.logger.Printf("Relaying %s from %s as #%d%s", method, from, relayID, traceSuffix(span.Context()))
+	return msg, relayID
+}
+
+// expireRelayed marks a relayed request as answered by the daemon, so a
+// late response from the peer is dropped. Returns the original request and
+// false if the peer already responded.
+func (d *Daemon) expireRelayed(relayID int) (relayedRequest, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	req, ok := d.relayed[relayID]
+	if !ok || req.expired {
+		return relayedRequest{}, false
+	}
+	req.expired = true
+	d.relayed[relayID] = req
+	req.span.SetError("no response before the deadline")
+	req.span.End()
+	return req, true
+}
+
+// relayWithDeadline relays a request from one peer to the other and answers
+// the sender through fallback if the peer is not connected or does not
+// respond within timeout. A response arriving after the deadline is dropped,
+// though onResponse (if non-nil) still sees it.
+func (d *Daemon) relayWithDeadline(from string, conn net.Conn, method string, content []byte,
+	timeout time.Duration, fallback func(id json.RawMessage, reason string), onResponse func([]byte),
+) {
+	id := messageID(content)
+	if id == nil {
+		return
+	}
+
+	peerName := d.routeFor(method, from)
+
+	d.mu.RLock()
+	peer := d.clients[peerName]
+	d.mu.RUnlock()
+
+	if peer == nil {
+		fallback(id, peerName+" is not connected")
+		return
+	}
+
+	msg, relayID := d.relayRequest(from, method, content, onResponse)
+	if msg == nil {
+		fallback(id, "failed to relay request")
+		return
+	}
+
+	timeout = d.requests.Deadline(method, timeout)
+	d.clock.AfterFunc(timeout, func() {
+		if req, ok := d.expireRelayed(relayID); ok {
+			d.metrics.Unanswered(method)
+			fallback(req.id, fmt.Sprintf("%s did not answer within %s", peerName, timeout))
+		}
+	})
+
+	if _, err := peer.Write(msg); err != nil {
+		if req, ok := d.expireRelayed(relayID); ok {
+			fallback(req.id, "failed to forward to "+peerName)
+		}
+		d.reportError(lsp.ErrorParams{
+			Code:    lsp.ErrorCodeForward,
+			Message: fmt.Sprintf("failed to forward to %s: %v", peerName, err),
+			Method:  method,
+		})
+	}
+}
+
+// watchRelayed reports the relayed request relayID if its peer has not
+// answered within its deadline (requests.deadlines, else the request
+// timeout), for requests with no deadline of their own. With
+// synthesizeErrors, the sender gets an error in place of the missing
+// response instead of waiting forever.
+func (d *Daemon) watchRelayed(relayID int) {
+	d.mu.RLock()
+	method := d.relayed[relayID].method
+	d.mu.RUnlock()
+
+	timeout := d.requests.Deadline(method, d.requests.Timeout())
+	d.clock.AfterFunc(timeout, func() {
+		d.mu.RLock()
+		req, ok := d.relayed[relayID]
+		sender := d.clients[req.from]
+		d.mu.RUnlock()
+
+		if !ok || req.expired {
+			return
+		}
+		d.metrics.Unanswered(req.method)
+		d.logger.Printf("Warning: %s has not answered %s #%d from %s within %s%s",
+			req.to, req.method, relayID, req.from, timeout, traceSuffix(req.span.Context()))
+
+		if !d.requests.SynthesizeErrors || sender == nil {
+			return
+		}
+		if req, ok := d.expireRelayed(relayID); ok {
+			d.respondError(sender, req.id, lsp.RequestFailed,
+				fmt.Sprintf("%s did not answer %s within %s", req.to, req.method, timeout))
+		}
+	})
+}
+
+// relayResponse restores the original ID on a response to a relayed request.
+// Returns the rewritten frame and its recipient, or ok=false if the response
+// does not belong to a relayed request. Late responses to expired requests
+// are consumed: ok is true and msg is nil.
+func (d *Daemon) relayResponse(content []byte) (msg []byte, to string, ok bool) {
+	relayID, err := strconv.Atoi(string(messageID(content)))
+	if err != nil {
+		return nil, "", false
+	}
+
+	d.mu.Lock()
+	req, found := d.relayed[relayID]
+	delete(d.relayed, relayID)
+	sender := d.clients[req.from]
+	d.mu.Unlock()
+
+	if !found {
+		return nil, "", false
+	}
+	d.metrics.Response(req.method, len(content), d.clock.Since(req.sent))
+	endHop(req.span, content)
+	if req.onResponse != nil {
+		req.onResponse(content)
+	}
+	if req.expired {
+		d.logger.Printf("Dropping late response to %s #%d%s", req.method, relayID, traceSuffix(req.span.Context()))
+		return nil, "", true
+	}
+	if req.from == "" {
+		return nil, "", true // Answer to the daemon's own request
+	}
+
+	msg, err = withID(content, req.id, "")
+	if err != nil {
+		return nil, "", false
+	}
+	d.endSpan(sender, req.id, responseError(content))
+	return msg, req.from, true
+}
+
+// call sends a daemon-originated request to client and waits up to timeout
+// (or the deadline requests.deadlines sets for method) for the result. If
+// ctx is done first, the request is cancelled.
+func (d *Daemon) call(ctx context.Context, client, method string, params any, timeout time.Duration) (json.RawMessage, error) {
+	timeout = d.requests.Deadline(method, timeout)
+	done := make(chan []byte, 1)
+
+	d.mu.Lock()
+	conn := d.clients[client]
+	if conn == nil {
+		d.mu.Unlock()
+		return nil, fmt.Errorf("%s is not connected", client)
+	}
+	d.requestID++
+	requestID := d.requestID
+	span := d.startHop(tracing.SpanFromContext(ctx).Context(), method, client)
+	d.relayed[requestID] = relayedRequest{
+		to:         client,
+		method:     method,
+		sent:       d.clock.Now(),
+		span:       span,
+		onResponse: func(content []byte) { done <- content },
+	}
+	d.mu.Unlock()
+
+	request := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      requestID,
+		"method":  method,
+		"params":  params,
+	}
+	if traceparent := span.Context().Traceparent(); traceparent != "" {
+		request["traceparent"] = traceparent
+	}
+	if _, err := conn.Write([]byte(rpc.EncodeMessage(request))); err != nil {
+		d.expireRelayed(requestID)
+		return nil, err
+	}
+
+	select {
+	case content := <-done:
+		var resp struct {
+			Result json.RawMessage    `json:"result"`
+			Error  *lsp.ResponseError `json:"error"`
+		}
+		if err := json.Unmarshal(content, &resp); err != nil {
+			return nil, err
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s: %s", client, resp.Error.Message)
+		}
+		return resp.Result, nil
+	case <-ctx.Done():
+		d.expireRelayed(requestID)
+		conn.Write([]byte(rpc.EncodeMessage(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "$/cancelRequest",
+			"params":  map[string]any{"id": requestID},
+		})))
+		return nil, fmt.Errorf("%s: %w", method, ctx.Err())
+	case <-d.clock.After(timeout):
+		d.expireRelayed(requestID)
+		d.metrics.Unanswered(method)
+		d.logger.Printf("%s did not answer %s #%d%s", client, method, requestID, traceSuffix(span.Context()))
+		return nil, fmt.Errorf("%s did not answer %s within %s", client, method, timeout)
+	}
+}
+
+// dropRelayed forgets requests sent by a client that has disconnected.
+func (d *Daemon) dropRelayed(client string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id, req := range d.relayed {
+		if req.from == client || req.to == client {
+			delete(d.relayed, id)
+			req.span.SetError(client + " disconnected")
+			req.span.End()
+		}
+	}
+}
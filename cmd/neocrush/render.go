@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/taigrr/neocrush/internal/render"
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleRenderSnippet answers crush/renderSnippet with the lines drawn as
+// a PNG or SVG image.
+func (d *Daemon) handleRenderSnippet(content []byte, conn net.Conn) {
+	id := messageID(content)
+
+	var req struct {
+		Params lsp.RenderSnippetParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, id, lsp.InvalidParams, "invalid renderSnippet params: "+err.Error())
+		return
+	}
+	params := req.Params
+	if params.Format != "" && params.Format != lsp.RenderPNG && params.Format != lsp.RenderSVG {
+		d.respondError(conn, id, lsp.InvalidParams, fmt.Sprintf("unknown format %q", params.Format))
+		return
+	}
+	start := max(params.StartLine, 1)
+	if params.EndLine <= 0 || params.EndLine-start >= lsp.MaxRenderLines {
+		params.EndLine = start + lsp.MaxRenderLines - 1
+	}
+
+	file, code, err := d.readFile(d.requestContext(conn, content), lsp.ReadFileParams{
+		Path:      params.Path,
+		StartLine: params.StartLine,
+		EndLine:   params.EndLine,
+	})
+	if err != nil {
+		d.respondError(conn, id, code, err.Error())
+		return
+	}
+	if file.Binary {
+		d.respondError(conn, id, lsp.InvalidParams, fmt.Sprintf("%s is a binary file", params.Path))
+		return
+	}
+	if file.TooLarge {
+		d.respondError(conn, id, lsp.RequestFailed, fmt.Sprintf("%s is %d bytes, too large to read", params.Path, file.Size))
+		return
+	}
+
+	title := file.Path
+	if rel, err := filepath.Rel(d.workspace, file.Path); err == nil && filepath.IsLocal(rel) {
+		title = filepath.ToSlash(rel)
+	}
+	snippet := render.Snippet{
+		Text:       file.Content,
+		LanguageID: workspace.Language(file.Path),
+		FirstLine:  max(file.StartLine-1, 0),
+		Highlight:  params.Highlight - 1,
+		Title:      title,
+	}
+	result := lsp.RenderSnippetResult{StartLine: file.StartLine, EndLine: file.EndLine}
+	if params.Format == lsp.RenderSVG {
+		result.MIMEType, result.Data = render.SVGMIMEType, render.SVG(snippet)
+	} else {
+		result.MIMEType = render.PNGMIMEType
+		if result.Data, err = render.PNG(snippet); err != nil {
+			d.respondError(conn, id, lsp.RequestFailed, err.Error())
+			return
+		}
+	}
+	d.respondResult(conn, id, result)
+}
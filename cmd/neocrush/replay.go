@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/capture"
+	"github.com/taigrr/neocrush/internal/clock"
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/history"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// defaultReplayTimeout is how long a replay waits, in real time, for the
+// daemon to handle a message or to send the one the capture expects next.
+const defaultReplayTimeout = 2 * time.Second
+
+// replayMismatch is a message the replayed daemon sent differently from
+// the captured one.
+type replayMismatch struct {
+	Record int             `json:"record"` // Line of the capture, from 1
+	Conn   int             `json:"conn"`
+	Client string          `json:"client,omitempty"`
+	Want   json.RawMessage `json:"want,omitempty"` // Empty if the replay sent an extra message
+	Got    json.RawMessage `json:"got,omitempty"`  // Empty if the replay didn't send Want
+}
+
+// replayResult is the outcome of a replay.
+type replayResult struct {
+	Received   int               `json:"received"` // Client messages fed to the daemon
+	Compared   int               `json:"compared"` // Daemon messages checked against the capture
+	Mismatches []replayMismatch  `json:"mismatches"`
+	Documents  map[string]string `json:"documents"` // URI -> content hash, after the replay
+}
+
+// replayer feeds the messages clients sent in a capture to a daemon on a
+// virtual clock, one at a time, and checks what the daemon sends back
+// against what the captured daemon sent.
+type replayer struct {
+	daemon  *Daemon
+	clock   *clock.Virtual
+	timeout time.Duration
+	conns   map[int]*replayConn      // Captured connection number -> its replay
+	accepts map[net.Conn]*replayConn // Daemon's end -> its replay
+	handled chan net.Conn            // Daemon's ends, once per message handled
+}
+
+// replayConn is one captured client connection, replayed over a pipe.
+type replayConn struct {
+	client   string   // Name the capture knows it by
+	conn     net.Conn // The client's end
+	writes   chan []byte
+	sent     chan json.RawMessage // What the daemon sent, redacted like the capture
+	written  int                  // Messages written
+	finished int                  // Messages the daemon has handled
+}
+
+// newReplayer returns a replayer driving a fresh daemon for workspace,
+// configured by cfg, with its clock starting at start.
+func newReplayer(logger *log.Logger, workspace string, cfg *config.Config, start time.Time, timeout time.Duration) *replayer {
+	r := &replayer{
+		clock:   clock.NewVirtual(start),
+		timeout: timeout,
+		conns:   make(map[int]*replayConn),
+		accepts: make(map[net.Conn]*replayConn),
+		handled: make(chan net.Conn, 1024),
+	}
+	// Timers that wait for a reply must not hold up the messages carrying it
+	r.clock.Settle = timeout / 10
+
+	r.daemon = newDaemon(logger, replayListener{})
+	r.daemon.workspace = workspace
+	r.daemon.configure(cfg)
+	r.daemon.clock = r.clock
+	r.daemon.onHandled = func(conn net.Conn) {
+		select {
+		case r.handled <- conn:
+		default: // Nobody waits that long
+		}
+	}
+	return r
+}
+
+// replayListener stands in for the daemon's socket: replayed connections
+// are handed to it directly.
+type replayListener struct{}
+
+func (replayListener) Accept() (net.Conn, error) { return nil, net.ErrClosed }
+func (replayListener) Close() error              { return nil }
+func (replayListener) Addr() net.Addr            { return &net.UnixAddr{Name: "replay", Net: "unix"} }
+
+// handled tells a replay the daemon is done with a message from conn.
+func (d *Daemon) handled(conn net.Conn) {
+	if d.onHandled != nil {
+		d.onHandled(conn)
+	}
+}
+
+// run replays records in order. The clock moves to each record's time
+// before it is replayed, firing the timers due by then.
+func (r *replayer) run(records []capture.Record) (replayResult, error) {
+	result := replayResult{Mismatches: []replayMismatch{}}
+	defer r.close()
+
+	for i, rec := range records {
+		if rec.Conn == 0 {
+			return result, fmt.Errorf("record %d has no connection number; captures older than neocrush replay can't be replayed", i+1)
+		}
+		r.clock.AdvanceTo(rec.Time)
+
+		switch rec.Dir {
+		case capture.Recv:
+			c := r.conn(rec.Conn, rec.Client)
+			c.writes <- []byte(rpc.EncodeMessage(rec.Message))
+			c.written++
+			r.waitHandled(c)
+			result.Received++
+		case capture.Send:
+			result.Compared++
+			mismatch := replayMismatch{Record: i + 1, Conn: rec.Conn, Client: rec.Client, Want: rec.Message}
+			c := r.conns[rec.Conn]
+			if c == nil {
+				result.Mismatches = append(result.Mismatches, mismatch)
+				continue
+			}
+			select {
+			case got := <-c.sent:
+				if !sameJSON(got, rec.Message) {
+					mismatch.Got = got
+					result.Mismatches = append(result.Mismatches, mismatch)
+				}
+			case <-time.After(r.timeout):
+				result.Mismatches = append(result.Mismatches, mismatch)
+			}
+		}
+	}
+
+	// Whatever else the daemon sends wasn't in the capture
+	time.Sleep(r.timeout / 10)
+	for _, number := range slices.Sorted(maps.Keys(r.conns)) {
+		c := r.conns[number]
+	drain:
+		for {
+			select {
+			case got, ok := <-c.sent:
+				if !ok {
+					break drain
+				}
+				result.Mismatches = append(result.Mismatches, replayMismatch{Record: len(records), Conn: number, Client: c.client, Got: got})
+			default:
+				break drain
+			}
+		}
+	}
+
+	r.daemon.mu.RLock()
+	result.Documents = make(map[string]string, len(r.daemon.documentState))
+	for uri, text := range r.daemon.documentState {
+		result.Documents[uri] = contentHash(text)
+	}
+	r.daemon.mu.RUnlock()
+	return result, nil
+}
+
+// conn returns the replay of captured connection number, connecting it on
+// first use.
+func (r *replayer) conn(number int, client string) *replayConn {
+	if c := r.conns[number]; c != nil {
+		if c.client == "" {
+			c.client = client
+		}
+		return c
+	}
+
+	clientEnd, daemonEnd := net.Pipe()
+	c := &replayConn{
+		client: client,
+		conn:   clientEnd,
+		writes: make(chan []byte, 64),
+		sent:   make(chan json.RawMessage, 1024),
+	}
+	r.conns[number] = c
+	r.accepts[daemonEnd] = c
+	go r.daemon.handleClient(daemonEnd)
+
+	// Writes don't wait for the daemon, which may be busy with an earlier
+	// message until a later one answers it
+	go func() {
+		for frame := range c.writes {
+			if _, err := clientEnd.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(c.sent)
+		scanner := bufio.NewScanner(clientEnd)
+		scanner.Split(rpc.Split)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			_, content, err := rpc.DecodeMessage(scanner.Bytes())
+			if err != nil {
+				continue
+			}
+			c.sent <- history.RedactSecrets(content)
+		}
+	}()
+	return c
+}
+
+// waitHandled waits until the daemon has handled every message written to
+// c, or the timeout passes (the daemon may be waiting for a later message).
+func (r *replayer) waitHandled(c *replayConn) {
+	deadline := time.After(r.timeout)
+	for c.finished < c.written {
+		select {
+		case conn := <-r.handled:
+			if done := r.accepts[conn]; done != nil {
+				done.finished++
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// close hangs up every replayed connection.
+func (r *replayer) close() {
+	for _, c := range r.conns {
+		close(c.writes)
+		c.conn.Close()
+	}
+}
+
+// sameJSON reports whether a and b encode the same value.
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return string(a) == string(b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// replayCapture reads the capture at path and replays it against a daemon
+// for workspace.
+func replayCapture(path, workspace string, timeout time.Duration) (replayResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return replayResult{}, err
+	}
+	defer f.Close()
+
+	var records []capture.Record
+	if err := capture.Read(f, func(rec capture.Record) error {
+		records = append(records, rec)
+		return nil
+	}); err != nil {
+		return replayResult{}, err
+	}
+	if len(records) == 0 {
+		return replayResult{}, fmt.Errorf("%s has no records", path)
+	}
+
+	cfg, err := config.Load(workspace)
+	if err != nil {
+		cfg = config.Default()
+	}
+	r := newReplayer(log.New(io.Discard, "", 0), workspace, cfg, records[0].Time, timeout)
+	return r.run(records)
+}
+
+func newReplayCmd() *cobra.Command {
+	var (
+		workspace  string
+		timeout    time.Duration
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "replay <capture.jsonl>",
+		Short: "Replay a capture against a fresh daemon and compare its output",
+		Long: `Feeds the messages clients sent in a capture (see "debug": {"capture": true})
+to a new daemon, one at a time and in their captured order, and checks every
+message the daemon sends against what the captured daemon sent. The daemon
+runs on a virtual clock that moves to each message's captured time, so its
+timers (edit summaries, post-edit verification, request timeouts) fire
+between the same messages as they did live. Periodic sweeps don't run.
+
+Differences are printed with the capture line they diverge at, and the
+command fails if there are any, so a capture of an ordering bug becomes a
+regression test. The daemon reads files and config from --workspace, which
+should match the captured workspace.`,
+		Example: `  neocrush replay .crush/captures/abc123.jsonl
+  neocrush replay bug.jsonl --workspace ~/src/project --json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if workspace == "" {
+				cwd, err := os.Getwd()
+				if err != nil {
+					return err
+				}
+				workspace = cwd
+			}
+
+			result, err := replayCapture(args[0], workspace, timeout)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if jsonOutput {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(result); err != nil {
+					return err
+				}
+			} else {
+				writeReplay(out, result)
+			}
+			if len(result.Mismatches) > 0 {
+				return errors.New("the replay diverged from the capture")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&workspace, "workspace", "", "Workspace the daemon serves (default: current directory)")
+	cmd.Flags().DurationVar(&timeout, "timeout", defaultReplayTimeout, "How long to wait for each message the capture expects")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the result as JSON")
+	return cmd
+}
+
+// writeReplay prints the differences and the final documents of a replay.
+func writeReplay(out io.Writer, result replayResult) {
+	fmt.Fprintf(out, "Replayed %d client messages, compared %d daemon messages: %d differences\n",
+		result.Received, result.Compared, len(result.Mismatches))
+	for _, m := range result.Mismatches {
+		fmt.Fprintf(out, "\nLine %d, connection %d (%s):\n", m.Record, m.Conn, cmp.Or(m.Client, "unidentified"))
+		fmt.Fprintf(out, "  want: %s\n", cmp.Or(string(m.Want), "nothing"))
+		fmt.Fprintf(out, "  got:  %s\n", cmp.Or(string(m.Got), "nothing"))
+	}
+
+	if len(result.Documents) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "\nDocuments:")
+	for _, uri := range slices.Sorted(maps.Keys(result.Documents)) {
+		fmt.Fprintf(out, "  %s  %s\n", result.Documents[uri][:12], strings.TrimPrefix(uri, "file://"))
+	}
+}
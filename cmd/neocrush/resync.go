@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// handleResyncDocument answers crush/resyncDocument. It runs off the read
+// loop because resyncing asks the requesting client for its own copy.
+func (d *Daemon) handleResyncDocument(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.ResyncDocumentParams `json:"params"`
+	}
+	id := messageID(content)
+	if err := json.Unmarshal(content, &req); err != nil || req.Params.TextDocument.URI == "" {
+		d.respondError(conn, id, lsp.InvalidParams, "resyncDocument requires textDocument.uri")
+		return
+	}
+	if s := req.Params.Source; s != "" && s != "neovim" && s != "crush" {
+		d.respondError(conn, id, lsp.InvalidParams, "unknown resync source "+s)
+		return
+	}
+
+	result, err := d.resyncDocument(d.requestContext(conn, content), req.Params.TextDocument.URI, req.Params.Source)
+	if err != nil {
+		d.respondError(conn, id, lsp.RequestFailed, err.Error())
+		return
+	}
+	if id != nil {
+		d.respondResult(conn, id, result)
+	}
+}
+
+// resyncDocument re-establishes the baseline for uri: it pulls Neovim's
+// buffer (or the file on disk when Neovim doesn't have it open) and Crush's
+// copy, picks the winner (Neovim unless source is "crush"), and pushes it to
+// the other side. Neovim gets an applyEdit; Crush gets crush/documentChanged.
+func (d *Daemon) resyncDocument(ctx context.Context, uri, source string) (lsp.ResyncDocumentResult, error) {
+	d.mu.RLock()
+	neovimHasFile := d.neovimOpenDocs[uri]
+	d.mu.RUnlock()
+
+	var neovimText *string
+	if neovimHasFile {
+		neovimText = d.documentContent(ctx, "neovim", uri)
+	}
+	crushText := d.documentContent(ctx, cmp.Or(d.agentFor(), "crush"), uri)
+
+	result := lsp.ResyncDocumentResult{Source: "neovim"}
+	baseline := neovimText
+	if source == "crush" && crushText != nil {
+		result.Source = "crush"
+		baseline = crushText
+	}
+	if baseline == nil {
+		path, err := uriToPath(uri)
+		if err != nil {
+			return result, err
+		}
+		text, _, err := readText(path)
+		if err != nil {
+			return result, fmt.Errorf("no copy of %s to resync from: %w", extractFilename(uri), err)
+		}
+		result.Source = "disk"
+		baseline = &text
+	}
+
+	d.mu.Lock()
+	previous, hadBaseline := d.documentState[uri]
+	d.documentState[uri] = *baseline
+	d.mu.Unlock()
+	d.state.Touch()
+	if hadBaseline && previous != *baseline {
+		d.recordChange("resync", uri, previous, *baseline)
+	}
+	result.Hash = contentHash(*baseline)
+
+	if neovimText != nil && *neovimText != *baseline {
+		if err := d.replaceInNeovim(uri, *neovimText, *baseline); err == nil {
+			result.Updated = append(result.Updated, "neovim")
+		}
+	}
+	if crushText == nil || *crushText != *baseline {
+		if err := d.sendDocumentChanged(uri, *baseline, result.Source); err == nil {
+			result.Updated = append(result.Updated, "crush")
+		}
+	}
+
+	d.documentReplaced(uri)
+
+	d.logger.Printf("Resynced %s from %s (updated %v)", uri, result.Source, result.Updated)
+	return result, nil
+}
+
+// documentContent asks client for its text of uri via crush/documentContent.
+// Returns nil if the client doesn't have it or can't answer.
+func (d *Daemon) documentContent(ctx context.Context, client, uri string) *string {
+	params := lsp.DocumentContentParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}}
+	raw, err := d.call(ctx, client, "crush/documentContent", params, verifyTimeout)
+	if err != nil {
+		d.logger.Printf("documentContent %s: %v", uri, err)
+		return nil
+	}
+	var result lsp.DocumentContentResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil
+	}
+	return result.Text
+}
+
+// replaceInNeovim edits Neovim's buffer from oldText to newText.
+func (d *Daemon) replaceInNeovim(uri, oldText, newText string) error {
+	if !d.state.Capabilities().ApplyEdit {
+		return errors.New("neovim does not support workspace/applyEdit")
+	}
+	d.requestNeovim("workspace/applyEdit", d.withFoldHint(map[string]any{
+		"label": "Crush resync",
+		"edit": map[string]any{
+			"changes": map[string]any{uri: computeLineEdits(oldText, newText)},
+		},
+	}, uri, oldText, newText))
+	return nil
+}
+
+// sendDocumentChanged pushes the full text of uri to every agent.
+func (d *Daemon) sendDocumentChanged(uri, text, source string) error {
+	agents := d.agentConns()
+	if len(agents) == 0 {
+		return errors.New("crush is not connected")
+	}
+
+	notification := lsp.DocumentChangedNotification{
+		Notification: lsp.Notification{
+			RPC:    "2.0",
+			Method: "crush/documentChanged",
+		},
+		Params: lsp.DocumentChangedParams{
+			TextDocument: lsp.VersionTextDocumentIdentifier{
+				TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri},
+			},
+			Content:      text,
+			ChangeSource: source,
+		},
+	}
+	msg := []byte(rpc.EncodeMessage(notification))
+	var errs []error
+	for _, crush := range agents {
+		if _, err := crush.Write(msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/diff"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// review is a document whose hunks from Crush await the user's decision.
+// Neovim's buffer keeps its text until a hunk is accepted.
+type review struct {
+	lines    []string     // Neovim's buffer, with the accepted hunks
+	hunks    []stagedHunk // Pending, in order, relative to lines
+	proposal string       // Crush's text of the document
+}
+
+// stagedHunk is a diff hunk awaiting review.
+type stagedHunk struct {
+	id int
+	diff.Hunk
+}
+
+// find returns the index of hunk id, or -1.
+func (r *review) find(id int) int {
+	return slices.IndexFunc(r.hunks, func(h stagedHunk) bool { return h.id == id })
+}
+
+// accept splices hunk i into lines and moves the hunks after it.
+func (r *review) accept(i int) {
+	h := r.hunks[i]
+	r.lines = slices.Replace(r.lines, h.OldStart, h.OldStart+len(h.Old), h.New...)
+	for j := i + 1; j < len(r.hunks); j++ {
+		r.hunks[j].OldStart += len(h.New) - len(h.Old)
+	}
+	r.hunks = slices.Delete(r.hunks, i, i+1)
+}
+
+// target returns lines with every pending hunk applied.
+func (r *review) target() []string {
+	hunks := make([]diff.Hunk, len(r.hunks))
+	for i, h := range r.hunks {
+		hunks[i] = h.Hunk
+	}
+	return diff.Apply(r.lines, hunks)
+}
+
+// stageReview stages the hunks turning Neovim's buffer of uri into Crush's
+// text and tells Neovim, replacing hunks pending from an earlier edit. base
+// is the buffer's text when none are pending.
+func (d *Daemon) stageReview(uri, base, proposal string) {
+	d.mu.Lock()
+	lines := strings.Split(base, "\n")
+	if r := d.reviews[uri]; r != nil {
+		lines = slices.Clone(r.lines)
+	}
+	r := d.stageLocked(uri, lines, strings.Split(proposal, "\n"), proposal)
+	if len(r.hunks) == 0 {
+		delete(d.reviews, uri) // Crush's edit matches the buffer
+	}
+	d.mu.Unlock()
+
+	d.logger.Printf("Staged %d hunks of %s for review", len(r.hunks), extractFilename(uri))
+	d.sendReviewHunks(uri)
+}
+
+// stageLocked replaces the review of uri with the hunks turning lines into
+// target. Called with d.mu held.
+func (d *Daemon) stageLocked(uri string, lines, target []string, proposal string) *review {
+	r := &review{lines: lines, proposal: proposal}
+	hunks := diff.Lines(lines, target)
+	if _, profile := d.language(uri); profile.DiffGranularity == config.DiffGranularityFile {
+		hunks = mergeHunks(lines, target, hunks)
+	}
+	for _, h := range hunks {
+		d.hunkSeq++
+		r.hunks = append(r.hunks, stagedHunk{id: d.hunkSeq, Hunk: h})
+	}
+	d.reviews[uri] = r
+	return r
+}
+
+// settleLocked removes the review of uri and returns it if no hunks are
+// left. Called with d.mu held.
+func (d *Daemon) settleLocked(uri string) *review {
+	r := d.reviews[uri]
+	if r == nil || len(r.hunks) > 0 {
+		return nil
+	}
+	delete(d.reviews, uri)
+	return r
+}
+
+// hunkEdit is the edit applying h to lines.
+func hunkEdit(lines []string, h diff.Hunk) lsp.TextEdit {
+	start, end := h.OldStart, h.OldStart+len(h.Old)
+	if end < len(lines) {
+		// Whole lines, each with its newline
+		text := ""
+		if len(h.New) > 0 {
+			text = strings.Join(h.New, "\n") + "\n"
+		}
+		return lsp.TextEdit{
+			Range:   lsp.Range{Start: lsp.Position{Line: start}, End: lsp.Position{Line: end}},
+			NewText: text,
+		}
+	}
+
+	// Up to the last line, which has no newline after it: start at the
+	// end of the line before instead
+	if start == 0 {
+		return lsp.TextEdit{
+			Range:   lsp.Range{End: lsp.Position{Line: end - 1, Character: lineUnits(lines[end-1])}},
+			NewText: strings.Join(h.New, "\n"),
+		}
+	}
+	text := ""
+	if len(h.New) > 0 {
+		text = "\n" + strings.Join(h.New, "\n")
+	}
+	return lsp.TextEdit{
+		Range: lsp.Range{
+			Start: lsp.Position{Line: start - 1, Character: lineUnits(lines[start-1])},
+			End:   lsp.Position{Line: end - 1, Character: lineUnits(lines[end-1])},
+		},
+		NewText: text,
+	}
+}
+
+// lineUnits returns the length of line in UTF-16 code units.
+func lineUnits(line string) int {
+	n := 0
+	for _, r := range line {
+		n += utf16Len(r)
+	}
+	return n
+}
+
+// reviewHunks returns the pending hunks of uri. Called with d.mu held.
+func (d *Daemon) reviewHunks(uri string) []lsp.ReviewHunk {
+	hunks := []lsp.ReviewHunk{}
+	r := d.reviews[uri]
+	if r == nil {
+		return hunks
+	}
+	for _, h := range r.hunks {
+		hunks = append(hunks, lsp.ReviewHunk{
+			ID: h.id,
+			Range: lsp.Range{
+				Start: lsp.Position{Line: h.OldStart},
+				End:   lsp.Position{Line: h.OldStart + len(h.Old)},
+			},
+			OldText: strings.Join(h.Old, "\n"),
+			NewText: strings.Join(h.New, "\n"),
+		})
+	}
+	return hunks
+}
+
+// sendReviewHunks sends Neovim the pending hunks of uri.
+func (d *Daemon) sendReviewHunks(uri string) {
+	d.mu.RLock()
+	neovim := d.clients["neovim"]
+	hunks := d.reviewHunks(uri)
+	d.mu.RUnlock()
+	if neovim == nil {
+		return
+	}
+
+	msg := lsp.ReviewHunksNotification{
+		Notification: lsp.Notification{
+			RPC:    "2.0",
+			Method: "crush/reviewHunks",
+		},
+		Params: lsp.ReviewHunksParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+			Hunks:        hunks,
+		},
+	}
+	if _, err := neovim.Write([]byte(rpc.EncodeMessage(msg))); err != nil {
+		d.logger.Printf("Failed to send review hunks for %s: %v", uri, err)
+	}
+}
+
+// handleListHunks answers crush/listHunks.
+func (d *Daemon) handleListHunks(content []byte, conn net.Conn) {
+	d.mu.RLock()
+	result := lsp.ListHunksResult{Documents: []lsp.ReviewHunksParams{}}
+	for _, uri := range slices.Sorted(maps.Keys(d.reviews)) {
+		result.Documents = append(result.Documents, lsp.ReviewHunksParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+			Hunks:        d.reviewHunks(uri),
+		})
+	}
+	d.mu.RUnlock()
+	d.respondResult(conn, messageID(content), result)
+}
+
+// handleResolveHunk answers crush/resolveHunk from Neovim. An accepted hunk
+// is applied to the buffer, after checking the buffer still holds the text
+// the hunks were staged against; if not, they are staged again.
+func (d *Daemon) handleResolveHunk(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.ResolveHunkParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid resolveHunk params: "+err.Error())
+		return
+	}
+	id := req.Params.ID
+
+	// One decision at a time
+	d.reviewMu.Lock()
+	defer d.reviewMu.Unlock()
+
+	d.mu.RLock()
+	var uri string
+	var r *review
+	for u, candidate := range d.reviews {
+		if candidate.find(id) >= 0 {
+			uri, r = u, candidate
+			break
+		}
+	}
+	var h stagedHunk
+	var base string
+	if r != nil {
+		h = r.hunks[r.find(id)]
+		base = strings.Join(r.lines, "\n")
+	}
+	d.mu.RUnlock()
+	if r == nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, fmt.Sprintf("no pending hunk %d", id))
+		return
+	}
+
+	var result lsp.ResolveHunkResult
+	if req.Params.Accept {
+		ctx := d.requestContext(conn, content)
+		if current := d.documentContent(ctx, "neovim", uri); current != nil && *current != base {
+			var settled *review
+			d.mu.Lock()
+			if d.reviews[uri] == r {
+				d.stageLocked(uri, strings.Split(*current, "\n"), r.target(), r.proposal)
+				settled = d.settleLocked(uri)
+			}
+			d.mu.Unlock()
+			d.sendReviewHunks(uri)
+			if settled != nil {
+				d.finishReview(uri, settled)
+			}
+			d.respondError(conn, messageID(content), lsp.RequestFailed, "the buffer changed since the hunks were staged; staged them again")
+			return
+		}
+
+		raw, err := d.call(ctx, "neovim", "workspace/applyEdit", map[string]any{
+			"label":     "Accept hunk",
+			"edit":      map[string]any{"changes": map[string]any{uri: []lsp.TextEdit{hunkEdit(r.lines, h.Hunk)}}},
+			"editGroup": d.beginEditGroup("Accept hunk: "+extractFilename(uri), []string{uri}),
+		}, d.commandTimeout)
+		if err != nil {
+			d.respondError(conn, messageID(content), failureCode(err, lsp.RequestFailed), err.Error())
+			return
+		}
+		var applied struct {
+			Applied       bool   `json:"applied"`
+			FailureReason string `json:"failureReason"`
+		}
+		if err := json.Unmarshal(raw, &applied); err != nil {
+			d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+			return
+		}
+		if !applied.Applied {
+			d.logger.Printf("Neovim rejected hunk %d of %s: %s", id, uri, applied.FailureReason)
+		}
+		result.Applied = applied.Applied
+	}
+
+	d.mu.Lock()
+	switch current := d.reviews[uri]; {
+	case current == r:
+		if i := r.find(id); i < 0 {
+			// Resolved meanwhile
+		} else if result.Applied {
+			r.accept(i)
+		} else if !req.Params.Accept {
+			r.hunks = slices.Delete(r.hunks, i, i+1)
+		}
+	case current != nil && result.Applied:
+		// Crush edited the document while Neovim applied the hunk: stage
+		// Crush's newer edit against the buffer with the hunk
+		lines := slices.Replace(slices.Clone(current.lines), h.OldStart, h.OldStart+len(h.Old), h.New...)
+		d.stageLocked(uri, lines, current.target(), current.proposal)
+	}
+	if current := d.reviews[uri]; current != nil {
+		result.Remaining = len(current.hunks)
+	}
+	settled := d.settleLocked(uri)
+	d.mu.Unlock()
+
+	d.respondResult(conn, messageID(content), result)
+	d.sendReviewHunks(uri)
+	if settled != nil {
+		d.finishReview(uri, settled)
+	}
+}
+
+// finishReview ends the review of uri once no hunks are left. If the user
+// rejected any, Crush is sent the buffer's text in place of its edit.
+func (d *Daemon) finishReview(uri string, r *review) {
+	d.logger.Printf("Review of %s done", extractFilename(uri))
+	final := strings.Join(r.lines, "\n")
+	if final == r.proposal {
+		return
+	}
+	d.mu.Lock()
+	d.documentState[uri] = final
+	d.mu.Unlock()
+	d.state.Touch()
+	d.lint(uri, "", final, 0)
+	if err := d.sendDocumentChanged(uri, final, "review"); err != nil {
+		d.logger.Printf("Failed to send reviewed %s to crush: %v", uri, err)
+	}
+	d.scheduleVerify(uri)
+}
+
+// dropReview forgets the hunks of uri, which Neovim closed.
+func (d *Daemon) dropReview(uri string) {
+	d.mu.Lock()
+	delete(d.reviews, uri)
+	d.mu.Unlock()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// initializeRoot returns the workspace root an LSP initialize request
+// names: its first workspace folder, else rootUri, else the deprecated
+// rootPath. Returns "" if none names an existing directory.
+func initializeRoot(content []byte) string {
+	var req struct {
+		Params struct {
+			WorkspaceFolders []struct {
+				URI string `json:"uri"`
+			} `json:"workspaceFolders"`
+			RootURI  string `json:"rootUri"`
+			RootPath string `json:"rootPath"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		return ""
+	}
+
+	var candidates []string
+	for _, folder := range req.Params.WorkspaceFolders {
+		if path, err := uriToPath(folder.URI); err == nil {
+			candidates = append(candidates, path)
+		}
+	}
+	if path, err := uriToPath(req.Params.RootURI); err == nil {
+		candidates = append(candidates, path)
+	}
+	candidates = append(candidates, req.Params.RootPath)
+
+	for _, path := range candidates {
+		if path == "" || !filepath.IsAbs(path) {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return filepath.Clean(path)
+		}
+	}
+	return ""
+}
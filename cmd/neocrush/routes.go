@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// builtinMethods are crush/* methods the daemon handles itself; clients
+// cannot register them.
+var builtinMethods = map[string]bool{
+	"crush/cursorMoved":       true,
+	"crush/selectionChanged":  true,
+	"crush/getEditorContext":  true,
+	"crush/showLocations":     true,
+	"crush/getState":          true,
+	"crush/getMetrics":        true,
+	"crush/daemonStatus":      true,
+	"crush/stopDaemon":        true,
+	"crush/sessionInfo":       true,
+	"crush/inspect":           true,
+	"crush/sessionEnding":     true,
+	"crush/inspectMessage":    true,
+	"crush/inlayHints":        true,
+	"crush/codeLens":          true,
+	"crush/executeLens":       true,
+	"crush/invokeAction":      true,
+	"crush/fixDiagnostic":     true,
+	"crush/fixVerified":       true,
+	"crush/reviewHunks":       true,
+	"crush/resolveHunk":       true,
+	"crush/listHunks":         true,
+	"crush/previewFile":       true,
+	"crush/resolvePreview":    true,
+	"crush/exportPatch":       true,
+	"crush/importPatch":       true,
+	"crush/stageFiles":        true,
+	"crush/commit":            true,
+	"crush/openScratch":       true,
+	"crush/appendTranscript":  true,
+	"crush/getTranscript":     true,
+	"crush/renderSnippet":     true,
+	"crush/getEnvironment":    true,
+	"crush/workspaceStats":    true,
+	"crush/error":             true,
+	"crush/registerMethod":    true,
+	"crush/unregisterMethod":  true,
+	"crush/verifySync":        true,
+	"crush/resyncDocument":    true,
+	"crush/documentContent":   true,
+	"crush/documentChanged":   true,
+	"crush/documentOpened":    true,
+	"crush/documentClosed":    true,
+	"crush/listFiles":         true,
+	"crush/readFile":          true,
+	"crush/writeFile":         true,
+	"crush/generateFile":      true,
+	"crush/recentChanges":     true,
+	"crush/relatedFiles":      true,
+	"crush/definition":        true,
+	"crush/history":           true,
+	"crush/undoLastAgentEdit": true,
+	"crush/undoEditGroup":     true,
+	"crush/createCheckpoint":  true,
+	"crush/listCheckpoints":   true,
+	"crush/restoreCheckpoint": true,
+	"crush/editSelections":    true,
+	"crush/mcpInitialize":     true,
+	"crush/stateVersion":      true,
+	"crush/setLogLevel":       true,
+	"crush/logMessage":        true,
+	"crush/runJob":            true,
+	"crush/cancelJob":         true,
+	"crush/getRecentJobs":     true,
+	"crush/jobStarted":        true,
+	"crush/jobOutput":         true,
+	"crush/jobFinished":       true,
+	"crush/listTodos":         true,
+
+	// Presence events, sent to clients
+	"crush/clientConnected":    true,
+	"crush/clientDisconnected": true,
+}
+
+// routeFor returns the client a message from `from` is routed to: the
+// client that registered method, or otherwise from's peer.
+func (d *Daemon) routeFor(method, from string) string {
+	d.mu.RLock()
+	owner, ok := d.routes[method]
+	d.mu.RUnlock()
+
+	if ok && owner != from {
+		return owner
+	}
+	return d.peerOf(from)
+}
+
+// handleRegisterMethod adds routing entries for crush/* methods a client
+// handles. Built-in methods and methods owned by another client are rejected.
+func (d *Daemon) handleRegisterMethod(client string, content []byte, conn net.Conn) {
+	var req lsp.RegisterMethodRequest
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid registerMethod params: "+err.Error())
+		return
+	}
+
+	result := lsp.RegisterMethodResult{Registered: []string{}}
+
+	d.mu.Lock()
+	for _, method := range req.Params.Methods {
+		owner, taken := d.routes[method]
+		if !strings.HasPrefix(method, "crush/") || builtinMethods[method] || taken && owner != client {
+			result.Rejected = append(result.Rejected, method)
+			continue
+		}
+		d.routes[method] = client
+		result.Registered = append(result.Registered, method)
+	}
+	d.mu.Unlock()
+
+	d.logger.Printf("%s registered methods %v (rejected %v)", client, result.Registered, result.Rejected)
+	d.respondResult(conn, messageID(content), result)
+}
+
+// handleUnregisterMethod removes routing entries a client registered.
+func (d *Daemon) handleUnregisterMethod(client string, content []byte, conn net.Conn) {
+	var req lsp.RegisterMethodRequest
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid unregisterMethod params: "+err.Error())
+		return
+	}
+
+	d.mu.Lock()
+	for _, method := range req.Params.Methods {
+		if d.routes[method] == client {
+			delete(d.routes, method)
+		}
+	}
+	d.mu.Unlock()
+
+	if id := messageID(content); id != nil {
+		d.respondResult(conn, id, nil)
+	}
+}
+
+// dropRoutes removes every method registered by a disconnected client.
+func (d *Daemon) dropRoutes(client string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var dropped []string
+	for method, owner := range d.routes {
+		if owner == client {
+			delete(d.routes, method)
+			dropped = append(dropped, method)
+		}
+	}
+	if len(dropped) > 0 {
+		sort.Strings(dropped)
+		d.logger.Printf("Dropped methods registered by %s: %v", client, dropped)
+	}
+}
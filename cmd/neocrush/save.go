@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// defaultWillSaveTimeout bounds how long a save in Neovim waits for Crush's
+// pre-save edits. Neovim blocks the write while waiting, so keep it short.
+const defaultWillSaveTimeout = 1500 * time.Millisecond
+
+// handleWillSaveWaitUntil relays Neovim's textDocument/willSaveWaitUntil to
+// Crush so the agent can return pre-save edits (formatting, import fixes).
+// If Crush is absent or too slow, Neovim gets an empty edit list and saves
+// unchanged; a late answer from Crush is dropped.
+func (d *Daemon) handleWillSaveWaitUntil(content []byte, conn net.Conn) {
+	d.relayWithDeadline("neovim", conn, "textDocument/willSaveWaitUntil", content, d.willSaveTimeout,
+		func(id json.RawMessage, reason string) {
+			d.logger.Printf("willSaveWaitUntil: %s, saving without edits", reason)
+			d.respondNoEdits(conn, id)
+		}, nil)
+}
+
+// respondNoEdits answers a willSaveWaitUntil request with an empty edit list.
+func (d *Daemon) respondNoEdits(conn net.Conn, id json.RawMessage) {
+	d.respondResult(conn, id, []lsp.TextEdit{})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// isScratch reports whether uri is a scratch document, which has no file.
+func isScratch(uri string) bool {
+	return strings.HasPrefix(uri, lsp.ScratchScheme)
+}
+
+// handleOpenScratch answers crush/openScratch: the text is shown in a
+// Neovim scratch buffer, new unless params name an open one.
+func (d *Daemon) handleOpenScratch(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.OpenScratchParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid openScratch params: "+err.Error())
+		return
+	}
+	params := req.Params
+	params.LanguageID = cmp.Or(params.LanguageID, workspace.Language(params.Title))
+
+	d.mu.Lock()
+	neovim := d.clients["neovim"]
+	if neovim == nil {
+		d.mu.Unlock()
+		d.respondError(conn, messageID(content), lsp.RequestFailed, "neovim is not connected")
+		return
+	}
+	switch {
+	case params.URI == "":
+		d.scratchSeq++
+		name := strings.NewReplacer("/", "-", " ", "-").Replace(cmp.Or(params.Title, "scratch"))
+		params.URI = fmt.Sprintf("%s%d/%s", lsp.ScratchScheme, d.scratchSeq, name)
+		d.scratches[params.URI] = true
+	case !d.scratches[params.URI]:
+		d.mu.Unlock()
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "no open scratch buffer "+params.URI)
+		return
+	}
+	text := params.Text
+	if params.Append {
+		text = d.documentState[params.URI] + text
+	}
+	d.documentState[params.URI] = text
+	d.neovimOpenDocs[params.URI] = true
+	d.mu.Unlock()
+	d.state.Touch()
+
+	if err := sendScratch(neovim, params); err != nil {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, "failed to send scratch buffer to neovim: "+err.Error())
+		return
+	}
+	d.respondResult(conn, messageID(content), lsp.OpenScratchResult{URI: params.URI})
+}
+
+// sendScratch sends Neovim a crush/openScratch notification.
+func sendScratch(neovim net.Conn, params lsp.OpenScratchParams) error {
+	msg := rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "crush/openScratch",
+		"params":  params,
+	})
+	_, err := neovim.Write([]byte(msg))
+	return err
+}
+
+// dropScratch forgets a scratch document Neovim closed.
+func (d *Daemon) dropScratch(uri string) {
+	if !isScratch(uri) {
+		return
+	}
+	d.mu.Lock()
+	delete(d.scratches, uri)
+	delete(d.documentState, uri)
+	if uri == d.transcriptURI() {
+		d.transcriptShown = false
+	}
+	d.mu.Unlock()
+}
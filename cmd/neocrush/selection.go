@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// byteOffset converts an LSP character offset (UTF-16 code units) in line
+// to a byte offset, clamped to the line.
+func byteOffset(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += utf16Len(r)
+	}
+	return len(line)
+}
+
+// utf16Len returns the UTF-16 code units needed for r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 && r <= utf8.MaxRune {
+		return 2
+	}
+	return 1
+}
+
+// rangeText returns the text of text covered by r, or "" if r lies outside
+// it.
+func rangeText(text string, r lsp.Range) string {
+	lines := strings.Split(text, "\n")
+	if r.Start.Line < 0 || r.Start.Line >= len(lines) || r.End.Line < r.Start.Line {
+		return ""
+	}
+	if r.End.Line >= len(lines) {
+		r.End = lsp.Position{Line: len(lines) - 1, Character: len(lines[len(lines)-1])}
+	}
+
+	start := byteOffset(lines[r.Start.Line], r.Start.Character)
+	end := byteOffset(lines[r.End.Line], r.End.Character)
+	if r.Start.Line == r.End.Line {
+		if end < start {
+			return ""
+		}
+		return lines[r.Start.Line][start:end]
+	}
+
+	parts := []string{lines[r.Start.Line][start:]}
+	parts = append(parts, lines[r.Start.Line+1:r.End.Line]...)
+	parts = append(parts, lines[r.End.Line][:end])
+	return strings.Join(parts, "\n")
+}
+
+// selectionContexts pairs each selected range with its text, sliced from
+// text when known.
+func selectionContexts(text string, hasText bool, selections []lsp.Range) []lsp.SelectionContext {
+	contexts := make([]lsp.SelectionContext, 0, len(selections))
+	for _, r := range selections {
+		c := lsp.SelectionContext{Range: r}
+		if hasText {
+			c.Text = rangeText(text, r)
+		}
+		contexts = append(contexts, c)
+	}
+	return contexts
+}
+
+// rangeEdit replaces the text of a range.
+type rangeEdit struct {
+	r       lsp.Range
+	newText string
+}
+
+// positionBefore reports whether a comes before b.
+func positionBefore(a, b lsp.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
+
+// textOffset converts p to a byte offset in text, clamped to it.
+func textOffset(text string, p lsp.Position) int {
+	offset := 0
+	for line := 0; line < p.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+	end := strings.IndexByte(text[offset:], '\n')
+	if end < 0 {
+		end = len(text) - offset
+	}
+	return offset + byteOffset(text[offset:offset+end], p.Character)
+}
+
+// applyRangeEdits applies non-overlapping edits, sorted by position, to
+// text. Their ranges refer to text as it was before any of them.
+func applyRangeEdits(text string, edits []rangeEdit) string {
+	for i := len(edits) - 1; i >= 0; i-- {
+		start := textOffset(text, edits[i].r.Start)
+		end := max(textOffset(text, edits[i].r.End), start)
+		text = text[:start] + edits[i].newText + text[end:]
+	}
+	return text
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"cmp"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleSessionInfo answers crush/sessionInfo with the session's ID, roots,
+// daemon version, connected clients and negotiated capabilities.
+func (d *Daemon) handleSessionInfo(content []byte, conn net.Conn) {
+	d.respondResult(conn, messageID(content), d.sessionInfo())
+}
+
+func (d *Daemon) sessionInfo() lsp.SessionInfoResult {
+	roots := d.roots()
+	result := lsp.SessionInfoResult{
+		ID:           d.sessionID,
+		Root:         roots[0],
+		Folders:      roots[1:],
+		Version:      version,
+		StartedAt:    d.metrics.Started(),
+		Clients:      []lsp.SessionClient{},
+		Capabilities: d.state.Capabilities(),
+	}
+
+	d.mu.RLock()
+	for name := range d.clients {
+		info := d.clientInfo[name]
+		client := lsp.SessionClient{
+			Type:          clientType(name),
+			Name:          info.Name,
+			Version:       info.Version,
+			PluginVersion: d.clientSettings[name].PluginVersion,
+			Tools:         d.mcpTools[name],
+		}
+		if client.Type != name {
+			client.ID = name
+		}
+		result.Clients = append(result.Clients, client)
+	}
+	d.mu.RUnlock()
+
+	slices.SortFunc(result.Clients, func(a, b lsp.SessionClient) int {
+		return cmp.Or(strings.Compare(a.Type, b.Type), strings.Compare(a.ID, b.ID))
+	})
+	return result
+}
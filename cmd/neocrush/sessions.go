@@ -0,0 +1,200 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// sessionsClientName identifies the sessions command to the daemon.
+const sessionsClientName = "sessions"
+
+// handleDaemonStatus answers crush/daemonStatus.
+func (d *Daemon) handleDaemonStatus(content []byte, conn net.Conn) {
+	d.respondResult(conn, messageID(content), d.daemonStatus())
+}
+
+func (d *Daemon) daemonStatus() lsp.DaemonStatusResult {
+	info := d.sessionInfo()
+	result := lsp.DaemonStatusResult{
+		SessionID: info.ID,
+		Root:      info.Root,
+		Folders:   info.Folders,
+		PID:       os.Getpid(),
+		Version:   version,
+		StartedAt: info.StartedAt,
+		UptimeMS:  time.Since(info.StartedAt).Milliseconds(),
+		Clients: slices.DeleteFunc(info.Clients, func(c lsp.SessionClient) bool {
+			return toolClients[c.Type]
+		}),
+	}
+
+	d.mu.RLock()
+	result.Documents = len(d.documentState)
+	result.OpenDocuments = len(d.neovimOpenDocs)
+	d.mu.RUnlock()
+	return result
+}
+
+// sessionEntry is a session found by the sessions command: an index entry,
+// a socket, or both.
+type sessionEntry struct {
+	Socket    string                  `json:"socket"`
+	ID        string                  `json:"id,omitempty"`        // From the index
+	Workspace string                  `json:"workspace,omitempty"` // From the index
+	Current   bool                    `json:"current"`             // Serves the current directory
+	Status    *lsp.DaemonStatusResult `json:"status,omitempty"`    // Nil if no daemon answered
+	Error     string                  `json:"error,omitempty"`     // Why no daemon answered
+}
+
+// findSessions lists the sessions in the index and the sockets in the
+// socket directory, asking each daemon for its status. The session serving
+// cwd is marked current.
+func findSessions(mgr *session.Manager, cwd string) []sessionEntry {
+	var entries []sessionEntry
+	seen := make(map[string]bool)
+	for _, indexed := range mgr.IndexedSessions() {
+		entries = append(entries, sessionEntry{Socket: indexed.SocketPath, ID: indexed.ID, Workspace: indexed.WorkspaceRoot})
+		seen[indexed.SocketPath] = true
+	}
+	for _, socket := range mgr.Sockets() {
+		if !seen[socket] {
+			entries = append(entries, sessionEntry{Socket: socket})
+		}
+	}
+
+	current := currentSocket(mgr, cwd)
+	for i := range entries {
+		entry := &entries[i]
+		entry.Current = entry.Socket == current
+		status, err := fetchDaemonStatus(entry.Socket, cmp.Or(entry.Workspace, cwd))
+		if err != nil {
+			entry.Error = err.Error()
+			continue
+		}
+		entry.Status = &status
+	}
+	return entries
+}
+
+// currentSocket returns the socket of the session serving cwd, or "" if
+// there is none.
+func currentSocket(mgr *session.Manager, cwd string) string {
+	if indexed, ok := mgr.LookupWorkspace(cwd); ok {
+		return indexed.SocketPath
+	}
+	if sess, err := mgr.LoadSessionMetadata(cwd); err == nil {
+		return sess.SocketPath
+	}
+	return ""
+}
+
+// fetchDaemonStatus identifies as the sessions client and asks the daemon
+// for crush/daemonStatus.
+func fetchDaemonStatus(socketPath, workspace string) (lsp.DaemonStatusResult, error) {
+	var status lsp.DaemonStatusResult
+
+	c, err := connectAs(socketPath, workspace, sessionsClientName)
+	if err != nil {
+		return status, err
+	}
+	defer c.Close()
+
+	result, err := c.Request("crush/daemonStatus", nil)
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(result, &status); err != nil {
+		return status, fmt.Errorf("failed to parse daemon status: %w", err)
+	}
+	return status, nil
+}
+
+func newSessionsCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "List session daemons and who is connected to them",
+		Long: `Lists every session in the socket directory's index, and every socket
+there the index doesn't name, and asks each daemon over crush/daemonStatus
+for its workspace root, connected clients, uptime and documents. The session
+serving the current directory is marked with "*", so you can tell which
+daemon your editor talks to. Sessions whose daemon doesn't answer are shown
+as stale, with the reason.`,
+		Example: `  neocrush sessions
+  neocrush sessions --json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			entries := findSessions(session.NewManager(), cwd)
+
+			out := cmd.OutOrStdout()
+			if jsonOutput {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(entries)
+			}
+			if len(entries) == 0 {
+				fmt.Fprintln(out, "No sessions")
+				return nil
+			}
+			return writeSessions(out, entries)
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the sessions as JSON")
+	return cmd
+}
+
+// writeSessions prints sessions as a table.
+func writeSessions(out io.Writer, entries []sessionEntry) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tSESSION\tWORKSPACE\tSTATUS\tPID\tVERSION\tUPTIME\tCLIENTS\tDOCS\tSOCKET\t")
+	for _, e := range entries {
+		mark := ""
+		if e.Current {
+			mark = "*"
+		}
+		s := e.Status
+		if s == nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\tstale\t-\t-\t-\t-\t-\t%s\t\n",
+				mark, cmp.Or(e.ID, "-"), cmp.Or(e.Workspace, "-"), e.Socket)
+			continue
+		}
+
+		var clients []string
+		for _, c := range s.Clients {
+			clients = append(clients, cmp.Or(c.ID, c.Type))
+		}
+		uptime := (time.Duration(s.UptimeMS) * time.Millisecond).Round(time.Second)
+		fmt.Fprintf(w, "%s\t%s\t%s\trunning\t%d\t%s\t%s\t%s\t%d/%d\t%s\t\n",
+			mark, s.SessionID, s.Root, s.PID, s.Version, uptime,
+			cmp.Or(strings.Join(clients, ", "), "none"), s.OpenDocuments, s.Documents, e.Socket)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.Error != "" {
+			fmt.Fprintf(out, "\n%s: %s", e.Socket, e.Error)
+		}
+	}
+	fmt.Fprintln(out)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// defaultContextLines is how many lines before and after the cursor are
+// shared as editor context when Neovim doesn't ask for a different amount.
+const defaultContextLines = 5
+
+// errUnauthorized rejects a client that did not present the configured token.
+var errUnauthorized = errors.New("unauthorized: missing or invalid authToken")
+
+// parseInitializationOptions decodes a client's initializationOptions.
+// Absent options yield the zero value.
+func parseInitializationOptions(raw json.RawMessage) (lsp.InitializationOptions, error) {
+	var opts lsp.InitializationOptions
+	if len(raw) == 0 || string(raw) == "null" {
+		return opts, nil
+	}
+	err := json.Unmarshal(raw, &opts)
+	return opts, err
+}
+
+// authorized reports whether token matches the configured auth token.
+// Without a configured token every client is authorized.
+func (d *Daemon) authorized(token string) bool {
+	if d.authToken == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(d.authToken)) == 1
+}
+
+// settingsFor returns the initializationOptions a client connected with.
+func (d *Daemon) settingsFor(client string) lsp.InitializationOptions {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.clientSettings[client]
+}
+
+// contextLines returns how many lines around the cursor Neovim shares in
+// uri: its language's context_lines, or else Neovim's setting.
+func (d *Daemon) contextLines(uri string) int {
+	if _, profile := d.language(uri); profile.ContextLines > 0 {
+		return profile.ContextLines
+	}
+	if n := d.settingsFor("neovim").ContextLines; n > 0 {
+		return n
+	}
+	return defaultContextLines
+}
+
+// autoApply reports whether Crush edits may be applied in Neovim without
+// confirmation.
+func (d *Daemon) autoApply() bool {
+	if autoApply := d.settingsFor("neovim").AutoApply; autoApply != nil {
+		return *autoApply
+	}
+	return true
+}
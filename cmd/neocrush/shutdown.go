@@ -0,0 +1,169 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/history"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// teardownFlushTimeout bounds how long teardown waits for clients'
+// queued messages to be written.
+const teardownFlushTimeout = 2 * time.Second
+
+// teardown stops the daemon on a signal or crush/stopDaemon: it stops
+// accepting connections, kills running jobs, records its final state in
+// the session history, tells every client the session is ending (for
+// ending, a SessionEnding* reason), and closes their connections once
+// queued messages are flushed. run returns as soon as the listener is closed.
+func (d *Daemon) teardown(reason, ending string) {
+	d.logger.Printf("Received %s, shutting down", reason)
+	d.listener.Close()
+	d.cancelJobs()
+
+	d.mu.RLock()
+	conns := make(map[string]net.Conn, len(d.clients))
+	for name, conn := range d.clients {
+		conns[name] = conn
+	}
+	d.mu.RUnlock()
+
+	d.recordFinalState(reason)
+	for name := range conns {
+		d.notifySessionEnding(name, lsp.SessionEndingParams{
+			Client: "daemon",
+			Reason: ending,
+		})
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	deadline := time.After(teardownFlushTimeout)
+	for name, conn := range conns {
+		o, ok := conn.(*outbox)
+		if !ok {
+			continue
+		}
+		select {
+		case <-o.flushed():
+		case <-deadline:
+			d.logger.Printf("Gave up flushing messages to %s", name)
+			return
+		}
+	}
+}
+
+// handleStopDaemon answers crush/stopDaemon and has runDaemon tear the
+// daemon down.
+func (d *Daemon) handleStopDaemon(clientName string, content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.StopDaemonParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid stopDaemon params: "+err.Error())
+		return
+	}
+
+	reason := cmp.Or(req.Params.Reason, "crush/stopDaemon from "+clientName)
+	d.logger.Printf("Client %s asked the daemon to stop: %s", clientName, reason)
+	d.respondResult(conn, messageID(content), nil)
+	select {
+	case d.stopRequests <- reason:
+	default: // Already stopping
+	}
+}
+
+// recordFinalState appends the daemon's state at shutdown to the session
+// history, so an interrupted session can be audited afterwards.
+func (d *Daemon) recordFinalState(reason string) {
+	if d.history == nil {
+		return
+	}
+
+	metrics := d.metricsResult()
+	d.mu.RLock()
+	state := map[string]any{
+		"reason":    reason,
+		"clients":   metrics.Clients,
+		"documents": len(d.documentState),
+		"desyncs":   metrics.Desyncs,
+		"uptimeMs":  metrics.UptimeMS,
+	}
+	d.mu.RUnlock()
+
+	params, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	d.appendHistory(history.Record{
+		Kind:    history.KindSession,
+		Client:  "daemon",
+		Summary: "stopped by " + reason,
+		Params:  params,
+	})
+}
+
+// handleShutdown answers an LSP shutdown request with a null result and
+// warns the client's peer with crush/sessionEnding. Further requests from
+// the client are refused until it sends exit.
+func (d *Daemon) handleShutdown(clientName string, content []byte, conn net.Conn) {
+	d.mu.Lock()
+	d.shuttingDown[clientName] = true
+	d.mu.Unlock()
+
+	d.logger.Printf("Client %s requested shutdown", clientName)
+	d.respondResult(conn, messageID(content), nil)
+	d.mu.RLock()
+	peers := d.peersLocked(clientName)
+	d.mu.RUnlock()
+	for _, peer := range peers {
+		d.notifySessionEnding(peer, lsp.SessionEndingParams{
+			Client: clientName,
+			Reason: lsp.SessionEndingShutdown,
+		})
+	}
+}
+
+// refuseAfterShutdown rejects a request from a client that has already
+// requested shutdown, as LSP requires, and drops its notifications other
+// than exit. Responses still pass, so requests pending on the client can
+// complete. Returns true if the message was consumed.
+func (d *Daemon) refuseAfterShutdown(clientName, method string, content []byte, conn net.Conn) bool {
+	d.mu.RLock()
+	shuttingDown := d.shuttingDown[clientName]
+	d.mu.RUnlock()
+
+	if !shuttingDown || method == "" {
+		return false
+	}
+	if id := messageID(content); id != nil {
+		d.respondError(conn, id, lsp.InvalidRequest, "shutdown already requested")
+	}
+	return true
+}
+
+// notifySessionEnding sends crush/sessionEnding to client, if connected.
+func (d *Daemon) notifySessionEnding(client string, params lsp.SessionEndingParams) {
+	d.mu.RLock()
+	conn := d.clients[client]
+	d.mu.RUnlock()
+
+	if conn == nil {
+		return
+	}
+	msg := lsp.SessionEndingNotification{
+		Notification: lsp.Notification{
+			RPC:    "2.0",
+			Method: "crush/sessionEnding",
+		},
+		Params: params,
+	}
+	if _, err := conn.Write([]byte(rpc.EncodeMessage(msg))); err != nil {
+		d.logger.Printf("Failed to notify %s of session end: %v", client, err)
+	}
+}
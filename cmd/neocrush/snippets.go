@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/taigrr/neocrush/internal/snippet"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// insertTextFormatSnippet marks an edit's newText as snippet syntax, as in
+// CompletionItem.insertTextFormat.
+const insertTextFormatSnippet = 2
+
+// adaptSnippetEdits rewrites a workspace/applyEdit from Crush into edits
+// Neovim can apply: InsertReplaceEdits use their replace range, and snippet
+// edits are passed through as SnippetTextEdits when Neovim declared
+// snippetEditSupport, otherwise flattened to plain text. Returns msg
+// unchanged when nothing needed adapting.
+func (d *Daemon) adaptSnippetEdits(msg, content []byte) []byte {
+	var req map[string]any
+	if err := json.Unmarshal(content, &req); err != nil {
+		return msg
+	}
+	params, _ := req["params"].(map[string]any)
+	edit, _ := params["edit"].(map[string]any)
+	if edit == nil {
+		return msg
+	}
+
+	snippets := d.state.Capabilities().SnippetEdits
+
+	changed := false
+	if changes, ok := edit["changes"].(map[string]any); ok {
+		for _, edits := range changes {
+			changed = adaptEditList(edits, snippets) || changed
+		}
+	}
+	if docChanges, ok := edit["documentChanges"].([]any); ok {
+		for _, change := range docChanges {
+			if change, ok := change.(map[string]any); ok {
+				changed = adaptEditList(change["edits"], snippets) || changed
+			}
+		}
+	}
+
+	if !changed {
+		return msg
+	}
+	d.logger.Printf("Adapted snippet edits for neovim (snippetEditSupport=%v)", snippets)
+	return []byte(rpc.EncodeMessage(req))
+}
+
+// adaptEditList adapts each edit in a TextEdit array in place.
+func adaptEditList(edits any, snippets bool) bool {
+	list, ok := edits.([]any)
+	if !ok {
+		return false
+	}
+
+	changed := false
+	for _, edit := range list {
+		if edit, ok := edit.(map[string]any); ok {
+			changed = adaptEdit(edit, snippets) || changed
+		}
+	}
+	return changed
+}
+
+// adaptEdit normalizes one edit in place and reports whether it changed.
+func adaptEdit(edit map[string]any, snippets bool) bool {
+	changed := false
+
+	// InsertReplaceEdit: applying an edit replaces, so use the replace range
+	if replace, ok := edit["replace"]; ok {
+		edit["range"] = replace
+		delete(edit, "insert")
+		delete(edit, "replace")
+		changed = true
+	}
+
+	// TextEdit whose newText is snippet syntax
+	if format, ok := edit["insertTextFormat"].(float64); ok {
+		delete(edit, "insertTextFormat")
+		changed = true
+		if text, ok := edit["newText"].(string); ok && format == insertTextFormatSnippet {
+			if snippets {
+				delete(edit, "newText")
+				edit["snippet"] = map[string]any{"kind": "snippet", "value": text}
+			} else {
+				edit["newText"] = snippet.ToPlainText(text)
+			}
+		}
+	}
+
+	// SnippetTextEdit the client can't expand
+	if value, ok := edit["snippet"].(map[string]any); ok && !snippets {
+		text, _ := value["value"].(string)
+		edit["newText"] = snippet.ToPlainText(text)
+		delete(edit, "snippet")
+		changed = true
+	}
+
+	return changed
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/soak"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// soakGauges are what soak mode watches: the runtime's goroutines and heap,
+// and the daemon's tables that gain an entry per connection, request or
+// document and must lose it again.
+func (d *Daemon) soakGauges() []soak.Gauge {
+	table := func(name string, size func() int) soak.Gauge {
+		return soak.Gauge{Name: name, Read: func() int64 {
+			d.mu.RLock()
+			defer d.mu.RUnlock()
+			return int64(size())
+		}}
+	}
+	return []soak.Gauge{
+		{Name: "goroutines", Read: func() int64 { return int64(runtime.NumGoroutine()) }},
+		{Name: "heap_bytes", Read: func() int64 {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			return int64(stats.HeapAlloc)
+		}},
+		table("clients", func() int { return len(d.clients) }),
+		table("connections", func() int { return len(d.connNumbers) }),
+		table("documents", func() int { return len(d.documentState) }),
+		table("pending_requests", func() int { return len(d.pendingRequests) }),
+		table("relayed_requests", func() int { return len(d.relayed) }),
+		table("spans", func() int { return len(d.spans) }),
+		table("verify_timers", func() int { return len(d.verifyTimers) }),
+		table("previews", func() int { return len(d.previews) }),
+		{Name: "inflight_requests", Read: func() int64 {
+			d.inflight.mu.Lock()
+			defer d.inflight.mu.Unlock()
+			return int64(len(d.inflight.requests))
+		}},
+	}
+}
+
+// soakLoop samples the soak gauges each soakInterval until stop is closed.
+func (d *Daemon) soakLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.soakInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			d.sampleSoak(now)
+		}
+	}
+}
+
+// sampleSoak samples the soak gauges and reports every gauge that has
+// started growing steadily.
+func (d *Daemon) sampleSoak(now time.Time) {
+	for _, g := range d.soak.Sample(now) {
+		d.reportError(lsp.ErrorParams{
+			Code: lsp.ErrorCodeLeak,
+			Message: fmt.Sprintf("%s grew from %d to %d over %d samples since %s without falling; possible leak",
+				g.Gauge, g.From, g.To, g.Samples, g.Since.Format(time.TimeOnly)),
+		})
+	}
+}
+
+// leakWarnings returns the gauges soak mode currently sees growing, for
+// crush/getMetrics.
+func (d *Daemon) leakWarnings() []lsp.LeakWarning {
+	if d.soak == nil {
+		return nil
+	}
+	var warnings []lsp.LeakWarning
+	for _, g := range d.soak.Growing() {
+		warnings = append(warnings, lsp.LeakWarning{
+			Gauge:   g.Gauge,
+			From:    g.From,
+			To:      g.To,
+			SinceMS: g.Since.UnixMilli(),
+		})
+	}
+	return warnings
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/workspace"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// defaultLargestFiles is how many of the largest files crush/workspaceStats
+// lists when the request does not say.
+const defaultLargestFiles = 10
+
+// handleWorkspaceStats answers crush/workspaceStats.
+func (d *Daemon) handleWorkspaceStats(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.WorkspaceStatsParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid workspaceStats params: "+err.Error())
+		return
+	}
+	prefix := strings.Trim(path.Clean("/"+filepath.ToSlash(req.Params.Path)), "/")
+	largest := max(cmp.Or(req.Params.Largest, defaultLargestFiles), 0)
+
+	result := lsp.WorkspaceStatsResult{Languages: []lsp.LanguageStats{}, Largest: []lsp.FileStats{}}
+	languages := make(map[string]*lsp.LanguageStats)
+	var files []lsp.FileStats
+	var sourceLines int
+	for i, root := range d.roots() {
+		list, err := d.workspaceFiles(root, req.Params.Refresh)
+		if err != nil {
+			d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+			return
+		}
+		result.Truncated = result.Truncated || list.truncated
+		lines := d.lineCounts(root, list)
+
+		var folder string
+		if i > 0 {
+			folder = root
+		}
+		for j, f := range list.files {
+			if prefix != "" && !strings.HasPrefix(f.Path, prefix+"/") {
+				continue
+			}
+			stats := lsp.FileStats{
+				FileInfo: lsp.FileInfo{Path: f.Path, Size: f.Size, Language: f.Language, Root: folder},
+				Lines:    max(lines[j], 0),
+				Test:     workspace.IsTest(f.Path),
+			}
+			files = append(files, stats)
+
+			lang := languages[f.Language]
+			if lang == nil {
+				lang = &lsp.LanguageStats{Language: f.Language}
+				languages[f.Language] = lang
+			}
+			lang.Files++
+			lang.Lines += stats.Lines
+			lang.Bytes += f.Size
+			result.Files++
+			result.Lines += stats.Lines
+			result.Bytes += f.Size
+			switch {
+			case stats.Test:
+				lang.TestFiles++
+				lang.TestLines += stats.Lines
+				result.TestFiles++
+				result.TestLines += stats.Lines
+			case f.Language != "":
+				sourceLines += stats.Lines
+			}
+		}
+	}
+
+	for _, lang := range languages {
+		result.Languages = append(result.Languages, *lang)
+	}
+	slices.SortFunc(result.Languages, func(a, b lsp.LanguageStats) int {
+		return cmp.Or(cmp.Compare(b.Lines, a.Lines), strings.Compare(a.Language, b.Language))
+	})
+	slices.SortStableFunc(files, func(a, b lsp.FileStats) int { return cmp.Compare(b.Lines, a.Lines) })
+	result.Largest = append(result.Largest, files[:min(largest, len(files))]...)
+	if sourceLines > 0 {
+		result.TestRatio = float64(result.TestLines) / float64(sourceLines)
+	}
+	d.respondResult(conn, messageID(content), result)
+}
+
+// lineCounts returns the line counts of the files of a walk of root,
+// counting them the first time.
+func (d *Daemon) lineCounts(root string, list *fileList) []int {
+	d.mu.RLock()
+	lines := list.lines
+	d.mu.RUnlock()
+	if lines != nil {
+		return lines
+	}
+
+	lines = make([]int, len(list.files))
+	for i, f := range list.files {
+		n, text, err := workspace.CountLines(filepath.Join(root, filepath.FromSlash(f.Path)))
+		if err != nil || !text {
+			n = -1
+		}
+		lines[i] = n
+	}
+	d.mu.Lock()
+	list.lines = lines
+	d.mu.Unlock()
+	return lines
+}
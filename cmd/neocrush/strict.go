@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// checkSchema validates the params of a crush/* message against its
+// schema when the daemon runs with --strict. A violation is counted in the
+// metrics and the message is dropped: a request is answered with
+// InvalidParams naming the offending field, a notification is reported as
+// a crush/error. It reports whether the message may be routed.
+func (d *Daemon) checkSchema(method string, content []byte, conn net.Conn) bool {
+	if !d.strict || method == "" {
+		return true
+	}
+
+	var msg struct {
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(content, &msg); err != nil {
+		// Undecodable messages are reported where they are routed
+		return true
+	}
+	err := lsp.ValidateParams(method, msg.Params)
+	if err == nil {
+		return true
+	}
+
+	d.metrics.Invalid(method)
+	violation := err.Error()
+	var verr *lsp.ValidationError
+	if errors.As(err, &verr) {
+		violation = verr.Err.Error()
+	}
+
+	if id := messageID(content); id != nil {
+		d.logger.Printf("Rejecting %s: %v", method, err)
+		d.respondErrorData(conn, id, lsp.InvalidParams, err.Error(), lsp.SchemaViolationData{
+			Code:      lsp.ErrorCodeSchema,
+			Method:    method,
+			Violation: violation,
+			Schema:    lsp.SchemaFile(method),
+		})
+		return false
+	}
+	d.reportError(lsp.ErrorParams{
+		Code:    lsp.ErrorCodeSchema,
+		Message: err.Error(),
+		Method:  method,
+	})
+	return false
+}
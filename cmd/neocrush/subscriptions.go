@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// handleSubscribe answers crush/subscribe, replacing the events client is
+// sent. Subscribing to nothing unsubscribes.
+func (d *Daemon) handleSubscribe(client string, content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.SubscribeParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid subscribe params: "+err.Error())
+		return
+	}
+
+	d.mu.Lock()
+	if req.Params == (lsp.SubscribeParams{}) {
+		delete(d.subscriptions, client)
+	} else {
+		d.subscriptions[client] = req.Params
+	}
+	d.mu.Unlock()
+	d.respondResult(conn, messageID(content), lsp.SubscribeResult{Subscribed: req.Params != (lsp.SubscribeParams{})})
+}
+
+// publish sends the notification method to every client whose
+// subscription wants it.
+func (d *Daemon) publish(wants func(lsp.SubscribeParams) bool, method string, params any) {
+	d.mu.RLock()
+	var conns []net.Conn
+	for client, sub := range d.subscriptions {
+		if conn := d.clients[client]; conn != nil && wants(sub) {
+			conns = append(conns, conn)
+		}
+	}
+	d.mu.RUnlock()
+
+	if len(conns) == 0 {
+		return
+	}
+	msg := []byte(rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	}))
+	for _, conn := range conns {
+		if _, err := conn.Write(msg); err != nil {
+			d.logger.Printf("Failed to send %s: %v", method, err)
+		}
+	}
+}
+
+func wantsCursor(sub lsp.SubscribeParams) bool      { return sub.CursorChanges }
+func wantsFocus(sub lsp.SubscribeParams) bool       { return sub.FocusChanges }
+func wantsDocuments(sub lsp.SubscribeParams) bool   { return sub.DocumentChanges }
+func wantsDiagnostics(sub lsp.SubscribeParams) bool { return sub.Diagnostics }
+
+// publishDocumentChanged tells document subscribers uri now reads text.
+func (d *Daemon) publishDocumentChanged(uri, text, source string) {
+	d.publish(wantsDocuments, "crush/documentChanged", lsp.DocumentChangedParams{
+		TextDocument: lsp.VersionTextDocumentIdentifier{
+			TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri},
+		},
+		Content:      text,
+		ChangeSource: source,
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/clock"
+	"github.com/taigrr/neocrush/internal/history"
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// defaultSummaryDelay is how long an agent must stop editing for its
+// transaction to be summed up.
+const defaultSummaryDelay = 2 * time.Second
+
+// editTransaction accumulates an agent's consecutive edits.
+type editTransaction struct {
+	started time.Time
+	last    time.Time
+	files   []*lsp.FileEditStat // In order of first edit
+	timer   clock.Timer
+}
+
+// trackTransaction adds an edit by client to its open transaction,
+// starting one if needed, and postpones the summary.
+func (d *Daemon) trackTransaction(client, uri string, added, removed int) {
+	now := d.clock.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx := d.transactions[client]
+	if tx == nil {
+		tx = &editTransaction{started: now}
+		tx.timer = d.clock.AfterFunc(d.summaryDelay, func() { d.finishTransaction(client) })
+		d.transactions[client] = tx
+	} else {
+		tx.timer.Reset(d.summaryDelay)
+	}
+	tx.last = now
+
+	var stat *lsp.FileEditStat
+	for _, f := range tx.files {
+		if f.URI == uri {
+			stat = f
+			break
+		}
+	}
+	if stat == nil {
+		stat = &lsp.FileEditStat{URI: uri}
+		tx.files = append(tx.files, stat)
+	}
+	stat.Edits++
+	stat.Added += added
+	stat.Removed += removed
+}
+
+// finishTransaction closes client's transaction, sends its summary to
+// Neovim, and records it in the session history.
+func (d *Daemon) finishTransaction(client string) {
+	d.mu.Lock()
+	tx := d.transactions[client]
+	delete(d.transactions, client)
+	neovim := d.clients["neovim"]
+	d.mu.Unlock()
+	if tx == nil {
+		return
+	}
+
+	params := lsp.EditSummaryParams{
+		Client:     client,
+		Started:    tx.started.Format(time.RFC3339),
+		DurationMS: tx.last.Sub(tx.started).Milliseconds(),
+	}
+	for _, f := range tx.files {
+		params.Files = append(params.Files, *f)
+		params.Added += f.Added
+		params.Removed += f.Removed
+	}
+	params.Summary = diffStat(len(params.Files), params.Added, params.Removed)
+
+	d.logger.Printf("Edit summary for %s: %s", client, params.Summary)
+	if d.history != nil {
+		raw, _ := json.Marshal(params.Files)
+		d.appendHistory(history.Record{
+			Kind:    history.KindSummary,
+			Client:  client,
+			Summary: params.Summary,
+			Params:  raw,
+		})
+	}
+
+	if neovim == nil {
+		return
+	}
+	msg := lsp.EditSummaryNotification{
+		Notification: lsp.Notification{
+			RPC:    "2.0",
+			Method: "crush/editSummary",
+		},
+		Params: params,
+	}
+	if _, err := neovim.Write([]byte(rpc.EncodeMessage(msg))); err != nil {
+		d.logger.Printf("Failed to send edit summary to neovim: %v", err)
+	}
+}
+
+// diffStat formats a git-style summary line.
+func diffStat(files, added, removed int) string {
+	s := fmt.Sprintf("%d %s changed", files, plural(files, "file", "files"))
+	if added > 0 || removed == 0 {
+		s += fmt.Sprintf(", %d %s(+)", added, plural(added, "insertion", "insertions"))
+	}
+	if removed > 0 {
+		s += fmt.Sprintf(", %d %s(-)", removed, plural(removed, "deletion", "deletions"))
+	}
+	return s
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/state"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// maxScannedFileSize skips large (usually generated) files.
+	maxScannedFileSize = 1 << 20
+	// maxListedTodos caps a scan.
+	maxListedTodos = 5000
+)
+
+// handleListTodos answers crush/listTodos with the marker comments in the
+// files of every workspace folder.
+func (d *Daemon) handleListTodos(content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.ListTodosParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid listTodos params: "+err.Error())
+		return
+	}
+
+	if req.Params.Cursor != "" {
+		snapshot, id, offset, err := d.pages.load("crush/listTodos", req.Params.Cursor)
+		if err != nil {
+			d.respondError(conn, messageID(content), lsp.InvalidParams, err.Error())
+			return
+		}
+		d.respondResult(conn, messageID(content), listTodosPage(snapshot.(lsp.ListTodosResult), req.Params.PageParams, offset, func() int { return id }))
+		return
+	}
+
+	markers := d.todoMarkers
+	if len(markers) == 0 {
+		markers = state.DefaultMarkers
+	}
+	scanner, err := state.NewScanner(markers, nil)
+	if err != nil {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+		return
+	}
+	ctx := d.requestContext(conn, content)
+	progress := beginWorkDone(conn, content, "Scanning for TODOs")
+	defer progress.end("")
+
+	// Clean relative to the root so ".." can't escape it
+	prefix := strings.Trim(path.Clean("/"+filepath.ToSlash(req.Params.Path)), "/")
+
+	result := lsp.ListTodosResult{Todos: []lsp.TodoInfo{}}
+	for i, root := range d.roots() {
+		list, err := d.workspaceFiles(root, false)
+		if err != nil {
+			d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+			return
+		}
+		result.Truncated = result.Truncated || list.truncated
+
+		var folder string
+		if i > 0 {
+			folder = root
+		}
+		for n, f := range list.files {
+			if err := ctx.Err(); err != nil {
+				d.respondError(conn, messageID(content), failureCode(err, lsp.RequestFailed), err.Error())
+				return
+			}
+			if prefix != "" && !strings.HasPrefix(f.Path, prefix+"/") {
+				continue
+			}
+			if f.Size > maxScannedFileSize {
+				result.Truncated = true
+				continue
+			}
+			progress.report(n, len(list.files), "Scanning "+f.Path)
+			text := d.documentText("file://" + filepath.Join(root, filepath.FromSlash(f.Path)))
+			if strings.IndexByte(text[:min(len(text), binarySniffLen)], 0) >= 0 {
+				continue
+			}
+			for _, note := range scanner.Notes(text) {
+				if len(result.Todos) == maxListedTodos {
+					result.Truncated = true
+					break
+				}
+				result.Todos = append(result.Todos, lsp.TodoInfo{
+					Path:   f.Path,
+					Line:   note.Line + 1,
+					Marker: note.Marker,
+					Owner:  note.Owner,
+					Text:   note.Text,
+					Root:   folder,
+				})
+			}
+		}
+	}
+	d.respondResult(conn, messageID(content), listTodosPage(result, req.Params.PageParams, 0, func() int {
+		return d.pages.save("crush/listTodos", result)
+	}))
+}
+
+// listTodosPage returns the page of result that params asks for, starting
+// at offset. snapshot returns the ID of result's page snapshot.
+func listTodosPage(result lsp.ListTodosResult, params lsp.PageParams, offset int, snapshot func() int) lsp.ListTodosResult {
+	start, end, next := paginate(params, len(result.Todos), offset, snapshot)
+	result.Todos = result.Todos[start:end]
+	result.NextCursor = next
+	return result
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"github.com/taigrr/neocrush/internal/tracing"
+)
+
+// spanKey identifies a request a client is waiting on.
+type spanKey struct {
+	conn net.Conn
+	id   string
+}
+
+// traceRequest starts the span of a request received from client, joining
+// the trace named by the message's "traceparent" member if present. The
+// span ends when the request is answered (see endSpan).
+func (d *Daemon) traceRequest(conn net.Conn, client, method string, content []byte) {
+	if d.tracer == nil {
+		return
+	}
+	id := messageID(content)
+	if id == nil {
+		return
+	}
+
+	var msg struct {
+		Traceparent string `json:"traceparent"`
+	}
+	_ = json.Unmarshal(content, &msg)
+	parent, _ := tracing.ParseTraceparent(msg.Traceparent)
+
+	span := d.tracer.Start(method, tracing.KindServer, parent)
+	span.SetAttr("rpc.system", "jsonrpc")
+	span.SetAttr("rpc.method", method)
+	span.SetAttr("neocrush.client", client)
+
+	d.mu.Lock()
+	d.spans[spanKey{conn, string(id)}] = span
+	d.mu.Unlock()
+}
+
+// endSpan ends the span of the request id from conn once it is answered.
+// A non-empty errMessage marks it failed.
+func (d *Daemon) endSpan(conn net.Conn, id json.RawMessage, errMessage string) {
+	if d.tracer == nil {
+		return
+	}
+	key := spanKey{conn, string(id)}
+
+	d.mu.Lock()
+	span := d.spans[key]
+	delete(d.spans, key)
+	d.mu.Unlock()
+
+	if errMessage != "" {
+		span.SetError(errMessage)
+	}
+	span.End()
+}
+
+// endSpans ends the spans of requests conn is still waiting on when it
+// disconnects.
+func (d *Daemon) endSpans(conn net.Conn) {
+	if d.tracer == nil {
+		return
+	}
+
+	d.mu.Lock()
+	var open []*tracing.Span
+	for key, span := range d.spans {
+		if key.conn == conn {
+			open = append(open, span)
+			delete(d.spans, key)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, span := range open {
+		span.SetError("client disconnected before the response")
+		span.End()
+	}
+}
+
+// requestContext returns a context carrying the span of the request in
+// content, so calls made while serving it join its trace. The context is
+// cancelled if the client cancels the request or disconnects, or once the
+// request's deadline (see handlerDeadline) passes.
+func (d *Daemon) requestContext(conn net.Conn, content []byte) context.Context {
+	ctx := context.Background()
+	if d.tracer != nil {
+		d.mu.RLock()
+		span := d.spans[spanKey{conn, string(messageID(content))}]
+		d.mu.RUnlock()
+		ctx = tracing.ContextWithSpan(ctx, span)
+	}
+
+	id := messageID(content)
+	if id == nil {
+		return ctx
+	}
+	return d.inflight.start(ctx, conn, id, d.handlerDeadline(content))
+}
+
+// startHop starts the span of a request the daemon sends to a peer, as a
+// child of parent. The caller holds d.mu.
+func (d *Daemon) startHop(parent tracing.SpanContext, method, to string) *tracing.Span {
+	span := d.tracer.Start(method, tracing.KindClient, parent)
+	span.SetAttr("rpc.system", "jsonrpc")
+	span.SetAttr("rpc.method", method)
+	if to != "" {
+		span.SetAttr("neocrush.peer", to)
+	}
+	return span
+}
+
+// endHop ends the span of a request sent to a peer with the peer's response.
+func endHop(span *tracing.Span, response []byte) {
+	if span == nil {
+		return
+	}
+	if msg := responseError(response); msg != "" {
+		span.SetError(msg)
+	}
+	span.End()
+}
+
+// responseError returns the error message of a JSON-RPC response, or "".
+func responseError(content []byte) string {
+	var resp struct {
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(content, &resp) != nil || resp.Error == nil {
+		return ""
+	}
+	if resp.Error.Message == "" {
+		return "error"
+	}
+	return resp.Error.Message
+}
+
+// traceSuffix tags a log line with the trace it belongs to.
+func traceSuffix(sc tracing.SpanContext) string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return " trace=" + sc.TraceIDString()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// transcriptURI returns the URI of the session's conversation document.
+func (d *Daemon) transcriptURI() string {
+	return lsp.ScratchScheme + "conversation/" + cmp.Or(d.sessionID, "session") + ".md"
+}
+
+// handleAppendTranscript answers crush/appendTranscript from client type
+// from, adding an entry to the conversation and showing it in Neovim.
+func (d *Daemon) handleAppendTranscript(from string, content []byte, conn net.Conn) {
+	var req struct {
+		Params lsp.AppendTranscriptParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "invalid appendTranscript params: "+err.Error())
+		return
+	}
+	text := strings.TrimRight(req.Params.Text, "\n")
+	if text == "" {
+		d.respondError(conn, messageID(content), lsp.InvalidParams, "text is required")
+		return
+	}
+	entry := fmt.Sprintf("## %s · %s\n\n%s\n\n", cmp.Or(req.Params.Role, from), time.Now().Format(time.TimeOnly), text)
+
+	uri := d.transcriptURI()
+	params := lsp.OpenScratchParams{URI: uri, Title: "conversation.md", LanguageID: "markdown", Split: true}
+	d.mu.Lock()
+	if d.transcript == "" {
+		d.transcript = "# Conversation\n\n"
+	}
+	d.transcript += entry
+	neovim := d.clients["neovim"]
+	if neovim != nil {
+		params.Text, params.Append = d.transcript, false
+		if d.transcriptShown {
+			params.Text, params.Append = entry, true
+		}
+		d.transcriptShown = true
+		d.scratches[uri] = true
+		d.neovimOpenDocs[uri] = true
+		d.documentState[uri] = d.transcript
+	}
+	d.mu.Unlock()
+
+	if neovim != nil {
+		d.state.Touch()
+		if err := sendScratch(neovim, params); err != nil {
+			d.logger.Printf("Failed to send the conversation to neovim: %v", err)
+		}
+	}
+	d.respondResult(conn, messageID(content), lsp.TranscriptResult{URI: uri})
+}
+
+// handleGetTranscript answers crush/getTranscript.
+func (d *Daemon) handleGetTranscript(content []byte, conn net.Conn) {
+	d.mu.RLock()
+	result := lsp.TranscriptResult{URI: d.transcriptURI(), Text: d.transcript}
+	d.mu.RUnlock()
+	d.respondResult(conn, messageID(content), result)
+}
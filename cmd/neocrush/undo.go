@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// maxEditGroups bounds the agent operations remembered for undo.
+const maxEditGroups = 100
+
+// editGroup is an agent operation sent to Neovim.
+type editGroup struct {
+	group lsp.EditGroup
+	uris  []string
+}
+
+// beginEditGroup records a new agent operation on uris and returns the
+// group to send with the applyEdit that carries it.
+func (d *Daemon) beginEditGroup(label string, uris []string) *lsp.EditGroup {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.editGroupSeq++
+	group := lsp.EditGroup{ID: fmt.Sprintf("g%d", d.editGroupSeq), Label: label}
+	d.editGroups = append(d.editGroups, editGroup{group: group, uris: uris})
+	if len(d.editGroups) > maxEditGroups {
+		d.editGroups = d.editGroups[len(d.editGroups)-maxEditGroups:]
+	}
+	return &group
+}
+
+// groupApplyEdit adds an edit group to a workspace/applyEdit request from
+// Crush, labelled with the request's label. Returns msg unchanged if it
+// cannot be parsed.
+func (d *Daemon) groupApplyEdit(msg []byte) []byte {
+	_, content, err := rpc.DecodeMessage(msg)
+	if err != nil {
+		return msg
+	}
+	var req map[string]any
+	if err := json.Unmarshal(content, &req); err != nil {
+		return msg
+	}
+	params, _ := req["params"].(map[string]any)
+	if params == nil {
+		return msg
+	}
+
+	label, _ := params["label"].(string)
+	if label == "" {
+		label = "Crush edit"
+	}
+	params["editGroup"] = d.beginEditGroup(label, workspaceEditStats(content).uris)
+	return []byte(rpc.EncodeMessage(req))
+}
+
+// handleUndoLastAgentEdit answers crush/undoLastAgentEdit.
+func (d *Daemon) handleUndoLastAgentEdit(content []byte, conn net.Conn) {
+	result, err := d.undoLastAgentEdit(d.requestContext(conn, content))
+	if err != nil {
+		d.respondError(conn, messageID(content), lsp.RequestFailed, err.Error())
+		return
+	}
+	d.respondResult(conn, messageID(content), result)
+}
+
+// undoLastAgentEdit asks Neovim to undo the most recent agent operation,
+// then pushes the restored buffers to Crush.
+func (d *Daemon) undoLastAgentEdit(ctx context.Context) (lsp.UndoLastAgentEditResult, error) {
+	d.mu.RLock()
+	var last editGroup
+	if n := len(d.editGroups); n > 0 {
+		last = d.editGroups[n-1]
+	}
+	d.mu.RUnlock()
+
+	if last.group.ID == "" {
+		return lsp.UndoLastAgentEditResult{Reason: "no agent edits to undo"}, nil
+	}
+	result := lsp.UndoLastAgentEditResult{Group: last.group, URIs: last.uris}
+
+	raw, err := d.call(ctx, "neovim", "crush/undoEditGroup", lsp.UndoEditGroupParams{
+		Group: last.group,
+		URIs:  last.uris,
+	}, d.commandTimeout)
+	if err != nil {
+		return result, err
+	}
+	var undo lsp.UndoEditGroupResult
+	if err := json.Unmarshal(raw, &undo); err != nil {
+		return result, errors.New("invalid crush/undoEditGroup response")
+	}
+	result.Undone, result.Reason = undo.Undone, undo.Reason
+	if !undo.Undone {
+		return result, nil
+	}
+
+	d.mu.Lock()
+	for i, g := range d.editGroups {
+		if g.group.ID == last.group.ID {
+			d.editGroups = append(d.editGroups[:i], d.editGroups[i+1:]...)
+			break
+		}
+	}
+	d.mu.Unlock()
+
+	for _, uri := range last.uris {
+		d.syncFromNeovim(ctx, uri)
+	}
+	d.logger.Printf("Undid agent edit %s (%s)", last.group.ID, last.group.Label)
+	return result, nil
+}
+
+// syncFromNeovim makes Neovim's buffer of uri the baseline and pushes it to
+// Crush, after Neovim changed it on the daemon's behalf.
+func (d *Daemon) syncFromNeovim(ctx context.Context, uri string) {
+	text := d.documentContent(ctx, "neovim", uri)
+	if text == nil {
+		return
+	}
+
+	d.mu.Lock()
+	previous, hadBaseline := d.documentState[uri]
+	d.documentState[uri] = *text
+	d.mu.Unlock()
+	d.state.Touch()
+	if hadBaseline && previous != *text {
+		d.recordChange("undo", uri, previous, *text)
+	}
+
+	if err := d.sendDocumentChanged(uri, *text, "neovim"); err != nil {
+		d.logger.Printf("Failed to push %s to crush: %v", uri, err)
+	}
+	d.documentReplaced(uri)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// defaultVerifyInterval is how often every tracked document is verified.
+	defaultVerifyInterval = 30 * time.Second
+	// defaultVerifyDelay gives Neovim time to apply an edit before it is verified.
+	defaultVerifyDelay = 500 * time.Millisecond
+	// verifyTimeout bounds how long the daemon waits for a client's hash.
+	verifyTimeout = 5 * time.Second
+)
+
+// contentHash is the crush/verifySync hash of a document's text.
+func contentHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyLoop verifies every tracked document each verifyInterval until stop
+// is closed.
+func (d *Daemon) verifyLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.verifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			d.mu.RLock()
+			uris := make([]string, 0, len(d.documentState))
+			for uri := range d.documentState {
+				uris = append(uris, uri)
+			}
+			d.mu.RUnlock()
+
+			for _, uri := range uris {
+				d.verifySync(uri)
+			}
+		}
+	}
+}
+
+// scheduleVerify verifies uri once Neovim has had time to apply an edit.
+// Edits in quick succession share one verification.
+func (d *Daemon) scheduleVerify(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if timer, ok := d.verifyTimers[uri]; ok {
+		timer.Reset(d.verifyDelay)
+		return
+	}
+	d.verifyTimers[uri] = d.clock.AfterFunc(d.verifyDelay, func() {
+		d.mu.Lock()
+		delete(d.verifyTimers, uri)
+		d.mu.Unlock()
+		d.verifySync(uri)
+	})
+}
+
+// verifySync compares the daemon's copy of uri with Neovim's buffer and
+// Crush's copy via crush/verifySync. Clients that don't have the document,
+// don't implement the method, or don't answer in time are skipped, as are
+// documents under review. A mismatch is counted as a desync and the
+// document is resynced.
+func (d *Daemon) verifySync(uri string) {
+	d.mu.RLock()
+	text, ok := d.documentState[uri]
+	clients := slices.Clone(d.agents)
+	if d.neovimOpenDocs[uri] {
+		clients = append(clients, "neovim")
+	}
+	_, reviewing := d.reviews[uri] // Neovim lacks the hunks under review
+	d.mu.RUnlock()
+	if !ok || reviewing {
+		return
+	}
+
+	want := contentHash(text)
+	params := lsp.VerifySyncParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+		Hash:         want,
+	}
+
+	var mismatched []string
+	for _, client := range clients {
+		result, err := d.call(context.Background(), client, "crush/verifySync", params, verifyTimeout)
+		if err != nil {
+			d.logger.Printf("verifySync %s: %v", uri, err)
+			continue
+		}
+		var got lsp.VerifySyncResult
+		if err := json.Unmarshal(result, &got); err != nil || got.Hash == "" {
+			continue
+		}
+		if got.Hash != want {
+			mismatched = append(mismatched, client)
+		}
+	}
+
+	d.mu.Lock()
+	if d.documentState[uri] != text {
+		// Changed while we were asking; the answers are stale
+		d.mu.Unlock()
+		return
+	}
+	if len(mismatched) == 0 {
+		d.mu.Unlock()
+		return
+	}
+	d.desyncs++
+	desyncs := d.desyncs
+	d.mu.Unlock()
+
+	d.logger.Printf("Desync #%d: %s differs in %s", desyncs, uri, strings.Join(mismatched, ", "))
+	d.reportError(lsp.ErrorParams{
+		Code:    lsp.ErrorCodeDesync,
+		Message: fmt.Sprintf("%s is out of sync in %s, resyncing", extractFilename(uri), strings.Join(mismatched, " and ")),
+		Method:  "crush/verifySync",
+		URI:     uri,
+	})
+	if _, err := d.resyncDocument(context.Background(), uri, ""); err != nil {
+		d.logger.Printf("Resync of %s failed: %v", uri, err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/taigrr/neocrush/internal/charset"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// handleWriteFile answers crush/writeFile. Files open in Neovim are edited
+// in the buffer so undo history survives; others are written to disk.
+func (d *Daemon) handleWriteFile(from string, content []byte, conn net.Conn) {
+	id := messageID(content)
+
+	var req struct {
+		Params lsp.WriteFileParams `json:"params"`
+	}
+	if err := json.Unmarshal(content, &req); err != nil {
+		d.respondError(conn, id, lsp.InvalidParams, "invalid writeFile params: "+err.Error())
+		return
+	}
+
+	result, code, err := d.writeFile(d.requestContext(conn, content), from, req.Params)
+	if data := editErrorData(err); data != nil {
+		d.respondErrorData(conn, id, code, err.Error(), data)
+		return
+	}
+	if err != nil {
+		d.respondError(conn, id, code, err.Error())
+		return
+	}
+	d.respondResult(conn, id, result)
+}
+
+// writeFile applies a write requested by client from. On failure it also
+// returns the JSON-RPC error code to answer with.
+func (d *Daemon) writeFile(ctx context.Context, from string, params lsp.WriteFileParams) (lsp.WriteFileResult, int, error) {
+	return d.applyWrite(ctx, from, params, true)
+}
+
+// applyWrite is writeFile, running checkEdit on the write only if check is
+// set; callers writing several files check them together beforehand.
+func (d *Daemon) applyWrite(ctx context.Context, from string, params lsp.WriteFileParams, check bool) (lsp.WriteFileResult, int, error) {
+	path, err := d.resolveWorkspacePath(params.Path)
+	if err != nil {
+		return lsp.WriteFileResult{}, lsp.InvalidParams, err
+	}
+	result := lsp.WriteFileResult{Path: path}
+	uri := "file://" + path
+	if formatted, ok := d.formatContent(uri, params.Content); ok {
+		params.Content = formatted
+		result.Formatted = true
+	}
+
+	d.mu.RLock()
+	neovimHasFile := d.neovimOpenDocs[uri]
+	_, staged := d.previews[uri]
+	d.mu.RUnlock()
+
+	// The file keeps its encoding; the daemon works in UTF-8
+	old, encoding, err := readText(path)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return result, lsp.RequestFailed, err
+	}
+	if params.Create && (exists || neovimHasFile || staged) {
+		return result, lsp.InvalidParams, fmt.Errorf("%s already exists", params.Path)
+	}
+	result.Created = !exists && !neovimHasFile
+
+	if check {
+		if err := d.checkEdit(from, "crush/writeFile", editStats{uris: []string{uri}, bytes: len(params.Content)}); err != nil {
+			return result, lsp.RequestFailed, err
+		}
+	}
+
+	// New files wait in a preview for the user to accept them
+	if result.Created && d.previewsCreated() {
+		return d.stagePreview(from, uri, params.Content, result), 0, nil
+	}
+
+	// Protected files in confirm mode and writes over the guardrails also go
+	// through Neovim, to be confirmed
+	large := d.oversized(documentEditStats(uri, computeLineEdits(old, params.Content)))
+	if neovimHasFile || d.confirmProtected(uri) || large {
+		return d.writeBuffer(ctx, from, uri, params.Content, result)
+	}
+	return d.writeDisk(from, uri, old, encoding, params.Content, result)
+}
+
+// writeDisk writes content over the file of uri, whose text was old in
+// encoding, and opens it in Neovim with the change highlighted.
+func (d *Daemon) writeDisk(from, uri, old string, encoding charset.Encoding, content string, result lsp.WriteFileResult) (lsp.WriteFileResult, int, error) {
+	path, _ := uriToPath(uri)
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			return result, lsp.InvalidParams, fmt.Errorf("%s is a directory", path)
+		}
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return result, lsp.RequestFailed, err
+	}
+	data, err := charset.Encode(content, cmp.Or(encoding, charset.UTF8))
+	if err != nil {
+		return result, lsp.InvalidParams, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data, mode); err != nil {
+		return result, lsp.RequestFailed, err
+	}
+	result.Via = "disk"
+	result.Applied = true
+
+	d.mu.Lock()
+	d.documentState[uri] = content
+	if result.Created {
+		d.fileLists = nil // The listing is missing the new file
+	}
+	d.mu.Unlock()
+	d.state.Touch()
+	d.fixEdited(uri)
+	d.documentReplaced(uri)
+	d.recordChange(from, uri, old, content)
+
+	// Open the file in Neovim and highlight the change, as for Crush's edits
+	if edits := noOpEdits(old, content); len(edits) > 0 && d.state.Capabilities().ApplyEdit {
+		d.requestNeovim("workspace/applyEdit", d.withFoldHint(map[string]any{
+			"label": "Crush edit",
+			"edit":  map[string]any{"changes": map[string]any{uri: edits}},
+		}, uri, old, content))
+	}
+
+	d.logger.Printf("Wrote %s to disk (created=%v)", path, result.Created)
+	return result, 0, nil
+}
+
+// writeBuffer replaces the content of a document open in Neovim through
+// workspace/applyEdit, leaving it unsaved.
+func (d *Daemon) writeBuffer(ctx context.Context, from, uri, content string, result lsp.WriteFileResult) (lsp.WriteFileResult, int, error) {
+	result.Via = "buffer"
+	if !d.state.Capabilities().ApplyEdit {
+		return result, lsp.RequestFailed, errors.New("neovim does not support workspace/applyEdit")
+	}
+
+	current := d.documentContent(ctx, "neovim", uri)
+	if current == nil {
+		d.mu.RLock()
+		text, ok := d.documentState[uri]
+		d.mu.RUnlock()
+		if !ok {
+			// Not loaded in Neovim: start from the file on disk, if any
+			path, _ := uriToPath(uri)
+			var err error
+			if text, _, err = readText(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return result, lsp.RequestFailed, errors.New("could not read Neovim's buffer")
+			}
+		}
+		current = &text
+	}
+
+	edits := computeLineEdits(*current, content)
+	if len(edits) == 0 {
+		result.Applied = true
+		return result, 0, nil
+	}
+
+	raw, err := d.call(ctx, "neovim", "workspace/applyEdit", d.withFoldHint(map[string]any{
+		"label":     "Crush edit",
+		"edit":      d.workspaceEdit(uri, edits),
+		"editGroup": d.beginEditGroup("Write "+extractFilename(uri), []string{uri}),
+	}, uri, *current, content), d.commandTimeout)
+	if err != nil {
+		return result, lsp.RequestFailed, err
+	}
+	var applied struct {
+		Applied       bool   `json:"applied"`
+		FailureReason string `json:"failureReason"`
+	}
+	if err := json.Unmarshal(raw, &applied); err != nil {
+		return result, lsp.RequestFailed, err
+	}
+	result.Applied = applied.Applied
+	if !applied.Applied {
+		d.logger.Printf("Neovim rejected write to %s: %s", uri, applied.FailureReason)
+		return result, 0, nil
+	}
+
+	d.mu.Lock()
+	d.documentState[uri] = content
+	d.mu.Unlock()
+	d.state.Touch()
+	d.fixEdited(uri)
+	d.documentReplaced(uri)
+	d.recordChange(from, uri, *current, content)
+	d.scheduleVerify(uri)
+	return result, 0, nil
+}
+
+// documentReplaced drops state derived from the previous content of uri
+// and checks its new content for diagnostics.
+func (d *Daemon) documentReplaced(uri string) {
+	d.clearInlayHints(uri)
+	d.clearCodeLenses(uri)
+	d.clearCompletions(uri)
+
+	d.mu.RLock()
+	text, ok := d.documentState[uri]
+	d.mu.RUnlock()
+	if ok {
+		d.lint(uri, "", text, 0)
+	}
+}
@@ -0,0 +1,153 @@
+// Package boilerplate generates the content of new files from templates:
+// a license header, a test file scaffolded after the project's conventions,
+// or a template the workspace keeps in .crush/templates.
+package boilerplate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Built-in templates.
+const (
+	License = "license" // Params: path, body, license, holder, year
+	Test    = "test"    // Params: source
+)
+
+// Dir holds a workspace's own templates, <name>.tmpl, relative to its root.
+const Dir = ".crush/templates"
+
+// Project is the workspace a file is generated in.
+type Project struct {
+	Root  string
+	Files []string // The workspace's files, slash-separated relative to Root
+	Year  int      // For copyright lines
+}
+
+// File is a generated file.
+type File struct {
+	Path    string // Slash-separated, relative to the root
+	Content string
+}
+
+// Names returns the templates available in the workspace at root, sorted.
+func Names(root string) []string {
+	names := []string{License, Test}
+	entries, _ := os.ReadDir(filepath.Join(root, filepath.FromSlash(Dir)))
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".tmpl"); ok && !entry.IsDir() && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Generate renders template name with params. A workspace template named
+// like a built-in one replaces it.
+func Generate(p Project, name string, params map[string]string) (File, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return File{}, fmt.Errorf("invalid template name %q", name)
+	}
+	src, err := os.ReadFile(filepath.Join(p.Root, filepath.FromSlash(Dir), name+".tmpl"))
+	switch {
+	case err == nil:
+		return generateCustom(p, name, string(src), params)
+	case !errors.Is(err, os.ErrNotExist):
+		return File{}, err
+	}
+
+	switch name {
+	case License:
+		return generateLicensed(p, params)
+	case Test:
+		return generateTest(p, params)
+	}
+	return File{}, fmt.Errorf("unknown template %q (have %s)", name, strings.Join(Names(p.Root), ", "))
+}
+
+// generateLicensed generates params["path"] holding params["body"] under
+// the project's license header.
+func generateLicensed(p Project, params map[string]string) (File, error) {
+	file, err := target(params)
+	if err != nil {
+		return File{}, err
+	}
+	header, err := licenseHeader(p, file.Path, params)
+	if err != nil {
+		return File{}, err
+	}
+	if header == "" {
+		return File{}, errors.New("no license found: add a LICENSE file or give license or holder")
+	}
+	file.Content = withHeader(header, params["body"])
+	return file, nil
+}
+
+// generateCustom renders a workspace template. Its data are params, and
+// Year, Holder, License (an SPDX identifier), Name (the output file's name
+// without extension) and Dir; header returns the license header for the
+// output file, "" if the project has none.
+func generateCustom(p Project, name, src string, params map[string]string) (File, error) {
+	file, err := target(params)
+	if err != nil {
+		return File{}, err
+	}
+	holder, license := detectLicense(p.Root)
+	base := path.Base(file.Path)
+	data := map[string]any{
+		"Year":    year(p, params),
+		"Holder":  holder,
+		"License": license,
+		"Name":    strings.TrimSuffix(base, path.Ext(base)),
+		"Dir":     path.Dir(file.Path),
+	}
+	for k, v := range params {
+		data[k] = v
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"header": func() (string, error) { return licenseHeader(p, file.Path, params) },
+	}).Parse(src)
+	if err != nil {
+		return File{}, fmt.Errorf("template %s: %w", name, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return File{}, fmt.Errorf("template %s: %w", name, err)
+	}
+	file.Content = out.String()
+	return file, nil
+}
+
+// target returns the file params["path"] names, which must stay inside
+// the workspace.
+func target(params map[string]string) (File, error) {
+	p := params["path"]
+	if p == "" {
+		return File{}, errors.New("path is required")
+	}
+	return File{Path: cleanPath(p)}, nil
+}
+
+// cleanPath makes p slash-separated and relative, with leading ".."
+// dropped; the daemon rejects paths outside the workspace anyway.
+func cleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+// year returns params["year"], or the project's year.
+func year(p Project, params map[string]string) string {
+	if y := params["year"]; y != "" {
+		return y
+	}
+	return strconv.Itoa(p.Year)
+}
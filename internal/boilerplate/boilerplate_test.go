@@ -0,0 +1,235 @@
+package boilerplate
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const mitLicense = `MIT License
+
+Copyright (c) 2021-2024 Jane Doe
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software...
+`
+
+// project writes files (slash-separated path to content) under a new root.
+func project(t *testing.T, files map[string]string) Project {
+	t.Helper()
+	p := Project{Root: t.TempDir(), Year: 2026}
+	for name, content := range files {
+		path := filepath.Join(p.Root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		p.Files = append(p.Files, name)
+	}
+	slices.Sort(p.Files)
+	return p
+}
+
+func TestLicense(t *testing.T) {
+	p := project(t, map[string]string{"LICENSE": mitLicense})
+
+	file, err := Generate(p, License, map[string]string{"path": "scripts/run.sh", "body": "#!/bin/sh\necho hi\n"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	want := "#!/bin/sh\n# Copyright 2026 Jane Doe\n# SPDX-License-Identifier: MIT\n\necho hi\n"
+	if file.Path != "scripts/run.sh" || file.Content != want {
+		t.Errorf("Got %s:\n%s\nwant:\n%s", file.Path, file.Content, want)
+	}
+
+	file, err = Generate(p, License, map[string]string{"path": "a.css", "license": "Apache-2.0", "holder": "Acme", "year": "2020"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if want := "/* Copyright 2020 Acme */\n/* SPDX-License-Identifier: Apache-2.0 */\n"; file.Content != want {
+		t.Errorf("Got %q, want %q", file.Content, want)
+	}
+
+	if _, err := Generate(project(t, nil), License, map[string]string{"path": "a.go"}); err == nil {
+		t.Error("Expected an error without a license")
+	}
+}
+
+func TestGoTest(t *testing.T) {
+	src := `// Copyright 2026 Jane Doe
+// SPDX-License-Identifier: MIT
+
+package parse
+
+func Parse(s string) error { return nil }
+
+func helper() {}
+
+type Parser struct{}
+
+func (p *Parser) Next() {}
+
+func (p Parser) Done() bool { return true }
+`
+	t.Run("internal", func(t *testing.T) {
+		p := project(t, map[string]string{"go.mod": "module example.com/m\n", "parse/parse.go": src})
+		file, err := Generate(p, Test, map[string]string{"source": "parse/parse.go"})
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		want := `// Copyright 2026 Jane Doe
+// SPDX-License-Identifier: MIT
+
+package parse
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	t.Skip("not written yet")
+}
+
+func TestParser_Next(t *testing.T) {
+	t.Skip("not written yet")
+}
+
+func TestParser_Done(t *testing.T) {
+	t.Skip("not written yet")
+}
+`
+		if file.Path != "parse/parse_test.go" || file.Content != want {
+			t.Errorf("Got %s:\n%s\nwant:\n%s", file.Path, file.Content, want)
+		}
+	})
+
+	t.Run("external", func(t *testing.T) {
+		p := project(t, map[string]string{
+			"go.mod":               "module example.com/m\n",
+			"parse/parse.go":       src,
+			"parse/other_test.go":  "package parse_test\n",
+			"parse/helper_test.go": "package parse\n",
+		})
+		file, err := Generate(p, Test, map[string]string{"source": "parse/parse.go"})
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		for _, want := range []string{
+			"package parse_test\n\nimport (\n\t\"testing\"\n\n\t\"example.com/m/parse\"\n)\n",
+			"\t_ = parse.Parse\n",
+			"\t_ = (*parse.Parser).Next\n",
+			"\t_ = parse.Parser.Done\n",
+		} {
+			if !strings.Contains(file.Content, want) {
+				t.Errorf("Expected %q in:\n%s", want, file.Content)
+			}
+		}
+	})
+
+	t.Run("existing test", func(t *testing.T) {
+		p := project(t, map[string]string{"a_test.go": "package a\n"})
+		if _, err := Generate(p, Test, map[string]string{"source": "a_test.go"}); err == nil {
+			t.Error("Expected an error for a test source")
+		}
+	})
+}
+
+func TestPythonTest(t *testing.T) {
+	p := project(t, map[string]string{
+		"pyproject.toml":       "[tool.pytest.ini_options]\n",
+		"src/pkg/util.py":      "def parse_url(s):\n    pass\n\ndef _private():\n    pass\n\nclass HTTPClient:\n    def get(self):\n        pass\n",
+		"tests/test_other.py":  "",
+		"tests/conftest.py":    "",
+		"src/pkg/__init__.py":  "",
+		"docs/unrelated.md":    "",
+		"scripts/tool_test.py": "",
+	})
+	file, err := Generate(p, Test, map[string]string{"source": "src/pkg/util.py"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	want := `import pytest
+
+from pkg.util import parse_url, HTTPClient
+
+
+def test_parse_url():
+    pytest.skip("not written yet")
+
+
+def test_http_client():
+    pytest.skip("not written yet")
+`
+	if file.Path != "tests/test_util.py" || file.Content != want {
+		t.Errorf("Got %s:\n%s\nwant:\n%s", file.Path, file.Content, want)
+	}
+
+	// Without pytest, unittest beside the source
+	p = project(t, map[string]string{"app.py": "def main():\n    pass\n"})
+	file, err = Generate(p, Test, map[string]string{"source": "app.py"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if file.Path != "test_app.py" || !strings.Contains(file.Content, "class TestApp(unittest.TestCase):\n    @unittest.skip") {
+		t.Errorf("Got %s:\n%s", file.Path, file.Content)
+	}
+}
+
+func TestJSTest(t *testing.T) {
+	p := project(t, map[string]string{
+		"package.json":               `{"devDependencies": {"vitest": "^1.0.0"}}`,
+		"src/math.ts":                "export function add(a: number, b: number) { return a + b }\nexport const PI = 3.14\nconst hidden = 1\n",
+		"src/__tests__/str.spec.ts":  "",
+		"src/__tests__/list.spec.ts": "",
+		"src/__tests__/map.test.ts":  "",
+	})
+	file, err := Generate(p, Test, map[string]string{"source": "src/math.ts"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	want := `import { describe, it } from "vitest";
+import { add, PI } from "../math";
+
+describe("add", () => {
+  it.todo("works");
+});
+
+describe("PI", () => {
+  it.todo("works");
+});
+`
+	if file.Path != "src/__tests__/math.spec.ts" || file.Content != want {
+		t.Errorf("Got %s:\n%s\nwant:\n%s", file.Path, file.Content, want)
+	}
+}
+
+func TestWorkspaceTemplate(t *testing.T) {
+	p := project(t, map[string]string{
+		"LICENSE":                   mitLicense,
+		".crush/templates/cmd.tmpl": "{{header}}\npackage main\n\n// {{.Name}} runs {{.what}}.\nfunc main() {}\n",
+	})
+	if names := Names(p.Root); !slices.Equal(names, []string{"cmd", License, Test}) {
+		t.Errorf("Names = %v", names)
+	}
+
+	file, err := Generate(p, "cmd", map[string]string{"path": "cmd/tool/tool.go", "what": "the tool"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	want := "// Copyright 2026 Jane Doe\n// SPDX-License-Identifier: MIT\n\npackage main\n\n// tool runs the tool.\nfunc main() {}\n"
+	if file.Content != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", file.Content, want)
+	}
+
+	if _, err := Generate(p, "cmd", map[string]string{"path": "x.go"}); err == nil {
+		t.Error("Expected an error for a missing param")
+	}
+	if _, err := Generate(p, "nope", nil); err == nil || !strings.Contains(err.Error(), "cmd, license, test") {
+		t.Errorf("Expected the available templates in the error, got %v", err)
+	}
+	if _, err := Generate(p, "../LICENSE", nil); err == nil {
+		t.Error("Expected an error for a path as template name")
+	}
+}
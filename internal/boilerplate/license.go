@@ -0,0 +1,161 @@
+package boilerplate
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// licenseFiles are where a project's license is looked for, in order.
+var licenseFiles = []string{"LICENSE", "LICENSE.md", "LICENSE.txt", "COPYING", "COPYING.md"}
+
+// licenses recognize a license text by phrases it contains, all of which
+// must be present. More specific licenses come first.
+var licenses = []struct {
+	id      string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"MPL-2.0", []string{"Mozilla Public License", "2.0"}},
+	{"AGPL-3.0-or-later", []string{"GNU AFFERO GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-3.0-or-later", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-2.1-or-later", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 2.1"}},
+	{"GPL-3.0-or-later", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0-or-later", []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"ISC", []string{"Permission to use, copy, modify, and/or distribute"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+	{"Unlicense", []string{"This is free and unencumbered software"}},
+}
+
+// copyrightLine matches "Copyright (c) 2020-2024 Jane Doe" and captures the
+// holder.
+var copyrightLine = regexp.MustCompile(`(?mi)^[\s#*/-]*copyright\s+(?:\(c\)\s*|©\s*)?(?:\d{4}(?:\s*[-–,]\s*\d{4})*,?\s+)?(.+?)\s*$`)
+
+// detectLicense returns the copyright holder and SPDX identifier of the
+// project at root from its license file, "" for what it can't tell.
+func detectLicense(root string) (holder, license string) {
+	for _, name := range licenseFiles {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		text := string(data)
+		for _, l := range licenses {
+			if containsAll(text, l.phrases) {
+				license = l.id
+				break
+			}
+		}
+		for _, m := range copyrightLine.FindAllStringSubmatch(text, -1) {
+			// Skip placeholders such as Apache's "[name of copyright owner]"
+			h := strings.TrimSuffix(strings.TrimSpace(strings.TrimSuffix(m[1], "All rights reserved.")), ".")
+			if h != "" && !strings.ContainsAny(h, "[<{") && !strings.EqualFold(h, "notice") {
+				holder = h
+				break
+			}
+		}
+		return holder, license
+	}
+	return "", ""
+}
+
+func containsAll(text string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if !strings.Contains(text, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// licenseHeader returns the license header comment for the file at p:
+// a copyright line and an SPDX-License-Identifier line, from params or
+// the project's license file. It is "" if neither is known, and an error
+// if the file's language has no comments.
+func licenseHeader(proj Project, p string, params map[string]string) (string, error) {
+	holder, license := detectLicense(proj.Root)
+	if h := params["holder"]; h != "" {
+		holder = h
+	}
+	if l := params["license"]; l != "" {
+		license = l
+	}
+
+	var lines []string
+	if holder != "" {
+		lines = append(lines, "Copyright "+year(proj, params)+" "+holder)
+	}
+	if license != "" {
+		lines = append(lines, "SPDX-License-Identifier: "+license)
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return comment(p, lines)
+}
+
+// commentStyle is how a language writes a comment: every line starting
+// with line, or each wrapped in open and close.
+type commentStyle struct {
+	line        string
+	open, close string
+}
+
+// commentStyles by file extension.
+var commentStyles = map[string]commentStyle{}
+
+func init() {
+	for style, exts := range map[commentStyle][]string{
+		{line: "//"}:                 {".go", ".c", ".h", ".cc", ".cpp", ".hpp", ".cs", ".java", ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".rs", ".swift", ".kt", ".kts", ".scala", ".dart", ".php", ".zig", ".proto", ".groovy"},
+		{line: "#"}:                  {".py", ".sh", ".bash", ".zsh", ".fish", ".rb", ".pl", ".yaml", ".yml", ".toml", ".r", ".ex", ".exs", ".nix", ".tf", ".cmake", ".ps1"},
+		{line: "--"}:                 {".lua", ".sql", ".hs", ".elm"},
+		{line: ";;"}:                 {".el", ".clj", ".lisp", ".scm"},
+		{line: "%"}:                  {".erl", ".tex"},
+		{open: "/*", close: "*/"}:    {".css", ".scss", ".less"},
+		{open: "<!--", close: "-->"}: {".html", ".xml", ".svg", ".md", ".vue", ".svelte"},
+	} {
+		for _, ext := range exts {
+			commentStyles[ext] = style
+		}
+	}
+}
+
+// comment writes lines as a comment in the language of the file at p.
+func comment(p string, lines []string) (string, error) {
+	base := path.Base(p)
+	style, ok := commentStyles[strings.ToLower(path.Ext(base))]
+	switch {
+	case base == "Makefile" || base == "Dockerfile":
+		style = commentStyle{line: "#"}
+	case !ok:
+		return "", fmt.Errorf("don't know how to write comments in %s", base)
+	}
+
+	var b strings.Builder
+	for _, line := range lines {
+		if style.line != "" {
+			b.WriteString(style.line + " " + line + "\n")
+		} else {
+			b.WriteString(style.open + " " + line + " " + style.close + "\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// withHeader puts header at the top of body, after a shebang line, with a
+// blank line between them.
+func withHeader(header, body string) string {
+	if body == "" {
+		return header
+	}
+	if strings.HasPrefix(body, "#!") {
+		shebang, rest, _ := strings.Cut(body, "\n")
+		return shebang + "\n" + header + "\n" + rest
+	}
+	return header + "\n" + body
+}
@@ -0,0 +1,384 @@
+package boilerplate
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/taigrr/neocrush/internal/workspace"
+)
+
+// skipReason is what generated tests say until someone writes them.
+const skipReason = "not written yet"
+
+// generateTest scaffolds the test file of params["source"], with a test
+// for each exported function, following the conventions of the project's
+// existing tests. It keeps the source's license header.
+func generateTest(p Project, params map[string]string) (File, error) {
+	source := params["source"]
+	if source == "" {
+		return File{}, errors.New("source is required")
+	}
+	source = cleanPath(source)
+	if workspace.IsTest(source) {
+		return File{}, fmt.Errorf("%s is a test already", source)
+	}
+	src, err := os.ReadFile(filepath.Join(p.Root, filepath.FromSlash(source)))
+	if err != nil {
+		return File{}, err
+	}
+
+	var file File
+	switch ext := path.Ext(source); ext {
+	case ".go":
+		file, err = goTest(p, source, src)
+	case ".py":
+		file, err = pythonTest(p, source, src)
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx":
+		file = jsTest(p, source, src)
+	default:
+		return File{}, fmt.Errorf("no test conventions for %s files", ext)
+	}
+	if err != nil {
+		return File{}, err
+	}
+	if header := sourceHeader(string(src)); header != "" {
+		file.Content = withHeader(header, file.Content)
+	}
+	return file, nil
+}
+
+// goTest scaffolds source_test.go, in the external package pkg_test if the
+// directory's tests already use it.
+func goTest(p Project, source string, src []byte) (File, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), source, src, parser.SkipObjectResolution)
+	if f == nil || f.Name == nil {
+		return File{}, fmt.Errorf("failed to parse %s: %w", source, err)
+	}
+	pkg := f.Name.Name
+	dir := path.Dir(source)
+	file := File{Path: path.Join(dir, strings.TrimSuffix(path.Base(source), ".go")+"_test.go")}
+
+	var importPath string
+	if goExternalTests(p, dir, pkg) {
+		importPath = goImportPath(p.Root, dir)
+	}
+
+	// Each test's name, and in an external package an expression using
+	// what it tests, so the import is used
+	type test struct{ name, ref string }
+	var tests []test
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || !fn.Name.IsExported() {
+			continue
+		}
+		t := test{name: fn.Name.Name, ref: pkg + "." + fn.Name.Name}
+		if fn.Recv != nil {
+			recv, pointer, generic := receiverType(fn.Recv.List[0].Type)
+			if !ast.IsExported(recv) {
+				continue
+			}
+			t.name = recv + "_" + fn.Name.Name
+			switch {
+			case generic:
+				t.ref = ""
+			case pointer:
+				t.ref = "(*" + pkg + "." + recv + ")." + fn.Name.Name
+			default:
+				t.ref = pkg + "." + recv + "." + fn.Name.Name
+			}
+		}
+		if !slices.ContainsFunc(tests, func(o test) bool { return o.name == t.name }) {
+			tests = append(tests, t)
+		}
+	}
+	if len(tests) == 0 {
+		tests = []test{{name: camel(strings.TrimSuffix(path.Base(source), ".go"))}}
+	}
+	external := importPath != "" && slices.ContainsFunc(tests, func(t test) bool { return t.ref != "" })
+
+	var b strings.Builder
+	if external {
+		spec := strconv.Quote(importPath)
+		if path.Base(importPath) != pkg {
+			spec = pkg + " " + spec
+		}
+		fmt.Fprintf(&b, "package %s_test\n\nimport (\n\t\"testing\"\n\n\t%s\n)\n", pkg, spec)
+	} else {
+		fmt.Fprintf(&b, "package %s\n\nimport \"testing\"\n", pkg)
+	}
+	for _, t := range tests {
+		fmt.Fprintf(&b, "\nfunc Test%s(t *testing.T) {\n", t.name)
+		if external && t.ref != "" {
+			fmt.Fprintf(&b, "\t_ = %s\n", t.ref)
+		}
+		fmt.Fprintf(&b, "\tt.Skip(%q)\n}\n", skipReason)
+	}
+	file.Content = b.String()
+	return file, nil
+}
+
+// receiverType returns the name of a method's receiver type, and whether
+// it is a pointer or generic.
+func receiverType(expr ast.Expr) (name string, pointer, generic bool) {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr, pointer = star.X, true
+	}
+	switch x := expr.(type) {
+	case *ast.IndexExpr:
+		expr, generic = x.X, true
+	case *ast.IndexListExpr:
+		expr, generic = x.X, true
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		name = ident.Name
+	}
+	return name, pointer, generic
+}
+
+// goExternalTests reports whether the tests in dir are in the external
+// test package of pkg.
+func goExternalTests(p Project, dir, pkg string) bool {
+	for _, f := range p.Files {
+		if path.Dir(f) != dir || !strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(token.NewFileSet(), f, readFile(p.Root, f), parser.PackageClauseOnly)
+		if err == nil && parsed.Name.Name == pkg+"_test" {
+			return true
+		}
+	}
+	return false
+}
+
+// goModule matches the module line of a go.mod file.
+var goModule = regexp.MustCompile(`(?m)^module\s+"?([^\s"]+)"?`)
+
+// goImportPath returns the import path of the package in dir, from the
+// closest go.mod above it, or "" if there is none.
+func goImportPath(root, dir string) string {
+	for mod := dir; ; mod = path.Dir(mod) {
+		if m := goModule.FindSubmatch(readFile(root, path.Join(mod, "go.mod"))); m != nil {
+			rel := strings.TrimPrefix(strings.TrimPrefix(dir, mod), "/")
+			if mod == "." {
+				rel = strings.TrimPrefix(dir, ".")
+			}
+			return strings.TrimSuffix(string(m[1])+"/"+rel, "/")
+		}
+		if mod == "." || mod == "/" {
+			return ""
+		}
+	}
+}
+
+var (
+	pythonDef   = regexp.MustCompile(`(?m)^(?:async\s+)?def\s+([A-Za-z]\w*)\s*\(`)
+	pythonClass = regexp.MustCompile(`(?m)^class\s+([A-Za-z]\w*)`)
+)
+
+// pythonTest scaffolds test_source.py, in the top-level tests directory if
+// the project keeps its tests there, for pytest or else unittest.
+func pythonTest(p Project, source string, src []byte) (File, error) {
+	stem := strings.TrimSuffix(path.Base(source), ".py")
+	file := File{Path: path.Join(path.Dir(source), "test_"+stem+".py")}
+	if slices.ContainsFunc(p.Files, func(f string) bool {
+		return strings.HasPrefix(f, "tests/") && strings.HasSuffix(f, ".py")
+	}) {
+		file.Path = "tests/test_" + stem + ".py"
+	}
+
+	module := strings.TrimPrefix(strings.TrimSuffix(source, ".py"), "src/")
+	if stem == "__init__" {
+		module = path.Dir(module)
+	}
+	if module == "." {
+		return File{}, fmt.Errorf("can't import %s", source)
+	}
+	module = strings.ReplaceAll(module, "/", ".")
+
+	var names, tests []string
+	for _, re := range []*regexp.Regexp{pythonDef, pythonClass} {
+		for _, m := range re.FindAllSubmatch(src, -1) {
+			name := string(m[1])
+			if test := "test_" + snake(name); !slices.Contains(tests, test) {
+				names = append(names, name)
+				tests = append(tests, test)
+			}
+		}
+	}
+	if len(tests) == 0 {
+		tests = []string{"test_" + snake(stem)}
+	}
+	var from string
+	if len(names) > 0 {
+		from = fmt.Sprintf("\nfrom %s import %s\n", module, strings.Join(names, ", "))
+	}
+
+	var b strings.Builder
+	if usesPytest(p) {
+		b.WriteString("import pytest\n" + from)
+		for _, test := range tests {
+			fmt.Fprintf(&b, "\n\ndef %s():\n    pytest.skip(%q)\n", test, skipReason)
+		}
+	} else {
+		fmt.Fprintf(&b, "import unittest\n%s\n\nclass Test%s(unittest.TestCase):\n", from, camel(stem))
+		for i, test := range tests {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "    @unittest.skip(%q)\n    def %s(self):\n        pass\n", skipReason, test)
+		}
+		b.WriteString("\n\nif __name__ == \"__main__\":\n    unittest.main()\n")
+	}
+	file.Content = b.String()
+	return file, nil
+}
+
+// usesPytest reports whether the project runs its tests with pytest.
+func usesPytest(p Project) bool {
+	for _, f := range p.Files {
+		switch base := path.Base(f); {
+		case base == "conftest.py", f == "pytest.ini":
+			return true
+		case f == "pyproject.toml", f == "setup.cfg", f == "tox.ini", strings.HasPrefix(f, "requirements") && strings.HasSuffix(f, ".txt"):
+			if strings.Contains(string(readFile(p.Root, f)), "pytest") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsExport matches a named export of a JavaScript or TypeScript module.
+var jsExport = regexp.MustCompile(`(?m)^export\s+(?:async\s+)?(?:function\*?|class|const|let|var)\s+([A-Za-z_$][\w$]*)`)
+
+// jsTest scaffolds source.test.ts (or .spec., as the project's tests are
+// named), beside the source or in __tests__ if the project uses those, for
+// Vitest if it is a dependency, or else Jest.
+func jsTest(p Project, source string, src []byte) File {
+	ext := path.Ext(source)
+	stem := strings.TrimSuffix(path.Base(source), ext)
+	dir := path.Dir(source)
+
+	var specs, tests int
+	testDirs := false
+	for _, f := range p.Files {
+		base := path.Base(f)
+		if strings.Contains(base, ".spec.") {
+			specs++
+		} else if strings.Contains(base, ".test.") {
+			tests++
+		}
+		testDirs = testDirs || strings.HasPrefix(f, "__tests__/") || strings.Contains(f, "/__tests__/")
+	}
+	kind := ".test"
+	if specs > tests {
+		kind = ".spec"
+	}
+	file, from := File{Path: path.Join(dir, stem+kind+ext)}, "./"+stem
+	if testDirs {
+		file.Path, from = path.Join(dir, "__tests__", stem+kind+ext), "../"+stem
+	}
+
+	var names []string
+	for _, m := range jsExport.FindAllSubmatch(src, -1) {
+		if name := string(m[1]); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	var b strings.Builder
+	if strings.Contains(string(readFile(p.Root, "package.json")), `"vitest"`) {
+		b.WriteString("import { describe, it } from \"vitest\";\n")
+	}
+	if len(names) > 0 {
+		fmt.Fprintf(&b, "import { %s } from %q;\n", strings.Join(names, ", "), from)
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	if len(names) == 0 {
+		names = []string{stem}
+	}
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "describe(%q, () => {\n  it.todo(\"works\");\n});\n", name)
+	}
+	file.Content = b.String()
+	return file
+}
+
+// sourceHeader returns the comment at the top of src, past a shebang, if
+// it is a license header: one mentioning a copyright or SPDX identifier.
+func sourceHeader(src string) string {
+	var b strings.Builder
+	block := false
+	for i, line := range strings.SplitAfter(src, "\n") {
+		t := strings.TrimSpace(line)
+		switch {
+		case i == 0 && strings.HasPrefix(t, "#!"):
+			continue
+		case block:
+			block = !strings.Contains(t, "*/")
+		case strings.HasPrefix(t, "/*"):
+			block = !strings.Contains(t, "*/")
+		case strings.HasPrefix(t, "//"), strings.HasPrefix(t, "#"), strings.HasPrefix(t, "--"):
+		default:
+			header := b.String()
+			if strings.Contains(header, "Copyright") || strings.Contains(header, "SPDX-License-Identifier") {
+				return header
+			}
+			return ""
+		}
+		b.WriteString(line)
+	}
+	return ""
+}
+
+// readFile returns the content of the file at p under root, nil if it
+// can't be read.
+func readFile(root, p string) []byte {
+	data, _ := os.ReadFile(filepath.Join(root, filepath.FromSlash(p)))
+	return data
+}
+
+// camel turns a file name such as file_list into FileList.
+func camel(s string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+// snake turns a name such as parseURL or FileList into parse_url or
+// file_list.
+func snake(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// A word starts at an upper case letter after a lower case one,
+			// or before one ending an acronym
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
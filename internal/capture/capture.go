@@ -0,0 +1,96 @@
+// Package capture records the messages the daemon routes, one JSON record
+// per line, for later inspection with `neocrush inspect`.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DirName is the capture directory inside the workspace's .crush folder.
+const DirName = "captures"
+
+// Directions a message travels, seen from the daemon.
+const (
+	Recv = "recv" // From a client to the daemon
+	Send = "send" // From the daemon to a client
+)
+
+// Record is one routed message.
+type Record struct {
+	Time    time.Time       `json:"time"`
+	Dir     string          `json:"dir"`              // Recv or Send
+	Client  string          `json:"client,omitempty"` // Empty before the client identified itself
+	Conn    int             `json:"conn,omitempty"`   // Connection, numbered from 1 in the order clients connected
+	Message json.RawMessage `json:"message"`          // JSON-RPC content, secrets redacted
+}
+
+// Dir returns the capture directory of a workspace.
+func Dir(workspaceRoot string) string {
+	return filepath.Join(workspaceRoot, ".crush", DirName)
+}
+
+// Path returns the capture file of session in dir.
+func Path(dir, session string) string {
+	return filepath.Join(dir, session+".jsonl")
+}
+
+// Writer appends records to a capture file.
+type Writer struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Create opens path for appending, creating it (and its directory) if
+// needed. Captures contain file contents, so they are private to the user.
+func Create(path string) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create capture dir: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture: %w", err)
+	}
+	return &Writer{file: file}, nil
+}
+
+// Append writes one record.
+func (w *Writer) Append(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the capture file.
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
+
+// Read calls fn for each record in r, in order. Malformed lines (e.g. one
+// cut short when the daemon was killed) are skipped.
+func Read(r io.Reader, fn func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
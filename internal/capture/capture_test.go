@@ -0,0 +1,54 @@
+package capture
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteRead(t *testing.T) {
+	path := Path(t.TempDir()+"/captures", "s1")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: now, Dir: Recv, Client: "neovim", Message: json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`)},
+		{Time: now, Dir: Send, Client: "neovim", Message: json.RawMessage(`{"jsonrpc":"2.0","id":1,"result":null}`)},
+	}
+	for _, r := range records {
+		if err := w.Append(r); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A line cut short by a crash is skipped
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"time":"2026-01-01T12:00:00Z","dir":"re`)
+	f.Close()
+
+	data, _ := os.ReadFile(path)
+	var got []Record
+	err = Read(strings.NewReader(string(data)), func(r Record) error {
+		got = append(got, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(got) != 2 || got[0].Dir != Recv || got[1].Dir != Send || string(got[1].Message) != string(records[1].Message) {
+		t.Errorf("Unexpected records: %+v", got)
+	}
+
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected capture to be private, got %v", info.Mode().Perm())
+	}
+}
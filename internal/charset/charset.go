@@ -0,0 +1,125 @@
+// Package charset detects the encoding of text files read from disk and
+// converts between it and the UTF-8 the daemon works in, so files that
+// aren't UTF-8 are edited without being corrupted.
+package charset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding names a file's encoding.
+type Encoding string
+
+// Encodings Decode detects.
+const (
+	UTF8    Encoding = "utf-8"
+	UTF8BOM Encoding = "utf-8-bom" // UTF-8 with a byte order mark
+	UTF16LE Encoding = "utf-16le"  // With a byte order mark
+	UTF16BE Encoding = "utf-16be"  // With a byte order mark
+	Latin1  Encoding = "latin-1"   // ISO 8859-1: anything that isn't valid UTF-8
+)
+
+// SniffLen is how much of a file Binary checks for NUL bytes.
+const SniffLen = 8000
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// Detect returns the encoding of data: from its byte order mark, else
+// UTF-8 if it is valid UTF-8, else Latin-1.
+func Detect(data []byte) Encoding {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return UTF8BOM
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return UTF16LE
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return UTF16BE
+	case utf8.Valid(data):
+		return UTF8
+	}
+	return Latin1
+}
+
+// Binary reports whether data looks like a binary file: it has a NUL byte
+// in its first SniffLen bytes and isn't UTF-16, where NULs are common.
+func Binary(data []byte) bool {
+	if bytes.HasPrefix(data, bomUTF16LE) || bytes.HasPrefix(data, bomUTF16BE) {
+		return false
+	}
+	return bytes.IndexByte(data[:min(len(data), SniffLen)], 0) >= 0
+}
+
+// Decode returns data as UTF-8 text, without a byte order mark, and the
+// encoding it was in.
+func Decode(data []byte) (string, Encoding) {
+	enc := Detect(data)
+	switch enc {
+	case UTF8BOM:
+		return string(data[len(bomUTF8):]), enc
+	case UTF16LE, UTF16BE:
+		return decodeUTF16(data[2:], enc == UTF16BE), enc
+	case Latin1:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), enc
+	}
+	return string(data), enc
+}
+
+// decodeUTF16 decodes UTF-16 code units, replacing a dangling odd byte and
+// unpaired surrogates with U+FFFD.
+func decodeUTF16(data []byte, bigEndian bool) string {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, order.Uint16(data[i:]))
+	}
+	text := string(utf16.Decode(units))
+	if len(data)%2 == 1 {
+		text += string(utf8.RuneError)
+	}
+	return text
+}
+
+// Encode converts UTF-8 text to enc, adding the byte order mark enc
+// implies. It fails if enc can't represent a character of text.
+func Encode(text string, enc Encoding) ([]byte, error) {
+	switch enc {
+	case UTF8BOM:
+		return append(bytes.Clone(bomUTF8), text...), nil
+	case UTF16LE, UTF16BE:
+		var order binary.AppendByteOrder = binary.LittleEndian
+		bom := bomUTF16LE
+		if enc == UTF16BE {
+			order, bom = binary.BigEndian, bomUTF16BE
+		}
+		out := bytes.Clone(bom)
+		for _, unit := range utf16.Encode([]rune(text)) {
+			out = order.AppendUint16(out, unit)
+		}
+		return out, nil
+	case Latin1:
+		out := make([]byte, 0, len(text))
+		for i, r := range text {
+			if r > 0xff {
+				return nil, fmt.Errorf("%q at byte %d can't be written in %s", r, i, enc)
+			}
+			out = append(out, byte(r))
+		}
+		return out, nil
+	}
+	return []byte(text), nil
+}
@@ -0,0 +1,54 @@
+package charset
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		text string
+		enc  Encoding
+	}{
+		{"utf-8", []byte("héllo\n"), "héllo\n", UTF8},
+		{"bom", []byte("\xef\xbb\xbfhi\n"), "hi\n", UTF8BOM},
+		{"latin-1", []byte("caf\xe9\r\n"), "café\r\n", Latin1},
+		{"utf-16le", []byte("\xff\xfeh\x00\xe9\x00\n\x00"), "hé\n", UTF16LE},
+		{"utf-16be", []byte("\xfe\xff\x00h\xd8\x3d\xde\x00"), "h😀", UTF16BE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, enc := Decode(tt.data)
+			if text != tt.text || enc != tt.enc {
+				t.Fatalf("Decode = %q, %s; want %q, %s", text, enc, tt.text, tt.enc)
+			}
+			data, err := Encode(text, enc)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if !bytes.Equal(data, tt.data) {
+				t.Errorf("Encode = %q, want %q", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestEncodeLatin1Unrepresentable(t *testing.T) {
+	if _, err := Encode("price: €5", Latin1); err == nil {
+		t.Error("Expected an error for a character outside Latin-1")
+	}
+}
+
+func TestBinary(t *testing.T) {
+	if !Binary([]byte("PK\x03\x04\x00\x00")) {
+		t.Error("Expected NUL bytes to mean binary")
+	}
+	if Binary([]byte("\xff\xfea\x00b\x00")) {
+		t.Error("Expected UTF-16 not to be binary")
+	}
+	if Binary([]byte("caf\xe9")) {
+		t.Error("Expected Latin-1 text not to be binary")
+	}
+}
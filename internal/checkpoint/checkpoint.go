@@ -0,0 +1,231 @@
+// Package checkpoint saves named snapshots of workspace files under
+// .crush/checkpoints so they can be restored after an agent edit goes
+// wrong.
+//
+// Each checkpoint is a directory holding a manifest.json and a copy of
+// every file under files/, at its workspace-relative path.
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DirName is the checkpoint directory inside the workspace's .crush folder.
+const DirName = "checkpoints"
+
+const (
+	manifestName = "manifest.json"
+	filesDir     = "files"
+)
+
+// ErrNotFound means no checkpoint has the requested ID or name.
+var ErrNotFound = errors.New("checkpoint not found")
+
+// Checkpoint describes a snapshot.
+type Checkpoint struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Files   []File    `json:"files"`
+}
+
+// File is one snapshotted file.
+type File struct {
+	Path   string `json:"path"`   // Slash-separated, relative to the workspace root
+	Exists bool   `json:"exists"` // False if the file did not exist; restoring deletes it
+	Size   int    `json:"size"`
+}
+
+// Dir returns the checkpoint directory of a workspace.
+func Dir(workspaceRoot string) string {
+	return filepath.Join(workspaceRoot, ".crush", DirName)
+}
+
+// ReadFunc returns the current content of an absolute path. It returns an
+// error matching fs.ErrNotExist for missing files.
+type ReadFunc func(path string) ([]byte, error)
+
+// Create snapshots paths (relative to root, or absolute inside it) as a
+// checkpoint called name. read supplies file contents; nil reads from disk.
+func Create(root, name string, paths []string, read ReadFunc) (*Checkpoint, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no files to snapshot")
+	}
+	if read == nil {
+		read = os.ReadFile
+	}
+
+	created := time.Now()
+	cp := &Checkpoint{Name: name, Created: created}
+	dir, err := makeDir(root, created)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	cp.ID = filepath.Base(dir)
+
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		if filepath.IsAbs(p) {
+			if r, err := filepath.Rel(root, p); err == nil {
+				p = r
+			}
+		}
+		rel := filepath.ToSlash(filepath.Clean(p))
+		if rel == ".." || strings.HasPrefix(rel, "../") || filepath.IsAbs(rel) {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("%s is outside the workspace", p)
+		}
+		if seen[rel] {
+			continue
+		}
+		seen[rel] = true
+
+		data, err := read(filepath.Join(root, filepath.FromSlash(rel)))
+		if errors.Is(err, fs.ErrNotExist) {
+			cp.Files = append(cp.Files, File{Path: rel})
+			continue
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("failed to read %s: %w", rel, err)
+		}
+
+		dest := filepath.Join(dir, filesDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		if err := os.WriteFile(dest, data, 0o600); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		cp.Files = append(cp.Files, File{Path: rel, Exists: true, Size: len(data)})
+	}
+
+	manifest, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestName), manifest, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return cp, nil
+}
+
+// makeDir creates the directory of a checkpoint taken at t, named after the
+// time with a numeric suffix if that is taken.
+func makeDir(root string, t time.Time) (string, error) {
+	if err := os.MkdirAll(Dir(root), 0o700); err != nil {
+		return "", err
+	}
+	base := filepath.Join(Dir(root), t.Format("20060102-150405"))
+	dir := base
+	for i := 2; ; i++ {
+		err := os.Mkdir(dir, 0o700)
+		if err == nil {
+			return dir, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		dir = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// List returns the checkpoints of a workspace, oldest first.
+func List(root string) ([]Checkpoint, error) {
+	entries, err := os.ReadDir(Dir(root))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoints []Checkpoint
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(Dir(root), entry.Name(), manifestName))
+		if err != nil {
+			continue // Incomplete checkpoint
+		}
+		var cp Checkpoint
+		if json.Unmarshal(data, &cp) == nil {
+			checkpoints = append(checkpoints, cp)
+		}
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Created.Before(checkpoints[j].Created)
+	})
+	return checkpoints, nil
+}
+
+// Find returns the checkpoint with the given ID, or the newest one with
+// the given name.
+func Find(root, idOrName string) (*Checkpoint, error) {
+	checkpoints, err := List(root)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		if checkpoints[i].ID == idOrName || checkpoints[i].Name == idOrName {
+			return &checkpoints[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, idOrName)
+}
+
+// Content returns the snapshotted content of f, or nil if it did not exist.
+func (cp *Checkpoint) Content(root string, f File) ([]byte, error) {
+	if !f.Exists {
+		return nil, nil
+	}
+	if strings.HasPrefix(f.Path, "../") || f.Path == ".." || filepath.IsAbs(f.Path) {
+		return nil, fmt.Errorf("invalid path in checkpoint: %s", f.Path)
+	}
+	return os.ReadFile(filepath.Join(Dir(root), cp.ID, filesDir, filepath.FromSlash(f.Path)))
+}
+
+// Restore writes the snapshot back to disk, deleting files that did not
+// exist when it was taken, and returns the restored paths.
+func Restore(root string, cp *Checkpoint) ([]string, error) {
+	var restored []string
+	for _, f := range cp.Files {
+		data, err := cp.Content(root, f)
+		if err != nil {
+			return restored, err
+		}
+		path := filepath.Join(root, filepath.FromSlash(f.Path))
+		if !f.Exists {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return restored, err
+			}
+			restored = append(restored, f.Path)
+			continue
+		}
+
+		mode := fs.FileMode(0o644)
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return restored, err
+		}
+		if err := os.WriteFile(path, data, mode); err != nil {
+			return restored, err
+		}
+		restored = append(restored, f.Path)
+	}
+	return restored, nil
+}
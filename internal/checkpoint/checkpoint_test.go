@@ -0,0 +1,74 @@
+package checkpoint_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/checkpoint"
+)
+
+func TestCreateAndRestore(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "pkg"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "pkg", "a.go"), []byte("package a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := checkpoint.Create(root, "before refactor", []string{"pkg/a.go", "pkg/new.go", "pkg/a.go"}, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if len(cp.Files) != 2 || !cp.Files[0].Exists || cp.Files[1].Exists {
+		t.Fatalf("Unexpected files: %+v", cp.Files)
+	}
+
+	// The agent rewrites one file and creates another
+	os.WriteFile(filepath.Join(root, "pkg", "a.go"), []byte("package broken\n"), 0o644)
+	os.WriteFile(filepath.Join(root, "pkg", "new.go"), []byte("package a\n"), 0o644)
+
+	found, err := checkpoint.Find(root, "before refactor")
+	if err != nil || found.ID != cp.ID {
+		t.Fatalf("Find by name failed: %v %+v", err, found)
+	}
+
+	restored, err := checkpoint.Restore(root, found)
+	if err != nil || len(restored) != 2 {
+		t.Fatalf("Restore failed: %v %v", err, restored)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "pkg", "a.go")); string(data) != "package a\n" {
+		t.Errorf("Expected a.go restored, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "pkg", "new.go")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected new.go removed, got %v", err)
+	}
+}
+
+func TestListAndFind(t *testing.T) {
+	root := t.TempDir()
+	read := func(string) ([]byte, error) { return []byte("x"), nil }
+
+	first, _ := checkpoint.Create(root, "same", []string{"a"}, read)
+	second, err := checkpoint.Create(root, "same", []string{"b"}, read)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	list, err := checkpoint.List(root)
+	if err != nil || len(list) != 2 || list[0].ID != first.ID {
+		t.Fatalf("Expected 2 checkpoints oldest first, got %v %+v", err, list)
+	}
+
+	if found, _ := checkpoint.Find(root, "same"); found == nil || found.ID != second.ID {
+		t.Errorf("Expected the newest checkpoint with the name, got %+v", found)
+	}
+	if found, _ := checkpoint.Find(root, first.ID); found == nil || found.ID != first.ID {
+		t.Errorf("Expected lookup by ID, got %+v", found)
+	}
+	if _, err := checkpoint.Find(root, "missing"); !errors.Is(err, checkpoint.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
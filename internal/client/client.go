@@ -0,0 +1,382 @@
+// Package client provides the primitives used to talk to a neocrush daemon:
+// locating or spawning it, request/notification round-trips, and bridging
+// a stdio LSP stream onto the daemon socket.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/mux"
+	"github.com/taigrr/neocrush/internal/sandbox"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/internal/transport"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+const (
+	// DefaultTimeout bounds a single request round-trip.
+	DefaultTimeout = 5 * time.Second
+	// dialTimeout bounds connecting to an existing daemon socket.
+	dialTimeout = 2 * time.Second
+	// probeTimeout bounds connecting to the daemon of an existing session
+	// before it is considered dead.
+	probeTimeout = 500 * time.Millisecond
+)
+
+// ResponseError is a JSON-RPC error returned by the daemon.
+type ResponseError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("daemon error: %s", e.Message)
+}
+
+// Client is a connection to a neocrush daemon.
+type Client struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+	logger  *log.Logger
+
+	// Session is the session the client connected to (nil for raw connections).
+	Session *session.Session
+
+	writeMu   sync.Mutex
+	readMu    sync.Mutex
+	requestID atomic.Int64
+}
+
+// New wraps an existing daemon connection.
+func New(conn net.Conn, logger *log.Logger) *Client {
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(rpc.Split)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	return &Client{
+		conn:    conn,
+		scanner: scanner,
+		logger:  logger,
+	}
+}
+
+// Dial connects to a daemon listening on socketPath.
+func Dial(socketPath string, logger *log.Logger) (*Client, error) {
+	conn, err := transport.Dial(socketPath, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+	return New(conn, logger), nil
+}
+
+// DialChannels connects to a daemon listening on socketPath and starts a
+// multiplexed session, whose channels each serve as a connection of their
+// own (wrap them with New).
+func DialChannels(socketPath string) (*mux.Session, error) {
+	conn, err := transport.Dial(socketPath, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+	s, err := mux.Client(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Connect returns a client for the daemon serving workspace, starting a
+// new daemon if no live session exists.
+func Connect(logger *log.Logger, workspace string) (*Client, error) {
+	mgr := session.NewManager()
+
+	// Try to load existing session (don't check socket - we'll verify by connecting)
+	sess, err := mgr.LoadSessionMetadata(workspace)
+	if err == nil && sess.IsLegacy() {
+		// Sessions left behind by crush-lsp point at a daemon we no longer
+		// speak for; replace them with a neocrush session.
+		logger.Printf("Migrating legacy crush-lsp session %s", sess.ID)
+	} else if err == nil {
+		c, err := reuse(sess, logger)
+		if err == nil {
+			logger.Printf("Connected to existing session %s", sess.ID)
+			return c, nil
+		}
+		logger.Printf("Removing dead session %s: %v", sess.ID, err)
+		if err := mgr.RemoveSession(sess.ID); err != nil {
+			logger.Printf("Warning: failed to clean up session %s: %v", sess.ID, err)
+		}
+	} else if entry, ok := mgr.LookupWorkspace(workspace); ok && entry.Folder != "" {
+		// A workspace folder of a multi-root session joins that session
+		if sess, err := mgr.LoadSessionMetadata(entry.WorkspaceRoot); err == nil && !sess.IsLegacy() {
+			if c, err := reuse(sess, logger); err == nil {
+				logger.Printf("Connected to session %s of %s", sess.ID, sess.WorkspaceRoot)
+				return c, nil
+			}
+		}
+	}
+
+	// No session or daemon dead - start new daemon
+	sess, err = Spawn(logger, workspace, mgr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start daemon: %w", err)
+	}
+
+	conn, err := transport.Dial(sess.SocketPath, DefaultTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+
+	logger.Printf("Connected to session %s", sess.ID)
+	c := New(conn, logger)
+	c.Session = sess
+	return c, nil
+}
+
+// reuse connects to the daemon of an existing session. It fails without
+// dialing if the recorded daemon process has exited, and otherwise if the
+// socket does not accept a connection within probeTimeout.
+func reuse(sess *session.Session, logger *log.Logger) (*Client, error) {
+	if sess.DaemonDead() {
+		return nil, fmt.Errorf("daemon (pid %d) is not running", sess.Daemon.PID)
+	}
+	conn, err := transport.Dial(sess.SocketPath, probeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("daemon unreachable: %w", err)
+	}
+	c := New(conn, logger)
+	c.Session = sess
+	return c, nil
+}
+
+// Strict makes the daemons Spawn starts validate crush/* messages against
+// their schemas (neocrush --strict).
+var Strict bool
+
+// Spawn creates a session for workspace and starts a detached daemon
+// process (the current executable with --daemon) to serve it.
+func Spawn(logger *log.Logger, workspace string, mgr *session.Manager) (*session.Session, error) {
+	// Create session first to get socket path
+	sess, err := mgr.CreateSession(workspace, os.Getppid())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	cfg, err := config.Load(workspace)
+	if err != nil {
+		logger.Printf("Warning: %v, using defaults", err)
+		cfg = config.Default()
+	}
+
+	args := []string{"--daemon", "--log", filepath.Join(mgr.SocketDir(), "daemon.log")}
+	if Strict {
+		args = append(args, "--strict")
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = workspace
+	if cfg.Daemon.Dir != "" {
+		cmd.Dir = cfg.Daemon.Dir
+	}
+	// The daemon only sees allowlisted variables, so secrets in the editor's
+	// environment don't leak into it (or anything it launches).
+	cmd.Env = append(sandbox.Env(os.Environ(), cfg.Daemon.EnvAllowlist()),
+		"CRUSH_SESSION_ID="+sess.ID,
+		"CRUSH_WORKSPACE_ROOT="+workspace)
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start daemon: %w", err)
+	}
+	if err := sandbox.Prioritize(cmd.Process.Pid, cfg.Daemon); err != nil {
+		logger.Printf("Warning: failed to set daemon priority: %v", err)
+	}
+
+	// Detach from parent
+	if err := cmd.Process.Release(); err != nil {
+		logger.Printf("Warning: failed to release daemon process: %v", err)
+	}
+
+	// Wait for socket to be ready
+	for i := 0; i < 50; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if transport.Exists(sess.SocketPath) {
+			return sess, nil
+		}
+	}
+
+	return nil, fmt.Errorf("daemon did not create socket within timeout")
+}
+
+// Conn returns the underlying daemon connection.
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
+
+// Close closes the daemon connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Notify sends a JSON-RPC notification to the daemon.
+func (c *Client) Notify(method string, params any) error {
+	return c.write(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	})
+}
+
+// Request sends a JSON-RPC request and waits for the matching response,
+// returning its raw result. Unrelated messages read while waiting are dropped.
+func (c *Client) Request(method string, params any) (json.RawMessage, error) {
+	return c.RequestWithTrace("", method, params)
+}
+
+// RequestWithTrace is Request carrying a W3C traceparent, so the daemon's
+// span for the request joins the caller's trace.
+func (c *Client) RequestWithTrace(traceparent, method string, params any) (json.RawMessage, error) {
+	if params == nil {
+		params = map[string]any{}
+	}
+
+	id := c.requestID.Add(1)
+	msg := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	}
+	if traceparent != "" {
+		msg["traceparent"] = traceparent
+	}
+	if err := c.write(msg); err != nil {
+		return nil, err
+	}
+
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if err := c.conn.SetReadDeadline(time.Now().Add(DefaultTimeout)); err != nil {
+		return nil, err
+	}
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	for c.scanner.Scan() {
+		_, content, err := rpc.DecodeMessage(c.scanner.Bytes())
+		if err != nil {
+			continue
+		}
+
+		var resp struct {
+			ID     *int64          `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *ResponseError  `json:"error"`
+		}
+		if err := json.Unmarshal(content, &resp); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		if resp.ID == nil || *resp.ID != id {
+			continue
+		}
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Result, nil
+	}
+
+	if err := c.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Next reads the next message from the daemon, returning its method ("" for
+// responses) and JSON content. It returns io.EOF when the daemon hangs up.
+func (c *Client) Next() (string, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return "", nil, err
+		}
+		return "", nil, io.EOF
+	}
+	method, content, err := rpc.DecodeMessage(c.scanner.Bytes())
+	return method, bytes.Clone(content), err
+}
+
+// write encodes msg with LSP framing and sends it to the daemon.
+func (c *Client) write(msg any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(DefaultTimeout)); err != nil {
+		return err
+	}
+	defer c.conn.SetWriteDeadline(time.Time{})
+
+	_, err := c.conn.Write([]byte(rpc.EncodeMessage(msg)))
+	return err
+}
+
+// Bridge copies LSP messages between stdin/stdout and the daemon connection
+// until either side closes.
+func (c *Client) Bridge(stdin io.Reader, stdout io.Writer) error {
+	return Bridge(stdin, stdout, c.conn)
+}
+
+// Bridge copies LSP messages between stdin/stdout and conn until either
+// side closes, returning the first error (nil on clean EOF).
+func Bridge(stdin io.Reader, stdout io.Writer, conn net.Conn) error {
+	errChan := make(chan error, 2)
+
+	// stdin -> socket
+	go func() {
+		errChan <- copyMessages(conn, stdin)
+	}()
+
+	// socket -> stdout
+	go func() {
+		errChan <- copyMessages(stdout, conn)
+	}()
+
+	err := <-errChan
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// copyMessages forwards complete LSP frames from src to dst.
+func copyMessages(dst io.Writer, src io.Reader) error {
+	scanner := bufio.NewScanner(src)
+	scanner.Split(rpc.Split)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	for scanner.Scan() {
+		if _, err := dst.Write(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,391 @@
+package client_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/client"
+	"github.com/taigrr/neocrush/internal/session"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+func TestBridge(t *testing.T) {
+	// Create pipes for testing
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+
+	// Create a mock socket connection using pipes
+	clientConn, serverConn := net.Pipe()
+
+	// Start bridge in background
+	done := make(chan struct{})
+	go func() {
+		client.Bridge(stdinReader, stdoutWriter, clientConn)
+		close(done)
+	}()
+
+	// Test stdin -> socket
+	testMsg := rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "test",
+	})
+
+	go func() {
+		stdinWriter.Write([]byte(testMsg))
+	}()
+
+	// Read from server side of socket
+	serverConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, len(testMsg))
+	n, err := io.ReadFull(serverConn, buf)
+	if err != nil {
+		t.Errorf("Failed to read from server: %v", err)
+	}
+	if n != len(testMsg) {
+		t.Errorf("Expected %d bytes, got %d", len(testMsg), n)
+	}
+
+	// Test socket -> stdout
+	responseMsg := rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"result":  "ok",
+	})
+
+	go func() {
+		serverConn.Write([]byte(responseMsg))
+	}()
+
+	// Read from stdout
+	buf = make([]byte, len(responseMsg))
+	n, err = io.ReadFull(stdoutReader, buf)
+	if err != nil {
+		t.Errorf("Failed to read from stdout: %v", err)
+	}
+	if n != len(responseMsg) {
+		t.Errorf("Expected %d bytes, got %d", len(responseMsg), n)
+	}
+
+	// Cleanup
+	stdinWriter.Close()
+	serverConn.Close()
+	<-done
+}
+
+func TestBridgeResuming(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	first, firstServer := net.Pipe()
+	second, secondServer := net.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		redial := func() (*client.Client, error) { return client.New(second, logger), nil }
+		done <- client.New(first, logger).BridgeResuming(stdinReader, stdoutWriter, redial)
+	}()
+
+	send := func(msg map[string]any) {
+		t.Helper()
+		if _, err := stdinWriter.Write([]byte(rpc.EncodeMessage(msg))); err != nil {
+			t.Errorf("Failed to write stdin: %v", err)
+		}
+	}
+	reader := func(r io.Reader) func() (string, map[string]any) {
+		scanner := bufio.NewScanner(r)
+		scanner.Split(rpc.Split)
+		return func() (string, map[string]any) {
+			t.Helper()
+			if !scanner.Scan() {
+				t.Fatalf("No message received: %v", scanner.Err())
+			}
+			method, content, _ := rpc.DecodeMessage(scanner.Bytes())
+			var msg map[string]any
+			json.Unmarshal(content, &msg)
+			return method, msg
+		}
+	}
+	firstNext, secondNext, stdoutNext := reader(firstServer), reader(secondServer), reader(stdoutReader)
+
+	go func() {
+		send(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]any{}})
+		send(map[string]any{"jsonrpc": "2.0", "method": "initialized", "params": map[string]any{}})
+		send(map[string]any{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]any{
+			"textDocument": map[string]any{"uri": "file:///a.go", "text": "package a"},
+		}})
+	}()
+	for _, want := range []string{"initialize", "initialized", "textDocument/didOpen"} {
+		if method, _ := firstNext(); method != want {
+			t.Fatalf("Expected %s, got %s", want, method)
+		}
+	}
+
+	// The daemon goes away; the new one hears the session again
+	firstServer.Close()
+	var resyncs int
+	for _, want := range []string{"initialize", "initialized", "textDocument/didOpen", "crush/resyncDocument"} {
+		method, msg := secondNext()
+		if method != want {
+			t.Fatalf("Expected %s replayed, got %s", want, method)
+		}
+		if method == "initialize" {
+			if id, _ := msg["id"].(string); !strings.HasPrefix(id, "neocrush-resume-") {
+				t.Errorf("Expected the replayed initialize under the bridge's ID, got %v", msg["id"])
+			}
+			// Its response isn't passed to the editor
+			go secondServer.Write([]byte(rpc.EncodeMessage(map[string]any{"jsonrpc": "2.0", "id": msg["id"], "result": map[string]any{}})))
+		}
+		if method == "crush/resyncDocument" {
+			resyncs++
+		}
+	}
+	if resyncs != 1 {
+		t.Errorf("Expected one resync, got %d", resyncs)
+	}
+	go secondServer.Write([]byte(rpc.EncodeMessage(map[string]any{"jsonrpc": "2.0", "method": "window/logMessage", "params": map[string]any{}})))
+	if method, _ := stdoutNext(); method != "window/logMessage" {
+		t.Errorf("Expected only the new daemon's notification on stdout, got %s", method)
+	}
+
+	// The editor carries on over the new connection
+	go send(map[string]any{"jsonrpc": "2.0", "method": "textDocument/didChange", "params": map[string]any{}})
+	if method, _ := secondNext(); method != "textDocument/didChange" {
+		t.Errorf("Expected didChange on the new connection, got %s", method)
+	}
+
+	stdinWriter.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Expected a clean close, got %v", err)
+	}
+}
+
+// serveOnce answers the next request on conn using reply, after first
+// sending an unrelated notification the client must skip over.
+func serveOnce(t *testing.T, conn net.Conn, reply func(id any) map[string]any) {
+	t.Helper()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(rpc.Split)
+	if !scanner.Scan() {
+		t.Errorf("No request received")
+		return
+	}
+
+	_, content, err := rpc.DecodeMessage(scanner.Bytes())
+	if err != nil {
+		t.Errorf("Failed to decode request: %v", err)
+		return
+	}
+
+	var req struct {
+		ID any `json:"id"`
+	}
+	json.Unmarshal(content, &req)
+
+	conn.Write([]byte(rpc.EncodeMessage(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "crush/cursorMoved",
+		"params":  map[string]any{},
+	})))
+	conn.Write([]byte(rpc.EncodeMessage(reply(req.ID))))
+}
+
+func TestConnect_ExistingSession(t *testing.T) {
+	workspace := t.TempDir()
+	mgr := session.NewManager()
+	sess, err := mgr.CreateSession(workspace, os.Getpid())
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	listener, err := net.Listen("unix", sess.SocketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		mgr.RemoveSession(sess.ID)
+	})
+	if err := mgr.RecordDaemon(sess.ID, session.DaemonInfo{PID: os.Getpid()}); err != nil {
+		t.Fatalf("Failed to record daemon PID: %v", err)
+	}
+
+	c, err := client.Connect(log.New(io.Discard, "", 0), workspace)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+	if c.Session.ID != sess.ID {
+		t.Fatalf("Expected to reuse session %s, got %s", sess.ID, c.Session.ID)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	c := client.New(clientConn, log.New(io.Discard, "", 0))
+	defer c.Close()
+
+	go serveOnce(t, serverConn, func(id any) map[string]any {
+		return map[string]any{
+			"jsonrpc": "2.0",
+			"id":      id,
+			"result":  map[string]any{"uri": "file:///test.go"},
+		}
+	})
+
+	result, err := c.Request("crush/getEditorContext", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	var got struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(result, &got); err != nil {
+		t.Fatalf("Failed to parse result: %v", err)
+	}
+	if got.URI != "file:///test.go" {
+		t.Fatalf("Expected uri file:///test.go, got %q", got.URI)
+	}
+}
+
+func TestRequest_Error(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	c := client.New(clientConn, log.New(io.Discard, "", 0))
+	defer c.Close()
+
+	go serveOnce(t, serverConn, func(id any) map[string]any {
+		return map[string]any{
+			"jsonrpc": "2.0",
+			"id":      id,
+			"error":   map[string]any{"code": -32601, "message": "method not found"},
+		}
+	})
+
+	_, err := c.Request("crush/unknown", nil)
+	var respErr *client.ResponseError
+	if !errors.As(err, &respErr) {
+		t.Fatalf("Expected ResponseError, got %v", err)
+	}
+	if respErr.Code != -32601 {
+		t.Fatalf("Expected code -32601, got %d", respErr.Code)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	c := client.New(clientConn, log.New(io.Discard, "", 0))
+	defer c.Close()
+
+	notified := make(chan string, 1)
+	d := c.Dispatch(func(method string, content []byte) { notified <- method })
+
+	// Read both requests, then answer them in reverse order
+	go func() {
+		scanner := bufio.NewScanner(serverConn)
+		scanner.Split(rpc.Split)
+		var reqs []map[string]any
+		for len(reqs) < 2 && scanner.Scan() {
+			_, content, _ := rpc.DecodeMessage(scanner.Bytes())
+			var req map[string]any
+			json.Unmarshal(content, &req)
+			reqs = append(reqs, req)
+		}
+		serverConn.Write([]byte(rpc.EncodeMessage(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "crush/clientConnected",
+			"params":  map[string]any{"type": "neovim"},
+		})))
+		for i := len(reqs) - 1; i >= 0; i-- {
+			serverConn.Write([]byte(rpc.EncodeMessage(map[string]any{
+				"jsonrpc": "2.0",
+				"id":      reqs[i]["id"],
+				"result":  reqs[i]["method"],
+			})))
+		}
+	}()
+
+	results := make(chan string, 2)
+	for _, method := range []string{"crush/listFiles", "crush/readFile"} {
+		go func() {
+			result, err := d.Call(context.Background(), "", method, nil)
+			if err != nil {
+				t.Errorf("Call %s failed: %v", method, err)
+			}
+			var got string
+			json.Unmarshal(result, &got)
+			if got != method {
+				t.Errorf("Call %s got the response to %s", method, got)
+			}
+			results <- got
+		}()
+	}
+	<-results
+	<-results
+
+	select {
+	case method := <-notified:
+		if method != "crush/clientConnected" {
+			t.Errorf("Expected the notification passed on, got %s", method)
+		}
+	case <-time.After(time.Second):
+		t.Error("Notification was not passed on")
+	}
+}
+
+func TestDispatcher_Cancel(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	c := client.New(clientConn, log.New(io.Discard, "", 0))
+	defer c.Close()
+	d := c.Dispatch(nil)
+
+	// The request is read but never answered
+	received := make(chan string, 4)
+	go func() {
+		scanner := bufio.NewScanner(serverConn)
+		scanner.Split(rpc.Split)
+		for scanner.Scan() {
+			method, _, _ := rpc.DecodeMessage(scanner.Bytes())
+			received <- method
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := d.Call(ctx, "", "crush/getEditorContext", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the call to time out, got %v", err)
+	}
+	for _, want := range []string{"crush/getEditorContext", "$/cancelRequest"} {
+		select {
+		case method := <-received:
+			if method != want {
+				t.Errorf("Expected %s, got %s", want, method)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s", want)
+		}
+	}
+
+	// Calls fail once the daemon hangs up
+	serverConn.Close()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := d.Call(context.Background(), "", "crush/getEditorContext", nil); err == nil {
+		t.Fatal("Expected an error after the connection closed")
+	}
+}
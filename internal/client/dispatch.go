@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// response is a daemon response, routed to the call awaiting it.
+type response struct {
+	result json.RawMessage
+	err    error
+}
+
+// Dispatcher runs concurrent requests over one Client. Each call gets its
+// own ID, and a single goroutine reads the daemon's messages and hands each
+// response to the call waiting for it; messages that aren't responses go
+// to the notification handler. Once a Dispatcher is started, the Client
+// must not be read from directly (Request, Next).
+type Dispatcher struct {
+	c      *Client
+	notify func(method string, content []byte)
+
+	mu      sync.Mutex
+	pending map[int64]chan response
+	err     error         // Why reading stopped; set once the connection is gone
+	done    chan struct{} // Closed once the connection is gone
+}
+
+// Dispatch starts reading the client's connection and returns the
+// Dispatcher to send requests through. notify, if not nil, is called on
+// the reading goroutine for each message from the daemon that isn't a
+// response.
+func (c *Client) Dispatch(notify func(method string, content []byte)) *Dispatcher {
+	d := &Dispatcher{
+		c:       c,
+		notify:  notify,
+		pending: make(map[int64]chan response),
+		done:    make(chan struct{}),
+	}
+	go d.read()
+	return d
+}
+
+// Call sends a request carrying traceparent (if not empty) and waits for
+// its response until ctx is done, then cancels it with $/cancelRequest.
+// Without a deadline on ctx, it waits up to DefaultTimeout. A response
+// arriving after the call gave up is dropped.
+func (d *Dispatcher) Call(ctx context.Context, traceparent, method string, params any) (json.RawMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+	if params == nil {
+		params = map[string]any{}
+	}
+
+	id := d.c.requestID.Add(1)
+	ch := make(chan response, 1)
+	d.mu.Lock()
+	if d.err != nil {
+		d.mu.Unlock()
+		return nil, d.err
+	}
+	d.pending[id] = ch
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.pending, id)
+		d.mu.Unlock()
+	}()
+
+	msg := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	}
+	if traceparent != "" {
+		msg["traceparent"] = traceparent
+	}
+	if err := d.c.write(msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp.result, resp.err
+	case <-ctx.Done():
+		// Let the daemon stop working on it
+		_ = d.c.write(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "$/cancelRequest",
+			"params":  map[string]any{"id": id},
+		})
+		return nil, fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+}
+
+// Done returns a channel that is closed once the daemon connection is gone
+// and every call fails.
+func (d *Dispatcher) Done() <-chan struct{} {
+	return d.done
+}
+
+// read delivers the daemon's messages until the connection closes, then
+// fails the calls still waiting.
+func (d *Dispatcher) read() {
+	d.c.readMu.Lock()
+	defer d.c.readMu.Unlock()
+
+	for d.c.scanner.Scan() {
+		method, content, err := rpc.DecodeMessage(d.c.scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		if method != "" {
+			if d.notify != nil {
+				d.notify(method, content)
+			}
+			continue
+		}
+
+		var resp struct {
+			ID     *int64          `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *ResponseError  `json:"error"`
+		}
+		if err := json.Unmarshal(content, &resp); err != nil || resp.ID == nil {
+			continue
+		}
+		d.mu.Lock()
+		ch := d.pending[*resp.ID]
+		d.mu.Unlock()
+		if ch == nil {
+			continue // Its call gave up
+		}
+		if resp.Error != nil {
+			ch <- response{err: resp.Error}
+		} else {
+			ch <- response{result: resp.Result}
+		}
+	}
+
+	err := d.c.scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	d.mu.Lock()
+	d.err = fmt.Errorf("daemon connection closed: %w", err)
+	for id, ch := range d.pending {
+		ch <- response{err: d.err}
+		delete(d.pending, id)
+	}
+	d.mu.Unlock()
+	close(d.done)
+}
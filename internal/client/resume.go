@@ -0,0 +1,299 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/taigrr/neocrush/rpc"
+)
+
+const (
+	// resumeDelay is the first wait before redialing a daemon that hung up.
+	// It doubles after each failed attempt, up to maxResumeDelay.
+	resumeDelay    = 100 * time.Millisecond
+	maxResumeDelay = 5 * time.Second
+	// resumeAttempts bounds the redials before the bridge gives up.
+	resumeAttempts = 12
+	// resumeIDPrefix marks the IDs of requests the bridge sends itself
+	// while resuming; their responses are not passed to the editor.
+	resumeIDPrefix = "neocrush-resume-"
+)
+
+// errBridgeClosed ends a resuming bridge once either side is done.
+var errBridgeClosed = errors.New("bridge closed")
+
+// resumer is the state of BridgeResuming: what the editor sent that a new
+// daemon must hear again, and the connection messages currently go to.
+type resumer struct {
+	stdout io.Writer
+	redial func() (*Client, error)
+	c      *Client // Logs reconnects
+
+	mu          sync.Mutex
+	changed     *sync.Cond        // Broadcast when conn is replaced or the bridge closes
+	conn        net.Conn          // Current daemon connection
+	closed      bool              // Either side is done; stop redialing
+	exited      bool              // The editor sent exit, so the daemon hanging up is expected
+	initialize  json.RawMessage   // The editor's initialize request
+	initialized json.RawMessage   // Its initialized notification
+	open        map[string][]byte // URI -> the editor's didOpen, for documents still open
+	order       []string          // URIs of open, in the order they were opened
+	seq         int               // Counter for resume request IDs
+}
+
+// BridgeResuming is Bridge, but survives the daemon going away: when the
+// daemon hangs up, it redials with redial (which may start a new daemon)
+// with exponential backoff, replays the editor's initialize, initialized
+// and the didOpen of its open documents, asks the daemon to resync those
+// from the editor with crush/resyncDocument, and resumes. The replies to
+// what it replays are not passed on. Messages from the editor wait while
+// it redials. It gives up after resumeAttempts failed redials.
+func (c *Client) BridgeResuming(stdin io.Reader, stdout io.Writer, redial func() (*Client, error)) error {
+	r := &resumer{
+		stdout: stdout,
+		redial: redial,
+		c:      c,
+		conn:   c.conn,
+		open:   make(map[string][]byte),
+	}
+	r.changed = sync.NewCond(&r.mu)
+
+	errChan := make(chan error, 2)
+	go func() { errChan <- r.fromEditor(stdin) }()
+	go func() { errChan <- r.fromDaemon() }()
+
+	err := <-errChan
+	r.mu.Lock()
+	r.closed = true
+	conn := r.conn
+	r.changed.Broadcast()
+	r.mu.Unlock()
+	conn.Close()
+
+	if errors.Is(err, io.EOF) || errors.Is(err, errBridgeClosed) {
+		return nil
+	}
+	return err
+}
+
+// fromEditor forwards the editor's messages to the current daemon
+// connection, noting what a new daemon must hear again. A message that
+// can't be written waits for the next connection.
+func (r *resumer) fromEditor(stdin io.Reader) error {
+	scanner := bufio.NewScanner(stdin)
+	scanner.Split(rpc.Split)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	for scanner.Scan() {
+		msg := scanner.Bytes()
+		r.note(msg)
+		for {
+			r.mu.Lock()
+			if r.closed {
+				r.mu.Unlock()
+				return errBridgeClosed
+			}
+			conn := r.conn
+			r.mu.Unlock()
+
+			if _, err := conn.Write(msg); err == nil {
+				break
+			}
+			r.mu.Lock()
+			for r.conn == conn && !r.closed {
+				r.changed.Wait()
+			}
+			r.mu.Unlock()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// note records the editor messages a new daemon needs.
+func (r *resumer) note(msg []byte) {
+	method, content, err := rpc.DecodeMessage(msg)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch method {
+	case "initialize":
+		r.initialize = json.RawMessage(content)
+	case "initialized":
+		r.initialized = json.RawMessage(content)
+	case "exit":
+		r.exited = true
+	case "textDocument/didOpen", "textDocument/didClose":
+		var req struct {
+			Params struct {
+				TextDocument struct {
+					URI string `json:"uri"`
+				} `json:"textDocument"`
+			} `json:"params"`
+		}
+		if json.Unmarshal(content, &req) != nil || req.Params.TextDocument.URI == "" {
+			return
+		}
+		uri := req.Params.TextDocument.URI
+		delete(r.open, uri)
+		if i := slices.Index(r.order, uri); i >= 0 {
+			r.order = slices.Delete(r.order, i, i+1)
+		}
+		if method == "textDocument/didOpen" {
+			r.open[uri] = append([]byte(nil), msg...)
+			r.order = append(r.order, uri)
+		}
+	}
+}
+
+// fromDaemon forwards the daemon's messages to the editor, resuming on a
+// new connection whenever the daemon hangs up unexpectedly.
+func (r *resumer) fromDaemon() error {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+
+	for {
+		err := r.relay(conn)
+		r.mu.Lock()
+		done := r.closed || r.exited
+		r.mu.Unlock()
+		if done {
+			return errBridgeClosed
+		}
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			r.c.logger.Printf("Daemon connection failed: %v", err)
+		}
+
+		conn, err = r.resume()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// relay copies the daemon's messages on conn to the editor until conn
+// closes, dropping the responses to the bridge's own requests.
+func (r *resumer) relay(conn net.Conn) error {
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(rpc.Split)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	for scanner.Scan() {
+		msg := scanner.Bytes()
+		if method, content, err := rpc.DecodeMessage(msg); err == nil && method == "" && ownResponse(content) {
+			continue
+		}
+		if _, err := r.stdout.Write(msg); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// ownResponse reports whether content answers a request the bridge sent.
+func ownResponse(content []byte) bool {
+	var resp struct {
+		ID any `json:"id"`
+	}
+	if json.Unmarshal(content, &resp) != nil {
+		return false
+	}
+	id, ok := resp.ID.(string)
+	return ok && strings.HasPrefix(id, resumeIDPrefix)
+}
+
+// resume redials the daemon with backoff, replays the editor's session on
+// the new connection, and makes it current.
+func (r *resumer) resume() (net.Conn, error) {
+	delay := resumeDelay
+	var lastErr error
+	for range resumeAttempts {
+		r.mu.Lock()
+		closed := r.closed
+		r.mu.Unlock()
+		if closed {
+			return nil, errBridgeClosed
+		}
+
+		time.Sleep(delay)
+		delay = min(delay*2, maxResumeDelay)
+		c, err := r.redial()
+		if err != nil {
+			lastErr = err
+			r.c.logger.Printf("Reconnecting to daemon failed: %v", err)
+			continue
+		}
+		if err := r.replay(c.conn); err != nil {
+			lastErr = err
+			c.Close()
+			continue
+		}
+
+		r.mu.Lock()
+		r.conn = c.conn
+		r.changed.Broadcast()
+		r.mu.Unlock()
+		r.c.logger.Printf("Reconnected to daemon")
+		return c.conn, nil
+	}
+	return nil, fmt.Errorf("daemon gone, gave up reconnecting: %w", lastErr)
+}
+
+// replay sends a new daemon what it needs to pick up the editor's session:
+// initialize (under the bridge's own ID), initialized, the didOpen of each
+// open document, and a crush/resyncDocument for each, so the daemon takes
+// the editor's current text rather than the text it was opened with.
+func (r *resumer) replay(conn net.Conn) error {
+	r.mu.Lock()
+	var msgs []string
+	if r.initialize != nil {
+		var req map[string]json.RawMessage
+		if err := json.Unmarshal(r.initialize, &req); err != nil {
+			r.mu.Unlock()
+			return fmt.Errorf("failed to replay initialize: %w", err)
+		}
+		r.seq++
+		req["id"], _ = json.Marshal(fmt.Sprintf("%s%d", resumeIDPrefix, r.seq))
+		msgs = append(msgs, rpc.EncodeMessage(req))
+	}
+	if r.initialized != nil {
+		msgs = append(msgs, rpc.EncodeMessage(r.initialized))
+	}
+	for _, uri := range r.order {
+		msgs = append(msgs, string(r.open[uri]))
+	}
+	for _, uri := range r.order {
+		r.seq++
+		msgs = append(msgs, rpc.EncodeMessage(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      fmt.Sprintf("%s%d", resumeIDPrefix, r.seq),
+			"method":  "crush/resyncDocument",
+			"params":  map[string]any{"textDocument": map[string]string{"uri": uri}},
+		}))
+	}
+	r.mu.Unlock()
+
+	for _, msg := range msgs {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
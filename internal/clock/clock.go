@@ -0,0 +1,157 @@
+// Package clock abstracts the daemon's timers, so a replay can drive them
+// from the timestamps of a capture instead of the wall clock.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs timers.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call of an AfterFunc function. *time.Timer is one.
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                            { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration           { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time    { return time.After(d) }
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// Virtual is a clock that only moves when advanced. Timers fire in order of
+// their deadlines (then of being set) as AdvanceTo passes them, each on its
+// own goroutine as with time.AfterFunc.
+type Virtual struct {
+	// Settle is how long AdvanceTo waits for a fired function to return
+	// before firing the next one. A function still running after that
+	// (e.g. waiting for a reply) carries on in the background.
+	Settle time.Duration
+
+	mu     sync.Mutex
+	now    time.Time
+	seq    int
+	timers map[*virtualTimer]bool // Pending
+}
+
+type virtualTimer struct {
+	v    *Virtual
+	when time.Time
+	seq  int // When the timer was set, breaking ties between deadlines
+	f    func()
+}
+
+// NewVirtual returns a virtual clock reading start.
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start, timers: make(map[*virtualTimer]bool)}
+}
+
+// Now returns the clock's time.
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// Since returns the virtual time elapsed since t.
+func (v *Virtual) Since(t time.Time) time.Duration {
+	return v.Now().Sub(t)
+}
+
+// After returns a channel that receives the virtual time once d has
+// passed.
+func (v *Virtual) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	v.AfterFunc(d, func() { ch <- v.Now() })
+	return ch
+}
+
+// AfterFunc calls f once the clock has advanced by d.
+func (v *Virtual) AfterFunc(d time.Duration, f func()) Timer {
+	t := &virtualTimer{v: v, f: f}
+	v.mu.Lock()
+	v.schedule(t, d)
+	v.mu.Unlock()
+	return t
+}
+
+// schedule sets t to fire d from now. v.mu must be held.
+func (v *Virtual) schedule(t *virtualTimer, d time.Duration) {
+	v.seq++
+	t.seq = v.seq
+	t.when = v.now.Add(d)
+	v.timers[t] = true
+}
+
+func (t *virtualTimer) Stop() bool {
+	t.v.mu.Lock()
+	defer t.v.mu.Unlock()
+	pending := t.v.timers[t]
+	delete(t.v.timers, t)
+	return pending
+}
+
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	t.v.mu.Lock()
+	defer t.v.mu.Unlock()
+	pending := t.v.timers[t]
+	t.v.schedule(t, d)
+	return pending
+}
+
+// AdvanceTo moves the clock to t, firing the timers due by then. Each fires
+// with the clock reading its deadline, so timers it sets are relative to
+// that. The clock never moves backwards.
+func (v *Virtual) AdvanceTo(t time.Time) {
+	for {
+		v.mu.Lock()
+		var next *virtualTimer
+		for timer := range v.timers {
+			if timer.when.After(t) {
+				continue
+			}
+			if next == nil || timer.when.Before(next.when) || timer.when.Equal(next.when) && timer.seq < next.seq {
+				next = timer
+			}
+		}
+		if next == nil {
+			if t.After(v.now) {
+				v.now = t
+			}
+			v.mu.Unlock()
+			return
+		}
+		delete(v.timers, next)
+		if next.when.After(v.now) {
+			v.now = next.when
+		}
+		v.mu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			next.f()
+		}()
+		select {
+		case <-done:
+		case <-time.After(v.Settle):
+		}
+	}
+}
+
+// Advance moves the clock forward by d (see AdvanceTo).
+func (v *Virtual) Advance(d time.Duration) {
+	v.AdvanceTo(v.Now().Add(d))
+}
@@ -0,0 +1,76 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVirtual(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewVirtual(start)
+	v.Settle = time.Second
+
+	var mu sync.Mutex
+	var fired []string
+	record := func(name string) func() {
+		return func() {
+			mu.Lock()
+			fired = append(fired, name+"@"+v.Since(start).String())
+			mu.Unlock()
+		}
+	}
+
+	v.AfterFunc(2*time.Second, record("b"))
+	v.AfterFunc(time.Second, record("a"))
+	stopped := v.AfterFunc(time.Second, record("stopped"))
+	reset := v.AfterFunc(time.Second, record("reset"))
+	v.AfterFunc(time.Second, func() {
+		// Set from a firing timer: relative to its deadline
+		v.AfterFunc(500*time.Millisecond, record("chained"))
+	})
+	if !stopped.Stop() {
+		t.Error("Expected Stop to report a pending timer")
+	}
+	reset.Reset(3 * time.Second)
+
+	v.Advance(1500 * time.Millisecond)
+	if got := v.Since(start); got != 1500*time.Millisecond {
+		t.Errorf("Expected the clock at 1.5s, got %s", got)
+	}
+	v.AdvanceTo(start.Add(time.Second)) // Never backwards
+	v.Advance(1500 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"a@1s", "chained@1.5s", "b@2s", "reset@3s"}
+	if !slices.Equal(fired, want) {
+		t.Errorf("Expected %v, got %v", want, fired)
+	}
+	if stopped.Stop() {
+		t.Error("Expected a stopped timer to stay stopped")
+	}
+}
+
+func TestVirtualAfter(t *testing.T) {
+	v := NewVirtual(time.Unix(0, 0))
+	ch := v.After(time.Minute)
+
+	v.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("Fired early")
+	default:
+	}
+
+	v.Advance(time.Second)
+	select {
+	case now := <-ch:
+		if !now.Equal(time.Unix(60, 0)) {
+			t.Errorf("Expected the deadline, got %s", now)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not fire")
+	}
+}
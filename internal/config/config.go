@@ -0,0 +1,713 @@
+// Package config loads neocrush settings.
+//
+// Settings are read from the user config file
+// ($XDG_CONFIG_HOME/neocrush/config.json) and then overlaid with the
+// workspace file (<workspace>/.crush/neocrush.json). Fields present in the
+// workspace file replace the user values; absent fields are inherited.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// FileName is the workspace config file name inside .crush.
+	FileName = "neocrush.json"
+	// UserFileName is the config file name inside the user config dir.
+	UserFileName = "config.json"
+)
+
+// Config holds all neocrush settings.
+type Config struct {
+	Daemon      DaemonConfig      `json:"daemon"`
+	Completion  CompletionConfig  `json:"completion"`
+	Security    SecurityConfig    `json:"security"`
+	Files       FilesConfig       `json:"files"`
+	Documents   DocumentsConfig   `json:"documents"`
+	Related     RelatedConfig     `json:"related"`
+	Limits      LimitsConfig      `json:"limits"`
+	Guardrails  GuardrailsConfig  `json:"guardrails"`
+	Review      ReviewConfig      `json:"review"`
+	Git         GitConfig         `json:"git"`
+	Tracing     TracingConfig     `json:"tracing"`
+	Requests    RequestsConfig    `json:"requests"`
+	Agent       AgentConfig       `json:"agent"`
+	MCP         MCPConfig         `json:"mcp"`
+	Jobs        JobsConfig        `json:"jobs"`
+	Format      FormatConfig      `json:"format"`
+	Diagnostics DiagnosticsConfig `json:"diagnostics"`
+	Debug       DebugConfig       `json:"debug"`
+
+	// Languages are profiles by LSP language ID ("go", "python") that
+	// override the settings above for that language's files. A language in
+	// the workspace file replaces its whole profile from the user file.
+	Languages map[string]LanguageConfig `json:"languages"`
+}
+
+// DaemonConfig controls how the daemon process is spawned and sandboxed.
+type DaemonConfig struct {
+	// Env lists environment variables passed to the daemon. Entries ending
+	// in "*" match by prefix; a lone "*" passes the full environment.
+	// When empty, DefaultEnv is used.
+	Env []string `json:"env,omitempty"`
+	// Dir overrides the daemon's working directory (default: workspace root).
+	Dir string `json:"dir,omitempty"`
+	// Nice is the scheduling priority adjustment (-20..19, 0 = unchanged).
+	Nice int `json:"nice,omitempty"`
+	// IONice is the best-effort I/O priority level (0..7, Linux only).
+	// Zero leaves the inherited I/O priority.
+	IONice int `json:"ionice,omitempty"`
+	// MaxOpenFiles sets RLIMIT_NOFILE (0 = unchanged).
+	MaxOpenFiles uint64 `json:"max_open_files,omitempty"`
+	// MaxMemoryMB sets RLIMIT_AS in megabytes (0 = unchanged).
+	MaxMemoryMB uint64 `json:"max_memory_mb,omitempty"`
+}
+
+// CompletionConfig controls AI completions in Neovim's completion menu.
+type CompletionConfig struct {
+	// Enabled makes the daemon answer textDocument/completion from Neovim
+	// by asking Crush.
+	Enabled bool `json:"enabled,omitempty"`
+	// TimeoutMS is the latency budget for Crush's answer. Slower answers are
+	// dropped from the menu but still cached. Zero uses DefaultCompletionTimeout.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+}
+
+// SecurityConfig restricts who may talk to the daemon.
+type SecurityConfig struct {
+	// AuthToken, when set, must be presented by every client: LSP clients as
+	// initializationOptions.authToken, MCP clients as params.authToken.
+	// Set it in the user config file, not a committed workspace file.
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+// FilesConfig controls the workspace file listing served to agents.
+type FilesConfig struct {
+	// Exclude lists gitignore-style patterns hidden from the listing in
+	// addition to those in .gitignore files.
+	Exclude []string `json:"exclude,omitempty"`
+	// Protected lists gitignore-style patterns agents may not edit.
+	Protected []string `json:"protected,omitempty"`
+	// ProtectedMode is what happens to an agent edit of a protected file:
+	// "deny" (default) rejects it, "confirm" asks in Neovim first.
+	ProtectedMode string `json:"protected_mode,omitempty"`
+	// AutoOpen surfaces files Crush opens or creates that Neovim doesn't
+	// have open: "show" opens them in Neovim without taking focus,
+	// "quickfix" lists them with crush/showLocations. Empty leaves them be.
+	AutoOpen string `json:"auto_open,omitempty"`
+	// MaxDocumentBytes is the size above which a document's content isn't
+	// cached, diffed, linted or sent to tools; only its metadata is.
+	// Binary documents are always treated so. Zero uses
+	// DefaultMaxDocumentBytes.
+	MaxDocumentBytes int `json:"max_document_bytes,omitempty"`
+}
+
+// ProtectedModeConfirm asks the user to confirm edits to protected files
+// instead of rejecting them.
+const ProtectedModeConfirm = "confirm"
+
+// Values of FilesConfig.AutoOpen.
+const (
+	AutoOpenShow     = "show"     // window/showDocument without focus
+	AutoOpenQuickfix = "quickfix" // A crush/showLocations entry
+)
+
+// DocumentsConfig decides how textDocument/didOpen and didClose travel
+// between Neovim and Crush. Each policy is one of the Policy* constants.
+type DocumentsConfig struct {
+	// FromNeovim is the policy for Neovim's didOpen/didClose sent to Crush.
+	// Empty uses PolicyMirror.
+	FromNeovim string `json:"from_neovim,omitempty"`
+	// FromCrush is the policy for Crush's didOpen/didClose sent to Neovim.
+	// Empty uses PolicyIgnore.
+	FromCrush string `json:"from_crush,omitempty"`
+	// Rules override the policies for some files. For each direction, the
+	// first rule that sets a policy for it and matches the document wins.
+	Rules []DocumentRule `json:"rules,omitempty"`
+}
+
+// DocumentRule sets didOpen/didClose policies for matching files.
+type DocumentRule struct {
+	// Files lists gitignore-style patterns, relative to the workspace
+	// folder the document is in.
+	Files []string `json:"files"`
+	// FromNeovim and FromCrush override the policies; empty leaves a
+	// direction to later rules.
+	FromNeovim string `json:"from_neovim,omitempty"`
+	FromCrush  string `json:"from_crush,omitempty"`
+}
+
+// didOpen/didClose policies.
+const (
+	PolicyMirror = "mirror" // Forward the notification unchanged
+	PolicyNotify = "notify" // Send crush/documentOpened or crush/documentClosed with just the URI
+	PolicyIgnore = "ignore" // Drop the notification
+)
+
+// RelatedConfig controls related-file suggestions.
+type RelatedConfig struct {
+	// Enabled makes the daemon index workspace files (TF-IDF signatures,
+	// built on first use) to answer crush/relatedFiles.
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// LimitsConfig caps how fast each agent client may edit the workspace.
+// Zero disables a limit.
+type LimitsConfig struct {
+	// EditsPerMinute caps document edits (one per file touched) per client.
+	EditsPerMinute int `json:"edits_per_minute,omitempty"`
+	// BytesPerMinute caps the new text written per client.
+	BytesPerMinute int `json:"bytes_per_minute,omitempty"`
+	// FilesPerOperation caps the files a single edit may touch.
+	FilesPerOperation int `json:"files_per_operation,omitempty"`
+	// Mode is what happens to an edit over a per-minute limit: "reject"
+	// (default) fails it, "queue" holds the client's messages until the
+	// edit fits.
+	Mode string `json:"mode,omitempty"`
+}
+
+// LimitModeQueue delays edits over a limit instead of rejecting them.
+const LimitModeQueue = "queue"
+
+// GuardrailsConfig sets the size above which agent edits are no longer
+// applied automatically but must be confirmed in Neovim. Zero disables a
+// threshold.
+type GuardrailsConfig struct {
+	// ConfirmLines is the most lines (replaced plus inserted) an edit may
+	// change and still be auto-applied.
+	ConfirmLines int `json:"confirm_lines,omitempty"`
+	// ConfirmFiles is the most files an edit may touch and still be
+	// auto-applied.
+	ConfirmFiles int `json:"confirm_files,omitempty"`
+}
+
+// ReviewConfig controls review of Crush's edits in Neovim.
+type ReviewConfig struct {
+	// Enabled stages each hunk of Crush's edits to documents open in
+	// Neovim for the user to accept or reject, instead of applying them.
+	Enabled bool `json:"enabled,omitempty"`
+	// PreviewCreated stages files agents create through crush/writeFile as
+	// read-only previews in Neovim; they are written only once accepted.
+	PreviewCreated bool `json:"preview_created,omitempty"`
+	// FoldLines is how many lines an agent edit must span before its
+	// workspace/applyEdit carries a fold hint, so the plugin can fold the
+	// lines it left unchanged (0 = DefaultFoldLines, negative disables).
+	FoldLines int `json:"fold_lines,omitempty"`
+}
+
+// GitConfig guards the branches agents may edit on.
+type GitConfig struct {
+	// ProtectedBranches lists branch names or globs ("main", "release/*")
+	// on which agent edits are not applied directly.
+	ProtectedBranches []string `json:"protected_branches,omitempty"`
+	// ProtectedBranchMode is what happens to an agent edit while the
+	// workspace is on a protected branch: "deny" (default) rejects it,
+	// "confirm" asks in Neovim first.
+	ProtectedBranchMode string `json:"protected_branch_mode,omitempty"`
+}
+
+// TracingConfig controls export of request spans to an OpenTelemetry
+// collector.
+type TracingConfig struct {
+	// Enabled makes the daemon and the MCP server record a span for each
+	// request/response pair.
+	Enabled bool `json:"enabled,omitempty"`
+	// Endpoint is the OTLP/HTTP collector address. When empty,
+	// OTEL_EXPORTER_OTLP_ENDPOINT is used, then http://localhost:4318.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// RequestsConfig controls how the daemon handles requests relayed between
+// clients that go unanswered.
+type RequestsConfig struct {
+	// TimeoutMS is how long a relayed request may wait for its response
+	// before it is reported. Zero uses DefaultRequestTimeout.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+	// SynthesizeErrors answers an unanswered request with an error on the
+	// peer's behalf, so the sender stops waiting. A late response is dropped.
+	SynthesizeErrors bool `json:"synthesize_errors,omitempty"`
+	// HandlerTimeoutMS is how long the daemon may spend serving a request
+	// itself (reading files, asking Neovim) before the work is cancelled
+	// and the request fails. Zero uses DefaultHandlerTimeout; negative
+	// leaves requests unbounded.
+	HandlerTimeoutMS int `json:"handler_timeout_ms,omitempty"`
+	// Deadlines overrides the deadline of single methods, in milliseconds:
+	// for a request relayed to a peer, how long its response may take; for
+	// one the daemon sends itself (e.g. workspace/applyEdit), how long it
+	// waits; for one the daemon serves, how long it may work on it.
+	Deadlines map[string]int `json:"deadlines,omitempty"`
+}
+
+// AgentConfig controls launching an agent for Neovim when none attaches.
+type AgentConfig struct {
+	// Command is the agent to launch in the workspace root (program and
+	// arguments, e.g. ["crush", "--lsp"]). Empty disables launching.
+	Command []string `json:"command,omitempty"`
+	// LaunchAfterMS is how long Neovim may wait for Crush to attach before
+	// Command is launched. Zero uses DefaultAgentLaunchDelay.
+	LaunchAfterMS int `json:"launch_after_ms,omitempty"`
+	// Routing picks which of several connected Crush agents answers
+	// Neovim's requests: "first" (default) the one connected longest,
+	// "active" the one that sent a message last. Neovim's notifications
+	// go to every agent.
+	Routing string `json:"routing,omitempty"`
+}
+
+// Values of AgentConfig.Routing.
+const (
+	AgentRoutingFirst  = "first"
+	AgentRoutingActive = "active"
+)
+
+// MCPConfig controls the MCP server neocrush runs for agents.
+type MCPConfig struct {
+	// CacheTTLMS is how long an editor_context result is reused without
+	// asking the daemon; after that it is reused while the daemon's state
+	// version is unchanged. Zero uses DefaultMCPCacheTTL; negative disables
+	// caching.
+	CacheTTLMS int `json:"cache_ttl_ms,omitempty"`
+	// Tools, when set, lists the only tools offered, by built-in name.
+	// Empty offers every tool.
+	Tools []string `json:"tools,omitempty"`
+	// DisabledTools lists tools not offered, by built-in name.
+	DisabledTools []string `json:"disabled_tools,omitempty"`
+	// ToolAliases renames tools for hosts with naming collisions: built-in
+	// name -> name offered to the host.
+	ToolAliases map[string]string `json:"tool_aliases,omitempty"`
+}
+
+// JobsConfig sets the test and build commands the daemon runs for
+// crush/runJob. An empty command is detected from the project type.
+type JobsConfig struct {
+	// Test is the test command (program and arguments, e.g.
+	// ["go", "test", "./..."]).
+	Test []string `json:"test,omitempty"`
+	// Build is the build command.
+	Build []string `json:"build,omitempty"`
+	// TimeoutMS is how long a job may run before it is killed. Zero uses
+	// DefaultJobTimeout.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+	// History is how many finished jobs crush/getRecentJobs remembers.
+	// Zero uses DefaultJobHistory; negative remembers none.
+	History int `json:"history,omitempty"`
+}
+
+// FormatConfig runs formatters on files agents write, so their edits land
+// formatted. No rules disables formatting.
+type FormatConfig struct {
+	// Rules pick the formatter of a file; the first whose files match wins.
+	Rules []FormatRule `json:"rules,omitempty"`
+	// TimeoutMS is how long a formatter may run before the edit is
+	// applied unformatted. Zero uses DefaultFormatTimeout.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+}
+
+// FormatRule sets the formatter of matching files.
+type FormatRule struct {
+	// Files lists gitignore-style patterns, relative to the workspace
+	// folder the file is in.
+	Files []string `json:"files"`
+	// Command reads the file on stdin and writes it formatted to stdout
+	// (program and arguments, e.g. ["gofmt"]). "{path}" in an argument is
+	// replaced with the file's absolute path, for formatters that pick
+	// their settings by it (e.g. ["prettier", "--stdin-filepath", "{path}"]).
+	Command []string `json:"command"`
+}
+
+// DiagnosticsConfig sets the linters the daemon runs on documents Neovim
+// opens and agents edit, publishing their problems as diagnostics.
+type DiagnosticsConfig struct {
+	// Providers run in order; each adds its diagnostics.
+	Providers []DiagnosticsProvider `json:"providers,omitempty"`
+	// TimeoutMS is how long a command provider may run before its
+	// diagnostics are dropped. Zero uses DefaultDiagnosticsTimeout.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+	// Todos reports comments starting with a marker (TODO, FIXME, HACK)
+	// as information diagnostics.
+	Todos bool `json:"todos,omitempty"`
+	// Markers replaces the default markers, for Todos and list_todos.
+	Markers []string `json:"markers,omitempty"`
+	// BannedWords are regular expressions reported wherever they match a
+	// whole word, regardless of case.
+	BannedWords []string `json:"banned_words,omitempty"`
+}
+
+// DiagnosticsProvider is a linter: regex rules, or an external command
+// that prints its problems as JSON.
+type DiagnosticsProvider struct {
+	// Name is the diagnostics' source (default "neocrush").
+	Name string `json:"name,omitempty"`
+	// Languages limits the provider to documents with these LSP language
+	// IDs (e.g. "go", "python"); empty runs it on all documents.
+	Languages []string `json:"languages,omitempty"`
+	// Rules flag matches of regular expressions, line by line.
+	Rules []DiagnosticsRule `json:"rules,omitempty"`
+	// Command reads the document on stdin and prints a JSON array of
+	// problems (program and arguments). "{path}" in an argument is
+	// replaced with the document's absolute path.
+	Command []string `json:"command,omitempty"`
+}
+
+// DiagnosticsRule flags each match of a regular expression.
+type DiagnosticsRule struct {
+	// Pattern is an RE2 regular expression matched against each line.
+	Pattern string `json:"pattern"`
+	// Severity is "error", "warning" (default), "information" or "hint".
+	Severity string `json:"severity,omitempty"`
+	// Message may refer to submatches as $1 or ${name}; empty uses the
+	// matched text.
+	Message string `json:"message,omitempty"`
+}
+
+// LanguageConfig is the profile of one language. Empty fields leave the
+// general setting in effect.
+type LanguageConfig struct {
+	// ContextLines is how many lines around the cursor editor_context
+	// shares for the language's files.
+	ContextLines int `json:"context_lines,omitempty"`
+	// Format formats the language's files when no format rule matches
+	// them (program and arguments, as in FormatRule.Command).
+	Format []string `json:"format,omitempty"`
+	// Test is the test command for crush/runJob on the language.
+	Test []string `json:"test,omitempty"`
+	// DiffGranularity is how edits to the language's files are staged for
+	// review: "hunk" (default) stages each changed region, "file" the
+	// whole edit as one hunk.
+	DiffGranularity string `json:"diff_granularity,omitempty"`
+	// Protected lists gitignore-style patterns of the language's files
+	// agents may not edit, in addition to files.protected.
+	Protected []string `json:"protected,omitempty"`
+}
+
+// DiffGranularityFile stages an edit for review as a single hunk.
+const DiffGranularityFile = "file"
+
+// DebugConfig controls troubleshooting aids.
+type DebugConfig struct {
+	// Capture records every message the daemon routes (secrets redacted)
+	// to .crush/captures/<session>.jsonl for `neocrush inspect`.
+	Capture bool `json:"capture,omitempty"`
+	// Faults lets crush/setFaults (`neocrush chaos`) inject delays, drops,
+	// truncated messages and throttling into the daemon's sends, to test
+	// that clients recover. Never enable it outside testing.
+	Faults bool `json:"faults,omitempty"`
+	// Soak has the daemon sample its goroutines, heap and tables every
+	// SoakIntervalMS and warn when one grows over SoakWindow samples in a
+	// row, which points to a leak in long sessions.
+	Soak           bool `json:"soak,omitempty"`
+	SoakIntervalMS int  `json:"soak_interval_ms,omitempty"`
+	SoakWindow     int  `json:"soak_window,omitempty"`
+}
+
+// DefaultSoakInterval is how often soak mode samples when unset.
+const DefaultSoakInterval = 30 * time.Second
+
+// DefaultSoakWindow is how many samples in a row soak mode compares when
+// unset.
+const DefaultSoakWindow = 10
+
+// DefaultMaxDocumentBytes is the largest document handled in full when
+// unset.
+const DefaultMaxDocumentBytes = 1 << 20
+
+// DefaultFoldLines is how many lines an edit spans before it gets a fold
+// hint when unset.
+const DefaultFoldLines = 50
+
+// DefaultCompletionTimeout is the completion latency budget when unset.
+const DefaultCompletionTimeout = 300 * time.Millisecond
+
+// DefaultRequestTimeout is the unanswered-request threshold when unset.
+const DefaultRequestTimeout = 30 * time.Second
+
+// DefaultHandlerTimeout is how long the daemon may serve a request when
+// unset.
+const DefaultHandlerTimeout = time.Minute
+
+// DefaultAgentLaunchDelay is the wait for Crush before launching the
+// agent command when unset.
+const DefaultAgentLaunchDelay = 5 * time.Second
+
+// DefaultMCPCacheTTL is how long the MCP server reuses editor_context
+// results without asking the daemon when unset.
+const DefaultMCPCacheTTL = time.Second
+
+// DefaultJobTimeout is how long a test or build job may run when unset.
+const DefaultJobTimeout = 10 * time.Minute
+
+// DefaultJobHistory is how many finished jobs are remembered when unset.
+const DefaultJobHistory = 20
+
+// DefaultFormatTimeout is how long a formatter may run when unset.
+const DefaultFormatTimeout = 2 * time.Second
+
+// DefaultDiagnosticsTimeout is how long a command diagnostics provider
+// may run when unset.
+const DefaultDiagnosticsTimeout = 5 * time.Second
+
+// defaultOTLPEndpoint is the standard local OTLP/HTTP collector address.
+const defaultOTLPEndpoint = "http://localhost:4318"
+
+// DefaultEnv is the environment allowlist used when DaemonConfig.Env is empty.
+// It keeps what tools need to run and drops everything else (API keys, tokens).
+var DefaultEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "TZ", "TMPDIR",
+	"LANG", "LC_*", "XDG_*",
+	"GOPATH", "GOROOT", "GOFLAGS", "GOPROXY", "GOPRIVATE",
+	"CRUSH_*", "NEOCRUSH_*", "OTEL_*",
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{}
+}
+
+// UserPath returns the user config file path.
+func UserPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "neocrush", UserFileName)
+}
+
+// WorkspacePath returns the workspace config file path.
+func WorkspacePath(workspaceRoot string) string {
+	return filepath.Join(workspaceRoot, ".crush", FileName)
+}
+
+// Load reads the user and workspace config files. Missing files are not
+// an error; malformed files are.
+func Load(workspaceRoot string) (*Config, error) {
+	cfg := Default()
+
+	for _, path := range []string{UserPath(), WorkspacePath(workspaceRoot)} {
+		if path == "" {
+			continue
+		}
+		if err := overlay(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// overlay unmarshals path on top of cfg.
+func overlay(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// EnvAllowlist returns the effective environment allowlist.
+func (c DaemonConfig) EnvAllowlist() []string {
+	if len(c.Env) == 0 {
+		return DefaultEnv
+	}
+	return c.Env
+}
+
+// OTLPEndpoint returns the effective collector address.
+func (c TracingConfig) OTLPEndpoint() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	if env := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); env != "" {
+		return env
+	}
+	return defaultOTLPEndpoint
+}
+
+// Timeout returns the effective unanswered-request threshold.
+func (c RequestsConfig) Timeout() time.Duration {
+	if c.TimeoutMS <= 0 {
+		return DefaultRequestTimeout
+	}
+	return time.Duration(c.TimeoutMS) * time.Millisecond
+}
+
+// HandlerTimeout returns the effective deadline of requests the daemon
+// serves, or 0 if they are unbounded.
+func (c RequestsConfig) HandlerTimeout() time.Duration {
+	switch {
+	case c.HandlerTimeoutMS < 0:
+		return 0
+	case c.HandlerTimeoutMS == 0:
+		return DefaultHandlerTimeout
+	}
+	return time.Duration(c.HandlerTimeoutMS) * time.Millisecond
+}
+
+// Deadline returns the deadline configured for method, or fallback.
+func (c RequestsConfig) Deadline(method string, fallback time.Duration) time.Duration {
+	if ms := c.Deadlines[method]; ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return fallback
+}
+
+// Policy returns the policy for a didOpen/didClose from "neovim" or
+// "crush", for a document some rules match (matched reports whether rule i
+// matches it).
+func (c DocumentsConfig) Policy(from string, matched func(i int) bool) string {
+	for i, rule := range c.Rules {
+		policy := rule.FromNeovim
+		if from == "crush" {
+			policy = rule.FromCrush
+		}
+		if policy != "" && matched(i) {
+			return policy
+		}
+	}
+	switch {
+	case from == "crush" && c.FromCrush != "":
+		return c.FromCrush
+	case from == "crush":
+		return PolicyIgnore
+	case c.FromNeovim != "":
+		return c.FromNeovim
+	default:
+		return PolicyMirror
+	}
+}
+
+// MaxDocumentSize returns the effective size limit of documents.
+func (c FilesConfig) MaxDocumentSize() int {
+	if c.MaxDocumentBytes <= 0 {
+		return DefaultMaxDocumentBytes
+	}
+	return c.MaxDocumentBytes
+}
+
+// FoldThreshold returns the lines an edit must span to get a fold hint, or
+// 0 if fold hints are disabled.
+func (c ReviewConfig) FoldThreshold() int {
+	switch {
+	case c.FoldLines < 0:
+		return 0
+	case c.FoldLines == 0:
+		return DefaultFoldLines
+	}
+	return c.FoldLines
+}
+
+// SoakInterval returns the effective soak-mode sampling interval.
+func (c DebugConfig) SoakInterval() time.Duration {
+	if c.SoakIntervalMS <= 0 {
+		return DefaultSoakInterval
+	}
+	return time.Duration(c.SoakIntervalMS) * time.Millisecond
+}
+
+// SoakSamples returns the effective soak-mode window.
+func (c DebugConfig) SoakSamples() int {
+	if c.SoakWindow <= 0 {
+		return DefaultSoakWindow
+	}
+	return c.SoakWindow
+}
+
+// LaunchDelay returns the effective wait before the agent is launched.
+func (c AgentConfig) LaunchDelay() time.Duration {
+	if c.LaunchAfterMS <= 0 {
+		return DefaultAgentLaunchDelay
+	}
+	return time.Duration(c.LaunchAfterMS) * time.Millisecond
+}
+
+// CacheTTL returns the effective editor_context cache lifetime, or 0 if
+// caching is disabled.
+func (c MCPConfig) CacheTTL() time.Duration {
+	switch {
+	case c.CacheTTLMS < 0:
+		return 0
+	case c.CacheTTLMS == 0:
+		return DefaultMCPCacheTTL
+	}
+	return time.Duration(c.CacheTTLMS) * time.Millisecond
+}
+
+// ToolName returns the name the built-in tool is offered under, or "" if
+// it is not offered.
+func (c MCPConfig) ToolName(tool string) string {
+	if len(c.Tools) > 0 && !slices.Contains(c.Tools, tool) {
+		return ""
+	}
+	if slices.Contains(c.DisabledTools, tool) {
+		return ""
+	}
+	if alias := c.ToolAliases[tool]; alias != "" {
+		return alias
+	}
+	return tool
+}
+
+// Command returns the configured command of a job kind ("test" or
+// "build"), or nil if it is not configured.
+func (c JobsConfig) Command(kind string) []string {
+	switch kind {
+	case "test":
+		return c.Test
+	case "build":
+		return c.Build
+	}
+	return nil
+}
+
+// Timeout returns the effective job run time limit.
+func (c JobsConfig) Timeout() time.Duration {
+	if c.TimeoutMS <= 0 {
+		return DefaultJobTimeout
+	}
+	return time.Duration(c.TimeoutMS) * time.Millisecond
+}
+
+// HistorySize returns the effective number of finished jobs remembered.
+func (c JobsConfig) HistorySize() int {
+	switch {
+	case c.History < 0:
+		return 0
+	case c.History == 0:
+		return DefaultJobHistory
+	}
+	return c.History
+}
+
+// Timeout returns the effective formatter run time limit.
+func (c FormatConfig) Timeout() time.Duration {
+	if c.TimeoutMS <= 0 {
+		return DefaultFormatTimeout
+	}
+	return time.Duration(c.TimeoutMS) * time.Millisecond
+}
+
+// Timeout returns the effective command provider run time limit.
+func (c DiagnosticsConfig) Timeout() time.Duration {
+	if c.TimeoutMS <= 0 {
+		return DefaultDiagnosticsTimeout
+	}
+	return time.Duration(c.TimeoutMS) * time.Millisecond
+}
+
+// Timeout returns the effective completion latency budget.
+func (c CompletionConfig) Timeout() time.Duration {
+	if c.TimeoutMS <= 0 {
+		return DefaultCompletionTimeout
+	}
+	return time.Duration(c.TimeoutMS) * time.Millisecond
+}
@@ -0,0 +1,261 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/config"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	cfg, err := config.Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if got := cfg.Daemon.EnvAllowlist(); len(got) != len(config.DefaultEnv) {
+		t.Fatalf("Expected default env allowlist, got %v", got)
+	}
+}
+
+func TestLoad_WorkspaceOverridesUser(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	workspace := t.TempDir()
+
+	writeFile(t, filepath.Join(configHome, "neocrush", "config.json"),
+		`{"daemon": {"nice": 5, "max_open_files": 4096}}`)
+	writeFile(t, filepath.Join(workspace, ".crush", "neocrush.json"),
+		`{"daemon": {"nice": 10, "env": ["PATH"]}}`)
+
+	cfg, err := config.Load(workspace)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Daemon.Nice != 10 {
+		t.Errorf("Expected workspace nice 10, got %d", cfg.Daemon.Nice)
+	}
+	if cfg.Daemon.MaxOpenFiles != 4096 {
+		t.Errorf("Expected inherited max_open_files 4096, got %d", cfg.Daemon.MaxOpenFiles)
+	}
+	if got := cfg.Daemon.EnvAllowlist(); len(got) != 1 || got[0] != "PATH" {
+		t.Errorf("Expected env [PATH], got %v", got)
+	}
+}
+
+func TestLoad_Languages(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	workspace := t.TempDir()
+
+	writeFile(t, filepath.Join(configHome, "neocrush", "config.json"),
+		`{"languages": {"go": {"context_lines": 20, "format": ["gofmt"]}, "python": {"test": ["pytest"]}}}`)
+	writeFile(t, filepath.Join(workspace, ".crush", "neocrush.json"),
+		`{"languages": {"go": {"diff_granularity": "file"}}}`)
+
+	cfg, err := config.Load(workspace)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if got := cfg.Languages["go"]; got.DiffGranularity != config.DiffGranularityFile || got.ContextLines != 0 || got.Format != nil {
+		t.Errorf("Expected the workspace go profile to replace the user's, got %+v", got)
+	}
+	if got := cfg.Languages["python"].Test; !slices.Equal(got, []string{"pytest"}) {
+		t.Errorf("Expected the user python profile kept, got %v", got)
+	}
+}
+
+func TestLoad_Malformed(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	workspace := t.TempDir()
+
+	writeFile(t, filepath.Join(workspace, ".crush", "neocrush.json"), `{"daemon": `)
+
+	if _, err := config.Load(workspace); err == nil {
+		t.Fatal("Expected error for malformed config")
+	}
+}
+
+func TestCompletionTimeout(t *testing.T) {
+	if got := (config.CompletionConfig{}).Timeout(); got != config.DefaultCompletionTimeout {
+		t.Errorf("Expected default timeout, got %v", got)
+	}
+	if got := (config.CompletionConfig{TimeoutMS: 150}).Timeout(); got != 150*time.Millisecond {
+		t.Errorf("Expected 150ms, got %v", got)
+	}
+}
+
+func TestDocumentsPolicy(t *testing.T) {
+	none := func(int) bool { return false }
+	if got := (config.DocumentsConfig{}).Policy("neovim", none); got != config.PolicyMirror {
+		t.Errorf("Expected Neovim's documents mirrored by default, got %s", got)
+	}
+	if got := (config.DocumentsConfig{}).Policy("crush", none); got != config.PolicyIgnore {
+		t.Errorf("Expected Crush's documents ignored by default, got %s", got)
+	}
+
+	cfg := config.DocumentsConfig{
+		FromCrush: config.PolicyNotify,
+		Rules: []config.DocumentRule{
+			{Files: []string{"*.lock"}, FromNeovim: config.PolicyIgnore},
+			{Files: []string{"*"}, FromCrush: config.PolicyMirror},
+		},
+	}
+	all := func(int) bool { return true }
+	if got := cfg.Policy("neovim", all); got != config.PolicyIgnore {
+		t.Errorf("Expected the first rule for Neovim, got %s", got)
+	}
+	if got := cfg.Policy("crush", all); got != config.PolicyMirror {
+		t.Errorf("Expected the rule setting Crush's policy, got %s", got)
+	}
+	if got := cfg.Policy("crush", none); got != config.PolicyNotify {
+		t.Errorf("Expected from_crush without a matching rule, got %s", got)
+	}
+}
+
+func TestAgentLaunchDelay(t *testing.T) {
+	if got := (config.AgentConfig{}).LaunchDelay(); got != config.DefaultAgentLaunchDelay {
+		t.Errorf("Expected default delay, got %v", got)
+	}
+	if got := (config.AgentConfig{LaunchAfterMS: 1500}).LaunchDelay(); got != 1500*time.Millisecond {
+		t.Errorf("Expected 1.5s, got %v", got)
+	}
+}
+
+func TestSoakDefaults(t *testing.T) {
+	if got := (config.DebugConfig{}).SoakInterval(); got != config.DefaultSoakInterval {
+		t.Errorf("Expected default interval, got %v", got)
+	}
+	if got := (config.DebugConfig{SoakWindow: 4}).SoakSamples(); got != 4 {
+		t.Errorf("Expected a window of 4, got %d", got)
+	}
+}
+
+func TestFoldThreshold(t *testing.T) {
+	if got := (config.ReviewConfig{}).FoldThreshold(); got != config.DefaultFoldLines {
+		t.Errorf("Expected default threshold, got %d", got)
+	}
+	if got := (config.ReviewConfig{FoldLines: -1}).FoldThreshold(); got != 0 {
+		t.Errorf("Expected fold hints disabled, got %d", got)
+	}
+}
+
+func TestMCPCacheTTL(t *testing.T) {
+	if got := (config.MCPConfig{}).CacheTTL(); got != config.DefaultMCPCacheTTL {
+		t.Errorf("Expected default TTL, got %v", got)
+	}
+	if got := (config.MCPConfig{CacheTTLMS: 250}).CacheTTL(); got != 250*time.Millisecond {
+		t.Errorf("Expected 250ms, got %v", got)
+	}
+	if got := (config.MCPConfig{CacheTTLMS: -1}).CacheTTL(); got != 0 {
+		t.Errorf("Expected caching disabled, got %v", got)
+	}
+}
+
+func TestMCPToolName(t *testing.T) {
+	cfg := config.MCPConfig{
+		DisabledTools: []string{"write_file"},
+		ToolAliases:   map[string]string{"read_file": "nvim_read_file"},
+	}
+	tests := map[string]string{
+		"editor_context": "editor_context",
+		"read_file":      "nvim_read_file",
+		"write_file":     "",
+	}
+	for tool, want := range tests {
+		if got := cfg.ToolName(tool); got != want {
+			t.Errorf("ToolName(%q) = %q, want %q", tool, got, want)
+		}
+	}
+
+	cfg.Tools = []string{"read_file", "write_file"}
+	if got := cfg.ToolName("editor_context"); got != "" {
+		t.Errorf("Expected tools outside the list to be disabled, got %q", got)
+	}
+	if got := cfg.ToolName("read_file"); got != "nvim_read_file" {
+		t.Errorf("Expected the alias for a listed tool, got %q", got)
+	}
+	if got := cfg.ToolName("write_file"); got != "" {
+		t.Errorf("Expected disabled_tools to win over tools, got %q", got)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	if got := (config.RequestsConfig{}).Timeout(); got != config.DefaultRequestTimeout {
+		t.Errorf("Expected default timeout, got %v", got)
+	}
+	if got := (config.RequestsConfig{TimeoutMS: 2000}).Timeout(); got != 2*time.Second {
+		t.Errorf("Expected 2s, got %v", got)
+	}
+
+	cfg := config.RequestsConfig{HandlerTimeoutMS: -1, Deadlines: map[string]int{"crush/readFile": 500}}
+	if got := cfg.HandlerTimeout(); got != 0 {
+		t.Errorf("Expected unbounded handlers, got %v", got)
+	}
+	if got := (config.RequestsConfig{}).HandlerTimeout(); got != config.DefaultHandlerTimeout {
+		t.Errorf("Expected default handler timeout, got %v", got)
+	}
+	if got := cfg.Deadline("crush/readFile", time.Second); got != 500*time.Millisecond {
+		t.Errorf("Expected the configured deadline, got %v", got)
+	}
+	if got := cfg.Deadline("crush/writeFile", time.Second); got != time.Second {
+		t.Errorf("Expected the fallback, got %v", got)
+	}
+}
+
+func TestJobsConfig(t *testing.T) {
+	cfg := config.JobsConfig{Test: []string{"make", "check"}}
+	if got := cfg.Command("test"); !slices.Equal(got, []string{"make", "check"}) {
+		t.Errorf("Expected the configured test command, got %v", got)
+	}
+	if got := cfg.Command("build"); got != nil {
+		t.Errorf("Expected no build command, got %v", got)
+	}
+	if got := cfg.Timeout(); got != config.DefaultJobTimeout {
+		t.Errorf("Expected default timeout, got %v", got)
+	}
+	if got := (config.JobsConfig{TimeoutMS: 5000}).Timeout(); got != 5*time.Second {
+		t.Errorf("Expected 5s, got %v", got)
+	}
+	if got := cfg.HistorySize(); got != config.DefaultJobHistory {
+		t.Errorf("Expected default history, got %d", got)
+	}
+	if got := (config.JobsConfig{History: -1}).HistorySize(); got != 0 {
+		t.Errorf("Expected no history, got %d", got)
+	}
+}
+
+func TestFormatTimeout(t *testing.T) {
+	if got := (config.FormatConfig{}).Timeout(); got != config.DefaultFormatTimeout {
+		t.Errorf("Expected default timeout, got %v", got)
+	}
+	if got := (config.FormatConfig{TimeoutMS: 500}).Timeout(); got != 500*time.Millisecond {
+		t.Errorf("Expected 500ms, got %v", got)
+	}
+}
+
+func TestDiagnosticsTimeout(t *testing.T) {
+	if got := (config.DiagnosticsConfig{}).Timeout(); got != config.DefaultDiagnosticsTimeout {
+		t.Errorf("Expected default timeout, got %v", got)
+	}
+	if got := (config.DiagnosticsConfig{TimeoutMS: 1500}).Timeout(); got != 1500*time.Millisecond {
+		t.Errorf("Expected 1.5s, got %v", got)
+	}
+}
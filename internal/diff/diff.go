@@ -0,0 +1,90 @@
+// Package diff computes line diffs between versions of a document, as
+// hunks of replaced lines.
+package diff
+
+import "slices"
+
+// maxCells bounds the table of the longest common subsequence search.
+// Larger changed regions become a single hunk.
+const maxCells = 1 << 22
+
+// Hunk replaces the lines Old, starting at line OldStart of the old text,
+// with the lines New, starting at line NewStart of the new text. Lines are
+// 0-indexed.
+type Hunk struct {
+	OldStart int
+	Old      []string
+	NewStart int
+	New      []string
+}
+
+// Lines returns the hunks turning a into b, in order. Changes separated by
+// an unchanged line are separate hunks.
+func Lines(a, b []string) []Hunk {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	if len(a)*len(b) > maxCells {
+		return []Hunk{{OldStart: prefix, Old: slices.Clone(a), NewStart: prefix, New: slices.Clone(b)}}
+	}
+
+	// lcs[i*(m+1)+j] is the length of the longest common subsequence of
+	// a[i:] and b[j:]
+	n, m := len(a), len(b)
+	lcs := make([]int32, (n+1)*(m+1))
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+			} else {
+				lcs[i*(m+1)+j] = max(lcs[(i+1)*(m+1)+j], lcs[i*(m+1)+j+1])
+			}
+		}
+	}
+
+	var hunks []Hunk
+	var h *Hunk
+	i, j := 0, 0
+	for i < n || j < m {
+		if i < n && j < m && a[i] == b[j] {
+			h = nil
+			i++
+			j++
+			continue
+		}
+		if h == nil {
+			hunks = append(hunks, Hunk{OldStart: prefix + i, NewStart: prefix + j})
+			h = &hunks[len(hunks)-1]
+		}
+		if j == m || (i < n && lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]) {
+			h.Old = append(h.Old, a[i])
+			i++
+		} else {
+			h.New = append(h.New, b[j])
+			j++
+		}
+	}
+	return hunks
+}
+
+// Apply returns lines with hunks applied. The hunks must be in order, not
+// overlap, and have OldStart relative to lines.
+func Apply(lines []string, hunks []Hunk) []string {
+	var out []string
+	next := 0
+	for _, h := range hunks {
+		out = append(out, lines[next:h.OldStart]...)
+		out = append(out, h.New...)
+		next = h.OldStart + len(h.Old)
+	}
+	return append(out, lines[next:]...)
+}
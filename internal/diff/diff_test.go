@@ -0,0 +1,34 @@
+package diff_test
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/diff"
+)
+
+func TestLines(t *testing.T) {
+	a := strings.Split("package a\n\nfunc A() {}\n\nfunc B() {}\n\nfunc C() {}\n", "\n")
+	b := strings.Split("package a\n\nfunc A() int { return 1 }\n\nfunc C() {}\n\nfunc D() {}\n", "\n")
+
+	want := []diff.Hunk{
+		{OldStart: 2, Old: []string{"func A() {}", "", "func B() {}"}, NewStart: 2, New: []string{"func A() int { return 1 }"}},
+		{OldStart: 7, NewStart: 5, New: []string{"", "func D() {}"}},
+	}
+	got := diff.Lines(a, b)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Lines() =\n%+v\nwant\n%+v", got, want)
+	}
+	if applied := diff.Apply(a, got); !slices.Equal(applied, b) {
+		t.Errorf("Apply() = %q, want %q", applied, b)
+	}
+
+	if got := diff.Lines(a, a); got != nil {
+		t.Errorf("Expected no hunks for equal texts, got %+v", got)
+	}
+	if got := diff.Lines(nil, []string{"x"}); len(got) != 1 || got[0].OldStart != 0 || got[0].New[0] != "x" {
+		t.Errorf("Expected one insertion, got %+v", got)
+	}
+}
@@ -0,0 +1,259 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// context is how many unchanged lines surround each hunk of a unified diff.
+const context = 3
+
+// noEOL ends the last line of a text with no newline after it, so that
+// adding or removing the newline is a change.
+const noEOL = "\x00"
+
+// noEOLMarker follows a line with no newline after it in a unified diff.
+const noEOLMarker = `\ No newline at end of file`
+
+// split returns the lines of text, marking a last line with no newline.
+func split(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += noEOL
+	return lines
+}
+
+// join is the inverse of split.
+func join(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	text := strings.Join(lines, "\n")
+	if t, ok := strings.CutSuffix(text, noEOL); ok {
+		return t
+	}
+	return text + "\n"
+}
+
+// Unified returns the unified diff turning oldText, at oldPath, into
+// newText, at newPath, or "" if they are equal. An empty path is
+// /dev/null, for a created or deleted file.
+func Unified(oldPath, newPath, oldText, newText string) string {
+	a, b := split(oldText), split(newText)
+	hunks := Lines(a, b)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", header("a/", oldPath), header("b/", newPath))
+	for i := 0; i < len(hunks); {
+		// Hunks whose context would touch share a header
+		j := i + 1
+		for j < len(hunks) && hunks[j].OldStart-(hunks[j-1].OldStart+len(hunks[j-1].Old)) <= 2*context {
+			j++
+		}
+		first, last := hunks[i], hunks[j-1]
+		oldStart := max(first.OldStart-context, 0)
+		oldEnd := min(last.OldStart+len(last.Old)+context, len(a))
+		newStart := first.NewStart - (first.OldStart - oldStart)
+		newEnd := last.NewStart + len(last.New) + oldEnd - (last.OldStart + len(last.Old))
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", span(oldStart, oldEnd-oldStart), span(newStart, newEnd-newStart))
+
+		next := oldStart
+		for _, h := range hunks[i:j] {
+			writeLines(&sb, ' ', a[next:h.OldStart])
+			writeLines(&sb, '-', h.Old)
+			writeLines(&sb, '+', h.New)
+			next = h.OldStart + len(h.Old)
+		}
+		writeLines(&sb, ' ', a[next:oldEnd])
+		i = j
+	}
+	return sb.String()
+}
+
+// header returns the file header naming path.
+func header(prefix, path string) string {
+	if path == "" {
+		return "/dev/null"
+	}
+	return prefix + path
+}
+
+// span formats the range of n lines from 0-indexed line start as a hunk
+// header does: 1-indexed, and naming the line before an empty range.
+func span(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// writeLines writes lines to sb, each after prefix.
+func writeLines(sb *strings.Builder, prefix byte, lines []string) {
+	for _, line := range lines {
+		sb.WriteByte(prefix)
+		if text, ok := strings.CutSuffix(line, noEOL); ok {
+			sb.WriteString(text + "\n" + noEOLMarker + "\n")
+			continue
+		}
+		sb.WriteString(line + "\n")
+	}
+}
+
+// File is the change to one file in a unified diff. The Old and New lines
+// of its hunks include their context.
+type File struct {
+	OldPath string // Empty for a created file
+	NewPath string // Empty for a deleted file
+	Hunks   []Hunk
+}
+
+// Parse reads the files of a unified diff, as written by Unified, diff -u
+// or git diff. Lines outside the files' headers and hunks are ignored, and
+// the a/ and b/ prefixes are removed from paths.
+func Parse(patch string) ([]File, error) {
+	var files []File
+	var f *File
+	var h *Hunk
+	var oldLeft, newLeft int
+	var last byte // The kind of the last hunk line, for noEOLMarker
+
+	for n, line := range strings.Split(patch, "\n") {
+		if h != nil && (oldLeft > 0 || newLeft > 0) {
+			kind, text := byte(' '), "" // Some tools drop the space of empty context lines
+			if line != "" {
+				kind, text = line[0], line[1:]
+			}
+			switch {
+			case kind == ' ' && oldLeft > 0 && newLeft > 0:
+				h.Old = append(h.Old, text)
+				h.New = append(h.New, text)
+				oldLeft--
+				newLeft--
+			case kind == '-' && oldLeft > 0:
+				h.Old = append(h.Old, text)
+				oldLeft--
+			case kind == '+' && newLeft > 0:
+				h.New = append(h.New, text)
+				newLeft--
+			case kind == '\\':
+				markNoEOL(h, last)
+				continue
+			default:
+				return nil, fmt.Errorf("line %d: unexpected %q in hunk", n+1, line)
+			}
+			last = kind
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, `\`) && h != nil:
+			markNoEOL(h, last)
+		case strings.HasPrefix(line, "--- "):
+			files = append(files, File{OldPath: parsePath(line[4:], "a/")})
+			f, h = &files[len(files)-1], nil
+		case strings.HasPrefix(line, "+++ "):
+			if f == nil || h != nil {
+				return nil, fmt.Errorf("line %d: +++ without ---", n+1)
+			}
+			f.NewPath = parsePath(line[4:], "b/")
+		case strings.HasPrefix(line, "@@ "):
+			if f == nil {
+				return nil, fmt.Errorf("line %d: hunk outside a file", n+1)
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[3] != "@@" {
+				return nil, fmt.Errorf("line %d: invalid hunk header", n+1)
+			}
+			oldStart, oldCount, err := parseSpan(fields[1], "-")
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			newStart, newCount, err := parseSpan(fields[2], "+")
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			f.Hunks = append(f.Hunks, Hunk{OldStart: oldStart, NewStart: newStart})
+			h = &f.Hunks[len(f.Hunks)-1]
+			oldLeft, newLeft = oldCount, newCount
+		}
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return nil, errors.New("patch ends inside a hunk")
+	}
+	for _, f := range files {
+		if f.OldPath == "" && f.NewPath == "" {
+			return nil, errors.New("file without a path")
+		}
+	}
+	return files, nil
+}
+
+// markNoEOL marks the last line of kind in h as having no newline.
+func markNoEOL(h *Hunk, kind byte) {
+	if (kind == ' ' || kind == '-') && len(h.Old) > 0 {
+		h.Old[len(h.Old)-1] += noEOL
+	}
+	if (kind == ' ' || kind == '+') && len(h.New) > 0 {
+		h.New[len(h.New)-1] += noEOL
+	}
+}
+
+// parsePath returns the path of a file header, without prefix or a
+// timestamp, or "" for /dev/null.
+func parsePath(s, prefix string) string {
+	s, _, _ = strings.Cut(s, "\t")
+	if s == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(s, prefix)
+}
+
+// parseSpan is the inverse of span, for a range after sign.
+func parseSpan(s, sign string) (start, n int, err error) {
+	s, ok := strings.CutPrefix(s, sign)
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	first, count, hasCount := strings.Cut(s, ",")
+	start, err = strconv.Atoi(first)
+	n = 1
+	if err == nil && hasCount {
+		n, err = strconv.Atoi(count)
+	}
+	if err != nil || start < 0 || n < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", sign+s)
+	}
+	if n > 0 {
+		start--
+	}
+	return max(start, 0), n, nil
+}
+
+// Apply returns text with the hunks of f applied. Each hunk's old lines
+// must be at its line in text; the patch is not applied at an offset.
+func (f File) Apply(text string) (string, error) {
+	lines := split(text)
+	next := 0
+	for i, h := range f.Hunks {
+		end := h.OldStart + len(h.Old)
+		if h.OldStart < next || end > len(lines) || !slices.Equal(lines[h.OldStart:end], h.Old) {
+			return "", fmt.Errorf("hunk %d does not apply at line %d", i+1, h.OldStart+1)
+		}
+		next = end
+	}
+	return join(Apply(lines, f.Hunks)), nil
+}
@@ -0,0 +1,78 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/diff"
+)
+
+func TestUnified(t *testing.T) {
+	oldText := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	newText := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn"
+
+	want := `--- a/x.txt
++++ b/x.txt
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+\ No newline at end of file
+`
+	got := diff.Unified("x.txt", "x.txt", oldText, newText)
+	if got != want {
+		t.Fatalf("Unified() =\n%s\nwant\n%s", got, want)
+	}
+	if got := diff.Unified("x.txt", "x.txt", oldText, oldText); got != "" {
+		t.Errorf("Expected no diff for equal texts, got %q", got)
+	}
+
+	files, err := diff.Parse("diff --git a/x.txt b/x.txt\nindex 1..2 100644\n" + got)
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if len(files) != 1 || files[0].OldPath != "x.txt" || files[0].NewPath != "x.txt" || len(files[0].Hunks) != 2 {
+		t.Fatalf("Parse() = %+v", files)
+	}
+	applied, err := files[0].Apply(oldText)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	if applied != newText {
+		t.Errorf("Apply() = %q, want %q", applied, newText)
+	}
+	if _, err := files[0].Apply("a\nb\n"); err == nil {
+		t.Error("Expected an error applying to a different text")
+	}
+}
+
+func TestUnifiedCreate(t *testing.T) {
+	patch := diff.Unified("", "new.go", "", "package x\n")
+	files, err := diff.Parse(patch)
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if len(files) != 1 || files[0].OldPath != "" || files[0].NewPath != "new.go" {
+		t.Fatalf("Parse() = %+v", files)
+	}
+	if got, err := files[0].Apply(""); err != nil || got != "package x\n" {
+		t.Errorf("Apply() = %q, %v", got, err)
+	}
+
+	// The newline at the end is a change of its own
+	patch = diff.Unified("x", "x", "a\nb", "a\nb\n")
+	files, err = diff.Parse(patch)
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got, err := files[0].Apply("a\nb"); err != nil || got != "a\nb\n" {
+		t.Errorf("Apply() = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,161 @@
+// Package errorformat finds errors and warnings in the output of build and
+// test tools, in the spirit of Vim's 'errorformat'. It understands the
+// compiler and test output of Go, TypeScript (tsc) and Rust (cargo), and
+// the common "file:line:col: message" form of other tools.
+package errorformat
+
+import (
+	"cmp"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severities.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Entry is one problem found in tool output.
+type Entry struct {
+	File     string // As printed: relative to the tool's directory (or package), or absolute
+	Line     int    // 1-indexed; 0 if unknown
+	Column   int    // 1-indexed; 0 if unknown
+	Severity string // SeverityError or SeverityWarning
+	Message  string
+}
+
+var (
+	// ansi matches terminal color sequences, which tools configured to
+	// always use color print even when piped.
+	ansi = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+	// tscPlain is tsc's default output: src/app.ts(12,5): error TS2322: ...
+	tscPlain = regexp.MustCompile(`^(.+?)\((\d+),(\d+)\): (error|warning) (TS\d+: .*)$`)
+	// tscPretty is tsc's --pretty output: src/app.ts:12:5 - error TS2322: ...
+	tscPretty = regexp.MustCompile(`^(.+?):(\d+):(\d+) - (error|warning) (TS\d+: .*)$`)
+
+	// cargoHeader starts a rustc diagnostic: error[E0425]: cannot find ...
+	cargoHeader = regexp.MustCompile(`^(error|warning)(?:\[\w+\])?: (.*)$`)
+	// cargoLocation places the diagnostic started by cargoHeader:  --> src/main.rs:2:13
+	cargoLocation = regexp.MustCompile(`^\s*--> (.+?):(\d+):(\d+)$`)
+	// cargoPanic is a failing Rust test: thread 'tests::it' panicked at src/lib.rs:10:5:
+	cargoPanic = regexp.MustCompile(`^thread '.*' panicked at (.+?):(\d+):(\d+):?$`)
+
+	// goTest is a t.Error or t.Fatal line, indented under its test and
+	// relative to its package:     parse_test.go:23: got 1, want 2
+	goTest = regexp.MustCompile(`^(\s+)([^\s:]+_test\.go):(\d+): (.*)$`)
+	// generic is "file:line:col: message", as printed by the Go compiler,
+	// go vet, gcc, clang and many linters. An "error:" or "warning:"
+	// after the position sets the severity; notes are skipped.
+	generic = regexp.MustCompile(`^([^\s:]+\.[A-Za-z0-9]+):(\d+):(\d+): (?:(error|warning|note): )?(.*)$`)
+)
+
+// Parser collects entries from tool output fed to it line by line. Some
+// problems span several lines, so it keeps what it has read of them.
+type Parser struct {
+	entries []Entry
+
+	header     *Entry // A cargo diagnostic awaiting its location
+	panicked   *Entry // A Rust panic awaiting its message
+	continuing bool   // The last entry is a go test failure whose message may go on
+	goIndent   int    // Indent of that failure's first line
+	maxEntries int
+}
+
+// New returns a parser that keeps at most maxEntries entries (0 = all).
+func New(maxEntries int) *Parser {
+	return &Parser{maxEntries: maxEntries}
+}
+
+// Feed parses the next line of output.
+func (p *Parser) Feed(line string) {
+	line = ansi.ReplaceAllString(strings.TrimRight(line, "\r"), "")
+
+	if p.panicked != nil {
+		if strings.TrimSpace(line) == "" {
+			return
+		}
+		p.panicked.Message = strings.TrimSpace(line)
+		p.add(*p.panicked)
+		p.panicked = nil
+		return
+	}
+	if p.continuing {
+		if indent := len(line) - len(strings.TrimLeft(line, " \t")); indent > p.goIndent && strings.TrimSpace(line) != "" && !goTest.MatchString(line) {
+			last := &p.entries[len(p.entries)-1]
+			last.Message += "\n" + strings.TrimSpace(line)
+			return
+		}
+		p.continuing = false
+	}
+
+	if m := tscPlain.FindStringSubmatch(line); m != nil {
+		p.add(entry(m[1], m[2], m[3], m[4], m[5]))
+		return
+	}
+	if m := tscPretty.FindStringSubmatch(line); m != nil {
+		p.add(entry(m[1], m[2], m[3], m[4], m[5]))
+		return
+	}
+	if m := cargoLocation.FindStringSubmatch(line); m != nil {
+		if p.header != nil {
+			e := *p.header
+			e.File, e.Line, e.Column = m[1], atoi(m[2]), atoi(m[3])
+			p.add(e)
+			p.header = nil
+		}
+		return
+	}
+	if m := cargoHeader.FindStringSubmatch(line); m != nil {
+		p.header = &Entry{Severity: m[1], Message: m[2]}
+		return
+	}
+	if m := cargoPanic.FindStringSubmatch(line); m != nil {
+		e := entry(m[1], m[2], m[3], SeverityError, "")
+		p.panicked = &e
+		return
+	}
+	if m := goTest.FindStringSubmatch(line); m != nil {
+		p.continuing = p.add(entry(m[2], m[3], "", SeverityError, m[4]))
+		p.goIndent = len(m[1])
+		return
+	}
+	if m := generic.FindStringSubmatch(line); m != nil && m[4] != "note" {
+		p.add(entry(m[1], m[2], m[3], cmp.Or(m[4], SeverityError), m[5]))
+	}
+}
+
+// Entries returns the problems found so far, in output order.
+func (p *Parser) Entries() []Entry {
+	entries := p.entries
+	if p.panicked != nil && (p.maxEntries == 0 || len(entries) < p.maxEntries) {
+		entries = append(entries[:len(entries):len(entries)], *p.panicked)
+	}
+	return entries
+}
+
+// add keeps e unless the parser is full. It reports whether e was kept.
+func (p *Parser) add(e Entry) bool {
+	if p.maxEntries > 0 && len(p.entries) >= p.maxEntries {
+		return false
+	}
+	p.entries = append(p.entries, e)
+	return true
+}
+
+func entry(file, line, col, severity, message string) Entry {
+	return Entry{
+		File:     file,
+		Line:     atoi(line),
+		Column:   atoi(col),
+		Severity: severity,
+		Message:  message,
+	}
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,77 @@
+package errorformat_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/errorformat"
+)
+
+func parse(output string, maxEntries int) []errorformat.Entry {
+	p := errorformat.New(maxEntries)
+	for _, line := range strings.Split(output, "\n") {
+		p.Feed(line)
+	}
+	return p.Entries()
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		output string
+		want   []errorformat.Entry
+	}{
+		"go build": {
+			output: "# example.com/app\n./main.go:12:5: undefined: foo\n./main.go:14:2: declared and not used: x",
+			want: []errorformat.Entry{
+				{File: "./main.go", Line: 12, Column: 5, Severity: "error", Message: "undefined: foo"},
+				{File: "./main.go", Line: 14, Column: 2, Severity: "error", Message: "declared and not used: x"},
+			},
+		},
+		"go test": {
+			output: "--- FAIL: TestParse (0.00s)\n    parse_test.go:23: got 1\n        want 2\n    --- FAIL: TestParse/empty (0.00s)\n        parse_test.go:30: unexpected error\nFAIL\nFAIL\texample.com/app\t0.01s",
+			want: []errorformat.Entry{
+				{File: "parse_test.go", Line: 23, Severity: "error", Message: "got 1\nwant 2"},
+				{File: "parse_test.go", Line: 30, Severity: "error", Message: "unexpected error"},
+			},
+		},
+		"tsc": {
+			output: "src/app.ts(12,5): error TS2322: Type 'string' is not assignable to type 'number'.\n\x1b[96msrc/util.ts\x1b[0m:\x1b[93m3\x1b[0m:\x1b[93m1\x1b[0m - \x1b[91merror\x1b[0m TS1005: ';' expected.",
+			want: []errorformat.Entry{
+				{File: "src/app.ts", Line: 12, Column: 5, Severity: "error", Message: "TS2322: Type 'string' is not assignable to type 'number'."},
+				{File: "src/util.ts", Line: 3, Column: 1, Severity: "error", Message: "TS1005: ';' expected."},
+			},
+		},
+		"cargo": {
+			output: "warning: unused variable: `y`\n --> src/lib.rs:4:9\n  |\nerror[E0425]: cannot find value `x` in this scope\n --> src/main.rs:2:13\nerror: aborting due to 1 previous error",
+			want: []errorformat.Entry{
+				{File: "src/lib.rs", Line: 4, Column: 9, Severity: "warning", Message: "unused variable: `y`"},
+				{File: "src/main.rs", Line: 2, Column: 13, Severity: "error", Message: "cannot find value `x` in this scope"},
+			},
+		},
+		"cargo test": {
+			output: "thread 'tests::it_works' panicked at src/lib.rs:10:5:\nassertion `left == right` failed\nnote: run with `RUST_BACKTRACE=1`",
+			want: []errorformat.Entry{
+				{File: "src/lib.rs", Line: 10, Column: 5, Severity: "error", Message: "assertion `left == right` failed"},
+			},
+		},
+		"generic": {
+			output: "lib.c:3:10: warning: unused parameter 'argc'\nlib.c:1:1: note: declared here\nok   example.com/app 0.01s\n12:00:01: not a file",
+			want: []errorformat.Entry{
+				{File: "lib.c", Line: 3, Column: 10, Severity: "warning", Message: "unused parameter 'argc'"},
+			},
+		},
+	}
+	for name, tt := range tests {
+		if got := parse(tt.output, 0); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", name, got, tt.want)
+		}
+	}
+}
+
+func TestParseMaxEntries(t *testing.T) {
+	got := parse("a.go:1:1: one\na.go:2:1: two\na.go:3:1: three", 2)
+	if len(got) != 2 || got[1].Message != "two" {
+		t.Errorf("Expected the first two entries, got %+v", got)
+	}
+}
@@ -0,0 +1,382 @@
+// Package inspect pretty-prints captured daemon traffic: one header line
+// per message, edits decoded into readable hunks, document bodies hidden
+// unless asked for, and protocol anomalies (error responses, unanswered or
+// unexpected responses, duplicate IDs) flagged with "!!".
+package inspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/capture"
+	"github.com/taigrr/neocrush/internal/history"
+)
+
+// DefaultTimeout is how long a request may wait for its response before it
+// is reported as unanswered.
+const DefaultTimeout = 10 * time.Second
+
+// Options control the output.
+type Options struct {
+	Bodies  bool          // Show document contents instead of their size
+	Timeout time.Duration // Unanswered-request threshold (0 = DefaultTimeout)
+}
+
+type pendingKey struct {
+	client string
+	dir    string // Direction the request traveled
+	id     string
+}
+
+type pending struct {
+	method   string
+	at       time.Time
+	reported bool
+}
+
+// Inspector prints records in order and tracks requests awaiting responses.
+type Inspector struct {
+	out     io.Writer
+	opts    Options
+	pending map[pendingKey]*pending
+
+	messages  int
+	anomalies int
+}
+
+// New returns an inspector printing to out.
+func New(out io.Writer, opts Options) *Inspector {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &Inspector{out: out, opts: opts, pending: make(map[pendingKey]*pending)}
+}
+
+// message is the part of a JSON-RPC message the inspector looks at.
+type message struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Print writes one record, then any requests that became overdue by its time.
+func (in *Inspector) Print(r capture.Record) {
+	in.messages++
+	client := r.Client
+	if client == "" {
+		client = "?"
+	}
+	route := client + " → daemon"
+	if r.Dir == capture.Send {
+		route = "daemon → " + client
+	}
+	stamp := r.Time.Local().Format("15:04:05.000")
+
+	var msg message
+	if err := json.Unmarshal(r.Message, &msg); err != nil {
+		fmt.Fprintf(in.out, "%s  %s  ???\n", stamp, route)
+		in.anomaly("malformed message: %v", err)
+		return
+	}
+	id := string(msg.ID)
+	if id == "null" {
+		id = ""
+	}
+
+	switch {
+	case msg.Method != "" && id != "":
+		fmt.Fprintf(in.out, "%s  %s  %s #%s\n", stamp, route, msg.Method, id)
+		key := pendingKey{client: r.Client, dir: r.Dir, id: id}
+		if p, ok := in.pending[key]; ok {
+			in.anomaly("duplicate request #%s (%s still pending)", id, p.method)
+		}
+		in.pending[key] = &pending{method: msg.Method, at: r.Time}
+		in.details(msg.Method, msg.Params)
+
+	case msg.Method != "":
+		fmt.Fprintf(in.out, "%s  %s  %s\n", stamp, route, msg.Method)
+		in.details(msg.Method, msg.Params)
+
+	default:
+		requestDir := capture.Recv
+		if r.Dir == capture.Recv {
+			requestDir = capture.Send
+		}
+		key := pendingKey{client: r.Client, dir: requestDir, id: id}
+		p, ok := in.pending[key]
+		delete(in.pending, key)
+
+		if !ok {
+			fmt.Fprintf(in.out, "%s  %s  response #%s\n", stamp, route, id)
+			in.anomaly("unexpected response: no pending request #%s", id)
+		} else {
+			elapsed := r.Time.Sub(p.at)
+			fmt.Fprintf(in.out, "%s  %s  response #%s to %s (%s)\n", stamp, route, id, p.method, formatDuration(elapsed))
+			if elapsed > in.opts.Timeout && !p.reported {
+				in.anomaly("slow response: took %s", formatDuration(elapsed))
+			}
+		}
+		if msg.Error != nil {
+			in.anomaly("error %d: %s", msg.Error.Code, msg.Error.Message)
+		} else if len(msg.Result) > 0 && string(msg.Result) != "null" {
+			in.line("result", msg.Result)
+		}
+	}
+
+	in.Check(r.Time)
+}
+
+// Check reports requests that have waited longer than the timeout at now.
+// Each request is reported once.
+func (in *Inspector) Check(now time.Time) {
+	var overdue []pendingKey
+	for key, p := range in.pending {
+		if !p.reported && now.Sub(p.at) > in.opts.Timeout {
+			overdue = append(overdue, key)
+		}
+	}
+	sortKeys(overdue, in.pending)
+
+	for _, key := range overdue {
+		p := in.pending[key]
+		p.reported = true
+		in.anomalies++
+		fmt.Fprintf(in.out, "!! unanswered: %s #%s %s (waiting %s)\n", p.method, key.id, describe(key), formatDuration(now.Sub(p.at)))
+	}
+}
+
+// Summary prints totals and the requests still waiting for a response.
+func (in *Inspector) Summary() {
+	keys := make([]pendingKey, 0, len(in.pending))
+	for key := range in.pending {
+		keys = append(keys, key)
+	}
+	sortKeys(keys, in.pending)
+
+	fmt.Fprintf(in.out, "\n%d messages, %d anomalies", in.messages, in.anomalies)
+	if len(keys) > 0 {
+		fmt.Fprintf(in.out, ", %d requests without a response", len(keys))
+	}
+	fmt.Fprintln(in.out)
+	for _, key := range keys {
+		p := in.pending[key]
+		fmt.Fprintf(in.out, "    %s #%s %s\n", p.method, key.id, describe(key))
+	}
+}
+
+// Anomalies returns how many anomalies were reported.
+func (in *Inspector) Anomalies() int {
+	return in.anomalies
+}
+
+func sortKeys(keys []pendingKey, pending map[pendingKey]*pending) {
+	sort.Slice(keys, func(i, j int) bool {
+		return pending[keys[i]].at.Before(pending[keys[j]].at)
+	})
+}
+
+func describe(key pendingKey) string {
+	if key.dir == capture.Send {
+		return "to " + key.client
+	}
+	return "from " + key.client
+}
+
+// anomaly flags a problem with the message just printed.
+func (in *Inspector) anomaly(format string, args ...any) {
+	in.anomalies++
+	fmt.Fprintf(in.out, "    !! "+format+"\n", args...)
+}
+
+// details prints the interesting part of a request or notification.
+func (in *Inspector) details(method string, params json.RawMessage) {
+	switch method {
+	case "textDocument/didChange":
+		in.didChange(params)
+	case "workspace/applyEdit":
+		in.applyEdit(params)
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI     string `json:"uri"`
+				Version int    `json:"version"`
+				Text    string `json:"text"`
+			} `json:"textDocument"`
+		}
+		_ = json.Unmarshal(params, &p)
+		fmt.Fprintf(in.out, "    %s v%d\n", p.TextDocument.URI, p.TextDocument.Version)
+		in.body(p.TextDocument.Text)
+	case "crush/writeFile":
+		var p struct {
+			Path    string `json:"path"`
+			Content string `json:"content"`
+			Create  bool   `json:"create"`
+		}
+		_ = json.Unmarshal(params, &p)
+		verb := "write"
+		if p.Create {
+			verb = "create"
+		}
+		fmt.Fprintf(in.out, "    %s %s\n", verb, p.Path)
+		in.body(p.Content)
+	default:
+		if len(params) > 0 && string(params) != "{}" && string(params) != "null" {
+			in.line("params", params)
+		}
+	}
+}
+
+// line prints a compact JSON value, secrets masked and (unless bodies are
+// shown) long strings replaced by their size.
+func (in *Inspector) line(label string, value json.RawMessage) {
+	shown := history.Redact(value)
+	if in.opts.Bodies {
+		shown = history.RedactSecrets(value)
+	}
+	if shown == nil {
+		shown = value
+	}
+	fmt.Fprintf(in.out, "    %s: %s\n", label, shown)
+}
+
+// body prints document content, or just its size.
+func (in *Inspector) body(text string) {
+	if !in.opts.Bodies {
+		fmt.Fprintf(in.out, "    [%s]\n", size(text))
+		return
+	}
+	for _, l := range splitLines(text) {
+		fmt.Fprintf(in.out, "    | %s\n", l)
+	}
+}
+
+type lspRange struct {
+	Start struct{ Line, Character int } `json:"start"`
+	End   struct{ Line, Character int } `json:"end"`
+}
+
+type textEdit struct {
+	Range   *lspRange `json:"range"`
+	NewText string    `json:"newText"`
+	Text    string    `json:"text"` // didChange content changes use "text"
+}
+
+func (in *Inspector) didChange(params json.RawMessage) {
+	var p struct {
+		TextDocument struct {
+			URI     string `json:"uri"`
+			Version int    `json:"version"`
+		} `json:"textDocument"`
+		ContentChanges []textEdit `json:"contentChanges"`
+	}
+	_ = json.Unmarshal(params, &p)
+	fmt.Fprintf(in.out, "    %s v%d\n", p.TextDocument.URI, p.TextDocument.Version)
+	for _, change := range p.ContentChanges {
+		in.hunk(change.Range, change.Text)
+	}
+}
+
+func (in *Inspector) applyEdit(params json.RawMessage) {
+	var p struct {
+		Label string `json:"label"`
+		Edit  struct {
+			Changes         map[string][]textEdit `json:"changes"`
+			DocumentChanges []struct {
+				TextDocument struct {
+					URI string `json:"uri"`
+				} `json:"textDocument"`
+				Edits []textEdit `json:"edits"`
+				Kind  string     `json:"kind"`
+				URI   string     `json:"uri"`
+			} `json:"documentChanges"`
+		} `json:"edit"`
+	}
+	_ = json.Unmarshal(params, &p)
+	if p.Label != "" {
+		fmt.Fprintf(in.out, "    %q\n", p.Label)
+	}
+
+	uris := make([]string, 0, len(p.Edit.Changes))
+	for uri := range p.Edit.Changes {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		fmt.Fprintf(in.out, "    --- %s\n", uri)
+		for _, edit := range p.Edit.Changes[uri] {
+			in.hunk(edit.Range, edit.NewText)
+		}
+	}
+	for _, change := range p.Edit.DocumentChanges {
+		if change.Kind != "" {
+			fmt.Fprintf(in.out, "    %s %s\n", change.Kind, change.URI)
+			continue
+		}
+		fmt.Fprintf(in.out, "    --- %s\n", change.TextDocument.URI)
+		for _, edit := range change.Edits {
+			in.hunk(edit.Range, edit.NewText)
+		}
+	}
+}
+
+// hunk prints one edit: the replaced range and the new text as "+" lines.
+// A change without a range replaces the whole document.
+func (in *Inspector) hunk(r *lspRange, text string) {
+	if r == nil {
+		fmt.Fprintln(in.out, "    @@ whole document @@")
+		in.body(text)
+		return
+	}
+	fmt.Fprintf(in.out, "    @@ %d:%d-%d:%d @@\n", r.Start.Line+1, r.Start.Character, r.End.Line+1, r.End.Character)
+	if r.Start != r.End {
+		fmt.Fprintf(in.out, "    - %s\n", span(r))
+	}
+	if text == "" {
+		return
+	}
+	if !in.opts.Bodies && len(text) > maxInlineText {
+		fmt.Fprintf(in.out, "    + [%s]\n", size(text))
+		return
+	}
+	for _, l := range splitLines(text) {
+		fmt.Fprintf(in.out, "    + %s\n", l)
+	}
+}
+
+// maxInlineText is the longest edit text shown without --bodies.
+const maxInlineText = 400
+
+func span(r *lspRange) string {
+	if lines := r.End.Line - r.Start.Line; lines > 0 {
+		return fmt.Sprintf("(%d lines replaced)", lines+1)
+	}
+	return fmt.Sprintf("(%d characters replaced)", r.End.Character-r.Start.Character)
+}
+
+func splitLines(text string) []string {
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func size(text string) string {
+	lines := strings.Count(text, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		lines++
+	}
+	return fmt.Sprintf("%d bytes, %d lines", len(text), lines)
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(100 * time.Microsecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
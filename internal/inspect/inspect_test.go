@@ -0,0 +1,88 @@
+package inspect
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/capture"
+)
+
+func record(at time.Time, dir, client, msg string) capture.Record {
+	return capture.Record{Time: at, Dir: dir, Client: client, Message: json.RawMessage(msg)}
+}
+
+func TestInspector(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	body := strings.Repeat("package main\n", 100)
+
+	records := []capture.Record{
+		record(at(0), capture.Recv, "neovim", `{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{"authToken":"hunter2"}}`),
+		record(at(1), capture.Send, "crush", `{"jsonrpc":"2.0","id":7,"method":"textDocument/hover","params":{}}`),
+		record(at(20), capture.Recv, "crush", `{"jsonrpc":"2.0","id":7,"result":{"contents":"docs"}}`),
+		record(at(21), capture.Send, "neovim", `{"jsonrpc":"2.0","id":1,"result":{"contents":"docs"}}`),
+		record(at(30), capture.Recv, "crush", `{"jsonrpc":"2.0","method":"textDocument/didChange","params":{
+			"textDocument":{"uri":"file:///w/main.go","version":3},
+			"contentChanges":[{"range":{"start":{"line":4,"character":0},"end":{"line":4,"character":5}},"text":"hello\nworld"},{"text":`+jsonString(body)+`}]}}`),
+		record(at(40), capture.Send, "neovim", `{"jsonrpc":"2.0","id":8,"method":"workspace/applyEdit","params":{"label":"Crush edit",
+			"edit":{"documentChanges":[{"textDocument":{"uri":"file:///w/a.go"},"edits":[{"range":{"start":{"line":0,"character":0},"end":{"line":2,"character":0}},"newText":""}]}]}}}`),
+		record(at(50), capture.Recv, "neovim", `{"jsonrpc":"2.0","id":8,"error":{"code":-32603,"message":"buffer locked"}}`),
+		record(at(60), capture.Recv, "neovim", `{"jsonrpc":"2.0","id":99,"result":null}`),
+		record(at(70), capture.Recv, "mcp", `{"jsonrpc":"2.0","id":2,"method":"crush/readFile","params":{"path":"a.go"}}`),
+		record(at(70), capture.Recv, "mcp", `{"jsonrpc":"2.0","id":2,"method":"crush/readFile","params":{"path":"b.go"}}`),
+		record(at(20000), capture.Recv, "neovim", `{"jsonrpc":"2.0","method":"crush/cursorMoved","params":{"line":1}}`),
+	}
+
+	var out strings.Builder
+	in := New(&out, Options{})
+	for _, r := range records {
+		in.Print(r)
+	}
+	in.Summary()
+	got := out.String()
+
+	for _, want := range []string{
+		"neovim → daemon  textDocument/hover #1",
+		`params: {"authToken":"[redacted]"}`,
+		"crush → daemon  response #7 to textDocument/hover (19ms)",
+		"@@ 5:0-5:5 @@",
+		"- (5 characters replaced)",
+		"+ hello\n    + world",
+		"@@ whole document @@\n    [1300 bytes, 100 lines]",
+		"--- file:///w/a.go",
+		"- (3 lines replaced)",
+		"!! error -32603: buffer locked",
+		"!! unexpected response: no pending request #99",
+		"!! duplicate request #2 (crush/readFile still pending)",
+		"!! unanswered: crush/readFile #2 from mcp",
+		"11 messages, 4 anomalies, 1 requests without a response",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected output to contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "hunter2") {
+		t.Error("Expected the auth token to be redacted")
+	}
+	if in.Anomalies() != 4 {
+		t.Errorf("Expected 4 anomalies, got %d", in.Anomalies())
+	}
+}
+
+func TestInspectorBodies(t *testing.T) {
+	var out strings.Builder
+	in := New(&out, Options{Bodies: true})
+	in.Print(record(time.Now(), capture.Recv, "neovim", `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{
+		"textDocument":{"uri":"file:///w/main.go","version":1,"text":"package main\n\nfunc main() {}\n"}}}`))
+
+	if !strings.Contains(out.String(), "| func main() {}") {
+		t.Errorf("Expected the document body, got:\n%s", out.String())
+	}
+}
+
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -0,0 +1,129 @@
+// Package jobs runs a workspace's test and build commands, streaming their
+// output line by line.
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Job kinds.
+const (
+	KindTest  = "test"
+	KindBuild = "build"
+)
+
+const (
+	// maxLineBytes caps one line of output; the rest of it is dropped.
+	maxLineBytes = 4096
+	// killDelay is how long the output of a cancelled command is drained
+	// before its pipes are closed.
+	killDelay = time.Second
+)
+
+// project is a kind of project recognized by a marker file in its root.
+type project struct {
+	marker string
+	test   []string
+	build  []string
+}
+
+// projects are checked in order; the first whose marker exists wins.
+var projects = []project{
+	{"go.mod", []string{"go", "test", "./..."}, []string{"go", "build", "./..."}},
+	{"Cargo.toml", []string{"cargo", "test"}, []string{"cargo", "build"}},
+	{"package.json", []string{"npm", "test"}, []string{"npm", "run", "build"}},
+	{"pyproject.toml", []string{"python", "-m", "pytest"}, nil},
+	{"Makefile", []string{"make", "test"}, []string{"make"}},
+}
+
+// Detect returns the command that runs kind for the project in root,
+// judged by its marker files, or nil if it recognizes none.
+func Detect(root, kind string) []string {
+	for _, p := range projects {
+		if _, err := os.Stat(filepath.Join(root, p.marker)); err != nil {
+			continue
+		}
+		switch kind {
+		case KindTest:
+			return p.test
+		case KindBuild:
+			return p.build
+		}
+		return nil
+	}
+	return nil
+}
+
+// Run runs command in dir until it exits, calling output with each line
+// it writes to stdout or stderr, in order. Cancelling ctx kills the command
+// and the processes it started. It returns the command's exit code; err is
+// set only if the command could not be started or ctx ended it.
+func Run(ctx context.Context, dir string, command []string, output func(line string)) (exitCode int, err error) {
+	if len(command) == 0 {
+		return -1, errors.New("no command")
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.WaitDelay = killDelay
+	killGroup(cmd)
+
+	// Both streams share one pipe so lines keep the order a terminal shows
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return -1, err
+	}
+
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+
+	r := bufio.NewReader(pr)
+	for {
+		line, err := readLine(r)
+		if line != "" || err == nil {
+			output(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	err = <-waitErr
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return -1, err
+	}
+	return cmd.ProcessState.ExitCode(), nil
+}
+
+// readLine reads one line from r without its line ending, cut to
+// maxLineBytes.
+func readLine(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if b.Len() < maxLineBytes {
+			b.Write(chunk[:min(len(chunk), maxLineBytes-b.Len())])
+		}
+		if err != nil || !isPrefix {
+			return b.String(), err
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package jobs
+
+import "os/exec"
+
+// Process groups are only used on Linux and macOS; elsewhere cancelling a
+// command kills just the command itself.
+
+func killGroup(*exec.Cmd) {}
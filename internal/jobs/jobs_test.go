@@ -0,0 +1,102 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/jobs"
+)
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+	if got := jobs.Detect(root, jobs.KindTest); got != nil {
+		t.Fatalf("Detect() in an empty dir = %v, want nil", got)
+	}
+
+	// go.mod takes precedence over a Makefile
+	for _, name := range []string{"Makefile", "go.mod"} {
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := jobs.Detect(root, jobs.KindTest); !slices.Equal(got, []string{"go", "test", "./..."}) {
+		t.Errorf("Detect(test) = %v", got)
+	}
+	if got := jobs.Detect(root, jobs.KindBuild); !slices.Equal(got, []string{"go", "build", "./..."}) {
+		t.Errorf("Detect(build) = %v", got)
+	}
+	if got := jobs.Detect(root, "lint"); got != nil {
+		t.Errorf("Detect(lint) = %v, want nil", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	var lines []string
+	code, err := jobs.Run(context.Background(), t.TempDir(), []string{"sh", "-c", "echo one; echo two >&2; printf three; exit 3"}, func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+	if !slices.Equal(lines, []string{"one", "two", "three"}) {
+		t.Errorf("output = %q", lines)
+	}
+
+	lines = nil
+	if _, err := jobs.Run(context.Background(), t.TempDir(), []string{"sh", "-c", "head -c 10000 /dev/zero | tr '\\0' x"}, func(line string) {
+		lines = append(lines, line)
+	}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(lines) != 1 || len(lines[0]) != 4096 || strings.Trim(lines[0], "x") != "" {
+		t.Errorf("Expected one line cut to 4096 bytes, got %d lines", len(lines))
+	}
+
+	if _, err := jobs.Run(context.Background(), t.TempDir(), []string{"neocrush-no-such-command"}, func(string) {}); err == nil {
+		t.Error("Expected an error for a missing command")
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		// The background sleep holds the pipe open unless the group is killed
+		_, err := jobs.Run(ctx, t.TempDir(), []string{"sh", "-c", "sleep 30 & echo ready; wait"}, func(line string) {
+			if line == "ready" {
+				close(started)
+			}
+		})
+		done <- err
+	}()
+
+	<-started
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after cancellation")
+	}
+}
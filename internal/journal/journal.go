@@ -0,0 +1,137 @@
+// Package journal keeps a bounded, in-memory record of document changes so
+// an agent rejoining a session can ask what changed recently.
+package journal
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is one recorded change.
+type Entry struct {
+	Time    time.Time
+	Source  string // Who made the change: "crush", "mcp", "resync", ...
+	URI     string
+	Added   int // Lines added
+	Removed int // Lines removed
+}
+
+// FileSummary aggregates the changes to one document.
+type FileSummary struct {
+	URI         string
+	Changes     int
+	Added       int
+	Removed     int
+	Sources     []string // Distinct sources, in order of first change
+	LastChanged time.Time
+}
+
+// Journal is a fixed-size ring of entries, safe for concurrent use. When
+// full, the oldest entry is dropped.
+type Journal struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// New returns a journal holding up to size entries.
+func New(size int) *Journal {
+	return &Journal{entries: make([]Entry, size)}
+}
+
+// Record adds a change. Entries without a time are stamped now.
+func (j *Journal) Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.entries) == 0 {
+		return
+	}
+	j.entries[j.next] = e
+	j.next = (j.next + 1) % len(j.entries)
+	if j.next == 0 {
+		j.full = true
+	}
+}
+
+// Since returns the entries recorded at or after t, oldest first.
+func (j *Journal) Since(t time.Time) []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var ordered []Entry
+	if j.full {
+		ordered = append(ordered, j.entries[j.next:]...)
+	}
+	ordered = append(ordered, j.entries[:j.next]...)
+
+	i := sort.Search(len(ordered), func(i int) bool {
+		return !ordered[i].Time.Before(t)
+	})
+	return ordered[i:]
+}
+
+// Summarize aggregates the entries recorded at or after t per document,
+// most recently changed first.
+func (j *Journal) Summarize(t time.Time) []FileSummary {
+	byURI := map[string]*FileSummary{}
+	var files []*FileSummary
+	for _, e := range j.Since(t) {
+		s, ok := byURI[e.URI]
+		if !ok {
+			s = &FileSummary{URI: e.URI}
+			byURI[e.URI] = s
+			files = append(files, s)
+		}
+		s.Changes++
+		s.Added += e.Added
+		s.Removed += e.Removed
+		s.LastChanged = e.Time
+		if !contains(s.Sources, e.Source) {
+			s.Sources = append(s.Sources, e.Source)
+		}
+	}
+
+	sort.SliceStable(files, func(a, b int) bool {
+		return files[a].LastChanged.After(files[b].LastChanged)
+	})
+	summaries := make([]FileSummary, len(files))
+	for i, s := range files {
+		summaries[i] = *s
+	}
+	return summaries
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// LineStats counts the lines added and removed between oldText and newText,
+// treating everything between the common prefix and suffix as changed.
+func LineStats(oldText, newText string) (added, removed int) {
+	oldLines := strings.Split(oldText, "\n")
+	newLines := strings.Split(newText, "\n")
+
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+	return len(newLines) - prefix - suffix, len(oldLines) - prefix - suffix
+}
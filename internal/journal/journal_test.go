@@ -0,0 +1,58 @@
+package journal_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/journal"
+)
+
+func TestJournalSummarize(t *testing.T) {
+	j := journal.New(3)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	j.Record(journal.Entry{Time: start, Source: "crush", URI: "file:///a.go", Added: 5})
+	j.Record(journal.Entry{Time: start.Add(time.Minute), Source: "crush", URI: "file:///b.go", Added: 1, Removed: 1})
+	j.Record(journal.Entry{Time: start.Add(2 * time.Minute), Source: "mcp", URI: "file:///b.go", Added: 2})
+	j.Record(journal.Entry{Time: start.Add(3 * time.Minute), Source: "crush", URI: "file:///c.go", Removed: 4})
+
+	// The first entry was evicted
+	if got := j.Since(start); len(got) != 3 || got[0].URI != "file:///b.go" {
+		t.Fatalf("Expected the 3 newest entries, got %+v", got)
+	}
+
+	files := j.Summarize(start.Add(90 * time.Second))
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %+v", files)
+	}
+	if files[0].URI != "file:///c.go" || files[0].Removed != 4 {
+		t.Errorf("Expected c.go first, got %+v", files[0])
+	}
+	if b := files[1]; b.URI != "file:///b.go" || b.Changes != 1 || b.Added != 2 || len(b.Sources) != 1 || b.Sources[0] != "mcp" {
+		t.Errorf("Unexpected b.go summary: %+v", b)
+	}
+
+	all := j.Summarize(time.Time{})
+	if b := all[1]; b.Changes != 2 || b.Added != 3 || b.Removed != 1 || len(b.Sources) != 2 {
+		t.Errorf("Unexpected full b.go summary: %+v", b)
+	}
+}
+
+func TestLineStats(t *testing.T) {
+	tests := []struct {
+		old, new       string
+		added, removed int
+	}{
+		{"a\nb\nc\n", "a\nb\nc\n", 0, 0},
+		{"a\nb\nc\n", "a\nx\nc\n", 1, 1},
+		{"a\nc\n", "a\nb1\nb2\nc\n", 2, 0},
+		{"a\nb\nc\n", "a\n", 0, 2},
+		{"", "x\n", 1, 0},
+	}
+	for _, tt := range tests {
+		added, removed := journal.LineStats(tt.old, tt.new)
+		if added != tt.added || removed != tt.removed {
+			t.Errorf("LineStats(%q, %q) = +%d -%d, want +%d -%d", tt.old, tt.new, added, removed, tt.added, tt.removed)
+		}
+	}
+}
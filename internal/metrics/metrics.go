@@ -0,0 +1,163 @@
+// Package metrics keeps per-method traffic statistics for the daemon:
+// message counts and sizes, and how long peers take to answer relayed
+// requests.
+package metrics
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Samples is how many recent latencies are kept per method for percentiles.
+const Samples = 512
+
+// MethodStats summarizes the traffic seen for one method.
+type MethodStats struct {
+	Method   string
+	Count    int   // Messages received
+	Bytes    int64 // Total size of those messages
+	MaxBytes int   // Largest single message
+
+	Responses     int   // Responses to relayed requests
+	ResponseBytes int64 // Total size of those responses
+	Unanswered    int   // Relayed requests with no response in time
+	Invalid       int   // Messages whose params failed schema validation
+	P50, P90, P99 time.Duration
+}
+
+// AvgBytes returns the mean message size.
+func (s MethodStats) AvgBytes() int {
+	if s.Count == 0 {
+		return 0
+	}
+	return int(s.Bytes / int64(s.Count))
+}
+
+type method struct {
+	count         int
+	bytes         int64
+	maxBytes      int
+	responses     int
+	responseBytes int64
+	unanswered    int
+	invalid       int
+	latencies     []time.Duration // Ring of the most recent samples
+	next          int
+}
+
+// Recorder accumulates statistics. It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	started time.Time
+	methods map[string]*method
+}
+
+// New returns an empty recorder.
+func New() *Recorder {
+	return &Recorder{
+		started: time.Now(),
+		methods: make(map[string]*method),
+	}
+}
+
+// Started returns when the recorder was created.
+func (r *Recorder) Started() time.Time {
+	return r.started
+}
+
+func (r *Recorder) method(name string) *method {
+	m := r.methods[name]
+	if m == nil {
+		m = &method{}
+		r.methods[name] = m
+	}
+	return m
+}
+
+// Message records a received request or notification of size bytes.
+func (r *Recorder) Message(name string, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.method(name)
+	m.count++
+	m.bytes += int64(size)
+	m.maxBytes = max(m.maxBytes, size)
+}
+
+// Response records the answer to a request for name, size bytes long,
+// arriving latency after the request was forwarded.
+func (r *Recorder) Response(name string, size int, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.method(name)
+	m.responses++
+	m.responseBytes += int64(size)
+	if len(m.latencies) < Samples {
+		m.latencies = append(m.latencies, latency)
+		return
+	}
+	m.latencies[m.next] = latency
+	m.next = (m.next + 1) % Samples
+}
+
+// Unanswered records a request for name that got no response in time.
+func (r *Recorder) Unanswered(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.method(name).unanswered++
+}
+
+// Invalid records a message for name whose params failed validation.
+func (r *Recorder) Invalid(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.method(name).invalid++
+}
+
+// Snapshot returns the statistics for every method seen, heaviest traffic
+// (by bytes received) first.
+func (r *Recorder) Snapshot() []MethodStats {
+	r.mu.Lock()
+	stats := make([]MethodStats, 0, len(r.methods))
+	for name, m := range r.methods {
+		s := MethodStats{
+			Method:        name,
+			Count:         m.count,
+			Bytes:         m.bytes,
+			MaxBytes:      m.maxBytes,
+			Responses:     m.responses,
+			ResponseBytes: m.responseBytes,
+			Unanswered:    m.unanswered,
+			Invalid:       m.invalid,
+		}
+		if len(m.latencies) > 0 {
+			sorted := slices.Clone(m.latencies)
+			slices.Sort(sorted)
+			s.P50 = percentile(sorted, 50)
+			s.P90 = percentile(sorted, 90)
+			s.P99 = percentile(sorted, 99)
+		}
+		stats = append(stats, s)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		return stats[i].Method < stats[j].Method
+	})
+	return stats
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	r := New()
+	r.Message("textDocument/didChange", 1000)
+	r.Message("textDocument/didChange", 3000)
+	r.Message("textDocument/hover", 100)
+	r.Invalid("textDocument/didChange")
+	for i := 1; i <= 100; i++ {
+		r.Response("textDocument/hover", 50, time.Duration(i)*time.Millisecond)
+	}
+
+	stats := r.Snapshot()
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 methods, got %d", len(stats))
+	}
+
+	change := stats[0]
+	if change.Method != "textDocument/didChange" {
+		t.Fatalf("Expected heaviest method first, got %s", change.Method)
+	}
+	if change.Count != 2 || change.Bytes != 4000 || change.MaxBytes != 3000 || change.AvgBytes() != 2000 || change.Invalid != 1 {
+		t.Errorf("Unexpected didChange stats: %+v", change)
+	}
+	if change.P50 != 0 {
+		t.Errorf("Expected no latency for notifications, got %s", change.P50)
+	}
+
+	hover := stats[1]
+	if hover.Responses != 100 || hover.ResponseBytes != 5000 {
+		t.Errorf("Unexpected hover responses: %+v", hover)
+	}
+	if hover.P50 != 50*time.Millisecond || hover.P90 != 90*time.Millisecond || hover.P99 != 99*time.Millisecond {
+		t.Errorf("Unexpected percentiles: p50=%s p90=%s p99=%s", hover.P50, hover.P90, hover.P99)
+	}
+}
+
+func TestRecorderKeepsRecentSamples(t *testing.T) {
+	r := New()
+	for range Samples {
+		r.Response("slow", 0, time.Second)
+	}
+	for range Samples {
+		r.Response("slow", 0, time.Millisecond)
+	}
+
+	stats := r.Snapshot()
+	if stats[0].Responses != 2*Samples {
+		t.Errorf("Expected %d responses, got %d", 2*Samples, stats[0].Responses)
+	}
+	if stats[0].P99 != time.Millisecond {
+		t.Errorf("Expected old samples to be dropped, got p99=%s", stats[0].P99)
+	}
+}
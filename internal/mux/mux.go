@@ -0,0 +1,480 @@
+// Package mux carries several logical channels over one daemon connection,
+// so a client can run its LSP traffic, control-plane queries and log
+// streaming side by side without opening a socket for each.
+//
+// A client opts in by sending Preface as the first bytes of the
+// connection. After that, both sides exchange frames: a 1-byte type, a
+// 4-byte channel ID and a 4-byte payload length (big-endian), then the
+// payload. Each channel carries an ordinary LSP-framed stream, so the
+// daemon serves it like any other connection. Connections that don't
+// start with the preface are served as before.
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Preface opens a multiplexed connection.
+const Preface = "NEOCRUSH-MUX/1\r\n"
+
+// Frame types.
+const (
+	frameOpen  = 1 // The client opened the channel
+	frameData  = 2 // Payload for the channel
+	frameClose = 3 // The sender closed the channel
+)
+
+const (
+	headerSize = 9
+	// maxFrame bounds a frame's payload; larger writes are split.
+	maxFrame = 64 * 1024
+	// maxBuffered bounds the unread data of a channel. Reading the
+	// connection stops while a channel is full, so a channel that is never
+	// read stalls the others rather than growing without bound.
+	maxBuffered = 16 * 1024 * 1024
+)
+
+// ErrSessionClosed is returned once the underlying connection is gone.
+var ErrSessionClosed = errors.New("mux: session closed")
+
+// Session is one multiplexed connection.
+type Session struct {
+	conn    net.Conn
+	reader  io.Reader
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	channels map[uint32]*Channel
+	nextID   uint32
+	err      error         // Why the session ended
+	accepted chan *Channel // Channels the peer opened (nil on the client side)
+	done     chan struct{} // Closed when the session ends
+}
+
+// Client starts a multiplexed session on conn by sending the preface.
+// Channels are opened with Open.
+func Client(conn net.Conn) (*Session, error) {
+	if _, err := conn.Write([]byte(Preface)); err != nil {
+		return nil, fmt.Errorf("mux: failed to send preface: %w", err)
+	}
+	s := newSession(conn, conn)
+	go s.read()
+	return s, nil
+}
+
+// newSession wraps conn, reading frames from reader.
+func newSession(conn net.Conn, reader io.Reader) *Session {
+	return &Session{
+		conn:     conn,
+		reader:   reader,
+		channels: make(map[uint32]*Channel),
+		done:     make(chan struct{}),
+	}
+}
+
+// Open opens a new channel.
+func (s *Session) Open() (*Channel, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	s.nextID++
+	ch := s.newChannel(s.nextID)
+	s.mu.Unlock()
+
+	if err := s.writeFrame(frameOpen, ch.id, nil, time.Time{}); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Close closes every channel and the underlying connection.
+func (s *Session) Close() error {
+	err := s.conn.Close()
+	s.end(ErrSessionClosed)
+	return err
+}
+
+// Done returns a channel that is closed when the session ends.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// newChannel registers channel id. s.mu must be held.
+func (s *Session) newChannel(id uint32) *Channel {
+	ch := &Channel{session: s, id: id}
+	ch.cond = sync.NewCond(&ch.mu)
+	s.channels[id] = ch
+	return ch
+}
+
+// read dispatches incoming frames to their channels until the connection
+// fails.
+func (s *Session) read() {
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(s.reader, header); err != nil {
+			s.end(err)
+			return
+		}
+		kind := header[0]
+		id := binary.BigEndian.Uint32(header[1:5])
+		size := binary.BigEndian.Uint32(header[5:9])
+		if size > maxFrame {
+			s.end(fmt.Errorf("mux: frame of %d bytes exceeds %d", size, maxFrame))
+			s.conn.Close()
+			return
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(s.reader, payload); err != nil {
+			s.end(err)
+			return
+		}
+
+		s.mu.Lock()
+		ch := s.channels[id]
+		if kind == frameOpen && ch == nil && s.accepted != nil {
+			ch = s.newChannel(id)
+			s.mu.Unlock()
+			select {
+			case s.accepted <- ch:
+			case <-s.done:
+				return
+			}
+			continue
+		}
+		s.mu.Unlock()
+		if ch == nil {
+			continue // Closed on our side
+		}
+
+		switch kind {
+		case frameData:
+			ch.deliver(payload)
+		case frameClose:
+			ch.closeRemote()
+		}
+	}
+}
+
+// end fails the session and its channels with err, once.
+func (s *Session) end(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		err = ErrSessionClosed
+	}
+	s.err = err
+	channels := s.channels
+	s.channels = make(map[uint32]*Channel)
+	close(s.done)
+	s.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.closeRemote()
+	}
+}
+
+// writeFrame sends one frame, honoring deadline if set.
+func (s *Session) writeFrame(kind byte, id uint32, payload []byte, deadline time.Time) error {
+	header := make([]byte, headerSize)
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:5], id)
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(payload)))
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	if _, err := s.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Channel is one logical connection of a Session. It implements net.Conn,
+// deadlines included.
+type Channel struct {
+	session *Session
+	id      uint32
+
+	mu            sync.Mutex
+	cond          *sync.Cond
+	buf           bytes.Buffer
+	remoteClosed  bool // No more data will arrive
+	closed        bool // Closed on this side
+	readDeadline  time.Time
+	writeDeadline time.Time
+	timer         *time.Timer // Wakes readers at the read deadline
+}
+
+// ID returns the channel's ID within its session.
+func (c *Channel) ID() uint32 {
+	return c.id
+}
+
+// deliver queues data for Read, waiting while the channel is full.
+func (c *Channel) deliver(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.buf.Len() >= maxBuffered && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return
+	}
+	c.buf.Write(data)
+	c.cond.Broadcast()
+}
+
+// closeRemote marks the end of the channel's incoming data.
+func (c *Channel) closeRemote() {
+	c.mu.Lock()
+	c.remoteClosed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// Read reads data the peer sent on the channel.
+func (c *Channel) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.buf.Len() == 0 {
+		switch {
+		case c.closed:
+			return 0, net.ErrClosed
+		case c.remoteClosed:
+			return 0, io.EOF
+		case !c.readDeadline.IsZero() && !time.Now().Before(c.readDeadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+		c.cond.Wait()
+	}
+	n, _ := c.buf.Read(p)
+	c.cond.Broadcast() // Room for deliver
+	return n, nil
+}
+
+// Write sends p on the channel, split into frames.
+func (c *Channel) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	closed, deadline := c.closed, c.writeDeadline
+	c.mu.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxFrame)
+		if err := c.session.writeFrame(frameData, c.id, p[:n], deadline); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close closes the channel and tells the peer. The session stays open.
+func (c *Channel) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	s := c.session
+	s.mu.Lock()
+	delete(s.channels, c.id)
+	ended := s.err != nil
+	s.mu.Unlock()
+	if ended {
+		return nil
+	}
+	return s.writeFrame(frameClose, c.id, nil, time.Now().Add(time.Second))
+}
+
+// LocalAddr returns the session connection's local address.
+func (c *Channel) LocalAddr() net.Addr { return c.session.conn.LocalAddr() }
+
+// RemoteAddr returns the session connection's remote address.
+func (c *Channel) RemoteAddr() net.Addr { return c.session.conn.RemoteAddr() }
+
+// SetDeadline sets the read and write deadlines.
+func (c *Channel) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets when a blocked Read fails.
+func (c *Channel) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if !t.IsZero() {
+		c.timer = time.AfterFunc(time.Until(t), func() {
+			c.mu.Lock()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		})
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline sets when a blocked Write fails.
+func (c *Channel) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
+
+// Listener serves both plain and multiplexed connections: every channel
+// of a multiplexed connection is accepted as a connection of its own.
+type Listener struct {
+	net.Listener
+
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	err   error
+	errMu sync.Mutex
+}
+
+// Listen wraps l so it accepts multiplexed connections too.
+func Listen(l net.Listener) *Listener {
+	ml := &Listener{
+		Listener: l,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go ml.acceptLoop()
+	return ml
+}
+
+// acceptLoop accepts raw connections and sorts them.
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.errMu.Lock()
+			l.err = err
+			l.errMu.Unlock()
+			l.once.Do(func() { close(l.done) })
+			return
+		}
+		go l.sniff(conn)
+	}
+}
+
+// sniff hands conn to Accept as is, or, if it starts with the preface,
+// each of its channels as they are opened.
+func (l *Listener) sniff(conn net.Conn) {
+	// Compare byte by byte, so a plain client is not kept waiting for
+	// bytes it won't send before it hears back
+	reader := bufio.NewReader(conn)
+	for i := range len(Preface) {
+		head, err := reader.Peek(i + 1)
+		if len(head) <= i {
+			if i == 0 {
+				conn.Close()
+			} else {
+				l.deliver(&peekedConn{Conn: conn, reader: reader})
+			}
+			return
+		}
+		if err == nil && head[i] != Preface[i] {
+			l.deliver(&peekedConn{Conn: conn, reader: reader})
+			return
+		}
+	}
+
+	reader.Discard(len(Preface))
+	s := newSession(conn, reader)
+	s.accepted = make(chan *Channel, 16)
+	go s.read()
+	for {
+		select {
+		case ch := <-s.accepted:
+			if !l.deliver(ch) {
+				s.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// deliver passes conn to Accept, reporting false if the listener closed.
+func (l *Listener) deliver(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		conn.Close()
+		return false
+	}
+}
+
+// Accept returns the next plain connection or channel.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		l.errMu.Lock()
+		defer l.errMu.Unlock()
+		return nil, l.err
+	}
+}
+
+// Close stops accepting connections.
+func (l *Listener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() {
+		l.errMu.Lock()
+		l.err = net.ErrClosed
+		l.errMu.Unlock()
+		close(l.done)
+	})
+	return err
+}
+
+// peekedConn is a plain connection whose first bytes were already read into
+// reader.
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads the peeked bytes first.
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
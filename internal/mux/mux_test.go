@@ -0,0 +1,125 @@
+package mux
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer accepts connections on a multiplexing listener and answers
+// each line with the line upper-cased.
+func echoServer(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "mux.sock")
+	raw, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	l := Listen(raw)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					conn.Write([]byte(strings.ToUpper(line)))
+				}
+			}()
+		}
+	}()
+	return path
+}
+
+func TestListenerChannels(t *testing.T) {
+	path := echoServer(t)
+
+	// Plain connections are served as before
+	plain, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer plain.Close()
+	plain.Write([]byte("short\n"))
+	plain.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if line, _ := bufio.NewReader(plain).ReadString('\n'); line != "SHORT\n" {
+		t.Errorf("Expected the plain connection echoed, got %q", line)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	s, err := Client(conn)
+	if err != nil {
+		t.Fatalf("Failed to start session: %v", err)
+	}
+	defer s.Close()
+
+	a, _ := s.Open()
+	b, _ := s.Open()
+	if a.ID() == b.ID() {
+		t.Fatalf("Expected distinct channel IDs, got %d twice", a.ID())
+	}
+	readers := map[*Channel]*bufio.Reader{a: bufio.NewReader(a), b: bufio.NewReader(b)}
+	for _, step := range []struct {
+		ch   *Channel
+		text string
+	}{{b, "logs"}, {a, "lsp"}, {b, strings.Repeat("x", 2*maxFrame)}} {
+		step.ch.Write([]byte(step.text + "\n"))
+		step.ch.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := readers[step.ch].ReadString('\n')
+		if err != nil || line != strings.ToUpper(step.text)+"\n" {
+			t.Errorf("Expected %.20q echoed on channel %d, got %.20q, %v", step.text, step.ch.ID(), line, err)
+		}
+	}
+
+	// Closing a channel ends only that channel
+	a.Close()
+	b.Write([]byte("still here\n"))
+	if line, _ := readers[b].ReadString('\n'); line != "STILL HERE\n" {
+		t.Errorf("Expected the other channel to keep working, got %q", line)
+	}
+}
+
+func TestChannelClosedByPeer(t *testing.T) {
+	path := echoServer(t)
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	s, _ := Client(conn)
+	ch, _ := s.Open()
+
+	// A read past the deadline fails, and the channel stays usable
+	ch.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := ch.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected a deadline error, got %v", err)
+	}
+	ch.SetReadDeadline(time.Time{})
+
+	// The session ending ends its channels
+	s.Close()
+	if _, err := ch.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected EOF after the session closed, got %v", err)
+	}
+	if _, err := s.Open(); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected opening on a closed session to fail, got %v", err)
+	}
+}
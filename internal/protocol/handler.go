@@ -89,6 +89,7 @@ func (h *Handler) RemoveClient(clientID string) {
 			h.neovimClient = nil
 		}
 		delete(h.clients, clientID)
+		h.state.RemoveClientCapabilities(string(client.Type))
 	}
 }
 
@@ -162,6 +163,8 @@ func (h *Handler) handleInitialize(client *Client, content []byte) error {
 		request.Params.ClientInfo.Name,
 		request.Params.ClientInfo.Version)
 
+	h.state.SetClientCapabilities(string(client.Type), request.Params.Capabilities)
+
 	response := lsp.InitializeResponse{
 		Response: lsp.Response{
 			RPC: "2.0",
@@ -482,6 +485,11 @@ func (h *Handler) handleGetState(client *Client, content []byte) error {
 		}
 	}
 
+	if request.Params.IncludeCapabilities {
+		caps := h.state.Capabilities()
+		result.Capabilities = &caps
+	}
+
 	// Open documents
 	for _, uri := range h.state.ListDocuments() {
 		doc := h.state.GetDocument(uri)
@@ -0,0 +1,130 @@
+// Package quota enforces per-client limits on agent edits: document edits
+// and bytes written per minute, and files touched by a single operation.
+package quota
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Window is the span the per-minute limits are counted over.
+const Window = time.Minute
+
+// Limits are the configured ceilings. Zero disables a limit.
+type Limits struct {
+	EditsPerMinute    int
+	BytesPerMinute    int
+	FilesPerOperation int
+}
+
+// Op is one edit operation.
+type Op struct {
+	Files int // Documents touched; each counts as one edit
+	Bytes int // Bytes of new text written
+}
+
+// ExceededError reports which limit an operation would exceed.
+type ExceededError struct {
+	Limit      string        // "edits_per_minute", "bytes_per_minute", or "files_per_operation"
+	Max        int           // Configured ceiling
+	Value      int           // What the operation would bring the count to
+	RetryAfter time.Duration // When the operation would fit; zero if it never will
+}
+
+func (e *ExceededError) Error() string {
+	msg := fmt.Sprintf("limit %s exceeded (%d > %d)", e.Limit, e.Value, e.Max)
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry in %s", e.RetryAfter.Round(time.Second))
+	}
+	return msg
+}
+
+type event struct {
+	at    time.Time
+	files int
+	bytes int
+}
+
+// Limiter tracks recent operations per client.
+type Limiter struct {
+	limits Limits
+	now    func() time.Time
+
+	mu     sync.Mutex
+	events map[string][]event
+}
+
+// New returns a limiter enforcing limits.
+func New(limits Limits) *Limiter {
+	return &Limiter{
+		limits: limits,
+		now:    time.Now,
+		events: make(map[string][]event),
+	}
+}
+
+// Enabled reports whether any limit is set.
+func (l *Limiter) Enabled() bool {
+	return l.limits != Limits{}
+}
+
+// Reserve records op for client if it fits within the limits, and
+// otherwise returns an *ExceededError without recording it.
+func (l *Limiter) Reserve(client string, op Op) error {
+	if !l.Enabled() {
+		return nil
+	}
+	if max := l.limits.FilesPerOperation; max > 0 && op.Files > max {
+		return &ExceededError{Limit: "files_per_operation", Max: max, Value: op.Files}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	events := l.events[client]
+	for len(events) > 0 && now.Sub(events[0].at) >= Window {
+		events = events[1:]
+	}
+	l.events[client] = events
+
+	if err := l.check(events, now, op.Files, l.limits.EditsPerMinute, "edits_per_minute", func(e event) int { return e.files }); err != nil {
+		return err
+	}
+	if err := l.check(events, now, op.Bytes, l.limits.BytesPerMinute, "bytes_per_minute", func(e event) int { return e.bytes }); err != nil {
+		return err
+	}
+
+	l.events[client] = append(events, event{at: now, files: op.Files, bytes: op.Bytes})
+	return nil
+}
+
+// check tests whether adding n to the windowed sum of size stays within
+// max, and if not, how long until enough events expire.
+func (l *Limiter) check(events []event, now time.Time, n, max int, limit string, size func(event) int) error {
+	if max <= 0 || n == 0 {
+		return nil
+	}
+	total := n
+	for _, e := range events {
+		total += size(e)
+	}
+	if total <= max {
+		return nil
+	}
+
+	err := &ExceededError{Limit: limit, Max: max, Value: total}
+	if n > max {
+		return err // Never fits
+	}
+	excess := total - max
+	for _, e := range events {
+		excess -= size(e)
+		if excess <= 0 {
+			err.RetryAfter = e.at.Add(Window).Sub(now)
+			break
+		}
+	}
+	return err
+}
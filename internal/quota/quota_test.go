@@ -0,0 +1,65 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(Limits{EditsPerMinute: 3, BytesPerMinute: 100, FilesPerOperation: 2})
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	var exceeded *ExceededError
+
+	err := l.Reserve("crush", Op{Files: 3, Bytes: 1})
+	if !errors.As(err, &exceeded) || exceeded.Limit != "files_per_operation" || exceeded.RetryAfter != 0 {
+		t.Fatalf("Expected files_per_operation error, got %v", err)
+	}
+
+	for i := range 3 {
+		if err := l.Reserve("crush", Op{Files: 1, Bytes: 10}); err != nil {
+			t.Fatalf("Edit %d rejected: %v", i, err)
+		}
+		now = now.Add(10 * time.Second)
+	}
+
+	err = l.Reserve("crush", Op{Files: 1, Bytes: 10})
+	if !errors.As(err, &exceeded) || exceeded.Limit != "edits_per_minute" || exceeded.RetryAfter != 30*time.Second {
+		t.Fatalf("Expected edits_per_minute error with 30s retry, got %v", err)
+	}
+
+	// Other clients have their own budget
+	if err := l.Reserve("mcp", Op{Files: 1, Bytes: 10}); err != nil {
+		t.Errorf("Expected mcp edit to pass, got %v", err)
+	}
+
+	// The first edit expires
+	now = now.Add(30 * time.Second)
+	if err := l.Reserve("crush", Op{Files: 1, Bytes: 10}); err != nil {
+		t.Errorf("Expected edit after window to pass, got %v", err)
+	}
+
+	err = l.Reserve("crush", Op{Files: 0, Bytes: 90})
+	if !errors.As(err, &exceeded) || exceeded.Limit != "bytes_per_minute" || exceeded.RetryAfter == 0 {
+		t.Errorf("Expected retryable bytes_per_minute error, got %v", err)
+	}
+
+	err = l.Reserve("mcp", Op{Files: 0, Bytes: 101})
+	if !errors.As(err, &exceeded) || exceeded.RetryAfter != 0 {
+		t.Errorf("Expected oversized write never to fit, got %v", err)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := New(Limits{})
+	if l.Enabled() {
+		t.Error("Expected zero limits to be disabled")
+	}
+	for range 1000 {
+		if err := l.Reserve("crush", Op{Files: 100, Bytes: 1 << 20}); err != nil {
+			t.Fatalf("Expected no limits, got %v", err)
+		}
+	}
+}
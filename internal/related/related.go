@@ -0,0 +1,177 @@
+// Package related ranks workspace files by similarity to a piece of text
+// using TF-IDF signatures over identifiers, a lightweight stand-in for
+// embeddings that needs no model.
+package related
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxTerms caps the signature of one document; its most weighted terms are kept.
+const maxTerms = 200
+
+// Match is a file similar to the query.
+type Match struct {
+	Path  string
+	Score float64 // Cosine similarity, 0..1
+}
+
+// Index holds a TF-IDF signature per file.
+type Index struct {
+	idf  map[string]float64
+	docs map[string]map[string]float64 // Path -> normalized signature
+}
+
+// Build indexes files, keyed by path.
+func Build(files map[string]string) *Index {
+	counts := make(map[string]map[string]int, len(files))
+	df := map[string]int{}
+	for path, text := range files {
+		tf := termCounts(text)
+		counts[path] = tf
+		for term := range tf {
+			df[term]++
+		}
+	}
+
+	idx := &Index{
+		idf:  make(map[string]float64, len(df)),
+		docs: make(map[string]map[string]float64, len(files)),
+	}
+	n := float64(len(files))
+	for term, c := range df {
+		idx.idf[term] = math.Log(1 + n/float64(c))
+	}
+	for path, tf := range counts {
+		idx.docs[path] = idx.signature(tf)
+	}
+	return idx
+}
+
+// Len returns the number of indexed files.
+func (idx *Index) Len() int {
+	return len(idx.docs)
+}
+
+// Query returns up to limit files most similar to text, best first,
+// leaving out exclude (typically the file the text came from).
+func (idx *Index) Query(text, exclude string, limit int) []Match {
+	q := idx.signature(termCounts(text))
+	if len(q) == 0 {
+		return nil
+	}
+
+	var matches []Match
+	for path, doc := range idx.docs {
+		if path == exclude {
+			continue
+		}
+		score := 0.0
+		for term, w := range q {
+			score += w * doc[term]
+		}
+		if score > 0 {
+			matches = append(matches, Match{Path: path, Score: score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Path < matches[j].Path
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// signature weights term counts by IDF, keeps the top maxTerms terms, and
+// normalizes to unit length. Terms unknown to the index are dropped.
+func (idx *Index) signature(tf map[string]int) map[string]float64 {
+	type weighted struct {
+		term string
+		w    float64
+	}
+	var terms []weighted
+	for term, c := range tf {
+		if idf, ok := idx.idf[term]; ok {
+			terms = append(terms, weighted{term, (1 + math.Log(float64(c))) * idf})
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].w != terms[j].w {
+			return terms[i].w > terms[j].w
+		}
+		return terms[i].term < terms[j].term
+	})
+	if len(terms) > maxTerms {
+		terms = terms[:maxTerms]
+	}
+
+	norm := 0.0
+	for _, t := range terms {
+		norm += t.w * t.w
+	}
+	norm = math.Sqrt(norm)
+
+	sig := make(map[string]float64, len(terms))
+	for _, t := range terms {
+		sig[t.term] = t.w / norm
+	}
+	return sig
+}
+
+// termCounts counts the identifier-like words in text, split at case
+// changes and underscores and lowercased. Words shorter than 3 letters
+// and common keywords are ignored.
+func termCounts(text string) map[string]int {
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		for _, part := range splitIdentifier(word) {
+			part = strings.ToLower(part)
+			if len(part) >= 3 && !stopWords[part] {
+				counts[part]++
+			}
+		}
+	}
+	return counts
+}
+
+// splitIdentifier splits camelCase and snake_case words into parts.
+func splitIdentifier(word string) []string {
+	var parts []string
+	start := 0
+	runes := []rune(word)
+	for i := 1; i < len(runes); i++ {
+		switch {
+		case runes[i] == '_':
+			parts = append(parts, string(runes[start:i]))
+			start = i + 1
+		case unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i-1]):
+			parts = append(parts, string(runes[start:i]))
+			start = i
+		}
+	}
+	if start < len(runes) {
+		parts = append(parts, string(runes[start:]))
+	}
+	return parts
+}
+
+// stopWords are keywords too common across languages to tell files apart.
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "func": true, "function": true,
+	"return": true, "var": true, "let": true, "const": true, "else": true,
+	"nil": true, "null": true, "true": true, "false": true, "string": true,
+	"int": true, "err": true, "error": true, "import": true, "package": true,
+	"def": true, "self": true, "this": true, "new": true, "type": true,
+	"struct": true, "class": true, "public": true, "private": true,
+	"static": true, "void": true, "range": true, "with": true, "from": true,
+}
@@ -0,0 +1,39 @@
+package related_test
+
+import (
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/related"
+)
+
+func TestQuery(t *testing.T) {
+	idx := related.Build(map[string]string{
+		"session.go":      "type Session struct { SocketPath string }\nfunc NewSession() *Session { return &Session{} }\nfunc (s *Session) SocketPath() {}",
+		"session_test.go": "func TestSession(t *testing.T) { s := NewSession(); _ = s.SocketPath }",
+		"snippet.go":      "func ToPlainText(snippet string) string { return parsePlaceholder(snippet) }",
+		"README.md":       "Install neocrush and configure Crush.",
+	})
+	if idx.Len() != 4 {
+		t.Fatalf("Expected 4 files, got %d", idx.Len())
+	}
+
+	matches := idx.Query("sess := NewSession()\nsess.SocketPath", "session_test.go", 2)
+	if len(matches) == 0 || matches[0].Path != "session.go" {
+		t.Fatalf("Expected session.go first, got %+v", matches)
+	}
+	for _, m := range matches {
+		if m.Path == "session_test.go" {
+			t.Error("Expected the excluded file to be left out")
+		}
+		if m.Score <= 0 || m.Score > 1.0001 {
+			t.Errorf("Score out of range: %+v", m)
+		}
+	}
+
+	if got := idx.Query("snippet placeholder", "", 1); len(got) != 1 || got[0].Path != "snippet.go" {
+		t.Errorf("Expected snippet.go, got %+v", got)
+	}
+	if got := idx.Query("zzz qqq", "", 5); len(got) != 0 {
+		t.Errorf("Expected no matches for unknown terms, got %+v", got)
+	}
+}
@@ -0,0 +1,209 @@
+package render
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// kind is the kind of a token, which sets its color.
+type kind int
+
+const (
+	plain kind = iota
+	comment
+	str
+	number
+	keyword
+)
+
+// token is a run of a line's text of one kind.
+type token struct {
+	kind kind
+	text string
+}
+
+// rules are a language's lexical rules.
+type rules struct {
+	lineComment  []string
+	blockComment [2]string // Start and end, or empty
+	quotes       string    // Characters that delimit strings
+	keywords     map[string]bool
+}
+
+// lexState carries a block comment across lines.
+type lexState struct {
+	inComment bool
+}
+
+// words returns a set of space-separated words.
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		set[w] = true
+	}
+	return set
+}
+
+var (
+	cLike = rules{
+		lineComment:  []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+	hashLike = rules{lineComment: []string{"#"}, quotes: `"'`}
+)
+
+// languageRules are the rules by LSP language ID. Languages without rules
+// are drawn without highlighting.
+var languageRules = map[string]rules{
+	"go":          with(cLike, "`", "break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota"),
+	"c":           with(cLike, "", "auto break case char const continue default do double else enum extern float for goto if int long register return short signed sizeof static struct switch typedef union unsigned void volatile while NULL"),
+	"cpp":         with(cLike, "", "auto bool break case catch char class const constexpr continue default delete do double else enum explicit false float for friend if inline int long namespace new nullptr operator private protected public return short sizeof static struct switch template this throw true try typedef typename union using virtual void while"),
+	"java":        with(cLike, "", "abstract boolean break byte case catch char class continue default do double else enum extends false final finally float for if implements import instanceof int interface long new null package private protected public return short static super switch this throw throws true try void while var"),
+	"javascript":  with(cLike, "`", "async await break case catch class const continue default delete do else export extends false finally for function if import in instanceof let new null return static super switch this throw true try typeof undefined var void while yield of"),
+	"typescript":  with(cLike, "`", "abstract any as async await boolean break case catch class const continue default delete do else enum export extends false finally for from function if implements import in instanceof interface let new null number private protected public readonly return static string super switch this throw true try type typeof undefined var void while yield of"),
+	"rust":        with(cLike, "", "as async await break const continue crate else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while"),
+	"zig":         with(cLike, "", "const var fn pub return if else while for break continue switch struct enum union error try catch defer errdefer comptime null undefined true false"),
+	"python":      with(hashLike, "", "and as assert async await break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return True try while with yield self"),
+	"ruby":        with(hashLike, "", "alias and begin break case class def do else elsif end ensure false for if in module next nil not or redo rescue retry return self super then true undef unless until when while yield"),
+	"shellscript": with(hashLike, "", "case do done elif else esac export fi for function if in local return then until while"),
+	"yaml":        with(hashLike, "", "true false null"),
+	"toml":        with(hashLike, "", "true false"),
+	"lua": {
+		lineComment: []string{"--"},
+		quotes:      `"'`,
+		keywords:    words("and break do else elseif end false for function goto if in local nil not or repeat return then true until while"),
+	},
+	"sql": {
+		lineComment: []string{"--"},
+		quotes:      `'"`,
+		keywords:    words("select from where and or not insert into values update set delete create table drop alter join left right inner outer on as group by order having limit null is in SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER JOIN LEFT RIGHT INNER OUTER ON AS GROUP BY ORDER HAVING LIMIT NULL IS IN"),
+	},
+}
+
+// with returns base with extra quote characters and keywords.
+func with(base rules, quotes, keywords string) rules {
+	base.quotes += quotes
+	base.keywords = words(keywords)
+	return base
+}
+
+// rulesFor returns the rules of languageID. The react variants of
+// javascript and typescript share their rules.
+func rulesFor(languageID string) (rules, bool) {
+	r, ok := languageRules[strings.TrimSuffix(languageID, "react")]
+	return r, ok
+}
+
+// tokenize splits line into tokens, continuing and updating a block
+// comment in state.
+func (r rules) tokenize(line string, state *lexState) []token {
+	var tokens []token
+	emit := func(k kind, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(tokens); n > 0 && tokens[n-1].kind == k {
+			tokens[n-1].text += text
+			return
+		}
+		tokens = append(tokens, token{k, text})
+	}
+
+	for line != "" {
+		if state.inComment {
+			end := strings.Index(line, r.blockComment[1])
+			if end < 0 {
+				emit(comment, line)
+				return tokens
+			}
+			end += len(r.blockComment[1])
+			emit(comment, line[:end])
+			line = line[end:]
+			state.inComment = false
+			continue
+		}
+		if r.blockComment[0] != "" && strings.HasPrefix(line, r.blockComment[0]) {
+			state.inComment = true
+			emit(comment, r.blockComment[0])
+			line = line[len(r.blockComment[0]):]
+			continue
+		}
+		if r.isLineComment(line) {
+			emit(comment, line)
+			return tokens
+		}
+
+		c := line[0]
+		switch {
+		case strings.IndexByte(r.quotes, c) >= 0:
+			n := stringEnd(line)
+			emit(str, line[:n])
+			line = line[n:]
+		case isWordStart(c):
+			n := wordEnd(line)
+			if n == 0 { // A symbol outside ASCII
+				_, n = utf8.DecodeRuneInString(line)
+			}
+			word := line[:n]
+			switch {
+			case c >= '0' && c <= '9':
+				emit(number, word)
+			case r.keywords[word]:
+				emit(keyword, word)
+			default:
+				emit(plain, word)
+			}
+			line = line[n:]
+		default:
+			emit(plain, line[:1])
+			line = line[1:]
+		}
+	}
+	return tokens
+}
+
+// isLineComment reports whether line starts with a line comment.
+func (r rules) isLineComment(line string) bool {
+	for _, prefix := range r.lineComment {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringEnd returns the length of the string literal line starts with, to
+// the closing quote or the end of the line.
+func stringEnd(line string) int {
+	quote := line[0]
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			return i + 1
+		}
+	}
+	return len(line)
+}
+
+// isWordStart reports whether c starts an identifier, keyword or number.
+func isWordStart(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// wordEnd returns the length of the word line starts with. Dots continue
+// numbers.
+func wordEnd(line string) int {
+	isNumber := line[0] >= '0' && line[0] <= '9'
+	for i, r := range line {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) && (r != '.' || !isNumber) {
+			return i
+		}
+	}
+	return len(line)
+}
@@ -0,0 +1,135 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// PNGMIMEType is the type of the images PNG returns.
+const PNGMIMEType = "image/png"
+
+const (
+	// pngScale enlarges the 7x13 bitmap font so hosts show it legibly.
+	pngScale = 2
+	// pngLineHeight is the height of a line before scaling.
+	pngLineHeight = 16
+	// maxPNGColumns cuts longer lines, so a minified file can't produce a
+	// huge image.
+	maxPNGColumns = 200
+)
+
+// face draws PNG text. It has the printable ASCII characters; others are
+// drawn as U+FFFD.
+var face = basicfont.Face7x13
+
+// PNG draws s as a raster image, which every host that shows image content
+// accepts.
+func PNG(s Snippet) ([]byte, error) {
+	lines, digits, width := layout(s)
+	width = min(width, maxPNGColumns)
+	advance := face.Advance
+
+	top := padding
+	if s.Title != "" {
+		top += pngLineHeight
+	}
+	codeX := padding + (digits+2)*advance
+	w := codeX + width*advance + padding
+	h := top + len(lines)*pngLineHeight + padding
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(hexColor(background)), image.Point{}, draw.Src)
+	text := func(x, y int, fill, s string) {
+		d := font.Drawer{Dst: img, Src: image.NewUniform(hexColor(fill)), Face: face, Dot: fixed.P(x, y)}
+		d.DrawString(s)
+	}
+	// Baselines sit a descent above the bottom of the line
+	baseline := func(y int) int { return y + (pngLineHeight+face.Ascent-face.Descent)/2 }
+
+	if s.Title != "" {
+		text(padding, baseline(padding), titleColor, s.Title)
+	}
+	state := lexState{}
+	rules, highlight := rulesFor(s.LanguageID)
+	for i, line := range lines {
+		y := top + i*pngLineHeight
+		if s.FirstLine+i == s.Highlight {
+			draw.Draw(img, image.Rect(0, y, w, y+pngLineHeight), image.NewUniform(hexColor(marked)), image.Point{}, draw.Src)
+		}
+		number := strconv.Itoa(s.FirstLine + i + 1)
+		text(padding+(digits-len(number))*advance, baseline(y), gutter, number)
+
+		tokens := []token{{plain, line}}
+		if highlight {
+			tokens = rules.tokenize(line, &state)
+		}
+		col := 0
+		for _, t := range tokens {
+			if col >= maxPNGColumns {
+				break
+			}
+			runes := []rune(t.text)
+			runes = runes[:min(len(runes), maxPNGColumns-col)]
+			text(codeX+col*advance, baseline(y), theme[t.kind], string(runes))
+			col += len(runes)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, scale(img)); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// scale enlarges img by pngScale. The bitmap font isn't antialiased, so the
+// image only has the theme's colors and is returned paletted, which encodes
+// several times faster and smaller than RGBA.
+func scale(img *image.RGBA) image.Image {
+	b := img.Bounds()
+	indexes := make(map[color.RGBA]uint8)
+	var palette color.Palette
+	pix := make([]uint8, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			i, ok := indexes[c]
+			if !ok {
+				if len(palette) == 256 {
+					scaled := image.NewRGBA(image.Rect(0, 0, b.Dx()*pngScale, b.Dy()*pngScale))
+					draw.NearestNeighbor.Scale(scaled, scaled.Bounds(), img, b, draw.Src, nil)
+					return scaled
+				}
+				i = uint8(len(palette))
+				indexes[c] = i
+				palette = append(palette, c)
+			}
+			pix = append(pix, i)
+		}
+	}
+
+	scaled := image.NewPaletted(image.Rect(0, 0, b.Dx()*pngScale, b.Dy()*pngScale), palette)
+	for y := range scaled.Rect.Dy() {
+		row := pix[y/pngScale*b.Dx():]
+		out := scaled.Pix[y*scaled.Stride:]
+		for x := range scaled.Rect.Dx() {
+			out[x] = row[x/pngScale]
+		}
+	}
+	return scaled
+}
+
+// hexColor parses a "#rrggbb" color of the theme.
+func hexColor(hex string) color.RGBA {
+	v, _ := strconv.ParseUint(hex[1:], 16, 32)
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}
+}
@@ -0,0 +1,134 @@
+// Package render draws code as syntax-highlighted PNG or SVG images, for
+// MCP hosts that display image content. Highlighting is lexical: comments, strings,
+// numbers and keywords, by the rules of the file's language.
+package render
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SVGMIMEType is the type of the images SVG returns.
+const SVGMIMEType = "image/svg+xml"
+
+const (
+	fontSize   = 14
+	charWidth  = 8.4 // Of the monospace font at fontSize
+	lineHeight = 20
+	padding    = 12
+	tabWidth   = 4
+)
+
+// Snippet is code to draw.
+type Snippet struct {
+	Text       string // The lines to draw
+	LanguageID string // LSP language ID, for highlighting
+	FirstLine  int    // 0-indexed number of the first line, for the gutter
+	Highlight  int    // 0-indexed line to mark, or -1
+	Title      string // Shown above the code, if set
+}
+
+// theme colors the kinds of token.
+var theme = map[kind]string{
+	plain:   "#c0caf5",
+	comment: "#565f89",
+	str:     "#9ece6a",
+	number:  "#ff9e64",
+	keyword: "#bb9af7",
+}
+
+const (
+	background = "#1a1b26"
+	gutter     = "#3b4261"
+	marked     = "#292e42"
+	titleColor = "#7aa2f7"
+)
+
+// layout splits s into lines with tabs expanded, and measures the gutter
+// digits and the longest line in characters.
+func layout(s Snippet) (lines []string, digits, width int) {
+	lines = strings.Split(strings.TrimSuffix(s.Text, "\n"), "\n")
+	digits = len(fmt.Sprint(s.FirstLine + len(lines)))
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+		width = max(width, len([]rune(lines[i])))
+	}
+	return lines, digits, width
+}
+
+// SVG draws s as a vector image.
+func SVG(s Snippet) []byte {
+	lines, digits, width := layout(s)
+
+	top := padding
+	if s.Title != "" {
+		top += lineHeight
+	}
+	codeX := float64(padding) + float64(digits+2)*charWidth
+	w := int(codeX+float64(width)*charWidth) + padding
+	h := top + len(lines)*lineHeight + padding
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="ui-monospace, Menlo, Consolas, monospace" font-size="%d">`+"\n", w, h, w, h, fontSize)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" rx="6" fill="%s"/>`+"\n", background)
+	if s.Title != "" {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" fill="%s">%s</text>`+"\n", padding, padding+fontSize, titleColor, escape(s.Title))
+	}
+
+	state := lexState{}
+	rules, highlight := rulesFor(s.LanguageID)
+	for i, line := range lines {
+		y := top + i*lineHeight
+		if s.FirstLine+i == s.Highlight {
+			fmt.Fprintf(&b, `<rect x="0" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", y, w, lineHeight, marked)
+		}
+		baseline := y + fontSize
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" fill="%s" text-anchor="end">%d</text>`, padding+float64(digits)*charWidth, baseline, gutter, s.FirstLine+i+1)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" xml:space="preserve">`, codeX, baseline)
+		tokens := []token{{plain, line}}
+		if highlight {
+			tokens = rules.tokenize(line, &state)
+		}
+		for _, t := range tokens {
+			fmt.Fprintf(&b, `<tspan fill="%s">%s</tspan>`, theme[t.kind], escape(t.text))
+		}
+		b.WriteString("</text>\n")
+	}
+	b.WriteString("</svg>\n")
+	return []byte(b.String())
+}
+
+// expandTabs replaces the tabs in line with spaces to the next tab stop.
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := tabWidth - col%tabWidth
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
+
+// escaper escapes text for SVG.
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// escape escapes text for SVG, dropping the control characters XML does
+// not allow.
+func escape(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' || r == 0xfffe || r == 0xffff {
+			return -1
+		}
+		return r
+	}, text)
+	return escaper.Replace(text)
+}
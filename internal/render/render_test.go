@@ -0,0 +1,120 @@
+package render_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/render"
+)
+
+func TestSVG(t *testing.T) {
+	svg := string(render.SVG(render.Snippet{
+		Text:       "/* a\nb */ func f() {\n\treturn \"<x>\" // 42\n}\n",
+		LanguageID: "go",
+		FirstLine:  9,
+		Highlight:  11,
+		Title:      "main.go",
+	}))
+
+	// Well-formed, so hosts can draw it
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("Invalid SVG: %v\n%s", err, svg)
+			}
+			break
+		}
+	}
+
+	for _, want := range []string{
+		`<tspan fill="#565f89">/* a</tspan>`,          // Block comment
+		`<tspan fill="#565f89">b */</tspan>`,          // continued on the next line
+		`<tspan fill="#bb9af7">func</tspan>`,          // Keyword
+		`<tspan fill="#9ece6a">&quot;&lt;x&gt;&quot;`, // Escaped string
+		`<tspan fill="#565f89">// 42</tspan>`,         // Line comment, not a number
+		`    </tspan><tspan fill="#bb9af7">return`,    // Tabs expanded
+		`>10</text>`,      // Gutter from FirstLine
+		`>main.go</text>`, // Title
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("Expected %s in\n%s", want, svg)
+		}
+	}
+	if strings.Count(svg, `fill="#292e42"`) != 1 {
+		t.Errorf("Expected one highlighted line in\n%s", svg)
+	}
+}
+
+func TestSVGPlain(t *testing.T) {
+	svg := string(render.SVG(render.Snippet{Text: "if x 😀 1", Highlight: -1}))
+	if !strings.Contains(svg, `<tspan fill="#c0caf5">if x 😀 1</tspan>`) {
+		t.Errorf("Expected unknown languages drawn plain, got\n%s", svg)
+	}
+}
+
+func TestSVGControlCharacters(t *testing.T) {
+	svg := render.SVG(render.Snippet{Text: "a\x00b\x1bc\td", Highlight: -1})
+	dec := xml.NewDecoder(bytes.NewReader(svg))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("Invalid SVG: %v\n%s", err, svg)
+			}
+			break
+		}
+	}
+	if !bytes.Contains(svg, []byte(">abc")) || bytes.ContainsAny(svg, "\x00\x1b") {
+		t.Errorf("Expected control characters dropped, got\n%s", svg)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	short := render.Snippet{Text: "package main\n\nfunc main() {}\n", LanguageID: "go", Highlight: 0, Title: "main.go"}
+	data, err := render.PNG(short)
+	if err != nil {
+		t.Fatalf("PNG() failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+	// The background, and text in other colors
+	if r, g, b, _ := img.At(1, 1).RGBA(); r>>8 != 0x1a || g>>8 != 0x1b || b>>8 != 0x26 {
+		t.Errorf("Expected the background color in the corner, got %02x%02x%02x", r>>8, g>>8, b>>8)
+	}
+	if colors := countColors(img); colors < 4 {
+		t.Errorf("Expected highlighted text, got %d colors", colors)
+	}
+
+	// Long lines are cut, so the image stays small
+	long, err := render.PNG(render.Snippet{Text: strings.Repeat("x", 10000), Highlight: -1})
+	if err != nil {
+		t.Fatalf("PNG() failed: %v", err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(long))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+	if cfg.Width > 4000 {
+		t.Errorf("Expected a long line cut, got an image %d wide", cfg.Width)
+	}
+}
+
+// countColors counts the distinct colors of img.
+func countColors(img image.Image) int {
+	colors := make(map[[4]uint32]bool)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			colors[[4]uint32{r, g, bl, a}] = true
+		}
+	}
+	return len(colors)
+}
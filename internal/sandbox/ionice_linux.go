@@ -0,0 +1,27 @@
+package sandbox
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess   = 1
+	ioprioClassBestEff = 2
+	ioprioClassShift   = 13
+	ioprioMaxLevel     = 7
+)
+
+func setIONice(pid, level int) error {
+	if level < 0 || level > ioprioMaxLevel {
+		return fmt.Errorf("ionice level %d out of range 0-%d", level, ioprioMaxLevel)
+	}
+
+	prio := ioprioClassBestEff<<ioprioClassShift | level
+	for _, tid := range threads(pid) {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+			return fmt.Errorf("failed to set ionice level %d: %w", level, errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package sandbox
+
+// setIONice is a no-op outside Linux, which has no ioprio interface.
+func setIONice(int, int) error { return nil }
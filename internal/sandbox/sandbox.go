@@ -0,0 +1,73 @@
+// Package sandbox restricts the environment and resources of the spawned
+// daemon process.
+package sandbox
+
+import (
+	"strings"
+
+	"github.com/taigrr/neocrush/internal/config"
+)
+
+// Env filters environ ("KEY=value" pairs) down to the variables matched by
+// allow. Entries ending in "*" match by prefix; a lone "*" matches all.
+func Env(environ, allow []string) []string {
+	var filtered []string
+	for _, kv := range environ {
+		key, _, _ := strings.Cut(kv, "=")
+		if allowed(key, allow) {
+			filtered = append(filtered, kv)
+		}
+	}
+	return filtered
+}
+
+// allowed reports whether key matches any allowlist pattern.
+func allowed(key string, allow []string) bool {
+	for _, pattern := range allow {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+			continue
+		}
+		if key == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Prioritize sets the scheduling and I/O priority from cfg on the process
+// pid. It is called on the daemon right after it is spawned, since both are
+// per-thread attributes on Linux: every thread the process has is
+// adjusted, and threads it starts later inherit the priority.
+func Prioritize(pid int, cfg config.DaemonConfig) error {
+	if cfg.Nice != 0 {
+		if err := setNice(pid, cfg.Nice); err != nil {
+			return err
+		}
+	}
+	if cfg.IONice != 0 {
+		if err := setIONice(pid, cfg.IONice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply sets the resource limits from cfg on the current process. It is
+// called by the daemon itself right after startup, so the limits apply to
+// it and anything it launches.
+func Apply(cfg config.DaemonConfig) error {
+	if cfg.MaxOpenFiles != 0 {
+		if err := setMaxOpenFiles(cfg.MaxOpenFiles); err != nil {
+			return err
+		}
+	}
+	if cfg.MaxMemoryMB != 0 {
+		if err := setMaxMemory(cfg.MaxMemoryMB * 1024 * 1024); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package sandbox
+
+// Resource limits are only supported on Linux and macOS; elsewhere the
+// daemon runs with the inherited limits.
+
+func setNice(int, int) error { return nil }
+
+func setMaxOpenFiles(uint64) error { return nil }
+
+func setMaxMemory(uint64) error { return nil }
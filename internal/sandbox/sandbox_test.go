@@ -0,0 +1,28 @@
+package sandbox_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/sandbox"
+)
+
+func TestEnv(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"HOME=/home/me",
+		"LC_ALL=C",
+		"OPENAI_API_KEY=secret",
+		"GITHUB_TOKEN=secret",
+	}
+
+	got := sandbox.Env(environ, []string{"PATH", "HOME", "LC_*"})
+	want := []string{"PATH=/usr/bin", "HOME=/home/me", "LC_ALL=C"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Env() = %v, want %v", got, want)
+	}
+
+	if got := sandbox.Env(environ, []string{"*"}); !slices.Equal(got, environ) {
+		t.Fatalf("Env() with * = %v, want full environment", got)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build linux || darwin
+
+package sandbox
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func setNice(pid, nice int) error {
+	for _, tid := range threads(pid) {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil {
+			return fmt.Errorf("failed to set nice level %d: %w", nice, err)
+		}
+	}
+	return nil
+}
+
+func setMaxOpenFiles(n uint64) error {
+	return setrlimit(syscall.RLIMIT_NOFILE, "RLIMIT_NOFILE", n)
+}
+
+func setMaxMemory(bytes uint64) error {
+	return setrlimit(syscall.RLIMIT_AS, "RLIMIT_AS", bytes)
+}
+
+// setrlimit lowers (or raises, up to the hard limit) the soft limit for resource.
+func setrlimit(resource int, name string, value uint64) error {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &lim); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	lim.Cur = min(value, lim.Max)
+	if err := syscall.Setrlimit(resource, &lim); err != nil {
+		return fmt.Errorf("failed to set %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build linux || darwin
+
+package sandbox_test
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/config"
+	"github.com/taigrr/neocrush/internal/sandbox"
+)
+
+func TestPrioritize(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start sleep: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	// Raising the nice level needs no privileges
+	if err := sandbox.Prioritize(cmd.Process.Pid, config.DaemonConfig{Nice: 5}); err != nil {
+		t.Fatalf("Prioritize() failed: %v", err)
+	}
+	got, err := syscall.Getpriority(syscall.PRIO_PROCESS, cmd.Process.Pid)
+	if err != nil {
+		t.Fatalf("Failed to read the priority: %v", err)
+	}
+	// Linux's raw syscall returns 20 - nice
+	if runtime.GOOS == "linux" {
+		got = 20 - got
+	}
+	if got != 5 {
+		t.Errorf("Expected nice level 5, got %d", got)
+	}
+}
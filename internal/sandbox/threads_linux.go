@@ -0,0 +1,22 @@
+package sandbox
+
+import (
+	"os"
+	"strconv"
+)
+
+// threads returns the IDs of pid's threads, which Linux prioritizes one
+// by one, or just pid if they can't be listed.
+func threads(pid int) []int {
+	entries, err := os.ReadDir("/proc/" + strconv.Itoa(pid) + "/task")
+	if err != nil {
+		return []int{pid}
+	}
+	tids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if tid, err := strconv.Atoi(entry.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids
+}
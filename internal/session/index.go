@@ -0,0 +1,255 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/transport"
+)
+
+const (
+	// IndexFileName is the name of the workspace index in the socket directory.
+	IndexFileName = "index.json"
+	// maxSocketNameLen caps the human-readable part of socket names, keeping
+	// full paths under the ~104 byte sun_path limit on macOS.
+	maxSocketNameLen = 32
+)
+
+// IndexEntry maps a workspace to its session socket. A workspace folder of
+// a multi-root session has its own entry naming the session's root.
+type IndexEntry struct {
+	ID            string    `json:"id"`
+	WorkspaceRoot string    `json:"workspace_root"`
+	SocketPath    string    `json:"socket_path"`
+	CreatedAt     time.Time `json:"created_at"`
+	Folder        string    `json:"folder,omitempty"` // Set on workspace folder entries
+}
+
+// WorkspaceHash returns the stable index key for a workspace root.
+func WorkspaceHash(workspaceRoot string) string {
+	if abs, err := filepath.Abs(workspaceRoot); err == nil {
+		workspaceRoot = abs
+	}
+	sum := sha256.Sum256([]byte(workspaceRoot))
+	return hex.EncodeToString(sum[:8])
+}
+
+// socketName derives a readable socket base name from a workspace root,
+// e.g. "/home/me/src/My Project" -> "my-project".
+func socketName(workspaceRoot string) string {
+	base := strings.ToLower(filepath.Base(workspaceRoot))
+
+	var b strings.Builder
+	for _, r := range base {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+
+	name := strings.Trim(b.String(), "-.")
+	if len(name) > maxSocketNameLen {
+		name = strings.TrimRight(name[:maxSocketNameLen], "-.")
+	}
+	if name == "" {
+		name = "workspace"
+	}
+	return name
+}
+
+// PipePath returns the named pipe a session named name listens on under
+// Windows, e.g. \\.\pipe\neocrush-my-project.
+func PipePath(name string) string {
+	return PipePrefix + name
+}
+
+// socketPath returns the path a session named name listens on: a socket in
+// the socket directory, or a named pipe on Windows.
+func (m *Manager) socketPath(name string) string {
+	if runtime.GOOS == "windows" {
+		return PipePath(name)
+	}
+	return filepath.Join(m.socketDir, name+".sock")
+}
+
+// Sockets returns the sockets in the socket directories, sorted, whether
+// or not the index names them (a daemon started by an older version, or
+// whose workspace was moved, is still listed). On Windows they are the
+// named pipes beginning with PipePrefix.
+func (m *Manager) Sockets() []string {
+	var paths []string
+	if runtime.GOOS == "windows" {
+		dir := PipePrefix[:strings.LastIndex(PipePrefix, `\`)+1]
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if path := dir + entry.Name(); strings.HasPrefix(path, PipePrefix) {
+				paths = append(paths, path)
+			}
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	for _, dir := range []string{m.socketDir, m.legacySocketDir} {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if entry.Type()&os.ModeSocket != 0 && filepath.Ext(entry.Name()) == ".sock" {
+				paths = append(paths, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// allocateSocketPath picks a socket path for workspaceRoot, adding a numeric
+// suffix when another workspace already owns the readable name.
+// Must be called with the index lock held (see updateIndex).
+func (m *Manager) allocateSocketPath(index map[string]IndexEntry, workspaceRoot string) string {
+	name := socketName(workspaceRoot)
+	hash := WorkspaceHash(workspaceRoot)
+
+	taken := make(map[string]bool)
+	for key, entry := range index {
+		if key != hash && entry.ID != index[hash].ID {
+			taken[entry.SocketPath] = true
+		}
+	}
+
+	for n := 1; ; n++ {
+		candidate := name
+		if n > 1 {
+			candidate = name + "-" + strconv.Itoa(n)
+		}
+		path := m.socketPath(candidate)
+		if taken[path] {
+			continue
+		}
+		// A live socket we don't know about (e.g. a daemon from another index) also collides
+		if transport.Exists(path) && index[hash].SocketPath != path {
+			continue
+		}
+		return path
+	}
+}
+
+// readIndex loads the workspace index. A missing or corrupt index is empty.
+func (m *Manager) readIndex() map[string]IndexEntry {
+	index := make(map[string]IndexEntry)
+
+	data, err := os.ReadFile(filepath.Join(m.socketDir, IndexFileName))
+	if err != nil {
+		return index
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return make(map[string]IndexEntry)
+	}
+	return index
+}
+
+// writeIndex atomically replaces the workspace index, dropping entries
+// whose workspace no longer exists.
+func (m *Manager) writeIndex(index map[string]IndexEntry) error {
+	for key, entry := range index {
+		if _, err := os.Stat(entry.WorkspaceRoot); err != nil {
+			delete(index, key)
+		}
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session index: %w", err)
+	}
+
+	path := filepath.Join(m.socketDir, IndexFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write session index: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write session index: %w", err)
+	}
+	return nil
+}
+
+// updateIndex applies fn to the index and persists the result.
+func (m *Manager) updateIndex(fn func(index map[string]IndexEntry)) error {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	index := m.readIndex()
+	fn(index)
+	return m.writeIndex(index)
+}
+
+// SetFolders records the workspace folders of session id besides its root,
+// so clients started in any of them find the session. Folders that already
+// have a session of their own keep it.
+func (m *Manager) SetFolders(id string, folders []string) error {
+	return m.updateIndex(func(index map[string]IndexEntry) {
+		var root IndexEntry
+		for key, entry := range index {
+			if entry.ID != id {
+				continue
+			}
+			if entry.Folder != "" {
+				delete(index, key)
+			} else {
+				root = entry
+			}
+		}
+		if root.ID == "" {
+			return
+		}
+		for _, folder := range folders {
+			key := WorkspaceHash(folder)
+			if _, taken := index[key]; taken {
+				continue
+			}
+			entry := root
+			entry.Folder = folder
+			index[key] = entry
+		}
+	})
+}
+
+// LookupWorkspace returns the indexed session for a workspace root (or a
+// workspace folder of a multi-root session) without reading the
+// workspace's .crush directory.
+func (m *Manager) LookupWorkspace(workspaceRoot string) (IndexEntry, bool) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	entry, ok := m.readIndex()[WorkspaceHash(workspaceRoot)]
+	return entry, ok
+}
+
+// IndexedSessions returns all indexed sessions, oldest first. Workspace
+// folder entries are left out.
+func (m *Manager) IndexedSessions() []IndexEntry {
+	m.indexMu.Lock()
+	index := m.readIndex()
+	m.indexMu.Unlock()
+
+	entries := make([]IndexEntry, 0, len(index))
+	for _, entry := range index {
+		if entry.Folder == "" {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries
+}
@@ -9,11 +9,13 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/taigrr/neocrush/internal/state"
+	"github.com/taigrr/neocrush/internal/transport"
 )
 
 const (
@@ -21,6 +23,13 @@ const (
 	SessionFileName = "session"
 	// SocketDirName is the name of the socket directory in runtime dir.
 	SocketDirName = "neocrush"
+	// LegacySocketDirName is the socket directory used by the retired crush-lsp binary.
+	LegacySocketDirName = "crush-lsp"
+	// PipePrefix begins the paths of the named pipes sessions use on Windows.
+	PipePrefix = `\\.\pipe\neocrush-`
+	// SchemaVersion is the current session file schema version.
+	// Files written before versioning was introduced are treated as version 0.
+	SchemaVersion = 1
 )
 
 // Session represents a paired Neovim/Crush session.
@@ -32,47 +41,76 @@ type Session struct {
 	NeovimPID     int       `json:"neovim_pid,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
 	SocketPath    string    `json:"socket_path"`
+	Daemon        DaemonInfo
 
 	state *state.State
 	mu    sync.RWMutex
 }
 
+// DaemonInfo identifies the daemon process serving a session. It is zero
+// until the daemon is listening.
+type DaemonInfo struct {
+	PID        int
+	Executable string // Absolute path of the daemon binary
+	Version    string // neocrush version of the daemon binary
+	StartedAt  time.Time
+}
+
 // SessionMetadata is the JSON-serializable session info stored in workspace.
+// Unknown fields are ignored on read so newer daemons' files stay loadable.
 type SessionMetadata struct {
+	Version       int       `json:"version"`
 	ID            string    `json:"id"`
 	WorkspaceRoot string    `json:"workspace_root"`
 	NeovimPID     int       `json:"neovim_pid,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
 	SocketPath    string    `json:"socket_path"`
+
+	// Set once the daemon is listening
+	DaemonPID        int       `json:"daemon_pid,omitempty"`
+	DaemonExecutable string    `json:"daemon_executable,omitempty"`
+	DaemonVersion    string    `json:"daemon_version,omitempty"`
+	DaemonStartedAt  time.Time `json:"daemon_started_at,omitzero"`
 }
 
 // Manager handles multiple concurrent sessions.
 type Manager struct {
-	mu        sync.RWMutex
-	sessions  map[string]*Session
-	socketDir string
+	mu              sync.RWMutex
+	sessions        map[string]*Session
+	socketDir       string
+	legacySocketDir string
+
+	// indexMu serializes read-modify-write cycles on the socket dir index.
+	indexMu sync.Mutex
 }
 
 // NewManager creates a new session manager.
 func NewManager() *Manager {
 	return &Manager{
-		sessions:  make(map[string]*Session),
-		socketDir: getSecureSocketDir(),
+		sessions:        make(map[string]*Session),
+		socketDir:       getSecureSocketDir(SocketDirName),
+		legacySocketDir: getSecureSocketDir(LegacySocketDirName),
 	}
 }
 
-// getSecureSocketDir returns a secure directory for sockets.
+// SocketDir returns the directory holding the session index and daemon
+// logs, and on Unix the session sockets.
+func (m *Manager) SocketDir() string {
+	return m.socketDir
+}
+
+// getSecureSocketDir returns a secure directory for sockets named name.
 // Uses XDG_RUNTIME_DIR on Linux, falls back to TMPDIR with UID on macOS.
-func getSecureSocketDir() string {
+func getSecureSocketDir(name string) string {
 	// Try XDG_RUNTIME_DIR first (Linux standard, secure tmpfs)
 	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
-		return filepath.Join(runtimeDir, SocketDirName)
+		return filepath.Join(runtimeDir, name)
 	}
 
 	// macOS/fallback: use TMPDIR with UID for isolation
 	tmpDir := os.TempDir()
 	uid := os.Getuid()
-	return filepath.Join(tmpDir, fmt.Sprintf("%s-%d", SocketDirName, uid))
+	return filepath.Join(tmpDir, fmt.Sprintf("%s-%d", name, uid))
 }
 
 // ensureSecureSocketDir creates the socket directory with secure permissions.
@@ -111,7 +149,9 @@ func GenerateSessionID() (string, error) {
 
 // CreateSession creates a new session with a unique ID.
 // The session file is written to <workspaceRoot>/.crush/session
-// The socket is created in the secure runtime directory.
+// The socket is created in the secure runtime directory, named after the
+// workspace (e.g. my-project.sock, my-project-2.sock on collision), and
+// recorded in the socket dir index.
 func (m *Manager) CreateSession(workspaceRoot string, neovimPID int) (*Session, error) {
 	id, err := GenerateSessionID()
 	if err != nil {
@@ -123,18 +163,28 @@ func (m *Manager) CreateSession(workspaceRoot string, neovimPID int) (*Session,
 		return nil, err
 	}
 
-	// Socket goes in secure runtime directory
-	socketPath := filepath.Join(m.socketDir, id+".sock")
-
 	session := &Session{
 		ID:            id,
 		WorkspaceRoot: workspaceRoot,
 		NeovimPID:     neovimPID,
 		CreatedAt:     time.Now(),
-		SocketPath:    socketPath,
 		state:         state.NewState(),
 	}
 
+	// Socket goes in secure runtime directory
+	err = m.updateIndex(func(index map[string]IndexEntry) {
+		session.SocketPath = m.allocateSocketPath(index, workspaceRoot)
+		index[WorkspaceHash(workspaceRoot)] = IndexEntry{
+			ID:            id,
+			WorkspaceRoot: workspaceRoot,
+			SocketPath:    session.SocketPath,
+			CreatedAt:     session.CreatedAt,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	// Save session file to workspace .crush folder
 	if err := m.saveWorkspaceSessionFile(session); err != nil {
 		return nil, err
@@ -194,9 +244,18 @@ func (m *Manager) loadSessionFromWorkspace(workspaceRoot string, checkSocket boo
 		return nil, fmt.Errorf("failed to parse session file: %w", err)
 	}
 
+	// Upgrade older files in place so the daemon can read its own metadata
+	// after an upgrade. Newer versions are read as-is and never rewritten.
+	if meta.Version < SchemaVersion {
+		migrateMetadata(&meta, workspaceRoot)
+		if err := writeMetadata(sessionFile, meta); err != nil {
+			return nil, err
+		}
+	}
+
 	// Verify socket still exists (only if requested)
 	if checkSocket {
-		if _, err := os.Stat(meta.SocketPath); err != nil {
+		if !transport.Exists(meta.SocketPath) {
 			// Socket gone, session is stale
 			os.Remove(sessionFile)
 			return nil, fmt.Errorf("session socket no longer exists")
@@ -210,6 +269,12 @@ func (m *Manager) loadSessionFromWorkspace(workspaceRoot string, checkSocket boo
 		CreatedAt:     meta.CreatedAt,
 		SocketPath:    meta.SocketPath,
 		state:         state.NewState(),
+		Daemon: DaemonInfo{
+			PID:        meta.DaemonPID,
+			Executable: meta.DaemonExecutable,
+			Version:    meta.DaemonVersion,
+			StartedAt:  meta.DaemonStartedAt,
+		},
 	}
 
 	m.mu.Lock()
@@ -277,7 +342,37 @@ func (m *Manager) RemoveSession(id string) error {
 	sessionFile := filepath.Join(session.WorkspaceRoot, ".crush", SessionFileName)
 	os.Remove(sessionFile)
 
-	return nil
+	// Drop the index entries (root and folders) still pointing at this session
+	return m.updateIndex(func(index map[string]IndexEntry) {
+		for key, entry := range index {
+			if entry.ID == id {
+				delete(index, key)
+			}
+		}
+	})
+}
+
+// RecordDaemon stores the daemon serving a loaded session in its session
+// file, so clients can tell a dead daemon from a slow one and spot a daemon
+// running a different version without scanning the process table.
+func (m *Manager) RecordDaemon(id string, daemon DaemonInfo) error {
+	session, ok := m.GetSession(id)
+	if !ok {
+		return fmt.Errorf("session %s not found in memory", id)
+	}
+
+	session.mu.Lock()
+	session.Daemon = daemon
+	session.mu.Unlock()
+
+	return m.saveWorkspaceSessionFile(session)
+}
+
+// DaemonDead reports whether the session's recorded daemon is no longer
+// running. Sessions whose daemon has not recorded its PID (including files
+// written by older versions) report false.
+func (s *Session) DaemonDead() bool {
+	return s.Daemon.PID > 0 && !IsProcessAlive(s.Daemon.PID)
 }
 
 // State returns the session's shared state.
@@ -297,19 +392,29 @@ func (m *Manager) saveWorkspaceSessionFile(session *Session) error {
 	}
 
 	meta := SessionMetadata{
+		Version:       SchemaVersion,
 		ID:            session.ID,
 		WorkspaceRoot: session.WorkspaceRoot,
 		NeovimPID:     session.NeovimPID,
 		CreatedAt:     session.CreatedAt,
 		SocketPath:    session.SocketPath,
+
+		DaemonPID:        session.Daemon.PID,
+		DaemonExecutable: session.Daemon.Executable,
+		DaemonVersion:    session.Daemon.Version,
+		DaemonStartedAt:  session.Daemon.StartedAt,
 	}
 
+	return writeMetadata(filepath.Join(crushDir, SessionFileName), meta)
+}
+
+// writeMetadata serializes meta to sessionFile.
+func writeMetadata(sessionFile string, meta SessionMetadata) error {
 	data, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal session metadata: %w", err)
 	}
 
-	sessionFile := filepath.Join(crushDir, SessionFileName)
 	if err := os.WriteFile(sessionFile, data, 0644); err != nil {
 		return fmt.Errorf("failed to write session file: %w", err)
 	}
@@ -317,10 +422,46 @@ func (m *Manager) saveWorkspaceSessionFile(session *Session) error {
 	return nil
 }
 
+// migrations upgrade session metadata one schema version at a time.
+// migrations[n] converts a version n file to version n+1.
+var migrations = []func(meta *SessionMetadata, workspaceRoot string){
+	// 0 -> 1: unversioned files from early neocrush and crush-lsp. crush-lsp
+	// omitted workspace_root and stored sockets under its own runtime dir;
+	// the socket path is kept so the session is recognized as legacy.
+	func(meta *SessionMetadata, workspaceRoot string) {
+		if meta.WorkspaceRoot == "" {
+			meta.WorkspaceRoot = workspaceRoot
+		}
+	},
+}
+
+// migrateMetadata applies all migrations needed to bring meta to SchemaVersion.
+func migrateMetadata(meta *SessionMetadata, workspaceRoot string) {
+	for meta.Version < SchemaVersion {
+		migrations[meta.Version](meta, workspaceRoot)
+		meta.Version++
+	}
+}
+
+// IsLegacy reports whether the session was created by the retired crush-lsp
+// binary. Legacy sessions are never reused; a fresh neocrush session replaces them.
+func (s *Session) IsLegacy() bool {
+	return filepath.Base(filepath.Dir(s.SocketPath)) == LegacySocketDirName ||
+		strings.HasPrefix(filepath.Base(filepath.Dir(s.SocketPath)), LegacySocketDirName+"-")
+}
+
 // CleanupStaleSessions removes sessions whose Neovim process is no longer running.
 func (m *Manager) CleanupStaleSessions() error {
+	if err := cleanupStaleSockets(m.socketDir); err != nil {
+		return err
+	}
+	return cleanupStaleSockets(m.legacySocketDir)
+}
+
+// cleanupStaleSockets removes old sockets in dir.
+func cleanupStaleSockets(dir string) error {
 	// Clean up sockets in runtime dir that don't have a live process
-	entries, err := os.ReadDir(m.socketDir)
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -333,7 +474,7 @@ func (m *Manager) CleanupStaleSessions() error {
 			continue
 		}
 
-		socketPath := filepath.Join(m.socketDir, entry.Name())
+		socketPath := filepath.Join(dir, entry.Name())
 
 		// Try to determine if socket is stale
 		// A socket is stale if we can't connect to it
@@ -356,9 +497,21 @@ func (m *Manager) CleanupOnShutdown(sessionID string) {
 	m.RemoveSession(sessionID)
 }
 
-// GetSocketPath returns the socket path for a session ID.
+// GetSocketPath returns the socket path for a session ID, consulting
+// loaded sessions first and then the socket dir index.
 func (m *Manager) GetSocketPath(sessionID string) string {
-	return filepath.Join(m.socketDir, sessionID+".sock")
+	if session, ok := m.GetSession(sessionID); ok {
+		return session.SocketPath
+	}
+
+	for _, entry := range m.IndexedSessions() {
+		if entry.ID == sessionID {
+			return entry.SocketPath
+		}
+	}
+
+	// Sessions created before named sockets used the ID directly
+	return m.socketPath(sessionID)
 }
 
 // IsProcessAlive checks if a process with the given PID is still running.
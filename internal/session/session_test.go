@@ -1,9 +1,13 @@
 package session_test
 
 import (
+	"encoding/json"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/taigrr/neocrush/internal/session"
 )
@@ -121,6 +125,43 @@ func TestLoadSessionMetadata(t *testing.T) {
 	}
 }
 
+func TestRecordDaemon(t *testing.T) {
+	tmpDir := t.TempDir()
+	mgr := session.NewManager()
+
+	created, err := mgr.CreateSession(tmpDir, 12345)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if created.DaemonDead() {
+		t.Fatal("A session without a daemon PID should not be reported dead")
+	}
+
+	started := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	daemon := session.DaemonInfo{PID: os.Getpid(), Executable: "/usr/bin/neocrush", Version: "1.2.3", StartedAt: started}
+	if err := mgr.RecordDaemon(created.ID, daemon); err != nil {
+		t.Fatalf("Failed to record daemon: %v", err)
+	}
+	loaded, err := session.NewManager().LoadSessionMetadata(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load session metadata: %v", err)
+	}
+	if !loaded.Daemon.StartedAt.Equal(started) || loaded.Daemon.Executable != daemon.Executable || loaded.Daemon.Version != daemon.Version {
+		t.Fatalf("Expected daemon %+v, got %+v", daemon, loaded.Daemon)
+	}
+	if loaded.Daemon.PID != os.Getpid() || loaded.DaemonDead() {
+		t.Fatalf("Expected a live daemon PID %d, got %d", os.Getpid(), loaded.Daemon.PID)
+	}
+
+	if err := mgr.RecordDaemon(created.ID, session.DaemonInfo{PID: 999999999}); err != nil {
+		t.Fatalf("Failed to record daemon: %v", err)
+	}
+	loaded, _ = session.NewManager().LoadSessionMetadata(tmpDir)
+	if !loaded.DaemonDead() {
+		t.Fatal("Expected a session whose daemon is gone to be reported dead")
+	}
+}
+
 func TestLoadSessionFromWorkspace_StaleSession(t *testing.T) {
 	tmpDir := t.TempDir()
 	mgr := session.NewManager()
@@ -290,3 +331,250 @@ func TestIsProcessAlive(t *testing.T) {
 		t.Fatal("PID 999999999 should not be alive")
 	}
 }
+
+func TestIsLegacy(t *testing.T) {
+	tests := []struct {
+		socketPath string
+		expected   bool
+	}{
+		{"/run/user/1000/neocrush/abc.sock", false},
+		{"/tmp/neocrush-1000/abc.sock", false},
+		{"/run/user/1000/crush-lsp/abc.sock", true},
+		{"/tmp/crush-lsp-1000/abc.sock", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.socketPath, func(t *testing.T) {
+			sess := &session.Session{SocketPath: tt.socketPath}
+			if got := sess.IsLegacy(); got != tt.expected {
+				t.Errorf("IsLegacy(%q) = %v, want %v", tt.socketPath, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadSessionMetadata_MigratesUnversioned(t *testing.T) {
+	tmpDir := t.TempDir()
+	crushDir := filepath.Join(tmpDir, ".crush")
+	if err := os.MkdirAll(crushDir, 0755); err != nil {
+		t.Fatalf("Failed to create .crush dir: %v", err)
+	}
+
+	// crush-lsp era file: no version, no workspace_root
+	legacy := `{"id":"0123456789abcdef","socket_path":"/tmp/crush-lsp-1000/0123456789abcdef.sock"}`
+	sessionFile := filepath.Join(crushDir, "session")
+	if err := os.WriteFile(sessionFile, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write session file: %v", err)
+	}
+
+	mgr := session.NewManager()
+	loaded, err := mgr.LoadSessionMetadata(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load legacy session: %v", err)
+	}
+
+	if loaded.WorkspaceRoot != tmpDir {
+		t.Fatalf("Expected workspace root %s, got %s", tmpDir, loaded.WorkspaceRoot)
+	}
+
+	if !loaded.IsLegacy() {
+		t.Fatal("Migrated crush-lsp session should still be recognized as legacy")
+	}
+
+	data, err := os.ReadFile(sessionFile)
+	if err != nil {
+		t.Fatalf("Failed to read session file: %v", err)
+	}
+
+	var meta session.SessionMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("Failed to parse migrated file: %v", err)
+	}
+
+	if meta.Version != session.SchemaVersion {
+		t.Fatalf("Expected version %d, got %d", session.SchemaVersion, meta.Version)
+	}
+}
+
+func TestLoadSessionMetadata_NewerVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+	crushDir := filepath.Join(tmpDir, ".crush")
+	if err := os.MkdirAll(crushDir, 0755); err != nil {
+		t.Fatalf("Failed to create .crush dir: %v", err)
+	}
+
+	future := `{"version":99,"id":"0123456789abcdef","workspace_root":"` + tmpDir + `","socket_path":"/tmp/x.sock","future_field":true}`
+	sessionFile := filepath.Join(crushDir, "session")
+	if err := os.WriteFile(sessionFile, []byte(future), 0644); err != nil {
+		t.Fatalf("Failed to write session file: %v", err)
+	}
+
+	mgr := session.NewManager()
+	loaded, err := mgr.LoadSessionMetadata(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load newer session: %v", err)
+	}
+
+	if loaded.ID != "0123456789abcdef" {
+		t.Fatalf("Expected ID 0123456789abcdef, got %s", loaded.ID)
+	}
+
+	// Newer files must not be rewritten (and lose unknown fields)
+	data, _ := os.ReadFile(sessionFile)
+	if string(data) != future {
+		t.Fatal("Newer session file should not be rewritten")
+	}
+}
+
+func TestCreateSession_NamedSockets(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	mgr := session.NewManager()
+
+	root1 := filepath.Join(t.TempDir(), "My Project")
+	root2 := filepath.Join(t.TempDir(), "my-project")
+	for _, root := range []string{root1, root2} {
+		if err := os.MkdirAll(root, 0755); err != nil {
+			t.Fatalf("Failed to create workspace: %v", err)
+		}
+	}
+
+	sess1, err := mgr.CreateSession(root1, 12345)
+	if err != nil {
+		t.Fatalf("Failed to create session 1: %v", err)
+	}
+	if want := socketPath(mgr, "my-project"); sess1.SocketPath != want {
+		t.Fatalf("Expected %s, got %s", want, sess1.SocketPath)
+	}
+
+	sess2, err := mgr.CreateSession(root2, 12346)
+	if err != nil {
+		t.Fatalf("Failed to create session 2: %v", err)
+	}
+	if want := socketPath(mgr, "my-project-2"); sess2.SocketPath != want {
+		t.Fatalf("Expected %s on collision, got %s", want, sess2.SocketPath)
+	}
+
+	// Recreating a session for the same workspace reuses its name
+	sess3, err := mgr.CreateSession(root1, 12347)
+	if err != nil {
+		t.Fatalf("Failed to create session 3: %v", err)
+	}
+	if sess3.SocketPath != sess1.SocketPath {
+		t.Fatalf("Expected socket %s to be reused, got %s", sess1.SocketPath, sess3.SocketPath)
+	}
+
+	// Index resolves workspaces without reading .crush
+	entry, ok := session.NewManager().LookupWorkspace(root2)
+	if !ok {
+		t.Fatal("Workspace should be in index")
+	}
+	if entry.ID != sess2.ID || entry.SocketPath != sess2.SocketPath {
+		t.Fatalf("Index entry mismatch: %+v", entry)
+	}
+
+	if err := mgr.RemoveSession(sess2.ID); err != nil {
+		t.Fatalf("Failed to remove session: %v", err)
+	}
+	if _, ok := mgr.LookupWorkspace(root2); ok {
+		t.Fatal("Removed session should be dropped from index")
+	}
+	if got := len(mgr.IndexedSessions()); got != 1 {
+		t.Fatalf("Expected 1 indexed session, got %d", got)
+	}
+}
+
+// socketPath is where a session named name listens.
+func socketPath(mgr *session.Manager, name string) string {
+	if runtime.GOOS == "windows" {
+		return session.PipePath(name)
+	}
+	return filepath.Join(mgr.SocketDir(), name+".sock")
+}
+
+func TestPipePath(t *testing.T) {
+	if got, want := session.PipePath("my-project-2"), `\\.\pipe\neocrush-my-project-2`; got != want {
+		t.Errorf("PipePath = %s, want %s", got, want)
+	}
+}
+
+func TestSockets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sessions listen on named pipes")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	mgr := session.NewManager()
+
+	sess, err := mgr.CreateSession(t.TempDir(), 12345)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	listener, err := net.Listen("unix", sess.SocketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// Only sockets count, not other files in the directory
+	if err := os.WriteFile(socketPath(mgr, "stray"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := mgr.Sockets(); len(got) != 1 || got[0] != sess.SocketPath {
+		t.Errorf("Sockets = %v, want [%s]", got, sess.SocketPath)
+	}
+}
+
+func TestSetFolders(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	mgr := session.NewManager()
+
+	root := t.TempDir()
+	folder := t.TempDir()
+	other := t.TempDir()
+
+	sess, err := mgr.CreateSession(root, 12345)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	otherSess, err := mgr.CreateSession(other, 12346)
+	if err != nil {
+		t.Fatalf("Failed to create other session: %v", err)
+	}
+
+	if err := mgr.SetFolders(sess.ID, []string{folder, other}); err != nil {
+		t.Fatalf("Failed to set folders: %v", err)
+	}
+
+	entry, ok := mgr.LookupWorkspace(folder)
+	if !ok {
+		t.Fatal("Folder should be in index")
+	}
+	if entry.ID != sess.ID || entry.WorkspaceRoot != root || entry.SocketPath != sess.SocketPath {
+		t.Fatalf("Folder entry mismatch: %+v", entry)
+	}
+
+	// A folder with its own session keeps it
+	if entry, _ := mgr.LookupWorkspace(other); entry.ID != otherSess.ID {
+		t.Fatalf("Expected %s to keep session %s, got %s", other, otherSess.ID, entry.ID)
+	}
+	if got := len(mgr.IndexedSessions()); got != 2 {
+		t.Fatalf("Expected 2 indexed sessions, got %d", got)
+	}
+
+	// Removed folders are dropped
+	if err := mgr.SetFolders(sess.ID, nil); err != nil {
+		t.Fatalf("Failed to clear folders: %v", err)
+	}
+	if _, ok := mgr.LookupWorkspace(folder); ok {
+		t.Fatal("Removed folder should be dropped from index")
+	}
+
+	if err := mgr.SetFolders(sess.ID, []string{folder}); err != nil {
+		t.Fatalf("Failed to set folders: %v", err)
+	}
+	if err := mgr.RemoveSession(sess.ID); err != nil {
+		t.Fatalf("Failed to remove session: %v", err)
+	}
+	if _, ok := mgr.LookupWorkspace(folder); ok {
+		t.Fatal("Folder entries should be removed with their session")
+	}
+}
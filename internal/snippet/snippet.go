@@ -0,0 +1,153 @@
+// Package snippet converts LSP snippet syntax to plain text for clients that
+// cannot expand snippets.
+package snippet
+
+import "strings"
+
+// ToPlainText returns the text a snippet inserts when every placeholder is
+// left at its default: tabstops and variables without defaults become empty,
+// placeholders keep their (recursively expanded) default, and choices take
+// their first option. Malformed constructs are kept literally.
+func ToPlainText(snippet string) string {
+	p := parser{s: snippet}
+	return p.text(false)
+}
+
+// IsSnippet reports whether s contains snippet syntax that ToPlainText
+// would change.
+func IsSnippet(s string) bool {
+	return ToPlainText(s) != s
+}
+
+type parser struct {
+	s string
+	i int
+}
+
+// text parses until the end of input or, when nested, an unescaped '}'
+// (left for the caller to consume).
+func (p *parser) text(nested bool) string {
+	var b strings.Builder
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch {
+		case c == '\\' && p.i+1 < len(p.s) && strings.IndexByte(`$}\`, p.s[p.i+1]) >= 0:
+			b.WriteByte(p.s[p.i+1])
+			p.i += 2
+		case c == '}' && nested:
+			return b.String()
+		case c == '$':
+			b.WriteString(p.dollar())
+		default:
+			b.WriteByte(c)
+			p.i++
+		}
+	}
+	return b.String()
+}
+
+// dollar parses a construct starting at '$' and returns its expansion.
+func (p *parser) dollar() string {
+	start := p.i
+	p.i++ // '$'
+
+	if name := p.ident(); name != "" {
+		return "" // $1, $0, $TM_FILENAME
+	}
+
+	if p.i >= len(p.s) || p.s[p.i] != '{' {
+		return "$"
+	}
+	p.i++ // '{'
+
+	if p.ident() == "" || p.i >= len(p.s) {
+		p.i = start + 1
+		return "$"
+	}
+
+	switch p.s[p.i] {
+	case '}': // ${1}
+		p.i++
+		return ""
+	case ':': // ${1:default}
+		p.i++
+		text := p.text(true)
+		if p.i < len(p.s) {
+			p.i++ // '}'
+		}
+		return text
+	case '|': // ${1|one,two|}
+		p.i++
+		return p.choice()
+	case '/': // ${TM_FILENAME/regex/format/}
+		p.skipBlock()
+		return ""
+	default:
+		p.i = start + 1
+		return "$"
+	}
+}
+
+// ident consumes a tabstop number or variable name.
+func (p *parser) ident() string {
+	start := p.i
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			p.i++
+			continue
+		}
+		break
+	}
+	return p.s[start:p.i]
+}
+
+// choice consumes "one,two|}" and returns the first option.
+func (p *parser) choice() string {
+	var first strings.Builder
+	done := false
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch {
+		case c == '\\' && p.i+1 < len(p.s):
+			if !done {
+				first.WriteByte(p.s[p.i+1])
+			}
+			p.i += 2
+		case c == '|' && p.i+1 < len(p.s) && p.s[p.i+1] == '}':
+			p.i += 2
+			return first.String()
+		case c == ',':
+			done = true
+			p.i++
+		default:
+			if !done {
+				first.WriteByte(c)
+			}
+			p.i++
+		}
+	}
+	return first.String()
+}
+
+// skipBlock consumes up to and including the '}' closing a transform,
+// skipping any ${...} format groups inside it.
+func (p *parser) skipBlock() {
+	depth := 0
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case '\\':
+			p.i += 2
+			continue
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				p.i++
+				return
+			}
+			depth--
+		}
+		p.i++
+	}
+}
@@ -0,0 +1,43 @@
+package snippet_test
+
+import (
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/snippet"
+)
+
+func TestToPlainText(t *testing.T) {
+	tests := []struct {
+		name    string
+		snippet string
+		want    string
+	}{
+		{"plain", "fmt.Println()", "fmt.Println()"},
+		{"tabstops", "for $1 := range $2 {\n\t$0\n}", "for  := range  {\n\t\n}"},
+		{"placeholder", "func ${1:name}() {}", "func name() {}"},
+		{"nested", "${1:foo(${2:bar})}", "foo(bar)"},
+		{"choice", "${1|one,two,three|}", "one"},
+		{"variable", "// ${TM_FILENAME:file}", "// file"},
+		{"transform", "${TM_FILENAME/(.*)/${1:/upcase}/}x", "x"},
+		{"escapes", `cost: \$5 \} \\`, `cost: $5 } \`},
+		{"bare dollar", "a $ b", "a $ b"},
+		{"unterminated", "${1:foo", "foo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippet.ToPlainText(tt.snippet); got != tt.want {
+				t.Errorf("ToPlainText(%q) = %q, want %q", tt.snippet, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSnippet(t *testing.T) {
+	if snippet.IsSnippet("plain text") {
+		t.Error("Expected plain text not to be a snippet")
+	}
+	if !snippet.IsSnippet("x := $1") {
+		t.Error("Expected tabstop to be a snippet")
+	}
+}
@@ -0,0 +1,133 @@
+// Package soak watches a long-running process's own resource use, such as
+// goroutines, heap and the sizes of its tables, for the steady growth that
+// points to a leak.
+package soak
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMinGrowth is the fraction a gauge must rise by across a window
+// to be flagged when Monitor.MinGrowth is unset.
+const DefaultMinGrowth = 0.1
+
+// Gauge is one measured quantity.
+type Gauge struct {
+	Name string
+	Read func() int64
+}
+
+// Growth is a gauge that rose without falling over a whole window.
+type Growth struct {
+	Gauge   string
+	From    int64     // Value at the start of the window
+	To      int64     // Latest value
+	Since   time.Time // When the window started
+	Samples int       // Samples in the window
+}
+
+type sample struct {
+	at    time.Time
+	value int64
+}
+
+// Monitor samples gauges and flags those that keep growing. It is safe for
+// concurrent use.
+type Monitor struct {
+	// MinGrowth is the fraction a gauge must rise by across a window, so
+	// values that settle after a step (a file opened once) aren't flagged.
+	MinGrowth float64
+
+	mu      sync.Mutex
+	window  int
+	gauges  []Gauge
+	samples map[string][]sample // Gauge -> its last window of samples
+	growing map[string]Growth   // Gauges flagged and still growing
+}
+
+// New returns a monitor flagging gauges that grow over window samples
+// (at least 3).
+func New(window int, gauges ...Gauge) *Monitor {
+	return &Monitor{
+		window:  max(window, 3),
+		gauges:  gauges,
+		samples: make(map[string][]sample),
+		growing: make(map[string]Growth),
+	}
+}
+
+// Sample reads every gauge at now and returns those newly found growing.
+// A flagged gauge is cleared once it falls.
+func (m *Monitor) Sample(now time.Time) []Growth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found []Growth
+	for _, g := range m.gauges {
+		samples := append(m.samples[g.Name], sample{at: now, value: g.Read()})
+		if len(samples) > m.window {
+			samples = slices.Delete(samples, 0, len(samples)-m.window)
+		}
+		m.samples[g.Name] = samples
+
+		growth, ok := m.growth(g.Name, samples)
+		if !ok {
+			delete(m.growing, g.Name)
+			continue
+		}
+		if _, flagged := m.growing[g.Name]; !flagged {
+			found = append(found, growth)
+		}
+		m.growing[g.Name] = growth
+	}
+	return found
+}
+
+// growth reports whether samples, a full window, never fell, rose on at
+// least half of their steps, and rose by MinGrowth overall.
+func (m *Monitor) growth(name string, samples []sample) (Growth, bool) {
+	if len(samples) < m.window {
+		return Growth{}, false
+	}
+	rises := 0
+	for i := 1; i < len(samples); i++ {
+		switch {
+		case samples[i].value < samples[i-1].value:
+			return Growth{}, false
+		case samples[i].value > samples[i-1].value:
+			rises++
+		}
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	minGrowth := m.MinGrowth
+	if minGrowth <= 0 {
+		minGrowth = DefaultMinGrowth
+	}
+	if 2*rises < len(samples)-1 || float64(last.value) < float64(first.value)*(1+minGrowth) || last.value == first.value {
+		return Growth{}, false
+	}
+	return Growth{
+		Gauge:   name,
+		From:    first.value,
+		To:      last.value,
+		Since:   first.at,
+		Samples: len(samples),
+	}, true
+}
+
+// Growing returns the gauges currently flagged, by name.
+func (m *Monitor) Growing() []Growth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	growing := make([]Growth, 0, len(m.growing))
+	for _, g := range m.growing {
+		growing = append(growing, g)
+	}
+	slices.SortFunc(growing, func(a, b Growth) int { return strings.Compare(a.Gauge, b.Gauge) })
+	return growing
+}
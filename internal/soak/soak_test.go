@@ -0,0 +1,54 @@
+package soak
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	values := map[string][]int64{
+		"leaking":  {10, 12, 12, 15, 18, 20, 21},
+		"steady":   {10, 11, 9, 10, 11, 10, 10},
+		"step":     {10, 10, 10, 40, 40, 40, 40}, // Grew once, then settled
+		"sawtooth": {10, 12, 14, 16, 5, 7, 9},
+	}
+	var i int
+	gauge := func(name string) Gauge {
+		return Gauge{Name: name, Read: func() int64 { return values[name][i] }}
+	}
+	m := New(4, gauge("leaking"), gauge("steady"), gauge("step"), gauge("sawtooth"))
+
+	start := time.Unix(0, 0)
+	var flagged []string
+	for i = range values["leaking"] {
+		for _, g := range m.Sample(start.Add(time.Duration(i) * time.Second)) {
+			flagged = append(flagged, g.Gauge)
+		}
+	}
+
+	// sawtooth was flagged on its climb and cleared when it fell
+	if len(flagged) != 2 || flagged[0] != "leaking" || flagged[1] != "sawtooth" {
+		t.Errorf("Expected leaking and sawtooth to be flagged once each, got %v", flagged)
+	}
+	growing := m.Growing()
+	if len(growing) != 1 {
+		t.Fatalf("Expected only leaking to be growing, got %+v", growing)
+	}
+	want := Growth{Gauge: "leaking", From: 15, To: 21, Since: start.Add(3 * time.Second), Samples: 4}
+	if growing[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, growing[0])
+	}
+}
+
+func TestMonitorNeedsFullWindow(t *testing.T) {
+	n := int64(0)
+	m := New(5, Gauge{Name: "n", Read: func() int64 { n += 10; return n }})
+	for i := range 4 {
+		if found := m.Sample(time.Unix(int64(i), 0)); len(found) > 0 {
+			t.Fatalf("Flagged after %d samples: %+v", i+1, found)
+		}
+	}
+	if found := m.Sample(time.Unix(4, 0)); len(found) != 1 {
+		t.Errorf("Expected growth over a full window, got %+v", found)
+	}
+}
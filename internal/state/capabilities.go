@@ -0,0 +1,85 @@
+package state
+
+import (
+	"sort"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// Client IDs the capability negotiation routes between.
+const (
+	EditorClient = "neovim"
+	AgentClient  = "crush"
+)
+
+// SetClientCapabilities records the capabilities a client declared in
+// initialize and renegotiates routed features.
+func (s *State) SetClientCapabilities(clientID string, caps lsp.ClientCapabilities) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clientCaps[clientID] = caps
+	s.negotiated = Negotiate(s.clientCaps)
+	s.version++
+}
+
+// RemoveClientCapabilities forgets a disconnected client's capabilities.
+func (s *State) RemoveClientCapabilities(clientID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clientCaps, clientID)
+	s.negotiated = Negotiate(s.clientCaps)
+	s.version++
+}
+
+// ClientCapabilities returns the capabilities a client declared.
+func (s *State) ClientCapabilities(clientID string) (lsp.ClientCapabilities, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	caps, ok := s.clientCaps[clientID]
+	return caps, ok
+}
+
+// Capabilities returns the currently negotiated routed features.
+func (s *State) Capabilities() lsp.NegotiatedCapabilities {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	negotiated := s.negotiated
+	negotiated.Clients = append([]string(nil), s.negotiated.Clients...)
+	return negotiated
+}
+
+// Negotiate computes routed features from declared client capabilities.
+// Features delivered to the editor depend only on the editor; features
+// exchanged between editor and agent require both. With no editor
+// connected, nothing is negotiated.
+func Negotiate(clients map[string]lsp.ClientCapabilities) lsp.NegotiatedCapabilities {
+	var negotiated lsp.NegotiatedCapabilities
+	for id := range clients {
+		negotiated.Clients = append(negotiated.Clients, id)
+	}
+	sort.Strings(negotiated.Clients)
+
+	editor, ok := clients[EditorClient]
+	if !ok {
+		return negotiated
+	}
+
+	negotiated.ApplyEdit = editor.Workspace.ApplyEdit
+	negotiated.DocumentChanges = editor.Workspace.WorkspaceEdit.DocumentChanges
+	negotiated.SnippetEdits = editor.Workspace.WorkspaceEdit.SnippetEditSupport
+	negotiated.CompletionSnippets = editor.TextDocument.Completion.CompletionItem.SnippetSupport
+	negotiated.ShowDocument = editor.Window.ShowDocument.Support
+	negotiated.InlayHintRefresh = editor.Workspace.InlayHint.RefreshSupport
+	negotiated.CodeLensRefresh = editor.Workspace.CodeLens.RefreshSupport
+
+	negotiated.WorkDoneProgress = editor.Window.WorkDoneProgress
+	if agent, ok := clients[AgentClient]; ok {
+		negotiated.WorkDoneProgress = negotiated.WorkDoneProgress && agent.Window.WorkDoneProgress
+	}
+
+	return negotiated
+}
@@ -0,0 +1,50 @@
+package state_test
+
+import (
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/state"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+func TestNegotiate(t *testing.T) {
+	var editor, agent lsp.ClientCapabilities
+	editor.Workspace.ApplyEdit = true
+	editor.Window.WorkDoneProgress = true
+
+	got := state.Negotiate(map[string]lsp.ClientCapabilities{state.EditorClient: editor})
+	if !got.ApplyEdit || !got.WorkDoneProgress {
+		t.Errorf("Expected editor features with only the editor connected, got %+v", got)
+	}
+
+	// Progress flows both ways, so the agent must support it too
+	got = state.Negotiate(map[string]lsp.ClientCapabilities{
+		state.EditorClient: editor,
+		state.AgentClient:  agent,
+	})
+	if !got.ApplyEdit || got.WorkDoneProgress {
+		t.Errorf("Expected workDoneProgress intersected away, got %+v", got)
+	}
+
+	got = state.Negotiate(map[string]lsp.ClientCapabilities{state.AgentClient: agent})
+	if got.ApplyEdit || len(got.Clients) != 1 {
+		t.Errorf("Expected nothing negotiated without an editor, got %+v", got)
+	}
+}
+
+func TestStateCapabilities(t *testing.T) {
+	s := state.NewState()
+
+	var editor lsp.ClientCapabilities
+	editor.Workspace.WorkspaceEdit.SnippetEditSupport = true
+	s.SetClientCapabilities(state.EditorClient, editor)
+
+	if !s.Capabilities().SnippetEdits {
+		t.Fatal("Expected snippet edits after editor declared support")
+	}
+
+	s.RemoveClientCapabilities(state.EditorClient)
+	if s.Capabilities().SnippetEdits {
+		t.Error("Expected snippet edits cleared after editor disconnected")
+	}
+}
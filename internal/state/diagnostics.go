@@ -1,40 +1,246 @@
 package state
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
+	"unicode/utf16"
 
 	"github.com/taigrr/neocrush/lsp"
 )
 
-// getDiagnosticsForFile generates diagnostics for file content.
-// This is a placeholder implementation - in production this would
-// delegate to real language analysis or external LSP servers.
-func getDiagnosticsForFile(text string) []lsp.Diagnostic {
-	diagnostics := []lsp.Diagnostic{}
+// DefaultProviderTimeout is how long a CommandProvider may run when it
+// sets no timeout.
+const DefaultProviderTimeout = 5 * time.Second
+
+// Snapshot is a document's content at one version, as providers see it.
+type Snapshot struct {
+	URI        string
+	LanguageID string
+	Text       string
+	Version    int
+}
+
+// Path returns the file path of the document, or "" if its URI is not a
+// file URI.
+func (s Snapshot) Path() string {
+	path, ok := strings.CutPrefix(s.URI, "file://")
+	if !ok {
+		return ""
+	}
+	return path
+}
+
+// Provider computes diagnostics for a document, like a linter. Providers
+// run outside the state lock and may be slow; an error drops only that
+// provider's diagnostics.
+type Provider interface {
+	Diagnose(ctx context.Context, doc Snapshot) ([]lsp.Diagnostic, error)
+}
+
+// ForLanguages limits p to documents with one of the given language IDs,
+// or to all documents if none are given.
+func ForLanguages(p Provider, languages ...string) Provider {
+	if len(languages) == 0 {
+		return p
+	}
+	return languageProvider{p: p, languages: languages}
+}
+
+type languageProvider struct {
+	p         Provider
+	languages []string
+}
+
+func (l languageProvider) Diagnose(ctx context.Context, doc Snapshot) ([]lsp.Diagnostic, error) {
+	if !slices.Contains(l.languages, doc.LanguageID) {
+		return nil, nil
+	}
+	return l.p.Diagnose(ctx, doc)
+}
+
+// ParseSeverity returns the LSP severity named by s ("error", "warning",
+// "information" or "info", "hint"), or 0 if s names none.
+func ParseSeverity(s string) int {
+	switch strings.ToLower(s) {
+	case "error":
+		return lsp.SeverityError
+	case "warning", "warn":
+		return lsp.SeverityWarning
+	case "information", "info":
+		return lsp.SeverityInformation
+	case "hint":
+		return lsp.SeverityHint
+	}
+	return 0
+}
+
+// Rule flags every match of Pattern.
+type Rule struct {
+	Pattern  *regexp.Regexp
+	Severity int // An lsp.Severity* value; warning if 0
+	// Message is expanded with the match's submatches ($1, ${name}); the
+	// matched text if empty.
+	Message string
+}
+
+// RegexProvider reports a diagnostic for each match of its rules on each
+// line of a document.
+type RegexProvider struct {
+	Source string
+	Rules  []Rule
+}
+
+// Diagnose implements Provider.
+func (p RegexProvider) Diagnose(_ context.Context, doc Snapshot) ([]lsp.Diagnostic, error) {
+	var diagnostics []lsp.Diagnostic
+	for row, line := range strings.Split(doc.Text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		for _, rule := range p.Rules {
+			for _, m := range rule.Pattern.FindAllStringSubmatchIndex(line, -1) {
+				if m[0] == m[1] {
+					continue
+				}
+				message := line[m[0]:m[1]]
+				if rule.Message != "" {
+					message = string(rule.Pattern.ExpandString(nil, rule.Message, line, m))
+				}
+				diagnostics = append(diagnostics, lsp.Diagnostic{
+					Range:    lineRange(row, utf16Column(line, m[0]), utf16Column(line, m[1])),
+					Severity: cmpSeverity(rule.Severity),
+					Source:   p.Source,
+					Message:  message,
+				})
+			}
+		}
+	}
+	return diagnostics, nil
+}
+
+// CommandProvider runs an external linter on a document. The command gets
+// the document's text on stdin, with "{path}" in its arguments replaced by
+// the document's path, and prints a JSON array of problems:
+//
+//	[{"line": 3, "column": 5, "end_line": 3, "end_column": 9,
+//	  "severity": "warning", "message": "unused variable", "source": "vet"}]
+//
+// Lines and columns are 1-indexed; the end is optional. A non-zero exit
+// status is expected from linters that found problems and is only an error
+// when the command printed nothing.
+type CommandProvider struct {
+	Source  string
+	Command []string
+	Dir     string        // Working directory; the daemon's if empty
+	Timeout time.Duration // DefaultProviderTimeout if 0
+}
+
+// commandProblem is one problem printed by a CommandProvider's command.
+type commandProblem struct {
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Source    string `json:"source"`
+}
+
+// Diagnose implements Provider.
+func (p CommandProvider) Diagnose(ctx context.Context, doc Snapshot) ([]lsp.Diagnostic, error) {
+	if len(p.Command) == 0 {
+		return nil, errors.New("no command")
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultProviderTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	for row, line := range strings.Split(text, "\n") {
-		if idx := strings.Index(line, "VS Code"); idx >= 0 {
-			diagnostics = append(diagnostics, lsp.Diagnostic{
-				Range:    lineRange(row, idx, idx+len("VS Code")),
-				Severity: 1,
-				Source:   "Common Sense",
-				Message:  "Please make sure we use good language in this video",
-			})
+	args := make([]string, len(p.Command))
+	for i, arg := range p.Command {
+		args[i] = strings.ReplaceAll(arg, "{path}", doc.Path())
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = p.Dir
+	cmd.Stdin = strings.NewReader(doc.Text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if len(bytes.TrimSpace(out)) == 0 {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w %s", args[0], err, strings.TrimSpace(stderr.String()))
 		}
+		return nil, nil
+	}
 
-		if idx := strings.Index(line, "Neovim"); idx >= 0 {
-			diagnostics = append(diagnostics, lsp.Diagnostic{
-				Range:    lineRange(row, idx, idx+len("Neovim")),
-				Severity: 2,
-				Source:   "Common Sense",
-				Message:  "Great choice :)",
-			})
+	var problems []commandProblem
+	if err := json.Unmarshal(out, &problems); err != nil {
+		return nil, fmt.Errorf("%s: invalid output: %w", args[0], err)
+	}
+	diagnostics := make([]lsp.Diagnostic, 0, len(problems))
+	for _, problem := range problems {
+		start := lsp.Position{Line: max(problem.Line-1, 0), Character: max(problem.Column-1, 0)}
+		end := start
+		if problem.EndLine > 0 {
+			end = lsp.Position{Line: problem.EndLine - 1, Character: max(problem.EndColumn-1, 0)}
+		}
+		source := problem.Source
+		if source == "" {
+			source = p.Source
 		}
+		diagnostics = append(diagnostics, lsp.Diagnostic{
+			Range:    lsp.Range{Start: start, End: end},
+			Severity: cmpSeverity(ParseSeverity(problem.Severity)),
+			Source:   source,
+			Message:  problem.Message,
+		})
 	}
+	return diagnostics, nil
+}
 
+// diagnose runs providers on doc in order, reporting the errors of those
+// that fail.
+func diagnose(providers []Provider, report func(error), doc Snapshot) []lsp.Diagnostic {
+	diagnostics := []lsp.Diagnostic{}
+	for _, p := range providers {
+		diags, err := p.Diagnose(context.Background(), doc)
+		if err != nil {
+			if report != nil {
+				report(fmt.Errorf("diagnostics for %s: %w", doc.URI, err))
+			}
+			continue
+		}
+		diagnostics = append(diagnostics, diags...)
+	}
 	return diagnostics
 }
 
+// cmpSeverity defaults an unset severity to a warning.
+func cmpSeverity(severity int) int {
+	if severity == 0 {
+		return lsp.SeverityWarning
+	}
+	return severity
+}
+
+// utf16Column converts a byte offset in line to the UTF-16 column LSP
+// positions count in.
+func utf16Column(line string, offset int) int {
+	column := 0
+	for _, r := range line[:offset] {
+		column += utf16.RuneLen(r) // Invalid bytes decode to U+FFFD, one unit
+	}
+	return column
+}
+
 func lineRange(line, start, end int) lsp.Range {
 	return lsp.Range{
 		Start: lsp.Position{
@@ -0,0 +1,96 @@
+package state_test
+
+import (
+	"context"
+	"regexp"
+	"runtime"
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/state"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+func TestRegexProvider(t *testing.T) {
+	p := state.RegexProvider{
+		Source: "style",
+		Rules: []state.Rule{
+			{Pattern: regexp.MustCompile(`fmt\.Print(ln)?`), Severity: lsp.SeverityHint, Message: "stray Print$1"},
+			{Pattern: regexp.MustCompile(`\s+$`)},
+		},
+	}
+	got, err := p.Diagnose(context.Background(), state.Snapshot{Text: "package a\n\t// é fmt.Println(x) \n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %+v", got)
+	}
+	// Columns count UTF-16 units, so é is one
+	want := lsp.Range{Start: lsp.Position{Line: 1, Character: 6}, End: lsp.Position{Line: 1, Character: 17}}
+	if got[0].Range != want || got[0].Message != "stray Println" || got[0].Severity != lsp.SeverityHint || got[0].Source != "style" {
+		t.Errorf("Unexpected first diagnostic %+v", got[0])
+	}
+	if got[1].Message != " " || got[1].Severity != lsp.SeverityWarning {
+		t.Errorf("Expected the matched text as a warning, got %+v", got[1])
+	}
+}
+
+func TestCommandProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	// Like most linters, it exits non-zero when it finds problems
+	p := state.CommandProvider{
+		Source:  "lint",
+		Command: []string{"sh", "-c", `read line; printf '[{"line":2,"column":3,"severity":"error","message":"%s in %s"}]' "$line" "$0"; exit 1`, "{path}"},
+	}
+	got, err := p.Diagnose(context.Background(), state.Snapshot{URI: "file:///src/a.py", Text: "first\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := lsp.Diagnostic{
+		Range:    lsp.Range{Start: lsp.Position{Line: 1, Character: 2}, End: lsp.Position{Line: 1, Character: 2}},
+		Severity: lsp.SeverityError,
+		Source:   "lint",
+		Message:  "first in /src/a.py",
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("Diagnose() = %+v, want %+v", got, want)
+	}
+
+	for _, command := range [][]string{
+		{"sh", "-c", "echo broken >&2; exit 2"},
+		{"echo", "not json"},
+	} {
+		p := state.CommandProvider{Command: command}
+		if _, err := p.Diagnose(context.Background(), state.Snapshot{}); err == nil {
+			t.Errorf("Expected an error from %q", command)
+		}
+	}
+}
+
+func TestStateProviders(t *testing.T) {
+	s := state.NewState()
+	if diags := s.OpenDocument("file:///a.go", "TODO", "go", 1); len(diags) != 0 {
+		t.Fatalf("Expected no diagnostics without providers, got %+v", diags)
+	}
+
+	var errs []error
+	s.SetProviders(func(err error) { errs = append(errs, err) },
+		state.ForLanguages(state.RegexProvider{Rules: []state.Rule{{Pattern: regexp.MustCompile(`TODO`)}}}, "go"),
+		state.CommandProvider{Command: []string{"neocrush-no-such-linter"}},
+	)
+	if diags := s.UpdateDocument("file:///a.go", "TODO", 2); len(diags) != 1 {
+		t.Errorf("Expected the go rule's diagnostic, got %+v", diags)
+	}
+	if len(s.GetDiagnostics("file:///a.go")) != 1 {
+		t.Error("Expected the diagnostic stored")
+	}
+	if diags := s.OpenDocument("file:///a.py", "TODO", "python", 1); len(diags) != 0 {
+		t.Errorf("Expected no diagnostics for python, got %+v", diags)
+	}
+	if len(errs) != 2 {
+		t.Errorf("Expected the failing provider reported twice, got %v", errs)
+	}
+}
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// DefaultMarkers are the comment markers found when none are configured.
+var DefaultMarkers = []string{"TODO", "FIXME", "HACK"}
+
+// Note is a comment starting with a marker, such as
+// "// TODO(ana): handle errors".
+type Note struct {
+	Line   int    // 0-indexed
+	Column int    // 0-indexed, in UTF-16 units, at the marker
+	Marker string // e.g. "TODO"
+	Owner  string // From "TODO(owner)", if given
+	Text   string // The rest of the comment
+}
+
+// Scanner finds marker comments and banned words. As a Provider it reports
+// them as information diagnostics.
+type Scanner struct {
+	markers *regexp.Regexp // nil finds no notes
+	banned  []*regexp.Regexp
+}
+
+// NewScanner returns a scanner for comments starting with one of markers
+// and for the banned words, regular expressions matched as whole words
+// regardless of case.
+func NewScanner(markers, banned []string) (*Scanner, error) {
+	s := &Scanner{}
+	if len(markers) > 0 {
+		quoted := make([]string, len(markers))
+		for i, m := range markers {
+			quoted[i] = regexp.QuoteMeta(m)
+		}
+		// A comment opener (//, #, --, /*, <!--, ;, or a block comment's
+		// leading *), the marker, an optional (owner) and colon, and the
+		// text up to a comment closer
+		s.markers = regexp.MustCompile(`(?://+|#+|--|/\*+|<!--|;+|^\s*\*)\s*(` + strings.Join(quoted, "|") +
+			`)\b(?:\(([^)]*)\))?:?\s*(.*?)\s*(?:\*/|-->)?\s*$`)
+	}
+	for _, pattern := range banned {
+		re, err := regexp.Compile(`(?i)\b(?:` + pattern + `)\b`)
+		if err != nil {
+			return nil, fmt.Errorf("banned word %q: %w", pattern, err)
+		}
+		s.banned = append(s.banned, re)
+	}
+	return s, nil
+}
+
+// Notes returns the marker comments in text, in order.
+func (s *Scanner) Notes(text string) []Note {
+	if s.markers == nil {
+		return nil
+	}
+	var notes []Note
+	for row, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		m := s.markers.FindStringSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		note := Note{
+			Line:   row,
+			Column: utf16Column(line, m[2]),
+			Marker: line[m[2]:m[3]],
+			Text:   line[m[6]:m[7]],
+		}
+		if m[4] >= 0 {
+			note.Owner = line[m[4]:m[5]]
+		}
+		notes = append(notes, note)
+	}
+	return notes
+}
+
+// Diagnose implements Provider.
+func (s *Scanner) Diagnose(_ context.Context, doc Snapshot) ([]lsp.Diagnostic, error) {
+	var diagnostics []lsp.Diagnostic
+	for _, note := range s.Notes(doc.Text) {
+		message := note.Marker
+		if note.Owner != "" {
+			message += "(" + note.Owner + ")"
+		}
+		if note.Text != "" {
+			message += ": " + note.Text
+		}
+		diagnostics = append(diagnostics, lsp.Diagnostic{
+			Range:    lineRange(note.Line, note.Column, note.Column+len(note.Marker)),
+			Severity: lsp.SeverityInformation,
+			Source:   "todo",
+			Message:  message,
+		})
+	}
+	if len(s.banned) == 0 {
+		return diagnostics, nil
+	}
+	for row, line := range strings.Split(doc.Text, "\n") {
+		for _, re := range s.banned {
+			for _, m := range re.FindAllStringIndex(line, -1) {
+				if m[0] == m[1] {
+					continue
+				}
+				diagnostics = append(diagnostics, lsp.Diagnostic{
+					Range:    lineRange(row, utf16Column(line, m[0]), utf16Column(line, m[1])),
+					Severity: lsp.SeverityInformation,
+					Source:   "banned words",
+					Message:  fmt.Sprintf("%q is a banned word", line[m[0]:m[1]]),
+				})
+			}
+		}
+	}
+	return diagnostics, nil
+}
@@ -0,0 +1,80 @@
+package state_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/state"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+func TestScannerNotes(t *testing.T) {
+	s, err := state.NewScanner(state.DefaultMarkers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := `package a
+
+// TODO(ana): handle errors
+var TODO = 1 // not a FIXME
+	/* FIXME: leaks */
+# HACK
+ * TODO in a block comment
+<!-- TODO: docs -->
+// TODOS are not markers
+`
+	want := []state.Note{
+		{Line: 2, Column: 3, Marker: "TODO", Owner: "ana", Text: "handle errors"},
+		{Line: 4, Column: 4, Marker: "FIXME", Text: "leaks"},
+		{Line: 5, Column: 2, Marker: "HACK"},
+		{Line: 6, Column: 3, Marker: "TODO", Text: "in a block comment"},
+		{Line: 7, Column: 5, Marker: "TODO", Text: "docs"},
+	}
+	if got := s.Notes(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("Notes() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	none, _ := state.NewScanner(nil, nil)
+	if got := none.Notes(text); got != nil {
+		t.Errorf("Expected no notes without markers, got %+v", got)
+	}
+}
+
+func TestScannerDiagnose(t *testing.T) {
+	if _, err := state.NewScanner(nil, []string{"(unclosed"}); err == nil {
+		t.Error("Expected an error for an invalid banned word")
+	}
+
+	s, err := state.NewScanner([]string{"XXX"}, []string{"master", "white ?list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Diagnose(context.Background(), state.Snapshot{Text: "# XXX(bo) rename\ngit push Master # whitelist, masters\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lsp.Diagnostic{
+		{
+			Range:    lsp.Range{Start: lsp.Position{Line: 0, Character: 2}, End: lsp.Position{Line: 0, Character: 5}},
+			Severity: lsp.SeverityInformation,
+			Source:   "todo",
+			Message:  "XXX(bo): rename",
+		},
+		{
+			Range:    lsp.Range{Start: lsp.Position{Line: 1, Character: 9}, End: lsp.Position{Line: 1, Character: 15}},
+			Severity: lsp.SeverityInformation,
+			Source:   "banned words",
+			Message:  `"Master" is a banned word`,
+		},
+		{
+			Range:    lsp.Range{Start: lsp.Position{Line: 1, Character: 18}, End: lsp.Position{Line: 1, Character: 27}},
+			Severity: lsp.SeverityInformation,
+			Source:   "banned words",
+			Message:  `"whitelist" is a banned word`,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diagnose() =\n%+v\nwant\n%+v", got, want)
+	}
+}
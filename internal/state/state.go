@@ -73,7 +73,12 @@ type State struct {
 	documents   map[string]*Document
 	cursors     map[string]*CursorState // clientID -> cursor
 	diagnostics map[string][]lsp.Diagnostic
+	clientCaps  map[string]lsp.ClientCapabilities // clientID -> declared capabilities
+	negotiated  lsp.NegotiatedCapabilities
 	version     int64 // monotonic state version for change detection
+
+	providers []Provider  // Compute diagnostics for documents
+	report    func(error) // Called with providers' errors
 }
 
 // NewState creates a new thread-safe state manager.
@@ -82,36 +87,63 @@ func NewState() *State {
 		documents:   make(map[string]*Document),
 		cursors:     make(map[string]*CursorState),
 		diagnostics: make(map[string][]lsp.Diagnostic),
+		clientCaps:  make(map[string]lsp.ClientCapabilities),
 	}
 }
 
-// OpenDocument opens a document and returns initial diagnostics.
-func (s *State) OpenDocument(uri, text, languageID string, version int) []lsp.Diagnostic {
+// SetProviders replaces the providers that compute documents' diagnostics,
+// which run in order. report, if set, is called with the errors of
+// providers that fail.
+func (s *State) SetProviders(report func(error), providers ...Provider) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.documents[uri] = NewDocument(uri, text, languageID, version)
+	s.providers = providers
+	s.report = report
+}
+
+// OpenDocument opens a document and returns initial diagnostics.
+func (s *State) OpenDocument(uri, text, languageID string, version int) []lsp.Diagnostic {
+	s.mu.Lock()
+	doc := NewDocument(uri, text, languageID, version)
+	s.documents[uri] = doc
 	s.version++
+	s.mu.Unlock()
 
-	diags := getDiagnosticsForFile(text)
-	s.diagnostics[uri] = diags
-	return diags
+	return s.refreshDiagnostics(doc, Snapshot{URI: uri, LanguageID: languageID, Text: text, Version: version})
 }
 
 // UpdateDocument updates a document and returns new diagnostics.
 func (s *State) UpdateDocument(uri, text string, version int) []lsp.Diagnostic {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if doc, ok := s.documents[uri]; ok {
+	doc, ok := s.documents[uri]
+	if ok {
 		doc.SetContent(text, version)
 	} else {
-		s.documents[uri] = NewDocument(uri, text, "", version)
+		doc = NewDocument(uri, text, "", version)
+		s.documents[uri] = doc
 	}
 	s.version++
+	s.mu.Unlock()
 
-	diags := getDiagnosticsForFile(text)
-	s.diagnostics[uri] = diags
+	return s.refreshDiagnostics(doc, Snapshot{URI: uri, LanguageID: doc.LanguageID, Text: text, Version: version})
+}
+
+// refreshDiagnostics runs the providers on snapshot, the new content of
+// doc, and stores their diagnostics unless doc changed meanwhile.
+func (s *State) refreshDiagnostics(doc *Document, snapshot Snapshot) []lsp.Diagnostic {
+	s.mu.RLock()
+	providers, report := s.providers, s.report
+	s.mu.RUnlock()
+
+	diags := diagnose(providers, report, snapshot)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.documents[snapshot.URI] == doc && doc.GetContent() == snapshot.Text {
+		s.diagnostics[snapshot.URI] = diags
+		s.version++
+	}
 	return diags
 }
 
@@ -238,6 +270,14 @@ func (s *State) GetVersion() int64 {
 	return s.version
 }
 
+// Touch bumps the state version for a change to state kept outside State,
+// such as the daemon's cursor and document caches.
+func (s *State) Touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+}
+
 // ListDocuments returns all open document URIs.
 func (s *State) ListDocuments() []string {
 	s.mu.RLock()
@@ -0,0 +1,394 @@
+// Package tracing records request/response spans and exports them to an
+// OpenTelemetry collector over OTLP/HTTP (JSON encoding). Trace context
+// travels between processes as a W3C traceparent string.
+//
+// A nil *Tracer and a nil *Span are valid and do nothing, so callers need
+// not check whether tracing is enabled.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize     = 256
+	flushInterval = 2 * time.Second
+	queueSize     = 4096
+	exportTimeout = 5 * time.Second
+)
+
+// Kind is an OTLP span kind.
+type Kind int
+
+// Span kinds.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2 // Serving a request from a client
+	KindClient   Kind = 3 // Waiting on a request sent to a peer
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid reports whether sc identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceIDString returns the hex trace ID, or "" for an invalid context.
+func (sc SpanContext) TraceIDString() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("00-%x-%x-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	return sc, sc.IsValid()
+}
+
+// Span is one timed operation. Its methods are safe for concurrent use.
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   Kind
+	ctx    SpanContext
+	parent [8]byte
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs map[string]any
+	err   string
+	ended bool
+}
+
+// Context returns the span's identity, or the zero SpanContext for a nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetAttr records an attribute. Values are strings, bools, or integers.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = message
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := s.export(time.Now())
+	s.mu.Unlock()
+
+	s.tracer.enqueue(data)
+}
+
+// Tracer creates spans and exports them in batches.
+type Tracer struct {
+	endpoint string
+	service  string
+	logger   *log.Logger
+	client   *http.Client
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan otlpSpan
+	done   chan struct{}
+}
+
+// New returns a tracer exporting to the OTLP/HTTP collector at endpoint
+// (e.g. "http://localhost:4318"), reporting spans as service.
+func New(endpoint, service string, logger *log.Logger) *Tracer {
+	t := &Tracer{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service:  service,
+		logger:   logger,
+		client:   &http.Client{Timeout: exportTimeout},
+		queue:    make(chan otlpSpan, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start begins a span. With a valid parent the span joins the parent's
+// trace; otherwise it starts a new trace.
+func (t *Tracer) Start(name string, kind Kind, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  make(map[string]any),
+	}
+	if parent.IsValid() {
+		s.ctx.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.ctx.TraceID[:])
+	}
+	rand.Read(s.ctx.SpanID[:])
+	return s
+}
+
+// Shutdown exports queued spans and stops the tracer.
+func (t *Tracer) Shutdown() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	<-t.done
+}
+
+func (t *Tracer) enqueue(span otlpSpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return // Ended after Shutdown
+	}
+	select {
+	case t.queue <- span:
+	default:
+		// Collector too slow; tracing must never block routing
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	for {
+		select {
+		case span, ok := <-t.queue:
+			if !ok {
+				t.export(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = nil
+		}
+	}
+}
+
+// export posts batch to the collector.
+func (t *Tracer) export(batch []otlpSpan) {
+	if len(batch) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []resourceSpans{{
+		Resource: resource{Attributes: []keyValue{attribute("service.name", t.service)}},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: "github.com/taigrr/neocrush"},
+			Spans: batch,
+		}},
+	}}})
+	if err != nil {
+		t.logger.Printf("Failed to encode spans: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		t.logger.Printf("Failed to export spans: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		t.logger.Printf("Failed to export %d spans: %v", len(batch), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		t.logger.Printf("Collector rejected %d spans: %s", len(batch), resp.Status)
+	}
+}
+
+// The OTLP/HTTP JSON encoding. IDs are hex and nanosecond timestamps are
+// decimal strings, per the OTLP JSON mapping.
+type otlpRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              Kind       `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"` // 2 = error
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+	IntValue    string  `json:"intValue,omitempty"`
+}
+
+func attribute(key string, value any) keyValue {
+	kv := keyValue{Key: key}
+	switch v := value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	case int:
+		kv.Value.IntValue = strconv.Itoa(v)
+	case int64:
+		kv.Value.IntValue = strconv.FormatInt(v, 10)
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+// export converts s for the collector. The caller holds s.mu.
+func (s *Span) export(end time.Time) otlpSpan {
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.ctx.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.ctx.SpanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+	}
+	if s.parent != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for _, key := range slices.Sorted(maps.Keys(s.attrs)) {
+		span.Attributes = append(span.Attributes, attribute(key, s.attrs[key]))
+	}
+	if s.err != "" {
+		span.Status = &status{Code: 2, Message: s.err}
+	}
+	return span
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
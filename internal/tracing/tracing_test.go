@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestTraceparent(t *testing.T) {
+	tracer := New("http://127.0.0.1:0", "test", log.New(io.Discard, "", 0))
+	defer tracer.Shutdown()
+
+	span := tracer.Start("root", KindServer, SpanContext{})
+	sc, ok := ParseTraceparent(span.Context().Traceparent())
+	if !ok || sc != span.Context() {
+		t.Fatalf("Expected %v to round-trip, got %v", span.Context(), sc)
+	}
+
+	child := tracer.Start("child", KindClient, sc)
+	if child.Context().TraceID != sc.TraceID || child.Context().SpanID == sc.SpanID {
+		t.Errorf("Expected child in the same trace with its own ID, got %v", child.Context())
+	}
+
+	for _, bad := range []string{"", "00-abc-def-01", "00-00000000000000000000000000000000-0000000000000000-01"} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start("noop", KindServer, SpanContext{})
+	span.SetAttr("k", "v")
+	span.SetError("failed")
+	span.End()
+	tracer.Shutdown()
+	if span.Context().IsValid() {
+		t.Error("Expected a nil tracer to produce no span context")
+	}
+}
+
+func TestExport(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		got  otlpRequest
+		path string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode export: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	tracer := New(server.URL, "neocrush-test", log.New(io.Discard, "", 0))
+	parent := tracer.Start("crush/getEditorContext", KindServer, SpanContext{})
+	child := tracer.Start("crush/definition", KindClient, parent.Context())
+	child.SetAttr("neocrush.to", "neovim")
+	child.SetError("timeout")
+	child.End()
+	parent.End()
+	tracer.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if path != "/v1/traces" {
+		t.Errorf("Expected export to /v1/traces, got %q", path)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected export: %+v", got)
+	}
+	if name := got.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; name == nil || *name != "neocrush-test" {
+		t.Errorf("Expected service.name neocrush-test, got %+v", got.ResourceSpans[0].Resource)
+	}
+
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].ParentSpanID != spans[1].SpanID || spans[0].TraceID != spans[1].TraceID {
+		t.Errorf("Expected child linked to parent: %+v", spans)
+	}
+	if spans[0].Status == nil || spans[0].Status.Message != "timeout" {
+		t.Errorf("Expected error status on child, got %+v", spans[0].Status)
+	}
+	if len(spans[0].Attributes) != 1 || spans[0].Attributes[0].Key != "neocrush.to" {
+		t.Errorf("Unexpected attributes: %+v", spans[0].Attributes)
+	}
+}
@@ -0,0 +1,231 @@
+//go:build windows
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// pipeBufferSize is the in and out buffer size of each pipe instance.
+const pipeBufferSize = 64 * 1024
+
+// pipeAddr is the address of a named pipe, its path.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is one connected instance of a named pipe. The handle is opened
+// for overlapped I/O, so the os.File supports deadlines.
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func newPipeConn(h windows.Handle, path string) *pipeConn {
+	return &pipeConn{File: os.NewFile(uintptr(h), path), addr: pipeAddr(path)}
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+// pipeListener serves a named pipe. One unconnected instance always waits
+// for the next client, so a client never finds the pipe missing between
+// two Accepts.
+type pipeListener struct {
+	path string
+	sa   *windows.SecurityAttributes
+	done windows.Handle // Event set by Close
+
+	mu     sync.Mutex
+	next   windows.Handle // The instance the next Accept connects
+	closed bool
+}
+
+// Listen creates the named pipe at path (\\.\pipe\<name>). Only the current
+// user may connect, and only from this machine. It fails if another
+// process already serves the pipe.
+func Listen(path string) (net.Listener, error) {
+	sa, err := ownerOnly()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build pipe security: %w", err)
+	}
+	done, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &pipeListener{path: path, sa: sa, done: done}
+	l.next, err = l.create(true)
+	if err != nil {
+		windows.CloseHandle(done)
+		return nil, err
+	}
+	return l, nil
+}
+
+// ownerOnly returns security attributes granting the current user, and
+// nobody else, access to a pipe: the equivalent of a 0600 socket.
+func ownerOnly() (*windows.SecurityAttributes, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	sd, err := windows.SecurityDescriptorFromString("D:P(A;;GA;;;" + user.User.Sid.String() + ")")
+	if err != nil {
+		return nil, err
+	}
+	return &windows.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}, nil
+}
+
+// create creates an instance of the pipe. The first instance must be new,
+// so two daemons can't serve the same path.
+func (l *pipeListener) create(first bool) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(l.path)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+	flags := uint32(windows.PIPE_ACCESS_DUPLEX | windows.FILE_FLAG_OVERLAPPED)
+	if first {
+		flags |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	mode := uint32(windows.PIPE_TYPE_BYTE | windows.PIPE_READMODE_BYTE | windows.PIPE_WAIT | windows.PIPE_REJECT_REMOTE_CLIENTS)
+	h, err := windows.CreateNamedPipe(name, flags, mode, windows.PIPE_UNLIMITED_INSTANCES, pipeBufferSize, pipeBufferSize, 0, l.sa)
+	if err != nil {
+		return windows.InvalidHandle, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(l.path), Err: err}
+	}
+	return h, nil
+}
+
+// Accept waits for a client to connect to the waiting instance, and
+// creates the next one.
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	h := l.next
+	l.next = 0
+	l.mu.Unlock()
+
+	if h == 0 {
+		var err error
+		if h, err = l.create(false); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.connect(h); err != nil {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+
+	l.mu.Lock()
+	if !l.closed {
+		// On failure the next Accept creates the instance itself
+		l.next, _ = l.create(false)
+	}
+	l.mu.Unlock()
+	return newPipeConn(h, l.path), nil
+}
+
+// connect waits for a client on instance h, or for Close.
+func (l *pipeListener) connect(h windows.Handle) error {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(event)
+
+	// Heap allocated: the kernel writes to it until the operation ends
+	o := &windows.Overlapped{HEvent: event}
+	err = windows.ConnectNamedPipe(h, o)
+	switch {
+	case err == nil, errors.Is(err, windows.ERROR_PIPE_CONNECTED):
+		return nil
+	case !errors.Is(err, windows.ERROR_IO_PENDING):
+		return &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.path), Err: err}
+	}
+
+	var n uint32
+	which, err := windows.WaitForMultipleObjects([]windows.Handle{event, l.done}, false, windows.INFINITE)
+	if err == nil && which == windows.WAIT_OBJECT_0 {
+		if err := windows.GetOverlappedResult(h, o, &n, false); err != nil {
+			return &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.path), Err: err}
+		}
+		return nil
+	}
+
+	windows.CancelIoEx(h, o)
+	windows.GetOverlappedResult(h, o, &n, true)
+	if err != nil {
+		return err
+	}
+	return net.ErrClosed
+}
+
+// Close stops Accept and removes the waiting instance. Connections already
+// accepted stay open.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.next != 0 {
+		windows.CloseHandle(l.next)
+		l.next = 0
+	}
+	return windows.SetEvent(l.done)
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr(l.path) }
+
+// Dial connects to the named pipe at path, waiting up to timeout (if
+// positive) while all its instances are busy.
+func Dial(path string, timeout time.Duration) (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING,
+			windows.FILE_FLAG_OVERLAPPED|windows.SECURITY_SQOS_PRESENT|windows.SECURITY_IDENTIFICATION, 0)
+		if err == nil {
+			return newPipeConn(h, path), nil
+		}
+		if !errors.Is(err, windows.ERROR_PIPE_BUSY) || (timeout > 0 && time.Now().After(deadline)) {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(path), Err: err}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Exists reports whether a named pipe is at path. Unlike os.Stat, looking
+// it up doesn't connect to (and use up) an instance.
+func Exists(path string) bool {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	var data windows.Win32finddata
+	h, err := windows.FindFirstFile(name, &data)
+	if err != nil {
+		return false
+	}
+	windows.FindClose(h)
+	return true
+}
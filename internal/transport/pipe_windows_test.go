@@ -0,0 +1,88 @@
+//go:build windows
+
+package transport_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/transport"
+)
+
+// testPipePath returns a pipe path no other test run is serving.
+func testPipePath() string {
+	return fmt.Sprintf(`\\.\pipe\neocrush-test-%d-%d`, os.Getpid(), time.Now().UnixNano())
+}
+
+func TestPipeListenAndDial(t *testing.T) {
+	path := testPipePath()
+	if transport.Exists(path) {
+		t.Fatalf("Expected no pipe at %s before listening", path)
+	}
+
+	listener, err := transport.NewSocketListener(path)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", path, err)
+	}
+	defer listener.Close()
+	if !transport.Exists(path) {
+		t.Fatalf("Expected the pipe at %s to exist", path)
+	}
+	if _, err := transport.Listen(path); err == nil {
+		t.Error("Expected a second listener on the same pipe to fail")
+	}
+
+	// The server echoes each message back
+	go func() {
+		for {
+			server, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer server.Close()
+				for {
+					_, content, err := server.Read()
+					if err != nil {
+						return
+					}
+					server.Write(json.RawMessage(content))
+				}
+			}()
+		}
+	}()
+
+	// Two clients at once, each on its own pipe instance
+	for i := range 2 {
+		client, err := transport.DialSocket(path)
+		if err != nil {
+			t.Fatalf("Failed to dial %s: %v", path, err)
+		}
+		defer client.Close()
+
+		msg := map[string]any{"jsonrpc": "2.0", "method": "test/echo", "params": map[string]any{"n": i}}
+		if err := client.Write(msg); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		method, content, err := client.Read()
+		if err != nil {
+			t.Fatalf("Failed to read the echo: %v", err)
+		}
+		var got struct {
+			Params struct {
+				N int `json:"n"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(content, &got); err != nil || method != "test/echo" || got.Params.N != i {
+			t.Errorf("Expected test/echo with n=%d echoed, got %s %s (%v)", i, method, content, err)
+		}
+	}
+
+	listener.Close()
+	if _, err := transport.Dial(path, 100*time.Millisecond); err == nil {
+		t.Error("Expected dialing a closed pipe to fail")
+	}
+}
@@ -11,7 +11,8 @@ import (
 	"github.com/taigrr/neocrush/rpc"
 )
 
-// SocketTransport implements Transport over Unix socket.
+// SocketTransport implements Transport over a Unix socket, or a named pipe
+// on Windows.
 type SocketTransport struct {
 	conn    net.Conn
 	reader  *bufio.Scanner
@@ -88,14 +89,9 @@ type SocketListener struct {
 	path     string
 }
 
-// NewSocketListener creates a new Unix socket listener.
+// NewSocketListener creates a new listener on the socket (or pipe) at path.
 func NewSocketListener(path string) (*SocketListener, error) {
-	// Remove existing socket file if present
-	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("failed to remove existing socket: %w", err)
-	}
-
-	listener, err := net.Listen("unix", path)
+	listener, err := Listen(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on socket: %w", err)
 	}
@@ -127,9 +123,9 @@ func (l *SocketListener) Path() string {
 	return l.path
 }
 
-// DialSocket connects to a Unix socket and returns a transport.
+// DialSocket connects to a socket (or pipe) and returns a transport.
 func DialSocket(path string) (*SocketTransport, error) {
-	conn, err := net.Dial("unix", path)
+	conn, err := Dial(path, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to socket: %w", err)
 	}
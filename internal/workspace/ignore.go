@@ -0,0 +1,122 @@
+package workspace
+
+import (
+	"path"
+	"strings"
+)
+
+// rule is one line of a .gitignore file (or an exclude pattern).
+type rule struct {
+	base     string // Directory the rule applies under, relative to the root ("" = root)
+	pattern  string // Slash-separated glob
+	negate   bool   // "!" prefix: re-include
+	dirOnly  bool   // Trailing "/": matches directories only
+	anchored bool   // Contains a non-trailing "/": matched from base, not at any depth
+}
+
+// Matcher decides which workspace paths are ignored, following .gitignore
+// semantics: the last matching rule wins, "!" re-includes, a trailing "/"
+// matches directories only, and patterns without a "/" match at any depth.
+type Matcher struct {
+	rules []rule
+}
+
+// Add parses gitignore-style lines that apply under base, a slash-separated
+// directory relative to the workspace root ("" for the root).
+func (m *Matcher) Add(base string, lines []string) {
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " ")
+
+		r := rule{base: base}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:] // Escaped leading "#" or "!"
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		m.rules = append(m.rules, r)
+	}
+}
+
+// Ignored reports whether rel, a slash-separated path relative to the
+// workspace root, is ignored.
+func (m *Matcher) Ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			sub = rel[len(r.base)+1:]
+		}
+
+		var matched bool
+		if r.anchored {
+			matched = matchPath(r.pattern, sub)
+		} else {
+			matched, _ = path.Match(r.pattern, path.Base(sub))
+		}
+		if matched {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// Matches reports whether rel or any directory containing it is matched,
+// the way git treats everything under an ignored directory as ignored.
+func (m *Matcher) Matches(rel string) bool {
+	for i := range len(rel) {
+		if rel[i] == '/' && m.Ignored(rel[:i], true) {
+			return true
+		}
+	}
+	return m.Ignored(rel, false)
+}
+
+// matchPath matches a slash-separated glob against a path segment by
+// segment; "**" matches any number of segments.
+func matchPath(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,74 @@
+package workspace
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// sniffLen is how much of a file CountLines checks for NUL bytes.
+const sniffLen = 8000
+
+// testDirs are directories whose files are tests.
+var testDirs = map[string]bool{"test": true, "tests": true, "__tests__": true, "spec": true}
+
+// IsTest reports whether the file at p (slash-separated, relative to the
+// root) is a test by the naming conventions of common languages: Go's
+// _test.go, Python's test_*.py, JavaScript's .test. and .spec. files, Ruby's
+// _spec.rb, and files under test directories.
+func IsTest(p string) bool {
+	name := path.Base(p)
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	switch {
+	case strings.HasSuffix(stem, "_test"), strings.HasSuffix(stem, "_spec"):
+		return true
+	case ext == ".py" && strings.HasPrefix(stem, "test_"):
+		return true
+	case strings.HasSuffix(stem, ".test"), strings.HasSuffix(stem, ".spec"):
+		return true
+	}
+	for _, dir := range strings.Split(path.Dir(p), "/") {
+		if testDirs[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// CountLines returns the number of lines in the file at p, counting a last
+// line without a newline, and whether it is text. Files with a NUL byte
+// near the start are binary and not counted.
+func CountLines(p string) (int, bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32<<10)
+	lines, last, first := 0, byte('\n'), true
+	for {
+		n, err := f.Read(buf)
+		if first && bytes.IndexByte(buf[:min(n, sniffLen)], 0) >= 0 {
+			return 0, false, nil
+		}
+		first = false
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		if n > 0 {
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	if last != '\n' {
+		lines++
+	}
+	return lines, true, nil
+}
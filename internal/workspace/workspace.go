@@ -0,0 +1,136 @@
+// Package workspace lists the files of a workspace the way git sees them:
+// .gitignore files (at any depth) and extra exclude patterns are honored,
+// and the .git directory is skipped.
+package workspace
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// File is a file in the workspace.
+type File struct {
+	Path     string `json:"path"`               // Slash-separated, relative to the root
+	Size     int64  `json:"size"`               // Bytes
+	Language string `json:"language,omitempty"` // LSP language ID, if known
+}
+
+// List walks root and returns its files in lexical order, skipping paths
+// ignored by .gitignore files or matched by exclude (gitignore syntax,
+// relative to root). At most limit files are returned (0 = no limit); the
+// second result reports whether the listing was cut short.
+func List(root string, exclude []string, limit int) ([]File, bool, error) {
+	var m Matcher
+	m.Add("", exclude)
+
+	var files []File
+	truncated := false
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil // Unreadable entries are skipped
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == "." {
+				addGitignore(&m, p, "")
+				return nil
+			}
+			if d.Name() == ".git" || m.Ignored(rel, true) {
+				return filepath.SkipDir
+			}
+			addGitignore(&m, p, rel)
+			return nil
+		}
+
+		if !d.Type().IsRegular() || m.Ignored(rel, false) {
+			return nil
+		}
+		if limit > 0 && len(files) >= limit {
+			truncated = true
+			return filepath.SkipAll
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, File{
+			Path:     rel,
+			Size:     info.Size(),
+			Language: Language(rel),
+		})
+		return nil
+	})
+	return files, truncated, err
+}
+
+// addGitignore adds the rules of dir/.gitignore, if present.
+func addGitignore(m *Matcher, dir, base string) {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	m.Add(base, strings.Split(string(data), "\n"))
+}
+
+// languages maps file extensions to LSP language IDs.
+var languages = map[string]string{
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".css":   "css",
+	".go":    "go",
+	".html":  "html",
+	".java":  "java",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".jsx":   "javascriptreact",
+	".json":  "json",
+	".kt":    "kotlin",
+	".lua":   "lua",
+	".md":    "markdown",
+	".php":   "php",
+	".py":    "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".scss":  "scss",
+	".sh":    "shellscript",
+	".bash":  "shellscript",
+	".sql":   "sql",
+	".swift": "swift",
+	".toml":  "toml",
+	".ts":    "typescript",
+	".tsx":   "typescriptreact",
+	".vim":   "vim",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".zig":   "zig",
+}
+
+// Language returns the LSP language ID for a file name, or "" if unknown.
+func Language(name string) string {
+	switch path.Base(name) {
+	case "Makefile", "makefile", "GNUmakefile":
+		return "makefile"
+	case "Dockerfile":
+		return "dockerfile"
+	case "go.mod":
+		return "go.mod"
+	}
+	return languages[strings.ToLower(path.Ext(name))]
+}
@@ -0,0 +1,198 @@
+package workspace_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/taigrr/neocrush/internal/workspace"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	var m workspace.Matcher
+	m.Add("", []string{
+		"# comment",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"/root-only.txt",
+		"docs/**/*.tmp",
+	})
+	m.Add("sub", []string{"local.txt"})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"app.log", false, true},
+		{"deep/nested/app.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"root-only.txt", false, true},
+		{"sub/root-only.txt", false, false},
+		{"docs/a/b/x.tmp", false, true},
+		{"docs/x.tmp", false, true},
+		{"other/x.tmp", false, false},
+		{"sub/local.txt", false, true},
+		{"local.txt", false, false},
+		{"main.go", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := m.Ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherMatches(t *testing.T) {
+	var m workspace.Matcher
+	m.Add("", []string{"go.sum", ".github/**", "vendor/", "!vendor/keep.go"})
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"go.sum", true},
+		{"tools/go.sum", true},
+		{".github/workflows/ci.yml", true},
+		{"vendor/a/b.go", true},
+		{"vendor/keep.go", true}, // A matched directory covers its files
+		{"main.go", false},
+		{"vendored/x.go", false},
+	}
+
+	for _, tt := range tests {
+		if got := m.Matches(tt.path); got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".gitignore"), "node_modules/\n*.out\n")
+	writeFile(t, filepath.Join(root, "main.go"), "package main\n")
+	writeFile(t, filepath.Join(root, "a.out"), "binary")
+	writeFile(t, filepath.Join(root, "node_modules", "x", "index.js"), "")
+	writeFile(t, filepath.Join(root, ".git", "HEAD"), "ref: refs/heads/main\n")
+	writeFile(t, filepath.Join(root, "pkg", ".gitignore"), "gen.go\n")
+	writeFile(t, filepath.Join(root, "pkg", "gen.go"), "")
+	writeFile(t, filepath.Join(root, "pkg", "lib.py"), "")
+	writeFile(t, filepath.Join(root, "vendor", "dep.go"), "")
+
+	files, truncated, err := workspace.List(root, []string{"vendor/"}, 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if truncated {
+		t.Error("Expected a complete listing")
+	}
+
+	got := map[string]workspace.File{}
+	for _, f := range files {
+		got[f.Path] = f
+	}
+	want := []string{".gitignore", "main.go", "pkg/.gitignore", "pkg/lib.py"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, files)
+	}
+	for _, p := range want {
+		if _, ok := got[p]; !ok {
+			t.Errorf("Expected %s in %v", p, files)
+		}
+	}
+	if f := got["main.go"]; f.Size != 13 || f.Language != "go" {
+		t.Errorf("Unexpected entry for main.go: %+v", f)
+	}
+	if f := got["pkg/lib.py"]; f.Language != "python" {
+		t.Errorf("Expected python for lib.py, got %q", f.Language)
+	}
+
+	files, truncated, err = workspace.List(root, nil, 2)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(files) != 2 || !truncated {
+		t.Errorf("Expected 2 files and truncation, got %d (truncated=%v)", len(files), truncated)
+	}
+}
+
+func TestBranch(t *testing.T) {
+	root := t.TempDir()
+	if got := workspace.Branch(root); got != "" {
+		t.Errorf("Branch() outside a repository = %q, want empty", got)
+	}
+
+	writeFile(t, filepath.Join(root, ".git", "HEAD"), "ref: refs/heads/release/1.2\n")
+	sub := filepath.Join(root, "pkg")
+	writeFile(t, filepath.Join(sub, "a.go"), "package pkg\n")
+	if got := workspace.Branch(sub); got != "release/1.2" {
+		t.Errorf("Branch() = %q, want release/1.2", got)
+	}
+
+	writeFile(t, filepath.Join(root, ".git", "HEAD"), "0123456789abcdef0123456789abcdef01234567\n")
+	if got := workspace.Branch(root); got != "" {
+		t.Errorf("Branch() with a detached HEAD = %q, want empty", got)
+	}
+
+	// A worktree's .git file points to its git directory
+	worktree := filepath.Join(t.TempDir(), "wt")
+	writeFile(t, filepath.Join(root, ".git", "worktrees", "wt", "HEAD"), "ref: refs/heads/feature\n")
+	writeFile(t, filepath.Join(worktree, ".git"), "gitdir: "+filepath.Join(root, ".git", "worktrees", "wt")+"\n")
+	if got := workspace.Branch(worktree); got != "feature" {
+		t.Errorf("Branch() in a worktree = %q, want feature", got)
+	}
+}
+
+func TestIsTest(t *testing.T) {
+	for p, want := range map[string]bool{
+		"main_test.go":             true,
+		"main.go":                  false,
+		"pkg/test_util.py":         true,
+		"pkg/util.py":              false,
+		"src/app.test.ts":          true,
+		"src/app.spec.js":          true,
+		"spec/models/user_spec.rb": true,
+		"tests/integration.rs":     true,
+		"src/latest.go":            false,
+		"contest/main.c":           false,
+	} {
+		if got := workspace.IsTest(p); got != want {
+			t.Errorf("IsTest(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	dir := t.TempDir()
+	for name, tt := range map[string]struct {
+		content string
+		lines   int
+		text    bool
+	}{
+		"empty":   {"", 0, true},
+		"newline": {"a\nb\n", 2, true},
+		"no-eol":  {"a\nb", 2, true},
+		"binary":  {"a\x00b\n", 0, false},
+	} {
+		p := filepath.Join(dir, name)
+		writeFile(t, p, tt.content)
+		lines, text, err := workspace.CountLines(p)
+		if err != nil || lines != tt.lines || text != tt.text {
+			t.Errorf("%s: got %d lines, text %v (%v), want %d, %v", name, lines, text, err, tt.lines, tt.text)
+		}
+	}
+}
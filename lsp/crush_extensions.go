@@ -1,5 +1,10 @@
 package lsp
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // CursorMovedNotification is sent by the client when cursor position changes.
 // Method: crush/cursorMoved
 type CursorMovedNotification struct {
@@ -12,6 +17,8 @@ type CursorMovedParams struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
 	Position     Position               `json:"position"`
 	Selection    *Range                 `json:"selection,omitempty"`
+	Cursors      []Position             `json:"cursors,omitempty"`     // Secondary cursors (multi-cursor plugins)
+	LineContent  *string                `json:"lineContent,omitempty"` // Text of the cursor's line, if the plugin sends it
 }
 
 // SelectionChangedNotification is sent when selection changes.
@@ -41,7 +48,7 @@ type DocumentChangedNotification struct {
 type DocumentChangedParams struct {
 	TextDocument VersionTextDocumentIdentifier `json:"textDocument"`
 	Content      string                        `json:"content"`
-	ChangeSource string                        `json:"changeSource"` // "neovim" or "crush"
+	ChangeSource string                        `json:"changeSource"` // "neovim", "crush", or "formatter"
 }
 
 // FocusChangedNotification is broadcast when focused document changes.
@@ -66,9 +73,10 @@ type GetStateRequest struct {
 
 // GetStateParams specifies what state to retrieve.
 type GetStateParams struct {
-	IncludeContent     bool `json:"includeContent,omitempty"`
-	IncludeDiagnostics bool `json:"includeDiagnostics,omitempty"`
-	IncludeCursor      bool `json:"includeCursor,omitempty"`
+	IncludeContent      bool `json:"includeContent,omitempty"`
+	IncludeDiagnostics  bool `json:"includeDiagnostics,omitempty"`
+	IncludeCursor       bool `json:"includeCursor,omitempty"`
+	IncludeCapabilities bool `json:"includeCapabilities,omitempty"`
 }
 
 // GetStateResponse returns current editor state.
@@ -82,6 +90,51 @@ type GetStateResult struct {
 	FocusedDocument *TextDocumentIdentifier `json:"focusedDocument,omitempty"`
 	Cursor          *CursorInfo             `json:"cursor,omitempty"`
 	OpenDocuments   []DocumentInfo          `json:"openDocuments,omitempty"`
+	Capabilities    *NegotiatedCapabilities `json:"capabilities,omitempty"`
+	Version         int64                   `json:"version"` // State version, as returned by crush/stateVersion
+}
+
+// StateVersionRequest asks for the daemon's state version.
+// Method: crush/stateVersion
+// The version changes whenever the cursor, selection, open documents,
+// document content or negotiated capabilities do, so clients can reuse
+// editor context fetched at the same version.
+type StateVersionRequest struct {
+	Request
+}
+
+// StateVersionResult carries the state version.
+type StateVersionResult struct {
+	Version int64 `json:"version"`
+}
+
+// SetLogLevelRequest subscribes the caller to the daemon's log.
+// Method: crush/setLogLevel
+// Lines at the level and above are sent as crush/logMessage until the
+// client disconnects. Calling it again changes the level.
+type SetLogLevelRequest struct {
+	Request
+	Params SetLogLevelParams `json:"params"`
+}
+
+// SetLogLevelParams uses the MCP logging levels: debug, info, notice,
+// warning, error, critical, alert, emergency.
+type SetLogLevelParams struct {
+	Level string `json:"level"`
+}
+
+// LogMessageNotification is a daemon log line.
+// Method: crush/logMessage
+type LogMessageNotification struct {
+	Notification
+	Params LogMessageParams `json:"params"`
+}
+
+// LogMessageParams describes one log line.
+type LogMessageParams struct {
+	Level   string `json:"level"`  // MCP logging level
+	Logger  string `json:"logger"` // "neocrush"
+	Message string `json:"message"`
 }
 
 // CursorInfo contains current cursor position and context.
@@ -89,9 +142,19 @@ type CursorInfo struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
 	Position     Position               `json:"position"`
 	Selection    *Range                 `json:"selection,omitempty"`
+	Selections   []Range                `json:"selections,omitempty"` // All selected ranges; Selection is the first
+	Cursors      []Position             `json:"cursors,omitempty"`    // Secondary cursors
 	LineContent  string                 `json:"lineContent,omitempty"`
 }
 
+// SelectionContext is one selected range and its text, as reported by
+// crush/getEditorContext. Positions are LSP positions (0-indexed lines,
+// UTF-16 characters), ready to anchor edits to.
+type SelectionContext struct {
+	Range Range  `json:"range"`
+	Text  string `json:"text,omitempty"` // Empty if the document is not cached
+}
+
 // DocumentInfo contains document metadata and optionally content.
 type DocumentInfo struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
@@ -111,7 +174,8 @@ type EditFileRequest struct {
 
 // EditFileParams specifies the edits to apply.
 type EditFileParams struct {
-	TextDocument VersionTextDocumentIdentifier `json:"textDocument"`
+	TextDocument VersionTextDocumentIdentifier `json:"textDocument,omitzero"`
+	Path         string                        `json:"path,omitempty"` // Workspace path (relative to the root) instead of textDocument, as MCP tools give it
 	Edits        []TextEdit                    `json:"edits"`
 }
 
@@ -195,10 +259,1558 @@ type ShowLocationsParams struct {
 
 // LocationItem represents a single location with AI-generated context.
 type LocationItem struct {
-	Filename string `json:"filename"`          // Absolute or relative path
-	Line     int    `json:"lnum"`              // 1-indexed line number
-	Col      int    `json:"col,omitempty"`     // 1-indexed column (optional)
-	Text     string `json:"text"`              // The code snippet at this location
-	Note     string `json:"note"`              // AI explanation of why this location matters
-	Type     string `json:"type,omitempty"`    // E/W/I/N (error/warn/info/note), default N
+	Filename string `json:"filename"`       // Absolute or relative path
+	Line     int    `json:"lnum"`           // 1-indexed line number
+	Col      int    `json:"col,omitempty"`  // 1-indexed column (optional)
+	Text     string `json:"text"`           // The code snippet at this location
+	Note     string `json:"note"`           // AI explanation of why this location matters
+	Type     string `json:"type,omitempty"` // E/W/I/N (error/warn/info/note), default N
+}
+
+// InlayHintsNotification is sent by Crush to publish AI-generated inlay
+// hints for a document, replacing any it published before.
+// Method: crush/inlayHints
+// The daemon caches the hints and serves them to Neovim's native
+// textDocument/inlayHint requests.
+type InlayHintsNotification struct {
+	Notification
+	Params InlayHintsParams `json:"params"`
+}
+
+// InlayHintsParams contains the hints for one document. An empty list clears them.
+type InlayHintsParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Hints        []InlayHint            `json:"hints"`
+}
+
+// CodeLensNotification is sent by Crush to publish AI actions for ranges of
+// a document, replacing any it published before.
+// Method: crush/codeLens
+// The daemon serves them to Neovim's textDocument/codeLens requests.
+type CodeLensNotification struct {
+	Notification
+	Params CodeLensPublishParams `json:"params"`
+}
+
+// CodeLensPublishParams contains the lenses for one document. An empty list clears them.
+type CodeLensPublishParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Lenses       []AICodeLens           `json:"lenses"`
+}
+
+// AICodeLens is an agent action offered above a range, e.g. "Explain".
+type AICodeLens struct {
+	ID     string `json:"id"`               // Agent-chosen identifier, echoed on execution
+	Range  Range  `json:"range"`            // Range the action applies to
+	Title  string `json:"title"`            // Label shown in the editor
+	Action string `json:"action,omitempty"` // e.g. "explain", "generate_test", "refactor"
+}
+
+// ExecuteLensCommand is the workspace/executeCommand command Neovim runs
+// for an AI code lens. Its single argument is an ExecuteLensParams.
+const ExecuteLensCommand = "crush.executeLens"
+
+// ExecuteLensRequest asks Crush to perform a code lens action.
+// Method: crush/executeLens
+// The daemon sends this when Neovim executes an AI lens; Crush's response
+// becomes the result of Neovim's workspace/executeCommand.
+type ExecuteLensRequest struct {
+	Request
+	Params ExecuteLensParams `json:"params"`
+}
+
+// ExecuteLensParams identifies the lens being executed.
+type ExecuteLensParams struct {
+	ID           string                 `json:"id"`
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Action       string                 `json:"action,omitempty"`
+}
+
+// InvokeActionCommand is the workspace/executeCommand command Neovim runs
+// for an AI code action. Its single argument is an InvokeActionParams.
+const InvokeActionCommand = "crush.invokeAction"
+
+// AI code actions the daemon offers Neovim.
+const (
+	ActionExplain       = "explain"        // Explain the selected code
+	ActionGenerateTest  = "generate_test"  // Write a test for the code at the range
+	ActionFixDiagnostic = "fix_diagnostic" // Fix the problem a diagnostic reports; sent as crush/fixDiagnostic
+)
+
+// Code action kinds of the AI actions, for textDocument/codeAction's
+// context.only.
+const (
+	CodeActionKindExplain      = "ai.explain"
+	CodeActionKindGenerateTest = "ai.generateTest"
+	CodeActionKindFix          = "quickfix.ai"
+)
+
+// InvokeActionRequest asks the agent to perform an AI code action.
+// Method: crush/invokeAction
+// The daemon sends this when Neovim executes one of the code actions it
+// offered; the agent's response becomes the result of Neovim's
+// workspace/executeCommand.
+type InvokeActionRequest struct {
+	Request
+	Params InvokeActionParams `json:"params"`
+}
+
+// InvokeActionParams describes the action and where it applies.
+type InvokeActionParams struct {
+	Action       string                 `json:"action"` // One of the Action* constants
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`                // Neovim's selection, or the cursor
+	LanguageID   string                 `json:"languageId,omitempty"` // LSP language ID, if known
+	Diagnostic   *Diagnostic            `json:"diagnostic,omitempty"` // For fix_diagnostic
+}
+
+// FixDiagnosticRequest asks the agent to fix a diagnostic.
+// Method: crush/fixDiagnostic
+// Neovim sends it with the document and diagnostic, directly or by running
+// an AI fix code action. The daemon fills in the rest and sends it to Crush,
+// whose response is relayed back, and as a notification to MCP clients.
+// The agent's next edit of the document is checked for the diagnostic and
+// reported with crush/fixVerified.
+type FixDiagnosticRequest struct {
+	Request
+	Params FixDiagnosticParams `json:"params"`
+}
+
+// FixDiagnosticParams packages a diagnostic for the agent.
+type FixDiagnosticParams struct {
+	ID           int                    `json:"id,omitempty"` // Set by the daemon; matches crush/fixVerified
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Diagnostic   Diagnostic             `json:"diagnostic"`
+	Path         string                 `json:"path,omitempty"`       // Relative to the workspace root when inside it
+	LanguageID   string                 `json:"languageId,omitempty"` // LSP language ID, if known
+	LineCount    int                    `json:"lineCount,omitempty"`  // Lines in the document
+	Context      *FixContext            `json:"context,omitempty"`    // Code around the diagnostic
+}
+
+// FixContext is the code around a diagnostic.
+type FixContext struct {
+	StartLine int    `json:"startLine"` // 0-indexed line of Text's first line
+	Text      string `json:"text"`
+}
+
+// FixDiagnosticResult answers a crush/fixDiagnostic that only reached MCP
+// clients, because Crush is not connected.
+type FixDiagnosticResult struct {
+	ID int `json:"id"`
+}
+
+// Outcomes of a crush/fixDiagnostic reported by crush/fixVerified.
+const (
+	FixCleared    = "cleared"    // The diagnostic is gone after the agent's edit
+	FixRemaining  = "remaining"  // The diagnostic is still reported
+	FixUnverified = "unverified" // The daemon cannot recheck the diagnostic
+)
+
+// FixVerifiedNotification reports whether the agent's edit after a
+// crush/fixDiagnostic cleared the diagnostic.
+// Method: crush/fixVerified
+// Sent to Neovim, Crush and MCP clients after each edit of the document
+// until the diagnostic clears, the document closes, or it cannot be checked.
+type FixVerifiedNotification struct {
+	Notification
+	Params FixVerifiedParams `json:"params"`
+}
+
+// FixVerifiedParams identifies the fix and its outcome.
+type FixVerifiedParams struct {
+	ID           int                    `json:"id"`
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Diagnostic   Diagnostic             `json:"diagnostic"`
+	Status       string                 `json:"status"` // One of the Fix* outcomes
+}
+
+// ReviewHunk is a change by Crush staged for review in Neovim.
+type ReviewHunk struct {
+	ID      int    `json:"id"`
+	Range   Range  `json:"range"`   // Whole lines of Neovim's buffer it replaces; empty for an insertion
+	OldText string `json:"oldText"` // The lines it replaces
+	NewText string `json:"newText"` // The lines it proposes
+}
+
+// ReviewHunksNotification tells Neovim which hunks of a document await
+// review, so it can show accept and reject controls for them.
+// Method: crush/reviewHunks
+// Sent whenever the set changes; an empty list clears the document.
+type ReviewHunksNotification struct {
+	Notification
+	Params ReviewHunksParams `json:"params"`
+}
+
+// ReviewHunksParams lists the pending hunks of a document, in order.
+type ReviewHunksParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Hunks        []ReviewHunk           `json:"hunks"`
+}
+
+// ResolveHunkRequest accepts or rejects a staged hunk.
+// Method: crush/resolveHunk
+// An accepted hunk is applied to Neovim's buffer; a rejected one is
+// discarded. Once a document has no pending hunks, Crush is sent its text
+// if it differs from Crush's edit.
+type ResolveHunkRequest struct {
+	Request
+	Params ResolveHunkParams `json:"params"`
+}
+
+// ResolveHunkParams identifies the hunk and the decision.
+type ResolveHunkParams struct {
+	ID     int  `json:"id"`
+	Accept bool `json:"accept"`
+}
+
+// ResolveHunkResult reports the outcome.
+type ResolveHunkResult struct {
+	Applied   bool `json:"applied"`   // The hunk was applied to the buffer
+	Remaining int  `json:"remaining"` // Hunks of the document still pending
+}
+
+// PreviewFileNotification shows Neovim a file an agent created, with
+// review.preview_created, in a read-only preview buffer.
+// Method: crush/previewFile
+// The file is not on disk until the user accepts it with
+// crush/resolvePreview. Sent again with the new text when the agent
+// rewrites the file first.
+type PreviewFileNotification struct {
+	Notification
+	Params PreviewFileParams `json:"params"`
+}
+
+// PreviewFileParams holds the content the agent proposes for the file.
+type PreviewFileParams struct {
+	URI        string `json:"uri"`
+	Text       string `json:"text"`
+	LanguageID string `json:"languageId,omitempty"`
+	Client     string `json:"client"` // The agent that created it
+}
+
+// ResolvePreviewRequest accepts a previewed file into the workspace, or
+// discards it.
+// Method: crush/resolvePreview
+// An accepted file is written to disk like any other agent write and
+// opened in Neovim with its content highlighted.
+type ResolvePreviewRequest struct {
+	Request
+	Params ResolvePreviewParams `json:"params"`
+}
+
+// ResolvePreviewParams identifies the preview and the decision.
+type ResolvePreviewParams struct {
+	URI    string `json:"uri"`
+	Accept bool   `json:"accept"`
+}
+
+// ResolvePreviewResult reports the outcome.
+type ResolvePreviewResult struct {
+	Written bool `json:"written"` // The file was written to disk
+}
+
+// ListHunksRequest returns every hunk awaiting review.
+// Method: crush/listHunks
+type ListHunksRequest struct {
+	Request
+}
+
+// ListHunksResult holds the pending hunks by document, ordered by URI.
+type ListHunksResult struct {
+	Documents []ReviewHunksParams `json:"documents"`
+}
+
+// ExportPatchRequest returns the agents' edits as a unified diff.
+// Method: crush/exportPatch
+// Applied edits run from a document's text before the first agent edit to
+// Neovim's buffer; pending ones are the hunks staged for review.
+type ExportPatchRequest struct {
+	Request
+	Params ExportPatchParams `json:"params"`
+}
+
+// Scopes of crush/exportPatch.
+const (
+	PatchApplied = "applied" // Edits applied since the first agent edit
+	PatchPending = "pending" // Hunks awaiting review
+)
+
+// ExportPatchParams selects the edits to export.
+type ExportPatchParams struct {
+	Scope string `json:"scope,omitempty"` // PatchApplied or PatchPending (default both, in one diff per file)
+}
+
+// ExportPatchResult holds the diff, with paths relative to the workspace.
+type ExportPatchResult struct {
+	Patch string   `json:"patch"` // Empty if there are no edits
+	Files []string `json:"files"` // Paths changed by the patch, sorted
+}
+
+// ImportPatchRequest applies a unified diff as an agent edit would be.
+// Method: crush/importPatch
+// Every file is checked before any is written: the hunks must apply
+// exactly to the buffer, if Neovim has the file open, or else the file.
+// Files are then written as by crush/writeFile. Deleting files is not
+// supported.
+type ImportPatchRequest struct {
+	Request
+	Params ImportPatchParams `json:"params"`
+}
+
+// ImportPatchParams holds the diff, with paths relative to the workspace.
+type ImportPatchParams struct {
+	Patch string `json:"patch"`
+}
+
+// ImportPatchResult reports the write of each file, in patch order.
+type ImportPatchResult struct {
+	Files []WriteFileResult `json:"files"`
+}
+
+// ScratchScheme prefixes the URIs of scratch documents.
+const ScratchScheme = "untitled://"
+
+// OpenScratchRequest shows text in an unsaved Neovim scratch buffer.
+// Method: crush/openScratch
+// Without uri a new scratch buffer is opened; with it, the text of that
+// buffer is replaced, or appended to with append, so an agent can stream
+// into it. The daemon forwards the params to Neovim as a crush/openScratch
+// notification with uri set. Scratch documents are tracked like documents
+// open in Neovim but never read from or written to disk.
+type OpenScratchRequest struct {
+	Request
+	Params OpenScratchParams `json:"params"`
+}
+
+// OpenScratchParams holds the scratch buffer's text.
+type OpenScratchParams struct {
+	URI        string `json:"uri,omitempty"`        // untitled:// URI of an open scratch buffer (empty = new)
+	Title      string `json:"title,omitempty"`      // Buffer name, e.g. "plan.md"
+	LanguageID string `json:"languageId,omitempty"` // Filetype (default from the title's extension)
+	Text       string `json:"text"`
+	Append     bool   `json:"append,omitempty"` // Add text at the end instead of replacing
+	Split      bool   `json:"split,omitempty"`  // Show in a split without taking focus
+}
+
+// OpenScratchResult identifies the scratch buffer.
+type OpenScratchResult struct {
+	URI string `json:"uri"`
+}
+
+// AppendTranscriptRequest adds an entry to the session's conversation
+// document.
+// Method: crush/appendTranscript
+// The daemon keeps one markdown conversation per session, an untitled://
+// scratch document that Neovim shows live in a split: each entry is sent as
+// a crush/openScratch notification appending to it, or with the whole
+// document if Neovim has not shown it yet.
+type AppendTranscriptRequest struct {
+	Request
+	Params AppendTranscriptParams `json:"params"`
+}
+
+// AppendTranscriptParams holds an entry.
+type AppendTranscriptParams struct {
+	Role string `json:"role,omitempty"` // Heading of the entry, e.g. "plan" or "reasoning" (default the client type)
+	Text string `json:"text"`           // Markdown
+}
+
+// GetTranscriptRequest returns the session's conversation document.
+// Method: crush/getTranscript
+type GetTranscriptRequest struct {
+	Request
+}
+
+// TranscriptResult identifies the conversation document and holds its
+// text.
+type TranscriptResult struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+// StageFilesRequest stages files the agents edited with git add.
+// Method: crush/stageFiles
+// The user confirms in Neovim first, with window/showMessageRequest. Only
+// files agents edited in the session may be staged, and not while Neovim
+// has unsaved changes to them.
+type StageFilesRequest struct {
+	Request
+	Params StageFilesParams `json:"params"`
+}
+
+// StageFilesParams selects the files to stage.
+type StageFilesParams struct {
+	Paths []string `json:"paths,omitempty"` // Relative to the workspace (default every file agents edited)
+}
+
+// StageFilesResult reports the staged files.
+type StageFilesResult struct {
+	Confirmed bool     `json:"confirmed"` // False if the user declined
+	Staged    []string `json:"staged"`    // Relative to the workspace, sorted
+}
+
+// CommitRequest commits the staged changes with the agent's message.
+// Method: crush/commit
+// The user confirms the message and files in Neovim first.
+type CommitRequest struct {
+	Request
+	Params CommitParams `json:"params"`
+}
+
+// CommitParams holds the proposed commit message.
+type CommitParams struct {
+	Message string `json:"message"`
+}
+
+// CommitResult reports the commit.
+type CommitResult struct {
+	Confirmed bool     `json:"confirmed"`        // False if the user declined
+	Commit    string   `json:"commit,omitempty"` // Hash of the new commit
+	Files     []string `json:"files"`            // Files committed, relative to the repository
+}
+
+// RegisterMethodRequest declares extra methods the sending client handles.
+// Method: crush/registerMethod
+// Messages for a registered method are routed to the registering client
+// instead of the sender's usual peer, so plugins can add crush/* extensions
+// without daemon changes.
+type RegisterMethodRequest struct {
+	Request
+	Params RegisterMethodParams `json:"params"`
+}
+
+// RegisterMethodParams lists the methods to register or unregister.
+// Method: crush/registerMethod, crush/unregisterMethod
+type RegisterMethodParams struct {
+	Methods []string `json:"methods"`
+}
+
+// RegisterMethodResult reports which methods were accepted.
+type RegisterMethodResult struct {
+	Registered []string `json:"registered"`
+	Rejected   []string `json:"rejected,omitempty"` // Built-in or owned by another client
+}
+
+// VerifySyncRequest asks a client for its hash of a document.
+// Method: crush/verifySync
+// The daemon sends this to Neovim and Crush periodically and after every
+// applyEdit, comparing the answers with its own copy to detect desyncs.
+type VerifySyncRequest struct {
+	Request
+	Params VerifySyncParams `json:"params"`
+}
+
+// VerifySyncParams identifies the document to hash.
+type VerifySyncParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Hash         string                 `json:"hash"` // The daemon's hash, for the client's logs
+}
+
+// VerifySyncResult is a client's hash of a document: the hex SHA-256 of
+// its full text. Empty if the client does not have the document.
+type VerifySyncResult struct {
+	Hash string `json:"hash"`
+}
+
+// ResyncDocumentRequest asks the daemon to re-establish a document's
+// baseline after the clients' copies have drifted apart.
+// Method: crush/resyncDocument
+// Either client may send it; the daemon also runs it when crush/verifySync
+// finds a mismatch. The winning copy is pushed to the other client.
+type ResyncDocumentRequest struct {
+	Request
+	Params ResyncDocumentParams `json:"params"`
+}
+
+// ResyncDocumentParams identifies the document to resync.
+type ResyncDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Source       string                 `json:"source,omitempty"` // Copy that wins: "neovim" (default) or "crush"
+}
+
+// ResyncDocumentResult reports the new baseline.
+type ResyncDocumentResult struct {
+	Hash    string   `json:"hash"`              // Hash of the new baseline, as in crush/verifySync
+	Source  string   `json:"source"`            // "neovim", "crush", or "disk"
+	Updated []string `json:"updated,omitempty"` // Clients whose copies were replaced
+}
+
+// DocumentContentRequest asks a client for its current text of a document.
+// Method: crush/documentContent
+// Sent by the daemon during crush/resyncDocument.
+type DocumentContentRequest struct {
+	Request
+	Params DocumentContentParams `json:"params"`
+}
+
+// DocumentContentParams identifies the document to read.
+type DocumentContentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// DocumentContentResult is the client's text, or nil if it does not have
+// the document.
+type DocumentContentResult struct {
+	Text *string `json:"text"`
+}
+
+// ListFilesRequest asks the daemon for the workspace's files.
+// Method: crush/listFiles
+// Paths ignored by .gitignore or the files.exclude config are left out.
+// The listing is cached by the daemon. In multi-root workspaces, files of
+// the other workspace folders are listed too, each naming its folder.
+type ListFilesRequest struct {
+	Request
+	Params ListFilesParams `json:"params"`
+}
+
+// ListFilesParams narrows the listing.
+type ListFilesParams struct {
+	PageParams
+	Path    string `json:"path,omitempty"`    // Only files under this directory (relative to each root)
+	Refresh bool   `json:"refresh,omitempty"` // Bypass the cache
+}
+
+// ListFilesResult contains the workspace files.
+type ListFilesResult struct {
+	Root       string     `json:"root"`
+	Files      []FileInfo `json:"files"`
+	Truncated  bool       `json:"truncated,omitempty"`  // The workspace has more files than the daemon lists
+	Folders    []string   `json:"folders,omitempty"`    // Other workspace folders, in multi-root workspaces
+	NextCursor string     `json:"nextCursor,omitempty"` // Cursor of the next page, if there is one
+}
+
+// PageParams pages a list-style result. The first page snapshots the full
+// result in the daemon; passing back the nextCursor of a page returns the
+// next page of that snapshot, so pages stay consistent while the workspace
+// changes. Other params are ignored when a cursor is given. Cursors expire
+// five minutes after their snapshot was last read.
+type PageParams struct {
+	Cursor   string `json:"cursor,omitempty"`   // nextCursor of the previous page
+	PageSize int    `json:"pageSize,omitempty"` // Items per page; 0 = all
+}
+
+// FileInfo describes one workspace file.
+type FileInfo struct {
+	Path     string `json:"path"`               // Relative to the root, slash-separated
+	Size     int64  `json:"size"`               // Bytes
+	Language string `json:"language,omitempty"` // LSP language ID, if known
+	Root     string `json:"root,omitempty"`     // Workspace folder the path is in, if not the root
+}
+
+// WorkspaceStatsRequest sums up the workspace files.
+// Method: crush/workspaceStats
+// Covers the files crush/listFiles lists, with lines counted on disk.
+// Binary files count as files but not lines. Tests are recognized by the
+// naming conventions of common languages (_test.go, test_*.py, *.spec.ts,
+// files under test directories, ...).
+type WorkspaceStatsRequest struct {
+	Request
+	Params WorkspaceStatsParams `json:"params"`
+}
+
+// WorkspaceStatsParams narrows the statistics.
+type WorkspaceStatsParams struct {
+	Path    string `json:"path,omitempty"`    // Only files under this directory (relative to each root)
+	Largest int    `json:"largest,omitempty"` // How many of the largest files to list (default 10)
+	Refresh bool   `json:"refresh,omitempty"` // Bypass the cache
+}
+
+// WorkspaceStatsResult holds the statistics.
+type WorkspaceStatsResult struct {
+	Files     int             `json:"files"`
+	Lines     int             `json:"lines"`
+	Bytes     int64           `json:"bytes"`
+	Languages []LanguageStats `json:"languages"` // By lines, most first; "" is files of no known language
+	Largest   []FileStats     `json:"largest"`   // By lines, most first
+	TestFiles int             `json:"testFiles"`
+	TestLines int             `json:"testLines"`
+	// TestRatio is test lines per line of source (non-test files of a
+	// known language), 0 without source
+	TestRatio float64 `json:"testRatio"`
+	Truncated bool    `json:"truncated,omitempty"` // The workspace has more files than the daemon lists
+}
+
+// LanguageStats sums up the files of one language.
+type LanguageStats struct {
+	Language  string `json:"language"` // LSP language ID
+	Files     int    `json:"files"`
+	Lines     int    `json:"lines"`
+	Bytes     int64  `json:"bytes"`
+	TestFiles int    `json:"testFiles"`
+	TestLines int    `json:"testLines"`
+}
+
+// FileStats is one file in WorkspaceStatsResult.
+type FileStats struct {
+	FileInfo
+	Lines int  `json:"lines"`
+	Test  bool `json:"test,omitempty"`
+}
+
+// ReadFileRequest asks the daemon for a workspace file's content.
+// Method: crush/readFile
+// Neovim's buffer is preferred over the file on disk, so unsaved edits are
+// visible. Paths outside the workspace are rejected.
+type ReadFileRequest struct {
+	Request
+	Params ReadFileParams `json:"params"`
+}
+
+// ReadFileParams names the file and an optional line range.
+type ReadFileParams struct {
+	Path      string `json:"path"`                // Relative to the workspace root, or absolute inside it
+	StartLine int    `json:"startLine,omitempty"` // 1-indexed, inclusive (default: first line)
+	EndLine   int    `json:"endLine,omitempty"`   // 1-indexed, inclusive (default: last line)
+}
+
+// ReadFileResult contains the requested lines.
+type ReadFileResult struct {
+	Path       string `json:"path"`
+	Content    string `json:"content"` // Empty for binary files
+	StartLine  int    `json:"startLine"`
+	EndLine    int    `json:"endLine"`
+	TotalLines int    `json:"totalLines"`
+	Size       int64  `json:"size"`               // Bytes
+	Source     string `json:"source"`             // "buffer", "disk", or "preview" (a created file not yet accepted)
+	Encoding   string `json:"encoding,omitempty"` // "utf-8", "utf-8-bom", "utf-16le", "utf-16be" or "latin-1"
+	Binary     bool   `json:"binary,omitempty"`
+	TooLarge   bool   `json:"tooLarge,omitempty"` // Over files.max_document_bytes and no lines asked for; no content
+}
+
+// Why a document's content was left out of a result, leaving only its
+// metadata.
+const (
+	ContentBinary   = "binary"    // The document has NUL bytes
+	ContentTooLarge = "too_large" // The document is over files.max_document_bytes
+)
+
+// RenderSnippetRequest draws lines of a workspace file as an image.
+// Method: crush/renderSnippet
+// The lines are read as crush/readFile reads them and drawn with syntax
+// highlighting, for MCP hosts that display image content. At most
+// MaxRenderLines are drawn.
+type RenderSnippetRequest struct {
+	Request
+	Params RenderSnippetParams `json:"params"`
+}
+
+// MaxRenderLines is the most lines crush/renderSnippet draws.
+const MaxRenderLines = 200
+
+// Image formats of crush/renderSnippet.
+const (
+	RenderPNG = "png" // Accepted by every host that shows images
+	RenderSVG = "svg"
+)
+
+// RenderSnippetParams names the file and the lines to draw.
+type RenderSnippetParams struct {
+	Path      string `json:"path"`                // Relative to the workspace root, or absolute inside it
+	StartLine int    `json:"startLine,omitempty"` // 1-indexed, inclusive (default: first line)
+	EndLine   int    `json:"endLine,omitempty"`   // 1-indexed, inclusive (default: last line)
+	Highlight int    `json:"highlight,omitempty"` // 1-indexed line to mark, e.g. the cursor's
+	Format    string `json:"format,omitempty"`    // "png" (default) or "svg"
+}
+
+// RenderSnippetResult holds the image.
+type RenderSnippetResult struct {
+	MIMEType  string `json:"mimeType"`
+	Data      []byte `json:"data"` // Base64 in JSON
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+}
+
+// WriteFileRequest asks the daemon to replace or create a workspace file.
+// Method: crush/writeFile
+// A file open in Neovim is changed through workspace/applyEdit, keeping its
+// undo history; otherwise it is written to disk and Neovim is shown the
+// change.
+type WriteFileRequest struct {
+	Request
+	Params WriteFileParams `json:"params"`
+}
+
+// WriteFileParams names the file and its new content.
+type WriteFileParams struct {
+	Path    string `json:"path"`             // Relative to the workspace root, or absolute inside it
+	Content string `json:"content"`          // Full new content
+	Create  bool   `json:"create,omitempty"` // Fail if the file already exists
+}
+
+// WriteFileResult reports how the file was changed.
+type WriteFileResult struct {
+	Path      string `json:"path"`
+	Via       string `json:"via"`                 // "buffer" (unsaved in Neovim), "disk", or "preview" (not on disk until accepted in Neovim)
+	Created   bool   `json:"created,omitempty"`   // The file did not exist before
+	Applied   bool   `json:"applied"`             // False if Neovim rejected the edit
+	Formatted bool   `json:"formatted,omitempty"` // The configured formatter changed the content
+}
+
+// GenerateFileRequest creates a workspace file from a template.
+// Method: crush/generateFile
+// Templates are "license" (params path and body: body under the project's
+// license header, detected from its LICENSE file unless license and holder
+// are given), "test" (params source: a test scaffold for the source file,
+// named and laid out like the project's existing tests) and the
+// workspace's own, .crush/templates/<name>.tmpl (Go text/template). The
+// file is then created as by crush/writeFile with create set.
+type GenerateFileRequest struct {
+	Request
+	Params GenerateFileParams `json:"params"`
+}
+
+// GenerateFileParams names the template and its parameters.
+type GenerateFileParams struct {
+	Template string            `json:"template"`
+	Params   map[string]string `json:"params,omitempty"` // Paths in them are relative to the workspace root, or absolute inside it
+}
+
+// GenerateFileResult reports the generated file and how it was created.
+type GenerateFileResult struct {
+	WriteFileResult
+	Content string `json:"content"` // As generated, before formatting
+}
+
+// EditSelectionsRequest replaces the text of the ranges Neovim last
+// reported: its selections, or its cursors (as empty ranges) when nothing
+// is selected.
+// Method: crush/editSelections
+// The edits are applied to the buffer as one workspace/applyEdit, so they
+// are undone together.
+type EditSelectionsRequest struct {
+	Request
+	Params EditSelectionsParams `json:"params"`
+}
+
+// EditSelectionsParams lists the replacements, one per targeted range.
+type EditSelectionsParams struct {
+	Edits []SelectionEdit `json:"edits"`
+}
+
+// SelectionEdit replaces one selection. Selections not listed are left
+// as they are.
+type SelectionEdit struct {
+	Index   int     `json:"index"`             // Into the selections (or cursors), as reported in editor_context
+	NewText string  `json:"newText"`           // Replacement text
+	OldText *string `json:"oldText,omitempty"` // Expected current text; the request fails if it differs
+}
+
+// EditSelectionsResult reports the edit.
+type EditSelectionsResult struct {
+	URI     string `json:"uri"`
+	Edits   int    `json:"edits"`   // Ranges replaced
+	Applied bool   `json:"applied"` // False if Neovim rejected the edit
+}
+
+// RecentChangesRequest asks the daemon what changed recently.
+// Method: crush/recentChanges
+// The daemon keeps a bounded journal of the changes it routes (Crush edits,
+// file writes, resyncs).
+type RecentChangesRequest struct {
+	Request
+	Params RecentChangesParams `json:"params"`
+}
+
+// RecentChangesParams sets the window to summarize.
+type RecentChangesParams struct {
+	Minutes int `json:"minutes,omitempty"` // Default 10
+}
+
+// RecentChangesResult summarizes the changes per document, most recent first.
+type RecentChangesResult struct {
+	Since string        `json:"since"` // RFC 3339
+	Files []ChangedFile `json:"files"`
+}
+
+// ChangedFile aggregates the changes to one document.
+type ChangedFile struct {
+	URI         string   `json:"uri"`
+	Changes     int      `json:"changes"`
+	Added       int      `json:"added"`   // Lines added
+	Removed     int      `json:"removed"` // Lines removed
+	Sources     []string `json:"sources"` // e.g. "crush", "mcp", "resync"
+	LastChanged string   `json:"lastChanged"`
+}
+
+// DefinitionContext is the source of a symbol referenced at the cursor,
+// included in crush/getEditorContext when includeDefinitions is set.
+// The daemon finds it by sending crush/definition (DefinitionParams) to
+// Neovim, which answers like textDocument/definition using its own
+// language servers.
+type DefinitionContext struct {
+	Symbol    string `json:"symbol"`
+	URI       string `json:"uri"`
+	StartLine int    `json:"start_line"` // 0-indexed
+	Text      string `json:"text"`
+	Truncated bool   `json:"truncated,omitempty"` // Cut at the size limit
+}
+
+// RelatedFilesRequest asks the daemon for workspace files similar to a
+// document or the current selection.
+// Method: crush/relatedFiles
+// Requires related.enabled in the neocrush config.
+type RelatedFilesRequest struct {
+	Request
+	Params RelatedFilesParams `json:"params"`
+}
+
+// RelatedFilesParams picks the text to compare against.
+type RelatedFilesParams struct {
+	TextDocument *TextDocumentIdentifier `json:"textDocument,omitempty"` // Default: the focused document
+	UseSelection bool                    `json:"useSelection,omitempty"` // Compare the selection instead
+	Limit        int                     `json:"limit,omitempty"`        // Default 10
+}
+
+// RelatedFilesResult lists similar files, best first.
+type RelatedFilesResult struct {
+	Files []RelatedFile `json:"files"`
+}
+
+// RelatedFile is a file similar to the query.
+type RelatedFile struct {
+	Path  string  `json:"path"`  // Relative to the workspace root
+	Score float64 `json:"score"` // Cosine similarity, 0..1
+}
+
+// HistoryRequest queries the session transcript.
+// Method: crush/history
+// The daemon records crush/* requests (including MCP tool calls) and applied
+// edits under .crush/history, with secrets and file contents redacted.
+type HistoryRequest struct {
+	Request
+	Params HistoryParams `json:"params"`
+}
+
+// HistoryParams filters the transcript. Zero fields match everything.
+type HistoryParams struct {
+	PageParams
+	Minutes     int    `json:"minutes,omitempty"`     // Only the last N minutes
+	Method      string `json:"method,omitempty"`      // e.g. "crush/writeFile", or "crush/*"
+	Kind        string `json:"kind,omitempty"`        // "request", "edit", or "summary"
+	Limit       int    `json:"limit,omitempty"`       // Newest N records; default 100
+	AllSessions bool   `json:"allSessions,omitempty"` // Include earlier sessions
+}
+
+// HistoryResult lists matching records, oldest first.
+type HistoryResult struct {
+	Session    string          `json:"session"` // The daemon's session ID
+	Records    []HistoryRecord `json:"records"`
+	NextCursor string          `json:"nextCursor,omitempty"` // Cursor of the next page, if there is one
+}
+
+// HistoryRecord is one transcript entry.
+type HistoryRecord struct {
+	Time    string          `json:"time"` // RFC 3339
+	Session string          `json:"session"`
+	Kind    string          `json:"kind"`
+	Client  string          `json:"client"`
+	Method  string          `json:"method,omitempty"`
+	URI     string          `json:"uri,omitempty"`
+	Summary string          `json:"summary,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// EditGroup identifies one agent operation. The daemon adds it to every
+// workspace/applyEdit carrying an agent edit (as params.editGroup); the
+// plugin applies all edits of a group as a single undo block, labelled with
+// the description, so the operation can be undone in one step.
+type EditGroup struct {
+	ID    string `json:"id"`
+	Label string `json:"label"` // Description of the operation
+}
+
+// FoldHint describes a large agent change to one document. The daemon adds
+// it to a workspace/applyEdit (as params.fold) whose change spans more than
+// review.fold_lines lines, so the plugin can fold the lines it left as they
+// were, or show the summary, instead of a wall of changed lines. Ranges are
+// whole lines of the document once the edit is applied.
+type FoldHint struct {
+	URI       string  `json:"uri"`
+	Range     Range   `json:"range"`               // From the first changed line to the last
+	Unchanged []Range `json:"unchanged,omitempty"` // Runs of unchanged lines within Range
+	Added     int     `json:"added"`               // Lines inserted
+	Removed   int     `json:"removed"`             // Lines deleted
+	Summary   string  `json:"summary"`             // e.g. "+120 -80 lines in 3 hunks"
+}
+
+// UndoLastAgentEditRequest undoes the most recent agent operation.
+// Method: crush/undoLastAgentEdit
+// The daemon forwards it to Neovim as crush/undoEditGroup and then syncs
+// Crush with the restored buffers.
+type UndoLastAgentEditRequest struct {
+	Request
+}
+
+// UndoLastAgentEditResult describes what was undone.
+type UndoLastAgentEditResult struct {
+	Undone bool      `json:"undone"`
+	Group  EditGroup `json:"group"`
+	URIs   []string  `json:"uris"`             // Documents the operation touched
+	Reason string    `json:"reason,omitempty"` // Why nothing was undone
+}
+
+// UndoEditGroupRequest asks Neovim to undo an edit group.
+// Method: crush/undoEditGroup
+// The plugin undoes the group's undo block in each buffer where it is the
+// most recent change, and answers with UndoEditGroupResult.
+type UndoEditGroupRequest struct {
+	Request
+	Params UndoEditGroupParams `json:"params"`
+}
+
+// UndoEditGroupParams names the group and the documents it touched.
+type UndoEditGroupParams struct {
+	Group EditGroup `json:"group"`
+	URIs  []string  `json:"uris"`
+}
+
+// UndoEditGroupResult reports whether the group was undone.
+type UndoEditGroupResult struct {
+	Undone bool   `json:"undone"`
+	Reason string `json:"reason,omitempty"` // e.g. the user edited since
+}
+
+// CreateCheckpointRequest snapshots files before an agent changes them.
+// Method: crush/createCheckpoint
+// Copies are kept under .crush/checkpoints; buffers open in Neovim are
+// snapshotted with their unsaved changes.
+type CreateCheckpointRequest struct {
+	Request
+	Params CreateCheckpointParams `json:"params"`
+}
+
+// CreateCheckpointParams names the checkpoint and the files to include.
+type CreateCheckpointParams struct {
+	Name  string   `json:"name,omitempty"`
+	Paths []string `json:"paths"` // Relative to the workspace root, or absolute
+}
+
+// Checkpoint describes a snapshot. It is the result of
+// crush/createCheckpoint.
+type Checkpoint struct {
+	ID      string           `json:"id"`
+	Name    string           `json:"name,omitempty"`
+	Created string           `json:"created"` // RFC 3339
+	Files   []CheckpointFile `json:"files"`
+}
+
+// CheckpointFile is one snapshotted file.
+type CheckpointFile struct {
+	Path   string `json:"path"`   // Relative to the workspace root
+	Exists bool   `json:"exists"` // False if it did not exist; restoring deletes it
+	Size   int    `json:"size"`
+}
+
+// ListCheckpointsRequest lists the workspace's checkpoints.
+// Method: crush/listCheckpoints
+type ListCheckpointsRequest struct {
+	Request
+}
+
+// ListCheckpointsResult lists checkpoints, oldest first.
+type ListCheckpointsResult struct {
+	Checkpoints []Checkpoint `json:"checkpoints"`
+}
+
+// RestoreCheckpointRequest puts files back as they were in a checkpoint.
+// Method: crush/restoreCheckpoint
+// Files open in Neovim are restored in the buffer (undoable, unsaved);
+// others on disk.
+type RestoreCheckpointRequest struct {
+	Request
+	Params RestoreCheckpointParams `json:"params"`
+}
+
+// RestoreCheckpointParams names the checkpoint.
+type RestoreCheckpointParams struct {
+	Checkpoint string `json:"checkpoint"` // ID, or name (newest match)
+}
+
+// RestoreCheckpointResult lists what was restored.
+type RestoreCheckpointResult struct {
+	ID       string   `json:"id"`
+	Restored []string `json:"restored"`
+	Failed   []string `json:"failed,omitempty"` // "path: reason"
+}
+
+// EditSummaryNotification sums up an agent's edit transaction for display.
+// Method: crush/editSummary
+// Sent to Neovim once an agent has made no edits for a short while; the
+// same summary is recorded in the session history.
+type EditSummaryNotification struct {
+	Notification
+	Params EditSummaryParams `json:"params"`
+}
+
+// EditSummaryParams is a diff-stat of one transaction.
+type EditSummaryParams struct {
+	Client     string         `json:"client"` // "crush" or "mcp"
+	Files      []FileEditStat `json:"files"`
+	Added      int            `json:"added"`   // Lines added across files
+	Removed    int            `json:"removed"` // Lines removed across files
+	Started    string         `json:"started"` // RFC 3339
+	DurationMS int64          `json:"durationMs"`
+	Summary    string         `json:"summary"` // e.g. "2 files changed, 10 insertions(+), 3 deletions(-)"
+}
+
+// FileEditStat is the diff-stat of one file in a transaction.
+type FileEditStat struct {
+	URI     string `json:"uri"`
+	Edits   int    `json:"edits"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+// GetMetricsRequest asks for the daemon's traffic statistics.
+// Method: crush/getMetrics
+// Shows which traffic (e.g. full-document didChange) costs the most, and
+// how long peers take to answer relayed requests. Takes no params.
+type GetMetricsRequest struct {
+	Request
+}
+
+// GetMetricsResult is the daemon's traffic since it started.
+type GetMetricsResult struct {
+	UptimeMS int64           `json:"uptimeMs"`
+	Clients  []string        `json:"clients"`         // Connected clients, sorted
+	Desyncs  int             `json:"desyncs"`         // Documents found out of sync
+	Methods  []MethodMetrics `json:"methods"`         // Heaviest traffic first
+	Leaks    []LeakWarning   `json:"leaks,omitempty"` // Soak mode's growing gauges, by name
+}
+
+// LeakWarning is a gauge that soak mode (debug.soak) saw grow without
+// falling over a whole window of samples.
+type LeakWarning struct {
+	Gauge   string `json:"gauge"`   // e.g. "goroutines", "heap_bytes", "documents"
+	From    int64  `json:"from"`    // Value at the start of the window
+	To      int64  `json:"to"`      // Latest value
+	SinceMS int64  `json:"sinceMs"` // Unix time the window started, in milliseconds
+}
+
+// MethodMetrics is the traffic seen for one method. Latencies are measured
+// from forwarding a request to receiving its response, over recent requests.
+type MethodMetrics struct {
+	Method        string  `json:"method"`
+	Count         int     `json:"count"`    // Messages received
+	Bytes         int64   `json:"bytes"`    // Total size received
+	AvgBytes      int     `json:"avgBytes"` // Mean message size
+	MaxBytes      int     `json:"maxBytes"` // Largest message
+	Responses     int     `json:"responses,omitempty"`
+	ResponseBytes int64   `json:"responseBytes,omitempty"`
+	Unanswered    int     `json:"unanswered,omitempty"` // Requests not answered in time
+	Invalid       int     `json:"invalid,omitempty"`    // Messages rejected by --strict
+	P50MS         float64 `json:"p50Ms,omitempty"`
+	P90MS         float64 `json:"p90Ms,omitempty"`
+	P99MS         float64 `json:"p99Ms,omitempty"`
+}
+
+// SetFaultsRequest injects faults into the messages the daemon sends, to
+// check that clients recover from flaky connections.
+// Method: crush/setFaults
+// Only answered when "debug": {"faults": true} is set in the config. Each
+// call replaces the faults; empty params turn injection off.
+type SetFaultsRequest struct {
+	Request
+	Params SetFaultsParams `json:"params"`
+}
+
+// SetFaultsParams are the faults to inject. Rates are fractions of messages
+// from 0 to 1.
+type SetFaultsParams struct {
+	Clients        []string `json:"clients,omitempty"`        // Client types affected ("neovim", "crush", "mcp"); all if empty
+	DelayMS        int      `json:"delayMs,omitempty"`        // Hold every message this long before sending it
+	JitterMS       int      `json:"jitterMs,omitempty"`       // Plus a random extra delay of up to this long
+	DropRate       float64  `json:"dropRate,omitempty"`       // Notifications silently not sent
+	TruncateRate   float64  `json:"truncateRate,omitempty"`   // Messages cut short, after which the connection is closed
+	BytesPerSecond int      `json:"bytesPerSecond,omitempty"` // Throttle sends to this rate, as a slow peer would read
+	Seed           int64    `json:"seed,omitempty"`           // Seeds the random choices, for reproducible runs
+}
+
+// SetFaultsResult reports the faults injected under the replaced settings.
+type SetFaultsResult struct {
+	Active    bool `json:"active"` // Whether the new faults inject anything
+	Delayed   int  `json:"delayed"`
+	Dropped   int  `json:"dropped"`
+	Truncated int  `json:"truncated"`
+	Throttled int  `json:"throttled"`
+}
+
+// SessionEndingNotification tells a client its peer is going away.
+// Method: crush/sessionEnding
+// Sent to the peer of a client that requests shutdown, and to every client
+// when the daemon is stopped by a signal or crush/stopDaemon, so they can
+// stop sending work before the connection closes.
+type SessionEndingNotification struct {
+	Notification
+	Params SessionEndingParams `json:"params"`
+}
+
+// SessionEndingParams names who is leaving and why.
+type SessionEndingParams struct {
+	Client string `json:"client"` // Client shutting down, or "daemon"
+	Reason string `json:"reason"` // One of the SessionEnding* constants
+}
+
+// Reasons carried in crush/sessionEnding notifications.
+const (
+	SessionEndingShutdown = "shutdown" // The client sent an LSP shutdown request
+	SessionEndingSignal   = "signal"   // The daemon received SIGINT or SIGTERM
+	SessionEndingStopped  = "stopped"  // A client asked the daemon to stop with crush/stopDaemon
+)
+
+// StopDaemonRequest asks the daemon to shut down as on SIGTERM: it answers
+// with a null result, tells every client the session is ending, and
+// removes its socket and session file.
+// Method: crush/stopDaemon
+type StopDaemonRequest struct {
+	Request
+	Params StopDaemonParams `json:"params"`
+}
+
+// StopDaemonParams says why the daemon is stopped.
+type StopDaemonParams struct {
+	Reason string `json:"reason,omitempty"` // Logged and recorded in the session history, e.g. "neocrush kill"
+}
+
+// InspectRequest attaches the caller to the daemon's message stream.
+// Method: crush/inspect
+// Once answered, every message the daemon routes (other than the
+// inspector's own) is sent to the caller as crush/inspectMessage, with
+// secrets redacted. Takes no params.
+type InspectRequest struct {
+	Request
+}
+
+// InspectMessageNotification carries one routed message to an inspector.
+// Method: crush/inspectMessage
+type InspectMessageNotification struct {
+	Notification
+	Params InspectMessageParams `json:"params"`
+}
+
+// InspectMessageParams is a routed message, as recorded in captures.
+type InspectMessageParams struct {
+	Time    time.Time       `json:"time"`
+	Dir     string          `json:"dir"`              // "recv" (client to daemon) or "send"
+	Client  string          `json:"client,omitempty"` // Empty before the client identified itself
+	Conn    int             `json:"conn,omitempty"`   // Connection, numbered from 1 in the order clients connected
+	Message json.RawMessage `json:"message"`          // JSON-RPC content
+}
+
+// ErrorNotification reports a routing or transformation failure to a client.
+// Method: crush/error
+// Sent to Crush alongside a window/showMessage to Neovim so failures are
+// visible instead of only landing in daemon.log.
+type ErrorNotification struct {
+	Notification
+	Params ErrorParams `json:"params"`
+}
+
+// ErrorParams describes a daemon-side failure.
+type ErrorParams struct {
+	Code    string `json:"code"`             // One of the ErrorCode* constants
+	Message string `json:"message"`          // Human-readable description
+	Method  string `json:"method,omitempty"` // Method being routed when the failure occurred
+	URI     string `json:"uri,omitempty"`    // Affected document, if any
+}
+
+// Error codes carried in crush/error notifications.
+const (
+	// ErrorCodeParse means a message could not be decoded.
+	ErrorCodeParse = "parse_error"
+	// ErrorCodeTransform means a message could not be converted for the peer
+	// (e.g. didChange to workspace/applyEdit).
+	ErrorCodeTransform = "transform_failed"
+	// ErrorCodeForward means writing to the peer connection failed.
+	ErrorCodeForward = "forward_failed"
+	// ErrorCodeDesync means a client's copy of a document no longer matches
+	// the daemon's.
+	ErrorCodeDesync = "desync"
+	// ErrorCodeRateLimited means an agent edit was rejected for exceeding a
+	// configured limit.
+	ErrorCodeRateLimited = "rate_limited"
+	// ErrorCodeProtected means an agent edit was rejected because it touches
+	// a file matched by files.protected.
+	ErrorCodeProtected = "protected_path"
+	// ErrorCodeProtectedBranch means an agent edit was rejected because the
+	// workspace is on a branch matched by git.protected_branches.
+	ErrorCodeProtectedBranch = "protected_branch"
+	// ErrorCodeAgentLaunch means the configured agent command could not be
+	// started.
+	ErrorCodeAgentLaunch = "agent_launch_failed"
+	// ErrorCodeSchema means a daemon running with --strict dropped a message
+	// whose params don't match its method's schema.
+	ErrorCodeSchema = "schema_violation"
+	// ErrorCodeLeak means soak mode saw one of the daemon's gauges
+	// (goroutines, heap, a table) grow steadily, which points to a leak.
+	ErrorCodeLeak = "possible_leak"
+)
+
+// RateLimitedData is the data of a JSON-RPC error answering a request that
+// exceeded an edit limit.
+type RateLimitedData struct {
+	Code         string `json:"code"`         // ErrorCodeRateLimited
+	Limit        string `json:"limit"`        // "edits_per_minute", "bytes_per_minute", or "files_per_operation"
+	Max          int    `json:"max"`          // Configured ceiling
+	Value        int    `json:"value"`        // Count the request would have reached
+	RetryAfterMS int64  `json:"retryAfterMs"` // Zero if the request can never fit
+}
+
+// ProtectedPathData is the data of a JSON-RPC error answering a request
+// that would edit a protected file.
+type ProtectedPathData struct {
+	Code string `json:"code"` // ErrorCodeProtected
+	Path string `json:"path"` // Relative to the workspace root
+}
+
+// ProtectedBranchData is the data of a JSON-RPC error answering a request
+// that would edit files while the workspace is on a protected branch.
+type ProtectedBranchData struct {
+	Code   string `json:"code"`   // ErrorCodeProtectedBranch
+	Branch string `json:"branch"` // The branch checked out
+}
+
+// SchemaViolationData is the data of the InvalidParams error a daemon
+// running with --strict answers a request with when its params don't match
+// the method's schema.
+type SchemaViolationData struct {
+	Code      string `json:"code"`      // ErrorCodeSchema
+	Method    string `json:"method"`    // Method of the rejected request
+	Violation string `json:"violation"` // What the validator found, naming the field
+	Schema    string `json:"schema"`    // File in lsp/schema holding the method's schema
+}
+
+// SessionInfoRequest asks the daemon about the session a client is in.
+// Method: crush/sessionInfo
+// Lets plugins show pairing indicators (e.g. whether Crush is connected)
+// from real data. Takes no params.
+type SessionInfoRequest struct {
+	Request
+}
+
+// SessionInfoResult describes the session and who is connected to it.
+type SessionInfoResult struct {
+	ID           string                 `json:"id"`
+	Root         string                 `json:"root"`
+	Folders      []string               `json:"folders,omitempty"` // Other workspace folders, in multi-root workspaces
+	Version      string                 `json:"version"`           // Daemon version
+	StartedAt    time.Time              `json:"startedAt"`
+	Clients      []SessionClient        `json:"clients"` // Connected clients, sorted by type
+	Capabilities NegotiatedCapabilities `json:"capabilities"`
+}
+
+// SessionClient is one connected client.
+type SessionClient struct {
+	Type          string   `json:"type"`                    // "neovim", "crush", "mcp", or the name of another client
+	Name          string   `json:"name,omitempty"`          // clientInfo.name from initialize
+	Version       string   `json:"version,omitempty"`       // clientInfo.version from initialize
+	PluginVersion string   `json:"pluginVersion,omitempty"` // Version of the editor plugin, if reported
+	ID            string   `json:"id,omitempty"`            // Client ID of an MCP connection ("mcp-1", ...) or an extra Crush agent ("crush-2", ...)
+	Tools         []string `json:"tools,omitempty"`         // Tools an MCP connection offers
+}
+
+// DaemonStatusRequest asks a daemon to describe itself.
+// Method: crush/daemonStatus
+// Lets `neocrush sessions` show which daemon serves which workspace and
+// who is connected to it. Takes no params.
+type DaemonStatusRequest struct {
+	Request
+}
+
+// DaemonStatusResult describes a running daemon.
+type DaemonStatusResult struct {
+	SessionID     string          `json:"sessionId"`
+	Root          string          `json:"root"`
+	Folders       []string        `json:"folders,omitempty"` // Other workspace folders, in multi-root workspaces
+	PID           int             `json:"pid"`
+	Version       string          `json:"version"`
+	StartedAt     time.Time       `json:"startedAt"`
+	UptimeMS      int64           `json:"uptimeMs"`
+	Clients       []SessionClient `json:"clients"`       // Connected clients, sorted by type; neocrush's own commands are left out
+	Documents     int             `json:"documents"`     // Documents the daemon holds the content of
+	OpenDocuments int             `json:"openDocuments"` // Documents open in Neovim
+}
+
+// GetEnvironmentRequest describes the machine the daemon runs on.
+// Method: crush/getEnvironment
+// Tool versions are probed in the workspace root the first time and cached
+// for the session; Refresh probes again. Environment variables are limited
+// to those relevant to building and running code, with secrets redacted.
+type GetEnvironmentRequest struct {
+	Request
+	Params GetEnvironmentParams `json:"params"`
+}
+
+// GetEnvironmentParams holds the options.
+type GetEnvironmentParams struct {
+	Refresh bool `json:"refresh,omitempty"` // Probe tools again instead of using the cache
+}
+
+// EnvironmentResult describes the machine.
+type EnvironmentResult struct {
+	OS          string            `json:"os"`   // GOOS, e.g. "linux" or "darwin"
+	Arch        string            `json:"arch"` // GOARCH, e.g. "amd64" or "arm64"
+	Shell       string            `json:"shell,omitempty"`
+	Tools       []ToolVersion     `json:"tools"` // Installed tools, sorted by name
+	Env         map[string]string `json:"env"`   // Relevant variables, the home directory shown as ~
+	CollectedAt time.Time         `json:"collectedAt"`
+}
+
+// ToolVersion is an installed tool.
+type ToolVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"` // First line of its version output
+	Path    string `json:"path"`    // The home directory shown as ~
+}
+
+// DocumentOpenedNotification tells a client its peer opened a document.
+// Method: crush/documentOpened
+// Sent instead of the peer's didOpen when the documents policy for it is
+// "notify".
+type DocumentOpenedNotification struct {
+	Notification
+	Params DocumentNotifyParams `json:"params"`
+}
+
+// DocumentClosedNotification tells a client its peer closed a document.
+// Method: crush/documentClosed
+// Sent instead of the peer's didClose when the documents policy for it is
+// "notify".
+type DocumentClosedNotification struct {
+	Notification
+	Params DocumentNotifyParams `json:"params"`
+}
+
+// DocumentNotifyParams identifies the document a peer opened or closed.
+type DocumentNotifyParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// ClientConnectedNotification tells clients another client attached.
+// Method: crush/clientConnected
+// Sent by the daemon to every other connected client, so Neovim can show
+// that Crush is back without waiting for traffic.
+type ClientConnectedNotification struct {
+	Notification
+	Params ClientPresenceParams `json:"params"`
+}
+
+// ClientDisconnectedNotification tells clients another client detached.
+// Method: crush/clientDisconnected
+// Lets Crush pause edits while Neovim is gone instead of discovering it
+// through dropped messages.
+type ClientDisconnectedNotification struct {
+	Notification
+	Params ClientPresenceParams `json:"params"`
+}
+
+// ClientPresenceParams identifies the client that came or went.
+type ClientPresenceParams struct {
+	Type string `json:"type"`           // "neovim", "crush", "mcp", or the name of another client
+	Name string `json:"name,omitempty"` // clientInfo.name from initialize
+	ID   string `json:"id,omitempty"`   // Client ID of an MCP connection ("mcp-1", ...) or an extra Crush agent ("crush-2", ...)
+}
+
+// MCPInitializeRequest registers an MCP server with the daemon.
+// Method: crush/mcpInitialize
+// Every MCP connection gets its own client ID, so several MCP servers can
+// share a session and come and go independently. Connections that skip the
+// handshake are registered on their first crush/* request instead.
+type MCPInitializeRequest struct {
+	Request
+	Params MCPInitializeParams `json:"params"`
+}
+
+// MCPInitializeParams introduces the MCP server.
+type MCPInitializeParams struct {
+	ClientInfo ClientInfo `json:"clientInfo"`
+	AuthToken  string     `json:"authToken,omitempty"` // Required when the daemon sets security.auth_token
+	Tools      []string   `json:"tools,omitempty"`     // Names of the tools it offers, after the mcp config
+}
+
+// MCPInitializeResult gives the connection's client ID.
+type MCPInitializeResult struct {
+	ClientID  string `json:"clientId"` // e.g. "mcp-2"
+	SessionID string `json:"sessionId"`
+}
+
+// RunJobRequest runs the workspace's test or build command.
+// Method: crush/runJob
+// The command comes from the jobs config, or is detected from the project
+// type (go.mod, Cargo.toml, package.json, ...). Its output is streamed to
+// Neovim and the caller as crush/jobOutput between crush/jobStarted and
+// crush/jobFinished. One job of each kind runs at a time.
+type RunJobRequest struct {
+	Request
+	Params RunJobParams `json:"params"`
+}
+
+// RunJobParams picks the job.
+type RunJobParams struct {
+	Kind string   `json:"kind,omitempty"` // "test" (default) or "build"
+	Args []string `json:"args,omitempty"` // Appended to the command, e.g. ["-run", "TestFoo"]
+	Wait bool     `json:"wait,omitempty"` // Answer when the job ends; cancelling the request kills it
+	// Language picks the test command of the language's profile, by LSP
+	// language ID (default: the language of the file at Neovim's cursor)
+	Language string `json:"language,omitempty"`
+}
+
+// RunJobResult describes the job. Without wait, it is answered as soon as
+// the job starts, with status "running" and no output.
+type RunJobResult struct {
+	JobID      int          `json:"jobId"`
+	Kind       string       `json:"kind"`
+	Command    []string     `json:"command"`
+	Status     string       `json:"status"`              // One of the JobStatus* constants
+	ExitCode   int          `json:"exitCode"`            // -1 unless the command exited by itself
+	Output     []string     `json:"output,omitempty"`    // Last lines of output
+	Truncated  bool         `json:"truncated,omitempty"` // Earlier lines were dropped from output
+	DurationMS int64        `json:"durationMs,omitempty"`
+	Problems   []JobProblem `json:"problems,omitempty"` // Errors and warnings found in the output
+}
+
+// Job statuses.
+const (
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded" // Exited with code 0
+	JobStatusFailed    = "failed"    // Exited with another code, or could not start
+	JobStatusCancelled = "cancelled" // Killed by crush/cancelJob or $/cancelRequest
+	JobStatusTimedOut  = "timedOut"  // Killed after jobs.timeout_ms
+)
+
+// CancelJobRequest kills a running job.
+// Method: crush/cancelJob
+type CancelJobRequest struct {
+	Request
+	Params CancelJobParams `json:"params"`
+}
+
+// CancelJobParams names the job.
+type CancelJobParams struct {
+	JobID int `json:"jobId"`
+}
+
+// JobStartedNotification announces a job, so Neovim can open a terminal
+// or quickfix list for its output.
+// Method: crush/jobStarted
+type JobStartedNotification struct {
+	Notification
+	Params JobStartedParams `json:"params"`
+}
+
+// JobStartedParams describes the job.
+type JobStartedParams struct {
+	JobID   int      `json:"jobId"`
+	Kind    string   `json:"kind"`
+	Command []string `json:"command"`
+	Client  string   `json:"client"` // Who ran it
+}
+
+// JobOutputNotification carries lines a job wrote to stdout or stderr,
+// batched.
+// Method: crush/jobOutput
+type JobOutputNotification struct {
+	Notification
+	Params JobOutputParams `json:"params"`
+}
+
+// JobOutputParams is a batch of output lines, in order.
+type JobOutputParams struct {
+	JobID int      `json:"jobId"`
+	Lines []string `json:"lines"`
+}
+
+// JobFinishedNotification reports how a job ended.
+// Method: crush/jobFinished
+type JobFinishedNotification struct {
+	Notification
+	Params JobFinishedParams `json:"params"`
+}
+
+// JobFinishedParams describes the end of a job.
+type JobFinishedParams struct {
+	JobID      int          `json:"jobId"`
+	Kind       string       `json:"kind"`
+	Status     string       `json:"status"` // One of the JobStatus* constants
+	ExitCode   int          `json:"exitCode"`
+	DurationMS int64        `json:"durationMs"`
+	Message    string       `json:"message,omitempty"`  // Why the job could not start
+	Problems   []JobProblem `json:"problems,omitempty"` // Errors and warnings found in the output
+}
+
+// GetRecentJobsRequest asks for the jobs that finished most recently, so
+// an agent can pick up a failure without running the command again.
+// Method: crush/getRecentJobs
+// The daemon remembers the last jobs.history jobs of the session (default
+// 20), each with the last 50 lines of its output.
+type GetRecentJobsRequest struct {
+	Request
+	Params GetRecentJobsParams `json:"params"`
+}
+
+// GetRecentJobsParams filters the jobs.
+type GetRecentJobsParams struct {
+	Kind   string `json:"kind,omitempty"`   // "test" or "build" (default: both)
+	Failed bool   `json:"failed,omitempty"` // Only jobs that did not succeed
+	Limit  int    `json:"limit,omitempty"`  // Most jobs to return (0 = all remembered)
+}
+
+// GetRecentJobsResult lists the jobs, most recent first.
+type GetRecentJobsResult struct {
+	Jobs []RecentJob `json:"jobs"`
+}
+
+// RecentJob is a finished job.
+type RecentJob struct {
+	RunJobResult
+	Client     string    `json:"client"` // Who ran it
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// JobProblem is an error or warning found in a job's output, such as a
+// compile error or a failed test assertion. Problems in workspace files are
+// also published to Neovim as diagnostics when the job ends.
+type JobProblem struct {
+	Path     string `json:"path"`             // Relative to the workspace root; as printed if not found there
+	Line     int    `json:"line,omitempty"`   // 1-indexed
+	Column   int    `json:"column,omitempty"` // 1-indexed
+	Severity string `json:"severity"`         // "error" or "warning"
+	Message  string `json:"message"`
+}
+
+// ListTodosRequest asks the daemon for the workspace's marker comments
+// (TODO, FIXME, HACK, or the diagnostics.markers config).
+// Method: crush/listTodos
+// Files are listed as for crush/listFiles; the daemon's copy of a document
+// agents edited is scanned instead of the file on disk.
+type ListTodosRequest struct {
+	Request
+	Params ListTodosParams `json:"params"`
+}
+
+// ListTodosParams narrows the scan.
+type ListTodosParams struct {
+	PageParams
+	Path string `json:"path,omitempty"` // Only files under this directory (relative to each root)
+}
+
+// ListTodosResult contains the marker comments, in file and line order.
+type ListTodosResult struct {
+	Todos      []TodoInfo `json:"todos"`
+	Truncated  bool       `json:"truncated,omitempty"`  // Files or comments were left out to bound the scan
+	NextCursor string     `json:"nextCursor,omitempty"` // Cursor of the next page, if there is one
+}
+
+// TodoInfo is a comment starting with a marker, such as
+// "// TODO(ana): handle errors".
+type TodoInfo struct {
+	Path   string `json:"path"`            // Relative to the root, slash-separated
+	Line   int    `json:"line"`            // 1-indexed
+	Marker string `json:"marker"`          // e.g. "TODO"
+	Owner  string `json:"owner,omitempty"` // From "TODO(owner)"
+	Text   string `json:"text"`
+	Root   string `json:"root,omitempty"` // Workspace folder the path is in, if not the root
 }
@@ -1,15 +1,84 @@
 package lsp
 
+import "encoding/json"
+
 type InitializeRequest struct {
 	Request
 	Params InitializeRequestParams `json:"params"`
 }
 
 type InitializeRequestParams struct {
-	ClientInfo *ClientInfo `json:"clientInfo"`
+	ClientInfo            *ClientInfo        `json:"clientInfo"`
+	Capabilities          ClientCapabilities `json:"capabilities"`
+	InitializationOptions json.RawMessage    `json:"initializationOptions,omitempty"`
 	// ... there's tons more that goes here
 }
 
+// InitializationOptions are the neocrush settings a client may pass as
+// initializationOptions in initialize.
+type InitializationOptions struct {
+	PluginVersion string `json:"pluginVersion,omitempty"` // Version of the editor plugin
+	ContextLines  int    `json:"contextLines,omitempty"`  // Lines shared around the cursor as editor context
+	AutoApply     *bool  `json:"autoApply,omitempty"`     // Apply Crush edits without confirmation (default true)
+	AuthToken     string `json:"authToken,omitempty"`     // Must match security.auth_token when configured
+}
+
+// ClientCapabilities is the subset of client capabilities the daemon routes on.
+type ClientCapabilities struct {
+	Workspace    WorkspaceClientCapabilities    `json:"workspace"`
+	TextDocument TextDocumentClientCapabilities `json:"textDocument"`
+	Window       WindowClientCapabilities       `json:"window"`
+	Experimental map[string]any                 `json:"experimental,omitempty"`
+}
+
+type WorkspaceClientCapabilities struct {
+	ApplyEdit     bool                            `json:"applyEdit"`
+	WorkspaceEdit WorkspaceEditClientCapabilities `json:"workspaceEdit"`
+	InlayHint     RefreshClientCapabilities       `json:"inlayHint"`
+	CodeLens      RefreshClientCapabilities       `json:"codeLens"`
+}
+
+type WorkspaceEditClientCapabilities struct {
+	DocumentChanges    bool `json:"documentChanges"`
+	SnippetEditSupport bool `json:"snippetEditSupport"`
+}
+
+type RefreshClientCapabilities struct {
+	RefreshSupport bool `json:"refreshSupport"`
+}
+
+type TextDocumentClientCapabilities struct {
+	Completion CompletionClientCapabilities `json:"completion"`
+}
+
+type CompletionClientCapabilities struct {
+	CompletionItem struct {
+		SnippetSupport bool `json:"snippetSupport"`
+	} `json:"completionItem"`
+}
+
+type WindowClientCapabilities struct {
+	WorkDoneProgress bool `json:"workDoneProgress"`
+	ShowDocument     struct {
+		Support bool `json:"support"`
+	} `json:"showDocument"`
+}
+
+// NegotiatedCapabilities is the set of routed features both sides of a
+// route support. Features flowing to Neovim need Neovim's support; features
+// flowing both ways need both clients'.
+type NegotiatedCapabilities struct {
+	ApplyEdit          bool     `json:"applyEdit"`          // Crush edits reach Neovim via workspace/applyEdit
+	DocumentChanges    bool     `json:"documentChanges"`    // Versioned documentChanges in workspace edits
+	SnippetEdits       bool     `json:"snippetEdits"`       // SnippetTextEdit in workspace edits
+	CompletionSnippets bool     `json:"completionSnippets"` // Snippet completion items
+	ShowDocument       bool     `json:"showDocument"`       // window/showDocument
+	WorkDoneProgress   bool     `json:"workDoneProgress"`   // $/progress between peers
+	InlayHintRefresh   bool     `json:"inlayHintRefresh"`   // workspace/inlayHint/refresh
+	CodeLensRefresh    bool     `json:"codeLensRefresh"`    // workspace/codeLens/refresh
+	Clients            []string `json:"clients"`            // Clients that declared capabilities
+}
+
 type ClientInfo struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
@@ -0,0 +1,118 @@
+// Command genmethods writes lsp/methods_gen.go: the table of crush/*
+// methods and the Go types of their params. A method is picked up from the
+// "Method: crush/..." line in the doc comment of a message type whose
+// Params field gives the params type, so the types stay the one place a
+// message is described.
+//
+// Run it with go generate in the lsp package.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// output is the file written, relative to the lsp package.
+const output = "methods_gen.go"
+
+// methodLine matches the method named in a message type's doc comment.
+var methodLine = regexp.MustCompile(`^Method: (crush/[A-Za-z]+)\s*$`)
+
+func main() {
+	src, err := generate(".")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(output, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the source of methods_gen.go for the package in dir.
+func generate(dir string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go") && info.Name() != output
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	pkg, ok := pkgs["lsp"]
+	if !ok {
+		return nil, fmt.Errorf("no lsp package in %s", dir)
+	}
+
+	methods := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(pkg.Files)) {
+		for _, decl := range pkg.Files[name].Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				doc := ts.Doc
+				if doc == nil && len(gen.Specs) == 1 {
+					doc = gen.Doc
+				}
+				params := paramsType(ts)
+				if doc == nil || params == "" {
+					continue
+				}
+				for _, line := range strings.Split(doc.Text(), "\n") {
+					m := methodLine.FindStringSubmatch(line)
+					if m == nil {
+						continue
+					}
+					if prev, ok := methods[m[1]]; ok && prev != params {
+						return nil, fmt.Errorf("%s has params %s and %s", m[1], prev, params)
+					}
+					methods[m[1]] = params
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by go run ./internal/genmethods; DO NOT EDIT.\n\n")
+	buf.WriteString("package lsp\n\nimport \"reflect\"\n\n")
+	buf.WriteString("// crushMethods maps each crush/* method to the type of its params.\n")
+	buf.WriteString("var crushMethods = map[string]reflect.Type{\n")
+	for _, method := range slices.Sorted(maps.Keys(methods)) {
+		fmt.Fprintf(&buf, "\t%q: reflect.TypeFor[%s](),\n", method, methods[method])
+	}
+	buf.WriteString("}\n")
+	return format.Source(buf.Bytes())
+}
+
+// paramsType returns the type of the Params field of a struct type, or ""
+// if it has none.
+func paramsType(ts *ast.TypeSpec) string {
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return ""
+	}
+	for _, field := range st.Fields.List {
+		for _, name := range field.Names {
+			if name.Name != "Params" {
+				continue
+			}
+			var buf bytes.Buffer
+			if err := format.Node(&buf, token.NewFileSet(), field.Type); err != nil {
+				return ""
+			}
+			return buf.String()
+		}
+	}
+	return ""
+}
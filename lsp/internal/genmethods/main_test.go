@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMethodsUpToDate(t *testing.T) {
+	dir := filepath.Join("..", "..")
+	want, err := generate(dir)
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, output))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", output, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s is out of date; run go generate ./lsp", output)
+	}
+}
@@ -0,0 +1,41 @@
+// Command genschema writes the JSON schema of the params of each crush/*
+// method to lsp/schema/<method>.json (crush/cursorMoved to
+// schema/cursorMoved.json), inferred from the lsp package's Go types.
+// Files for methods that no longer exist are removed.
+//
+// Run it with go generate in the lsp package.
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// dir is where the schemas are written, relative to the lsp package.
+const dir = "schema"
+
+func main() {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	old, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range old {
+		os.Remove(path)
+	}
+
+	for _, method := range lsp.CrushMethods() {
+		data, err := lsp.SchemaJSON(method)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, lsp.SchemaFile(method)), data, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
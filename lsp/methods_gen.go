@@ -0,0 +1,72 @@
+// Code generated by go run ./internal/genmethods; DO NOT EDIT.
+
+package lsp
+
+import "reflect"
+
+// crushMethods maps each crush/* method to the type of its params.
+var crushMethods = map[string]reflect.Type{
+	"crush/appendTranscript":   reflect.TypeFor[AppendTranscriptParams](),
+	"crush/cancelJob":          reflect.TypeFor[CancelJobParams](),
+	"crush/clientConnected":    reflect.TypeFor[ClientPresenceParams](),
+	"crush/clientDisconnected": reflect.TypeFor[ClientPresenceParams](),
+	"crush/codeLens":           reflect.TypeFor[CodeLensPublishParams](),
+	"crush/commit":             reflect.TypeFor[CommitParams](),
+	"crush/createCheckpoint":   reflect.TypeFor[CreateCheckpointParams](),
+	"crush/cursorMoved":        reflect.TypeFor[CursorMovedParams](),
+	"crush/documentChanged":    reflect.TypeFor[DocumentChangedParams](),
+	"crush/documentClosed":     reflect.TypeFor[DocumentNotifyParams](),
+	"crush/documentContent":    reflect.TypeFor[DocumentContentParams](),
+	"crush/documentOpened":     reflect.TypeFor[DocumentNotifyParams](),
+	"crush/editFile":           reflect.TypeFor[EditFileParams](),
+	"crush/editSelections":     reflect.TypeFor[EditSelectionsParams](),
+	"crush/editSummary":        reflect.TypeFor[EditSummaryParams](),
+	"crush/error":              reflect.TypeFor[ErrorParams](),
+	"crush/executeLens":        reflect.TypeFor[ExecuteLensParams](),
+	"crush/exportPatch":        reflect.TypeFor[ExportPatchParams](),
+	"crush/fixDiagnostic":      reflect.TypeFor[FixDiagnosticParams](),
+	"crush/fixVerified":        reflect.TypeFor[FixVerifiedParams](),
+	"crush/focusChanged":       reflect.TypeFor[FocusChangedParams](),
+	"crush/focusFile":          reflect.TypeFor[FocusFileParams](),
+	"crush/generateFile":       reflect.TypeFor[GenerateFileParams](),
+	"crush/getEnvironment":     reflect.TypeFor[GetEnvironmentParams](),
+	"crush/getRecentJobs":      reflect.TypeFor[GetRecentJobsParams](),
+	"crush/getState":           reflect.TypeFor[GetStateParams](),
+	"crush/history":            reflect.TypeFor[HistoryParams](),
+	"crush/importPatch":        reflect.TypeFor[ImportPatchParams](),
+	"crush/inlayHints":         reflect.TypeFor[InlayHintsParams](),
+	"crush/inspectMessage":     reflect.TypeFor[InspectMessageParams](),
+	"crush/invokeAction":       reflect.TypeFor[InvokeActionParams](),
+	"crush/jobFinished":        reflect.TypeFor[JobFinishedParams](),
+	"crush/jobOutput":          reflect.TypeFor[JobOutputParams](),
+	"crush/jobStarted":         reflect.TypeFor[JobStartedParams](),
+	"crush/listFiles":          reflect.TypeFor[ListFilesParams](),
+	"crush/listTodos":          reflect.TypeFor[ListTodosParams](),
+	"crush/logMessage":         reflect.TypeFor[LogMessageParams](),
+	"crush/mcpInitialize":      reflect.TypeFor[MCPInitializeParams](),
+	"crush/openScratch":        reflect.TypeFor[OpenScratchParams](),
+	"crush/previewFile":        reflect.TypeFor[PreviewFileParams](),
+	"crush/readFile":           reflect.TypeFor[ReadFileParams](),
+	"crush/recentChanges":      reflect.TypeFor[RecentChangesParams](),
+	"crush/registerMethod":     reflect.TypeFor[RegisterMethodParams](),
+	"crush/relatedFiles":       reflect.TypeFor[RelatedFilesParams](),
+	"crush/renderSnippet":      reflect.TypeFor[RenderSnippetParams](),
+	"crush/resolveHunk":        reflect.TypeFor[ResolveHunkParams](),
+	"crush/resolvePreview":     reflect.TypeFor[ResolvePreviewParams](),
+	"crush/restoreCheckpoint":  reflect.TypeFor[RestoreCheckpointParams](),
+	"crush/resyncDocument":     reflect.TypeFor[ResyncDocumentParams](),
+	"crush/reviewHunks":        reflect.TypeFor[ReviewHunksParams](),
+	"crush/runJob":             reflect.TypeFor[RunJobParams](),
+	"crush/selectionChanged":   reflect.TypeFor[SelectionChangedParams](),
+	"crush/sessionEnding":      reflect.TypeFor[SessionEndingParams](),
+	"crush/setFaults":          reflect.TypeFor[SetFaultsParams](),
+	"crush/setLogLevel":        reflect.TypeFor[SetLogLevelParams](),
+	"crush/showLocations":      reflect.TypeFor[ShowLocationsParams](),
+	"crush/stageFiles":         reflect.TypeFor[StageFilesParams](),
+	"crush/stopDaemon":         reflect.TypeFor[StopDaemonParams](),
+	"crush/subscribe":          reflect.TypeFor[SubscribeParams](),
+	"crush/undoEditGroup":      reflect.TypeFor[UndoEditGroupParams](),
+	"crush/verifySync":         reflect.TypeFor[VerifySyncParams](),
+	"crush/workspaceStats":     reflect.TypeFor[WorkspaceStatsParams](),
+	"crush/writeFile":          reflect.TypeFor[WriteFileParams](),
+}
@@ -0,0 +1,53 @@
+package lsp
+
+import "encoding/json"
+
+// ProgressToken identifies a work-done progress stream. The LSP allows either
+// an integer or a string, so it is kept as raw JSON and passed through as-is.
+type ProgressToken = json.RawMessage
+
+// WorkDoneProgressCreateRequest asks the client to create a progress UI.
+// Method: window/workDoneProgress/create
+type WorkDoneProgressCreateRequest struct {
+	Request
+	Params WorkDoneProgressCreateParams `json:"params"`
+}
+
+// WorkDoneProgressCreateParams contains the token for the new progress.
+type WorkDoneProgressCreateParams struct {
+	Token ProgressToken `json:"token"`
+}
+
+// WorkDoneProgressCancelNotification is sent by the client to cancel progress.
+// Method: window/workDoneProgress/cancel
+type WorkDoneProgressCancelNotification struct {
+	Notification
+	Params WorkDoneProgressCancelParams `json:"params"`
+}
+
+// WorkDoneProgressCancelParams contains the token to cancel.
+type WorkDoneProgressCancelParams struct {
+	Token ProgressToken `json:"token"`
+}
+
+// ProgressNotification reports progress for a token.
+// Method: $/progress
+type ProgressNotification struct {
+	Notification
+	Params ProgressParams `json:"params"`
+}
+
+// ProgressParams contains a progress update.
+type ProgressParams struct {
+	Token ProgressToken         `json:"token"`
+	Value WorkDoneProgressValue `json:"value"`
+}
+
+// WorkDoneProgressValue is the begin/report/end payload of $/progress.
+type WorkDoneProgressValue struct {
+	Kind        string `json:"kind"` // "begin", "report", or "end"
+	Title       string `json:"title,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Percentage  *int   `json:"percentage,omitempty"`
+	Cancellable bool   `json:"cancellable,omitempty"`
+}
@@ -0,0 +1,134 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/jsonschema-go/jsonschema"
+)
+
+// The Go types in this package are the source of truth for the crush/*
+// messages. The method table and the JSON schemas in schema/ (for the
+// Neovim plugin, Crush and other clients) are generated from them:
+//
+//go:generate go run ./internal/genmethods
+//go:generate go run ./internal/genschema
+
+// ValidationError reports params that don't match their method's schema.
+type ValidationError struct {
+	Method string
+	Err    error // From the schema validator, naming the offending field
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s params: %v", e.Method, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// schemaOptions map types whose JSON form differs from their Go form.
+var schemaOptions = &jsonschema.ForOptions{
+	TypeSchemas: map[reflect.Type]*jsonschema.Schema{
+		reflect.TypeFor[json.RawMessage](): {}, // Any JSON value
+	},
+}
+
+var (
+	resolvedMu sync.Mutex
+	resolved   = make(map[string]*jsonschema.Resolved) // Method -> its params schema, resolved
+)
+
+// CrushMethods returns the crush/* methods whose params have a schema,
+// sorted.
+func CrushMethods() []string {
+	methods := make([]string, 0, len(crushMethods))
+	for method := range crushMethods {
+		methods = append(methods, method)
+	}
+	slices.Sort(methods)
+	return methods
+}
+
+// ParamsSchema returns the JSON schema of method's params, inferred from
+// their Go type: fields without omitempty are required and unknown fields
+// are not allowed. It reports false for methods without typed params.
+func ParamsSchema(method string) (*jsonschema.Schema, bool) {
+	t, ok := crushMethods[method]
+	if !ok {
+		return nil, false
+	}
+	s, err := jsonschema.ForType(t, schemaOptions)
+	if err != nil {
+		// The types are fixed at build time; the schema test covers them
+		panic(err)
+	}
+	s.Title = method
+	return s, true
+}
+
+// SchemaJSON returns ParamsSchema(method) as written to the schema
+// directory: indented, with a trailing newline.
+func SchemaJSON(method string) ([]byte, error) {
+	s, ok := ParamsSchema(method)
+	if !ok {
+		return nil, fmt.Errorf("%s has no params schema", method)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// SchemaFile returns the name of the file in the schema directory holding
+// the params schema of method: crush/cursorMoved is cursorMoved.json.
+func SchemaFile(method string) string {
+	return strings.TrimPrefix(method, "crush/") + ".json"
+}
+
+// ValidateParams checks the params of a method against its schema,
+// returning a *ValidationError if they don't match. Missing params are
+// checked as an empty object. Methods without a schema always pass.
+func ValidateParams(method string, params json.RawMessage) error {
+	rs, err := resolvedSchema(method)
+	if rs == nil || err != nil {
+		return err
+	}
+
+	var instance any = map[string]any{}
+	if trimmed := strings.TrimSpace(string(params)); trimmed != "" && trimmed != "null" {
+		if err := json.Unmarshal(params, &instance); err != nil {
+			return &ValidationError{Method: method, Err: err}
+		}
+	}
+	if err := rs.Validate(instance); err != nil {
+		return &ValidationError{Method: method, Err: err}
+	}
+	return nil
+}
+
+// resolvedSchema returns the resolved params schema of method, or nil if
+// it has none.
+func resolvedSchema(method string) (*jsonschema.Resolved, error) {
+	resolvedMu.Lock()
+	defer resolvedMu.Unlock()
+	if rs, ok := resolved[method]; ok {
+		return rs, nil
+	}
+	s, ok := ParamsSchema(method)
+	if !ok {
+		return nil, nil
+	}
+	rs, err := s.Resolve(nil)
+	if err != nil {
+		return nil, fmt.Errorf("schema of %s: %w", method, err)
+	}
+	resolved[method] = rs
+	return rs, nil
+}
@@ -0,0 +1,64 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemasUpToDate(t *testing.T) {
+	want := make(map[string]bool)
+	for _, method := range CrushMethods() {
+		data, err := SchemaJSON(method)
+		if err != nil {
+			t.Fatalf("Failed to build the schema of %s: %v", method, err)
+		}
+		file := SchemaFile(method)
+		want[file] = true
+		written, err := os.ReadFile(filepath.Join("schema", file))
+		if err != nil || !bytes.Equal(written, data) {
+			t.Errorf("schema/%s is out of date; run go generate ./lsp", file)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join("schema", "*.json"))
+	for _, path := range files {
+		if !want[filepath.Base(path)] {
+			t.Errorf("%s has no method; run go generate ./lsp", path)
+		}
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	tests := []struct {
+		method string
+		params string
+		want   string // Substring of the error, or "" for valid params
+	}{
+		{"crush/showLocations", `{"title":"Callers","items":[{"filename":"a.go","lnum":3,"text":"x","note":"y"}]}`, ""},
+		{"crush/showLocations", `{"title":"Callers","items":[{"filename":"a.go","line":3,"text":"x","note":"y"}]}`, `additional properties ["line"]`},
+		{"crush/cursorMoved", `{"textDocument":{"uri":"file:///a.go"},"position":{"line":"3","character":0}}`, "line"},
+		{"crush/cursorMoved", ``, "textDocument"},
+		{"crush/subscribe", `{"cursorChanges":true}`, ""},
+		{"crush/subscribe", `[]`, "object"},
+		{"crush/getEditorContext", `{"anything":1}`, ""}, // No schema
+		{"textDocument/hover", `{}`, ""},
+	}
+	for _, tt := range tests {
+		err := ValidateParams(tt.method, json.RawMessage(tt.params))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s %s: unexpected error: %v", tt.method, tt.params, err)
+			}
+			continue
+		}
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || invalid.Method != tt.method || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %s: expected a validation error mentioning %q, got %v", tt.method, tt.params, tt.want, err)
+		}
+	}
+}
@@ -17,13 +17,18 @@ type TextDocumentCodeActionResponse struct {
 }
 
 type CodeActionContext struct {
-	// Add fields for CodeActionContext as needed
+	// Diagnostics overlapping the range, as the client knows them
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	// Only limits the result to these kinds (and their subkinds)
+	Only []string `json:"only,omitempty"`
 }
 
 type CodeAction struct {
-	Title   string         `json:"title"`
-	Edit    *WorkspaceEdit `json:"edit,omitempty"`
-	Command *Command       `json:"command,omitempty"`
+	Title       string         `json:"title"`
+	Kind        string         `json:"kind,omitempty"`
+	Diagnostics []Diagnostic   `json:"diagnostics,omitempty"`
+	Edit        *WorkspaceEdit `json:"edit,omitempty"`
+	Command     *Command       `json:"command,omitempty"`
 }
 
 type Command struct {
@@ -10,6 +10,14 @@ type PublishDiagnosticsParams struct {
 	Diagnostics []Diagnostic `json:"diagnostics"`
 }
 
+// Diagnostic severities.
+const (
+	SeverityError       = 1
+	SeverityWarning     = 2
+	SeverityInformation = 3
+	SeverityHint        = 4
+)
+
 type Diagnostic struct {
 	Range    Range  `json:"range"`
 	Severity int    `json:"severity"`
@@ -0,0 +1,20 @@
+package lsp
+
+type WillSaveWaitUntilRequest struct {
+	Request
+	Params WillSaveTextDocumentParams `json:"params"`
+}
+
+type WillSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Reason       TextDocumentSaveReason `json:"reason"`
+}
+
+// TextDocumentSaveReason says why a document is being saved.
+type TextDocumentSaveReason int
+
+const (
+	SaveReasonManual     TextDocumentSaveReason = 1
+	SaveReasonAfterDelay TextDocumentSaveReason = 2
+	SaveReasonFocusOut   TextDocumentSaveReason = 3
+)
@@ -1,5 +1,7 @@
 package lsp
 
+import "encoding/json"
+
 // WorkspaceApplyEditRequest is sent from server to client to apply edits.
 // Method: workspace/applyEdit
 type WorkspaceApplyEditRequest struct {
@@ -9,8 +11,10 @@ type WorkspaceApplyEditRequest struct {
 
 // ApplyWorkspaceEditParams contains the workspace edit to apply.
 type ApplyWorkspaceEditParams struct {
-	Label string        `json:"label,omitempty"`
-	Edit  WorkspaceEdit `json:"edit"`
+	Label     string        `json:"label,omitempty"`
+	Edit      WorkspaceEdit `json:"edit"`
+	EditGroup *EditGroup    `json:"editGroup,omitempty"` // neocrush extension, see EditGroup
+	Fold      []FoldHint    `json:"fold,omitempty"`      // neocrush extension, see FoldHint
 }
 
 // ApplyWorkspaceEditResponse is the client's response to workspace/applyEdit.
@@ -111,3 +115,83 @@ type DidSaveTextDocumentParams struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
 	Text         *string                `json:"text,omitempty"` // If includeText is true
 }
+
+// ShowMessageNotification asks the client to display a message to the user.
+// Method: window/showMessage
+type ShowMessageNotification struct {
+	Notification
+	Params ShowMessageParams `json:"params"`
+}
+
+// ShowMessageParams contains the message to display.
+type ShowMessageParams struct {
+	Type    MessageType `json:"type"`
+	Message string      `json:"message"`
+}
+
+// MessageType is the severity of a window/showMessage message.
+type MessageType int
+
+const (
+	// MessageTypeError is an error message.
+	MessageTypeError MessageType = 1
+	// MessageTypeWarning is a warning message.
+	MessageTypeWarning MessageType = 2
+	// MessageTypeInfo is an information message.
+	MessageTypeInfo MessageType = 3
+	// MessageTypeLog is a log message.
+	MessageTypeLog MessageType = 4
+)
+
+// ShowMessageRequestParams asks the user to pick one of the actions.
+// Method: window/showMessageRequest
+// The client answers with the chosen MessageActionItem, or null if the user
+// dismissed the message.
+type ShowMessageRequestParams struct {
+	Type    MessageType         `json:"type"`
+	Message string              `json:"message"`
+	Actions []MessageActionItem `json:"actions,omitempty"`
+}
+
+// MessageActionItem is an action of a window/showMessageRequest.
+type MessageActionItem struct {
+	Title string `json:"title"`
+}
+
+// ExecuteCommandRequest asks the server to run a command.
+// Method: workspace/executeCommand
+type ExecuteCommandRequest struct {
+	Request
+	Params ExecuteCommandParams `json:"params"`
+}
+
+// ExecuteCommandParams names the command and its arguments.
+type ExecuteCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+}
+
+// WorkspaceFolder is one root of a multi-root workspace.
+type WorkspaceFolder struct {
+	URI  string `json:"uri"`
+	Name string `json:"name"`
+}
+
+// DidChangeWorkspaceFoldersNotification is sent when the client adds or
+// removes workspace folders.
+// Method: workspace/didChangeWorkspaceFolders
+type DidChangeWorkspaceFoldersNotification struct {
+	Notification
+	Params DidChangeWorkspaceFoldersParams `json:"params"`
+}
+
+// DidChangeWorkspaceFoldersParams contains the folder change.
+type DidChangeWorkspaceFoldersParams struct {
+	Event WorkspaceFoldersChangeEvent `json:"event"`
+}
+
+// WorkspaceFoldersChangeEvent lists the added and removed folders.
+type WorkspaceFoldersChangeEvent struct {
+	Added   []WorkspaceFolder `json:"added"`
+	Removed []WorkspaceFolder `json:"removed"`
+}
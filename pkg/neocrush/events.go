@@ -0,0 +1,243 @@
+package neocrush
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/taigrr/neocrush/lsp"
+)
+
+// defaultEventBuffer is how many events a stream holds for a slow reader
+// unless EventFilter.Buffer says otherwise.
+const defaultEventBuffer = 256
+
+// Event is an event from the daemon, one of *CursorMoved,
+// *SelectionChanged, *FocusChanged, *DocumentChanged, *Diagnostics,
+// *ClientConnected, *ClientDisconnected, *Reconnected or *Dropped.
+type Event interface {
+	// Method is the notification the event came as, or "" for the
+	// stream's own events (*Reconnected, *Dropped).
+	Method() string
+}
+
+// CursorMoved is Neovim's cursor moving (crush/cursorMoved).
+type CursorMoved struct{ lsp.CursorMovedParams }
+
+// SelectionChanged is Neovim's selections changing (crush/selectionChanged).
+type SelectionChanged struct{ lsp.SelectionChangedParams }
+
+// FocusChanged is Neovim's cursor moving to another document
+// (crush/focusChanged).
+type FocusChanged struct{ lsp.FocusChangedParams }
+
+// DocumentChanged is a document's full text after a change
+// (crush/documentChanged).
+type DocumentChanged struct{ lsp.DocumentChangedParams }
+
+// Diagnostics are a document's diagnostics, replacing the earlier ones
+// (textDocument/publishDiagnostics).
+type Diagnostics struct{ lsp.PublishDiagnosticsParams }
+
+// ClientConnected is another client attaching (crush/clientConnected).
+type ClientConnected struct{ lsp.ClientPresenceParams }
+
+// ClientDisconnected is another client detaching
+// (crush/clientDisconnected).
+type ClientDisconnected struct{ lsp.ClientPresenceParams }
+
+// Reconnected is sent after the client lost the daemon and reconnected.
+// Events in between are lost, so state derived from them should be
+// fetched again.
+type Reconnected struct {
+	ClientID string // The connection's new client ID
+}
+
+// Dropped is sent before the next event when the reader fell behind and
+// the stream dropped its oldest events.
+type Dropped struct {
+	Count int
+}
+
+func (*CursorMoved) Method() string        { return "crush/cursorMoved" }
+func (*SelectionChanged) Method() string   { return "crush/selectionChanged" }
+func (*FocusChanged) Method() string       { return "crush/focusChanged" }
+func (*DocumentChanged) Method() string    { return "crush/documentChanged" }
+func (*Diagnostics) Method() string        { return "textDocument/publishDiagnostics" }
+func (*ClientConnected) Method() string    { return "crush/clientConnected" }
+func (*ClientDisconnected) Method() string { return "crush/clientDisconnected" }
+func (*Reconnected) Method() string        { return "" }
+func (*Dropped) Method() string            { return "" }
+
+// decodeEvent parses a notification into its event. It reports false for
+// notifications that aren't events, and malformed ones.
+func decodeEvent(method string, params json.RawMessage) (Event, bool) {
+	var event Event
+	switch method {
+	case "crush/cursorMoved":
+		event = &CursorMoved{}
+	case "crush/selectionChanged":
+		event = &SelectionChanged{}
+	case "crush/focusChanged":
+		event = &FocusChanged{}
+	case "crush/documentChanged":
+		event = &DocumentChanged{}
+	case "textDocument/publishDiagnostics":
+		event = &Diagnostics{}
+	case "crush/clientConnected":
+		event = &ClientConnected{}
+	case "crush/clientDisconnected":
+		event = &ClientDisconnected{}
+	default:
+		return nil, false
+	}
+	if err := json.Unmarshal(params, event); err != nil {
+		return nil, false
+	}
+	return event, true
+}
+
+// EventFilter picks the events of a SubscribeEvents stream.
+type EventFilter struct {
+	lsp.SubscribeParams      // Editor events to subscribe to
+	Presence            bool // Other clients attaching and detaching
+	// Buffer is how many events are held for a slow reader before the
+	// oldest are dropped; 0 means 256.
+	Buffer int
+}
+
+// wants reports whether the filter picks event.
+func (f EventFilter) wants(event Event) bool {
+	switch event.(type) {
+	case *CursorMoved, *SelectionChanged:
+		return f.CursorChanges
+	case *FocusChanged:
+		return f.FocusChanges
+	case *DocumentChanged:
+		return f.DocumentChanges
+	case *Diagnostics:
+		return f.Diagnostics
+	case *ClientConnected, *ClientDisconnected:
+		return f.Presence
+	}
+	return true
+}
+
+// stream is one SubscribeEvents channel. Events are queued by the
+// connection's read loop and sent by a goroutine of the stream's own, so
+// a slow reader never holds up the connection.
+type stream struct {
+	filter EventFilter
+	out    chan Event
+
+	mu      sync.Mutex
+	queue   []Event       // Waiting to be sent, oldest first
+	dropped int           // Dropped since the last event sent
+	wake    chan struct{} // Signalled when queue grows
+}
+
+// SubscribeEvents subscribes to the events filter picks and returns them
+// on a channel, decoded into typed events. The stream survives the client
+// reconnecting, which it reports with *Reconnected. When the reader falls
+// behind by more than filter.Buffer events, the oldest are dropped and
+// *Dropped says how many. The channel is closed when ctx is done or the
+// client is closed.
+func (c *Client) SubscribeEvents(ctx context.Context, filter EventFilter) (<-chan Event, error) {
+	s := &stream{
+		filter: filter,
+		out:    make(chan Event),
+		wake:   make(chan struct{}, 1),
+	}
+	c.mu.Lock()
+	c.streams[s] = true
+	c.mu.Unlock()
+
+	if filter.SubscribeParams != (lsp.SubscribeParams{}) {
+		if err := c.subscribe(ctx); err != nil {
+			c.mu.Lock()
+			delete(c.streams, s)
+			c.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	go func() {
+		s.run(ctx, c.done)
+		c.mu.Lock()
+		delete(c.streams, s)
+		c.mu.Unlock()
+		// Let the daemon stop sending what no one reads anymore
+		if filter.SubscribeParams != (lsp.SubscribeParams{}) {
+			subCtx, cancel := context.WithTimeout(context.Background(), renewTimeout)
+			c.subscribe(subCtx)
+			cancel()
+		}
+	}()
+	return s.out, nil
+}
+
+// push queues event, dropping the oldest queued event if the buffer is
+// full.
+func (s *stream) push(event Event) {
+	size := s.filter.Buffer
+	if size <= 0 {
+		size = defaultEventBuffer
+	}
+
+	s.mu.Lock()
+	if len(s.queue) >= size {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, event)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the next event to send: a *Dropped if events were dropped,
+// else the oldest queued one.
+func (s *stream) next() (Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dropped > 0 {
+		event := &Dropped{Count: s.dropped}
+		s.dropped = 0
+		return event, true
+	}
+	if len(s.queue) == 0 {
+		return nil, false
+	}
+	event := s.queue[0]
+	s.queue = s.queue[1:]
+	return event, true
+}
+
+// run sends queued events to the reader until ctx or done ends the
+// stream, then closes the channel.
+func (s *stream) run(ctx context.Context, done <-chan struct{}) {
+	defer close(s.out)
+	for {
+		event, ok := s.next()
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+		select {
+		case s.out <- event:
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,420 @@
+// Package neocrush is a client library for agents that integrate with a
+// neocrush daemon. It speaks the daemon's LSP framing and crush/* messages
+// so Go programs can read the editor's context, edit Neovim's buffers,
+// show locations and follow editor events with typed calls.
+//
+// A Client registers with the daemon the way the MCP server does, so it
+// shows up as an MCP client (mcp-N) and is held to the same guardrails.
+// When the daemon goes away, the client redials it in the background,
+// registers again and renews its subscriptions.
+package neocrush
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/taigrr/neocrush/internal/client"
+	"github.com/taigrr/neocrush/lsp"
+)
+
+const (
+	// reconnectDelay is the first wait before redialing a daemon that went
+	// away. It doubles after each failed attempt, up to maxReconnectDelay.
+	reconnectDelay    = 250 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+	// renewTimeout bounds registering and subscribing again after a
+	// reconnect.
+	renewTimeout = 10 * time.Second
+)
+
+// ResponseError is a JSON-RPC error returned by the daemon. Use errors.As
+// to inspect its code and data.
+type ResponseError = client.ResponseError
+
+var (
+	// ErrReconnecting fails calls made while the daemon is unreachable and
+	// the client is redialing it.
+	ErrReconnecting = errors.New("daemon unreachable, reconnecting")
+	// ErrClosed fails calls made after Close.
+	ErrClosed = errors.New("client closed")
+)
+
+// Options configure how a Client introduces itself.
+type Options struct {
+	Name      string      // clientInfo name; defaults to "neocrush-go"
+	Version   string      // clientInfo version
+	AuthToken string      // Required when the daemon sets security.auth_token
+	Logger    *log.Logger // Connection logs; discarded when nil
+}
+
+// Client is a registered connection to a neocrush daemon. Its methods may
+// be called concurrently.
+type Client struct {
+	opts   Options
+	redial func() (*client.Client, error)
+
+	mu           sync.Mutex
+	conn         *client.Client
+	calls        *client.Dispatcher
+	id           string
+	reconnecting bool                // The daemon is gone; a reconnect loop is running
+	closed       bool                // Close was called
+	done         chan struct{}       // Closed by Close
+	handler      func(Notification)  // Set by Subscribe
+	events       lsp.SubscribeParams // Subscribe's events
+	streams      map[*stream]bool    // Open SubscribeEvents streams
+}
+
+// Notification is a notification the daemon sent the client.
+type Notification struct {
+	Method string
+	Params json.RawMessage
+}
+
+// EditorContext is Neovim's state around the cursor, as the editor_context
+// MCP tool reports it. Lines and columns are 1-indexed.
+type EditorContext struct {
+	URI           string `json:"uri"`
+	Filename      string `json:"filename"`
+	CursorLine    int    `json:"cursor_line"`
+	CursorColumn  int    `json:"cursor_column"`
+	ContextBefore string `json:"context_before"`
+	ContextLine   string `json:"context_line"`
+	ContextAfter  string `json:"context_after"`
+	TotalLines    int    `json:"total_lines"`
+	HasSelection  bool   `json:"has_selection"`
+	Selection     string `json:"selection,omitempty"`
+
+	Selections  []lsp.SelectionContext  `json:"selections,omitempty"`
+	Cursors     []lsp.Position          `json:"cursors,omitempty"`
+	Definitions []lsp.DefinitionContext `json:"definitions,omitempty"`
+
+	StateVersion int64 `json:"state_version"`
+}
+
+// Connect returns a client of the daemon serving workspace, starting a
+// daemon if none is running.
+func Connect(ctx context.Context, workspace string, opts Options) (*Client, error) {
+	return start(ctx, opts, func() (*client.Client, error) {
+		return client.Connect(logger(opts), workspace)
+	})
+}
+
+// Dial returns a client of the daemon listening on socketPath.
+func Dial(ctx context.Context, socketPath string, opts Options) (*Client, error) {
+	return start(ctx, opts, func() (*client.Client, error) {
+		return client.Dial(socketPath, logger(opts))
+	})
+}
+
+// start dials with redial and registers the connection.
+func start(ctx context.Context, opts Options, redial func() (*client.Client, error)) (*Client, error) {
+	conn, err := redial()
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		opts:    opts,
+		redial:  redial,
+		done:    make(chan struct{}),
+		streams: make(map[*stream]bool),
+	}
+	if err := c.attach(ctx, conn); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func logger(opts Options) *log.Logger {
+	if opts.Logger != nil {
+		return opts.Logger
+	}
+	return log.New(io.Discard, "", 0)
+}
+
+// attach registers conn with the daemon under crush/mcpInitialize and
+// makes it the client's connection. When conn goes away, the client
+// reconnects in the background.
+func (c *Client) attach(ctx context.Context, conn *client.Client) error {
+	calls := conn.Dispatch(c.dispatch)
+	raw, err := calls.Call(ctx, "", "crush/mcpInitialize", lsp.MCPInitializeParams{
+		ClientInfo: lsp.ClientInfo{Name: cmp.Or(c.opts.Name, "neocrush-go"), Version: c.opts.Version},
+		AuthToken:  c.opts.AuthToken,
+	})
+	var result lsp.MCPInitializeResult
+	if err == nil {
+		err = json.Unmarshal(raw, &result)
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to register with daemon: %w", err)
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	c.conn, c.calls, c.id = conn, calls, result.ClientID
+	c.mu.Unlock()
+	go func() {
+		<-calls.Done()
+		c.lost(calls)
+	}()
+	return nil
+}
+
+// lost starts reconnecting after the connection of calls went away, unless
+// the client was closed.
+func (c *Client) lost(calls *client.Dispatcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.reconnecting || calls != c.calls {
+		return
+	}
+	c.reconnecting = true
+	logger(c.opts).Printf("Lost the daemon connection, reconnecting")
+	go c.reconnectLoop()
+}
+
+// reconnectLoop redials the daemon with exponential backoff until it
+// answers or the client is closed, then renews the subscription and tells
+// the event streams.
+func (c *Client) reconnectLoop() {
+	delay := reconnectDelay
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+
+		conn, err := c.redial()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), renewTimeout)
+			err = c.attach(ctx, conn)
+			cancel()
+		}
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		if err != nil {
+			logger(c.opts).Printf("Reconnecting to daemon failed: %v", err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.reconnecting = false
+		id := c.id
+		streams := make([]*stream, 0, len(c.streams))
+		for s := range c.streams {
+			streams = append(streams, s)
+		}
+		c.mu.Unlock()
+		if err := c.resubscribe(); err != nil {
+			logger(c.opts).Printf("Failed to renew the subscription: %v", err)
+		}
+		for _, s := range streams {
+			s.push(&Reconnected{ClientID: id})
+		}
+		return
+	}
+}
+
+// ID returns the client ID the daemon gave the connection, e.g. "mcp-2".
+// It changes when the client reconnects.
+func (c *Client) ID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.id
+}
+
+// Close closes the connection and ends the event streams.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	c.mu.Unlock()
+	return conn.Close()
+}
+
+// Done is closed when the client is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// dispatcher returns the dispatcher of the current connection, or the
+// error calls fail with while there is none.
+func (c *Client) dispatcher() (*client.Dispatcher, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.closed:
+		return nil, ErrClosed
+	case c.reconnecting:
+		return nil, ErrReconnecting
+	}
+	return c.calls, nil
+}
+
+// Call sends method to the daemon and decodes its result into result
+// (unless nil). It is the escape hatch for crush/* methods without a typed
+// call. Cancelling ctx cancels the request. While the daemon is
+// unreachable it fails with ErrReconnecting.
+func (c *Client) Call(ctx context.Context, method string, params, result any) error {
+	calls, err := c.dispatcher()
+	if err != nil {
+		return err
+	}
+	raw, err := calls.Call(ctx, "", method, params)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", method, err)
+	}
+	return nil
+}
+
+// Notify sends the notification method to the daemon.
+func (c *Client) Notify(method string, params any) error {
+	if _, err := c.dispatcher(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	return conn.Notify(method, params)
+}
+
+// GetEditorContext returns Neovim's cursor, selections and the code around
+// them, with the definitions of the symbols at the cursor if
+// includeDefinitions is set.
+func (c *Client) GetEditorContext(ctx context.Context, includeDefinitions bool) (*EditorContext, error) {
+	var result EditorContext
+	err := c.Call(ctx, "crush/getEditorContext", map[string]any{"includeDefinitions": includeDefinitions}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ApplyEdit applies edits to the document at uri through Neovim, so they
+// land in its buffer (loading the file if needed) and undo history. A
+// rejected edit is reported with Applied false rather than an error.
+func (c *Client) ApplyEdit(ctx context.Context, uri string, edits []lsp.TextEdit) (*lsp.EditFileResult, error) {
+	var result lsp.EditFileResult
+	err := c.Call(ctx, "crush/editFile", lsp.EditFileParams{
+		TextDocument: lsp.VersionTextDocumentIdentifier{TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri}},
+		Edits:        edits,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ShowLocations shows items in Neovim's picker under title.
+func (c *Client) ShowLocations(title string, items []lsp.LocationItem) error {
+	return c.Notify("crush/showLocations", lsp.ShowLocationsParams{Title: title, Items: items})
+}
+
+// Subscribe asks the daemon for the events in events and calls handler
+// with every notification the daemon sends from then on, replacing any
+// earlier Subscribe. handler runs on the connection's read loop, so it
+// must not block. Subscribing to no events unsubscribes. The daemon also
+// keeps sending the events of open SubscribeEvents streams.
+func (c *Client) Subscribe(ctx context.Context, events lsp.SubscribeParams, handler func(Notification)) error {
+	c.mu.Lock()
+	c.handler = handler
+	c.events = events
+	c.mu.Unlock()
+	return c.subscribe(ctx)
+}
+
+// subscribe sends the daemon the union of the events of Subscribe and the
+// open streams.
+func (c *Client) subscribe(ctx context.Context) error {
+	c.mu.Lock()
+	events := c.events
+	for s := range c.streams {
+		events = union(events, s.filter.SubscribeParams)
+	}
+	c.mu.Unlock()
+	return c.Call(ctx, "crush/subscribe", events, nil)
+}
+
+// resubscribe renews the subscription on a new connection, if there is one.
+func (c *Client) resubscribe() error {
+	c.mu.Lock()
+	none := c.events == (lsp.SubscribeParams{}) && len(c.streams) == 0
+	c.mu.Unlock()
+	if none {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), renewTimeout)
+	defer cancel()
+	return c.subscribe(ctx)
+}
+
+// union returns the events in a or b.
+func union(a, b lsp.SubscribeParams) lsp.SubscribeParams {
+	return lsp.SubscribeParams{
+		DocumentChanges: a.DocumentChanges || b.DocumentChanges,
+		CursorChanges:   a.CursorChanges || b.CursorChanges,
+		FocusChanges:    a.FocusChanges || b.FocusChanges,
+		Diagnostics:     a.Diagnostics || b.Diagnostics,
+	}
+}
+
+// dispatch passes a notification from the daemon to the subscriber and
+// the event streams that want it.
+func (c *Client) dispatch(method string, content []byte) {
+	var msg struct {
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	handler := c.handler
+	streams := make([]*stream, 0, len(c.streams))
+	for s := range c.streams {
+		streams = append(streams, s)
+	}
+	c.mu.Unlock()
+
+	if handler != nil {
+		handler(Notification{Method: method, Params: msg.Params})
+	}
+	if len(streams) == 0 {
+		return
+	}
+	event, ok := decodeEvent(method, msg.Params)
+	if !ok {
+		return
+	}
+	for _, s := range streams {
+		if s.filter.wants(event) {
+			s.push(event)
+		}
+	}
+}
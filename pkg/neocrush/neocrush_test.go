@@ -0,0 +1,289 @@
+package neocrush_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/taigrr/neocrush/lsp"
+	"github.com/taigrr/neocrush/pkg/neocrush"
+	"github.com/taigrr/neocrush/rpc"
+)
+
+// fakeDaemon serves connections on a Unix socket, answering requests with
+// reply and passing notifications to received. It returns the socket path.
+func fakeDaemon(t *testing.T, reply func(conn net.Conn, method string, params json.RawMessage) any, received chan<- string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "daemon.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		scanner.Split(rpc.Split)
+		for scanner.Scan() {
+			method, content, err := rpc.DecodeMessage(scanner.Bytes())
+			if err != nil {
+				return
+			}
+			var msg struct {
+				ID     *int            `json:"id"`
+				Params json.RawMessage `json:"params"`
+			}
+			json.Unmarshal(content, &msg)
+			if msg.ID == nil {
+				received <- method + " " + string(msg.Params)
+				continue
+			}
+
+			response := map[string]any{"jsonrpc": "2.0", "id": *msg.ID}
+			switch result := reply(conn, method, msg.Params).(type) {
+			case *neocrush.ResponseError:
+				response["error"] = result
+			default:
+				response["result"] = result
+			}
+			conn.Write([]byte(rpc.EncodeMessage(response)))
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return path
+}
+
+func TestClient(t *testing.T) {
+	received := make(chan string, 4)
+	var editParams lsp.EditFileParams
+	path := fakeDaemon(t, func(conn net.Conn, method string, params json.RawMessage) any {
+		switch method {
+		case "crush/mcpInitialize":
+			return lsp.MCPInitializeResult{ClientID: "mcp-1"}
+		case "crush/getEditorContext":
+			return map[string]any{"uri": "file:///w/a.go", "cursor_line": 3, "context_line": "x := 1"}
+		case "crush/editFile":
+			json.Unmarshal(params, &editParams)
+			return lsp.EditFileResult{Applied: true}
+		case "crush/subscribe":
+			conn.Write([]byte(rpc.EncodeMessage(map[string]any{
+				"jsonrpc": "2.0",
+				"method":  "crush/focusChanged",
+				"params":  lsp.FocusChangedParams{TextDocument: lsp.TextDocumentIdentifier{URI: "file:///w/b.go"}, Source: "neovim"},
+			})))
+			return lsp.SubscribeResult{Subscribed: true}
+		}
+		return &neocrush.ResponseError{Code: lsp.MethodNotFound, Message: "method not found: " + method}
+	}, received)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := neocrush.Dial(ctx, path, neocrush.Options{Name: "test-agent"})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+	if c.ID() != "mcp-1" {
+		t.Errorf("Expected client ID mcp-1, got %q", c.ID())
+	}
+
+	editor, err := c.GetEditorContext(ctx, false)
+	if err != nil {
+		t.Fatalf("GetEditorContext failed: %v", err)
+	}
+	if editor.URI != "file:///w/a.go" || editor.CursorLine != 3 || editor.ContextLine != "x := 1" {
+		t.Errorf("Unexpected editor context: %+v", editor)
+	}
+
+	edit := lsp.TextEdit{Range: lsp.Range{End: lsp.Position{Character: 1}}, NewText: "y"}
+	result, err := c.ApplyEdit(ctx, "file:///w/a.go", []lsp.TextEdit{edit})
+	if err != nil || !result.Applied {
+		t.Fatalf("ApplyEdit failed: %+v, %v", result, err)
+	}
+	if editParams.TextDocument.URI != "file:///w/a.go" || len(editParams.Edits) != 1 || editParams.Edits[0] != edit {
+		t.Errorf("Unexpected crush/editFile params: %+v", editParams)
+	}
+
+	if err := c.ShowLocations("Callers", []lsp.LocationItem{{Filename: "a.go", Line: 3}}); err != nil {
+		t.Fatalf("ShowLocations failed: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != `crush/showLocations {"title":"Callers","items":[{"filename":"a.go","lnum":3,"text":"","note":""}]}` {
+			t.Errorf("Unexpected notification: %s", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("crush/showLocations not received")
+	}
+
+	events := make(chan neocrush.Notification, 1)
+	if err := c.Subscribe(ctx, lsp.SubscribeParams{FocusChanges: true}, func(n neocrush.Notification) { events <- n }); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	select {
+	case n := <-events:
+		var focus lsp.FocusChangedParams
+		json.Unmarshal(n.Params, &focus)
+		if n.Method != "crush/focusChanged" || focus.TextDocument.URI != "file:///w/b.go" {
+			t.Errorf("Unexpected event: %s %s", n.Method, n.Params)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No event delivered")
+	}
+
+	// Errors keep the daemon's code
+	var respErr *neocrush.ResponseError
+	if err := c.Call(ctx, "crush/unknown", nil, nil); !errors.As(err, &respErr) || respErr.Code != lsp.MethodNotFound {
+		t.Errorf("Expected a method not found error, got %v", err)
+	}
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	var mu sync.Mutex
+	var subscriptions []lsp.SubscribeParams
+	send := func(conn net.Conn, method string, params any) {
+		conn.Write([]byte(rpc.EncodeMessage(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})))
+	}
+	path := fakeDaemon(t, func(conn net.Conn, method string, params json.RawMessage) any {
+		switch method {
+		case "crush/mcpInitialize":
+			return lsp.MCPInitializeResult{ClientID: "mcp-1"}
+		case "crush/subscribe":
+			var sub lsp.SubscribeParams
+			json.Unmarshal(params, &sub)
+			mu.Lock()
+			subscriptions = append(subscriptions, sub)
+			n := len(subscriptions)
+			mu.Unlock()
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				if n > 1 {
+					// Renewed after the reconnect
+					send(conn, "crush/focusChanged", lsp.FocusChangedParams{TextDocument: lsp.TextDocumentIdentifier{URI: "file:///w/c.go"}})
+					return
+				}
+				send(conn, "crush/logMessage", lsp.LogMessageParams{Message: "not an event"})
+				send(conn, "crush/cursorMoved", lsp.CursorMovedParams{Position: lsp.Position{Line: 4}})
+				send(conn, "crush/focusChanged", lsp.FocusChangedParams{TextDocument: lsp.TextDocumentIdentifier{URI: "file:///w/b.go"}})
+				time.Sleep(20 * time.Millisecond)
+				conn.Close()
+			}()
+			return lsp.SubscribeResult{Subscribed: true}
+		}
+		return nil
+	}, make(chan string, 8))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := neocrush.Dial(ctx, path, neocrush.Options{})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+
+	events, err := c.SubscribeEvents(ctx, neocrush.EventFilter{SubscribeParams: lsp.SubscribeParams{FocusChanges: true}})
+	if err != nil {
+		t.Fatalf("SubscribeEvents failed: %v", err)
+	}
+	next := func() neocrush.Event {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-ctx.Done():
+			t.Fatal("No event delivered")
+			return nil
+		}
+	}
+
+	// Only the events the filter picks, decoded
+	if focus, ok := next().(*neocrush.FocusChanged); !ok || focus.TextDocument.URI != "file:///w/b.go" {
+		t.Errorf("Expected focus on b.go, got %#v", focus)
+	}
+
+	// The daemon hangs up: the stream resumes on a new connection
+	if _, ok := next().(*neocrush.Reconnected); !ok {
+		t.Fatal("Expected a reconnect event")
+	}
+	if focus, ok := next().(*neocrush.FocusChanged); !ok || focus.TextDocument.URI != "file:///w/c.go" {
+		t.Errorf("Expected focus on c.go after reconnecting, got %#v", focus)
+	}
+	mu.Lock()
+	if len(subscriptions) != 2 || !subscriptions[1].FocusChanges {
+		t.Errorf("Expected the subscription renewed, got %+v", subscriptions)
+	}
+	mu.Unlock()
+
+	// Ending the stream closes the channel
+	c.Close()
+	for range events {
+	}
+}
+
+func TestSubscribeEventsBackpressure(t *testing.T) {
+	path := fakeDaemon(t, func(conn net.Conn, method string, params json.RawMessage) any {
+		if method == "crush/subscribe" {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				for i := range 10 {
+					conn.Write([]byte(rpc.EncodeMessage(map[string]any{
+						"jsonrpc": "2.0",
+						"method":  "crush/cursorMoved",
+						"params":  lsp.CursorMovedParams{Position: lsp.Position{Line: i}},
+					})))
+				}
+			}()
+		}
+		return map[string]any{}
+	}, make(chan string, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := neocrush.Dial(ctx, path, neocrush.Options{})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+	events, err := c.SubscribeEvents(ctx, neocrush.EventFilter{SubscribeParams: lsp.SubscribeParams{CursorChanges: true}, Buffer: 2})
+	if err != nil {
+		t.Fatalf("SubscribeEvents failed: %v", err)
+	}
+
+	// Fall behind, then catch up: every event is delivered or counted as
+	// dropped, and the newest survive
+	time.Sleep(200 * time.Millisecond)
+	seen, dropped, last := 0, 0, -1
+	for seen+dropped < 10 {
+		select {
+		case event := <-events:
+			switch e := event.(type) {
+			case *neocrush.Dropped:
+				dropped += e.Count
+			case *neocrush.CursorMoved:
+				seen++
+				last = e.Position.Line
+			}
+		case <-ctx.Done():
+			t.Fatalf("Expected 10 events delivered or dropped, got %d and %d", seen, dropped)
+		}
+	}
+	if dropped == 0 || last != 9 {
+		t.Errorf("Expected the oldest events dropped and the newest kept, got %d dropped, last line %d", dropped, last)
+	}
+}
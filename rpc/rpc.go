@@ -1,11 +1,14 @@
 package rpc
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"strconv"
+	"strings"
 )
 
 // EncodeMessage serializes a message to LSP wire format with Content-Length header.
@@ -47,6 +50,40 @@ func DecodeMessage(msg []byte) (string, []byte, error) {
 	return baseMessage.Method, content[:contentLength], nil
 }
 
+// ReadMessage reads one LSP message (headers and content) from r, leaving
+// anything after it unread.
+func ReadMessage(r *bufio.Reader) ([]byte, error) {
+	var frame []byte
+	contentLength := -1
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		frame = append(frame, line...)
+
+		header := strings.TrimRight(string(line), "\r\n")
+		if header == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(header, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if contentLength, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %w", err)
+			}
+		}
+	}
+	if contentLength < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+
+	start := len(frame)
+	frame = append(frame, make([]byte, contentLength)...)
+	if _, err := io.ReadFull(r, frame[start:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
 // Split is a bufio.SplitFunc that splits LSP messages by Content-Length.
 // It returns complete messages only, buffering partial data until complete.
 func Split(data []byte, _ bool) (advance int, token []byte, err error) {
@@ -1,6 +1,9 @@
 package rpc_test
 
 import (
+	"bufio"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/taigrr/neocrush/rpc"
@@ -34,3 +37,22 @@ func TestDecode(t *testing.T) {
 		t.Fatalf("Expected: 'hi', Got: %s", method)
 	}
 }
+
+func TestReadMessage(t *testing.T) {
+	first := "Content-Length: 15\r\n\r\n{\"Method\":\"hi\"}"
+	second := "Content-Length: 2\r\n\r\n{}"
+	r := bufio.NewReader(strings.NewReader(first + second))
+
+	frame, err := rpc.ReadMessage(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame) != first {
+		t.Fatalf("Expected: %q, Got: %q", first, frame)
+	}
+
+	rest, _ := io.ReadAll(r)
+	if string(rest) != second {
+		t.Fatalf("Expected the next message to be left unread, got %q", rest)
+	}
+}